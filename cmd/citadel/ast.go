@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// cmdAST implements citadel ast, which prints the syntax tree of one C
+// file.
+func cmdAST() (*flag.FlagSet, func(args []string)) {
+	fs := newFlagSet("ast", "<input.c>")
+	asJSON := fs.Bool("json", false, "print the tree as JSON")
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		program := parseFile(inputArg(fs)).Program
+		print := parser.Fprint
+		if *asJSON {
+			print = parser.FprintJSON
+		}
+		if err := print(os.Stdout, program); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing syntax tree: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
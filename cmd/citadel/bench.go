@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/anouar-bakouch/citadel/pkg/sema"
+)
+
+// benchPhase is a phase of compiling a file that citadel bench times on
+// its own.
+type benchPhase struct {
+	name string
+	run  func() error
+}
+
+// benchResult is the timing of a phase, as citadel bench -json writes it.
+type benchResult struct {
+	Phase        string  `json:"phase"`
+	Runs         int     `json:"runs"`
+	NsPerRun     int64   `json:"ns_per_run"`
+	BytesPerRun  uint64  `json:"bytes_per_run"`
+	TokensPerSec float64 `json:"tokens_per_sec"`
+	NodesPerSec  float64 `json:"nodes_per_sec"`
+	LinesPerSec  float64 `json:"lines_per_sec"`
+}
+
+// benchReport is the output of citadel bench -json.
+type benchReport struct {
+	File      string        `json:"file"`
+	Version   string        `json:"version"`
+	Go        string        `json:"go"`
+	Lines     int           `json:"lines"`
+	Tokens    int           `json:"tokens"`
+	Nodes     int           `json:"nodes"`
+	Phases    []benchResult `json:"phases"`
+	PeakBytes uint64        `json:"peak_bytes"`
+}
+
+// cmdBench implements citadel bench, which times lexing, parsing, the
+// semantic checks, the security analysis and code generation of a C file
+// separately, each over and over for at least -time, and reports their
+// throughput and the memory they use, so that releases can be compared.
+func cmdBench() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("bench", "<input.c>")
+	benchtime := fs.Duration("time", time.Second, "how long to repeat each phase for")
+	o1 := fs.Bool("O1", false, "generate code with -O1")
+	jsonOut := fs.Bool("json", false, "write the results as JSON, to keep and compare")
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		path := inputArg(fs)
+		if *o1 {
+			opts.OptLevel = 1
+		}
+
+		file := parseFile(path)
+		input, program := file.Source, file.Program
+		opts.SourceFile, opts.Source = sourceName(path), input
+		if err := sema.Check(program, opts); err != nil {
+			err = codegenError(path, err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+		tokens := 0
+		for l := lexer.New(input); l.NextToken().Type != lexer.EOF; {
+			tokens++
+		}
+		lines := strings.Count(input, "\n")
+		if !strings.HasSuffix(input, "\n") {
+			lines++
+		}
+		report := benchReport{
+			File:    sourceName(path),
+			Version: codegen.Version,
+			Go:      runtime.Version(),
+			Lines:   lines,
+			Tokens:  tokens,
+			Nodes:   parser.CountNodes(program),
+		}
+
+		// Each phase starts from the output of the one before it, which is
+		// made once, so its time is its own. Parsing has to lex, though, as
+		// the parser pulls its tokens from the lexer, and codegen makes its
+		// semantic checks as it lowers the program, so sema is code
+		// generation with the IR thrown away.
+		phases := []benchPhase{
+			{"lex", func() error {
+				l := lexer.New(input)
+				for l.NextToken().Type != lexer.EOF {
+				}
+				return nil
+			}},
+			{"parse", func() error {
+				_, err := parser.New(lexer.New(input)).ParseProgram()
+				return err
+			}},
+			{"sema", func() error {
+				return sema.Check(program, opts)
+			}},
+			{"analysis", func() error {
+				_, err := analysis.Analyze(program, analysis.DefaultConfig())
+				return err
+			}},
+			{"codegen", func() error {
+				_, err := codegen.NewWithOptions(opts).Generate(program)
+				return err
+			}},
+		}
+		for _, phase := range phases {
+			result, err := benchmark(phase, *benchtime)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error in %s: %v\n", phase.name, err)
+				os.Exit(exitCodegen)
+			}
+			perSec := func(n int) float64 {
+				return float64(n) * 1e9 / float64(result.NsPerRun)
+			}
+			result.TokensPerSec = perSec(report.Tokens)
+			result.NodesPerSec = perSec(report.Nodes)
+			result.LinesPerSec = perSec(report.Lines)
+			report.Phases = append(report.Phases, result)
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		// The runtime keeps the memory it obtains, so Sys is the most the
+		// process held at once
+		report.PeakBytes = mem.Sys
+
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing results: %v\n", err)
+				os.Exit(exitUsage)
+			}
+			return
+		}
+		fmt.Printf("%s: %s, %s, %s (citadel %s, %s)\n", report.File, plural(report.Lines, "line"),
+			plural(report.Tokens, "token"), plural(report.Nodes, "node"), report.Version, report.Go)
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "phase\truns\ttime/run\talloc/run\ttokens/s\tnodes/s\tlines/s\t")
+		for _, r := range report.Phases {
+			fmt.Fprintf(w, "%s\t%d\t%v\t%s\t%.0f\t%.0f\t%.0f\t\n", r.Phase, r.Runs,
+				time.Duration(r.NsPerRun), formatBytes(r.BytesPerRun), r.TokensPerSec, r.NodesPerSec, r.LinesPerSec)
+		}
+		w.Flush()
+		fmt.Printf("peak memory: %s\n", formatBytes(report.PeakBytes))
+	}
+}
+
+// benchmark runs phase over and over for at least d, and at least once,
+// and returns the time and memory it took per run.
+func benchmark(phase benchPhase, d time.Duration) (benchResult, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	runs := 0
+	start := time.Now()
+	for runs == 0 || time.Since(start) < d {
+		if err := phase.run(); err != nil {
+			return benchResult{}, err
+		}
+		runs++
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	ns := elapsed.Nanoseconds() / int64(runs)
+	if ns == 0 {
+		// Keeps the rates finite, as JSON has no infinity
+		ns = 1
+	}
+	return benchResult{
+		Phase:       phase.name,
+		Runs:        runs,
+		NsPerRun:    ns,
+		BytesPerRun: (after.TotalAlloc - before.TotalAlloc) / uint64(runs),
+	}, nil
+}
+
+// formatBytes returns n as a number of bytes, KiB or MiB.
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
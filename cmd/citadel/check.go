@@ -0,0 +1,707 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/anouar-bakouch/citadel/pkg/report"
+	"github.com/anouar-bakouch/citadel/pkg/sema"
+	"github.com/anouar-bakouch/citadel/pkg/symexec"
+)
+
+// checkedFile is one input of citadel check and what checking it found.
+type checkedFile struct {
+	path       string
+	name       string // as messages give it
+	config     *analysis.Config
+	excludedBy string   // the policy file excluding it, or ""
+	target     string   // the target its compile command builds for, or ""
+	clangArgs  []string // the -I and -D flags of its compile command
+
+	input    string
+	lex      *lexer.Lexer
+	program  *ast.Program
+	findings []analysis.Finding
+	warnings []string
+	err      error // a stageError
+	elapsed  time.Duration
+}
+
+// cmdCheck implements citadel check, which reports the security
+// weaknesses the analysis passes find in C files. It also reports the
+// errors compile would, but generates no code, so it suits pre-commit
+// hooks. A directory stands for
+// the .c files in it, or anywhere below it when followed by /..., and the
+// files are checked in parallel.
+func cmdCheck() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("check", "<input.c|dir|dir/...|pattern>...")
+	sarif := fs.String("sarif", "", "also write the findings to this file as a SARIF 2.1.0 log")
+	jsonReport := fs.String("json", "", "also write the findings to this file as JSON, an array of one object per file when checking several")
+	htmlReport := fs.String("html", "", "also write the findings to this file as an HTML report")
+	markdownReport := fs.String("markdown", "", "also write a Markdown summary of the findings to this file")
+	failOn := fs.String("fail-on", "", "exit with status 5 if a finding of this severity or higher is reported (info, low, medium, high, critical)")
+	severities := fs.String("severity", "", "comma-separated rule=severity overrides, e.g. recursion=high")
+	disable := fs.String("disable", "", "comma-separated rules not to report")
+	enable := fs.String("enable", "", "comma-separated rules to report even if the policy file disables them")
+	score := fs.Bool("score", false, "print a security score for each function and the module after the findings")
+	sanitize := instrumentationFlags(fs, &opts)
+	baselinePath := fs.String("baseline", "", "report only findings not in this baseline file, creating it from the current findings if it does not exist")
+	updateBaseline := fs.Bool("update-baseline", false, "rewrite the -baseline file from the current findings")
+	showSuppressed := fs.Bool("show-suppressed", false, "also print the findings citadel:ignore comments suppress")
+	plugins := fs.String("plugin", "", "comma-separated Go plugins that register more analysis passes")
+	listRules := fs.Bool("list-rules", false, "list the rules check reports and exit")
+	policyFile := fs.String("config", "", "security policy file (default: the nearest .citadel.json, .citadel.yaml or .citadel.yml above each input)")
+	taintConfig := fs.String("taint-config", "", "JSON file of taint sources, sanitizers and sinks (default: built-in)")
+	dbPath := fs.String("compilation-db", "", "Clang compilation database (compile_commands.json) giving the build flags of each file; with no inputs, check the C files it lists")
+	cacheDir := fs.String("cache", "", "directory caching findings by file content and configuration, and function summaries by file content, so unchanged files are not analyzed again (conventionally .citadel-cache)")
+	symbolicPaths := fs.Int("symbolic-paths", symexec.DefaultOptions.MaxPaths, "paths per function symbolic execution explores to confirm bounds and division findings (0 to turn it off)")
+	format := fs.String("diagnostics-format", "text", "format of errors and findings: text, or json for one JSON object listing them as diagnostics")
+	fs.StringVar(format, "format", "text", "same as -diagnostics-format, its old name")
+	color := colorFlag(fs)
+	maxErrors := maxErrorsFlag(fs)
+	newLogger := verbosityFlags(fs)
+	applyFrontend := frontendFlags(fs)
+	applyMetrics := metricsFlag(fs)
+	applyProject := projectFlags(fs)
+	workers := fs.Int("j", runtime.NumCPU(), "number of files to check in parallel")
+	timeout := fs.Duration("timeout", 0, "fail the files not checked within this time, e.g. 5m for a CI job (0 for no limit)")
+	timeReportFlag := fs.Bool("time-report", false, "print how long each step took over all the files and what it allocated: lex, parse, sema, each analysis pass and output")
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		applyProject()
+
+		// Plugins add rules, so they are loaded before anything names one
+		if *plugins != "" {
+			for _, path := range strings.Split(*plugins, ",") {
+				if err := analysis.LoadPlugin(path); err != nil {
+					fmt.Fprintf(os.Stderr, "Error loading plugin: %v\n", err)
+					os.Exit(1)
+				}
+			}
+		}
+
+		if *listRules {
+			for _, rule := range analysis.Rules() {
+				fmt.Printf("%-20s CWE-%-4d %s\n", rule.ID, rule.CWE, rule.Description)
+			}
+			return
+		}
+
+		var db *compilationDB
+		if *dbPath != "" {
+			var err error
+			if db, err = loadCompilationDB(*dbPath); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading compilation database: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		inputs := fs.Args()
+		// The files of the compilation database that are gone, as it can be
+		// older than the tree; each fails on its own instead of ending the run
+		var stale []string
+		if len(inputs) == 0 && db != nil {
+			for _, file := range db.files {
+				if !strings.HasSuffix(file, ".c") {
+					continue
+				}
+				if _, err := os.Stat(file); err != nil {
+					stale = append(stale, relativePath(file))
+					continue
+				}
+				inputs = append(inputs, relativePath(file))
+			}
+			if len(inputs) == 0 && len(stale) == 0 {
+				fmt.Fprintf(os.Stderr, "No C files in %s\n", *dbPath)
+				os.Exit(1)
+			}
+		}
+		if len(inputs) == 0 && len(stale) == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		var paths []string
+		var err error
+		if len(inputs) > 0 {
+			if paths, err = expandInputs(inputs); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		paths = append(paths, stale...)
+		sort.Strings(paths)
+		isStale := map[string]bool{}
+		for _, path := range stale {
+			isStale[path] = true
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "No C files match %s\n", strings.Join(fs.Args(), " "))
+			os.Exit(1)
+		}
+		if *format != "text" && *format != "json" {
+			fmt.Fprintf(os.Stderr, "Unknown output format: %s\n", *format)
+			os.Exit(1)
+		}
+		if *workers < 1 {
+			fmt.Fprintf(os.Stderr, "Invalid -j: %d\n", *workers)
+			os.Exit(1)
+		}
+		setSanitizers(*sanitize, &opts)
+
+		// The -fail-on threshold, or nil; a policy file can also set one
+		var threshold *analysis.Severity
+		if *failOn != "" {
+			severity, err := analysis.ParseSeverity(*failOn)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -fail-on: %v\n", err)
+				os.Exit(1)
+			}
+			threshold = &severity
+		}
+
+		if *updateBaseline && *baselinePath == "" {
+			fmt.Fprintf(os.Stderr, "-update-baseline requires -baseline\n")
+			os.Exit(1)
+		}
+
+		var taint *analysis.TaintConfig
+		if *taintConfig != "" {
+			if taint, err = analysis.LoadTaintConfig(*taintConfig); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading taint configuration: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		overrides := map[string]analysis.Severity{}
+		if *severities != "" {
+			for _, override := range strings.Split(*severities, ",") {
+				parts := strings.SplitN(override, "=", 2)
+				if len(parts) != 2 || analysis.LookupRule(parts[0]) == nil {
+					fmt.Fprintf(os.Stderr, "Invalid severity override: %s\n", override)
+					os.Exit(1)
+				}
+				severity, err := analysis.ParseSeverity(parts[1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid severity override: %v\n", err)
+					os.Exit(1)
+				}
+				overrides[parts[0]] = severity
+			}
+		}
+		toggled := map[string]bool{}
+		for _, list := range []struct {
+			rules    string
+			disabled bool
+		}{{*enable, false}, {*disable, true}} {
+			if list.rules == "" {
+				continue
+			}
+			for _, id := range strings.Split(list.rules, ",") {
+				if analysis.LookupRule(id) == nil {
+					fmt.Fprintf(os.Stderr, "Unknown rule: %s\n", id)
+					os.Exit(1)
+				}
+				toggled[id] = list.disabled
+			}
+		}
+
+		// Each file is checked with the settings of its policy file, which is
+		// read once however many files it applies to, and flags take
+		// precedence over them
+		log := newLogger()
+		applyFrontend(log)
+		applyMetrics(log)
+		var times *timeReport
+		if *timeReportFlag {
+			times = newTimeReport()
+		}
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		opts.Context = ctx
+		policies := map[string]*analysis.Policy{}
+		files := make([]*checkedFile, len(paths))
+		for i, path := range paths {
+			file := &checkedFile{path: path, name: sourceName(path), config: analysis.DefaultConfig()}
+			files[i] = file
+			if isStale[path] {
+				file.err = stageErrorf("io", file.name, "Error reading input file: %s is in %s but does not exist", file.name, *dbPath)
+			}
+			if db != nil {
+				file.target, file.clangArgs = compileFlags(db, path, log)
+			}
+			policyPath := *policyFile
+			if policyPath == "" {
+				policyPath = analysis.FindPolicy(filepath.Dir(path))
+			}
+			if policyPath != "" {
+				policy, ok := policies[policyPath]
+				if !ok {
+					policy, _ = loadPolicy(policyPath, path)
+					policies[policyPath] = policy
+					log.Debug("using policy", "path", policyPath)
+				}
+				file.config = policy.Config()
+				if policy.Excludes(path) {
+					file.excludedBy = policyPath
+				}
+				if threshold == nil {
+					threshold = policy.FailOn
+				}
+			}
+			config := file.config
+			config.Logger = log.With("file", file.name)
+			config.Measure = serviceMetrics.measureAnalysis(times.measureAnalysis())
+			config.Context = ctx
+			if taint != nil {
+				config.Taint = taint
+			}
+			if *symbolicPaths <= 0 {
+				config.Symbolic = nil
+			} else if config.Symbolic != nil {
+				config.Symbolic.MaxPaths = *symbolicPaths
+			}
+			if config.Severities == nil {
+				config.Severities = map[string]analysis.Severity{}
+			}
+			for id, severity := range overrides {
+				config.Severities[id] = severity
+			}
+			if config.Disabled == nil {
+				config.Disabled = map[string]bool{}
+			}
+			for id, disabled := range toggled {
+				config.Disabled[id] = disabled
+			}
+		}
+
+		start := time.Now()
+		jobs := make(chan *checkedFile)
+		var wg sync.WaitGroup
+		for i := 0; i < *workers && i < len(files); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for file := range jobs {
+					if file.err == nil {
+						checkFile(file, opts, *cacheDir, *maxErrors, times)
+					}
+					serviceMetrics.file("check", file.err, file.findings)
+				}
+			}()
+		}
+		for _, file := range files {
+			jobs <- file
+		}
+		close(jobs)
+		wg.Wait()
+		elapsed := time.Since(start)
+
+		// Under -diagnostics-format=json the standard output is the diagnostics alone, so
+		// everything else check prints goes to the standard error
+		info := io.Writer(os.Stdout)
+		jsonOutput := *format == "json"
+		if jsonOutput {
+			info = os.Stderr
+		}
+
+		// The errors and warnings of every file are collected in bag, in the
+		// order of the files, to be written as text here or as JSON with the
+		// findings, and given to the SARIF log. Files that could not be
+		// checked give the exit status of the first of them once the reports
+		// are written
+		sources := map[string]string{}
+		for _, file := range files {
+			sources[file.name] = file.input
+		}
+		renderer := newErrorRenderer(useColor(*color), sources)
+		renderer.limit = *maxErrors
+		status := exitOK
+		bag := &diag.Bag{}
+		var checked []*checkedFile
+		for _, file := range files {
+			if jsonOutput && file.input != "" && clangFrontend == nil {
+				bag.AddLexerErrors(file.name, file.input)
+			}
+			if file.err != nil {
+				addError(bag, file.err)
+				if status == exitOK {
+					status = exitCode(file.err)
+				}
+				continue
+			}
+			if file.excludedBy != "" {
+				log.Info(fmt.Sprintf("Not analyzed: %s is excluded by %s", file.name, file.excludedBy))
+			}
+			for _, warning := range file.warnings {
+				bag.Add(diag.Diagnostic{File: file.name, Severity: diag.Warning, Stage: "suppression", Message: warning})
+			}
+			checked = append(checked, file)
+		}
+		if !jsonOutput {
+			for _, d := range bag.Diagnostics() {
+				if d.Severity == diag.Warning {
+					log.Warn(fmt.Sprintf("%s:%s", d.File, d.Message))
+				} else {
+					renderer.writeDiagnostic(os.Stderr, d)
+				}
+			}
+		}
+		renderer.finish(os.Stderr)
+
+		if *baselinePath != "" {
+			baseline, err := analysis.LoadBaseline(*baselinePath)
+			if err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Error reading baseline: %v\n", err)
+				os.Exit(1)
+			}
+			var fixed []analysis.BaselineEntry
+			if baseline != nil {
+				for _, file := range checked {
+					fixed = append(fixed, baseline.Fixed(file.name, file.input, file.findings)...)
+				}
+			}
+			if err != nil || *updateBaseline {
+				if baseline == nil {
+					baseline = &analysis.Baseline{}
+				}
+				for _, file := range checked {
+					baseline.Record(file.name, file.input, file.findings)
+				}
+				if err := baseline.Save(*baselinePath); err != nil {
+					fmt.Fprintf(os.Stderr, "Error writing baseline: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Fprintf(info, "Baseline written to %s\n", *baselinePath)
+			}
+			total := 0
+			for _, file := range checked {
+				var known int
+				file.findings, known = baseline.Filter(file.name, file.input, file.findings)
+				total += known
+			}
+			fmt.Fprintf(info, "Findings in baseline: %d\n", total)
+			fmt.Fprintf(info, "Fixed since baseline: %d\n", len(fixed))
+			for _, entry := range fixed {
+				fmt.Fprintf(info, "  %s:%d: %s [%s in %s]\n", entry.File, entry.Line, entry.Message, entry.Rule, entry.Function)
+			}
+		}
+
+		// Whether a finding reached the -fail-on threshold, reported once the
+		// reports are written
+		failed := false
+		var reported []report.File
+		var byFile []analysis.FileFindings
+		for _, file := range checked {
+			for _, finding := range file.findings {
+				failed = failed || finding.Suppressed == nil && threshold != nil && finding.Severity >= *threshold
+			}
+			reported = append(reported, report.File{Path: file.name, Source: file.input, Findings: file.findings})
+			byFile = append(byFile, analysis.FileFindings{File: file.name, Findings: file.findings})
+		}
+		times.measure("output", func() {
+			if jsonOutput {
+				diags := bag.Diagnostics()
+				for _, file := range checked {
+					diags = append(diags, findingDiagnostics(file.name, file.findings, *showSuppressed)...)
+				}
+				err = writeDiagnostics(os.Stdout, diags)
+			} else if len(checked) > 0 {
+				err = writeFindings(os.Stdout, reported, *showSuppressed)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing findings: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) > 1 {
+			logTimings(log, files, elapsed, *workers)
+		}
+		if *sarif != "" {
+			if err := writeFile(*sarif, func(w io.Writer) error { return analysis.WriteSARIFDiagnostics(w, byFile, bag.Diagnostics()) }); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing SARIF log: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *jsonReport != "" {
+			// One file keeps the object it always had
+			write := func(w io.Writer) error { return analysis.WriteJSONFiles(w, byFile) }
+			if len(files) == 1 && len(byFile) == 1 {
+				write = func(w io.Writer) error { return analysis.WriteJSON(w, byFile[0].File, byFile[0].Findings) }
+			}
+			if err := writeFile(*jsonReport, write); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing JSON report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *htmlReport != "" {
+			if err := writeFile(*htmlReport, func(w io.Writer) error { return report.HTML(w, reported) }); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing HTML report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *markdownReport != "" {
+			if err := writeFile(*markdownReport, func(w io.Writer) error { return report.Markdown(w, reported) }); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing Markdown report: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *score {
+			// Instrumentation the build enables counts towards the score
+			hardening := []report.Hardening{
+				{Name: "bounds-checks", Enabled: opts.BoundsChecks},
+				{Name: "overflow-checks", Enabled: opts.OverflowChecks},
+				{Name: "div-checks", Enabled: opts.DivisionChecks},
+				{Name: "cfi", Enabled: opts.CFI},
+				{Name: "safestack or shadow-call-stack", Enabled: opts.SafeStack || opts.ShadowCallStack},
+				{Name: "address sanitizer", Enabled: opts.SanitizeAddress},
+			}
+			var metrics []analysis.Metrics
+			var findings []analysis.Finding
+			for _, file := range checked {
+				metrics = append(metrics, analysis.Measure(file.program)...)
+				findings = append(findings, file.findings...)
+			}
+			fmt.Fprintf(info, "Security score:\n")
+			report.WriteScore(info, report.Scores(metrics, findings, hardening))
+		}
+
+		if times != nil {
+			notes := []string{lexNote}
+			if *workers > 1 && len(files) > 1 {
+				notes = append(notes, "files were checked in parallel, so the steps add up to more than the total and their allocations overlap")
+			}
+			times.write(info, notes...)
+		}
+
+		errorCount, warnings := renderer.errors, 0
+		if jsonOutput {
+			errorCount = bag.Count(diag.Error)
+		}
+		var findings []analysis.Finding
+		for _, file := range files {
+			warnings += len(file.warnings)
+			findings = append(findings, file.findings...)
+		}
+		writeSummary(info, errorCount, warnings, findings)
+
+		if status != exitOK {
+			os.Exit(status)
+		}
+		if failed {
+			fmt.Fprintf(os.Stderr, "Findings of severity %s or higher were reported\n", *threshold)
+			os.Exit(exitFindings)
+		}
+	}
+}
+
+// checkFile reads, parses, checks and analyzes one input of citadel
+// check, with the code generation options opts and up to maxErrors parse
+// errors, recording the outcome in file and measuring the steps in times,
+// if it is not nil. Different files can be checked at once. Once
+// opts.Context is done, the steps stop and the file fails.
+func checkFile(file *checkedFile, opts codegen.Options, cacheDir string, maxErrors int, times *timeReport) {
+	start := time.Now()
+	defer func() {
+		file.elapsed = time.Since(start)
+		file.config.Logger.Debug("checked", "findings", len(file.findings), "elapsed", file.elapsed)
+	}()
+	timedOut := func() bool {
+		if err := codegen.Canceled(opts); err != nil {
+			file.err = stageErrorf("io", file.name, "Timed out checking %s: %w", file.name, err)
+			return true
+		}
+		return false
+	}
+	if timedOut() {
+		return
+	}
+
+	var err error
+	times.measure("read", func() { file.input, err = readSource(file.path) })
+	if err != nil {
+		file.err = stageErrorf("io", file.name, "Error reading input file: %w", err)
+		return
+	}
+	times.lexStep(file.input)
+	times.measure("parse", func() {
+		if clangFrontend != nil {
+			file.lex, file.program, err = clangFrontend.parse(opts.Context, file.name, file.input, file.clangArgs...)
+			return
+		}
+		file.lex, file.program, err = parse(file.name, file.input, parser.WithContext(opts.Context), parser.WithMaxErrors(maxErrors))
+	})
+	if timedOut() {
+		return
+	}
+	if err != nil {
+		file.err = stageErrorf("parser", file.name, "Parse error: %w", err)
+		return
+	}
+	opts.SourceFile, opts.Source = file.name, file.input
+	if file.target != "" {
+		opts.Target = file.target
+	}
+	times.measure("sema", func() { err = sema.Check(file.program, opts) })
+	if timedOut() {
+		return
+	}
+	if err != nil {
+		file.err = codegenError(file.name, err)
+		return
+	}
+	file.findings = []analysis.Finding{}
+	if file.excludedBy == "" {
+		file.findings, err = analyzeCached(cacheDir, file.program, file.input, file.config)
+		if timedOut() {
+			return
+		}
+		if err != nil {
+			file.err = stageErrorf("analysis", file.name, "Analysis error: %s: %w", file.name, err)
+			return
+		}
+	}
+	suppressions, errs := analysis.ParseSuppressions(file.lex.Comments())
+	for _, err := range errs {
+		file.warnings = append(file.warnings, err.Error())
+	}
+	analysis.Suppress(file.findings, suppressions)
+	analysis.SetFingerprints(file.name, file.input, file.findings)
+}
+
+// logTimings logs how long checking files took and which files took
+// longest.
+func logTimings(log *slog.Logger, files []*checkedFile, elapsed time.Duration, workers int) {
+	if workers > len(files) {
+		workers = len(files)
+	}
+	slowest := append([]*checkedFile(nil), files...)
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].elapsed > slowest[j].elapsed })
+	if len(slowest) > 3 {
+		slowest = slowest[:3]
+	}
+	var times []string
+	for _, file := range slowest {
+		times = append(times, fmt.Sprintf("%s %s", file.name, file.elapsed.Round(time.Microsecond)))
+	}
+	log.Info(fmt.Sprintf("Checked %d files in %s with %s; slowest: %s", len(files), elapsed.Round(time.Microsecond), plural(workers, "worker"), strings.Join(times, ", ")))
+}
+
+// analyzeCached analyzes program, whose source is input, using the
+// findings cached in dir when there are any and caching them otherwise.
+// When it has to analyze, it starts from the function summaries cached
+// for the same input under any configuration, and caches them if there
+// were none. An empty dir disables the cache.
+func analyzeCached(dir string, program *ast.Program, input string, config *analysis.Config) ([]analysis.Finding, error) {
+	if dir == "" {
+		return analysis.Analyze(program, config)
+	}
+	cache, err := analysis.OpenCache(dir)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cache.Key(input, config)
+	if err != nil {
+		return nil, err
+	}
+	log := logging.Or(config.Logger)
+	findings, ok := cache.Load(key)
+	serviceMetrics.cacheLookup(ok)
+	if ok {
+		log.Debug("using cached findings", "key", key)
+		return findings, nil
+	}
+	sourceKey := cache.SourceKey(input)
+	facts, cached := cache.LoadSummaries(sourceKey)
+	if !cached {
+		facts = analysis.NewFacts("", 0)
+	}
+	log.Debug("no cached findings", "key", key, "cached summaries", len(facts.Names()))
+	withFacts := *config
+	withFacts.Facts = facts
+	findings, err = analysis.Analyze(program, &withFacts)
+	if err != nil {
+		return nil, err
+	}
+	if !cached {
+		if err := cache.StoreSummaries(sourceKey, facts); err != nil {
+			return nil, err
+		}
+	}
+	return findings, cache.Store(key, findings)
+}
+
+// loadPolicy loads the policy file at path, or else the one nearest the
+// input file, exiting if it cannot be read. It returns nil and "" if there
+// is none.
+func loadPolicy(path, inputFile string) (*analysis.Policy, string) {
+	if path == "" {
+		path = analysis.FindPolicy(filepath.Dir(inputFile))
+	}
+	if path == "" {
+		return nil, ""
+	}
+	policy, err := analysis.LoadPolicy(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading policy: %v\n", err)
+		os.Exit(1)
+	}
+	return policy, path
+}
+
+// writeFindings writes a count of the findings in files and then the
+// findings, with their traces and suggestions, leaving out the suppressed
+// ones unless showSuppressed is set.
+func writeFindings(w io.Writer, files []report.File, showSuppressed bool) error {
+	var b strings.Builder
+	total, suppressed := 0, 0
+	for _, file := range files {
+		total += len(file.Findings)
+		for _, finding := range file.Findings {
+			if finding.Suppressed != nil {
+				suppressed++
+			}
+		}
+	}
+	fmt.Fprintf(&b, "Findings: %d (%d suppressed)\n", total-suppressed, suppressed)
+	for _, file := range files {
+		for _, finding := range file.Findings {
+			if finding.Suppressed != nil && !showSuppressed {
+				continue
+			}
+			fmt.Fprintf(&b, "  %s:%s\n", file.Path, finding)
+			if s := finding.Suppressed; s != nil && s.Reason != "" {
+				fmt.Fprintf(&b, "    suppressed at %s:%s: %s\n", file.Path, s.Pos, s.Reason)
+			} else if s != nil {
+				fmt.Fprintf(&b, "    suppressed at %s:%s\n", file.Path, s.Pos)
+			}
+			for _, step := range finding.Trace {
+				fmt.Fprintf(&b, "    %s:%s: %s\n", file.Path, step.Pos, step.Message)
+			}
+			if finding.Suggestion != "" {
+				fmt.Fprintf(&b, "    suggestion: %s\n", finding.Suggestion)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/clangast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// clangFrontend, once -frontend=clang selects it, is how parse reads the
+// inputs of compile and check: clang parses them, and clangast imports
+// the syntax tree it dumps. It is nil with Citadel's own parser.
+var clangFrontend *clangImporter
+
+// clangImporter runs clang on inputs and imports what it dumps, leaving
+// out the functions that use what the AST does not have with a warning
+// for each.
+type clangImporter struct {
+	args []string // passed to clang before the input
+	log  *slog.Logger
+}
+
+// frontendFlags registers -frontend and -clang-flags on fs, and returns a
+// function to call once the flags and the logger are ready, which sets
+// clangFrontend if -frontend=clang. It exits if -frontend names no
+// frontend.
+func frontendFlags(fs *flag.FlagSet) func(log *slog.Logger) {
+	frontend := fs.String("frontend", "citadel", "C frontend: citadel, or clang to parse with clang and import its syntax tree (a .json input is taken as the tree clang -Xclang -ast-dump=json dumped)")
+	flags := fs.String("clang-flags", "", "space-separated flags for clang with -frontend=clang, such as -I and -D options")
+	return func(log *slog.Logger) {
+		switch *frontend {
+		case "citadel":
+		case "clang":
+			clangFrontend = &clangImporter{args: strings.Fields(*flags), log: log}
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown frontend %q (want citadel or clang)\n", *frontend)
+			os.Exit(exitUsage)
+		}
+	}
+}
+
+// parse imports the program of the named file, whose source is input,
+// as parse does with Citadel's parser, passing clang the flags of
+// -clang-flags and then extra. The lexer has read input for its
+// comments, for citadel:ignore to work as it does with Citadel's parser;
+// a .json input is a dump already, with no comments.
+func (c *clangImporter) parse(ctx context.Context, name, input string, extra ...string) (*lexer.Lexer, *ast.Program, error) {
+	dump := []byte(input)
+	lex := lexer.New("")
+	if !strings.HasSuffix(name, ".json") {
+		if name == sourceName("-") {
+			return nil, nil, errors.New("the clang frontend needs a file, not the standard input")
+		}
+		var err error
+		if dump, err = clangast.Dump(ctx, name, append(c.args[:len(c.args):len(c.args)], extra...)...); err != nil {
+			return nil, nil, err
+		}
+		lex = lexer.New(input)
+		for tok := lex.NextToken(); tok.Type != lexer.EOF; tok = lex.NextToken() {
+		}
+	}
+	program, err := clangast.Import(strings.NewReader(string(dump)), clangast.Options{Partial: true})
+	var left parser.ErrorList
+	if errors.As(err, &left) && program != nil {
+		for _, e := range left {
+			c.log.Warn("left out a function clang parsed", "file", name, "err", e)
+		}
+		err = nil
+	}
+	return lex, program, err
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// compileCommand is an entry of a Clang compilation database,
+// compile_commands.json, which gives the command line either as one
+// string or as a list of arguments.
+type compileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments"`
+}
+
+// buildFlags are the flags a compilation database gives for a file that
+// bear on checking it.
+type buildFlags struct {
+	includes []string // -I, -isystem and -iquote directories, made absolute
+	defines  []string // -D and -U, as NAME, NAME=VALUE or -NAME for -U
+	target   string   // -target or --target, or ""
+}
+
+// compilationDB is what a compilation database says about the files it
+// builds: their flags, by absolute path, and the files in the order it
+// lists them.
+type compilationDB struct {
+	flags map[string]buildFlags
+	files []string
+}
+
+// loadCompilationDB reads the compilation database at path.
+func loadCompilationDB(path string) (*compilationDB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var commands []compileCommand
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	db := &compilationDB{flags: map[string]buildFlags{}}
+	for i, cmd := range commands {
+		if cmd.File == "" {
+			return nil, fmt.Errorf("%s: entry %d has no file", path, i+1)
+		}
+		file := absPath(cmd.Directory, cmd.File)
+		if _, ok := db.flags[file]; ok {
+			// A file compiled more than once keeps the flags of its
+			// first command, as clangd does
+			continue
+		}
+		args := cmd.Arguments
+		if args == nil {
+			if args, err = splitCommand(cmd.Command); err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %v", path, i+1, err)
+			}
+		}
+		db.flags[file] = parseBuildFlags(args, cmd.Directory)
+		db.files = append(db.files, file)
+	}
+	return db, nil
+}
+
+// lookup returns the flags for the file at path and whether the database
+// builds it.
+func (db *compilationDB) lookup(path string) (buildFlags, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return buildFlags{}, false
+	}
+	flags, ok := db.flags[abs]
+	return flags, ok
+}
+
+// compileFlags returns the target the compile command of the file at
+// path builds for, or "" for the default when it names none or one
+// citadel does not support, and its include paths and defines as flags
+// for clang. Citadel's own parser has no preprocessor, so they bear only
+// on -frontend clang.
+func compileFlags(db *compilationDB, path string, log *slog.Logger) (target string, clangArgs []string) {
+	flags, ok := db.lookup(path)
+	if !ok {
+		log.Warn("not in the compilation database, so checked without its build flags", "file", path)
+		return "", nil
+	}
+	clangArgs = flags.clangArgs()
+	if len(clangArgs) > 0 && clangFrontend == nil {
+		log.Info("include paths and defines have no effect without -frontend clang, as the C subset has no preprocessor",
+			"file", path, "includes", len(flags.includes), "defines", len(flags.defines))
+	}
+	if flags.target == "" {
+		return "", clangArgs
+	}
+	if _, err := codegen.LookupTarget(flags.target); err != nil {
+		log.Warn("checked for the default target", "file", path, "err", err)
+		return "", clangArgs
+	}
+	log.Debug("using the target of the compile command", "file", path, "target", flags.target)
+	return flags.target, clangArgs
+}
+
+// clangArgs returns the include paths and defines of f as clang flags,
+// in the order the compile command gave them.
+func (f buildFlags) clangArgs() []string {
+	var args []string
+	for _, dir := range f.includes {
+		args = append(args, "-I"+dir)
+	}
+	for _, def := range f.defines {
+		if name, ok := strings.CutPrefix(def, "-"); ok {
+			args = append(args, "-U"+name)
+		} else {
+			args = append(args, "-D"+def)
+		}
+	}
+	return args
+}
+
+// relativePath returns path relative to the working directory when it
+// is below it, for messages to name files as they would be typed.
+func relativePath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// absPath returns path, resolved against dir if it is relative.
+func absPath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.Clean(path)
+}
+
+// parseBuildFlags picks the include directories, defines and target out
+// of args, the command line of a compiler run in dir. Both -Ifoo and
+// -I foo forms are understood.
+func parseBuildFlags(args []string, dir string) buildFlags {
+	var flags buildFlags
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		// value returns the value of the flag named prefix, joined to it
+		// or in the next argument
+		value := func(prefix string) (string, bool) {
+			if !strings.HasPrefix(arg, prefix) {
+				return "", false
+			}
+			if v := strings.TrimPrefix(arg[len(prefix):], "="); v != "" {
+				return v, true
+			}
+			if i+1 < len(args) {
+				i++
+				return args[i], true
+			}
+			return "", false
+		}
+		if v, ok := value("--target"); ok {
+			flags.target = v
+		} else if v, ok := value("-target"); ok {
+			flags.target = v
+		} else if v, ok := value("-isystem"); ok {
+			flags.includes = append(flags.includes, absPath(dir, v))
+		} else if v, ok := value("-iquote"); ok {
+			flags.includes = append(flags.includes, absPath(dir, v))
+		} else if v, ok := value("-I"); ok {
+			flags.includes = append(flags.includes, absPath(dir, v))
+		} else if v, ok := value("-D"); ok {
+			flags.defines = append(flags.defines, v)
+		} else if v, ok := value("-U"); ok {
+			flags.defines = append(flags.defines, "-"+v)
+		}
+	}
+	return flags
+}
+
+// splitCommand splits a command line into arguments as a POSIX shell
+// would: at unquoted blanks, with single and double quotes and
+// backslashes escaping.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case c == '\\' && i+1 < len(command):
+			i++
+			arg.WriteByte(command[i])
+			inArg = true
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated ' in command")
+			}
+			arg.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`", command[i+1]) >= 0 {
+					i++
+				}
+				arg.WriteByte(command[i])
+			}
+			if i == len(command) {
+				return nil, fmt.Errorf("unterminated \" in command")
+			}
+			inArg = true
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
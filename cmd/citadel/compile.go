@@ -0,0 +1,698 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/gobackend"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llirgen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llvmc"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/harden"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/anouar-bakouch/citadel/pkg/report"
+	"github.com/anouar-bakouch/citadel/pkg/sema"
+)
+
+// outputExtensions are the extensions of the files compile writes when
+// not given -o, by -emit, and by -format for IR and -ast-format for syntax
+// trees.
+var outputExtensions = map[string]string{
+	"ll": ".ll", "bc": ".bc", "asm": ".s", "obj": ".o",
+	"tokens": ".tokens", "text": ".ast", "json": ".ast.json", "findings": ".findings",
+	"c": ".hardened.c", "go": ".go", "manifest": ".manifest.json",
+}
+
+// cmdCompile implements citadel compile, which generates code for one C
+// file, or one module for several.
+func cmdCompile() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("compile", "<input.c>...")
+	output := fs.String("o", "", "output file when -emit names one output, or - for the standard output (default: the input's name with the extension of the output, in the current directory)")
+	o0 := fs.Bool("O0", false, "disable optimizations (default)")
+	o1 := fs.Bool("O1", false, "fold constants, promote locals to registers, eliminate common subexpressions and dead blocks")
+	fs.BoolVar(&opts.DiscardValueNames, "discard-value-names", false, "number values and blocks instead of naming them after the source")
+	fs.BoolVar(&opts.SourceComments, "source-comments", false, "precede each statement's instructions with a comment giving its source line")
+	fs.BoolVar(&opts.StackUsage, "stack-usage", false, "annotate each function with an estimate of its stack frame and print a report")
+	optReport := fs.Bool("opt-report", false, "print what each optimization pass changed")
+	fs.StringVar(&opts.Target, "target", codegen.DefaultTriple, "target triple (x86_64-*, aarch64-*, riscv64-*, wasm32-unknown-unknown)")
+	preset := fs.String("preset", "", "target preset giving the defaults of -target, -pic-level, -stack-protector and -frame-pointer: "+presetNames())
+	sanitize := instrumentationFlags(fs, &opts)
+	fs.StringVar(&opts.CallingConv, "cc", "ccc", "default calling convention for functions (ccc, fastcc)")
+	fs.StringVar(&opts.SymbolPrefix, "symbol-prefix", "", "prefix for the names of functions defined in the input")
+	fs.BoolVar(&opts.Internalize, "internalize", false, "give internal linkage to defined functions other than main and -export")
+	fs.StringVar(&opts.DefaultVisibility, "default-visibility", "", "visibility of defined functions without a visibility attribute (default, hidden, protected)")
+	exports := fs.String("export", "", "comma-separated functions that keep external linkage under -internalize")
+	obfuscate := fs.String("obfuscate", "", "comma-separated functions whose control flow is flattened, with opaque predicates, to resist tampering")
+	fs.IntVar(&opts.WCharSize, "wchar-size", 4, "size of wchar_t in bytes recorded in the module flags (0 to omit)")
+	fs.IntVar(&opts.PICLevel, "pic-level", 0, "PIC level recorded in the module flags (0, 1 or 2)")
+	pic := fs.Bool("pic", false, "generate position-independent code (same as -pic-level=2)")
+	fs.StringVar(&opts.FramePointer, "frame-pointer", "", "frame-pointer policy (none, non-leaf, all)")
+	format := fs.String("format", "ll", "output format (ll for textual IR, bc for bitcode)")
+	emit := fs.String("emit", "ir", "comma-separated outputs to produce: ir in -format, asm for an assembly listing, obj for an object file, tokens, ast in -ast-format, findings for the check report, c for the C source with the checks of -bounds-checks, -overflow-checks, -div-checks and -taint-checks added, go for a Go translation (experimental), or manifest for a JSON list of the module's functions, globals and external dependencies, with the findings and stack estimate of each function")
+	goPackage := fs.String("go-package", "main", "package name of -emit=go")
+	taintChecks := fs.Bool("taint-checks", false, "with -emit=c, report at run time where untrusted data reaches a sink, as the taint analysis found, and abort there if the C is built with -DCITADEL_TAINT_ABORT")
+	astFormat := fs.String("ast-format", "text", "format of -emit=ast (text, json)")
+	toolchain := fs.String("toolchain", "", "llc or clang binary for -emit=asm and -emit=obj (default: $LLC, else llc or clang on PATH)")
+	backend := fs.String("backend", "text", "IR backend to use (text, llir, or llvm in a binary built with -tags llvm)")
+	llvmPasses := fs.String("llvm-passes", "", "with -backend=llvm, the LLVM pass pipeline to run, as opt -passes takes it (default: default<On> at -O1 and above)")
+	diagFormat := fs.String("diagnostics-format", "text", "format of errors and findings: text, or json for one JSON object per build on the standard output (the standard error when an output goes there)")
+	color := colorFlag(fs)
+	maxErrors := maxErrorsFlag(fs)
+	newLogger := verbosityFlags(fs)
+	applyFrontend := frontendFlags(fs)
+	applyMetrics := metricsFlag(fs)
+	applyProject := projectFlags(fs)
+	timeReportFlag := fs.Bool("time-report", false, "print how long each step took and what it allocated: lex, parse, each analysis pass, codegen and output")
+	watch := fs.Bool("watch", false, "keep running, and produce the outputs again whenever an input changes")
+	stream := fs.Bool("stream", false, "compile a function at a time, writing its IR and releasing it before parsing the next, for inputs too large to hold whole; takes a single input, -emit=ir,findings and the text backend, and a function can only call those declared before it, so calls to functions defined later need a prototype first")
+	memoryLimit := fs.String("memory-limit", "", "with -stream, a soft limit on memory such as 512MiB: garbage is collected harder near it, and the analysis summaries spill to a temporary file past a quarter of it; the source, its comments and the findings are still held whole")
+	cacheDir := fs.String("cache", "", "with -emit=findings, a directory caching findings and function summaries as check -cache does")
+	watchInterval := fs.Duration("watch-interval", 300*time.Millisecond, "how often -watch looks for changes to the inputs")
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		cli := setFlags(fs)
+		applyProject()
+		applyPreset(fs, *preset, cli)
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		paths := fs.Args()
+		path := paths[0]
+		inputFile := sourceName(path)
+
+		setSanitizers(*sanitize, &opts)
+		log := newLogger()
+		opts.Logger = log
+		applyFrontend(log)
+		applyMetrics(log)
+
+		validCC := false
+		for _, cc := range codegen.CallingConvs {
+			validCC = validCC || opts.CallingConv == cc
+		}
+		if !validCC {
+			fmt.Fprintf(os.Stderr, "Unknown calling convention: %s\n", opts.CallingConv)
+			os.Exit(1)
+		}
+
+		target, err := codegen.LookupTarget(opts.Target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid target: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *o0 && *o1 {
+			fmt.Fprintf(os.Stderr, "-O0 and -O1 are mutually exclusive\n")
+			os.Exit(1)
+		}
+		if *o1 {
+			opts.OptLevel = 1
+		}
+
+		if *pic && opts.PICLevel == 0 {
+			opts.PICLevel = 2
+		}
+		if opts.PICLevel < 0 || opts.PICLevel > 2 {
+			fmt.Fprintf(os.Stderr, "Invalid PIC level: %d\n", opts.PICLevel)
+			os.Exit(1)
+		}
+		validFP := opts.FramePointer == ""
+		for _, policy := range codegen.FramePointerPolicies {
+			validFP = validFP || opts.FramePointer == policy
+		}
+		if !validFP {
+			fmt.Fprintf(os.Stderr, "Unknown frame-pointer policy: %s\n", opts.FramePointer)
+			os.Exit(1)
+		}
+
+		validVisibility := opts.DefaultVisibility == ""
+		for _, visibility := range codegen.Visibilities {
+			validVisibility = validVisibility || opts.DefaultVisibility == visibility
+		}
+		if !validVisibility {
+			fmt.Fprintf(os.Stderr, "Unknown visibility: %s\n", opts.DefaultVisibility)
+			os.Exit(1)
+		}
+
+		if *format != "ll" && *format != "bc" {
+			fmt.Fprintf(os.Stderr, "Unknown output format: %s\n", *format)
+			os.Exit(1)
+		}
+		if *backend != "text" && *backend != "llir" && *backend != "llvm" {
+			fmt.Fprintf(os.Stderr, "Unknown backend: %s\n", *backend)
+			os.Exit(1)
+		}
+		if *backend == "llvm" && !llvmc.Available {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", llvmc.ErrUnavailable)
+			os.Exit(1)
+		}
+		if *backend != "text" && strings.Contains(","+*emit+",", ",manifest,") {
+			fmt.Fprintf(os.Stderr, "-emit=manifest needs the text backend\n")
+			os.Exit(1)
+		}
+		if *diagFormat != "text" && *diagFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Unknown diagnostics format: %s\n", *diagFormat)
+			os.Exit(1)
+		}
+		if *astFormat != "text" && *astFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Unknown syntax tree format: %s\n", *astFormat)
+			os.Exit(1)
+		}
+
+		if *watch {
+			for _, path := range paths {
+				if path == "-" {
+					fmt.Fprintf(os.Stderr, "-watch cannot watch the standard input\n")
+					os.Exit(1)
+				}
+			}
+		}
+
+		// The outputs to produce, and the files they go to, named after the
+		// first input; those of the standard input are named after it
+		base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if path == "-" {
+			base = "stdin"
+		}
+		kinds := map[string]string{}
+		for _, kind := range strings.Split(*emit, ",") {
+			ext := outputExtensions[kind]
+			switch kind {
+			case "ir":
+				ext = outputExtensions[*format]
+			case "ast":
+				ext = outputExtensions[*astFormat]
+			case "asm", "obj", "tokens", "findings", "c", "go", "manifest":
+			default:
+				fmt.Fprintf(os.Stderr, "Unknown output kind: %s\n", kind)
+				os.Exit(1)
+			}
+			kinds[kind] = base + ext
+		}
+		// Tokens, findings, C, Go and manifests are written file by file
+		for _, kind := range []string{"tokens", "findings", "c", "go", "manifest"} {
+			if kinds[kind] != "" && len(paths) > 1 {
+				fmt.Fprintf(os.Stderr, "-emit=%s takes a single input\n", kind)
+				os.Exit(1)
+			}
+		}
+		var limit int64
+		if *memoryLimit != "" {
+			if !*stream {
+				fmt.Fprintf(os.Stderr, "-memory-limit needs -stream\n")
+				os.Exit(1)
+			}
+			if limit, err = parseSize(*memoryLimit); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -memory-limit: %v\n", err)
+				os.Exit(1)
+			}
+			debug.SetMemoryLimit(limit)
+		}
+		if *stream {
+			for kind := range kinds {
+				if kind != "ir" && kind != "findings" {
+					fmt.Fprintf(os.Stderr, "-stream cannot produce -emit=%s\n", kind)
+					os.Exit(1)
+				}
+			}
+			if len(paths) > 1 || *format != "ll" || *backend != "text" {
+				fmt.Fprintf(os.Stderr, "-stream takes a single input, -format=ll and the text backend\n")
+				os.Exit(1)
+			}
+			if *cacheDir != "" {
+				fmt.Fprintf(os.Stderr, "-stream and -cache are mutually exclusive\n")
+				os.Exit(1)
+			}
+		}
+		if *output != "" {
+			if len(kinds) > 1 {
+				fmt.Fprintf(os.Stderr, "-o cannot name the %d outputs of -emit=%s\n", len(kinds), *emit)
+				os.Exit(1)
+			}
+			for kind := range kinds {
+				kinds[kind] = *output
+			}
+		}
+
+		// Find the toolchain before doing any work that would be wasted
+		// without it
+		var tool string
+		if kinds["asm"] != "" || kinds["obj"] != "" {
+			tool, err = codegen.FindToolchain(*toolchain)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			log.Debug("using toolchain", "path", tool)
+		}
+
+		if *exports != "" {
+			opts.Exports = strings.Split(*exports, ",")
+		}
+		if *obfuscate != "" {
+			opts.Obfuscate = strings.Split(*obfuscate, ",")
+		}
+
+		// Reports go to the standard error when an output goes to the
+		// standard output
+		reports := io.Writer(os.Stdout)
+		for _, out := range kinds {
+			if out == "-" {
+				reports = os.Stderr
+			}
+		}
+
+		// build produces the outputs from the inputs as they are on disk,
+		// returning the error that stopped it, if any. It records the source
+		// of each input in sources for the error to quote, and under
+		// -diagnostics-format=json collects the diagnostics to write in diags.
+		var diags []diag.Diagnostic
+		var findings []analysis.Finding
+		var times *timeReport
+		sources := map[string]string{}
+		build := func() error {
+			// Tokens come first, since they are most useful when the input
+			// does not parse
+			var input string
+			var err error
+			times.measure("read", func() { input, err = readSource(path) })
+			if err != nil {
+				return stageErrorf("io", inputFile, "Error reading input file: %w", err)
+			}
+			sources[inputFile] = input
+			if *diagFormat == "json" {
+				diags = append(diags, lexerDiagnostics(inputFile, input)...)
+			}
+			if *stream {
+				if findings, err = compileStreaming(path, input, kinds, opts, limit, log, times); err != nil {
+					return err
+				}
+				diags = append(diags, findingDiagnostics(inputFile, findings, false)...)
+				if out := kinds["findings"]; out != "" {
+					files := []report.File{{Path: inputFile, Source: input, Findings: findings}}
+					times.measure("output", func() { err = writeFile(out, func(w io.Writer) error { return writeFindings(w, files, false) }) })
+					if err != nil {
+						return stageErrorf("io", inputFile, "Error writing findings: %w", err)
+					}
+				}
+				return nil
+			}
+			if out := kinds["tokens"]; out != "" {
+				times.measure("output", func() {
+					err = writeFile(out, func(w io.Writer) error {
+						_, err := writeTokens(w, inputFile, input, true)
+						return err
+					})
+				})
+				if err != nil {
+					return stageErrorf("io", inputFile, "Error writing tokens: %w", err)
+				}
+			}
+
+			times.lexStep(input)
+			start := time.Now()
+			var lex *lexer.Lexer
+			var program *ast.Program
+			times.measure("parse", func() { lex, program, err = parse(inputFile, input, parser.WithMaxErrors(*maxErrors)) })
+			if err != nil {
+				return stageErrorf("parser", inputFile, "Parse error: %w", err)
+			}
+			log.Debug("parsed", "file", inputFile, "functions", len(program.Functions), "elapsed", time.Since(start))
+			opts.SourceFile = inputFile
+			opts.Source = input
+			opts.Sources = nil
+			if len(paths) > 1 {
+				files := []parser.File{{Name: inputFile, Program: program}}
+				opts.Sources = map[string]string{inputFile: input}
+				for _, path := range paths[1:] {
+					name := sourceName(path)
+					var input string
+					var err error
+					times.measure("read", func() { input, err = readSource(path) })
+					if err != nil {
+						return stageErrorf("io", name, "Error reading input file: %w", err)
+					}
+					if *diagFormat == "json" {
+						diags = append(diags, lexerDiagnostics(name, input)...)
+					}
+					times.lexStep(input)
+					start := time.Now()
+					var program *ast.Program
+					times.measure("parse", func() { _, program, err = parse(name, input, parser.WithMaxErrors(*maxErrors)) })
+					if err != nil {
+						return stageErrorf("parser", name, "Parse error: %w", err)
+					}
+					log.Debug("parsed", "file", name, "functions", len(program.Functions), "elapsed", time.Since(start))
+					files = append(files, parser.File{Name: name, Program: program})
+					opts.Sources[name] = input
+					sources[name] = input
+				}
+				if program, err = parser.Merge(files); err != nil {
+					return &stageError{"semantic", "", err}
+				}
+			}
+
+			if out := kinds["ast"]; out != "" {
+				print := parser.Fprint
+				if *astFormat == "json" {
+					print = parser.FprintJSON
+				}
+				times.measure("output", func() { err = writeFile(out, func(w io.Writer) error { return print(w, program) }) })
+				if err != nil {
+					return stageErrorf("io", inputFile, "Error writing syntax tree: %w", err)
+				}
+			}
+
+			if out := kinds["findings"]; out != "" {
+				findings, err = defaultFindings(context.Background(), path, program, input, lex, *cacheDir, log, times)
+				if err != nil {
+					return stageErrorf("analysis", inputFile, "Analysis error: %w", err)
+				}
+				diags = append(diags, findingDiagnostics(inputFile, findings, false)...)
+				files := []report.File{{Path: inputFile, Source: input, Findings: findings}}
+				times.measure("output", func() { err = writeFile(out, func(w io.Writer) error { return writeFindings(w, files, false) }) })
+				if err != nil {
+					return stageErrorf("io", inputFile, "Error writing findings: %w", err)
+				}
+			}
+
+			if out := kinds["c"]; out != "" {
+				if err := sema.Check(program, opts); err != nil {
+					return codegenError(inputFile, err)
+				}
+				hardening := harden.Options{
+					File:           inputFile,
+					BoundsChecks:   opts.BoundsChecks,
+					OverflowChecks: opts.OverflowChecks,
+					DivisionChecks: opts.DivisionChecks,
+				}
+				if *taintChecks {
+					if findings == nil {
+						findings, err = defaultFindings(context.Background(), path, program, input, lex, *cacheDir, log, times)
+						if err != nil {
+							return stageErrorf("analysis", inputFile, "Analysis error: %w", err)
+						}
+					}
+					hardening.Taint = findings
+				}
+				times.measure("output", func() {
+					err = writeFile(out, func(w io.Writer) error { return harden.Write(w, program, input, lex.Comments(), hardening) })
+				})
+				if err != nil {
+					return stageErrorf("io", inputFile, "Error writing C: %w", err)
+				}
+			}
+
+			if out := kinds["go"]; out != "" {
+				if err := sema.Check(program, opts); err != nil {
+					return codegenError(inputFile, err)
+				}
+				var src string
+				times.measure("codegen", func() {
+					src, err = gobackend.New(gobackend.Options{Package: *goPackage, File: inputFile}).Generate(program)
+				})
+				if err != nil {
+					return codegenError(inputFile, err)
+				}
+				times.measure("output", func() { err = writeFile(out, func(w io.Writer) error { _, err := io.WriteString(w, src); return err }) })
+				if err != nil {
+					return stageErrorf("io", inputFile, "Error writing Go: %w", err)
+				}
+			}
+
+			if kinds["ir"] == "" && kinds["asm"] == "" && kinds["obj"] == "" && kinds["manifest"] == "" {
+				return nil
+			}
+
+			// Generate LLVM IR, without the division checks value ranges
+			// show cannot trap
+			if opts.DivisionChecks {
+				opts.ProvenDivisions = analysis.ProveDivisions(program)
+			}
+			var gen codegen.Backend
+			switch *backend {
+			case "llir":
+				gen = llirgen.New(opts)
+			case "llvm":
+				llvm := llvmc.New(opts)
+				llvm.Passes = *llvmPasses
+				gen = llvm
+			default:
+				gen = codegen.NewWithOptions(opts)
+			}
+			// Textual IR alone is streamed straight to the output file; the
+			// other outputs are produced from the IR in memory
+			var ir string
+			streamed := *format == "ll" && kinds["ir"] != "" && kinds["asm"] == "" && kinds["obj"] == ""
+			start = time.Now()
+			times.measure("codegen", func() {
+				if streamed {
+					err = generateFile(gen, program, kinds["ir"])
+				} else {
+					ir, err = gen.Generate(program)
+				}
+			})
+			if err != nil {
+				return codegenError(inputFile, err)
+			}
+			log.Debug("generated IR", "backend", *backend, "elapsed", time.Since(start))
+
+			if report, ok := gen.(*codegen.CodeGen); ok && *optReport {
+				fmt.Fprintf(reports, "Optimization report:\n")
+				for _, stat := range report.PassStats() {
+					fmt.Fprintf(reports, "  %s: %s: %d %s\n", stat.Function, stat.Pass, stat.Changes, stat.Unit)
+				}
+			}
+
+			if report, ok := gen.(*codegen.CodeGen); ok && opts.StackUsage {
+				fmt.Fprintf(reports, "Stack usage:\n")
+				for _, est := range report.StackEstimates() {
+					fmt.Fprintf(reports, "  %s: %d bytes (%d locals, %d call overhead)\n", est.Function, est.Total, est.Locals, est.Overhead)
+				}
+				frames := map[string]int{}
+				for _, est := range report.StackEstimates() {
+					frames[est.Function] = est.Total
+				}
+				graph := analysis.BuildCallGraph(program)
+				for _, root := range graph.Roots() {
+					chain, total, recursive := graph.DeepestChain(root, frames)
+					if recursive {
+						fmt.Fprintf(reports, "  worst case from %s: unbounded, recursion through %s\n", root, strings.Join(chain, " -> "))
+					} else {
+						fmt.Fprintf(reports, "  worst case from %s: %d bytes through %s\n", root, total, strings.Join(chain, " -> "))
+					}
+				}
+			}
+
+			if out := kinds["manifest"]; out != "" {
+				if findings == nil {
+					findings, err = defaultFindings(context.Background(), path, program, input, lex, *cacheDir, log, times)
+					if err != nil {
+						return stageErrorf("analysis", inputFile, "Analysis error: %w", err)
+					}
+				}
+				times.measure("output", func() {
+					err = writeFile(out, func(w io.Writer) error { return writeManifest(w, gen.(*codegen.CodeGen), program, findings) })
+				})
+				if err != nil {
+					return stageErrorf("io", inputFile, "Error writing manifest: %w", err)
+				}
+			}
+
+			if streamed {
+				return nil
+			}
+			for _, kind := range []string{"ir", "asm", "obj"} {
+				out := kinds[kind]
+				if out == "" {
+					continue
+				}
+				var output []byte
+				start := time.Now()
+				switch {
+				case kind != "ir":
+					times.measure(kind, func() { output, err = codegen.Native(ir, kind, tool, target, opts) })
+					if err != nil {
+						return stageErrorf("codegen", inputFile, "Error compiling to native code: %w", err)
+					}
+				case *format == "bc":
+					times.measure("bitcode", func() { output, err = codegen.Bitcode(ir) })
+					if err != nil {
+						return stageErrorf("codegen", inputFile, "Error assembling bitcode: %w", err)
+					}
+				default:
+					output = []byte(ir)
+				}
+
+				// Write output file
+				times.measure("output", func() {
+					err = writeFile(out, func(w io.Writer) error {
+						_, err := w.Write(output)
+						return err
+					})
+				})
+				if err != nil {
+					return stageErrorf("io", inputFile, "Error writing output file: %w", err)
+				}
+				log.Debug("wrote output", "kind", kind, "path", out, "elapsed", time.Since(start))
+			}
+			return nil
+		}
+
+		// run builds and reports the outcome: as one line of JSON
+		// diagnostics, or else by writing the error that stopped the build. A
+		// summary follows when there were errors or findings
+		run := func() error {
+			diags, findings = nil, nil
+			if *timeReportFlag {
+				times = newTimeReport()
+				defer times.write(reports, lexNote, "codegen makes the semantic checks as it lowers the program")
+			}
+			err := build()
+			serviceMetrics.file("compile", err, findings)
+			for range paths[1:] {
+				serviceMetrics.file("compile", err, nil)
+			}
+			errorCount := 0
+			if *diagFormat != "json" {
+				if err != nil {
+					renderer := newErrorRenderer(useColor(*color), sources)
+					renderer.limit = *maxErrors
+					renderer.write(os.Stderr, err)
+					renderer.finish(os.Stderr)
+					errorCount = renderer.errors
+				}
+			} else {
+				if err != nil {
+					diags = append(diags, errorDiagnostics(err)...)
+				}
+				if werr := writeDiagnostics(reports, diags); werr != nil {
+					fmt.Fprintf(os.Stderr, "Error writing diagnostics: %v\n", werr)
+					os.Exit(1)
+				}
+				for _, d := range diags {
+					if d.Severity == "error" {
+						errorCount++
+					}
+				}
+			}
+			if errorCount > 0 || len(findings) > 0 {
+				writeSummary(os.Stderr, errorCount, 0, findings)
+			}
+			return err
+		}
+
+		if *watch {
+			// -quiet silences the progress of each build, but not the line
+			// saying that one finished, which is all -watch shows
+			watchInputs(paths, *watchInterval, run, slog.New(logging.NewHandler(os.Stderr, logging.Level(0))))
+			return
+		}
+		if err := run(); err != nil {
+			os.Exit(exitCode(err))
+		}
+	}
+}
+
+// presetNames lists the names of the presets for usage messages.
+func presetNames() string {
+	var names []string
+	for _, preset := range codegen.Presets {
+		names = append(names, preset.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// applyPreset gives flags of fs the values of the preset called name, if
+// it is not empty, exiting if there is no such preset. A preset on the
+// command line, which cli names the flags of, overrides the project file
+// but not the other flags of the command line; one from the project file
+// overrides neither.
+func applyPreset(fs *flag.FlagSet, name string, cli map[string]bool) {
+	if name == "" {
+		return
+	}
+	preset, err := codegen.LookupPreset(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid preset: %v\n", err)
+		os.Exit(exitUsage)
+	}
+	given := cli
+	if !cli["preset"] {
+		given = setFlags(fs)
+	}
+	values := map[string]string{
+		"target":          preset.Triple,
+		"pic-level":       strconv.Itoa(preset.PICLevel),
+		"stack-protector": preset.StackProtector,
+		"frame-pointer":   preset.FramePointer,
+	}
+	for key, value := range values {
+		if !given[key] {
+			fs.Set(key, value)
+		}
+	}
+}
+
+// writeManifest writes the manifest of the module gen generated for
+// program as indented JSON, with each function's count of the findings
+// that are not suppressed.
+func writeManifest(w io.Writer, gen *codegen.CodeGen, program *ast.Program, findings []analysis.Finding) error {
+	counts := map[string]int{}
+	for _, f := range findings {
+		if f.Suppressed == nil {
+			counts[f.Function]++
+		}
+	}
+	manifest := gen.Manifest(program)
+	for i := range manifest.Functions {
+		manifest.Functions[i].Findings = counts[manifest.Functions[i].Name]
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// defaultFindings returns the findings check reports with its default
+// flags for program, read from input, the file at path, by lex: those of
+// the rules the nearest policy file enables, unless it excludes the file,
+// marked suppressed as its comments say, and cached in cacheDir as
+// analyzeCached does. Each pass is measured as a step
+// of times, if it is not nil, and the analysis stops once ctx is done.
+func defaultFindings(ctx context.Context, path string, program *ast.Program, input string, lex *lexer.Lexer, cacheDir string, log *slog.Logger, times *timeReport) ([]analysis.Finding, error) {
+	config := analysis.DefaultConfig()
+	findings := []analysis.Finding{}
+	policy, _ := loadPolicy("", path)
+	if policy != nil {
+		config = policy.Config()
+	}
+	config.Logger = log
+	config.Measure = serviceMetrics.measureAnalysis(times.measureAnalysis())
+	config.Context = ctx
+	if policy == nil || !policy.Excludes(path) {
+		var err error
+		if findings, err = analyzeCached(cacheDir, program, input, config); err != nil {
+			return nil, err
+		}
+	}
+	suppressions, _ := analysis.ParseSuppressions(lex.Comments())
+	analysis.Suppress(findings, suppressions)
+	return findings, nil
+}
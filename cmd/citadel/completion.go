@@ -0,0 +1,227 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llvmc"
+)
+
+// The completion scripts list the commands, so completion is added to
+// them once they are defined.
+func init() {
+	commands = append(commands, command{"completion", "print a bash, zsh or fish script completing commands and flags", cmdCompletion})
+}
+
+// completionFlag is a flag of a command as the completion scripts offer
+// it.
+type completionFlag struct {
+	name  string
+	usage string   // the usage of the flag up to its first parenthesis
+	value bool     // whether it takes a value
+	words []string // the values to offer, or none to offer file names
+}
+
+// flagValues returns the values to complete for the flag called name of
+// command, or nil for file names.
+func flagValues(command, name string) []string {
+	switch name {
+	case "target":
+		return []string{codegen.DefaultTriple, "x86_64-unknown-linux-gnu", "x86_64-apple-darwin", "aarch64-unknown-linux-gnu", "riscv64-unknown-elf", "wasm32-unknown-unknown", "wasm32-wasi"}
+	case "preset":
+		var names []string
+		for _, preset := range codegen.Presets {
+			names = append(names, preset.Name)
+		}
+		return names
+	case "stack-protector":
+		return codegen.StackProtectors
+	case "enable", "disable":
+		var ids []string
+		for _, rule := range analysis.Rules() {
+			ids = append(ids, rule.ID)
+		}
+		return ids
+	case "fail-on":
+		var names []string
+		for s := analysis.Info; s <= analysis.Critical; s++ {
+			names = append(names, s.String())
+		}
+		return names
+	case "color":
+		return []string{"auto", "always", "never"}
+	case "emit":
+		return []string{"ir", "asm", "obj", "tokens", "ast", "findings", "c", "go", "manifest"}
+	case "format":
+		switch command {
+		case "compile":
+			return []string{"ll", "bc"}
+		case "graph":
+			return []string{"dot", "svg"}
+		}
+		return []string{"text", "json"}
+	case "ast-format", "diagnostics-format":
+		return []string{"text", "json"}
+	case "backend":
+		if llvmc.Available {
+			return []string{"text", "llir", "llvm"}
+		}
+		return []string{"text", "llir"}
+	case "cc":
+		return codegen.CallingConvs
+	case "default-visibility":
+		return codegen.Visibilities
+	case "frame-pointer":
+		return codegen.FramePointerPolicies
+	case "fsanitize":
+		return []string{"address", "memory", "thread"}
+	}
+	return nil
+}
+
+// completionFlags returns the flags of cmd, sorted by name.
+func completionFlags(cmd command) []completionFlag {
+	var flags []completionFlag
+	fs, _ := cmd.flags()
+	fs.VisitAll(func(f *flag.Flag) {
+		usage := f.Usage
+		if i := strings.Index(usage, " ("); i >= 0 {
+			usage = usage[:i]
+		}
+		value := true
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			value = false
+		}
+		flag := completionFlag{name: f.Name, usage: usage, value: value}
+		if value {
+			flag.words = flagValues(cmd.name, f.Name)
+		}
+		flags = append(flags, flag)
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+// cmdCompletion implements citadel completion, which prints a script that
+// completes the commands, flags, rule names and target triples of
+// citadel in bash, zsh or fish.
+func cmdCompletion() (*flag.FlagSet, func(args []string)) {
+	fs := newFlagSet("completion", "bash|zsh|fish")
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		var write func(io.Writer)
+		switch fs.Arg(0) {
+		case "bash":
+			write = writeBashCompletion
+		case "zsh":
+			write = writeZshCompletion
+		case "fish":
+			write = writeFishCompletion
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown shell: %s (bash, zsh or fish)\n", fs.Arg(0))
+			os.Exit(exitUsage)
+		}
+		write(os.Stdout)
+	}
+}
+
+// writeBashCompletion writes a bash completion script, for
+// source <(citadel completion bash).
+func writeBashCompletion(w io.Writer) {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	fmt.Fprintf(w, `# bash completion for citadel
+_citadel() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W %q -- "$cur"))
+        return
+    fi
+    local flags
+    case "${COMP_WORDS[1]} $prev" in
+`, strings.Join(names, " "))
+	for _, cmd := range commands {
+		var all []string
+		for _, f := range completionFlags(cmd) {
+			all = append(all, "-"+f.name)
+			switch {
+			case len(f.words) > 0:
+				fmt.Fprintf(w, "    %q)\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n        return ;;\n", cmd.name+" -"+f.name, strings.Join(f.words, " "))
+			case f.value:
+				fmt.Fprintf(w, "    %q)\n        COMPREPLY=($(compgen -f -- \"$cur\"))\n        return ;;\n", cmd.name+" -"+f.name)
+			}
+		}
+		fmt.Fprintf(w, "    %q*)\n        flags=%q ;;\n", cmd.name+" ", strings.Join(all, " "))
+	}
+	fmt.Fprint(w, `    esac
+    if [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+complete -o filenames -F _citadel citadel
+`)
+}
+
+// writeZshCompletion writes a zsh completion script, to install as
+// _citadel in a directory of $fpath.
+func writeZshCompletion(w io.Writer) {
+	quote := strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+	fmt.Fprint(w, "#compdef citadel\n\n_citadel() {\n    local -a commands\n    commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "        '%s:%s'\n", cmd.name, quote.Replace(cmd.summary))
+	}
+	fmt.Fprint(w, "    )\n    if (( CURRENT == 2 )); then\n        _describe command commands\n        return\n    fi\n    shift words\n    (( CURRENT-- ))\n    case $words[1] in\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %s)\n        _arguments \\\n", cmd.name)
+		for _, f := range completionFlags(cmd) {
+			spec := fmt.Sprintf("-%s[%s]", f.name, quote.Replace(f.usage))
+			switch {
+			case len(f.words) > 0:
+				spec += fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.words, " "))
+			case f.value:
+				spec += ":" + f.name + ":_files"
+			}
+			fmt.Fprintf(w, "            '%s' \\\n", spec)
+		}
+		fmt.Fprint(w, "            '*:file:_files' ;;\n")
+	}
+	fmt.Fprint(w, "    esac\n}\n\n_citadel \"$@\"\n")
+}
+
+// writeFishCompletion writes a fish completion script, to install as
+// citadel.fish in ~/.config/fish/completions.
+func writeFishCompletion(w io.Writer) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	fmt.Fprint(w, "# fish completion for citadel\ncomplete -c citadel -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c citadel -n __fish_use_subcommand -a %s -d '%s'\n", cmd.name, quote.Replace(cmd.summary))
+	}
+	for _, cmd := range commands {
+		when := "'__fish_seen_subcommand_from " + cmd.name + "'"
+		fmt.Fprintf(w, "complete -c citadel -n %s -F\n", when)
+		for _, f := range completionFlags(cmd) {
+			line := fmt.Sprintf("complete -c citadel -n %s -o %s", when, f.name)
+			switch {
+			case len(f.words) > 0:
+				line += fmt.Sprintf(" -x -a '%s'", strings.Join(f.words, " "))
+			case f.value:
+				line += " -r"
+			}
+			fmt.Fprintf(w, "%s -d '%s'\n", line, quote.Replace(f.usage))
+		}
+	}
+}
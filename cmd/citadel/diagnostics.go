@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+)
+
+// stageError is an error that stopped compile or check at one of its
+// steps, which JSON diagnostics give as their source.
+type stageError struct {
+	stage string
+	file  string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+
+func (e *stageError) Unwrap() error { return e.err }
+
+// stageErrorf returns a stageError of the step stage for file, formatting
+// its message as fmt.Errorf does.
+func stageErrorf(stage, file, format string, args ...interface{}) error {
+	return &stageError{stage, file, fmt.Errorf(format, args...)}
+}
+
+// codegenError returns err, which checking or generating the code of file
+// failed with, as a stageError: of the codegen step for a failure of the
+// code generator itself, such as an option the backend cannot honor or IR
+// its verifier rejects, and of the semantic step for an error in the
+// program.
+func codegenError(file string, err error) error {
+	if codegen.IsBackendError(err) {
+		return stageErrorf("codegen", file, "Code generation error: %w", err)
+	}
+	return stageErrorf("semantic", file, "Semantic error: %w", err)
+}
+
+// errorDiagnostics returns the diagnostics of err, one for each
+// parser.Error it holds, with its position, or else one for the whole
+// error.
+func errorDiagnostics(err error) []diag.Diagnostic {
+	var bag diag.Bag
+	addError(&bag, err)
+	return bag.Diagnostics()
+}
+
+// addError adds the diagnostics of err to bag, of the step and file the
+// stageError it wraps names, or of io.
+func addError(bag *diag.Bag, err error) {
+	stage, file := "io", ""
+	var serr *stageError
+	if errors.As(err, &serr) {
+		stage, file = serr.stage, serr.file
+	}
+	bag.AddError(stage, file, err)
+}
+
+// lexerDiagnostics returns a diagnostic for each illegal token of input,
+// the source of file. With the clang frontend there are none: Citadel's
+// lexer does not read all the C clang does.
+func lexerDiagnostics(file, input string) []diag.Diagnostic {
+	if clangFrontend != nil {
+		return nil
+	}
+	var bag diag.Bag
+	bag.AddLexerErrors(file, input)
+	return bag.Diagnostics()
+}
+
+// findingDiagnostics returns a diagnostic for each of findings in file,
+// leaving out the suppressed ones unless showSuppressed is set.
+func findingDiagnostics(file string, findings []analysis.Finding, showSuppressed bool) []diag.Diagnostic {
+	var diags []diag.Diagnostic
+	for _, f := range findings {
+		if f.Suppressed != nil && !showSuppressed {
+			continue
+		}
+		diags = append(diags, f.Diagnostic(file))
+	}
+	return diags
+}
+
+// writeDiagnostics writes diags to w as one line of JSON, an object whose
+// diagnostics field lists them.
+func writeDiagnostics(w io.Writer, diags []diag.Diagnostic) error {
+	if diags == nil {
+		diags = []diag.Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(struct {
+		Diagnostics []diag.Diagnostic `json:"diagnostics"`
+	}{diags})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/harden"
+)
+
+// cmdDifftest implements citadel difftest, which builds a C file with
+// citadel and with a reference compiler, runs both on the same generated
+// arguments and standard input, and reports the runs whose exit status
+// or standard output differ. Such a divergence is a miscompile by one of
+// the two, or undefined behavior in the program that they treat
+// differently. It exits with exitDiverged if any run diverged, with the
+// status of the step that failed if citadel cannot build the program, and
+// with exitUsage if the reference compiler cannot.
+func cmdDifftest() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("difftest", "<input.c>")
+	o1 := fs.Bool("O1", false, "compile with -O1")
+	reference := fs.String("reference", "clang", "C compiler to compare with: clang, whose -S -emit-llvm IR runs like citadel's, or another such as gcc, whose executable runs")
+	lli := fs.String("lli", "", "lli binary that runs the IR (default: lli on PATH; without it, the IR is linked and run natively)")
+	toolchain := fs.String("toolchain", "", "clang or llc binary that links citadel's IR when there is no lli (default: clang, else llc on PATH)")
+	runs := fs.Int("runs", 100, "number of generated inputs to run both programs on")
+	seed := fs.Int64("seed", 1, "seed of the generated inputs, to repeat a session")
+	timeout := fs.Duration("timeout", 5*time.Second, "how long a run may take before it is stopped and counts as hanging")
+	maxDivergences := fs.Int("max-divergences", 10, "stop after reporting this many divergences (0 for no limit)")
+	color := colorFlag(fs)
+	newLogger := verbosityFlags(fs)
+	applyProject := projectFlags(fs)
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		applyProject()
+		path := inputArg(fs)
+		name := sourceName(path)
+		log := newLogger()
+		opts.Logger = log
+		if *o1 {
+			opts.OptLevel = 1
+		}
+
+		input, err := readSource(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		sources := map[string]string{name: input}
+		_, program, err := parse(name, input)
+		if err != nil {
+			newErrorRenderer(useColor(*color), sources).write(os.Stderr, stageErrorf("parser", name, "Parse error: %w", err))
+			os.Exit(exitParse)
+		}
+		hasMain := false
+		for _, fn := range program.Functions {
+			hasMain = hasMain || fn.Name == "main" && fn.Body != nil
+		}
+		if !hasMain {
+			fmt.Fprintf(os.Stderr, "%s does not define main, so there is nothing to run\n", name)
+			os.Exit(exitUsage)
+		}
+		opts.SourceFile, opts.Source = name, input
+		ir, err := codegen.NewWithOptions(opts).Generate(program)
+		if err != nil {
+			err = codegenError(name, err)
+			newErrorRenderer(useColor(*color), sources).write(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+
+		dir, err := os.MkdirTemp("", "citadel-difftest")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating a temporary directory: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		// os.Exit skips deferred calls
+		exit := func(status int) {
+			os.RemoveAll(dir)
+			os.Exit(status)
+		}
+
+		// The reference compiles the source itself, with the C library headers
+		// in place of the prototypes the subset needs, which C compilers reject
+		var c bytes.Buffer
+		if err := harden.WriteSource(&c, program, input); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing the C for the reference: %v\n", err)
+			exit(exitUsage)
+		}
+		interpreter := *lli
+		if interpreter == "" {
+			interpreter, _ = exec.LookPath("lli")
+		}
+		citadel, err := difftestCitadel(dir, ir, interpreter, *toolchain, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error building citadel's program: %v\n", err)
+			exit(exitCodegen)
+		}
+		ref, err := difftestReference(dir, c.String(), *reference, interpreter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error building the reference program: %v\n", err)
+			exit(exitUsage)
+		}
+		log.Debug("built both programs", "citadel", strings.Join(citadel, " "), "reference", strings.Join(ref, " "))
+
+		refName := filepath.Base(*reference)
+		rng := rand.New(rand.NewSource(*seed))
+		diverged := 0
+		for run := 0; run < *runs; run++ {
+			programArgs, stdin := difftestInput(run, rng)
+			got := difftestRun(citadel, programArgs, stdin, *timeout)
+			want := difftestRun(ref, programArgs, stdin, *timeout)
+			if got == want {
+				continue
+			}
+			diverged++
+			quoted := []string{}
+			for _, arg := range programArgs {
+				quoted = append(quoted, strconv.Quote(arg))
+			}
+			fmt.Printf("divergence in run %d: arguments [%s], standard input %q\n", run, strings.Join(quoted, " "), stdin)
+			width := len(refName)
+			if width < len("citadel") {
+				width = len("citadel")
+			}
+			fmt.Printf("  %-*s %s\n", width+1, "citadel:", got.describe(want))
+			fmt.Printf("  %-*s %s\n", width+1, refName+":", want.describe(got))
+			if *maxDivergences > 0 && diverged >= *maxDivergences {
+				fmt.Printf("stopping after %s\n", plural(diverged, "divergence"))
+				break
+			}
+		}
+		if diverged > 0 {
+			fmt.Printf("FAIL: %s of %s with -seed %d diverged from %s\n", plural(diverged, "run"), name, *seed, refName)
+			exit(exitDiverged)
+		}
+		fmt.Printf("ok: %s of %s behaved as with %s\n", plural(*runs, "run"), name, refName)
+		exit(exitOK)
+	}
+}
+
+// difftestCitadel writes citadel's IR to dir and returns the command that
+// runs it: lli with the IR, or else the executable the IR is linked to.
+func difftestCitadel(dir, ir, lli, toolchain string, opts codegen.Options) ([]string, error) {
+	path := filepath.Join(dir, "citadel.ll")
+	if lli != "" {
+		if opts.CFI {
+			lowered, err := codegen.LowerTypeTests(ir)
+			if err != nil {
+				return nil, err
+			}
+			ir = lowered
+		}
+		return []string{lli, path}, os.WriteFile(path, []byte(ir), 0644)
+	}
+	target, err := codegen.LookupTarget(opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	tool, err := codegen.FindLinker(toolchain)
+	if err != nil {
+		return nil, err
+	}
+	exe := filepath.Join(dir, "citadel")
+	return []string{exe}, codegen.Link(ir, exe, tool, target, opts)
+}
+
+// difftestReference compiles c with the reference compiler and returns
+// the command that runs the result. clang's IR, at -O0 like citadel's
+// default, runs through lli when citadel's does; anything else is built
+// into an executable.
+func difftestReference(dir, c, compiler, lli string) ([]string, error) {
+	source := filepath.Join(dir, "reference.c")
+	if err := os.WriteFile(source, []byte(c), 0644); err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath(compiler)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-w", "-O0"}
+	var run []string
+	if strings.Contains(filepath.Base(path), "clang") && lli != "" {
+		out := filepath.Join(dir, "reference.ll")
+		args = append(args, "-S", "-emit-llvm", "-o", out, source)
+		run = []string{lli, out}
+	} else {
+		out := filepath.Join(dir, "reference")
+		args = append(args, "-o", out, source)
+		run = []string{out}
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", filepath.Base(path), err, strings.TrimSpace(stderr.String()))
+	}
+	return run, nil
+}
+
+// difftestInts are integer arguments at the edges of the types of the
+// subset, where the two compilers are most likely to disagree.
+var difftestInts = []string{"0", "1", "-1", "2", "7", "-128", "127", "255", "256", "32767", "-32768", "65536", "2147483647", "-2147483648", "4294967296"}
+
+// difftestInput returns the arguments and standard input of a run. The
+// first run has neither, the next ones one boundary integer each, and the
+// rest up to three random integers, words and longer strings, with a line
+// of standard input half of the time.
+func difftestInput(run int, rng *rand.Rand) ([]string, string) {
+	switch {
+	case run == 0:
+		return nil, ""
+	case run <= len(difftestInts):
+		return []string{difftestInts[run-1]}, ""
+	}
+	word := func(n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte('a' + rng.Intn(26))
+		}
+		return string(b)
+	}
+	var args []string
+	for i := rng.Intn(4); i > 0; i-- {
+		switch rng.Intn(4) {
+		case 0:
+			args = append(args, difftestInts[rng.Intn(len(difftestInts))])
+		case 1:
+			args = append(args, strconv.Itoa(rng.Intn(201)-100))
+		case 2:
+			args = append(args, word(rng.Intn(9)))
+		default:
+			args = append(args, word(8+rng.Intn(57)))
+		}
+	}
+	stdin := ""
+	if rng.Intn(2) == 0 {
+		stdin = word(rng.Intn(20)) + "\n"
+	}
+	return args, stdin
+}
+
+// difftestOutcome is what a run of a program did, as far as difftest
+// compares it.
+type difftestOutcome struct {
+	status  string // "exit N", "signal S" or "timed out"
+	stdout  string
+	failure string // why the program could not be run at all
+}
+
+// difftestRun runs the command with the arguments and standard input,
+// stopping it after timeout.
+func difftestRun(command, args []string, stdin string, timeout time.Duration) difftestOutcome {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], append(command[1:len(command):len(command)], args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	outcome := difftestOutcome{status: "exit 0", stdout: stdout.String()}
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		outcome.status = "timed out"
+	case errors.As(err, &exitErr):
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			outcome.status = "signal " + status.Signal().String()
+		} else {
+			outcome.status = fmt.Sprintf("exit %d", exitErr.ExitCode())
+		}
+	case err != nil:
+		outcome.status, outcome.failure = "failed", err.Error()
+	}
+	return outcome
+}
+
+// describe gives the status of o and, if it differs from that of other,
+// the first line of its standard output that does.
+func (o difftestOutcome) describe(other difftestOutcome) string {
+	if o.failure != "" {
+		return "could not run: " + o.failure
+	}
+	if o.stdout == other.stdout {
+		return o.status + ", the same standard output"
+	}
+	lines, others := strings.SplitAfter(o.stdout, "\n"), strings.SplitAfter(other.stdout, "\n")
+	for i, line := range lines {
+		if i < len(others) && line == others[i] {
+			continue
+		}
+		if line == "" {
+			return fmt.Sprintf("%s, standard output ends after line %d", o.status, i)
+		}
+		if len(line) > 80 {
+			line = line[:80] + "..."
+		}
+		return fmt.Sprintf("%s, standard output line %d %q", o.status, i+1, line)
+	}
+	return fmt.Sprintf("%s, standard output ends after line %d", o.status, len(lines))
+}
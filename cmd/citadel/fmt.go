@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// cmdFmt implements citadel fmt, which reformats C files in one layout:
+// it writes them to the standard output, back to the files with -w, or
+// as a diff with -diff.
+func cmdFmt() (*flag.FlagSet, func(args []string)) {
+	fs := newFlagSet("fmt", "[input.c ...]")
+	write := fs.Bool("w", false, "write the result back to the files instead of the standard output")
+	diff := fs.Bool("diff", false, "print a unified diff of the changes instead of the result")
+	list := fs.Bool("l", false, "list the files whose layout differs instead of printing the result")
+	color := colorFlag(fs)
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+
+		paths := fs.Args()
+		if len(paths) == 0 {
+			paths = []string{"-"}
+		}
+		paths, err := expandInputs(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if *write {
+			for _, path := range paths {
+				if path == "-" {
+					fmt.Fprintln(os.Stderr, "Error: -w cannot write back to the standard input")
+					os.Exit(exitUsage)
+				}
+			}
+		}
+
+		// The first file that fails gives the exit status
+		code := exitOK
+		fail := func(status int) {
+			if code == exitOK {
+				code = status
+			}
+		}
+		for _, path := range paths {
+			name := sourceName(path)
+			input, err := readSource(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+				fail(exitUsage)
+				continue
+			}
+			lex, program, err := parse(name, input)
+			if err != nil {
+				newErrorRenderer(useColor(*color), map[string]string{name: input}).write(os.Stderr, err)
+				fail(exitParse)
+				continue
+			}
+			var out bytes.Buffer
+			if err := parser.Format(&out, program, input, lex.Comments()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error formatting %s: %v\n", name, err)
+				fail(exitUsage)
+				continue
+			}
+			formatted := out.String()
+			changed := formatted != input
+
+			switch {
+			case *list:
+				if changed {
+					fmt.Println(name)
+				}
+			case *diff:
+				if changed {
+					writeDiff(os.Stdout, name, "original", "formatted", input, formatted)
+				}
+			case *write:
+				if !changed {
+					continue
+				}
+				if err := writeFileKeepingMode(path, formatted); err != nil {
+					fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", name, err)
+					fail(exitUsage)
+				}
+			default:
+				io.WriteString(os.Stdout, formatted)
+			}
+		}
+		os.Exit(code)
+	}
+}
+
+// writeFileKeepingMode replaces the contents of the file at path with
+// text, keeping its permissions.
+func writeFileKeepingMode(path, text string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(text), info.Mode().Perm())
+}
+
+// diffContext is the number of unchanged lines writeDiff shows around
+// each change.
+const diffContext = 3
+
+// writeDiff writes the changes from old to new, the contents of file, to
+// w as a unified diff, its sides labeled oldLabel and newLabel.
+func writeDiff(w io.Writer, file, oldLabel, newLabel, old, new string) {
+	a, b := splitLines(old), splitLines(new)
+	ops := diffLines(a, b)
+	fmt.Fprintf(w, "--- %s (%s)\n+++ %s (%s)\n", file, oldLabel, file, newLabel)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// A hunk takes in the changes after ops[i] up to a run of more
+		// unchanged lines than the context of two hunks would show
+		lastChange := i
+		for j := i + 1; j < len(ops) && j-lastChange <= 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				lastChange = j
+			}
+		}
+		first, last := i-diffContext, lastChange+1+diffContext
+		if first < 0 {
+			first = 0
+		}
+		if last > len(ops) {
+			last = len(ops)
+		}
+		hunk := ops[first:last]
+		oldLen, newLen := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				oldLen++
+			}
+			if op.kind != '-' {
+				newLen++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(hunk[0].oldLine, oldLen), hunkRange(hunk[0].newLine, newLen))
+		for _, op := range hunk {
+			fmt.Fprintf(w, "%c%s\n", op.kind, op.text)
+		}
+		i = last
+	}
+}
+
+// hunkRange returns the line range of one side of a hunk as a unified
+// diff gives it: an empty range names the line before it.
+func hunkRange(line, n int) string {
+	if n == 0 {
+		line--
+	}
+	if n == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, n)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffOp is a line of a diff: kept (' '), removed ('-') or added ('+'),
+// with the lines of the old and new text it is at, from 1.
+type diffOp struct {
+	kind             byte
+	text             string
+	oldLine, newLine int
+}
+
+// diffLines returns the edits that turn a into b, keeping a longest
+// common subsequence of their lines.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i], i + 1, j + 1})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j], i + 1, j + 1})
+			j++
+		}
+	}
+	return ops
+}
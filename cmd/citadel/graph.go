@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/llir/llvm/asm"
+)
+
+// cmdGraph implements citadel graph, which draws the control-flow graph
+// of a function or the call graph of the program as Graphviz DOT, or as
+// SVG by running dot.
+func cmdGraph() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("graph", "-cfg func|-callgraph <input.c>...")
+	cfg := fs.String("cfg", "", "draw the basic blocks of the IR of this function and the branches between them")
+	callgraph := fs.Bool("callgraph", false, "draw which functions the program defines call which")
+	output := fs.String("o", "-", "output file, or - for the standard output")
+	format := fs.String("format", "dot", "output format: dot, or svg by running Graphviz dot")
+	o1 := fs.Bool("O1", false, "draw the control-flow graph of the optimized IR")
+	color := colorFlag(fs)
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		if fs.NArg() == 0 || (*cfg == "") == !*callgraph {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		if *format != "dot" && *format != "svg" {
+			fmt.Fprintf(os.Stderr, "Unknown output format: %s\n", *format)
+			os.Exit(exitUsage)
+		}
+		if *format == "svg" {
+			if _, err := exec.LookPath("dot"); err != nil {
+				fmt.Fprintln(os.Stderr, "Error: -format svg runs dot, from Graphviz, which is not on PATH")
+				os.Exit(exitUsage)
+			}
+		}
+		if *o1 {
+			opts.OptLevel = 1
+		}
+
+		sources := map[string]string{}
+		fail := func(err error) {
+			newErrorRenderer(useColor(*color), sources).write(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+		var files []parser.File
+		for _, path := range fs.Args() {
+			name := sourceName(path)
+			input, err := readSource(path)
+			if err != nil {
+				fail(stageErrorf("io", name, "Error reading input file: %w", err))
+			}
+			sources[name] = input
+			_, program, err := parse(name, input)
+			if err != nil {
+				fail(stageErrorf("parser", name, "Parse error: %w", err))
+			}
+			files = append(files, parser.File{Name: name, Program: program})
+		}
+		program := files[0].Program
+		if len(files) > 1 {
+			var err error
+			if program, err = parser.Merge(files); err != nil {
+				fail(&stageError{"semantic", "", err})
+			}
+			opts.Sources = sources
+		}
+		opts.SourceFile = files[0].Name
+		opts.Source = sources[files[0].Name]
+
+		var dot bytes.Buffer
+		if *callgraph {
+			writeCallGraphDOT(&dot, analysis.BuildCallGraph(program))
+		} else {
+			ir, err := codegen.NewWithOptions(opts).Generate(program)
+			if err != nil {
+				fail(codegenError(files[0].Name, err))
+			}
+			blocks, err := functionBlocks(ir, *cfg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(exitUsage)
+			}
+			writeCFGDOT(&dot, *cfg, blocks)
+		}
+
+		err := writeFile(*output, func(w io.Writer) error {
+			if *format == "dot" {
+				_, err := w.Write(dot.Bytes())
+				return err
+			}
+			cmd := exec.Command("dot", "-Tsvg")
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = &dot, w, os.Stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("running dot: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing graph: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+}
+
+// cfgBlock is a basic block of the IR of a function.
+type cfgBlock struct {
+	name   string
+	lines  []string // its instructions and terminator
+	succs  []string // the blocks it can branch to
+	leaves string   // how it leaves the function when it has no successors, e.g. ret
+}
+
+// functionBlocks returns the basic blocks of the named function in ir,
+// the text of a module.
+func functionBlocks(ir, name string) ([]cfgBlock, error) {
+	module, err := asm.ParseString(name+".ll", ir)
+	if err != nil {
+		return nil, fmt.Errorf("reading the IR: %v", err)
+	}
+	for _, fn := range module.Funcs {
+		if fn.Name() != name {
+			continue
+		}
+		if len(fn.Blocks) == 0 {
+			return nil, fmt.Errorf("%s is only declared", name)
+		}
+		var blocks []cfgBlock
+		for _, block := range fn.Blocks {
+			b := cfgBlock{name: block.Ident()}
+			for _, inst := range block.Insts {
+				b.lines = append(b.lines, inst.LLString())
+			}
+			b.lines = append(b.lines, block.Term.LLString())
+			for _, succ := range block.Term.Succs() {
+				b.succs = append(b.succs, succ.Ident())
+			}
+			if len(b.succs) == 0 {
+				b.leaves = strings.Fields(block.Term.LLString())[0]
+			}
+			blocks = append(blocks, b)
+		}
+		return blocks, nil
+	}
+	return nil, fmt.Errorf("no function %s", name)
+}
+
+// dotEscaper escapes text for a DOT string.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote returns s as a DOT string.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// writeCFGDOT writes the blocks of the function called name to w as a
+// DOT digraph, each block labelled with its instructions.
+func writeCFGDOT(w io.Writer, name string, blocks []cfgBlock) {
+	fmt.Fprintf(w, "digraph %s {\n", dotQuote("CFG of "+name))
+	fmt.Fprintf(w, "\tlabel=%s;\n\tnode [shape=box, fontname=monospace];\n", dotQuote("CFG of "+name))
+	for _, b := range blocks {
+		// \l ends a left-justified line
+		label := dotEscaper.Replace(b.name + ":")
+		for _, line := range b.lines {
+			label += `\l  ` + dotEscaper.Replace(line)
+		}
+		fmt.Fprintf(w, "\t%s [label=\"%s\\l\"];\n", dotQuote(b.name), label)
+	}
+	for _, b := range blocks {
+		for _, succ := range b.succs {
+			fmt.Fprintf(w, "\t%s -> %s;\n", dotQuote(b.name), dotQuote(succ))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// writeCallGraphDOT writes g to w as a DOT digraph, drawing the functions
+// in recursive cycles bold.
+func writeCallGraphDOT(w io.Writer, g *analysis.CallGraph) {
+	recursive := map[string]bool{}
+	for _, cycle := range g.Cycles() {
+		for _, fn := range cycle {
+			recursive[fn] = true
+		}
+	}
+	fmt.Fprintf(w, "digraph \"call graph\" {\n\tnode [shape=box, fontname=monospace];\n")
+	for _, fn := range g.Functions {
+		if recursive[fn] {
+			fmt.Fprintf(w, "\t%s [style=bold];\n", dotQuote(fn))
+		} else {
+			fmt.Fprintf(w, "\t%s;\n", dotQuote(fn))
+		}
+	}
+	for _, fn := range g.Functions {
+		for _, callee := range g.Calls[fn] {
+			fmt.Fprintf(w, "\t%s -> %s;\n", dotQuote(fn), dotQuote(callee))
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/anouar-bakouch/citadel/pkg/sema"
+)
+
+// cmdLSP implements citadel lsp, a language server speaking the Language
+// Server Protocol over the standard input and output. It publishes the
+// errors and findings check reports for each open file as it changes,
+// with a warning in place of the findings while the file has errors, and
+// answers hover, go-to-definition and document symbol requests from the
+// symbol table of the file.
+func cmdLSP() (*flag.FlagSet, func(args []string)) {
+	fs := newFlagSet("lsp", "")
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to check a document for before publishing its errors without the findings (0 for no limit)")
+	applyMetrics := metricsFlag(fs)
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		// The standard output is the protocol's, so logs go to the error
+		applyMetrics(slog.New(logging.NewHandler(os.Stderr, logging.Level(0))))
+		s := &lspServer{in: bufio.NewReader(os.Stdin), out: os.Stdout, docs: map[string]*lspDocument{}, timeout: *timeout}
+		if err := s.serve(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		// The protocol has the server exit with 1 when the client did not
+		// shut it down first
+		if !s.shutdown {
+			os.Exit(exitUsage)
+		}
+	}
+}
+
+// lspServer is the state of citadel lsp: the documents the client has
+// open, by URI.
+type lspServer struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*lspDocument
+	timeout  time.Duration // for checking a document, or 0
+	shutdown bool
+}
+
+// lspMessage is a JSON-RPC request or notification from the client;
+// notifications have no ID.
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// lspError is a JSON-RPC error, with one of the codes the protocol
+// defines.
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+const (
+	lspParseError     = -32700
+	lspInvalidParams  = -32602
+	lspMethodNotFound = -32601
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange     `json:"range"`
+	Severity int          `json:"severity"`
+	Code     string       `json:"code,omitempty"`
+	Source   string       `json:"source"`
+	Message  string       `json:"message"`
+	Related  []lspRelated `json:"relatedInformation,omitempty"`
+}
+
+type lspRelated struct {
+	Location lspLocation `json:"location"`
+	Message  string      `json:"message"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Detail         string              `json:"detail,omitempty"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+// The SymbolKind values of the protocol that citadel uses.
+const (
+	lspFunctionSymbol = 12
+	lspVariableSymbol = 13
+)
+
+// lspTextDocumentPosition is the parameters of hover and definition
+// requests.
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+// serve answers the messages of the client until it sends exit or closes
+// the standard input.
+func (s *lspServer) serve() error {
+	for {
+		body, err := readLSPMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.reply(json.RawMessage("null"), nil, &lspError{lspParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(msg)
+		var rerr *lspError
+		if err != nil && !errors.As(err, &rerr) {
+			return err
+		}
+		if msg.ID == nil {
+			continue
+		}
+		if err := s.reply(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+// handle carries out msg and returns the result of a request, or an
+// *lspError to answer it with; other errors stop the server.
+// Notifications it does not know are ignored, as the protocol says.
+func (s *lspServer) handle(msg lspMessage) (interface{}, error) {
+	var params struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// Each change sends the whole text
+				"textDocumentSync":       map[string]interface{}{"openClose": true, "change": 1},
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "citadel", "version": codegen.Version},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		return nil, s.update(uri, params.TextDocument.Text)
+	case "textDocument/didChange":
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(uri, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return nil, s.publish(uri, []lspDiagnostic{})
+	case "textDocument/hover":
+		return s.hover(msg.Params)
+	case "textDocument/definition":
+		return s.definition(msg.Params)
+	case "textDocument/documentSymbol":
+		if doc := s.docs[uri]; doc != nil {
+			return doc.documentSymbols(), nil
+		}
+		return []lspDocumentSymbol{}, nil
+	}
+	if msg.ID != nil {
+		return nil, &lspError{lspMethodNotFound, "method not supported: " + msg.Method}
+	}
+	return nil, nil
+}
+
+// update checks the new text of the document at uri and publishes its
+// diagnostics.
+func (s *lspServer) update(uri, text string) error {
+	doc := newLSPDocument(uri, text)
+	s.docs[uri] = doc
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	return s.publish(uri, doc.diagnostics(ctx))
+}
+
+// publish sends the diagnostics of the document at uri to the client.
+func (s *lspServer) publish(uri string, diags []lspDiagnostic) error {
+	return s.write(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/publishDiagnostics",
+		"params":  map[string]interface{}{"uri": uri, "diagnostics": diags},
+	})
+}
+
+// hover returns the declaration of the name under the cursor.
+func (s *lspServer) hover(raw json.RawMessage) (interface{}, error) {
+	doc, ref, err := s.refAt(raw)
+	if err != nil || ref == nil {
+		return nil, err
+	}
+	var value string
+	switch {
+	case ref.sym != nil && ref.sym.kind == "function":
+		value = fmt.Sprintf("```c\n%s\n```\nfunction", ref.sym.decl)
+	case ref.sym != nil:
+		value = fmt.Sprintf("```c\n%s\n```\n%s in %s", ref.sym.decl, ref.sym.kind, ref.sym.fn.Name)
+	case codegen.LookupLibc(ref.name) != nil:
+		value = fmt.Sprintf("`%s`: C library function", ref.name)
+	default:
+		return nil, nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": value},
+		"range":    doc.nameRange(ref.pos, ref.name),
+	}, nil
+}
+
+// definition returns where the name under the cursor is declared.
+func (s *lspServer) definition(raw json.RawMessage) (interface{}, error) {
+	doc, ref, err := s.refAt(raw)
+	if err != nil || ref == nil || ref.sym == nil {
+		return nil, err
+	}
+	return lspLocation{doc.uri, doc.nameRange(ref.sym.pos, ref.sym.name)}, nil
+}
+
+// refAt returns the document a hover or definition request is about and
+// the name under its cursor, or a nil reference when there is none or
+// the document does not parse.
+func (s *lspServer) refAt(raw json.RawMessage) (*lspDocument, *symbolRef, error) {
+	var params lspTextDocumentPosition
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, nil, &lspError{lspInvalidParams, err.Error()}
+	}
+	doc := s.docs[params.TextDocument.URI]
+	if doc == nil || doc.symbols == nil {
+		return nil, nil, nil
+	}
+	return doc, doc.symbols.refAt(doc.fromLSP(params.Position)), nil
+}
+
+// reply sends the response to the request with the given ID.
+func (s *lspServer) reply(id json.RawMessage, result interface{}, rerr *lspError) error {
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	if rerr != nil {
+		response["error"] = rerr
+	} else {
+		response["result"] = result
+	}
+	return s.write(response)
+}
+
+// write sends msg to the client with the header the protocol frames
+// messages with.
+func (s *lspServer) write(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// readLSPMessage reads the body of the next message from r, skipping the
+// headers other than Content-Length.
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" && length < 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading a message header: %v", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil || length < 0 {
+				return nil, fmt.Errorf("bad Content-Length: %s", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without a Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading a message: %v", err)
+	}
+	return body, nil
+}
+
+// lspDocument is an open file: its text, and its symbols if it parses.
+type lspDocument struct {
+	uri     string
+	name    string // the path of the file, or the URI if it is not a file
+	text    string
+	lines   []string
+	symbols *symbolTable
+}
+
+func newLSPDocument(uri, text string) *lspDocument {
+	name := uri
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		name = filepath.FromSlash(u.Path)
+	}
+	return &lspDocument{uri: uri, name: name, text: text, lines: strings.Split(text, "\n")}
+}
+
+// diagnostics returns the errors and findings check would report for the
+// document, as check -diagnostics-format json does, and records its
+// symbols if it parses. Once ctx is done, it returns the errors found so
+// far and a warning that checking stopped.
+func (d *lspDocument) diagnostics(ctx context.Context) []lspDiagnostic {
+	var failed error
+	var findings []analysis.Finding
+	defer func() { serviceMetrics.file("lsp", failed, findings) }()
+	bag := &diag.Bag{}
+	bag.AddLexerErrors(d.name, d.text)
+	stopped := func() bool {
+		if ctx.Err() == nil {
+			return false
+		}
+		failed = ctx.Err()
+		bag.Add(diag.Diagnostic{File: d.name, Severity: diag.Warning, Stage: "io",
+			Message: "checking took too long and stopped, so some errors and findings may be missing; citadel check reports them all"})
+		return true
+	}
+	// The analysis passes need a program that checks, so a file with
+	// errors has no findings until they are fixed, which is said rather
+	// than publishing no findings as if there were none
+	suppressed := func() {
+		bag.Add(diag.Diagnostic{File: d.name, Severity: diag.Warning, Stage: "analysis",
+			Message: "security findings are not reported until the errors in this file are fixed"})
+	}
+	lex, program, err := parse(d.name, d.text, parser.WithContext(ctx))
+	if stopped() {
+		return d.toLSPDiagnostics(bag.Diagnostics())
+	}
+	if err != nil {
+		failed = err
+		bag.AddError("parser", d.name, err)
+		suppressed()
+		return d.toLSPDiagnostics(bag.Diagnostics())
+	}
+	d.symbols = buildSymbols(program)
+	opts := codegen.Options{SourceFile: d.name, Source: d.text, Context: ctx}
+	err = sema.Check(program, opts)
+	if stopped() {
+		return d.toLSPDiagnostics(bag.Diagnostics())
+	}
+	if err != nil {
+		failed = err
+		bag.AddError("semantic", d.name, err)
+		suppressed()
+		return d.toLSPDiagnostics(bag.Diagnostics())
+	}
+	findings, err = defaultFindings(ctx, d.name, program, d.text, lex, "", logging.Discard, nil)
+	if stopped() {
+		return d.toLSPDiagnostics(bag.Diagnostics())
+	}
+	if err != nil {
+		failed = err
+		bag.AddError("analysis", d.name, err)
+	}
+	bag.Add(findingDiagnostics(d.name, findings, false)...)
+	return d.toLSPDiagnostics(bag.Diagnostics())
+}
+
+// toLSPDiagnostics converts diags, about the document, to the protocol's
+// diagnostics; notes on the document become related information.
+func (d *lspDocument) toLSPDiagnostics(diags []diag.Diagnostic) []lspDiagnostic {
+	converted := []lspDiagnostic{}
+	for _, dg := range diags {
+		c := lspDiagnostic{Severity: lspSeverity(dg.Severity), Code: dg.Rule, Source: "citadel", Message: dg.Message}
+		if dg.Pos.Line > 0 {
+			end := dg.End
+			if end.Line == 0 || end.Line == dg.Pos.Line && end.Column <= dg.Pos.Column {
+				end = d.tokenEnd(dg.Pos)
+			}
+			c.Range = lspRange{d.toLSP(dg.Pos), d.toLSP(end)}
+		}
+		for _, note := range dg.Notes {
+			if note.File != "" && note.File != d.name {
+				continue
+			}
+			c.Related = append(c.Related, lspRelated{lspLocation{d.uri, lspRange{d.toLSP(note.Pos), d.toLSP(note.Pos)}}, note.Message})
+		}
+		converted = append(converted, c)
+	}
+	return converted
+}
+
+// lspSeverity returns the DiagnosticSeverity of the protocol for the
+// severity of an error or finding: errors and high or critical findings
+// are errors, warnings and medium findings warnings, low findings
+// information and info ones hints.
+func lspSeverity(severity string) int {
+	switch severity {
+	case "medium", "warning":
+		return 2
+	case "low":
+		return 3
+	case "info":
+		return 4
+	}
+	return 1
+}
+
+// tokenEnd returns where the token at pos ends, so that an error known
+// only by where it starts still underlines the token it starts at; it is
+// pos if no token starts there.
+func (d *lspDocument) tokenEnd(pos lexer.Position) lexer.Position {
+	if pos.Line > len(d.lines) || pos.Column < 1 || pos.Column > len(d.lines[pos.Line-1]) {
+		return pos
+	}
+	tok := lexer.New(d.lines[pos.Line-1][pos.Column-1:]).NextToken()
+	if tok.Type == lexer.EOF || tok.Pos.Column != 1 {
+		return pos
+	}
+	end := pos
+	end.Column += tok.End().Column - 1
+	return end
+}
+
+// nameRange returns the range of name, written at pos.
+func (d *lspDocument) nameRange(pos lexer.Position, name string) lspRange {
+	end := pos
+	end.Column += len(name)
+	return lspRange{d.toLSP(pos), d.toLSP(end)}
+}
+
+// toLSP returns pos, with a line and a column of bytes counted from 1, as
+// a position of the protocol, with a line and a column of UTF-16 code
+// units counted from 0.
+func (d *lspDocument) toLSP(pos lexer.Position) lspPosition {
+	if pos.Line < 1 || pos.Line > len(d.lines) {
+		return lspPosition{}
+	}
+	line := d.lines[pos.Line-1]
+	column := pos.Column - 1
+	if column < 0 {
+		column = 0
+	}
+	if column > len(line) {
+		column = len(line)
+	}
+	units := 0
+	for _, r := range line[:column] {
+		units += utf16Len(r)
+	}
+	return lspPosition{pos.Line - 1, units}
+}
+
+// fromLSP returns the source position of p, a position of the protocol.
+func (d *lspDocument) fromLSP(p lspPosition) lexer.Position {
+	if p.Line < 0 || p.Line >= len(d.lines) {
+		return lexer.Position{}
+	}
+	units := 0
+	for i, r := range d.lines[p.Line] {
+		if units >= p.Character {
+			return lexer.Position{Line: p.Line + 1, Column: i + 1}
+		}
+		units += utf16Len(r)
+	}
+	return lexer.Position{Line: p.Line + 1, Column: len(d.lines[p.Line]) + 1}
+}
+
+// utf16Len returns the number of UTF-16 code units that encode r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// documentSymbols returns the functions of the document, each with its
+// parameters and locals.
+func (d *lspDocument) documentSymbols() []lspDocumentSymbol {
+	symbols := []lspDocumentSymbol{}
+	if d.symbols == nil {
+		return symbols
+	}
+	for _, sym := range d.symbols.symbols {
+		if sym.kind != "function" {
+			continue
+		}
+		fn := sym.fn
+		name := d.nameRange(fn.NamePos, fn.Name)
+		whole := lspRange{d.toLSP(fn.Pos), name.End}
+		if fn.Body != nil {
+			end := fn.Body.End
+			end.Column++
+			whole.End = d.toLSP(end)
+		}
+		s := lspDocumentSymbol{Name: fn.Name, Detail: sym.decl, Kind: lspFunctionSymbol, Range: whole, SelectionRange: name}
+		for _, local := range d.symbols.symbols {
+			if local.fn == fn && local.kind != "function" {
+				r := d.nameRange(local.pos, local.name)
+				s.Children = append(s.Children, lspDocumentSymbol{Name: local.name, Detail: local.decl, Kind: lspVariableSymbol, Range: r, SelectionRange: r})
+			}
+		}
+		symbols = append(symbols, s)
+	}
+	return symbols
+}
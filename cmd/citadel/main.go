@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// Exit statuses, by which scripts can tell failures apart.
+const (
+	exitOK = 0
+	// exitUsage is for a bad command line, and for failures with no
+	// status of their own, such as an input that cannot be read
+	exitUsage    = 1
+	exitParse    = 2 // an input does not lex or parse
+	exitSemantic = 3 // the program is invalid, e.g. uses an undefined variable
+	exitCodegen  = 4 // generating, assembling or compiling the IR failed, though the program is valid
+	exitFindings = 5 // check reported findings at or above -fail-on
+	exitDiverged = 6 // difftest found runs where citadel's build and the reference differ
+)
+
+// exitCode returns the exit status for err, by the step of the pipeline
+// it stopped.
+func exitCode(err error) int {
+	var serr *stageError
+	if !errors.As(err, &serr) {
+		return exitUsage
+	}
+	switch serr.stage {
+	case "lexer", "parser":
+		return exitParse
+	case "semantic":
+		return exitSemantic
+	case "codegen":
+		return exitCodegen
+	}
+	return exitUsage
+}
+
+// command is a subcommand of the CLI. Its flags make the flag set of
+// the command, with the function that parses a command line with it and
+// runs the command; the completion scripts list the flags without
+// running anything.
+type command struct {
+	name    string
+	summary string
+	flags   func() (*flag.FlagSet, func(args []string))
+}
+
+var commands = []command{
+	{"compile", "generate LLVM IR, bitcode, assembly or an object file from C files", cmdCompile},
+	{"run", "compile C files to a temporary executable and run it", cmdRun},
+	{"check", "report security weaknesses in a C file", cmdCheck},
+	{"tokens", "print the tokens of a C file", cmdTokens},
+	{"ast", "print the syntax tree of a C file", cmdAST},
+	{"fmt", "reformat C files in the standard layout", cmdFmt},
+	{"test", "compare the IR and findings of C files with their golden files", cmdTest},
+	{"difftest", "run a C file built by citadel and by clang on generated inputs and report where they behave differently", cmdDifftest},
+	{"repl", "compile definitions and expressions interactively", cmdREPL},
+	{"bench", "time lexing, parsing, checking, analysis and code generation of a C file", cmdBench},
+	{"graph", "write the control-flow graph of a function or the call graph as DOT or SVG", cmdGraph},
+	{"lsp", "serve diagnostics, hover, definitions and symbols to editors over the Language Server Protocol", cmdLSP},
+	{"version", "print the version, the C subset and the rules, for bug reports", cmdVersion},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
+		return
+	}
+	if name == "-version" || name == "--version" {
+		name = "version"
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			_, run := cmd.flags()
+			run(os.Args[2:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n", name)
+	usage()
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] <input.c>\n\nAn input of - is read from the standard input.\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(os.Stderr, "\nExit status: 0 success, 1 usage or I/O error, 2 lex or parse error, 3 semantic error,\n4 code generation error, 5 findings at or above -fail-on, 6 difftest divergence. run exits with the status of the program.\n")
+}
+
+// newFlagSet returns the flag set of the named command, whose usage
+// message names the command and its arguments.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags] %s\n", filepath.Base(os.Args[0]), name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// setFlags returns the names of the flags of fs that have been set, by
+// the command line or since.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// parseFlags parses args with fs, exiting with exitUsage if they are
+// wrong; flag.ExitOnError would exit with 2, which is exitParse here.
+// Flags may follow the inputs, as in compile a.c b.c -o out.ll, up to a
+// -- after which every argument is an input.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	var inputs []string
+	for {
+		switch err := fs.Parse(args); {
+		case err == flag.ErrHelp:
+			os.Exit(exitOK)
+		case err != nil:
+			os.Exit(exitUsage)
+		}
+		rest := fs.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			inputs = append(inputs, rest...)
+			break
+		}
+		n := 0
+		for n < len(rest) && (rest[n] == "-" || !strings.HasPrefix(rest[n], "-")) {
+			n++
+		}
+		inputs = append(inputs, rest[:n]...)
+		if n == len(rest) {
+			break
+		}
+		args = rest[n:]
+	}
+	// Leave the inputs as the arguments of fs
+	fs.Parse(append([]string{"--"}, inputs...))
+}
+
+// verbosityFlags registers -v, -vv and -quiet on fs, and returns a
+// function that makes the logger they select once the flags are parsed.
+// It writes progress and warnings to the standard error, and with -v and
+// -vv timings, decisions and traces of the passes; the requested output
+// and errors are written whatever the verbosity.
+func verbosityFlags(fs *flag.FlagSet) func() *slog.Logger {
+	v := fs.Bool("v", false, "also log timings and decisions, such as the analysis passes run and the tools used")
+	vv := fs.Bool("vv", false, "also trace each function generated and what each optimization pass changes in it")
+	quiet := fs.Bool("quiet", false, "log nothing but errors: no progress or warnings")
+	return func() *slog.Logger {
+		verbosity := 0
+		switch {
+		case *quiet:
+			verbosity = -1
+		case *vv:
+			verbosity = 2
+		case *v:
+			verbosity = 1
+		}
+		return slog.New(logging.NewHandler(os.Stderr, logging.Level(verbosity)))
+	}
+}
+
+// inputArg returns the single input file the command line of fs names,
+// exiting with its usage message if there is not exactly one.
+func inputArg(fs *flag.FlagSet) string {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	return fs.Arg(0)
+}
+
+// instrumentationFlags registers the flags for the run-time checks and
+// hardening that codegen can add to fs, and returns the -fsanitize list,
+// which setSanitizers applies once the flags are parsed.
+func instrumentationFlags(fs *flag.FlagSet, opts *codegen.Options) *string {
+	fs.BoolVar(&opts.SafeStack, "safestack", false, "emit functions with the safestack attribute")
+	fs.BoolVar(&opts.ShadowCallStack, "shadow-call-stack", false, "emit functions with the shadowcallstack attribute (aarch64 and riscv64 only)")
+	fs.StringVar(&opts.StackProtector, "stack-protector", "", "guard stack frames with a canary: ssp in functions with character arrays, sspstrong in those with any array or address-taken local, sspreq in all")
+	fs.BoolVar(&opts.CFI, "cfi", false, "guard indirect calls with llvm.type.test control-flow integrity checks")
+	fs.BoolVar(&opts.BoundsChecks, "bounds-checks", false, "trap on out-of-range indexes into local arrays")
+	fs.BoolVar(&opts.OverflowChecks, "overflow-checks", false, "trap on signed integer overflow in +, - and *")
+	fs.BoolVar(&opts.DivisionChecks, "div-checks", false, "trap on division by zero and INT_MIN / -1")
+	return fs.String("fsanitize", "", "comma-separated sanitizers to enable (address, memory, thread)")
+}
+
+// setSanitizers enables the sanitizers a -fsanitize list names, and
+// checks the -stack-protector the other instrumentation flags gave opts.
+func setSanitizers(list string, opts *codegen.Options) {
+	validSSP := opts.StackProtector == ""
+	for _, ssp := range codegen.StackProtectors {
+		validSSP = validSSP || opts.StackProtector == ssp
+	}
+	if !validSSP {
+		fmt.Fprintf(os.Stderr, "Unknown stack protector: %s\n", opts.StackProtector)
+		os.Exit(1)
+	}
+	for _, san := range strings.Split(list, ",") {
+		switch san {
+		case "":
+		case "address":
+			opts.SanitizeAddress = true
+		case "memory":
+			opts.SanitizeMemory = true
+		case "thread":
+			opts.SanitizeThread = true
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown sanitizer: %s\n", san)
+			os.Exit(1)
+		}
+	}
+}
+
+// readInput returns the contents of the file at path, or of the standard
+// input if path is "-", exiting if it cannot be read.
+func readInput(path string) string {
+	input, err := readSource(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+		os.Exit(1)
+	}
+	return input
+}
+
+// readSource returns the contents of the file at path, or of the standard
+// input if path is "-".
+func readSource(path string) (string, error) {
+	r, err := openSource(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	input, err := io.ReadAll(r)
+	return string(input), err
+}
+
+// openSource opens the file at path, or the standard input if path is
+// "-".
+func openSource(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// plural returns n and noun, which has an s added unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// sourceName returns the name messages give the input file at path.
+func sourceName(path string) string {
+	if path == "-" {
+		return "<stdin>"
+	}
+	return path
+}
+
+// parseFile reads and parses the C file at path, or the standard input
+// if path is "-", exiting if either fails.
+func parseFile(path string) *parser.File {
+	r, err := openSource(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+		os.Exit(1)
+	}
+	file, err := parser.ParseFile(nil, sourceName(path), r)
+	r.Close()
+	if file == nil {
+		fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		newErrorRenderer(useColor("auto"), map[string]string{file.Name: file.Source}).write(os.Stderr, err)
+		os.Exit(exitParse)
+	}
+	return file
+}
+
+// parse parses input, the source of the named file, with the parser
+// options opts, and returns the lexer that read it and the program. Its
+// error is a *parser.Error naming the file, or a parser.ErrorList of them
+// with parser.WithMaxErrors. With -frontend=clang,
+// clangFrontend parses input instead.
+func parse(name, input string, opts ...parser.Option) (*lexer.Lexer, *ast.Program, error) {
+	if clangFrontend != nil {
+		return clangFrontend.parse(context.Background(), name, input)
+	}
+	lex := lexer.New(input)
+	program, err := parser.New(lex, opts...).ParseProgram()
+	switch perr := err.(type) {
+	case *parser.Error:
+		perr.File = name
+	case parser.ErrorList:
+		for _, e := range perr {
+			e.File = name
+		}
+	}
+	return lex, program, err
+}
+
+// expandInputs returns the C files the arguments name: files as given,
+// the .c files in directories, the .c files anywhere below a directory
+// followed by /..., and the files glob patterns match. The result is
+// sorted, with each file once; "-" stays as it is.
+func expandInputs(args []string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	for _, arg := range args {
+		switch {
+		case arg == "-":
+			add(arg)
+		case arg == "..." || strings.HasSuffix(arg, "/..."):
+			root := strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/")
+			if root == "" {
+				root = "."
+			}
+			err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() && filepath.Ext(path) == ".c" {
+					add(path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		case strings.ContainsAny(arg, "*?["):
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", arg, err)
+			}
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && !info.IsDir() {
+					add(match)
+				}
+			}
+		default:
+			info, err := os.Stat(arg)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(arg)
+				continue
+			}
+			matches, err := filepath.Glob(filepath.Join(arg, "*.c"))
+			if err != nil {
+				return nil, err
+			}
+			for _, match := range matches {
+				add(match)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// generateFile streams the textual IR of program to path, or to the
+// standard output if path is "-", removing the partial file if generation
+// fails.
+func generateFile(gen codegen.Backend, program *ast.Program, path string) error {
+	if path == "-" {
+		return gen.GenerateTo(os.Stdout, program)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = gen.GenerateTo(f, program)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// writeFile creates the file at path and writes its contents with write,
+// or writes to the standard output if path is "-".
+func writeFile(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
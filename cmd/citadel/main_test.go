@@ -0,0 +1,690 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// TestMain runs the command itself when the test binary is started again
+// by citadel, so that tests can check its output and exit status.
+func TestMain(m *testing.M) {
+	if os.Getenv("CITADEL_TEST_MAIN") == "1" {
+		os.Args = append([]string{"citadel"}, os.Args[1:]...)
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+// runCitadel runs the command with args in dir and returns what it wrote to
+// the standard error and its exit status.
+func runCitadel(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	_, stderr, status := runCitadelInput(t, dir, "", args...)
+	return stderr, status
+}
+
+// runCitadelInput runs the command with args in dir, with stdin as its
+// standard input, and returns what it wrote to the standard output and
+// error and its exit status.
+func runCitadelInput(t *testing.T, dir, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "CITADEL_TEST_MAIN=1", "NO_COLOR=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return stdout.String(), stderr.String(), exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), exitOK
+}
+
+// writeFiles writes each source to the file of its name in a temporary
+// directory, which it returns.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestExitStatus checks that compile exits with the status of the step
+// that failed, telling errors in the program from those of the code
+// generator
+func TestExitStatus(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ok.c":        "int main() { return 0; }\n",
+		"parse.c":     "int main( { return 0; }\n",
+		"undefined.c": "int main() { return y; }\n",
+	})
+	for _, test := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"compile", "-o", "ok.ll", "ok.c"}, exitOK},
+		{[]string{"compile", "-o", "parse.ll", "parse.c"}, exitParse},
+		{[]string{"compile", "-o", "undefined.ll", "undefined.c"}, exitSemantic},
+		{[]string{"compile", "-backend=llir", "-O1", "-o", "ok.ll", "ok.c"}, exitCodegen},
+		{[]string{"compile", "-target", "wasm32-unknown-unknown", "-safestack", "-o", "ok.ll", "ok.c"}, exitCodegen},
+	} {
+		if stderr, got := runCitadel(t, dir, test.args...); got != test.want {
+			t.Errorf("citadel %v: exit status %d, want %d\n%s", test.args, got, test.want, stderr)
+		}
+	}
+}
+
+// TestFlagsAfterInputs checks that flags may follow the inputs, and that
+// every argument after -- is an input
+func TestFlagsAfterInputs(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.c": "int helper(int x);\nint main() { return helper(2); }\n",
+		"b.c": "int helper(int x) { return x * 2; }\n",
+	})
+	if stderr, status := runCitadel(t, dir, "compile", "a.c", "b.c", "-o", "out.ll", "-O1"); status != exitOK {
+		t.Fatalf("compile a.c b.c -o out.ll -O1: exit status %d\n%s", status, stderr)
+	}
+	ir, err := os.ReadFile(filepath.Join(dir, "out.ll"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"define dso_local i32 @main()", "define dso_local i32 @helper(i32 %x)"} {
+		if !bytes.Contains(ir, []byte(want)) {
+			t.Errorf("out.ll has no %s:\n%s", want, ir)
+		}
+	}
+
+	if stderr, status := runCitadel(t, dir, "compile", "-o", "out.ll", "--", "a.c", "-O1"); status != exitUsage || !strings.Contains(stderr, "-O1") {
+		t.Errorf("compile -o out.ll -- a.c -O1: exit status %d, want %d for the input -O1\n%s", status, exitUsage, stderr)
+	}
+}
+
+// TestCompilationDBClangFlags checks that under -frontend clang each file
+// is parsed with the include paths and defines of its compile command,
+// after those of -clang-flags
+func TestCompilationDBClangFlags(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"proj/src/a.c": "int main() { return 0; }\n",
+		// clang as far as citadel can tell: it records its arguments and
+		// dumps an empty translation unit
+		"clang": "#!/bin/sh\necho \"$@\" > \"$(dirname \"$0\")/args\"\necho '{\"kind\":\"TranslationUnitDecl\",\"inner\":[]}'\n",
+	})
+	if err := os.Chmod(filepath.Join(dir, "clang"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLANG", filepath.Join(dir, "clang"))
+	proj := filepath.ToSlash(filepath.Join(dir, "proj"))
+	db := `[{"directory": "` + proj + `", "file": "src/a.c", "arguments": ["cc", "-Iinclude", "-D", "FOO=1", "-UBAR", "-c", "src/a.c"]}]`
+	if err := os.WriteFile(filepath.Join(dir, "proj", "compile_commands.json"), []byte(db), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stderr, status := runCitadel(t, dir, "check", "-frontend", "clang", "-clang-flags", "-std=c99", "-compilation-db", "proj/compile_commands.json")
+	if status != exitOK {
+		t.Fatalf("exit status %d\n%s", status, stderr)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-std=c99 -I" + filepath.Join(dir, "proj", "include") + " -DFOO=1 -UBAR " + filepath.Join("proj", "src", "a.c")
+	if !strings.HasSuffix(strings.TrimSpace(string(args)), want) {
+		t.Errorf("clang ran with\n%s\nwant it to end with\n%s", args, want)
+	}
+}
+
+// TestCompletion checks that the completion scripts offer the flags of
+// each command, with the values of those that take a few, and that
+// listing them runs no command
+func TestCompletion(t *testing.T) {
+	dir := t.TempDir()
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		cmd := exec.Command(os.Args[0], "completion", shell)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "CITADEL_TEST_MAIN=1")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("completion %s: %v\n%s", shell, err, stderr.String())
+		}
+		for _, want := range []string{"diagnostics-format", "shadow-call-stack", "fail-on", "wasm32-unknown-unknown", "sspstrong"} {
+			if !bytes.Contains(out, []byte(want)) {
+				t.Errorf("completion %s does not offer %s", shell, want)
+			}
+		}
+		if stderr.Len() > 0 {
+			t.Errorf("completion %s wrote to the standard error:\n%s", shell, stderr.String())
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("completion left files behind: %v", entries)
+	}
+}
+
+// TestStaleCompilationDB checks that a file of the compilation database
+// that no longer exists fails on its own, and the others are still checked
+func TestStaleCompilationDB(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"proj/src/ok.c": "int main() { char buf[4]; gets(buf); return 0; }\n",
+	})
+	proj := filepath.ToSlash(filepath.Join(dir, "proj"))
+	db := `[{"directory": "` + proj + `", "file": "src/ok.c", "command": "cc -c src/ok.c"},
+{"directory": "` + proj + `", "file": "src/missing.c", "command": "cc -c src/missing.c"}]`
+	if err := os.WriteFile(filepath.Join(dir, "proj", "compile_commands.json"), []byte(db), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stderr, status := runCitadel(t, dir, "check", "-compilation-db", "proj/compile_commands.json", "-json", "findings.json")
+	missing := filepath.Join("proj", "src", "missing.c")
+	if status != exitUsage || !strings.Contains(stderr, missing+" is in") {
+		t.Errorf("exit status %d, want %d with an error for %s:\n%s", status, exitUsage, missing, stderr)
+	}
+	findings, err := os.ReadFile(filepath.Join(dir, "findings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(findings, []byte("gets")) {
+		t.Errorf("proj/src/ok.c was not checked:\n%s", findings)
+	}
+}
+
+// TestLSPDiagnostics checks that the language server underlines the
+// identifier a semantic error is about, and says that the findings are
+// not reported while the file has errors
+func TestLSPDiagnostics(t *testing.T) {
+	doc := newLSPDocument("file:///a.c", "int main() {\n    char buf[4];\n    gets(buf);\n    return cuont + 2;\n}\n")
+	diags := doc.diagnostics(context.Background())
+	if len(diags) != 2 {
+		t.Fatalf("got %d diagnostics, want the error and the note on findings: %+v", len(diags), diags)
+	}
+	want := lspRange{lspPosition{3, 11}, lspPosition{3, 16}}
+	if diags[0].Range != want || !strings.Contains(diags[0].Message, "cuont") {
+		t.Errorf("error %q at %+v, want cuont at %+v", diags[0].Message, diags[0].Range, want)
+	}
+	if !strings.Contains(diags[1].Message, "findings are not reported") {
+		t.Errorf("got %q, want the note that findings are not reported", diags[1].Message)
+	}
+}
+
+// TestPresetOverProject checks that a preset on the command line wins over
+// the target of the project file, and the command line over the preset
+func TestPresetOverProject(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		".citadel.toml": "target = \"wasm32-unknown-unknown\"\n",
+		"ok.c":          "int main() { return 0; }\n",
+	})
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"compile", "-o", "out.ll", "ok.c"}, "wasm32-unknown-unknown"},
+		{[]string{"compile", "-preset", "linux-arm64", "-o", "out.ll", "ok.c"}, "aarch64-unknown-linux-gnu"},
+		{[]string{"compile", "-preset", "linux-arm64", "-target", "riscv64-unknown-elf", "-o", "out.ll", "ok.c"}, "riscv64-unknown-elf"},
+	} {
+		if stderr, status := runCitadel(t, dir, test.args...); status != exitOK {
+			t.Fatalf("citadel %v: exit status %d\n%s", test.args, status, stderr)
+		}
+		ir, err := os.ReadFile(filepath.Join(dir, "out.ll"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `target triple = "` + test.want + `"`; !bytes.Contains(ir, []byte(want)) {
+			t.Errorf("citadel %v: the IR has no %s", test.args, want)
+		}
+	}
+}
+
+// TestDiagnosticsFormat checks that compile and check both take
+// -diagnostics-format, that check keeps -format as its old name, and that
+// either given on the command line wins over the project file
+func TestDiagnosticsFormat(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		".citadel.toml": "[output]\ndiagnostics = \"text\"\n",
+		"broken.c":      "int main() { return 1 +; }\n",
+	})
+	for _, args := range [][]string{
+		{"compile", "-diagnostics-format", "json", "-o", "out.ll", "broken.c"},
+		{"check", "-diagnostics-format", "json", "broken.c"},
+		{"check", "-format", "json", "broken.c"},
+	} {
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "CITADEL_TEST_MAIN=1", "NO_COLOR=1")
+		out, _ := cmd.Output()
+		var report struct {
+			Diagnostics []struct{ Source string }
+		}
+		if err := json.Unmarshal(out, &report); err != nil || len(report.Diagnostics) == 0 || report.Diagnostics[0].Source != "parser" {
+			t.Errorf("citadel %v: got %s, want the parse error as JSON", args, out)
+		}
+	}
+}
+
+// TestMaxErrors checks that check reports the parse errors of several
+// functions, up to -max-errors
+func TestMaxErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"broken.c": "int f( { return 0; }\nint g() { int = 1; return 0; }\nint h() { return (1; }\n",
+	})
+	for _, test := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"check", "broken.c"}, 3},
+		{[]string{"check", "-max-errors", "2", "broken.c"}, 2},
+	} {
+		stderr, status := runCitadel(t, dir, test.args...)
+		if got := strings.Count(stderr, "error:"); status != exitParse || got != test.want {
+			t.Errorf("citadel %v: exit status %d with %d errors, want %d with %d\n%s", test.args, status, got, exitParse, test.want, stderr)
+		}
+	}
+}
+
+// TestWriteDiff checks that writeDiff labels the sides of the diff as it
+// is told, for fmt and test to tell theirs apart.
+func TestWriteDiff(t *testing.T) {
+	var out bytes.Buffer
+	writeDiff(&out, "x.ll.golden", "golden", "actual", "a\nb\n", "a\nc\n")
+	want := "--- x.ll.golden (golden)\n+++ x.ll.golden (actual)\n"
+	if !strings.HasPrefix(out.String(), want) || !strings.Contains(out.String(), "-b\n+c\n") {
+		t.Errorf("got\n%s\nwant a diff starting\n%s", out.String(), want)
+	}
+}
+
+// TestLSPSession checks a session with the language server: the answer to
+// initialize, the diagnostics published for an opened document, hover and
+// go to definition on a use of a local, its document symbols, the error
+// for a method it does not support, and shutdown
+func TestLSPSession(t *testing.T) {
+	const uri = "file:///a.c"
+	const text = "int main() {\n    char buf[4];\n    int n = 3;\n    gets(buf);\n    return n;\n}\n"
+	var in bytes.Buffer
+	send := func(id int, method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if id > 0 {
+			msg["id"] = id
+		}
+		body, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	doc := map[string]string{"uri": uri}
+	// The cursor on the n of return n
+	at := map[string]interface{}{"textDocument": doc, "position": lspPosition{4, 11}}
+	send(1, "initialize", map[string]interface{}{})
+	send(0, "textDocument/didOpen", map[string]interface{}{"textDocument": map[string]string{"uri": uri, "text": text}})
+	send(2, "textDocument/hover", at)
+	send(3, "textDocument/definition", at)
+	send(4, "textDocument/documentSymbol", map[string]interface{}{"textDocument": doc})
+	send(5, "textDocument/formatting", map[string]interface{}{"textDocument": doc})
+	send(6, "shutdown", nil)
+	send(0, "exit", nil)
+
+	var out bytes.Buffer
+	s := &lspServer{in: bufio.NewReader(&in), out: &out, docs: map[string]*lspDocument{}}
+	if err := s.serve(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.shutdown {
+		t.Error("not shut down")
+	}
+	type message struct {
+		ID     int             `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Result json.RawMessage `json:"result"`
+		Error  *lspError       `json:"error"`
+	}
+	var msgs []message
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readLSPMessage(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 7 {
+		t.Fatalf("got %d messages, want 7: %+v", len(msgs), msgs)
+	}
+
+	if !strings.Contains(string(msgs[0].Result), `"hoverProvider":true`) {
+		t.Errorf("initialize: %s", msgs[0].Result)
+	}
+	var published struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(msgs[1].Params, &published); err != nil || msgs[1].Method != "textDocument/publishDiagnostics" {
+		t.Fatalf("got %s %s, want the published diagnostics", msgs[1].Method, msgs[1].Params)
+	}
+	found := false
+	for _, d := range published.Diagnostics {
+		found = found || d.Code == "dangerous-call" && d.Range.Start.Line == 3
+	}
+	if published.URI != uri || !found {
+		t.Errorf("published %+v, want the dangerous-call finding on line 4", published)
+	}
+	if !strings.Contains(string(msgs[2].Result), "int n") {
+		t.Errorf("hover: %s, want the declaration of n", msgs[2].Result)
+	}
+	var def lspLocation
+	if err := json.Unmarshal(msgs[3].Result, &def); err != nil || def.URI != uri || def.Range.Start != (lspPosition{2, 8}) {
+		t.Errorf("definition: %s, want n on line 3", msgs[3].Result)
+	}
+	var symbols []lspDocumentSymbol
+	if err := json.Unmarshal(msgs[4].Result, &symbols); err != nil || len(symbols) != 1 || symbols[0].Name != "main" || len(symbols[0].Children) != 2 {
+		t.Errorf("document symbols: %s, want main with buf and n", msgs[4].Result)
+	}
+	if msgs[5].Error == nil || msgs[5].Error.Code != lspMethodNotFound {
+		t.Errorf("formatting: %+v, want method not found", msgs[5].Error)
+	}
+	if msgs[6].ID != 6 || msgs[6].Error != nil {
+		t.Errorf("shutdown: %+v", msgs[6])
+	}
+}
+
+// unformatted is a program fmt lays out again
+const unformatted = "int twice(int x) {\nreturn x+x;\n}\nint main() { int n = twice(3); if (n == 6) { return 0; } return 1; }\n"
+
+// formatted is unformatted as fmt lays it out
+const formatted = "int twice(int x) {\n    return x + x;\n}\n\nint main() {\n    int n = twice(3);\n    if (n == 6) {\n        return 0;\n    }\n    return 1;\n}\n"
+
+// TestFmt checks what fmt prints for a file and the standard input, what
+// -l and -diff print, that -w rewrites only the files that change, and
+// its exit status when an input does not parse or -w has nowhere to write
+func TestFmt(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.c": unformatted, "b.c": formatted})
+	for _, test := range []struct {
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"", []string{"fmt", "a.c"}, formatted},
+		{unformatted, []string{"fmt"}, formatted},
+		{"", []string{"fmt", "-l", "a.c", "b.c"}, "a.c\n"},
+		{"", []string{"fmt", "-diff", "b.c"}, ""},
+	} {
+		stdout, stderr, status := runCitadelInput(t, dir, test.stdin, test.args...)
+		if status != exitOK || stdout != test.want {
+			t.Errorf("citadel %v: exit status %d with\n%s\nwant %d with\n%s\n%s", test.args, status, stdout, exitOK, test.want, stderr)
+		}
+	}
+	stdout, _, status := runCitadelInput(t, dir, "", "fmt", "-diff", "a.c")
+	if status != exitOK || !strings.HasPrefix(stdout, "--- a.c (original)\n+++ a.c (formatted)\n") || !strings.Contains(stdout, "-return x+x;\n+    return x + x;\n") {
+		t.Errorf("fmt -diff a.c: exit status %d with\n%s", status, stdout)
+	}
+
+	if stderr, status := runCitadel(t, dir, "fmt", "-w", "a.c", "b.c"); status != exitOK {
+		t.Fatalf("fmt -w: exit status %d\n%s", status, stderr)
+	}
+	for _, name := range []string{"a.c", "b.c"} {
+		if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(got) != formatted {
+			t.Errorf("fmt -w left %s as\n%s", name, got)
+		}
+	}
+
+	_, stderr, status := runCitadelInput(t, dir, "int main( {", "fmt")
+	if status != exitParse || !strings.Contains(stderr, "<stdin>:1:11: error") {
+		t.Errorf("fmt of a parse error: exit status %d, want %d\n%s", status, exitParse, stderr)
+	}
+	_, stderr, status = runCitadelInput(t, dir, formatted, "fmt", "-w")
+	if status != exitUsage || !strings.Contains(stderr, "-w cannot write back to the standard input") {
+		t.Errorf("fmt -w of the standard input: exit status %d, want %d\n%s", status, exitUsage, stderr)
+	}
+}
+
+// TestRun checks that run passes the arguments after -- to the program
+// and exits with its status, with 128 plus the signal that killed it, or
+// with the status of the step that failed to build it
+func TestRun(t *testing.T) {
+	if _, err := codegen.FindLinker(""); err != nil {
+		t.Skip(err)
+	}
+	dir := writeFiles(t, map[string]string{
+		"args.c":      "int puts(char *s);\nint main(int argc, char **argv) { puts(argv[1]); return argc; }\n",
+		"segv.c":      "int main() { int *p = 0; return p[0]; }\n",
+		"undefined.c": "int main() { return y; }\n",
+	})
+	stdout, stderr, status := runCitadelInput(t, dir, "", "run", "args.c", "--", "hello", "-O1")
+	if status != 3 || stdout != "hello\n" {
+		t.Errorf("run args.c -- hello -O1: exit status %d with %q, want 3 with \"hello\\n\"\n%s", status, stdout, stderr)
+	}
+	if stderr, status := runCitadel(t, dir, "run", "segv.c"); status != 128+int(syscall.SIGSEGV) {
+		t.Errorf("run segv.c: exit status %d, want %d\n%s", status, 128+int(syscall.SIGSEGV), stderr)
+	}
+	if stderr, status := runCitadel(t, dir, "run", "undefined.c"); status != exitSemantic || !strings.Contains(stderr, "undefined variable: y") {
+		t.Errorf("run undefined.c: exit status %d, want %d\n%s", status, exitSemantic, stderr)
+	}
+}
+
+// TestREPL checks that the repl prints the IR of the functions and
+// expressions it reads, quotes the entry an error is in and carries on,
+// and ends at :quit
+func TestREPL(t *testing.T) {
+	dir := t.TempDir()
+	const session = "int twice(int x) {\n    return x + x;\n}\ny + 1\ntwice(4) + 1\n:quit\ntwice(5)\n"
+	stdout, stderr, status := runCitadelInput(t, dir, session, "repl")
+	if status != exitOK {
+		t.Fatalf("exit status %d\n%s", status, stderr)
+	}
+	for _, want := range []string{"define dso_local i32 @twice(i32 %x) {", "define dso_local i32 @__repl() {", "%call = call i32 @twice(i32 4)"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("the output lacks %s:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "@twice(i32 5)") {
+		t.Errorf("the repl read on after :quit:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "<input 2> as __repl:2:1: error: undefined variable: y") {
+		t.Errorf("the standard error lacks the error in the second entry:\n%s", stderr)
+	}
+	if stdout, _, status := runCitadelInput(t, dir, ":help\n", "repl"); status != exitOK || !strings.Contains(stdout, ":cfg func") {
+		t.Errorf(":help: exit status %d with\n%s", status, stdout)
+	}
+	if _, _, status := runCitadelInput(t, dir, "", "repl", "a.c"); status != exitUsage {
+		t.Errorf("repl a.c: exit status %d, want %d", status, exitUsage)
+	}
+}
+
+// TestVersion checks that version prints the release, the LLVM releases
+// the IR works with and the rules, and takes no arguments
+func TestVersion(t *testing.T) {
+	dir := t.TempDir()
+	stdout, stderr, status := runCitadelInput(t, dir, "", "version")
+	if status != exitOK {
+		t.Fatalf("exit status %d\n%s", status, stderr)
+	}
+	for _, want := range []string{
+		"citadel version " + codegen.Version + "\n",
+		"LLVM IR: " + codegen.IRCompatibility + "\n",
+		"default " + codegen.DefaultTriple,
+		"dangerous-call      CWE-676",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("the output lacks %q:\n%s", want, stdout)
+		}
+	}
+	if stderr, status := runCitadel(t, dir, "version", "extra"); status != exitUsage || !strings.Contains(stderr, "Usage: citadel version") {
+		t.Errorf("version extra: exit status %d, want %d with the usage\n%s", status, exitUsage, stderr)
+	}
+}
+
+// TestGraph checks the DOT graphs graph draws of a function's blocks and
+// of the calls between functions, and that it needs exactly one of them
+// and a function that exists
+func TestGraph(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.c": unformatted})
+	stdout, stderr, status := runCitadelInput(t, dir, "", "graph", "-cfg", "main", "a.c")
+	if status != exitOK {
+		t.Fatalf("graph -cfg main: exit status %d\n%s", status, stderr)
+	}
+	for _, want := range []string{`digraph "CFG of main" {`, `"%entry" -> "%if.then";`, `"%entry" -> "%if.end";`, `"%if.then" -> "%return";`} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("graph -cfg main lacks %s:\n%s", want, stdout)
+		}
+	}
+	stdout, _, status = runCitadelInput(t, dir, "", "graph", "-callgraph", "a.c")
+	if status != exitOK || !strings.Contains(stdout, `"main" -> "twice";`) || strings.Contains(stdout, `"twice" ->`) {
+		t.Errorf("graph -callgraph: exit status %d with\n%s", status, stdout)
+	}
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"graph", "a.c"}, "Usage: citadel graph"},
+		{[]string{"graph", "-cfg", "main", "-callgraph", "a.c"}, "Usage: citadel graph"},
+		{[]string{"graph", "-cfg", "nope", "a.c"}, "no function nope"},
+		{[]string{"graph", "-callgraph", "-format", "png", "a.c"}, "Unknown output format: png"},
+	} {
+		if stderr, status := runCitadel(t, dir, test.args...); status != exitUsage || !strings.Contains(stderr, test.want) {
+			t.Errorf("citadel %v: exit status %d, want %d with %s\n%s", test.args, status, exitUsage, test.want, stderr)
+		}
+	}
+}
+
+// TestGoldenTests checks that test fails a file without golden files,
+// writes them with -update, passes them, and prints a diff and fails once
+// the output changes
+func TestGoldenTests(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"tests/a.c": unformatted})
+	for _, step := range []struct {
+		args   []string
+		status int
+		want   string
+	}{
+		{[]string{"test", "tests"}, exitUsage, "FAIL tests/a.c\nNo golden files; run with -update to write them\nFAIL: 1 of 1 test\n"},
+		{[]string{"test", "-update", "tests"}, exitOK, "updated tests/a.c\n"},
+		{[]string{"test", "-v", "tests"}, exitOK, "ok   tests/a.c\nok: 1 test\n"},
+	} {
+		stdout, stderr, status := runCitadelInput(t, dir, "", step.args...)
+		if status != step.status || stdout != step.want {
+			t.Fatalf("citadel %v: exit status %d with\n%s\nwant %d with\n%s\n%s", step.args, status, stdout, step.status, step.want, stderr)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tests", "a.findings.golden")); err != nil {
+		t.Error(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tests", "a.c"), []byte(strings.Replace(unformatted, "twice(3)", "twice(4)", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, status := runCitadelInput(t, dir, "", "test", "tests")
+	if status != exitUsage || !strings.Contains(stdout, "-  %call = call i32 @twice(i32 3)\n+  %call = call i32 @twice(i32 4)\n") || !strings.HasSuffix(stdout, "FAIL: 1 of 1 test\n") {
+		t.Errorf("test of a changed file: exit status %d with\n%s", status, stdout)
+	}
+	if stderr, status := runCitadel(t, dir, "test", "nothing/..."); status != exitUsage {
+		t.Errorf("test with no files: exit status %d, want %d\n%s", status, exitUsage, stderr)
+	}
+}
+
+// TestBench checks that bench times each phase, as a table or as JSON,
+// and takes a single file
+func TestBench(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.c": unformatted})
+	stdout, stderr, status := runCitadelInput(t, dir, "", "bench", "-time", "10ms", "a.c")
+	if status != exitOK {
+		t.Fatalf("exit status %d\n%s", status, stderr)
+	}
+	if !strings.HasPrefix(stdout, "a.c: 4 lines, 41 tokens, 23 nodes") {
+		t.Errorf("the output does not start with the size of a.c:\n%s", stdout)
+	}
+	for _, phase := range []string{"lex", "parse", "sema", "analysis", "codegen"} {
+		if !regexp.MustCompile(`(?m)^ +` + phase + ` +[1-9]`).MatchString(stdout) {
+			t.Errorf("%s was not timed:\n%s", phase, stdout)
+		}
+	}
+
+	stdout, _, status = runCitadelInput(t, dir, "", "bench", "-time", "10ms", "-json", "a.c")
+	var results struct {
+		File   string
+		Tokens int
+		Phases []struct {
+			Phase string
+			Runs  int
+		}
+	}
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil || status != exitOK {
+		t.Fatalf("bench -json: exit status %d, %v\n%s", status, err, stdout)
+	}
+	if results.File != "a.c" || results.Tokens != 41 || len(results.Phases) != 5 || results.Phases[0].Phase != "lex" || results.Phases[0].Runs == 0 {
+		t.Errorf("bench -json: %+v", results)
+	}
+
+	if stderr, status := runCitadel(t, dir, "bench", "a.c", "a.c"); status != exitUsage {
+		t.Errorf("bench a.c a.c: exit status %d, want %d\n%s", status, exitUsage, stderr)
+	}
+}
+
+// TestTimeReport checks that compile and check report the steps they
+// ran, on the standard output unless an output of theirs is written
+// there, when the report goes to the standard error
+func TestTimeReport(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.c": unformatted})
+	for _, test := range []struct {
+		args     []string
+		toStderr bool
+		steps    []string
+	}{
+		{[]string{"compile", "-time-report", "-o", "out.ll", "a.c"}, false, []string{"read", "lex", "parse", "codegen"}},
+		{[]string{"compile", "-time-report", "-o", "-", "a.c"}, true, []string{"read", "lex", "parse", "codegen"}},
+		{[]string{"check", "-time-report", "a.c"}, false, []string{"read", "lex", "parse", "sema", "analysis: taint", "output"}},
+		{[]string{"check", "-time-report", "-diagnostics-format", "json", "a.c"}, true, []string{"read", "sema", "analysis: taint", "output"}},
+	} {
+		stdout, stderr, status := runCitadelInput(t, dir, "", test.args...)
+		if status != exitOK {
+			t.Fatalf("citadel %v: exit status %d\n%s", test.args, status, stderr)
+		}
+		report, other := stdout, stderr
+		if test.toStderr {
+			report, other = stderr, stdout
+		}
+		if !strings.Contains(report, "Time report:\n") || !strings.Contains(report, "peak memory:") || strings.Contains(other, "Time report:") {
+			t.Errorf("citadel %v: the report is not where it belongs:\n%s\n%s", test.args, stdout, stderr)
+		}
+		for _, step := range test.steps {
+			if !regexp.MustCompile(`(?m)^  ` + step + ` +1 `).MatchString(report) {
+				t.Errorf("citadel %v: step %s is not reported once:\n%s", test.args, step, report)
+			}
+		}
+	}
+	if stdout, stderr, _ := runCitadelInput(t, dir, "", "compile", "-o", "out.ll", "a.c"); strings.Contains(stdout+stderr, "Time report:") {
+		t.Errorf("a report without -time-report:\n%s%s", stdout, stderr)
+	}
+}
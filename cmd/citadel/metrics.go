@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+)
+
+// serviceMetrics, once -metrics-addr is given, counts what check, compile
+// -watch and lsp do for as long as they run, and is served over HTTP. It
+// is nil otherwise, and a nil *metrics counts nothing.
+var serviceMetrics *metrics
+
+// metrics counts the files a command compiled or checked, the time each
+// analysis pass took, the findings by rule and severity and the lookups
+// in the -cache directory, to be written in the Prometheus text format.
+type metrics struct {
+	mu       sync.Mutex
+	files    map[[2]string]int64 // by command and result
+	findings map[[2]string]int64 // by rule and severity
+	passes   map[string]*passTime
+	cache    map[string]int64 // by hit or miss
+}
+
+type passTime struct {
+	runs    int64
+	elapsed time.Duration
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		files:    map[[2]string]int64{},
+		findings: map[[2]string]int64{},
+		passes:   map[string]*passTime{},
+		cache:    map[string]int64{},
+	}
+}
+
+// metricsFlag registers -metrics-addr on fs, and returns a function to
+// call once the flags and the logger are ready, which sets serviceMetrics
+// and serves them at /metrics on the address if one was given. It exits
+// if the address cannot be listened on.
+func metricsFlag(fs *flag.FlagSet) func(log *slog.Logger) {
+	addr := fs.String("metrics-addr", "", "serve metrics in the Prometheus text format at http://ADDR/metrics while running, such as :9464: files compiled, time per analysis pass, findings by rule and severity, and cache hits")
+	return func(log *slog.Logger) {
+		if *addr == "" {
+			return
+		}
+		ln, err := net.Listen("tcp", *addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error serving metrics: %v\n", err)
+			os.Exit(1)
+		}
+		serviceMetrics = newMetrics()
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			serviceMetrics.write(w)
+		})
+		go http.Serve(ln, mux)
+		log.Info(fmt.Sprintf("Serving metrics at http://%s/metrics", ln.Addr()))
+	}
+}
+
+// file counts a file the command compiled or checked, which failed with
+// err if it is not nil, and the findings in it that are not suppressed.
+func (m *metrics) file(command string, err error, findings []analysis.Finding) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[[2]string{command, result}]++
+	for _, f := range findings {
+		if f.Suppressed == nil {
+			m.findings[[2]string{f.Rule, f.Severity.String()}]++
+		}
+	}
+}
+
+// cacheLookup counts a lookup of findings in the -cache directory.
+func (m *metrics) cacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.mu.Lock()
+	m.cache[result]++
+	m.mu.Unlock()
+}
+
+// measureAnalysis returns the analysis.Config.Measure hook timing each
+// pass, which then calls next if it is not nil, or next alone for nil
+// metrics.
+func (m *metrics) measureAnalysis(next func(pass string, run func())) func(pass string, run func()) {
+	if m == nil {
+		return next
+	}
+	return func(pass string, run func()) {
+		start := time.Now()
+		if next != nil {
+			next(pass, run)
+		} else {
+			run()
+		}
+		elapsed := time.Since(start)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		t := m.passes[pass]
+		if t == nil {
+			t = &passTime{}
+			m.passes[pass] = t
+		}
+		t.runs++
+		t.elapsed += elapsed
+	}
+}
+
+// write writes the metrics to w in the Prometheus text format, each
+// series in the order of its labels.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP citadel_files_total Files compiled or checked, by command and result.")
+	fmt.Fprintln(w, "# TYPE citadel_files_total counter")
+	for _, key := range sortedPairs(m.files) {
+		fmt.Fprintf(w, "citadel_files_total{command=%s,result=%s} %d\n", quoteLabel(key[0]), quoteLabel(key[1]), m.files[key])
+	}
+	fmt.Fprintln(w, "# HELP citadel_findings_total Findings not suppressed, by rule and severity.")
+	fmt.Fprintln(w, "# TYPE citadel_findings_total counter")
+	for _, key := range sortedPairs(m.findings) {
+		fmt.Fprintf(w, "citadel_findings_total{rule=%s,severity=%s} %d\n", quoteLabel(key[0]), quoteLabel(key[1]), m.findings[key])
+	}
+	fmt.Fprintln(w, "# HELP citadel_pass_duration_seconds Time spent in each analysis pass.")
+	fmt.Fprintln(w, "# TYPE citadel_pass_duration_seconds summary")
+	passes := make([]string, 0, len(m.passes))
+	for pass := range m.passes {
+		passes = append(passes, pass)
+	}
+	sort.Strings(passes)
+	for _, pass := range passes {
+		t := m.passes[pass]
+		fmt.Fprintf(w, "citadel_pass_duration_seconds_sum{pass=%s} %g\n", quoteLabel(pass), t.elapsed.Seconds())
+		fmt.Fprintf(w, "citadel_pass_duration_seconds_count{pass=%s} %d\n", quoteLabel(pass), t.runs)
+	}
+	fmt.Fprintln(w, "# HELP citadel_cache_lookups_total Lookups of findings in the -cache directory, by result.")
+	fmt.Fprintln(w, "# TYPE citadel_cache_lookups_total counter")
+	for _, result := range []string{"hit", "miss"} {
+		fmt.Fprintf(w, "citadel_cache_lookups_total{result=%s} %d\n", quoteLabel(result), m.cache[result])
+	}
+}
+
+func sortedPairs(counts map[[2]string]int64) [][2]string {
+	keys := make([][2]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// quoteLabel quotes a label value as the text format escapes it.
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
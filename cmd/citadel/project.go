@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// projectFile is the name of the project file compile, check and run
+// look for above their first input.
+const projectFile = ".citadel.toml"
+
+// project holds the defaults a .citadel.toml file gives the flags of a
+// project's builds:
+//
+//	target = "wasm32-unknown-unknown"
+//	preset = "linux-arm64"
+//
+//	[rules]
+//	enable = ["recursion"]
+//	disable = ["unreachable-code"]
+//
+//	[output]
+//	emit = "ir,findings"
+//	diagnostics = "json"
+//
+// Flags given on the command line override it.
+type project struct {
+	Target string `toml:"target"`
+	// Preset names one of codegen.Presets, as -preset of compile does
+	Preset string `toml:"preset"`
+	// Include and Defines are accepted for build files shared with a C
+	// compiler, but the C subset has no preprocessor to use them
+	Include []string `toml:"include"`
+	Defines []string `toml:"defines"`
+	Rules   struct {
+		Enable  []string `toml:"enable"`
+		Disable []string `toml:"disable"`
+	} `toml:"rules"`
+	Output struct {
+		Emit      string `toml:"emit"`
+		IRFormat  string `toml:"ir-format"`
+		ASTFormat string `toml:"ast-format"`
+		// Diagnostics is text or json, as -diagnostics-format
+		Diagnostics string `toml:"diagnostics"`
+		Color       string `toml:"color"`
+	} `toml:"output"`
+}
+
+// flags returns the values the project gives the flags of command, by
+// flag name.
+func (p *project) flags(command string) map[string]string {
+	set := map[string]string{
+		"target":     p.Target,
+		"preset":     p.Preset,
+		"enable":     strings.Join(p.Rules.Enable, ","),
+		"disable":    strings.Join(p.Rules.Disable, ","),
+		"emit":       p.Output.Emit,
+		"ast-format": p.Output.ASTFormat,
+		"color":      p.Output.Color,
+	}
+	// -format is the IR format to compile, but the old name of
+	// -diagnostics-format to check
+	if command != "check" {
+		set["format"] = p.Output.IRFormat
+	}
+	set["diagnostics-format"] = p.Output.Diagnostics
+	return set
+}
+
+// flagAliases maps the old names of flags, which they keep, to their
+// names, by command.
+var flagAliases = map[string]map[string]string{
+	"check": {"format": "diagnostics-format"},
+}
+
+// findProject returns the path of the project file in dir or the nearest
+// of its parents that has one, or "" if none does.
+func findProject(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, projectFile)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProject reads the project file at path, rejecting settings it does
+// not know so that a misspelt one is not silently ignored.
+func loadProject(path string) (*project, error) {
+	p := &project{}
+	meta, err := toml.DecodeFile(path, p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("%s: unknown setting %s", path, undecoded[0])
+	}
+	return p, nil
+}
+
+// projectFlags registers -project on fs, and returns a function that,
+// once the flags are parsed, gives the flags the command line left unset
+// the values of the project file: the -project file, or else the nearest
+// .citadel.toml above the first input. It exits if the file cannot be
+// read or gives a flag a bad value.
+func projectFlags(fs *flag.FlagSet) func() {
+	file := fs.String("project", "", "project file giving defaults for the flags (default: the nearest "+projectFile+" above the first input; none for no file)")
+	return func() {
+		path := *file
+		if path == "none" {
+			return
+		}
+		if path == "" {
+			dir := "."
+			if fs.NArg() > 0 && fs.Arg(0) != "-" {
+				dir = filepath.Dir(fs.Arg(0))
+			}
+			if path = findProject(dir); path == "" {
+				return
+			}
+		}
+		p, err := loadProject(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading project file: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if len(p.Include) > 0 || len(p.Defines) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %s: include and defines have no effect, as the C subset has no preprocessor\n", path)
+		}
+
+		given := setFlags(fs)
+		for alias, name := range flagAliases[fs.Name()] {
+			given[name] = given[name] || given[alias]
+		}
+		for name, value := range p.flags(fs.Name()) {
+			if value == "" || given[name] || fs.Lookup(name) == nil {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s: invalid value %q for -%s: %v\n", path, value, name, err)
+				os.Exit(exitUsage)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// ANSI escapes for the parts of a diagnostic, in the colors clang uses.
+const (
+	ansiBold  = "\x1b[1m"
+	ansiError = "\x1b[1;31m"
+	ansiNote  = "\x1b[1;36m"
+	ansiCaret = "\x1b[1;32m"
+	ansiReset = "\x1b[0m"
+)
+
+// colorFlag registers -color on fs.
+func colorFlag(fs *flag.FlagSet) *string {
+	return fs.String("color", "auto", "color diagnostics: auto (when the standard error is a terminal and NO_COLOR is unset), always or never")
+}
+
+// useColor reports whether diagnostics are colored under the -color mode,
+// exiting if it is not one.
+func useColor(mode string) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false
+		}
+		return isTerminal(os.Stderr)
+	}
+	fmt.Fprintf(os.Stderr, "Unknown color mode: %s\n", mode)
+	os.Exit(1)
+	return false
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// errorRenderer writes errors as clang does: each diagnostic as
+// "file:line:col: error: message" with the source line and a caret under
+// the offending text, followed by its notes in the same form. An error
+// with the same message as one written before is only counted, and once
+// limit errors are written the rest are too; finish says how many.
+type errorRenderer struct {
+	color bool
+	files *lexer.FileSet // the files whose lines errors quote
+	limit int            // 0 for no limit
+
+	errors   int            // errors written, collapsed or left out
+	written  int            // errors written in full
+	similar  map[string]int // repeats of each message written, by message
+	messages []string       // the messages repeated, in the order first written
+	omitted  int            // errors left out past the limit
+}
+
+// newErrorRenderer returns an errorRenderer quoting the lines of sources,
+// the text of each file by name.
+func newErrorRenderer(color bool, sources map[string]string) *errorRenderer {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := &lexer.FileSet{}
+	for _, name := range names {
+		files.AddFile(name, sources[name])
+	}
+	return &errorRenderer{color: color, files: files, similar: map[string]int{}}
+}
+
+// maxErrorsFlag registers -max-errors on fs.
+func maxErrorsFlag(fs *flag.FlagSet) *int {
+	return fs.Int("max-errors", 20, "stop writing errors after this many, not counting repeats of the same message (0 for no limit)")
+}
+
+// write writes err to w. Errors without a position in the source are
+// written as their message alone.
+func (r *errorRenderer) write(w io.Writer, err error) {
+	for _, d := range errorDiagnostics(err) {
+		r.writeDiagnostic(w, d)
+	}
+}
+
+// writeDiagnostic writes the error d to w, with its notes and the fixes
+// that edit the source, each shown where it applies.
+func (r *errorRenderer) writeDiagnostic(w io.Writer, d diag.Diagnostic) {
+	if !r.admit(d.Message) {
+		return
+	}
+	if d.Pos.Line == 0 {
+		fmt.Fprintf(w, "%s\n", d.Message)
+		return
+	}
+	r.writeOne(w, d.File, d.Pos, d.End, "error", ansiError, d.Message)
+	for _, note := range d.Notes {
+		r.writeOne(w, note.File, note.Pos, note.Pos, "note", ansiNote, note.Message)
+	}
+	for _, fix := range d.Fixes {
+		for _, edit := range fix.Edits {
+			r.writeOne(w, d.File, edit.Pos, edit.End, "fix-it", ansiCaret, fix.Message)
+			// The text to insert goes under the caret, as clang has it
+			if line, ok := r.line(d.File, edit.Pos.Line); ok && edit.NewText != "" {
+				fmt.Fprintf(w, "%s%s%s%s\n", indent(line, edit.Pos.Column), r.paint(ansiCaret), edit.NewText, r.paint(ansiReset))
+			}
+		}
+	}
+}
+
+// admit counts an error with message msg and reports whether to write it:
+// not if an error with the same message was, or the limit is reached.
+func (r *errorRenderer) admit(msg string) bool {
+	r.errors++
+	if n, ok := r.similar[msg]; ok {
+		if n == 0 {
+			r.messages = append(r.messages, msg)
+		}
+		r.similar[msg]++
+		return false
+	}
+	if r.limit > 0 && r.written >= r.limit {
+		r.omitted++
+		return false
+	}
+	r.similar[msg] = 0
+	r.written++
+	return true
+}
+
+// finish writes to w how many errors write collapsed or left out.
+func (r *errorRenderer) finish(w io.Writer) {
+	for _, msg := range r.messages {
+		fmt.Fprintf(w, "%snote:%s and %s like %q\n", r.paint(ansiNote), r.paint(ansiReset), plural(r.similar[msg], "more error"), msg)
+	}
+	if r.omitted > 0 {
+		fmt.Fprintf(w, "%s not shown, past -max-errors=%d\n", plural(r.omitted, "more error"), r.limit)
+	}
+}
+
+// writeSummary writes the line that ends the output of a run: how many
+// errors, warnings and unsuppressed findings it reported, the findings by
+// severity, most severe first.
+func writeSummary(w io.Writer, errors, warnings int, findings []analysis.Finding) {
+	counts := map[analysis.Severity]int{}
+	total := 0
+	for _, f := range findings {
+		if f.Suppressed == nil {
+			counts[f.Severity]++
+			total++
+		}
+	}
+	line := fmt.Sprintf("%s, %s, %s", plural(errors, "error"), plural(warnings, "warning"), plural(total, "finding"))
+	var bySeverity []string
+	for s := analysis.Critical; s >= analysis.Info; s-- {
+		if counts[s] > 0 {
+			bySeverity = append(bySeverity, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	if len(bySeverity) > 0 {
+		line += " (" + strings.Join(bySeverity, ", ") + ")"
+	}
+	fmt.Fprintln(w, line)
+}
+
+func (r *errorRenderer) writeOne(w io.Writer, file string, pos, end lexer.Position, kind, color, msg string) {
+	fmt.Fprintf(w, "%s%s:%s: %s%s:%s %s%s%s\n", r.paint(ansiBold), file, pos, r.paint(color), kind, r.paint(ansiReset), r.paint(ansiBold), msg, r.paint(ansiReset))
+	line, ok := r.line(file, pos.Line)
+	if !ok {
+		return
+	}
+	marker := "^"
+	if end.Line == pos.Line && end.Column > pos.Column+1 {
+		marker += strings.Repeat("~", end.Column-pos.Column-1)
+	}
+	fmt.Fprintf(w, "%s\n%s%s%s%s\n", line, indent(line, pos.Column), r.paint(ansiCaret), marker, r.paint(ansiReset))
+}
+
+// indent returns the blanks that line up under column of line, tabs under
+// its tabs as the source line has them.
+func indent(line string, column int) string {
+	var pad strings.Builder
+	for i := 0; i < column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			pad.WriteByte('\t')
+		} else {
+			pad.WriteByte(' ')
+		}
+	}
+	return pad.String()
+}
+
+// line returns line n of file, if its source is known.
+func (r *errorRenderer) line(file string, n int) (string, bool) {
+	f := r.files.Lookup(file)
+	if f == nil || n < 1 || n > f.LineCount() {
+		return "", false
+	}
+	return f.Line(n), true
+}
+
+func (r *errorRenderer) paint(escape string) string {
+	if r.color {
+		return escape
+	}
+	return ""
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// replFunction is the function citadel repl wraps expressions and
+// statements in to compile them.
+const replFunction = "__repl"
+
+const replHelp = `Enter function definitions and prototypes to add them to the session,
+replacing those of the same name, or an expression or statements to see
+their IR. Commands:
+  :list          print the functions of the session
+  :ir [func]     print the IR of the session, or of one function
+  :cfg func      print the basic blocks of a function and their successors
+  :taint [var]   print the variables that may hold untrusted data when
+                 their function returns, and where the data came from
+  :findings      print what check reports for the session
+  :load file.c   add the functions of a file
+  :reset         remove every function
+  :help          print this message
+  :quit          leave (as does end of input)
+`
+
+// cmdREPL implements citadel repl, which compiles what is typed at it
+// and answers questions about the analysis of the program built up.
+func cmdREPL() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("repl", "")
+	o1 := fs.Bool("O1", false, "fold constants, promote locals to registers, eliminate common subexpressions and dead blocks")
+	color := colorFlag(fs)
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		if *o1 {
+			opts.OptLevel = 1
+		}
+
+		r := &repl{
+			out:      os.Stdout,
+			errs:     os.Stderr,
+			opts:     opts,
+			program:  &ast.Program{},
+			renderer: newErrorRenderer(useColor(*color), nil),
+		}
+		prompt := isTerminal(os.Stdin)
+		if prompt {
+			fmt.Fprintf(r.out, "citadel repl; :help lists the commands\n")
+		}
+
+		in := bufio.NewScanner(os.Stdin)
+		var entry strings.Builder
+		for {
+			if prompt {
+				if entry.Len() == 0 {
+					fmt.Fprint(r.out, "citadel> ")
+				} else {
+					fmt.Fprint(r.out, "...> ")
+				}
+			}
+			if !in.Scan() {
+				break
+			}
+			line := in.Text()
+			if entry.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+				if !r.command(strings.Fields(strings.TrimSpace(line))) {
+					return
+				}
+				continue
+			}
+			entry.WriteString(line + "\n")
+			if !complete(entry.String()) {
+				continue
+			}
+			if strings.TrimSpace(entry.String()) != "" {
+				r.eval(entry.String())
+			}
+			entry.Reset()
+		}
+		if err := in.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			os.Exit(1)
+		}
+		if entry.Len() > 0 {
+			r.eval(entry.String())
+		}
+	}
+}
+
+// complete reports whether text closes every brace and parenthesis it
+// opens, so that an entry spanning lines ends with it.
+func complete(text string) bool {
+	depth := 0
+	lex := lexer.New(text)
+	for tok := lex.NextToken(); tok.Type != lexer.EOF; tok = lex.NextToken() {
+		switch tok.Type {
+		case lexer.LBRACE, lexer.LPAREN:
+			depth++
+		case lexer.RBRACE, lexer.RPAREN:
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// repl is the state of a citadel repl session.
+type repl struct {
+	out, errs io.Writer
+	opts      codegen.Options
+	program   *ast.Program // the functions entered so far
+	entries   int
+	renderer  *errorRenderer // quotes the text of each entry, by name
+}
+
+// eval adds the functions text defines to the session, or, if it is not
+// a list of functions, compiles it as an expression or statements, and
+// prints the IR generated for it.
+func (r *repl) eval(text string) {
+	r.entries++
+	name := fmt.Sprintf("<input %d>", r.entries)
+	r.renderer.files.AddFile(name, text)
+	_, program, err := parse(name, text)
+	if err == nil {
+		r.define(name, program)
+		return
+	}
+
+	// Expressions are returned and statements run, from line 2 of the
+	// function wrapped around them so that positions in them keep their
+	// columns
+	wrapped := "int " + replFunction + "() {\n" + text + "return 0;\n}\n"
+	if body := strings.TrimSpace(text); !strings.HasSuffix(body, ";") && !strings.HasSuffix(body, "}") {
+		wrapped = "int " + replFunction + "() { return\n" + body + "\n;\n}\n"
+	}
+	wrappedName := name + " as " + replFunction
+	_, expr, werr := parse(wrappedName, wrapped)
+	if werr != nil {
+		// Report the error of whichever reading got further
+		if perr, ok := werr.(*parser.Error); ok {
+			if first, ok := err.(*parser.Error); !ok || perr.Pos.Line-1 > first.Pos.Line || perr.Pos.Line-1 == first.Pos.Line && perr.Pos.Column > first.Pos.Column {
+				r.renderer.files.AddFile(wrappedName, wrapped)
+				err = werr
+			}
+		}
+		r.renderer.write(r.errs, err)
+		return
+	}
+	expr.Functions[0].File = wrappedName
+	session := &ast.Program{Functions: append(append([]*ast.Function(nil), r.program.Functions...), expr.Functions...)}
+	ir, err := codegen.NewWithOptions(r.opts).Generate(session)
+	if err != nil {
+		r.renderer.files.AddFile(wrappedName, wrapped)
+		r.renderer.write(r.errs, err)
+		return
+	}
+	fmt.Fprint(r.out, functionIR(ir, replFunction))
+}
+
+// define adds the functions of program, entered as name, to the session
+// and prints their IR, leaving the session as it was if they do not
+// compile with it. A definition replaces any function of the same name;
+// a prototype adds nothing once there is one.
+func (r *repl) define(name string, program *ast.Program) {
+	session := &ast.Program{Functions: append([]*ast.Function(nil), r.program.Functions...)}
+	var added []string
+	for _, fn := range program.Functions {
+		fn.File = name
+		i := 0
+		for i < len(session.Functions) && session.Functions[i].Name != fn.Name {
+			i++
+		}
+		switch {
+		case i == len(session.Functions):
+			session.Functions = append(session.Functions, fn)
+		case fn.Body != nil:
+			session.Functions[i] = fn
+		default:
+			continue
+		}
+		added = append(added, fn.Name)
+	}
+	ir, err := codegen.NewWithOptions(r.opts).Generate(session)
+	if err != nil {
+		r.renderer.write(r.errs, err)
+		return
+	}
+	r.program = session
+	for _, fn := range added {
+		fmt.Fprint(r.out, functionIR(ir, fn))
+	}
+}
+
+// functionIR returns the definition or declaration of the named function
+// in ir, the text of a module.
+func functionIR(ir, name string) string {
+	var b strings.Builder
+	in := false
+	for _, line := range strings.SplitAfter(ir, "\n") {
+		header := (strings.HasPrefix(line, "define ") || strings.HasPrefix(line, "declare ")) && strings.Contains(line, "@"+name+"(")
+		if header && strings.HasPrefix(line, "declare ") {
+			b.WriteString(line)
+			continue
+		}
+		if header {
+			in = true
+		}
+		if in {
+			b.WriteString(line)
+			in = strings.TrimSpace(line) != "}"
+		}
+	}
+	return b.String()
+}
+
+// command runs a : command, reporting whether the session goes on.
+func (r *repl) command(fields []string) bool {
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+	switch fields[0] {
+	case ":quit", ":q", ":exit":
+		return false
+	case ":help", ":h":
+		fmt.Fprint(r.out, replHelp)
+	case ":reset":
+		r.program = &ast.Program{}
+	case ":list":
+		if err := parser.Format(r.out, r.program, "", nil); err != nil {
+			fmt.Fprintf(r.errs, "Error: %v\n", err)
+		}
+	case ":ir":
+		ir, err := codegen.NewWithOptions(r.opts).Generate(r.program)
+		switch {
+		case err != nil:
+			r.renderer.write(r.errs, err)
+		case arg == "":
+			fmt.Fprint(r.out, ir)
+		default:
+			if text := functionIR(ir, arg); text != "" {
+				fmt.Fprint(r.out, text)
+			} else {
+				fmt.Fprintf(r.errs, "No function %s\n", arg)
+			}
+		}
+	case ":cfg":
+		if arg == "" {
+			fmt.Fprintln(r.errs, "Usage: :cfg func")
+			break
+		}
+		r.cfg(arg)
+	case ":taint":
+		facts, err := analysis.TaintFacts(r.program, nil)
+		if err != nil {
+			fmt.Fprintf(r.errs, "Analysis error: %v\n", err)
+			break
+		}
+		found := false
+		for _, fact := range facts {
+			if arg != "" && fact.Variable != arg {
+				continue
+			}
+			found = true
+			fmt.Fprintf(r.out, "%s: %s may hold untrusted data when it returns\n", fact.Function, fact.Variable)
+			for _, step := range fact.Trace {
+				fmt.Fprintf(r.out, "  %s: %s\n", step.Pos, step.Message)
+			}
+		}
+		if !found {
+			fmt.Fprintln(r.out, "No untrusted data")
+		}
+	case ":findings":
+		findings, err := analysis.Analyze(r.program, nil)
+		if err != nil {
+			fmt.Fprintf(r.errs, "Analysis error: %v\n", err)
+			break
+		}
+		// Findings give positions within the entry that defined their
+		// function
+		files := map[string]string{}
+		for _, fn := range r.program.Functions {
+			files[fn.Name] = fn.File
+		}
+		for _, f := range findings {
+			fmt.Fprintf(r.out, "%s:%s\n", files[f.Function], f)
+		}
+		fmt.Fprintf(r.out, "Findings: %d\n", len(findings))
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.errs, "Usage: :load file.c")
+			break
+		}
+		input, err := readSource(arg)
+		if err != nil {
+			fmt.Fprintf(r.errs, "Error reading input file: %v\n", err)
+			break
+		}
+		r.renderer.files.AddFile(arg, input)
+		_, program, err := parse(arg, input)
+		if err != nil {
+			r.renderer.write(r.errs, err)
+			break
+		}
+		r.define(arg, program)
+	default:
+		fmt.Fprintf(r.errs, "Unknown command %s; :help lists the commands\n", fields[0])
+	}
+	return true
+}
+
+// cfg prints the basic blocks of the IR of the named function, each with
+// the blocks it can branch to.
+func (r *repl) cfg(name string) {
+	ir, err := codegen.NewWithOptions(r.opts).Generate(r.program)
+	if err != nil {
+		r.renderer.write(r.errs, err)
+		return
+	}
+	blocks, err := functionBlocks(ir, name)
+	if err != nil {
+		fmt.Fprintf(r.errs, "Error: %v\n", err)
+		return
+	}
+	for _, block := range blocks {
+		if len(block.succs) == 0 {
+			fmt.Fprintf(r.out, "%s: %s\n", block.name, block.leaves)
+		} else {
+			fmt.Fprintf(r.out, "%s -> %s\n", block.name, strings.Join(block.succs, ", "))
+		}
+	}
+}
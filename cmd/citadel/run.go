@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// cmdRun implements citadel run, which compiles C files to an executable
+// in a temporary directory and runs it with the arguments after --,
+// exiting with its status.
+func cmdRun() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("run", "<input.c>... [-- args...]")
+	o1 := fs.Bool("O1", false, "fold constants, promote locals to registers, eliminate common subexpressions and dead blocks")
+	sanitize := instrumentationFlags(fs, &opts)
+	toolchain := fs.String("toolchain", "", "clang binary that links the program, or llc, whose object file cc links (default: clang, else llc on PATH)")
+	color := colorFlag(fs)
+	newLogger := verbosityFlags(fs)
+	applyProject := projectFlags(fs)
+	return fs, func(args []string) {
+		args, programArgs := splitProgramArgs(args)
+		parseFlags(fs, args)
+		applyProject()
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		paths := fs.Args()
+		inputFile := sourceName(paths[0])
+
+		setSanitizers(*sanitize, &opts)
+		log := newLogger()
+		opts.Logger = log
+		if *o1 {
+			opts.OptLevel = 1
+		}
+		// The program runs here, so it is built for the default target
+		target, err := codegen.LookupTarget(opts.Target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid target: %v\n", err)
+			os.Exit(1)
+		}
+		tool, err := codegen.FindLinker(*toolchain)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		log.Debug("linking with", "tool", tool)
+
+		sources := map[string]string{}
+		build := func() (string, error) {
+			var files []parser.File
+			for _, path := range paths {
+				name := sourceName(path)
+				input, err := readSource(path)
+				if err != nil {
+					return "", stageErrorf("io", name, "Error reading input file: %w", err)
+				}
+				sources[name] = input
+				_, program, err := parse(name, input)
+				if err != nil {
+					return "", stageErrorf("parser", name, "Parse error: %w", err)
+				}
+				files = append(files, parser.File{Name: name, Program: program})
+			}
+			program := files[0].Program
+			opts.SourceFile = inputFile
+			opts.Source = sources[inputFile]
+			if len(files) > 1 {
+				opts.Sources = sources
+				if program, err = parser.Merge(files); err != nil {
+					return "", &stageError{"semantic", "", err}
+				}
+			}
+			if opts.DivisionChecks {
+				opts.ProvenDivisions = analysis.ProveDivisions(program)
+			}
+			ir, err := codegen.NewWithOptions(opts).Generate(program)
+			if err != nil {
+				return "", codegenError(inputFile, err)
+			}
+			return ir, nil
+		}
+		ir, err := build()
+		if err != nil {
+			newErrorRenderer(useColor(*color), sources).write(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+
+		dir, err := os.MkdirTemp("", "citadel-run")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating a temporary directory: %v\n", err)
+			os.Exit(1)
+		}
+		status := runProgram(dir, ir, tool, target, opts, programArgs, log)
+		os.RemoveAll(dir)
+		os.Exit(status)
+	}
+}
+
+// splitProgramArgs splits the arguments of citadel run at the first --
+// into its own and those of the program.
+func splitProgramArgs(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// runProgram links ir into an executable in dir and runs it with args
+// and the standard streams of citadel, returning the status to exit
+// with: the program's, 128 plus the number of the signal that killed it,
+// or exitCodegen if it could not be linked.
+func runProgram(dir, ir, tool string, target *codegen.Target, opts codegen.Options, args []string, log *slog.Logger) int {
+	exe := filepath.Join(dir, "a.out")
+	start := time.Now()
+	if err := codegen.Link(ir, exe, tool, target, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error linking the program: %v\n", err)
+		return exitCodegen
+	}
+	log.Debug("linked", "path", exe, "elapsed", time.Since(start))
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// An interrupt from the terminal reaches the program too; citadel
+	// outlives it to clean up and report its status
+	signal.Ignore(os.Interrupt)
+	start = time.Now()
+	err := cmd.Run()
+	signal.Reset(os.Interrupt)
+	log.Debug("ran the program", "args", len(args), "elapsed", time.Since(start))
+	if err == nil {
+		return exitOK
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	fmt.Fprintf(os.Stderr, "Error running the program: %v\n", err)
+	return exitUsage
+}
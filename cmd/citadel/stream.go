@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/citadel"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+)
+
+// streamTitles head the errors of compile -stream by the step that
+// failed, as compile heads its own.
+var streamTitles = map[string]string{
+	"parser":   "Parse error",
+	"semantic": "Semantic error",
+	"analysis": "Analysis error",
+	"codegen":  "Code generation error",
+}
+
+// compileStreaming produces the IR and findings outputs of compile
+// -stream from the input at path, a function at a time, with the
+// analysis summaries kept in memory up to a quarter of limit.
+func compileStreaming(path, input string, kinds map[string]string, opts codegen.Options, limit int64, log *slog.Logger, times *timeReport) ([]analysis.Finding, error) {
+	name := sourceName(path)
+	copts := citadel.Options{
+		Codegen:      opts,
+		Stream:       true,
+		MemoryLimit:  limit / 4,
+		SkipAnalysis: kinds["findings"] == "",
+		SkipCodegen:  kinds["ir"] == "",
+	}
+	if !copts.SkipAnalysis {
+		config := analysis.DefaultConfig()
+		policy, _ := loadPolicy("", path)
+		if policy != nil {
+			config = policy.Config()
+		}
+		config.Logger = log
+		copts.Analysis = config
+		copts.SkipAnalysis = policy != nil && policy.Excludes(path)
+	}
+
+	var res citadel.Result
+	var err error
+	compile := func(w io.Writer) error {
+		copts.Output = w
+		res, err = citadel.Compile(context.Background(), citadel.Source{Name: name, Text: input}, copts)
+		return err
+	}
+	times.measure("stream", func() {
+		if out := kinds["ir"]; out != "" {
+			err = writeFile(out, compile)
+		} else {
+			compile(nil)
+		}
+	})
+	if errors.Is(err, citadel.ErrCalledBeforeDeclared) {
+		// The source is valid C, which -stream cannot take as written
+		return nil, stageErrorf("usage", name, "Usage error: %w; -stream needs a prototype before the first call", errors.Unwrap(err))
+	}
+	if err != nil {
+		stage := "io"
+		for _, d := range res.Diagnostics {
+			if d.Severity == diag.Error {
+				stage = d.Stage
+			}
+		}
+		if title, ok := streamTitles[stage]; ok {
+			if inner := errors.Unwrap(err); inner != nil {
+				err = inner
+			}
+			return nil, stageErrorf(stage, name, "%s: %w", title, err)
+		}
+		return nil, stageErrorf(stage, name, "Error writing output file: %w", err)
+	}
+	log.Debug("compiled a function at a time", "file", name, "findings", len(res.Findings))
+	return res.Findings, nil
+}
+
+// parseSize parses a number of bytes, such as 512MiB, 2GiB or 1048576.
+func parseSize(size string) (int64, error) {
+	s := size
+	units := []struct {
+		suffix string
+		scale  int64
+	}{{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1}}
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, scale = strings.TrimSuffix(s, unit.suffix), unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * scale, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// symbol is a function, parameter or local variable a program declares.
+type symbol struct {
+	name string
+	kind string // function, parameter or local
+	pos  lexer.Position
+	// decl is its C declaration, such as char *buf or int f(int n)
+	decl string
+	// fn is the function it belongs to, or that it is
+	fn *ast.Function
+}
+
+// symbolRef is a use of a name, or its declaration, where pos says.
+type symbolRef struct {
+	pos  lexer.Position
+	name string
+	sym  *symbol // nil if the program does not declare the name
+}
+
+// symbolTable is what each name in a program refers to, with C scoping:
+// locals are visible from their declaration to the end of their block,
+// parameters in the body of their function and functions everywhere.
+type symbolTable struct {
+	symbols []*symbol // in the order they are declared
+	refs    []symbolRef
+	scopes  []map[string]*symbol
+	funcs   map[string]*symbol
+}
+
+// buildSymbols returns the symbol table of program.
+func buildSymbols(program *ast.Program) *symbolTable {
+	t := &symbolTable{funcs: map[string]*symbol{}}
+	// A definition is where a function is, even after its prototype
+	for _, fn := range program.Functions {
+		if sym := t.funcs[fn.Name]; sym == nil || sym.fn.Body == nil && fn.Body != nil {
+			t.funcs[fn.Name] = &symbol{name: fn.Name, kind: "function", pos: fn.NamePos, decl: functionDeclaration(fn), fn: fn}
+		}
+	}
+	for _, fn := range program.Functions {
+		sym := t.funcs[fn.Name]
+		if sym.fn == fn {
+			t.symbols = append(t.symbols, sym)
+		}
+		t.refs = append(t.refs, symbolRef{fn.NamePos, fn.Name, sym})
+		if fn.Body == nil {
+			continue
+		}
+		t.push()
+		for _, param := range fn.Params {
+			if param.Name != "" {
+				t.declare(&symbol{name: param.Name, kind: "parameter", pos: param.Pos, decl: parser.Declarator(paramType(param), param.Name), fn: fn})
+			}
+		}
+		t.block(fn, fn.Body.Statements)
+		t.pop()
+	}
+	return t
+}
+
+func (t *symbolTable) push() { t.scopes = append(t.scopes, map[string]*symbol{}) }
+
+func (t *symbolTable) pop() { t.scopes = t.scopes[:len(t.scopes)-1] }
+
+// declare adds sym to the innermost scope.
+func (t *symbolTable) declare(sym *symbol) {
+	t.scopes[len(t.scopes)-1][sym.name] = sym
+	t.symbols = append(t.symbols, sym)
+	t.refs = append(t.refs, symbolRef{sym.pos, sym.name, sym})
+}
+
+// lookup returns the symbol name refers to where the walk is, or nil.
+func (t *symbolTable) lookup(name string) *symbol {
+	for i := len(t.scopes) - 1; i >= 0; i-- {
+		if sym := t.scopes[i][name]; sym != nil {
+			return sym
+		}
+	}
+	return t.funcs[name]
+}
+
+// block walks stmts, the statements of a block of fn, in a scope of
+// their own.
+func (t *symbolTable) block(fn *ast.Function, stmts []ast.Statement) {
+	t.push()
+	for _, stmt := range stmts {
+		t.statement(fn, stmt)
+	}
+	t.pop()
+}
+
+func (t *symbolTable) statement(fn *ast.Function, stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.Block:
+		t.block(fn, s.Statements)
+	case *ast.VarDecl:
+		// A name is in scope from its declarator on, so int x = x reads
+		// the new x
+		t.declare(&symbol{name: s.Name, kind: "local", pos: s.NamePos, decl: parser.Declarator(s.Type, s.Name), fn: fn})
+		t.expression(s.Value)
+	case *ast.IfStatement:
+		t.expression(s.Condition)
+		if s.ThenBlock != nil {
+			t.block(fn, s.ThenBlock.Statements)
+		}
+		if s.ElseBlock != nil {
+			t.block(fn, s.ElseBlock.Statements)
+		}
+	case *ast.WhileStatement:
+		t.expression(s.Condition)
+		t.block(fn, s.Body.Statements)
+	case *ast.SwitchStatement:
+		t.expression(s.Tag)
+		// The cases share the body of the switch
+		t.push()
+		for _, cs := range s.Cases {
+			t.expression(cs.Value)
+			for _, stmt := range cs.Body {
+				t.statement(fn, stmt)
+			}
+		}
+		t.pop()
+	case *ast.ReturnStatement:
+		t.expression(s.Value)
+	case *ast.ExprStatement:
+		t.expression(s.Expr)
+	}
+}
+
+func (t *symbolTable) expression(expr ast.Expression) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		t.refs = append(t.refs, symbolRef{e.Pos, e.Name, t.lookup(e.Name)})
+	case *ast.BinaryOp:
+		t.expression(e.Left)
+		t.expression(e.Right)
+	case *ast.UnaryOp:
+		t.expression(e.Operand)
+	case *ast.IndexExpr:
+		t.expression(e.Array)
+		t.expression(e.Index)
+	case *ast.Assignment:
+		t.expression(e.Target)
+		t.expression(e.Value)
+	case *ast.CallExpr:
+		t.expression(e.Callee)
+		for _, arg := range e.Args {
+			t.expression(arg)
+		}
+	}
+}
+
+// refAt returns the reference whose name covers pos, or nil.
+func (t *symbolTable) refAt(pos lexer.Position) *symbolRef {
+	for i, ref := range t.refs {
+		if ref.pos.Line == pos.Line && ref.pos.Column <= pos.Column && pos.Column < ref.pos.Column+len(ref.name) {
+			return &t.refs[i]
+		}
+	}
+	return nil
+}
+
+// paramType returns the type param was declared with, an array where its
+// type is the pointer it is adjusted to.
+func paramType(param *ast.Parameter) *ast.Type {
+	if param.Written != nil {
+		return param.Written
+	}
+	return param.Type
+}
+
+// functionDeclaration returns the C declaration of fn, such as
+// static int check(char *input, int n).
+func functionDeclaration(fn *ast.Function) string {
+	params := []string{}
+	for _, param := range fn.Params {
+		params = append(params, parser.Declarator(paramType(param), param.Name))
+	}
+	decl := parser.Declarator(fn.ReturnType, fn.Name+"("+strings.Join(params, ", ")+")")
+	if fn.Static {
+		decl = "static " + decl
+	}
+	return decl
+}
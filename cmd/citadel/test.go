@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/report"
+)
+
+// goldenOutputs are the outputs citadel test compares, by the suffix of
+// their golden files, in the order it compares them.
+var goldenOutputs = []string{".ll.golden", ".findings.golden"}
+
+// cmdTest implements citadel test, which compiles each C file and compares
+// its IR and findings with the golden files beside it: x.ll.golden and
+// x.findings.golden for x.c. With -update it writes the golden files
+// instead.
+func cmdTest() (*flag.FlagSet, func(args []string)) {
+	var opts codegen.Options
+	fs := newFlagSet("test", "<input.c|dir|dir/...|pattern>...")
+	update := fs.Bool("update", false, "write the golden files from the current output instead of comparing them")
+	o1 := fs.Bool("O1", false, "compile with -O1")
+	plugins := fs.String("plugin", "", "comma-separated Go plugins that register more analysis passes, to test their rules")
+	verbose := fs.Bool("v", false, "also list the tests that pass")
+	color := colorFlag(fs)
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		if fs.NArg() == 0 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		if *plugins != "" {
+			for _, path := range strings.Split(*plugins, ",") {
+				if err := analysis.LoadPlugin(path); err != nil {
+					fmt.Fprintf(os.Stderr, "Error loading plugin: %v\n", err)
+					os.Exit(exitUsage)
+				}
+			}
+		}
+		if *o1 {
+			opts.OptLevel = 1
+		}
+		paths, err := expandInputs(fs.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		if len(paths) == 0 {
+			fmt.Fprintf(os.Stderr, "No C files match %s\n", strings.Join(fs.Args(), " "))
+			os.Exit(exitUsage)
+		}
+
+		failed := 0
+		sources := map[string]string{}
+		renderer := newErrorRenderer(useColor(*color), sources)
+		for _, path := range paths {
+			if path == "-" {
+				fmt.Fprintln(os.Stderr, "Error: a test cannot read the standard input")
+				os.Exit(exitUsage)
+			}
+			outputs, err := testOutputs(path, opts, sources)
+			if err != nil {
+				fmt.Printf("FAIL %s\n", path)
+				renderer.write(os.Stdout, err)
+				failed++
+				continue
+			}
+			if *update {
+				for _, suffix := range goldenOutputs {
+					golden := strings.TrimSuffix(path, ".c") + suffix
+					if err := os.WriteFile(golden, []byte(outputs[suffix]), 0644); err != nil {
+						fmt.Fprintf(os.Stderr, "Error writing golden file: %v\n", err)
+						os.Exit(exitUsage)
+					}
+				}
+				fmt.Printf("updated %s\n", path)
+				continue
+			}
+			if !compareGolden(path, outputs, *verbose) {
+				failed++
+			}
+		}
+		switch {
+		case *update:
+		case failed > 0:
+			fmt.Printf("FAIL: %d of %s\n", failed, plural(len(paths), "test"))
+			os.Exit(exitUsage)
+		default:
+			fmt.Printf("ok: %s\n", plural(len(paths), "test"))
+		}
+	}
+}
+
+// testOutputs compiles the C file at path and returns its IR and findings
+// by the suffix of their golden files, recording its source in sources
+// for errors to quote. They name the file by its base name, so that they
+// do not depend on where the tests are run from.
+func testOutputs(path string, opts codegen.Options, sources map[string]string) (map[string]string, error) {
+	name := filepath.Base(path)
+	input, err := readSource(path)
+	if err != nil {
+		return nil, stageErrorf("io", name, "Error reading input file: %w", err)
+	}
+	sources[name] = input
+	lex, program, err := parse(name, input)
+	if err != nil {
+		return nil, stageErrorf("parser", name, "Parse error: %w", err)
+	}
+	opts.SourceFile, opts.Source = name, input
+	ir, err := codegen.NewWithOptions(opts).Generate(program)
+	if err != nil {
+		return nil, codegenError(name, err)
+	}
+	findings, err := defaultFindings(context.Background(), path, program, input, lex, "", logging.Discard, nil)
+	if err != nil {
+		return nil, stageErrorf("analysis", name, "Analysis error: %w", err)
+	}
+	var b bytes.Buffer
+	if err := writeFindings(&b, []report.File{{Path: name, Source: input, Findings: findings}}, false); err != nil {
+		return nil, err
+	}
+	return map[string]string{".ll.golden": ir, ".findings.golden": b.String()}, nil
+}
+
+// compareGolden compares the outputs of the test of the C file at path
+// with its golden files, printing a diff of those that differ, and
+// reports whether they all match. A test needs at least one golden file.
+func compareGolden(path string, outputs map[string]string, verbose bool) bool {
+	var diff bytes.Buffer
+	compared := 0
+	for _, suffix := range goldenOutputs {
+		golden := strings.TrimSuffix(path, ".c") + suffix
+		want, err := os.ReadFile(golden)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(&diff, "Error reading golden file: %v\n", err)
+			continue
+		}
+		compared++
+		if got := outputs[suffix]; got != string(want) {
+			writeDiff(&diff, golden, "golden", "actual", string(want), got)
+		}
+	}
+	switch {
+	case compared == 0:
+		fmt.Printf("FAIL %s\nNo golden files; run with -update to write them\n", path)
+		return false
+	case diff.Len() > 0:
+		fmt.Printf("FAIL %s\n%s", path, diff.String())
+		return false
+	}
+	if verbose {
+		fmt.Printf("ok   %s\n", path)
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// timeReport adds up the time each step of a command takes and the
+// memory it allocates, for -time-report. A nil report measures nothing,
+// so the steps can be measured whether or not one was asked for.
+type timeReport struct {
+	start time.Time
+	mu    sync.Mutex
+	steps []string // in the order they first ran
+	stats map[string]*stepStats
+}
+
+type stepStats struct {
+	runs      int
+	elapsed   time.Duration
+	allocated uint64
+}
+
+func newTimeReport() *timeReport {
+	return &timeReport{start: time.Now(), stats: map[string]*stepStats{}}
+}
+
+// measure runs f as the named step. Steps run more than once, such as
+// for each file or function, are added up.
+func (r *timeReport) measure(step string, f func()) {
+	if r == nil {
+		f()
+		return
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	f()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.stats[step]
+	if stats == nil {
+		stats = &stepStats{}
+		r.stats[step] = stats
+		r.steps = append(r.steps, step)
+	}
+	stats.runs++
+	stats.elapsed += elapsed
+	stats.allocated += after.TotalAlloc - before.TotalAlloc
+}
+
+// measureAnalysis returns the analysis.Config.Measure hook recording each
+// pass as a step of its own, or nil for a nil report.
+func (r *timeReport) measureAnalysis() func(pass string, run func()) {
+	if r == nil {
+		return nil
+	}
+	return func(pass string, run func()) { r.measure("analysis: "+pass, run) }
+}
+
+// write writes the report to w: each step with its share of the time
+// since the report was made, then the peak memory of the process and
+// notes on how to read the steps.
+func (r *timeReport) write(w io.Writer, notes ...string) {
+	if r == nil {
+		return
+	}
+	total := time.Since(r.start)
+	fmt.Fprintln(w, "Time report:")
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "  step\truns\ttime\tshare\tallocated")
+	for _, step := range r.steps {
+		stats := r.stats[step]
+		fmt.Fprintf(tw, "  %s\t%d\t%v\t%.1f%%\t%s\n", step, stats.runs, stats.elapsed.Round(time.Microsecond),
+			100*float64(stats.elapsed)/float64(total), formatBytes(stats.allocated))
+	}
+	fmt.Fprintf(tw, "  total\t\t%v\t100.0%%\n", total.Round(time.Microsecond))
+	tw.Flush()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintf(w, "  peak memory: %s\n", formatBytes(mem.Sys))
+	for _, note := range notes {
+		fmt.Fprintf(w, "  %s\n", note)
+	}
+}
+
+// lexNote explains the lex step of a time report.
+const lexNote = "lex is timed on its own; parse lexes again as it reads the tokens"
+
+// lexStep measures lexing input on its own as the lex step of r, if r is
+// not nil; the parser lexes as it goes, so it cannot be told apart there.
+func (r *timeReport) lexStep(input string) {
+	if r == nil {
+		return
+	}
+	r.measure("lex", func() {
+		for l := lexer.New(input); l.NextToken().Type != lexer.EOF; {
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// cmdTokens implements citadel tokens, which prints the tokens of one C
+// file, one per line with its position, type and text.
+func cmdTokens() (*flag.FlagSet, func(args []string)) {
+	fs := newFlagSet("tokens", "<input.c>")
+	comments := fs.Bool("comments", false, "also print the comments the lexer skips, after the tokens")
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		path := inputArg(fs)
+
+		illegal, err := writeTokens(os.Stdout, sourceName(path), readInput(path), *comments)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing tokens: %v\n", err)
+			os.Exit(1)
+		}
+		if illegal {
+			os.Exit(exitParse)
+		}
+	}
+}
+
+// writeTokens writes the tokens of input, the source of file, to w, and
+// then its comments if asked to. It reports whether any token is
+// illegal. The lexer is run on its own, so the tokens are written even
+// if the input does not parse.
+func writeTokens(w io.Writer, file, input string, comments bool) (bool, error) {
+	lex := lexer.New(input)
+	illegal := false
+	for {
+		tok := lex.NextToken()
+		if _, err := fmt.Fprintf(w, "%s:%s\t%-12s %q\n", file, tok.Pos, tok.Type, tok.Literal); err != nil {
+			return illegal, err
+		}
+		illegal = illegal || tok.Type == lexer.ILLEGAL
+		if tok.Type == lexer.EOF {
+			break
+		}
+	}
+	if comments {
+		for _, comment := range lex.Comments() {
+			if _, err := fmt.Fprintf(w, "%s:%s\t%-12s %q\n", file, comment.Pos, "COMMENT", comment.Text); err != nil {
+				return illegal, err
+			}
+		}
+	}
+	return illegal, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llvmc"
+)
+
+// cFeatures lists what the C subset citadel accepts covers, for bug
+// reports to show against what their input uses.
+var cFeatures = []string{
+	"types: char, short, int, long, float, double, pointers, arrays, function pointers",
+	"functions: definitions, prototypes, static, __attribute__((...))",
+	"statements: declarations, if, while, switch/case/default, break, return, expressions, __asm__",
+	"operators: = || && == < > + - * / % unary - and *, indexing, calls",
+	"literals: decimal integers, strings",
+	"not supported: for and do loops, continue, else, structs, unions, enums, typedefs, the preprocessor",
+}
+
+// cmdVersion implements citadel version, which prints what a bug report
+// about an install needs: the release and the commit it was built from,
+// the C subset it accepts, the LLVM releases its IR works with, and the
+// rules check reports.
+func cmdVersion() (*flag.FlagSet, func(args []string)) {
+	fs := newFlagSet("version", "")
+	plugins := fs.String("plugin", "", "comma-separated Go plugins whose rules to list too, as check -plugin loads them")
+	return fs, func(args []string) {
+		parseFlags(fs, args)
+		if fs.NArg() != 0 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		if *plugins != "" {
+			for _, path := range strings.Split(*plugins, ",") {
+				if err := analysis.LoadPlugin(path); err != nil {
+					fmt.Fprintf(os.Stderr, "Error loading plugin: %v\n", err)
+					os.Exit(exitUsage)
+				}
+			}
+		}
+
+		fmt.Printf("citadel version %s\n", codegen.Version)
+		fmt.Printf("commit: %s\n", buildCommit())
+		fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		fmt.Printf("LLVM IR: %s\n", codegen.IRCompatibility)
+		fmt.Printf("targets: x86_64-*, aarch64-*, riscv64-*, wasm32-* (default %s)\n", codegen.DefaultTriple)
+		if llvmc.Available {
+			fmt.Printf("backends: text, llir, llvm\n")
+		} else {
+			fmt.Printf("backends: text, llir\n")
+		}
+		fmt.Printf("C subset:\n")
+		for _, feature := range cFeatures {
+			fmt.Printf("  %s\n", feature)
+		}
+		// Every rule is enabled unless a policy or project file or -disable
+		// turns it off
+		rules := analysis.Rules()
+		fmt.Printf("rules (%d, all enabled by default):\n", len(rules))
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for _, rule := range rules {
+			fmt.Fprintf(w, "  %s\tCWE-%d\n", rule.ID, rule.CWE)
+		}
+		w.Flush()
+	}
+}
+
+// buildCommit returns the commit citadel was built from, as the go tool
+// records it when building in a git checkout, or "unknown".
+func buildCommit() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	settings := map[string]string{}
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	commit := settings["vcs.revision"]
+	if commit == "" {
+		return "unknown"
+	}
+	if t := settings["vcs.time"]; t != "" {
+		commit += " (" + t + ")"
+	}
+	if settings["vcs.modified"] == "true" {
+		commit += ", with local changes"
+	}
+	return commit
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileStamp is what watchInputs compares to tell that a file changed.
+type fileStamp struct {
+	modTime int64 // in nanoseconds since the Unix epoch
+	size    int64
+	missing bool
+}
+
+// watchInputs runs build, and runs it again whenever one of the files at
+// paths changes, looking for changes every interval until the process is
+// interrupted. After each run it logs to status the files that changed,
+// whether build failed, which it reports itself, and how long it took, so
+// a failing edit does not end the session.
+// The C subset has no #include, so the inputs are all a build reads.
+func watchInputs(paths []string, interval time.Duration, build func() error, status *slog.Logger) {
+	stamps := stampFiles(paths)
+	run := func(changed []string) {
+		start := time.Now()
+		msg, failed := "built", "build failed"
+		var attrs []interface{}
+		if len(changed) > 0 {
+			msg, failed = "rebuilt", "rebuild failed"
+			attrs = append(attrs, "file", strings.Join(changed, ","))
+		}
+		if err := build(); err != nil {
+			msg = failed
+		}
+		attrs = append(attrs, "duration", time.Since(start).Round(time.Microsecond), "watching", len(paths))
+		status.Info(msg, attrs...)
+	}
+	run(nil)
+	for {
+		time.Sleep(interval)
+		current := stampFiles(paths)
+		var changed []string
+		for _, path := range paths {
+			if current[path] != stamps[path] {
+				changed = append(changed, path)
+			}
+		}
+		stamps = current
+		if len(changed) > 0 {
+			run(changed)
+		}
+	}
+}
+
+// stampFiles returns the stamps of the files at paths. A file that does
+// not exist, as while an editor replaces it, has a stamp saying so.
+func stampFiles(paths []string) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			stamps[path] = fileStamp{missing: true}
+			continue
+		}
+		stamps[path] = fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+	}
+	return stamps
+}
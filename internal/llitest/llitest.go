@@ -0,0 +1,44 @@
+// Package llitest runs LLVM IR with lli, for the tests that check what
+// the code the backends generate does
+package llitest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run runs ir, as text or bitcode, with lli and returns its exit status
+// and what it wrote to standard output. The test is skipped when there
+// is no lli on PATH, and fails when lli cannot run ir
+func Run(t testing.TB, ir string) (status int, stdout string) {
+	t.Helper()
+	lli, err := exec.LookPath("lli")
+	if err != nil {
+		t.Skip(err)
+	}
+	name := "a.ll"
+	if strings.HasPrefix(ir, "BC\xC0\xDE") {
+		name = "a.bc"
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(ir), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(lli, path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), string(out)
+	}
+	if err != nil {
+		t.Fatalf("lli %s: %v\n%s", name, err, stderr.Bytes())
+	}
+	return 0, string(out)
+}
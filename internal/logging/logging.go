@@ -0,0 +1,140 @@
+// Package logging provides the loggers the other packages trace their
+// work through. They take a *slog.Logger in their options, so any slog
+// handler can receive the records; NewHandler writes them as the plain
+// lines the command line tool prints.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// LevelTrace is below slog.LevelDebug, for the detail of each function
+// and pass
+const LevelTrace = slog.LevelDebug - 4
+
+// Discard is a logger that drops every record
+var Discard = slog.New(discardHandler{})
+
+// Or returns logger, or Discard if it is nil
+func Or(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return Discard
+	}
+	return logger
+}
+
+// Level returns the lowest level logged at a verbosity: -1 for quiet,
+// which leaves errors alone, 0 by default for progress and warnings, 1
+// for debugging detail such as timings and decisions, and 2 or more for
+// tracing every function and pass
+func Level(verbosity int) slog.Level {
+	switch {
+	case verbosity < 0:
+		return slog.LevelError
+	case verbosity == 0:
+		return slog.LevelInfo
+	case verbosity == 1:
+		return slog.LevelDebug
+	}
+	return LevelTrace
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// Handler writes records as lines of text: the message and then the
+// attributes as key=value, with warnings prefixed "Warning: " and debug
+// and trace records "debug: " and "trace: ". It is safe for concurrent
+// use
+type Handler struct {
+	w      io.Writer
+	level  slog.Leveler
+	mu     *sync.Mutex
+	attrs  string // the attributes added with WithAttrs, formatted
+	prefix string // the groups opened with WithGroup, each followed by a dot
+}
+
+// NewHandler returns a Handler writing the records at level or above to w
+func NewHandler(w io.Writer, level slog.Leveler) *Handler {
+	return &Handler{w: w, level: level, mu: &sync.Mutex{}}
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelDebug:
+		b.WriteString("trace: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("debug: ")
+	}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&b, h.prefix, attr)
+		return true
+	})
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, attr := range attrs {
+		writeAttr(&b, h.prefix, attr)
+	}
+	c := *h
+	c.attrs += b.String()
+	return &c
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix += name + "."
+	return &c
+}
+
+// writeAttr writes attr as " key=value", quoting values with spaces and
+// flattening groups into dotted keys
+func writeAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			writeAttr(b, prefix, member)
+		}
+		return
+	}
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	text := value.String()
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		text = fmt.Sprintf("%q", text)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, attr.Key, text)
+}
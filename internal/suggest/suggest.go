@@ -0,0 +1,50 @@
+// Package suggest finds, for a name that means nothing where it is used,
+// the name the author most likely meant, for errors to ask "did you mean"
+package suggest
+
+// Closest returns the candidate nearest to name, or "" when none is near
+// enough to be a likely misspelling. A misspelling is a few characters
+// inserted, deleted, changed or swapped with the next, one for names
+// shorter than 8 characters and one more for each 8 after that. Of
+// candidates as near as each other, the first in sorted order is returned
+func Closest(name string, candidates []string) string {
+	best, bestDist := "", len(name)/8+2
+	for _, c := range candidates {
+		if c == name || len(c) < 2 {
+			continue
+		}
+		d := Distance(name, c)
+		if d < bestDist || d == bestDist && best != "" && c < best {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// Distance is the number of characters to insert, delete, change or swap
+// with the next to turn a into b
+func Distance(a, b string) int {
+	// Three rows of the table of distances between prefixes: two back, the
+	// one before and the one being filled
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	row := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				row[j] = min(row[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, row = prev, row, prev2
+	}
+	return prev[len(b)]
+}
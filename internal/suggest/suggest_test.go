@@ -0,0 +1,56 @@
+package suggest
+
+import "testing"
+
+// TestDistance checks the edits counted between names, a swap of
+// neighbours being one
+func TestDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"count", "count", 0},
+		{"", "abc", 3},
+		{"count", "cuont", 1},
+		{"count", "coun", 1},
+		{"count", "counts", 1},
+		{"count", "mount", 1},
+		{"retrun", "return", 1},
+		{"abc", "cba", 2},
+		{"kitten", "sitting", 3},
+	} {
+		if got := Distance(test.a, test.b); got != test.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := Distance(test.b, test.a); got != test.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
+
+// TestClosest checks which candidate is suggested: the nearest within the
+// edits a name of its length allows, the first in sorted order of those
+// as near, and none for the name itself or one-letter names
+func TestClosest(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		candidates []string
+		want       string
+	}{
+		{"cuont", []string{"main", "count", "buf"}, "count"},
+		{"retrun", []string{"return", "while"}, "return"},
+		{"cnt", []string{"count"}, ""}, // two edits for a short name
+		{"lenght_of_buf", []string{"length_of_buf"}, "length_of_buf"},
+		{"buffer_size_x", []string{"buffer_sizes"}, "buffer_sizes"}, // two edits for 8 characters or more
+		{"bat", []string{"cat", "bar", "hat"}, "bar"},
+		{"x", []string{"x", "y"}, ""},
+		{"count", []string{"count"}, ""},
+		{"n", []string{"m"}, ""},
+		{"name", nil, ""},
+	} {
+		if got := Closest(test.name, test.candidates); got != test.want {
+			t.Errorf("Closest(%q, %q) = %q, want %q", test.name, test.candidates, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Package analysis looks for security weaknesses in parsed programs. Its
+// checks are Passes kept in a registry that other packages can add to.
+// Most inspect one function at a time; the taint check follows data
+// from the sources it is configured with across calls, to its sinks.
+// Each reports what it finds as Findings.
+//
+// Within a function, the flow-sensitive checks run the body of a while
+// loop until the state at its head settles before they report on it;
+// the value ranges widen it to a fixed point, as they do the returns of
+// a recursion cycle. Loops whose condition nothing in them changes are
+// reported as infinite, and the taint check treats a loop condition as a
+// sink, for loop bounds taken from input.
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/symexec"
+)
+
+// Severity ranks how serious a finding is
+type Severity int
+
+const (
+	Info Severity = iota
+	Low
+	Medium
+	High
+	Critical
+)
+
+var severityNames = []string{"info", "low", "medium", "high", "critical"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// MarshalText encodes a severity by name
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity name
+func (s *Severity) UnmarshalText(text []byte) error {
+	severity, err := ParseSeverity(string(text))
+	if err == nil {
+		*s = severity
+	}
+	return err
+}
+
+// ParseSeverity returns the severity called name
+func ParseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if n == name {
+			return Severity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q", name)
+}
+
+// Finding is one weakness reported by a check
+type Finding struct {
+	Rule     string // identifier of the check, e.g. "dangerous-call"
+	Severity Severity
+	Function string // function the finding is in
+	Pos      lexer.Position
+	End      lexer.Position // after the call or statement it is about, or zero
+	Message  string
+	CWE      int // Common Weakness Enumeration identifier, or 0
+	// Suggestion names a safer alternative, or is empty
+	Suggestion string
+	// Trace is the path data took to the finding, oldest step first, for
+	// findings about data flow
+	Trace []TraceStep
+	// Suppressed is the comment that silences the finding, or nil
+	Suppressed *Suppression
+	// Fingerprint identifies the finding across edits to its file, once
+	// SetFingerprints has set it
+	Fingerprint string
+}
+
+// FileFindings are the findings in one file
+type FileFindings struct {
+	File     string
+	Findings []Finding
+}
+
+// TraceStep is one step of a data-flow trace
+type TraceStep struct {
+	Pos     lexer.Position
+	Message string
+}
+
+func (f Finding) String() string {
+	message := f.Message
+	if name := CWEName(f.CWE); name != "" {
+		message += fmt.Sprintf(" (CWE-%d: %s)", f.CWE, name)
+	} else if f.CWE != 0 {
+		message += fmt.Sprintf(" (CWE-%d)", f.CWE)
+	}
+	return fmt.Sprintf("%s: %s: %s [%s in %s]", f.Pos, f.Severity, message, f.Rule, f.Function)
+}
+
+// Diagnostic returns the finding, in file, as a diagnostic of the analysis
+// stage. A suggestion becomes a fix to apply by hand
+func (f Finding) Diagnostic(file string) diag.Diagnostic {
+	end := f.End
+	if end.Line == 0 {
+		end = f.Pos
+	}
+	d := diag.Diagnostic{
+		File:       file,
+		Pos:        f.Pos,
+		End:        end,
+		Severity:   f.Severity.String(),
+		Stage:      "analysis",
+		Rule:       f.Rule,
+		Message:    f.Message,
+		Suppressed: f.Suppressed != nil,
+	}
+	if f.Suggestion != "" {
+		d.Fixes = []diag.Fix{{Message: f.Suggestion}}
+	}
+	return d
+}
+
+// check reports the findings of one analysis in a function
+type check func(fn *ast.Function, unit *Unit) []Finding
+
+// Config holds the settings of the checks that take any
+type Config struct {
+	Taint *TaintConfig
+	// Banned adds functions for the dangerous-call check to report
+	Banned []BannedFunction
+	// Disabled names the rules whose findings are not reported
+	Disabled map[string]bool
+	// Severities overrides the severity of every finding of the rules it
+	// names
+	Severities map[string]Severity
+	// Symbolic bounds the symbolic execution that confirms or rules out
+	// the array-bounds and division-by-zero findings, or is nil to report
+	// them as the range analysis finds them
+	Symbolic *symexec.Options
+	// Logger traces the passes run, how long they take and what they
+	// find; nil logs nothing. It does not affect the findings
+	Logger *slog.Logger `json:"-"`
+	// Measure, if set, is given each pass to run, by name, so that the
+	// caller can time it or count what it allocates. It must call run
+	// once
+	Measure func(pass string, run func()) `json:"-"`
+	// Context, if set, has Analyze stop once it is done, with its error.
+	// A pass stops between functions and symbolic execution takes no
+	// more paths, so the findings would be incomplete
+	Context context.Context `json:"-"`
+	// Hooks, if set, are told of the passes as they run and of the
+	// findings. They do not affect the findings
+	Hooks *Hooks `json:"-"`
+	// Facts, if set, has the summaries of functions analyzed before,
+	// which Analyze uses rather than compute them again, and to which it
+	// adds those of the functions this program defines. Those of
+	// functions that reach a cycle of mutually recursive functions are
+	// left out, as they depend on which function of the cycle was
+	// summarized first
+	Facts *Facts `json:"-"`
+}
+
+// DefaultConfig returns the settings Analyze uses when given none
+func DefaultConfig() *Config {
+	symbolic := symexec.DefaultOptions
+	return &Config{Taint: DefaultTaintConfig(), Symbolic: &symbolic}
+}
+
+// Analyze runs the registered passes over program and returns their
+// findings in source order. A nil config uses DefaultConfig. Passes all
+// of whose rules are disabled do not run, unless another pass requires
+// them
+func Analyze(program *ast.Program, config *Config) ([]Finding, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	order, err := schedule()
+	if err != nil {
+		return nil, err
+	}
+	needed := map[string]bool{}
+	for i := len(order) - 1; i >= 0; i-- {
+		pass := order[i]
+		for _, rule := range pass.Rules() {
+			needed[pass.Name()] = needed[pass.Name()] || !config.Disabled[rule.ID]
+		}
+		if needed[pass.Name()] {
+			for _, name := range pass.Requires() {
+				needed[name] = true
+			}
+		}
+	}
+	// Sources, sinks and sanitizers the program declares add to those
+	// configured
+	taint, err := config.Taint.withAnnotations(program)
+	if err != nil {
+		return nil, err
+	}
+	if taint != config.Taint {
+		extended := *config
+		extended.Taint = taint
+		config = &extended
+	}
+	unit := &Unit{Program: program, Config: config, results: map[string][]Finding{}}
+	findings := []Finding{}
+	log := logging.Or(config.Logger)
+	hooks := config.Hooks
+	if hooks == nil {
+		hooks = &Hooks{}
+	}
+	total, n := 0, 0
+	for _, pass := range order {
+		if needed[pass.Name()] {
+			total++
+		}
+	}
+	for _, pass := range order {
+		if err := unit.canceled(); err != nil {
+			return nil, err
+		}
+		if !needed[pass.Name()] {
+			log.Debug("skipped pass, its rules are disabled", "pass", pass.Name())
+			continue
+		}
+		n++
+		if hooks.OnPassStart != nil {
+			hooks.OnPassStart(pass.Name(), n, total)
+		}
+		unit.pass = pass.Name()
+		start := time.Now()
+		// A pass that panics fails the analysis with an *diag.InternalError
+		// naming it, rather than the program running it
+		var panicked error
+		run := func() {
+			defer diag.Recover("analysis pass "+pass.Name(), &panicked)
+			unit.results[pass.Name()] = pass.Run(unit)
+		}
+		if config.Measure != nil {
+			config.Measure(pass.Name(), run)
+		} else {
+			run()
+		}
+		if panicked != nil {
+			return nil, panicked
+		}
+		if err := unit.canceled(); err != nil {
+			return nil, err
+		}
+		elapsed := time.Since(start)
+		if hooks.OnPassEnd != nil {
+			hooks.OnPassEnd(pass.Name(), unit.results[pass.Name()], elapsed)
+		}
+		findings = append(findings, unit.results[pass.Name()]...)
+		log.Debug("ran pass", "pass", pass.Name(), "findings", len(unit.results[pass.Name()]), "elapsed", elapsed)
+	}
+	// A pass can report under several rules, so disabled rules are
+	// dropped from what the passes report
+	enabled := findings[:0]
+	for _, f := range findings {
+		if !config.Disabled[f.Rule] {
+			enabled = append(enabled, f)
+		}
+	}
+	findings = enabled
+	// Findings that name no weakness, such as taint reaching a sink
+	// configured without one, are the weakness of their rule. Then the
+	// configured severities apply
+	for i := range findings {
+		if rule := LookupRule(findings[i].Rule); findings[i].CWE == 0 && rule != nil {
+			findings[i].CWE = rule.CWE
+		}
+		if severity, ok := config.Severities[findings[i].Rule]; ok {
+			findings[i].Severity = severity
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i].Pos, findings[j].Pos
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	if config.Facts != nil {
+		for _, name := range unit.settledSummaries() {
+			if config.Facts.Has(name) {
+				continue
+			}
+			if err := config.Facts.Add(unit.Summary(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if hooks.OnFinding != nil {
+		for _, f := range findings {
+			hooks.OnFinding(f)
+		}
+	}
+	return findings, nil
+}
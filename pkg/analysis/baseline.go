@@ -0,0 +1,156 @@
+package analysis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Baseline records findings already known in a codebase, so that only
+// findings introduced since are reported. Findings are matched by
+// fingerprint rather than position, so they stay matched when code above
+// them moves
+type Baseline struct {
+	Findings []BaselineEntry `json:"findings"`
+}
+
+// BaselineEntry is one known finding. Only the fingerprint is matched; the
+// rest is for people reading the file
+type BaselineEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	File        string `json:"file"`
+	Rule        string `json:"rule"`
+	Function    string `json:"function"`
+	Line        int    `json:"line"`
+	Message     string `json:"message"`
+}
+
+// positions matches the line:column positions messages mention
+var positions = regexp.MustCompile(`\b\d+:\d+\b`)
+
+// spaces matches white space, which fingerprints ignore
+var spaces = regexp.MustCompile(`\s+`)
+
+// Fingerprint identifies a finding in file by its rule, its function, its
+// message without the positions it mentions, and the text of the line it
+// is on without its comment or white space. Edits elsewhere in the file,
+// and reformatting the line, leave it unchanged
+func Fingerprint(file, source string, f Finding) string {
+	line := ""
+	if lines := strings.Split(source, "\n"); f.Pos.Line >= 1 && f.Pos.Line <= len(lines) {
+		line = lines[f.Pos.Line-1]
+		if i := strings.Index(line, "//"); i >= 0 && !strings.Contains(line[:i], `"`) {
+			line = line[:i]
+		}
+		line = spaces.ReplaceAllString(line, "")
+	}
+	h := sha256.New()
+	for _, part := range []string{file, f.Rule, f.Function, positions.ReplaceAllString(f.Message, ""), line} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// SetFingerprints sets the fingerprint of each finding in file
+func SetFingerprints(file, source string, findings []Finding) {
+	for i := range findings {
+		findings[i].Fingerprint = Fingerprint(file, source, findings[i])
+	}
+}
+
+// LoadBaseline reads a baseline from a JSON file
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	baseline := &Baseline{}
+	if err := json.Unmarshal(data, baseline); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return baseline, nil
+}
+
+// Save writes the baseline to a JSON file
+func (b *Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Record replaces what the baseline knows of file with its unsuppressed
+// findings
+func (b *Baseline) Record(file, source string, findings []Finding) {
+	kept := []BaselineEntry{}
+	for _, entry := range b.Findings {
+		if entry.File != file {
+			kept = append(kept, entry)
+		}
+	}
+	for _, f := range findings {
+		if f.Suppressed != nil {
+			continue
+		}
+		kept = append(kept, BaselineEntry{
+			Fingerprint: Fingerprint(file, source, f),
+			File:        file,
+			Rule:        f.Rule,
+			Function:    f.Function,
+			Line:        f.Pos.Line,
+			Message:     f.Message,
+		})
+	}
+	b.Findings = kept
+}
+
+// Filter returns the findings in file the baseline does not know, and the
+// number it does. A fingerprint recorded n times matches n findings
+func (b *Baseline) Filter(file, source string, findings []Finding) (fresh []Finding, known int) {
+	remaining := map[string]int{}
+	for _, entry := range b.Findings {
+		if entry.File == file {
+			remaining[entry.Fingerprint]++
+		}
+	}
+	fresh = []Finding{}
+	for _, f := range findings {
+		fp := Fingerprint(file, source, f)
+		if f.Suppressed == nil && remaining[fp] > 0 {
+			remaining[fp]--
+			known++
+			continue
+		}
+		fresh = append(fresh, f)
+	}
+	return fresh, known
+}
+
+// Fixed returns the entries of the baseline for file that no finding
+// matches any more: the findings fixed since it was recorded
+func (b *Baseline) Fixed(file, source string, findings []Finding) []BaselineEntry {
+	found := map[string]int{}
+	for _, f := range findings {
+		if f.Suppressed == nil {
+			found[Fingerprint(file, source, f)]++
+		}
+	}
+	fixed := []BaselineEntry{}
+	for _, entry := range b.Findings {
+		if entry.File != file {
+			continue
+		}
+		if found[entry.Fingerprint] > 0 {
+			found[entry.Fingerprint]--
+			continue
+		}
+		fixed = append(fixed, entry)
+	}
+	return fixed
+}
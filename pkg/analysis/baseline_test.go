@@ -0,0 +1,52 @@
+package analysis
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestBaseline checks that a baseline saved and loaded back filters the
+// findings it recorded, even once the code above them moves, reports
+// those that are new, and lists those fixed since
+func TestBaseline(t *testing.T) {
+	const before = "int main() {\n  int z = 0;\n  return 1 / z;\n}\n"
+	findings := analyze(t, before)
+	if len(findings) == 0 {
+		t.Fatalf("%s: no findings to record", before)
+	}
+	baseline := &Baseline{}
+	baseline.Record("a.c", before, findings)
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := baseline.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baseline.Findings) != len(findings) {
+		t.Fatalf("loaded %d entries, want %d", len(baseline.Findings), len(findings))
+	}
+
+	for _, test := range []struct {
+		src                 string
+		fresh, known, fixed int
+	}{
+		{before, 0, len(findings), 0},
+		{"\n\nint main() {\n  int z = 0;\n\n  return 1 / z;\n}\n", 0, len(findings), 0},
+		{before + "int g() {\n  int x;\n  return x;\n}\n", 1, len(findings), 0},
+		{"int main() {\n  int z = 2;\n  return 1 / z;\n}\n", 0, 0, len(findings)},
+	} {
+		findings := analyze(t, test.src)
+		fresh, known := baseline.Filter("a.c", test.src, findings)
+		fixed := baseline.Fixed("a.c", test.src, findings)
+		if len(fresh) != test.fresh || known != test.known || len(fixed) != test.fixed {
+			t.Errorf("%q: %d fresh, %d known, %d fixed, want %d, %d, %d",
+				test.src, len(fresh), known, len(fixed), test.fresh, test.known, test.fixed)
+		}
+	}
+
+	if fresh, known := baseline.Filter("b.c", before, findings); len(fresh) != len(findings) || known != 0 {
+		t.Errorf("another file: %d fresh, %d known, want %d, 0", len(fresh), known, len(findings))
+	}
+}
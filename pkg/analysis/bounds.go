@@ -0,0 +1,76 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/symexec"
+)
+
+// checkArrayBounds reports subscripts of arrays whose index, given the
+// values it can hold, can fall outside the declared bounds. Writes are
+// reported as out-of-bounds writes and reads as out-of-bounds reads,
+// along with the branches on the way to the subscript, and with the
+// inputs that lead there when symbolic execution finds them. The subset
+// has no unsigned types, so comparisons of signed with unsigned operands
+// cannot arise; indexes checked against the upper bound only, which can
+// still be negative, are reported under a rule of their own
+func checkArrayBounds(fn *ast.Function, unit *Unit) []Finding {
+	ranges := unit.ranges(fn)
+	candidates := []candidate{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		index, ok := expr.(*ast.IndexExpr)
+		if !ok || ranges.access[index] == nil {
+			return
+		}
+		access := ranges.access[index]
+		value, ok := ranges.values[index.Index]
+		bounds := newInterval(0, int64(access.array.Len)-1)
+		if !ok || bounds.Contains(value) {
+			return
+		}
+
+		finding := Finding{
+			Rule:       "array-bounds",
+			Function:   fn.Name,
+			Pos:        stmt.Position(),
+			End:        stmt.EndPosition(),
+			CWE:        125,
+			Suggestion: fmt.Sprintf("check that the index is at least 0 and less than %d", access.array.Len),
+		}
+		kind := "read"
+		if access.write {
+			kind, finding.CWE = "write", 787
+		}
+		if !bounds.Overlaps(value) {
+			finding.Severity = Critical
+			finding.Message = fmt.Sprintf("%s %ss outside %s: the index is %s", exprString(index), kind, access.array, value)
+		} else {
+			finding.Severity = High
+			finding.Message = fmt.Sprintf("%s can %s outside %s: the index ranges over %s", exprString(index), kind, access.array, value)
+		}
+		if len(access.path) > 0 {
+			finding.Message += " when " + strings.Join(access.path, " and ")
+		}
+		candidates = append(candidates, candidate{finding, symexec.Query{
+			Expr:       index.Index,
+			Conditions: []symexec.Condition{{Op: "<", Value: 0}, {Op: ">", Value: int64(access.array.Len) - 1}},
+		}})
+		// Integers are signed, so checking only the upper bound, as is
+		// enough for an unsigned index, leaves negative indexes through
+		if len(access.path) > 0 && value.Hi.Cmp(bounds.Hi) <= 0 && value.Lo.Sign() < 0 {
+			candidates = append(candidates, candidate{Finding{
+				Rule:       "signed-index",
+				Severity:   Medium,
+				Function:   fn.Name,
+				Pos:        stmt.Position(),
+				End:        stmt.EndPosition(),
+				CWE:        839,
+				Message:    fmt.Sprintf("the index of %s is checked against the upper bound of %s only, when %s, but is signed and can be negative: it ranges over %s", exprString(index), access.array, strings.Join(access.path, " and "), value),
+				Suggestion: "check that the index is at least 0 as well",
+			}, symexec.Query{Expr: index.Index, Conditions: []symexec.Condition{{Op: "<", Value: 0}}}})
+		}
+	})
+	return confirm(fn, unit, candidates)
+}
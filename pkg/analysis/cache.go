@@ -0,0 +1,147 @@
+package analysis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// Cache keeps the findings of the files analyzed before in a directory,
+// one file per entry, so that a file analyzed again unchanged, with the
+// same rules and configuration, is not analyzed again. Beside them, under
+// a key of the source alone, it keeps the summaries of the functions of
+// each file, which no setting changes, for a file analyzed again
+// unchanged under other rules to start from. Syntax trees are not kept:
+// decoding one takes longer than parsing the source again
+type Cache struct {
+	Dir string
+}
+
+// OpenCache returns the cache in dir, creating the directory if need be
+func OpenCache(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// Key returns the key of the findings in source under config: a hash of
+// the source, the configuration, the registered passes and rules, and the
+// Citadel version, any change to which can change the findings
+func (c *Cache) Key(source string, config *Config) (string, error) {
+	settings, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(codegen.Version))
+	h.Write([]byte{0})
+	for _, pass := range Passes() {
+		h.Write([]byte(pass.Name()))
+		for _, rule := range pass.Rules() {
+			h.Write([]byte{0})
+			h.Write([]byte(rule.ID))
+		}
+		h.Write([]byte{0})
+	}
+	h.Write(settings)
+	h.Write([]byte{0})
+	h.Write([]byte(source))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SourceKey returns the key of the summaries of the functions in source:
+// a hash of the source and the Citadel version
+func (c *Cache) SourceKey(source string) string {
+	h := sha256.New()
+	h.Write([]byte(codegen.Version))
+	h.Write([]byte{0})
+	h.Write([]byte(source))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// Load returns the findings stored under key, and whether there were any.
+// An entry that cannot be read counts as missing
+func (c *Cache) Load(key string) ([]Finding, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	findings := []Finding{}
+	if err := json.Unmarshal(data, &findings); err != nil {
+		return nil, false
+	}
+	return findings, true
+}
+
+// Store records findings under key
+func (c *Cache) Store(key string, findings []Finding) error {
+	data, err := json.Marshal(findings)
+	if err != nil {
+		return err
+	}
+	return c.write(c.path(key), data)
+}
+
+// write writes an entry to a temporary file first and renames it into
+// place, so that concurrent runs never read half of one
+func (c *Cache) write(path string, data []byte) error {
+	tmp, err := os.CreateTemp(c.Dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSummaries returns the summaries stored under key, a SourceKey, in
+// a store for Config.Facts, and whether there were any
+func (c *Cache) LoadSummaries(key string) (*Facts, bool) {
+	data, err := os.ReadFile(filepath.Join(c.Dir, key+".summaries.gob"))
+	if err != nil {
+		return nil, false
+	}
+	var summaries []*Summary
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&summaries); err != nil {
+		return nil, false
+	}
+	facts := NewFacts("", 0)
+	for _, s := range summaries {
+		if err := facts.Add(s); err != nil {
+			return nil, false
+		}
+	}
+	return facts, true
+}
+
+// StoreSummaries records the summaries in facts, which Analyze filled in,
+// under key, a SourceKey
+func (c *Cache) StoreSummaries(key string, facts *Facts) error {
+	summaries := []*Summary{}
+	for _, name := range facts.Names() {
+		summaries = append(summaries, facts.Lookup(name))
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(summaries); err != nil {
+		return err
+	}
+	return c.write(filepath.Join(c.Dir, key+".summaries.gob"), buf.Bytes())
+}
@@ -0,0 +1,172 @@
+package analysis
+
+import (
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// CallGraph records which of the functions a program defines call which.
+// Calls through function pointers are not followed
+type CallGraph struct {
+	Functions []string            // defined functions, in source order
+	Calls     map[string][]string // callees of each function, in order of first call
+}
+
+// BuildCallGraph returns the call graph of the functions program defines
+func BuildCallGraph(program *ast.Program) *CallGraph {
+	g := &CallGraph{Calls: map[string][]string{}}
+	for _, fn := range program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		g.Functions = append(g.Functions, fn.Name)
+		seen := map[string]bool{}
+		inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+			call, ok := expr.(*ast.CallExpr)
+			if !ok {
+				return
+			}
+			name := calledFunction(fn, call)
+			if definesFunction(program, name) && !seen[name] {
+				seen[name] = true
+				g.Calls[fn.Name] = append(g.Calls[fn.Name], name)
+			}
+		})
+	}
+	return g
+}
+
+// Roots returns the functions no other function calls, in source order
+func (g *CallGraph) Roots() []string {
+	called := map[string]bool{}
+	for caller, callees := range g.Calls {
+		for _, callee := range callees {
+			called[callee] = called[callee] || callee != caller
+		}
+	}
+	roots := []string{}
+	for _, fn := range g.Functions {
+		if !called[fn] {
+			roots = append(roots, fn)
+		}
+	}
+	return roots
+}
+
+// Cycles returns the groups of mutually recursive functions, including
+// functions that call themselves, each in source order (Tarjan's strongly
+// connected components)
+func (g *CallGraph) Cycles() [][]string {
+	order := map[string]int{}
+	for i, fn := range g.Functions {
+		order[fn] = i
+	}
+	index, low := map[string]int{}, map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var cycles [][]string
+	var connect func(fn string)
+	connect = func(fn string) {
+		index[fn] = len(index)
+		low[fn] = index[fn]
+		stack = append(stack, fn)
+		onStack[fn] = true
+		for _, callee := range g.Calls[fn] {
+			if _, visited := index[callee]; !visited {
+				connect(callee)
+				if low[callee] < low[fn] {
+					low[fn] = low[callee]
+				}
+			} else if onStack[callee] && index[callee] < low[fn] {
+				low[fn] = index[callee]
+			}
+		}
+		if low[fn] != index[fn] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == fn {
+				break
+			}
+		}
+		if len(component) > 1 || g.calls(fn, fn) {
+			// Source order
+			for i := 1; i < len(component); i++ {
+				for j := i; j > 0 && order[component[j]] < order[component[j-1]]; j-- {
+					component[j], component[j-1] = component[j-1], component[j]
+				}
+			}
+			cycles = append(cycles, component)
+		}
+	}
+	for _, fn := range g.Functions {
+		if _, visited := index[fn]; !visited {
+			connect(fn)
+		}
+	}
+	return cycles
+}
+
+// calls reports whether caller calls callee directly
+func (g *CallGraph) calls(caller, callee string) bool {
+	for _, c := range g.Calls[caller] {
+		if c == callee {
+			return true
+		}
+	}
+	return false
+}
+
+// DeepestChain returns the chain of calls from root that uses the most
+// stack, given the frame size of each function, and the stack it uses.
+// When a chain from root can recurse, it returns that chain, ending with
+// the function called again, and recursive is true
+func (g *CallGraph) DeepestChain(root string, frames map[string]int) (chain []string, total int, recursive bool) {
+	type result struct {
+		chain []string
+		total int
+	}
+	done := map[string]result{}
+	active := map[string]bool{}
+	var cycle []string
+	var visit func(fn string) result
+	visit = func(fn string) result {
+		if r, ok := done[fn]; ok {
+			return r
+		}
+		active[fn] = true
+		best := result{}
+		for _, callee := range g.Calls[fn] {
+			if cycle != nil {
+				break
+			}
+			if active[callee] {
+				cycle = []string{fn, callee}
+				break
+			}
+			if r := visit(callee); r.total > best.total {
+				best = r
+			}
+		}
+		active[fn] = false
+		if cycle != nil {
+			// Unwind, prefixing the callers that led into the cycle
+			if cycle[0] != fn {
+				cycle = append([]string{fn}, cycle...)
+			}
+			return result{}
+		}
+		r := result{append([]string{fn}, best.chain...), frames[fn] + best.total}
+		done[fn] = r
+		return r
+	}
+	r := visit(root)
+	if cycle != nil {
+		return cycle, 0, true
+	}
+	return r.chain, r.total, false
+}
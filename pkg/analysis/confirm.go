@@ -0,0 +1,48 @@
+package analysis
+
+import (
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/symexec"
+)
+
+// candidate is a finding the range analysis suspects, with the values of
+// an expression that would make it real
+type candidate struct {
+	finding Finding
+	query   symexec.Query
+}
+
+// confirm settles candidates in fn by executing it symbolically. Those no
+// path can reach with such a value are dropped; those some path provably
+// does are told the inputs that lead there; the rest are kept as they are
+func confirm(fn *ast.Function, unit *Unit, candidates []candidate) []Finding {
+	findings := []Finding{}
+	if len(candidates) == 0 {
+		return findings
+	}
+	if unit.Config.Symbolic == nil {
+		for _, c := range candidates {
+			findings = append(findings, c.finding)
+		}
+		return findings
+	}
+	queries := make([]symexec.Query, len(candidates))
+	for i, c := range candidates {
+		queries[i] = c.query
+	}
+	options := *unit.Config.Symbolic
+	options.Context = unit.Config.Context
+	answers := symexec.Check(unit.Program, fn, queries, options)
+	for i, c := range candidates {
+		switch answers[i].Verdict {
+		case symexec.Infeasible:
+			continue
+		case symexec.Feasible:
+			if len(answers[i].Witness) > 0 {
+				c.finding.Message += "; it does when " + answers[i].Witness.String()
+			}
+		}
+		findings = append(findings, c.finding)
+	}
+	return findings
+}
@@ -0,0 +1,97 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// checkConversions reports implicit conversions that can lose
+// information: integers converted to a narrower type that cannot hold
+// every value they can have, and floating-point values converted to an
+// integer type. Conversions into a variable later used as an allocation
+// size or an array index are more severe. Library functions returning
+// size_t are typed int in the subset, so their results are not narrowed
+func checkConversions(fn *ast.Function, unit *Unit) []Finding {
+	ranges := unit.ranges(fn)
+	uses := sizeUses(fn)
+	// into maps the value of each assignment to a variable to the variable
+	into := map[ast.Expression]string{}
+	findings := []Finding{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		if a, ok := expr.(*ast.Assignment); ok {
+			if id, ok := a.Target.(*ast.Identifier); ok {
+				into[a.Value] = id.Name
+			}
+		}
+		if decl, ok := stmt.(*ast.VarDecl); ok && expr == decl.Value {
+			into[expr] = decl.Name
+		}
+		c := ranges.conversions[expr]
+		if c == nil {
+			return
+		}
+		full := typeRange(c.to)
+		finding := Finding{
+			Rule:     "lossy-conversion",
+			Severity: Low,
+			Function: fn.Name,
+			Pos:      stmt.Position(),
+			End:      stmt.EndPosition(),
+		}
+		switch {
+		case c.from.IsFloating():
+			finding.CWE = 681
+			finding.Message = fmt.Sprintf("%s is converted from %s to %s, which drops its fraction and is undefined when it is out of range", exprString(expr), c.from, c.to)
+			finding.Suggestion = "round the value explicitly and check that it is in range first"
+		case full.Contains(c.value):
+			return
+		default:
+			finding.CWE = 197
+			finding.Message = fmt.Sprintf("%s is converted from %s to %s, which cannot hold all of %s", exprString(expr), c.from, c.to, c.value)
+			if !full.Overlaps(c.value) {
+				finding.Severity = Medium
+				finding.Message = fmt.Sprintf("%s is converted from %s to %s, which cannot hold %s", exprString(expr), c.from, c.to, c.value)
+			}
+			finding.Suggestion = fmt.Sprintf("check that the value is between %s and %s, or keep it in a %s", full.Lo, full.Hi, c.from)
+		}
+		if name, ok := into[expr]; ok && uses[name] != "" {
+			finding.Severity = Medium
+			finding.Message += fmt.Sprintf("; %s is then used as %s", name, uses[name])
+		}
+		findings = append(findings, finding)
+	})
+	return findings
+}
+
+// sizeUses returns the variables fn uses in allocation sizes or array
+// indexes, with what they are used as
+func sizeUses(fn *ast.Function) map[string]string {
+	uses := map[string]string{}
+	mark := func(stmt ast.Statement, expr ast.Expression, use string) {
+		inspectExpression(stmt, expr, func(_ ast.Statement, e ast.Expression) {
+			if id, ok := e.(*ast.Identifier); ok && uses[id.Name] == "" {
+				uses[id.Name] = use
+			}
+		})
+	}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		switch e := expr.(type) {
+		case *ast.CallExpr:
+			name := calledFunction(fn, e)
+			if !allocators[name] {
+				return
+			}
+			args := e.Args
+			if name == "realloc" && len(args) > 0 {
+				args = args[1:]
+			}
+			for _, arg := range args {
+				mark(stmt, arg, "an allocation size")
+			}
+		case *ast.IndexExpr:
+			mark(stmt, e.Index, "an array index")
+		}
+	})
+	return uses
+}
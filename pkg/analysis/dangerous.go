@@ -0,0 +1,141 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// BannedFunction is a C library function that is unsafe to call, or
+// unsafe to call carelessly
+type BannedFunction struct {
+	Function string   `json:"function"`
+	Severity Severity `json:"severity"`
+	CWE      int      `json:"cwe"`
+	// Problem says what is wrong with calling it, e.g. "copies without
+	// checking the size of the destination"
+	Problem string `json:"problem"`
+	// Alternative names what to call instead
+	Alternative string `json:"alternative"`
+}
+
+// dangerousFunction is a BannedFunction without its name
+type dangerousFunction struct {
+	severity    Severity
+	cwe         int
+	problem     string
+	alternative string
+}
+
+// dangerousFunctions are the library functions the dangerous-call check
+// reports unless configured otherwise
+var dangerousFunctions = map[string]dangerousFunction{
+	"gets":     {Critical, 242, "reads a line of any length into a fixed-size buffer", "fgets with the buffer size"},
+	"strcpy":   {High, 120, "copies without checking the size of the destination", "strncpy or strlcpy with the destination size"},
+	"strcat":   {High, 120, "appends without checking the space left in the destination", "strncat or strlcat with the destination size"},
+	"sprintf":  {High, 120, "formats into a buffer without bounding the output", "snprintf with the buffer size"},
+	"vsprintf": {High, 120, "formats into a buffer without bounding the output", "vsnprintf with the buffer size"},
+	"system":   {Medium, 78, "runs a command through the shell, which interprets its metacharacters", "execve or posix_spawn with a fixed argument vector"},
+	"popen":    {Medium, 78, "runs a command through the shell, which interprets its metacharacters", "pipe with fork and execve"},
+	"tmpnam":   {Medium, 377, "returns a predictable name that another process can create first", "mkstemp"},
+	"tempnam":  {Medium, 377, "returns a predictable name that another process can create first", "mkstemp"},
+	"mktemp":   {Medium, 377, "returns a predictable name that another process can create first", "mkstemp"},
+	"strtok":   {Low, 676, "keeps hidden state between calls and is not reentrant", "strtok_r"},
+	"atoi":     {Low, 676, "cannot report invalid or out-of-range input", "strtol with error checking"},
+}
+
+// scanfFormats maps the scanf family to the index of their format argument
+var scanfFormats = map[string]int{"scanf": 0, "sscanf": 1, "fscanf": 1}
+
+// checkDangerousCalls reports calls to library functions that are prone
+// to buffer overflows, command injection or races, or that banned names,
+// and scanf-family calls whose format reads strings without a field width.
+// A banned function replaces the built-in entry of the same name
+func checkDangerousCalls(fn *ast.Function, unit *Unit, banned []BannedFunction) []Finding {
+	dangerous := dangerousFunctions
+	if len(banned) > 0 {
+		dangerous = map[string]dangerousFunction{}
+		for name, danger := range dangerousFunctions {
+			dangerous[name] = danger
+		}
+		for _, b := range banned {
+			dangerous[b.Function] = dangerousFunction{b.Severity, b.CWE, b.Problem, b.Alternative}
+		}
+	}
+	findings := []Finding{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return
+		}
+		name := calledFunction(fn, call)
+		if name == "" || unit.defines(name) {
+			return
+		}
+		if danger, ok := dangerous[name]; ok {
+			finding := Finding{
+				Rule:     "dangerous-call",
+				Severity: danger.severity,
+				Function: fn.Name,
+				Pos:      call.Pos,
+				End:      call.End,
+				Message:  fmt.Sprintf("%s %s", name, danger.problem),
+				CWE:      danger.cwe,
+			}
+			if danger.problem == "" {
+				finding.Message = fmt.Sprintf("%s is banned", name)
+			}
+			if danger.alternative != "" {
+				finding.Suggestion = "use " + danger.alternative
+			}
+			findings = append(findings, finding)
+		}
+		if i, ok := scanfFormats[name]; ok && i < len(call.Args) {
+			format, ok := call.Args[i].(*ast.StringLiteral)
+			if ok && hasUnboundedString(format.Value) {
+				findings = append(findings, Finding{
+					Rule:       "dangerous-call",
+					Severity:   High,
+					Function:   fn.Name,
+					Pos:        call.Pos,
+					End:        call.End,
+					Message:    fmt.Sprintf("%s reads a string of any length with %%s or %%[ and no field width", name),
+					CWE:        120,
+					Suggestion: "give the conversion a field width one less than the buffer size, e.g. %63s",
+				})
+			}
+		}
+	})
+	return findings
+}
+
+// hasUnboundedString reports whether a scanf format stores a string
+// conversion (%s or %[) without a maximum field width
+func hasUnboundedString(format string) bool {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		suppressed := i < len(format) && format[i] == '*'
+		if suppressed {
+			i++
+		}
+		width := false
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			width = true
+			i++
+		}
+		// Length modifiers
+		for i < len(format) && (format[i] == 'h' || format[i] == 'l' || format[i] == 'L' || format[i] == 'j' || format[i] == 'z' || format[i] == 't') {
+			i++
+		}
+		if i < len(format) && (format[i] == 's' || format[i] == '[') && !width && !suppressed {
+			return true
+		}
+	}
+	return false
+}
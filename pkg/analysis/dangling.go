@@ -0,0 +1,632 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// allocation is memory pointers can point into: a heap block or a local
+// array
+type allocation struct {
+	pos   lexer.Position
+	end   lexer.Position // after the declaration or call, if known
+	what  string         // e.g. "the block malloc allocates" or "local array buf"
+	local bool           // a local array, which does not outlive the function
+}
+
+// death is how an allocation came to end
+type death struct {
+	pos      lexer.Position
+	how      string // e.g. "freed" or "out of scope"
+	definite bool   // ended on every path, not only some
+}
+
+// lifetimeEnv is what a path knows about the allocations pointers refer
+// to. A nil env stands for a path that cannot be reached
+type lifetimeEnv struct {
+	points map[string]int // pointers and arrays to the allocations they refer to
+	dead   map[int]death  // allocations that have ended
+	// released holds the heap blocks the function need not free: those
+	// stored, passed or returned somewhere it cannot follow, and those
+	// whose allocation failed on the path
+	released map[int]bool
+}
+
+func newLifetimeEnv() *lifetimeEnv {
+	return &lifetimeEnv{points: map[string]int{}, dead: map[int]death{}, released: map[int]bool{}}
+}
+
+func (e *lifetimeEnv) copy() *lifetimeEnv {
+	if e == nil {
+		return nil
+	}
+	c := newLifetimeEnv()
+	for name, id := range e.points {
+		c.points[name] = id
+	}
+	for id, d := range e.dead {
+		c.dead[id] = d
+	}
+	for id := range e.released {
+		c.released[id] = true
+	}
+	return c
+}
+
+// joinLifetimes merges two paths that meet. A pointer refers to what it
+// refers to on either, an allocation ended on either may have ended, and
+// one released on either is taken to be released
+func joinLifetimes(a, b *lifetimeEnv) *lifetimeEnv {
+	if a == nil {
+		return b.copy()
+	}
+	if b == nil {
+		return a.copy()
+	}
+	j := newLifetimeEnv()
+	for id := range a.released {
+		j.released[id] = true
+	}
+	for id := range b.released {
+		j.released[id] = true
+	}
+	for name, id := range b.points {
+		j.points[name] = id
+	}
+	for name, id := range a.points {
+		j.points[name] = id
+	}
+	for id, d := range a.dead {
+		other, ok := b.dead[id]
+		d.definite = d.definite && ok && other.definite
+		j.dead[id] = d
+	}
+	for id, d := range b.dead {
+		if _, ok := a.dead[id]; !ok {
+			d.definite = false
+			j.dead[id] = d
+		}
+	}
+	return j
+}
+
+// lifetimes is a pass over one function that follows which allocation
+// each pointer refers to and reports pointers used after the allocation
+// ends, and heap blocks the function does not free or hand on
+type lifetimes struct {
+	fn       *ast.Function
+	program  *ast.Program
+	unit     *Unit
+	types    map[string]*ast.Type
+	allocs   []allocation
+	sites    map[allocation]int // ids of the allocations
+	reported map[int]bool
+	findings []Finding
+	// exit joins the envs at the return statements, and returnedAllocs
+	// holds the allocations returned pointers refer into
+	exit           *lifetimeEnv
+	returnedAllocs map[int]bool
+	// leaks is set to report leaks; leaked holds the blocks reported
+	leaks  bool
+	leaked map[int]bool
+}
+
+// checkDanglingPointers reports pointers used after free, pointers into
+// local arrays used after the block declaring the array ends, functions
+// returning a pointer into one of their local arrays, heap blocks freed
+// twice, and heap blocks allocated but neither freed nor handed on by the
+// time the function returns. A block whose allocation failed needs no freeing; using one is
+// for the null-dereference check to report
+func checkDanglingPointers(fn *ast.Function, unit *Unit) []Finding {
+	l := &lifetimes{
+		fn:             fn,
+		program:        unit.Program,
+		unit:           unit,
+		types:          map[string]*ast.Type{},
+		sites:          map[allocation]int{},
+		reported:       map[int]bool{},
+		findings:       []Finding{},
+		returnedAllocs: map[int]bool{},
+		leaks:          true,
+		leaked:         map[int]bool{},
+	}
+	for _, param := range fn.Params {
+		l.types[param.Name] = param.Type
+	}
+	out, _ := l.statements(fn.Body.Statements, newLifetimeEnv(), false)
+	if out != nil {
+		l.leak(nil, out)
+	}
+	return l.findings
+}
+
+// statements runs stmts from in and returns the env at their end and the
+// env joined over the break statements among them. When stmts form a
+// scope, the arrays they declare end with it
+func (l *lifetimes) statements(stmts []ast.Statement, in *lifetimeEnv, scope bool) (out, broke *lifetimeEnv) {
+	var declared []*ast.VarDecl
+	for _, stmt := range stmts {
+		if in == nil {
+			break
+		}
+		switch s := stmt.(type) {
+		case *ast.Block:
+			var b *lifetimeEnv
+			in, b = l.statements(s.Statements, in, true)
+			broke = joinLifetimes(broke, b)
+		case *ast.VarDecl:
+			l.types[s.Name] = s.Type
+			declared = append(declared, s)
+			delete(in.points, s.Name)
+			if s.Type.Kind == ast.ArrayType {
+				in.points[s.Name] = l.allocate(s.Pos, s.End, "local array "+s.Name, true, in)
+			} else if s.Value != nil {
+				l.expression(s, s.Value, in)
+				l.assign(s.Name, s.Value, in)
+			}
+		case *ast.IfStatement:
+			then, els := l.condition(s, s.Condition, in)
+			if s.ThenBlock != nil {
+				var b *lifetimeEnv
+				then, b = l.statements(s.ThenBlock.Statements, then, true)
+				broke = joinLifetimes(broke, b)
+			}
+			if s.ElseBlock != nil {
+				var b *lifetimeEnv
+				els, b = l.statements(s.ElseBlock.Statements, els, true)
+				broke = joinLifetimes(broke, b)
+			}
+			in = joinLifetimes(then, els)
+		case *ast.WhileStatement:
+			then, els := l.condition(s, s.Condition, l.loopHead(s, in))
+			_, b := l.statements(s.Body.Statements, then, true)
+			in = joinLifetimes(els, b)
+		case *ast.SwitchStatement:
+			l.expression(s, s.Tag, in)
+			var after, fall *lifetimeEnv
+			hasDefault := false
+			for _, cs := range s.Cases {
+				hasDefault = hasDefault || cs.Value == nil
+				out, b := l.statements(cs.Body, joinLifetimes(in.copy(), fall), false)
+				after = joinLifetimes(after, b)
+				fall = out
+			}
+			after = joinLifetimes(after, fall)
+			if !hasDefault {
+				after = joinLifetimes(after, in)
+			}
+			in = after
+		case *ast.BreakStatement:
+			broke = joinLifetimes(broke, in)
+			in = nil
+		case *ast.ReturnStatement:
+			if s.Value != nil {
+				l.expression(s, s.Value, in)
+				l.use(s, s.Value, in)
+				l.returned(s, in)
+			}
+			l.leak(s, in)
+			l.exit = joinLifetimes(l.exit, in)
+			in = nil
+		case *ast.ExprStatement:
+			l.expression(s, s.Expr, in)
+		}
+	}
+	if scope && in != nil {
+		for _, decl := range declared {
+			if id, ok := in.points[decl.Name]; ok && decl.Type.Kind == ast.ArrayType {
+				in.dead[id] = death{decl.Pos, "out of scope once the block declaring it ends", true}
+			}
+			delete(in.points, decl.Name)
+		}
+	}
+	return in, broke
+}
+
+// condition evaluates a branch condition and returns the envs on the
+// paths where it is true and where it is false
+func (l *lifetimes) condition(stmt ast.Statement, cond ast.Expression, in *lifetimeEnv) (then, els *lifetimeEnv) {
+	l.expression(stmt, cond, in)
+	then, els = in.copy(), in.copy()
+	// A block is not allocated where the pointer to it is null
+	if id := l.nullOn(cond, in); id >= 0 {
+		then.released[id] = true
+	} else if id := l.nullOn(&ast.BinaryOp{Left: cond, Operator: "==", Right: &ast.IntLiteral{}}, in); id >= 0 {
+		els.released[id] = true
+	}
+	return then, els
+}
+
+// loopHead returns the env at the head of a loop entered with in, where
+// the end of the body joins the entry. The rounds that find it report
+// nothing. A block the body allocates is not yet one on entry, so it
+// ends there as it does at the end of the body, and a block freed on
+// every round is not taken for one freed on some paths only
+func (l *lifetimes) loopHead(s *ast.WhileStatement, in *lifetimeEnv) *lifetimeEnv {
+	head := in
+	for round := 0; round < loopRounds; round++ {
+		quiet := *l
+		quiet.findings, quiet.reported, quiet.leaks = nil, map[int]bool{}, false
+		then, _ := quiet.condition(s, s.Condition, head.copy())
+		out, _ := quiet.statements(s.Body.Statements, then, true)
+		l.allocs = quiet.allocs
+		next := joinLifetimes(unborn(in, out), out)
+		if sameLifetimes(next, head) {
+			break
+		}
+		head = next
+	}
+	return head
+}
+
+// unborn returns entry with the ends, in out, of the allocations entry
+// knows nothing of: those made after it, which had not begun there
+func unborn(entry, out *lifetimeEnv) *lifetimeEnv {
+	if out == nil {
+		return entry
+	}
+	known := map[int]bool{}
+	for _, id := range entry.points {
+		known[id] = true
+	}
+	entry = entry.copy()
+	for id, d := range out.dead {
+		if _, ok := entry.dead[id]; !ok && !known[id] && !entry.released[id] {
+			entry.dead[id] = d
+		}
+	}
+	for id := range out.released {
+		if _, ok := entry.dead[id]; !ok && !known[id] {
+			entry.released[id] = true
+		}
+	}
+	return entry
+}
+
+// sameLifetimes reports whether a and b know the same of the same
+// allocations
+func sameLifetimes(a, b *lifetimeEnv) bool {
+	if len(a.points) != len(b.points) || len(a.dead) != len(b.dead) || len(a.released) != len(b.released) {
+		return false
+	}
+	for name, id := range a.points {
+		if other, ok := b.points[name]; !ok || other != id {
+			return false
+		}
+	}
+	for id, d := range a.dead {
+		if other, ok := b.dead[id]; !ok || other.definite != d.definite {
+			return false
+		}
+	}
+	for id := range a.released {
+		if !b.released[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// allocate returns the id of an allocation, recording it the first time
+// it is made. One made again, by a loop, starts out alive in in
+func (l *lifetimes) allocate(pos, end lexer.Position, what string, local bool, in *lifetimeEnv) int {
+	alloc := allocation{pos, end, what, local}
+	id, ok := l.sites[alloc]
+	if !ok {
+		l.allocs = append(l.allocs, alloc)
+		id = len(l.allocs) - 1
+		l.sites[alloc] = id
+	}
+	delete(in.dead, id)
+	delete(in.released, id)
+	return id
+}
+
+// assign records the allocation a pointer local refers to after it is
+// assigned value
+func (l *lifetimes) assign(name string, value ast.Expression, in *lifetimeEnv) {
+	if typ := l.types[name]; typ == nil || typ.Kind != ast.PointerType {
+		return
+	}
+	delete(in.points, name)
+	if call, ok := value.(*ast.CallExpr); ok {
+		callee := calledFunction(l.fn, call)
+		if !l.unit.defines(callee) {
+			if allocators[callee] {
+				in.points[name] = l.allocate(call.Pos, call.End, "the block "+callee+" allocates", false, in)
+			}
+			return
+		}
+		// A function that returns one of its arguments passes on what
+		// the argument refers to; one that allocates, a new block
+		if summary := l.unit.Summary(callee); summary != nil && len(summary.ReturnsParams) == 0 && summary.Allocates {
+			in.points[name] = l.allocate(call.Pos, call.End, "the block "+callee+" allocates", false, in)
+			return
+		}
+	}
+	if id := l.pointsTo(value, in); id >= 0 {
+		in.points[name] = id
+	}
+}
+
+// pointsTo returns the allocation a pointer expression refers into, or -1
+func (l *lifetimes) pointsTo(expr ast.Expression, in *lifetimeEnv) int {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if id, ok := in.points[e.Name]; ok {
+			return id
+		}
+	case *ast.BinaryOp:
+		// Pointer arithmetic stays within the allocation
+		if e.Operator == "+" || e.Operator == "-" {
+			if id := l.pointsTo(e.Left, in); id >= 0 {
+				return id
+			}
+			if e.Operator == "+" {
+				return l.pointsTo(e.Right, in)
+			}
+		}
+	case *ast.CallExpr:
+		callee := calledFunction(l.fn, e)
+		if !l.unit.defines(callee) {
+			break
+		}
+		if summary := l.unit.Summary(callee); summary != nil {
+			for _, i := range summary.ReturnsParams {
+				if i < len(e.Args) {
+					if id := l.pointsTo(e.Args[i], in); id >= 0 {
+						return id
+					}
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// expression reports the uses of dangling pointers in expr and follows
+// its assignments and calls to free
+func (l *lifetimes) expression(stmt ast.Statement, expr ast.Expression, in *lifetimeEnv) {
+	switch e := expr.(type) {
+	case *ast.BinaryOp:
+		l.expression(stmt, e.Left, in)
+		l.expression(stmt, e.Right, in)
+	case *ast.UnaryOp:
+		l.expression(stmt, e.Operand, in)
+		if e.Operator == "*" {
+			l.use(stmt, e.Operand, in)
+		}
+	case *ast.IndexExpr:
+		l.expression(stmt, e.Array, in)
+		l.expression(stmt, e.Index, in)
+		l.use(stmt, e.Array, in)
+	case *ast.Assignment:
+		l.expression(stmt, e.Value, in)
+		if target, ok := e.Target.(*ast.Identifier); ok {
+			l.assign(target.Name, e.Value, in)
+		} else {
+			l.expression(stmt, e.Target, in)
+			// Stored in memory, the pointer is out of sight
+			l.release(e.Value, in)
+		}
+	case *ast.CallExpr:
+		for _, arg := range e.Args {
+			l.expression(stmt, arg, in)
+		}
+		name := calledFunction(l.fn, e)
+		if name == "free" && !l.unit.defines(name) && len(e.Args) == 1 {
+			l.free(e, e.Args[0], "freed", true, in)
+			return
+		}
+		// A function the program defines that frees its argument ends
+		// what the argument refers to, as free does. Otherwise it may keep
+		// what the argument refers to
+		var summary *Summary
+		if l.unit.defines(name) {
+			summary = l.unit.Summary(name)
+		}
+		for i, arg := range e.Args {
+			if _, frees := summary.freesArg(i); !frees {
+				l.use(stmt, arg, in)
+			}
+		}
+		// Library functions do not keep the pointers they are passed,
+		// except realloc, which takes over the block
+		if !l.unit.defines(name) {
+			if name == "realloc" && len(e.Args) > 0 || name == "" {
+				for _, arg := range e.Args {
+					l.release(arg, in)
+				}
+			}
+			return
+		}
+		for i, arg := range e.Args {
+			if definite, frees := summary.freesArg(i); frees {
+				l.free(e, arg, "freed by "+name, definite, in)
+			} else {
+				l.release(arg, in)
+			}
+		}
+	}
+}
+
+// free ends the heap block ptr refers into, as call does, and reports
+// call if the block may already be freed. Each allocation is reported
+// once
+func (l *lifetimes) free(call *ast.CallExpr, ptr ast.Expression, how string, definite bool, in *lifetimeEnv) {
+	id := l.pointsTo(ptr, in)
+	if id < 0 {
+		return
+	}
+	d, ok := in.dead[id]
+	if !ok || !d.definite {
+		in.dead[id] = death{call.Pos, how, definite || ok && d.definite}
+	}
+	if !ok || !strings.HasPrefix(d.how, "freed") || l.reported[id] {
+		return
+	}
+	l.reported[id] = true
+	alloc := l.allocs[id]
+	qualifier, severity := "is", High
+	if !d.definite || !definite {
+		qualifier, severity = "may be", Medium
+	}
+	twice := fmt.Sprintf("it is %s at %s and %s at %s", d.how, d.pos, how, call.Pos)
+	if d.pos == call.Pos {
+		// Only a loop comes back to the same call
+		twice = fmt.Sprintf("it is %s at %s on one round of the loop and again on the next", how, call.Pos)
+	}
+	l.findings = append(l.findings, Finding{
+		Rule:       "double-free",
+		Severity:   severity,
+		Function:   l.fn.Name,
+		Pos:        call.Pos,
+		End:        call.End,
+		Message:    fmt.Sprintf("%s refers into %s, which %s freed twice: %s", exprString(ptr), alloc.what, qualifier, twice),
+		CWE:        415,
+		Suggestion: "set the pointer to NULL after freeing it, and free each block on exactly one path",
+		Trace: []TraceStep{
+			{Pos: alloc.pos, Message: alloc.what + " starts here"},
+			{Pos: d.pos, Message: alloc.what + " is " + d.how},
+			{Pos: call.Pos, Message: "it is " + how + " again"},
+		},
+	})
+}
+
+// use reports ptr if the allocation it refers into has ended. Each
+// allocation is reported once
+func (l *lifetimes) use(stmt ast.Statement, ptr ast.Expression, in *lifetimeEnv) {
+	id := l.pointsTo(ptr, in)
+	d, ok := in.dead[id]
+	if id < 0 || !ok || l.reported[id] {
+		return
+	}
+	l.reported[id] = true
+	alloc := l.allocs[id]
+	finding := Finding{
+		Rule:     "dangling-pointer",
+		Severity: High,
+		Function: l.fn.Name,
+		Pos:      stmt.Position(),
+		End:      stmt.EndPosition(),
+		CWE:      416,
+		Trace: []TraceStep{
+			{Pos: alloc.pos, Message: alloc.what + " starts here"},
+			{Pos: d.pos, Message: alloc.what + " is " + d.how},
+			{Pos: stmt.Position(), Message: exprString(ptr) + " is used"},
+		},
+	}
+	if alloc.local {
+		finding.CWE = 825
+	}
+	qualifier := "is"
+	if !d.definite {
+		qualifier = "may be"
+		finding.Severity = Medium
+	}
+	finding.Message = fmt.Sprintf("%s refers into %s, which %s %s", exprString(ptr), alloc.what, qualifier, d.how)
+	if strings.HasPrefix(d.how, "freed") {
+		finding.Message += fmt.Sprintf(" at %s", d.pos)
+		finding.Suggestion = "set the pointer to NULL after freeing it, and free it after its last use"
+	} else {
+		finding.Suggestion = "declare the array in a scope enclosing every use of pointers into it"
+	}
+	l.findings = append(l.findings, finding)
+}
+
+// returned reports a return of a pointer into a local array of the
+// function, which no longer exists when the caller uses it
+func (l *lifetimes) returned(stmt *ast.ReturnStatement, in *lifetimeEnv) {
+	id := l.pointsTo(stmt.Value, in)
+	if id >= 0 {
+		l.returnedAllocs[id] = true
+		in.released[id] = true
+	}
+	if id < 0 || !l.allocs[id].local || l.reported[id] {
+		return
+	}
+	l.reported[id] = true
+	alloc := l.allocs[id]
+	l.findings = append(l.findings, Finding{
+		Rule:       "dangling-pointer",
+		Severity:   High,
+		Function:   l.fn.Name,
+		Pos:        stmt.Pos,
+		End:        stmt.End,
+		Message:    fmt.Sprintf("%s returns a pointer into %s, which ends when %s returns", l.fn.Name, alloc.what, l.fn.Name),
+		CWE:        562,
+		Suggestion: "allocate the array with malloc, or have the caller pass the buffer in",
+		Trace: []TraceStep{
+			{Pos: alloc.pos, Message: alloc.what + " is declared"},
+			{Pos: stmt.Pos, Message: "a pointer into it is returned"},
+		},
+	})
+}
+
+// release marks the heap block ptr refers into as handed on
+func (l *lifetimes) release(ptr ast.Expression, in *lifetimeEnv) {
+	if id := l.pointsTo(ptr, in); id >= 0 {
+		in.released[id] = true
+	}
+}
+
+// nullOn returns the heap block a condition of the form p == 0 or 0 == p
+// tests the allocation of, or -1
+func (l *lifetimes) nullOn(cond ast.Expression, in *lifetimeEnv) int {
+	b, ok := cond.(*ast.BinaryOp)
+	if !ok || b.Operator != "==" {
+		return -1
+	}
+	ptr, zero := b.Left, b.Right
+	if lit, ok := ptr.(*ast.IntLiteral); ok && lit.Value == 0 {
+		ptr, zero = zero, ptr
+	}
+	if lit, ok := zero.(*ast.IntLiteral); !ok || lit.Value != 0 {
+		return -1
+	}
+	if _, ok := ptr.(*ast.Identifier); !ok {
+		return -1
+	}
+	return l.pointsTo(ptr, in)
+}
+
+// leak reports the heap blocks the function allocated and has neither
+// freed nor handed on when it returns, by ret or, if ret is nil, by
+// reaching its end. Each block is reported once
+func (l *lifetimes) leak(ret *ast.ReturnStatement, in *lifetimeEnv) {
+	if !l.leaks {
+		return
+	}
+	for id, alloc := range l.allocs {
+		d, freed := in.dead[id]
+		if alloc.local || in.released[id] || freed && d.definite || l.leaked[id] {
+			continue
+		}
+		l.leaked[id] = true
+		exit := "the end of " + l.fn.Name
+		trace := []TraceStep{{Pos: alloc.pos, Message: alloc.what + " starts here"}}
+		if ret != nil {
+			exit = fmt.Sprintf("%s returns at %s", l.fn.Name, ret.Pos)
+			trace = append(trace, TraceStep{Pos: ret.Pos, Message: l.fn.Name + " returns without freeing it"})
+		}
+		finding := Finding{
+			Rule:       "memory-leak",
+			Severity:   Medium,
+			Function:   l.fn.Name,
+			Pos:        alloc.pos,
+			End:        alloc.end,
+			Message:    fmt.Sprintf("%s is not freed before %s", alloc.what, exit),
+			CWE:        401,
+			Suggestion: "free the block on every path once it is no longer needed",
+			Trace:      trace,
+		}
+		if freed {
+			finding.Severity = Low
+			finding.Message = fmt.Sprintf("%s may not be freed before %s: it is %s at %s only on some paths", alloc.what, exit, d.how, d.pos)
+		}
+		l.findings = append(l.findings, finding)
+	}
+}
@@ -0,0 +1,94 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/symexec"
+)
+
+// checkDivisionByZero reports integer divisions and remainders whose
+// divisor, given the values it can hold, is zero or cannot be shown to be
+// nonzero. A divisor that is always zero is an error; one that may be is
+// a warning. Symbolic execution rules out divisors that are never zero
+// on a feasible path
+func checkDivisionByZero(fn *ast.Function, unit *Unit) []Finding {
+	ranges := unit.ranges(fn)
+	candidates := []candidate{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		division, ok := expr.(*ast.BinaryOp)
+		if !ok || division.Operator != "/" && division.Operator != "%" {
+			return
+		}
+		// Both operands have values only when they are integers, and only
+		// when the division can be reached
+		_, ok = ranges.values[division.Left]
+		divisor, integer := ranges.values[division.Right]
+		if !ok || !integer || !divisor.ContainsValue(0) || ranges.nonzero[division.Right] {
+			return
+		}
+		finding := Finding{
+			Rule:       "division-by-zero",
+			Function:   fn.Name,
+			Pos:        stmt.Position(),
+			End:        stmt.EndPosition(),
+			CWE:        369,
+			Suggestion: fmt.Sprintf("check that %s is not zero first", exprString(division.Right)),
+		}
+		if divisor.IsConstant() {
+			finding.Severity = High
+			finding.Message = fmt.Sprintf("%s divides by zero", exprString(division))
+		} else {
+			finding.Severity = Medium
+			finding.Message = fmt.Sprintf("%s can divide by zero: %s ranges over %s", exprString(division), exprString(division.Right), divisor)
+		}
+		candidates = append(candidates, candidate{finding, symexec.Query{
+			Expr:       division.Right,
+			Conditions: []symexec.Condition{{Op: "==", Value: 0}},
+		}})
+	})
+	return confirm(fn, unit, candidates)
+}
+
+// ProveDivisions returns what the value ranges of the functions the
+// program defines prove of their reachable integer divisions and
+// remainders, for the code generator to skip the runtime checks of
+// those that cannot trap. Operands keep their values when converted to
+// the type of the division, so one that holds the minimum of no integer
+// type is never its minimum
+func ProveDivisions(program *ast.Program) map[*ast.BinaryOp]codegen.DivisionProof {
+	unit := &Unit{Program: program, Config: DefaultConfig(), results: map[string][]Finding{}}
+	proofs := map[*ast.BinaryOp]codegen.DivisionProof{}
+	for _, fn := range unit.Functions() {
+		ranges := unit.ranges(fn)
+		inspect(fn.Body.Statements, func(_ ast.Statement, expr ast.Expression) {
+			division, ok := expr.(*ast.BinaryOp)
+			if !ok || division.Operator != "/" && division.Operator != "%" {
+				return
+			}
+			dividend, ok := ranges.values[division.Left]
+			divisor, integer := ranges.values[division.Right]
+			if !ok || !integer {
+				return
+			}
+			proof := codegen.DivisionProof{
+				NonzeroDivisor: !divisor.ContainsValue(0) || ranges.nonzero[division.Right],
+				NoOverflow:     !divisor.ContainsValue(-1),
+			}
+			if !proof.NoOverflow {
+				proof.NoOverflow = true
+				for _, min := range []int64{math.MinInt8, math.MinInt16, math.MinInt32, math.MinInt64} {
+					if dividend.ContainsValue(min) {
+						proof.NoOverflow = false
+					}
+				}
+			}
+			if proof.NonzeroDivisor || proof.NoOverflow {
+				proofs[division] = proof
+			}
+		})
+	}
+	return proofs
+}
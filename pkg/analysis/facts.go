@@ -0,0 +1,130 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Facts holds the summaries of functions analyzed before, for Analyze to
+// use in place of computing them again: at calls to them from a program
+// that only declares them, as when a large file is analyzed a function
+// at a time, or from the same program analyzed again, as Cache keeps
+// them. Summaries are
+// kept in memory until they take Limit bytes, as encoded, and the later
+// ones are written to a temporary file and read back when a call needs
+// them. A Limit of 0 keeps them all in memory.
+//
+// Only summaries cross from one program to the next: the taint check
+// does not follow data into a function defined in an earlier one, and a
+// recursion through functions in different programs is not reported
+type Facts struct {
+	Limit int64
+	// Dir is where the spill file is created, or the default directory
+	// for temporary files if empty
+	Dir string
+
+	names   []string // in the order they were added
+	memory  map[string]*Summary
+	size    int64
+	file    *os.File
+	end     int64
+	spilled map[string][2]int64 // offset and length in file
+}
+
+// NewFacts returns an empty store that spills to dir past limit bytes
+func NewFacts(dir string, limit int64) *Facts {
+	return &Facts{Limit: limit, Dir: dir, memory: map[string]*Summary{}, spilled: map[string][2]int64{}}
+}
+
+// Has reports whether the store holds a summary of the function name
+func (f *Facts) Has(name string) bool {
+	if f == nil {
+		return false
+	}
+	_, ok := f.memory[name]
+	if !ok {
+		_, ok = f.spilled[name]
+	}
+	return ok
+}
+
+// Add stores s, in memory if it fits within Limit and in the spill file
+// if not. A later summary of the same function replaces the earlier one
+func (f *Facts) Add(s *Summary) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if !f.Has(s.Function) {
+		f.names = append(f.names, s.Function)
+	}
+	delete(f.memory, s.Function)
+	delete(f.spilled, s.Function)
+	if f.Limit <= 0 || f.size+int64(len(data)) <= f.Limit {
+		f.memory[s.Function] = s
+		f.size += int64(len(data))
+		return nil
+	}
+	if f.file == nil {
+		if f.file, err = os.CreateTemp(f.Dir, "citadel-facts-*"); err != nil {
+			return err
+		}
+	}
+	if _, err := f.file.WriteAt(data, f.end); err != nil {
+		return fmt.Errorf("spilling the summary of %s: %w", s.Function, err)
+	}
+	f.spilled[s.Function] = [2]int64{f.end, int64(len(data))}
+	f.end += int64(len(data))
+	return nil
+}
+
+// Lookup returns the summary of the function name, or nil if the store
+// has none or it cannot be read back
+func (f *Facts) Lookup(name string) *Summary {
+	if f == nil {
+		return nil
+	}
+	if s, ok := f.memory[name]; ok {
+		return s
+	}
+	span, ok := f.spilled[name]
+	if !ok {
+		return nil
+	}
+	data := make([]byte, span[1])
+	if _, err := f.file.ReadAt(data, span[0]); err != nil {
+		return nil
+	}
+	s := &Summary{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil
+	}
+	return s
+}
+
+// Names returns the functions the store has summaries of, in the order
+// they were added
+func (f *Facts) Names() []string {
+	return f.names
+}
+
+// Spilled returns how many summaries went to the spill file
+func (f *Facts) Spilled() int {
+	return len(f.spilled)
+}
+
+// Close removes the spill file, if there is one
+func (f *Facts) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	name := f.file.Name()
+	err := f.file.Close()
+	if rerr := os.Remove(name); err == nil {
+		err = rerr
+	}
+	f.file = nil
+	f.spilled = map[string][2]int64{}
+	return err
+}
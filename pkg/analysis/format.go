@@ -0,0 +1,121 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// printfFormats maps the printf family to the index of their format
+// argument. The v-variants take a va_list, so only their format is checked
+var printfFormats = map[string]int{
+	"printf": 0, "fprintf": 1, "dprintf": 1, "sprintf": 1, "snprintf": 2,
+	"syslog": 1, "vprintf": 0, "vfprintf": 1, "vsprintf": 1, "vsnprintf": 2,
+}
+
+// checkFormatStrings reports printf-family calls whose format is not a
+// string literal, and literal formats whose conversions do not match the
+// arguments supplied
+func checkFormatStrings(fn *ast.Function, unit *Unit) []Finding {
+	findings := []Finding{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return
+		}
+		name := calledFunction(fn, call)
+		i, ok := printfFormats[name]
+		if !ok || unit.defines(name) || i >= len(call.Args) {
+			return
+		}
+		report := func(rule string, severity Severity, suggestion, format string, args ...interface{}) {
+			findings = append(findings, Finding{
+				Rule:       rule,
+				Severity:   severity,
+				Function:   fn.Name,
+				Pos:        call.Pos,
+				End:        call.End,
+				Message:    fmt.Sprintf(format, args...),
+				CWE:        LookupRule(rule).CWE,
+				Suggestion: suggestion,
+			})
+		}
+
+		supplied := len(call.Args) - i - 1
+		lit, ok := call.Args[i].(*ast.StringLiteral)
+		if !ok {
+			// With no arguments to format, the value is almost certainly
+			// data being printed
+			if supplied == 0 {
+				report("format-string", High, "pass the value as the argument of a constant \"%s\" format",
+					"format of %s is not a string literal; %% directives in it are interpreted", name)
+			} else {
+				report("format-string", Medium, "use a string literal as the format",
+					"format of %s is not a string literal and cannot be checked", name)
+			}
+			return
+		}
+		if strings.HasPrefix(name, "v") {
+			return
+		}
+
+		conversions, err := formatConversions(lit.Value)
+		if err != nil {
+			report("format-arguments", Medium, "", "%s format %s: %v", name, lit, err)
+			return
+		}
+		if strings.Contains(conversions, "n") {
+			report("format-string", Medium, "compute the count from the return value instead",
+				"%s format %s uses %%n, which writes through a pointer argument", name, lit)
+		}
+		switch expected := len(conversions); {
+		case supplied < expected:
+			report("format-arguments", High, "",
+				"%s format %s expects %d arguments, got %d; the missing ones are read from the stack", name, lit, expected, supplied)
+		case supplied > expected:
+			report("format-arguments", Low, "",
+				"%s format %s expects %d arguments, got %d", name, lit, expected, supplied)
+		}
+	})
+	return findings
+}
+
+// formatConversions returns one byte per argument a printf format
+// consumes: the conversion character, or '*' for a width or precision
+// taken from the arguments
+func formatConversions(format string) (string, error) {
+	var conversions []byte
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		// Flags
+		for i < len(format) && strings.IndexByte("-+ #0'", format[i]) >= 0 {
+			i++
+		}
+		// Width and precision
+		for i < len(format) && (format[i] >= '0' && format[i] <= '9' || format[i] == '.' || format[i] == '*') {
+			if format[i] == '*' {
+				conversions = append(conversions, '*')
+			}
+			i++
+		}
+		// Length modifiers
+		for i < len(format) && strings.IndexByte("hljztL", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			return "", fmt.Errorf("incomplete conversion at the end")
+		}
+		switch ch := format[i]; {
+		case ch == '%':
+		case strings.IndexByte("diouxXeEfFgGaAcspn", ch) >= 0:
+			conversions = append(conversions, ch)
+		default:
+			return "", fmt.Errorf("invalid conversion %%%c", ch)
+		}
+	}
+	return string(conversions), nil
+}
@@ -0,0 +1,36 @@
+package analysis
+
+import (
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Hooks are functions Analyze calls as it runs, for a caller to trace the
+// passes, show progress or collect metrics without changing the driver.
+// Any of them can be nil. Analyze calls them one at a time, on the
+// goroutine it runs on
+type Hooks struct {
+	// OnPassStart is called before a pass runs, with its number among the
+	// passes to run, from 1, and how many there are
+	OnPassStart func(pass string, n, total int)
+	// OnPassEnd is called once a pass has run, with what it reported,
+	// which it must not change, and how long it took
+	OnPassEnd func(pass string, findings []Finding, elapsed time.Duration)
+	// OnNodeVisited is called as a pass goes into a node of the syntax
+	// tree: each function, for the passes that check the functions one
+	// at a time, and whatever a pass reports with Unit.Visited
+	OnNodeVisited func(pass string, node ast.Node)
+	// OnFinding is called for each finding Analyze returns, in source
+	// order, once the findings of disabled rules are dropped and the
+	// configured severities applied
+	OnFinding func(Finding)
+}
+
+// Visited tells the OnNodeVisited hook of the run, if there is one, that
+// the running pass is going into node
+func (u *Unit) Visited(node ast.Node) {
+	if h := u.Config.Hooks; h != nil && h.OnNodeVisited != nil {
+		h.OnNodeVisited(u.pass, node)
+	}
+}
@@ -0,0 +1,113 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// hookedProgram has findings of two rules in two functions
+const hookedProgram = `int f() { char b[8]; gets(b); return 0; }
+int main() { int z = 0; f(); return 1 / z; }`
+
+// parse returns the program of src
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return program
+}
+
+// TestHooks checks that the hooks are told of each pass that runs, in
+// order and numbered among those, of the functions the passes go into,
+// and of the findings Analyze returns, in the same order
+func TestHooks(t *testing.T) {
+	program := parse(t, hookedProgram)
+	var started, ended []string
+	visited := map[string]int{}
+	var told []Finding
+	totals := map[int]bool{}
+	config := DefaultConfig()
+	config.Disabled = map[string]bool{"hardcoded-secret": true, "hardcoded-key": true}
+	config.Hooks = &Hooks{
+		OnPassStart: func(pass string, n, total int) {
+			if n != len(started)+1 {
+				t.Errorf("%s is pass %d, want %d", pass, n, len(started)+1)
+			}
+			started = append(started, pass)
+			totals[total] = true
+		},
+		OnPassEnd: func(pass string, findings []Finding, elapsed time.Duration) {
+			if pass != started[len(started)-1] || elapsed < 0 {
+				t.Errorf("%s ended after %s started, in %v", pass, started[len(started)-1], elapsed)
+			}
+			ended = append(ended, pass)
+		},
+		OnNodeVisited: func(pass string, node ast.Node) {
+			if fn, ok := node.(*ast.Function); ok {
+				visited[pass+" "+fn.Name]++
+			}
+		},
+		OnFinding: func(f Finding) { told = append(told, f) },
+	}
+	findings, err := Analyze(program, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(started, ended) || len(totals) != 1 || !totals[len(started)] {
+		t.Errorf("started %v and ended %v of totals %v", started, ended, totals)
+	}
+	for _, pass := range started {
+		if pass == "secrets" {
+			t.Error("the secrets pass ran with its rules disabled")
+		}
+	}
+	if visited["dangerous-calls f"] != 1 || visited["dangerous-calls main"] != 1 {
+		t.Errorf("the dangerous-calls pass went into %v, want f and main once", visited)
+	}
+	if !reflect.DeepEqual(told, findings) || len(findings) < 2 {
+		t.Errorf("told of %v, want the %d findings returned", told, len(findings))
+	}
+}
+
+// TestContext checks that Analyze stops with the error of a context done
+// before it starts or while a pass runs, telling no hook of the pass
+// that was stopped
+func TestContext(t *testing.T) {
+	program := parse(t, hookedProgram)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config := DefaultConfig()
+	config.Context = ctx
+	if _, err := Analyze(program, config); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled before: got %v, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var started, ended, found int
+	config.Context = ctx
+	config.Hooks = &Hooks{
+		OnPassStart: func(string, int, int) {
+			started++
+			cancel()
+		},
+		OnPassEnd: func(string, []Finding, time.Duration) { ended++ },
+		OnFinding: func(Finding) { found++ },
+	}
+	if _, err := Analyze(program, config); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled during a pass: got %v, want %v", err, context.Canceled)
+	}
+	if started != 1 || ended != 0 || found != 0 {
+		t.Errorf("%d passes started, %d ended and %d findings told, want 1, 0 and 0", started, ended, found)
+	}
+}
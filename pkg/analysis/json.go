@@ -0,0 +1,91 @@
+package analysis
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonReport is the JSON form of the findings in one file
+type jsonReport struct {
+	File     string        `json:"file"`
+	Findings []jsonFinding `json:"findings"`
+}
+
+type jsonFinding struct {
+	Rule            string     `json:"rule"`
+	RuleDescription string     `json:"ruleDescription,omitempty"`
+	Severity        Severity   `json:"severity"`
+	Function        string     `json:"function"`
+	Line            int        `json:"line"`
+	Column          int        `json:"column"`
+	Message         string     `json:"message"`
+	CWE             int        `json:"cwe,omitempty"`
+	CWEName         string     `json:"cweName,omitempty"`
+	Suggestion      string     `json:"suggestion,omitempty"`
+	Fingerprint     string     `json:"fingerprint,omitempty"`
+	Trace           []jsonStep `json:"trace,omitempty"`
+	// Suppressed is the reason given for suppressing the finding, or
+	// "suppressed" if none was
+	Suppressed string `json:"suppressed,omitempty"`
+}
+
+type jsonStep struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// WriteJSON writes findings in file as a JSON object, with the
+// description of each finding's rule and the name of its weakness.
+// Suppressed findings are included and say so
+func WriteJSON(w io.Writer, file string, findings []Finding) error {
+	return writeJSON(w, jsonReportOf(file, findings))
+}
+
+// WriteJSONFiles writes the findings in several files as a JSON array of
+// the objects WriteJSON writes for each
+func WriteJSONFiles(w io.Writer, files []FileFindings) error {
+	reports := []jsonReport{}
+	for _, ff := range files {
+		reports = append(reports, jsonReportOf(ff.File, ff.Findings))
+	}
+	return writeJSON(w, reports)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func jsonReportOf(file string, findings []Finding) jsonReport {
+	report := jsonReport{File: file, Findings: []jsonFinding{}}
+	for _, f := range findings {
+		jf := jsonFinding{
+			Rule:        f.Rule,
+			Severity:    f.Severity,
+			Function:    f.Function,
+			Line:        f.Pos.Line,
+			Column:      f.Pos.Column,
+			Message:     f.Message,
+			CWE:         f.CWE,
+			CWEName:     CWEName(f.CWE),
+			Suggestion:  f.Suggestion,
+			Fingerprint: f.Fingerprint,
+		}
+		if rule := LookupRule(f.Rule); rule != nil {
+			jf.RuleDescription = rule.Description
+		}
+		if f.Suppressed != nil {
+			jf.Suppressed = f.Suppressed.Reason
+			if jf.Suppressed == "" {
+				jf.Suppressed = "suppressed"
+			}
+		}
+		for _, step := range f.Trace {
+			jf.Trace = append(jf.Trace, jsonStep{step.Pos.Line, step.Pos.Column, step.Message})
+		}
+		report.Findings = append(report.Findings, jf)
+	}
+	return report
+}
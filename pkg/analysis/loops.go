@@ -0,0 +1,154 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// exits are the library functions that do not return
+var exits = map[string]bool{"exit": true, "_exit": true, "abort": true}
+
+// checkInfiniteLoops reports loops that, once entered, never end: those
+// with no break, return or call to exit in their body whose condition
+// reads only variables the loop does not assign, and memory it neither
+// stores to nor passes to a call. Locals have no address to be changed
+// through, so only an assignment changes them. A condition that makes
+// calls may change each time and is left alone
+func checkInfiniteLoops(fn *ast.Function, unit *Unit) []Finding {
+	ranges := unit.ranges(fn)
+	findings := []Finding{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		loop, ok := stmt.(*ast.WhileStatement)
+		if !ok || expr != loop.Condition || leaves(fn, loop.Body.Statements, true) {
+			return
+		}
+		value, reached := ranges.values[loop.Condition]
+		if reached && value.IsConstant() && value.Lo.Sign() == 0 {
+			return // never entered
+		}
+		read, memory, calls := conditionReads(loop.Condition)
+		if calls {
+			return
+		}
+		assigned, stores := loopWrites(loop)
+		if memory && stores {
+			return
+		}
+		var names []string
+		for name := range read {
+			if assigned[name] {
+				return
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		cond := exprString(loop.Condition)
+		ends := "never ends once entered"
+		if reached && value.IsConstant() {
+			ends = "never ends"
+		}
+		finding := Finding{
+			Rule:       "infinite-loop",
+			Severity:   Medium,
+			Function:   fn.Name,
+			Pos:        loop.Pos,
+			End:        loop.EndPosition(),
+			CWE:        835,
+			Suggestion: "leave the loop with break, or change what its condition tests in the body",
+		}
+		switch {
+		case len(names) == 0 && !memory:
+			finding.Message = fmt.Sprintf("the loop never ends: its condition %s is always true and its body has no break or return", cond)
+		case len(names) == 0:
+			finding.Message = fmt.Sprintf("the loop %s: its condition %s reads memory its body does not change, and the body has no break or return", ends, cond)
+		default:
+			finding.Message = fmt.Sprintf("the loop %s: its condition %s depends on %s, which its body does not change, and the body has no break or return", ends, cond, strings.Join(names, ", "))
+		}
+		findings = append(findings, finding)
+	})
+	return findings
+}
+
+// leaves reports whether stmts can leave the loop they are the body of:
+// by a break of that loop, when top is set, or by a return or a call to
+// a function that does not return anywhere in them
+func leaves(fn *ast.Function, stmts []ast.Statement, top bool) bool {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.Block:
+			if leaves(fn, s.Statements, top) {
+				return true
+			}
+		case *ast.IfStatement:
+			if s.ThenBlock != nil && leaves(fn, s.ThenBlock.Statements, top) || s.ElseBlock != nil && leaves(fn, s.ElseBlock.Statements, top) {
+				return true
+			}
+		case *ast.WhileStatement:
+			// A break there leaves the inner loop only
+			if leaves(fn, s.Body.Statements, false) {
+				return true
+			}
+		case *ast.SwitchStatement:
+			for _, cs := range s.Cases {
+				if leaves(fn, cs.Body, false) {
+					return true
+				}
+			}
+		case *ast.BreakStatement:
+			if top {
+				return true
+			}
+		case *ast.ReturnStatement:
+			return true
+		}
+	}
+	exited := false
+	inspect(stmts, func(_ ast.Statement, expr ast.Expression) {
+		if call, ok := expr.(*ast.CallExpr); ok && exits[calledFunction(fn, call)] {
+			exited = true
+		}
+	})
+	return exited
+}
+
+// conditionReads returns the variables cond reads, and whether it reads
+// memory and makes calls
+func conditionReads(cond ast.Expression) (read map[string]bool, memory, calls bool) {
+	read = map[string]bool{}
+	inspectExpression(nil, cond, func(_ ast.Statement, expr ast.Expression) {
+		switch e := expr.(type) {
+		case *ast.Identifier:
+			read[e.Name] = true
+		case *ast.IndexExpr:
+			memory = true
+		case *ast.UnaryOp:
+			memory = memory || e.Operator == "*"
+		case *ast.CallExpr:
+			calls = true
+		}
+	})
+	return read, memory, calls
+}
+
+// loopWrites returns the variables a loop assigns, in its condition or
+// body, and whether it stores to memory or makes calls that may
+func loopWrites(loop *ast.WhileStatement) (assigned map[string]bool, stores bool) {
+	assigned = map[string]bool{}
+	inspect([]ast.Statement{loop}, func(_ ast.Statement, expr ast.Expression) {
+		switch e := expr.(type) {
+		case *ast.Assignment:
+			if id, ok := e.Target.(*ast.Identifier); ok {
+				assigned[id.Name] = true
+			} else {
+				stores = true
+			}
+		case *ast.CallExpr:
+			stores = true
+		}
+	})
+	return assigned, stores
+}
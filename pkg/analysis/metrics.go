@@ -0,0 +1,68 @@
+package analysis
+
+import (
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Metrics are size and shape measures of a function
+type Metrics struct {
+	Function string
+	// Complexity is the cyclomatic complexity: one more than the number
+	// of branch points, counting each if, while, case label, && and ||
+	Complexity int
+	// Calls is the number of calls the function makes
+	Calls int
+}
+
+// Measure returns the metrics of each function program defines, in order
+func Measure(program *ast.Program) []Metrics {
+	metrics := []Metrics{}
+	for _, fn := range program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		m := Metrics{Function: fn.Name, Complexity: 1}
+		inspectBranches(fn.Body.Statements, &m)
+		inspect(fn.Body.Statements, func(_ ast.Statement, expr ast.Expression) {
+			switch e := expr.(type) {
+			case *ast.CallExpr:
+				m.Calls++
+			case *ast.BinaryOp:
+				if e.Operator == "&&" || e.Operator == "||" {
+					m.Complexity++
+				}
+			}
+		})
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// inspectBranches counts the if and while statements and case labels in
+// stmts
+func inspectBranches(stmts []ast.Statement, m *Metrics) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.Block:
+			inspectBranches(s.Statements, m)
+		case *ast.IfStatement:
+			m.Complexity++
+			if s.ThenBlock != nil {
+				inspectBranches(s.ThenBlock.Statements, m)
+			}
+			if s.ElseBlock != nil {
+				inspectBranches(s.ElseBlock.Statements, m)
+			}
+		case *ast.WhileStatement:
+			m.Complexity++
+			inspectBranches(s.Body.Statements, m)
+		case *ast.SwitchStatement:
+			for _, cs := range s.Cases {
+				if cs.Value != nil {
+					m.Complexity++
+				}
+				inspectBranches(cs.Body, m)
+			}
+		}
+	}
+}
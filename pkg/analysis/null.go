@@ -0,0 +1,384 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// nullness is what is known about whether a pointer is null
+type nullness int
+
+const (
+	nonNull nullness = iota
+	maybeNull
+	isNull
+)
+
+// nullFact is the nullness of a pointer and where it was last set
+type nullFact struct {
+	state  nullness
+	pos    lexer.Position
+	origin string // how the pointer came to be null or possibly null
+}
+
+// nullEnv maps the pointer locals whose nullness is known to it. A nil
+// env stands for a path that cannot be reached
+type nullEnv map[string]nullFact
+
+func (e nullEnv) copy() nullEnv {
+	if e == nil {
+		return nil
+	}
+	c := nullEnv{}
+	for name, fact := range e {
+		c[name] = fact
+	}
+	return c
+}
+
+// joinNull merges two paths that meet. A pointer null on one and not on
+// the other may be null; pointers known on one path only are not tracked
+func joinNull(a, b nullEnv) nullEnv {
+	if a == nil {
+		return b.copy()
+	}
+	if b == nil {
+		return a.copy()
+	}
+	j := nullEnv{}
+	for name, fa := range a {
+		fb, ok := b[name]
+		if !ok {
+			continue
+		}
+		switch {
+		case fa.state == fb.state:
+			j[name] = fa
+		case fa.state == nonNull:
+			j[name] = nullFact{maybeNull, fb.pos, fb.origin}
+		default:
+			j[name] = nullFact{maybeNull, fa.pos, fa.origin}
+		}
+	}
+	return j
+}
+
+// allocators are the library functions that return NULL when they fail
+var allocators = map[string]bool{"malloc": true, "calloc": true, "realloc": true}
+
+// nullChecks is a pass over one function that follows the nullness of
+// its pointer locals and reports dereferences of ones that may be null
+type nullChecks struct {
+	fn       *ast.Function
+	program  *ast.Program
+	unit     *Unit
+	types    map[string]*ast.Type
+	findings []Finding
+	// result is the nullness of the pointer the function returns, joined
+	// over its return statements
+	result nullFact
+}
+
+// checkNullDereference reports pointers dereferenced on a path where they
+// are null, or may be because an allocation they came from can fail and
+// was not checked
+func checkNullDereference(fn *ast.Function, unit *Unit) []Finding {
+	n := &nullChecks{fn: fn, program: unit.Program, unit: unit, types: map[string]*ast.Type{}, findings: []Finding{}}
+	for _, param := range fn.Params {
+		n.types[param.Name] = param.Type
+	}
+	n.statements(fn.Body.Statements, nullEnv{})
+	return n.findings
+}
+
+// statements runs stmts from in and returns the env at their end and the
+// env joined over the break statements among them
+func (n *nullChecks) statements(stmts []ast.Statement, in nullEnv) (out, broke nullEnv) {
+	for _, stmt := range stmts {
+		if in == nil {
+			break
+		}
+		switch s := stmt.(type) {
+		case *ast.Block:
+			var b nullEnv
+			in, b = n.statements(s.Statements, in)
+			broke = joinNull(broke, b)
+		case *ast.VarDecl:
+			n.types[s.Name] = s.Type
+			delete(in, s.Name)
+			if s.Value != nil {
+				n.expression(s, s.Value, in)
+				n.assign(s, s.Name, s.Value, in)
+			}
+		case *ast.IfStatement:
+			then, els := n.condition(s, s.Condition, in)
+			if s.ThenBlock != nil {
+				var b nullEnv
+				then, b = n.statements(s.ThenBlock.Statements, then)
+				broke = joinNull(broke, b)
+			}
+			if s.ElseBlock != nil {
+				var b nullEnv
+				els, b = n.statements(s.ElseBlock.Statements, els)
+				broke = joinNull(broke, b)
+			}
+			in = joinNull(then, els)
+		case *ast.WhileStatement:
+			then, els := n.condition(s, s.Condition, n.loopHead(s, in))
+			_, b := n.statements(s.Body.Statements, then)
+			in = joinNull(els, b)
+		case *ast.SwitchStatement:
+			n.expression(s, s.Tag, in)
+			var after, fall nullEnv
+			hasDefault := false
+			for _, cs := range s.Cases {
+				hasDefault = hasDefault || cs.Value == nil
+				out, b := n.statements(cs.Body, joinNull(in.copy(), fall))
+				after = joinNull(after, b)
+				fall = out
+			}
+			after = joinNull(after, fall)
+			if !hasDefault {
+				after = joinNull(after, in)
+			}
+			in = after
+		case *ast.BreakStatement:
+			broke = joinNull(broke, in)
+			in = nil
+		case *ast.ReturnStatement:
+			if s.Value != nil {
+				n.expression(s, s.Value, in)
+				fact, ok := n.valueFact(s, s.Value, in)
+				if ok && fact.state != nonNull && n.result.state == nonNull && n.fn.ReturnType.Kind == ast.PointerType {
+					n.result = nullFact{maybeNull, s.Pos, fmt.Sprintf("%s can return NULL at %s", n.fn.Name, s.Pos)}
+				}
+			}
+			in = nil
+		case *ast.ExprStatement:
+			n.expression(s, s.Expr, in)
+		}
+	}
+	return in, broke
+}
+
+// loopHead returns the env at the head of a loop entered with in, where
+// the end of the body joins the entry. The rounds that find it report
+// nothing
+func (n *nullChecks) loopHead(s *ast.WhileStatement, in nullEnv) nullEnv {
+	head := in
+	for round := 0; round < loopRounds; round++ {
+		quiet := &nullChecks{fn: n.fn, program: n.program, unit: n.unit, types: n.types}
+		then, _ := quiet.condition(s, s.Condition, head.copy())
+		out, _ := quiet.statements(s.Body.Statements, then)
+		next := joinNull(in, out)
+		if sameNullness(next, head) {
+			break
+		}
+		head = next
+	}
+	return head
+}
+
+// sameNullness reports whether a and b know the same of the same pointers
+func sameNullness(a, b nullEnv) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, fa := range a {
+		if fb, ok := b[name]; !ok || fa.state != fb.state {
+			return false
+		}
+	}
+	return true
+}
+
+// condition evaluates a branch condition and returns the envs on the
+// paths where it is true and where it is false, narrowed by the null
+// checks it makes
+func (n *nullChecks) condition(stmt ast.Statement, cond ast.Expression, in nullEnv) (then, els nullEnv) {
+	switch e := cond.(type) {
+	case *ast.BinaryOp:
+		switch e.Operator {
+		case "&&":
+			leftThen, leftElse := n.condition(stmt, e.Left, in)
+			then, els = n.condition(stmt, e.Right, leftThen)
+			return then, joinNull(leftElse, els)
+		case "||":
+			leftThen, leftElse := n.condition(stmt, e.Left, in)
+			then, els = n.condition(stmt, e.Right, leftElse)
+			return joinNull(leftThen, then), els
+		case "==":
+			n.expression(stmt, cond, in)
+			name := n.pointer(e.Left)
+			if !isZero(e.Right) {
+				name = n.pointer(e.Right)
+				if !isZero(e.Left) {
+					name = ""
+				}
+			}
+			then, els = in.copy(), in.copy()
+			return n.narrow(stmt, then, name, isNull), n.narrow(stmt, els, name, nonNull)
+		}
+	case *ast.Identifier:
+		then, els = in.copy(), in.copy()
+		return n.narrow(stmt, then, n.pointer(e), nonNull), n.narrow(stmt, els, n.pointer(e), isNull)
+	}
+	n.expression(stmt, cond, in)
+	return in.copy(), in.copy()
+}
+
+// narrow records that the pointer called name has the given nullness on
+// the path e describes, returning nil if it contradicts what is known
+func (n *nullChecks) narrow(stmt ast.Statement, e nullEnv, name string, state nullness) nullEnv {
+	if e == nil || name == "" {
+		return e
+	}
+	fact, ok := e[name]
+	if ok && (fact.state == isNull && state == nonNull || fact.state == nonNull && state == isNull) {
+		return nil
+	}
+	if !ok {
+		fact = nullFact{pos: stmt.Position(), origin: "it is compared with NULL"}
+	}
+	fact.state = state
+	e[name] = fact
+	return e
+}
+
+// assign records the nullness a pointer local takes from value
+func (n *nullChecks) assign(stmt ast.Statement, name string, value ast.Expression, in nullEnv) {
+	if typ := n.types[name]; typ == nil || typ.Kind != ast.PointerType {
+		return
+	}
+	delete(in, name)
+	if fact, ok := n.valueFact(stmt, value, in); ok {
+		in[name] = fact
+	}
+}
+
+// valueFact returns the nullness of a pointer value, and whether it is
+// known
+func (n *nullChecks) valueFact(stmt ast.Statement, value ast.Expression, in nullEnv) (nullFact, bool) {
+	switch v := value.(type) {
+	case *ast.IntLiteral:
+		if v.Value == 0 {
+			return nullFact{isNull, stmt.Position(), "it is assigned NULL"}, true
+		}
+	case *ast.Identifier:
+		fact, ok := in[v.Name]
+		return fact, ok
+	case *ast.StringLiteral:
+		return nullFact{state: nonNull}, true
+	case *ast.CallExpr:
+		// Calls to functions the program defines take their nullness from
+		// the callee's summary
+		callee := calledFunction(n.fn, v)
+		if !n.unit.defines(callee) {
+			if allocators[callee] {
+				return nullFact{maybeNull, v.Pos, callee + " returns NULL when the allocation fails"}, true
+			}
+		} else if summary := n.unit.Summary(callee); summary != nil && summary.NullResult != "" {
+			return nullFact{maybeNull, v.Pos, summary.NullResult}, true
+		}
+	}
+	return nullFact{}, false
+}
+
+// expression reports the dereferences in expr of pointers that may be
+// null, and records the assignments it makes
+func (n *nullChecks) expression(stmt ast.Statement, expr ast.Expression, in nullEnv) {
+	switch e := expr.(type) {
+	case *ast.BinaryOp:
+		n.expression(stmt, e.Left, in)
+		n.expression(stmt, e.Right, in)
+	case *ast.UnaryOp:
+		n.expression(stmt, e.Operand, in)
+		if e.Operator == "*" {
+			n.dereference(stmt, e.Operand, "*"+operandString(e.Operand), in)
+		}
+	case *ast.IndexExpr:
+		n.expression(stmt, e.Array, in)
+		n.expression(stmt, e.Index, in)
+		n.dereference(stmt, e.Array, exprString(e), in)
+	case *ast.Assignment:
+		n.expression(stmt, e.Value, in)
+		if target, ok := e.Target.(*ast.Identifier); ok {
+			n.assign(stmt, target.Name, e.Value, in)
+		} else {
+			n.expression(stmt, e.Target, in)
+		}
+	case *ast.CallExpr:
+		n.expression(stmt, e.Callee, in)
+		for _, arg := range e.Args {
+			n.expression(stmt, arg, in)
+		}
+		// Library functions read or write through their pointer
+		// arguments, except free and realloc, which accept NULL
+		name := calledFunction(n.fn, e)
+		libc := codegen.LookupLibc(name)
+		if libc == nil || n.unit.defines(name) || name == "free" || name == "realloc" {
+			return
+		}
+		for i, param := range libc.Params {
+			if param == codegen.LibcPtr && i < len(e.Args) {
+				n.dereference(stmt, e.Args[i], fmt.Sprintf("argument %d of %s", i+1, name), in)
+			}
+		}
+	}
+}
+
+// dereference reports the use of ptr as use if it may be null. Once
+// reported, the pointer is assumed non-null so the report is not repeated
+func (n *nullChecks) dereference(stmt ast.Statement, ptr ast.Expression, use string, in nullEnv) {
+	name := n.pointer(ptr)
+	fact, ok := in[name]
+	if !ok || fact.state == nonNull {
+		return
+	}
+	finding := Finding{
+		Rule:       "null-dereference",
+		Severity:   High,
+		Function:   n.fn.Name,
+		Pos:        stmt.Position(),
+		End:        stmt.EndPosition(),
+		Message:    fmt.Sprintf("%s dereferences %s, which is null on this path", use, name),
+		CWE:        476,
+		Suggestion: fmt.Sprintf("check %s against NULL before using it, as in if (%s == 0) { return 1; }", name, name),
+	}
+	if fact.state == maybeNull {
+		finding.Severity = Medium
+		finding.Message = fmt.Sprintf("%s dereferences %s, which may be null because %s", use, name, fact.origin)
+	}
+	if fact.pos != (lexer.Position{}) {
+		finding.Trace = []TraceStep{
+			{Pos: fact.pos, Message: fmt.Sprintf("%s may be null here", name)},
+			{Pos: stmt.Position(), Message: use + " dereferences " + name},
+		}
+		if fact.state == isNull {
+			finding.Trace[0].Message = fmt.Sprintf("%s can be null here because %s", name, fact.origin)
+		}
+	}
+	n.findings = append(n.findings, finding)
+	in[name] = nullFact{state: nonNull}
+}
+
+// pointer returns the name of the pointer local expr is, or ""
+func (n *nullChecks) pointer(expr ast.Expression) string {
+	id, ok := expr.(*ast.Identifier)
+	if !ok {
+		return ""
+	}
+	if typ := n.types[id.Name]; typ != nil && typ.Kind == ast.PointerType {
+		return id.Name
+	}
+	return ""
+}
+
+// isZero reports whether expr is the null pointer constant 0
+func isZero(expr ast.Expression) bool {
+	lit, ok := expr.(*ast.IntLiteral)
+	return ok && lit.Value == 0
+}
@@ -0,0 +1,68 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// checkIntegerOverflow reports signed integer arithmetic whose result,
+// given the values the operands can hold, does not fit the type it is
+// computed in. Overflow that is certain is reported as high severity;
+// overflow that some inputs cause is reported lower, as the inputs may be
+// checked by the callers
+func checkIntegerOverflow(fn *ast.Function, unit *Unit) []Finding {
+	ranges := unit.ranges(fn)
+	findings := []Finding{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		op, ok := ranges.arith[expr]
+		if !ok {
+			return
+		}
+		limits := typeRange(op.typ)
+		if limits.Contains(op.exact) || ranges.overflowsOperand(expr) {
+			return
+		}
+		finding := Finding{
+			Rule:       "integer-overflow",
+			Function:   fn.Name,
+			Pos:        stmt.Position(),
+			End:        stmt.EndPosition(),
+			CWE:        190,
+			Suggestion: fmt.Sprintf("check the operands against the limits of %s first, or compute in a wider type", op.typ),
+		}
+		if !limits.Overlaps(op.exact) {
+			finding.Severity = High
+			finding.Message = fmt.Sprintf("%s always overflows %s: its value is %s", exprString(expr), op.typ, op.exact)
+		} else {
+			// Products of unchecked values overflow for far smaller inputs
+			// than sums do
+			finding.Severity = Low
+			if binary, ok := expr.(*ast.BinaryOp); ok && binary.Operator == "*" {
+				finding.Severity = Medium
+			}
+			finding.Message = fmt.Sprintf("%s can overflow %s for some values of its operands", exprString(expr), op.typ)
+		}
+		findings = append(findings, finding)
+	})
+	return findings
+}
+
+// overflowsOperand reports whether an operand of expr can overflow
+// itself. Only the innermost operation is reported, as the ones around it
+// overflow because it does
+func (r *valueRanges) overflowsOperand(expr ast.Expression) bool {
+	var operands []ast.Expression
+	switch e := expr.(type) {
+	case *ast.BinaryOp:
+		operands = []ast.Expression{e.Left, e.Right}
+	case *ast.UnaryOp:
+		operands = []ast.Expression{e.Operand}
+	}
+	for _, operand := range operands {
+		if op, ok := r.arith[operand]; ok && !typeRange(op.typ).Contains(op.exact) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+package analysis
+
+import (
+	"fmt"
+	"plugin"
+	"sync"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Pass is an analysis Analyze runs over a program. Passes outside this
+// package make themselves known with Register, usually from an init
+// function, so importing their package for its side effects, or loading
+// it as a plugin with LoadPlugin, adds their rules
+type Pass interface {
+	// Name identifies the pass
+	Name() string
+	// Rules are the rules the pass reports findings under
+	Rules() []Rule
+	// Requires names the passes whose findings Run reads from the unit
+	Requires() []string
+	// Run reports the findings of the pass in the unit
+	Run(unit *Unit) []Finding
+}
+
+// Unit is what a pass runs over: a program, the settings of the run, the
+// findings of the passes it requires, and the summaries of the functions
+// the program defines
+type Unit struct {
+	Program   *ast.Program
+	Config    *Config
+	results   map[string][]Finding
+	pass      string // the name of the running pass
+	rangesOf  map[*ast.Function]*valueRanges
+	summaries map[string]*Summary // nil while being computed
+	callGraph *CallGraph
+	cycles    map[string][]string // the functions of the recursion cycle each is in
+	// assumed holds what the functions of a recursion cycle are taken to
+	// return while their returns are being computed, nil until some path
+	// returns, and cycleReturns what they return once computed
+	assumed      map[string]*Interval
+	cycleReturns map[string]*Interval
+}
+
+// Functions returns the functions the program defines
+func (u *Unit) Functions() []*ast.Function {
+	var fns []*ast.Function
+	for _, fn := range u.Program.Functions {
+		if fn.Body != nil {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// canceled returns the error of the context of the run once it is done,
+// or nil. Passes that take long stop early when it is not nil, as
+// Analyze throws away what they found
+func (u *Unit) canceled() error {
+	if u.Config.Context == nil {
+		return nil
+	}
+	return u.Config.Context.Err()
+}
+
+// FindingsOf returns the findings of a pass the running pass requires
+func (u *Unit) FindingsOf(pass string) []Finding {
+	return u.results[pass]
+}
+
+// funcPass is a Pass made of its parts
+type funcPass struct {
+	name     string
+	rules    []Rule
+	requires []string
+	run      func(unit *Unit) []Finding
+}
+
+func (p *funcPass) Name() string             { return p.name }
+func (p *funcPass) Rules() []Rule            { return p.rules }
+func (p *funcPass) Requires() []string       { return p.requires }
+func (p *funcPass) Run(unit *Unit) []Finding { return p.run(unit) }
+
+// NewPass returns a Pass with the given name, rules and requirements that
+// runs run
+func NewPass(name string, rules []Rule, requires []string, run func(unit *Unit) []Finding) Pass {
+	return &funcPass{name, rules, requires, run}
+}
+
+// eachFunction returns a pass body that runs check on every function the
+// program defines
+func eachFunction(check check) func(unit *Unit) []Finding {
+	return func(unit *Unit) []Finding {
+		findings := []Finding{}
+		for _, fn := range unit.Functions() {
+			if unit.canceled() != nil {
+				break
+			}
+			unit.Visited(fn)
+			findings = append(findings, check(fn, unit)...)
+		}
+		return findings
+	}
+}
+
+// passes are the registered passes, in the order they were registered.
+// registry guards them, for passes registered while others analyze
+var (
+	registry sync.RWMutex
+	passes   []Pass
+)
+
+// Register adds a pass to those Analyze runs. It panics if a pass of the
+// same name, or one reporting one of the same rules, is registered
+func Register(pass Pass) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, registered := range passes {
+		if registered.Name() == pass.Name() {
+			panic(fmt.Sprintf("analysis: pass %q registered twice", pass.Name()))
+		}
+		for _, rule := range registered.Rules() {
+			for _, added := range pass.Rules() {
+				if rule.ID == added.ID {
+					panic(fmt.Sprintf("analysis: rule %q of pass %q already registered", rule.ID, pass.Name()))
+				}
+			}
+		}
+	}
+	passes = append(passes, pass)
+}
+
+// Passes returns the registered passes, in the order they were registered
+func Passes() []Pass {
+	registry.RLock()
+	defer registry.RUnlock()
+	return append([]Pass(nil), passes...)
+}
+
+// LookupPass returns the registered pass called name, or nil
+func LookupPass(name string) Pass {
+	for _, pass := range Passes() {
+		if pass.Name() == name {
+			return pass
+		}
+	}
+	return nil
+}
+
+// LoadPlugin opens a Go plugin built with -buildmode=plugin against this
+// package; its init functions register its passes
+func LoadPlugin(path string) error {
+	before := len(Passes())
+	if _, err := plugin.Open(path); err != nil {
+		return err
+	}
+	if len(Passes()) == before {
+		return fmt.Errorf("%s registers no analysis passes", path)
+	}
+	return nil
+}
+
+// schedule orders the registered passes so each comes after the passes it
+// requires, keeping registration order otherwise
+func schedule() ([]Pass, error) {
+	var order []Pass
+	state := map[string]int{} // 1 while visiting, 2 once scheduled
+	var visit func(pass Pass) error
+	visit = func(pass Pass) error {
+		switch state[pass.Name()] {
+		case 1:
+			return fmt.Errorf("pass %s requires itself", pass.Name())
+		case 2:
+			return nil
+		}
+		state[pass.Name()] = 1
+		for _, name := range pass.Requires() {
+			required := LookupPass(name)
+			if required == nil {
+				return fmt.Errorf("pass %s requires unknown pass %s", pass.Name(), name)
+			}
+			if err := visit(required); err != nil {
+				return err
+			}
+		}
+		state[pass.Name()] = 2
+		order = append(order, pass)
+		return nil
+	}
+	for _, pass := range Passes() {
+		if err := visit(pass); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func init() {
+	Register(NewPass("taint", []Rule{
+		{"taint", "untrusted input that reaches a sensitive operation without sanitization", 20},
+	}, nil, func(unit *Unit) []Finding {
+		return checkTaint(unit, unit.Config.Taint)
+	}))
+	Register(NewPass("recursion", []Rule{
+		{"recursion", "recursion with no bound on its depth, or whose bound can exhaust the stack", 674},
+	}, nil, func(unit *Unit) []Finding {
+		return checkRecursion(unit)
+	}))
+	Register(NewPass("dangerous-calls", []Rule{
+		{"dangerous-call", "calls to library functions prone to buffer overflows, command injection or races", 676},
+	}, nil, func(unit *Unit) []Finding {
+		findings := []Finding{}
+		for _, fn := range unit.Functions() {
+			unit.Visited(fn)
+			findings = append(findings, checkDangerousCalls(fn, unit, unit.Config.Banned)...)
+		}
+		return findings
+	}))
+	Register(NewPass("format-strings", []Rule{
+		{"format-string", "printf-family formats that are not string literals or write through %n", 134},
+		{"format-arguments", "printf-family arguments that do not match the conversions of a literal format", 628},
+	}, nil, eachFunction(checkFormatStrings)))
+	Register(NewPass("integer-overflow", []Rule{
+		{"integer-overflow", "integer arithmetic whose result can fall outside its type", 190},
+	}, nil, eachFunction(checkIntegerOverflow)))
+	Register(NewPass("conversions", []Rule{
+		{"lossy-conversion", "implicit conversions to a type that cannot hold the value converted", 197},
+	}, nil, eachFunction(checkConversions)))
+	Register(NewPass("array-bounds", []Rule{
+		{"array-bounds", "indexes into local arrays that can fall outside the array", 125},
+		{"signed-index", "signed indexes checked against the upper bound of the array but not against 0", 839},
+	}, nil, eachFunction(checkArrayBounds)))
+	Register(NewPass("uninitialized", []Rule{
+		{"uninitialized", "locals read on a path where they were never assigned", 457},
+	}, nil, eachFunction(checkUninitialized)))
+	Register(NewPass("null-dereference", []Rule{
+		{"null-dereference", "pointers dereferenced where they are or may be null", 476},
+	}, nil, eachFunction(checkNullDereference)))
+	Register(NewPass("dangling-pointers", []Rule{
+		{"dangling-pointer", "pointers used after the memory they refer to is freed or goes out of scope", 416},
+		{"memory-leak", "heap blocks neither freed nor handed on before the function returns", 401},
+		{"double-free", "heap blocks that may be freed twice, by free or by functions that free their argument", 415},
+	}, nil, eachFunction(checkDanglingPointers)))
+	Register(NewPass("races", []Rule{
+		{"toctou", "files checked by name and then used by name, which can change in between", 367},
+	}, nil, eachFunction(checkRaces)))
+	Register(NewPass("division-by-zero", []Rule{
+		{"division-by-zero", "integer divisions and remainders whose divisor can be zero", 369},
+	}, nil, eachFunction(checkDivisionByZero)))
+	Register(NewPass("secrets", []Rule{
+		{"hardcoded-secret", "passwords, tokens and private keys written into the source", 798},
+		{"hardcoded-key", "constant keys and initialization vectors passed to cryptographic functions", 321},
+	}, nil, eachFunction(checkSecrets)))
+	Register(NewPass("infinite-loops", []Rule{
+		{"infinite-loop", "loops that never end once entered, as nothing in them changes what their condition tests", 835},
+	}, nil, eachFunction(checkInfiniteLoops)))
+	Register(NewPass("unreachable-code", []Rule{
+		{"unreachable-code", "statements no path reaches", 561},
+		{"constant-condition", "branch conditions that assign a constant where a comparison was meant", 481},
+	}, nil, eachFunction(checkUnreachableCode)))
+}
@@ -0,0 +1,177 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// PolicyFiles are the names a policy file is looked for under, in order
+var PolicyFiles = []string{".citadel.json", ".citadel.yaml", ".citadel.yml"}
+
+// Policy is a project's security policy: which rules run and how, which
+// files are not analyzed, and which findings fail the build. In YAML:
+//
+//	rules:
+//	  unreachable-code:
+//	    enabled: false
+//	  recursion:
+//	    severity: high
+//	  dangerous-call:
+//	    banned:
+//	      - function: memcpy
+//	        severity: low
+//	        alternative: memcpy_s
+//	  taint:
+//	    sources:
+//	      - function: read_packet
+//	        result: true
+//	        origin: the network
+//	exclude: [third_party, "tests/*.c"]
+//	fail-on: high
+type Policy struct {
+	Rules map[string]RulePolicy `json:"rules"`
+	// Exclude are file name patterns, relative to the directory of the
+	// policy file, of inputs not to analyze. A pattern matching a
+	// directory excludes everything in it
+	Exclude []string `json:"exclude"`
+	// FailOn is the lowest severity that fails the analysis, if any
+	FailOn *Severity `json:"fail-on"`
+
+	dir string // directory the policy file is in
+}
+
+// RulePolicy holds the settings of one rule
+type RulePolicy struct {
+	Enabled  *bool     `json:"enabled"`
+	Severity *Severity `json:"severity"`
+	// Banned adds to the functions of the dangerous-call rule
+	Banned []BannedFunction `json:"banned"`
+	// TaintConfig adds to the sources, sanitizers and sinks of the taint
+	// rule
+	TaintConfig
+}
+
+// FindPolicy returns the path of the policy file in dir or the nearest of
+// its parents that has one, or "" if none does
+func FindPolicy(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, name := range PolicyFiles {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadPolicy reads a policy file, in YAML if its name ends in .yaml or
+// .yml and in JSON otherwise
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		// Go through JSON so both formats share the field names and
+		// decoding of the JSON tags
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if data, err = json.Marshal(jsonValue(doc)); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for id := range policy.Rules {
+		if LookupRule(id) == nil {
+			return nil, fmt.Errorf("%s: unknown rule %q", path, id)
+		}
+	}
+	policy.dir = filepath.Dir(path)
+	return policy, nil
+}
+
+// jsonValue converts a decoded YAML value to one encoding/json accepts,
+// whose maps have string keys
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+	}
+	return v
+}
+
+// Config returns the analysis settings the policy describes, on top of
+// DefaultConfig
+func (p *Policy) Config() *Config {
+	config := DefaultConfig()
+	config.Disabled = map[string]bool{}
+	config.Severities = map[string]Severity{}
+	for id, rule := range p.Rules {
+		if rule.Enabled != nil && !*rule.Enabled {
+			config.Disabled[id] = true
+		}
+		if rule.Severity != nil {
+			config.Severities[id] = *rule.Severity
+		}
+		config.Banned = append(config.Banned, rule.Banned...)
+		config.Taint.Sources = append(config.Taint.Sources, rule.Sources...)
+		config.Taint.Sanitizers = append(config.Taint.Sanitizers, rule.Sanitizers...)
+		config.Taint.Sinks = append(config.Taint.Sinks, rule.Sinks...)
+	}
+	return config
+}
+
+// Excludes reports whether the policy excludes the input file at path
+func (p *Policy) Excludes(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	dir, err := filepath.Abs(p.dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	parts := strings.Split(rel, "/")
+	for _, pattern := range p.Exclude {
+		pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		// The file itself or any directory above it
+		for i := 1; i <= len(parts); i++ {
+			if ok, _ := filepath.Match(pattern, strings.Join(parts[:i], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,899 @@
+package analysis
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// Interval is the set of integers from Lo to Hi inclusive. Bounds are
+// exact, so arithmetic on intervals shows whether a result fits its type
+type Interval struct {
+	Lo, Hi *big.Int
+}
+
+func newInterval(lo, hi int64) Interval {
+	return Interval{big.NewInt(lo), big.NewInt(hi)}
+}
+
+// typeBits are the widths of the integer types. The analysis does not
+// depend on the target and assumes an LP64 long
+var typeBits = map[string]uint{"char": 8, "short": 16, "int": 32, "long": 64}
+
+// typeRange returns the values an integer type can represent
+func typeRange(t *ast.Type) Interval {
+	bits := typeBits[t.Name]
+	hi := new(big.Int).Lsh(big.NewInt(1), bits-1)
+	lo := new(big.Int).Neg(hi)
+	return Interval{lo, hi.Sub(hi, big.NewInt(1))}
+}
+
+// IsConstant reports whether the interval holds a single value
+func (i Interval) IsConstant() bool {
+	return i.Lo.Cmp(i.Hi) == 0
+}
+
+// Contains reports whether every value of j is in i
+func (i Interval) Contains(j Interval) bool {
+	return i.Lo.Cmp(j.Lo) <= 0 && j.Hi.Cmp(i.Hi) <= 0
+}
+
+// ContainsValue reports whether v is in i
+func (i Interval) ContainsValue(v int64) bool {
+	x := big.NewInt(v)
+	return i.Lo.Cmp(x) <= 0 && x.Cmp(i.Hi) <= 0
+}
+
+// Overlaps reports whether i and j have a value in common
+func (i Interval) Overlaps(j Interval) bool {
+	return i.Lo.Cmp(j.Hi) <= 0 && j.Lo.Cmp(i.Hi) <= 0
+}
+
+// Join returns the smallest interval containing both i and j
+func (i Interval) Join(j Interval) Interval {
+	return Interval{minInt(i.Lo, j.Lo), maxInt(i.Hi, j.Hi)}
+}
+
+// Meet returns the values in both i and j, and false if there are none
+func (i Interval) Meet(j Interval) (Interval, bool) {
+	m := Interval{maxInt(i.Lo, j.Lo), minInt(i.Hi, j.Hi)}
+	return m, m.Lo.Cmp(m.Hi) <= 0
+}
+
+// Widen returns i joined with j, except that a bound j extends goes to
+// the limit of type t. Applied to values that keep growing, it reaches a
+// fixed point in a couple of steps
+func (i Interval) Widen(j Interval, t *ast.Type) Interval {
+	full := typeRange(t)
+	w := i.Join(j)
+	if j.Lo.Cmp(i.Lo) < 0 {
+		w.Lo = full.Lo
+	}
+	if j.Hi.Cmp(i.Hi) > 0 {
+		w.Hi = full.Hi
+	}
+	return w
+}
+
+func (i Interval) String() string {
+	if i.IsConstant() {
+		return i.Lo.String()
+	}
+	return fmt.Sprintf("[%s, %s]", i.Lo, i.Hi)
+}
+
+func minInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// hull returns the smallest interval containing all of values
+func hull(values ...*big.Int) Interval {
+	i := Interval{values[0], values[0]}
+	for _, v := range values[1:] {
+		i = i.Join(Interval{v, v})
+	}
+	return i
+}
+
+// arith returns the exact result of applying an arithmetic operator to
+// every pair of values from l and r, ignoring the width of any type. ok is
+// false when no value can result, as when dividing by exactly zero
+func arith(op string, l, r Interval) (Interval, bool) {
+	switch op {
+	case "+":
+		return Interval{new(big.Int).Add(l.Lo, r.Lo), new(big.Int).Add(l.Hi, r.Hi)}, true
+	case "-":
+		return Interval{new(big.Int).Sub(l.Lo, r.Hi), new(big.Int).Sub(l.Hi, r.Lo)}, true
+	case "*":
+		return hull(
+			new(big.Int).Mul(l.Lo, r.Lo), new(big.Int).Mul(l.Lo, r.Hi),
+			new(big.Int).Mul(l.Hi, r.Lo), new(big.Int).Mul(l.Hi, r.Hi)), true
+	case "/":
+		// Division is monotonic on each side of zero, so the extremes are
+		// reached at the bounds of the divisor's nonzero parts
+		var candidates []*big.Int
+		for _, part := range nonzeroParts(r) {
+			for _, d := range []*big.Int{part.Lo, part.Hi} {
+				candidates = append(candidates, new(big.Int).Quo(l.Lo, d), new(big.Int).Quo(l.Hi, d))
+			}
+		}
+		if len(candidates) == 0 {
+			return Interval{}, false
+		}
+		return hull(candidates...), true
+	case "%":
+		// The remainder is smaller in magnitude than the divisor and has
+		// the sign of the dividend
+		parts := nonzeroParts(r)
+		if len(parts) == 0 {
+			return Interval{}, false
+		}
+		bound := big.NewInt(0)
+		for _, part := range parts {
+			bound = maxInt(bound, maxInt(new(big.Int).Abs(part.Lo), new(big.Int).Abs(part.Hi)))
+		}
+		bound = new(big.Int).Sub(bound, big.NewInt(1))
+		lo, hi := new(big.Int).Neg(bound), bound
+		if l.Lo.Sign() >= 0 {
+			lo = big.NewInt(0)
+			hi = minInt(hi, l.Hi)
+		}
+		if l.Hi.Sign() <= 0 {
+			hi = big.NewInt(0)
+			lo = maxInt(lo, l.Lo)
+		}
+		return Interval{lo, hi}, true
+	}
+	return Interval{}, false
+}
+
+// nonzeroParts splits i into its negative and positive parts
+func nonzeroParts(i Interval) []Interval {
+	parts := []Interval{}
+	if neg, ok := i.Meet(Interval{i.Lo, big.NewInt(-1)}); ok && i.Lo.Sign() < 0 {
+		parts = append(parts, neg)
+	}
+	if pos, ok := i.Meet(Interval{big.NewInt(1), i.Hi}); ok && i.Hi.Sign() > 0 {
+		parts = append(parts, pos)
+	}
+	return parts
+}
+
+// fit returns the values of type t a result in i can have: i itself when
+// it fits, and otherwise any value, as the result wraps or is truncated
+func fit(i Interval, t *ast.Type) Interval {
+	full := typeRange(t)
+	if full.Contains(i) {
+		return i
+	}
+	return full
+}
+
+// env holds the values the integer variables in scope can hold on one
+// path through a function. A nil env stands for a path that cannot be
+// reached
+type env struct {
+	values map[string]Interval
+	// nonzero holds the variables known not to be zero, which an interval
+	// cannot express when zero lies within its bounds
+	nonzero map[string]bool
+}
+
+func newEnv() *env {
+	return &env{values: map[string]Interval{}, nonzero: map[string]bool{}}
+}
+
+func (e *env) copy() *env {
+	if e == nil {
+		return nil
+	}
+	c := newEnv()
+	for name, i := range e.values {
+		c.values[name] = i
+	}
+	for name := range e.nonzero {
+		c.nonzero[name] = true
+	}
+	return c
+}
+
+// set records the values of a variable, forgetting what was known of it
+func (e *env) set(name string, value Interval) {
+	e.values[name] = value
+	delete(e.nonzero, name)
+}
+
+// joinEnvs merges the envs of two paths that meet. Variables declared on
+// only one of them have gone out of scope
+func joinEnvs(a, b *env) *env {
+	if a == nil {
+		return b.copy()
+	}
+	if b == nil {
+		return a.copy()
+	}
+	j := newEnv()
+	for name, i := range a.values {
+		if k, ok := b.values[name]; ok {
+			j.values[name] = i.Join(k)
+		}
+	}
+	for name := range a.nonzero {
+		if b.nonzero[name] {
+			j.nonzero[name] = true
+		}
+	}
+	return j
+}
+
+// arithmetic is an arithmetic expression the range pass evaluated
+type arithmetic struct {
+	typ   *ast.Type // type the operation is computed in
+	exact Interval  // exact result, before it is brought into range of typ
+}
+
+// access is an array subscript the range pass evaluated
+type access struct {
+	array *ast.Type // type of the array subscripted
+	write bool      // whether the element is assigned
+	path  []string  // branch conditions under which it is evaluated
+}
+
+// deadCode is a statement no path reaches
+type deadCode struct {
+	stmt   ast.Statement
+	reason string
+}
+
+// valueRanges is a forward pass over a function that tracks the values of
+// its integer variables and records the values each integer expression
+// can take. Loops are widened to a fixed point before what they evaluate
+// is recorded, as are the returns of recursive functions, which
+// Unit.returns follows across calls
+type valueRanges struct {
+	program *ast.Program
+	unit    *Unit
+	fn      *ast.Function
+	types   map[string]*ast.Type // declared types of parameters and locals
+	values  map[ast.Expression]Interval
+	arith   map[ast.Expression]arithmetic
+	access  map[*ast.IndexExpr]*access
+	// nonzero holds the variable references known not to be zero where
+	// they are evaluated
+	nonzero map[ast.Expression]bool
+	// dead holds the first statement of each run of statements that
+	// cannot be reached
+	dead []deadCode
+	// path holds the conditions of the branches enclosing the statement
+	// being evaluated
+	path []string
+	// returns joins the values the function returns, if its result is an
+	// integer and some path returns one
+	returns *Interval
+	// conversions holds the implicit conversions of integer values to a
+	// narrower type, and of floating-point values to an integer type, by
+	// the expression converted
+	conversions map[ast.Expression]*conversion
+	// unreachable is set by a call into a recursion cycle none of whose
+	// paths has returned yet: the path making it does not go on
+	unreachable bool
+}
+
+// conversion is an implicit conversion that can lose information
+type conversion struct {
+	from, to *ast.Type
+	value    Interval // the values converted, when from is an integer type
+}
+
+// computeRanges runs the range pass over fn. Parameters can hold any
+// value of their type, and so can call results, except those of functions
+// whose summary bounds them
+func computeRanges(fn *ast.Function, unit *Unit) *valueRanges {
+	r := newValueRanges(fn, unit, map[string]*ast.Type{})
+	in := newEnv()
+	for _, param := range fn.Params {
+		r.types[param.Name] = param.Type
+		if param.Type.IsInteger() {
+			in.set(param.Name, typeRange(param.Type))
+		}
+	}
+	r.statements(fn.Body.Statements, in)
+	return r
+}
+
+func newValueRanges(fn *ast.Function, unit *Unit, types map[string]*ast.Type) *valueRanges {
+	return &valueRanges{
+		program: unit.Program,
+		unit:    unit,
+		fn:      fn,
+		types:   types,
+		values:  map[ast.Expression]Interval{},
+		arith:   map[ast.Expression]arithmetic{},
+		access:  map[*ast.IndexExpr]*access{},
+		nonzero: map[ast.Expression]bool{},
+
+		conversions: map[ast.Expression]*conversion{},
+	}
+}
+
+// reachable returns in, or nil if the expression just evaluated made a
+// call that does not return
+func (r *valueRanges) reachable(in *env) *env {
+	if r.unreachable {
+		r.unreachable = false
+		return nil
+	}
+	return in
+}
+
+// statements runs stmts on the path described by in. It returns the env
+// at their end and the env joined over the break statements among them
+func (r *valueRanges) statements(stmts []ast.Statement, in *env) (out, broke *env) {
+	depth := len(r.path)
+	defer func() { r.path = r.path[:depth] }()
+	// reason says why the rest of stmts cannot be reached, once in is nil
+	reason := ""
+	for _, stmt := range stmts {
+		if in == nil {
+			if reason != "" {
+				r.dead = append(r.dead, deadCode{stmt, reason})
+			}
+			break
+		}
+		reason = "every path before it returns or breaks"
+		switch s := stmt.(type) {
+		case *ast.Block:
+			var b *env
+			in, b = r.statements(s.Statements, in)
+			broke = joinEnvs(broke, b)
+		case *ast.VarDecl:
+			r.types[s.Name] = s.Type
+			if s.Value == nil {
+				if s.Type.IsInteger() {
+					in.set(s.Name, typeRange(s.Type))
+				}
+				continue
+			}
+			value, typ := r.eval(s.Value, in)
+			if s.Type.IsInteger() {
+				in.set(s.Name, r.convert(s.Value, value, typ, s.Type))
+			}
+			in = r.reachable(in)
+		case *ast.IfStatement:
+			r.eval(s.Condition, in)
+			in = r.reachable(in)
+			then, els := r.refine(s.Condition, in, true), r.refine(s.Condition, in, false)
+			taken, notTaken := exprString(s.Condition)+" is true", exprString(s.Condition)+" is false"
+			if then == nil && s.ThenBlock != nil && len(s.ThenBlock.Statements) > 0 {
+				r.dead = append(r.dead, deadCode{s.ThenBlock.Statements[0], "the condition " + exprString(s.Condition) + " is always false"})
+			}
+			if els == nil && s.ElseBlock != nil && len(s.ElseBlock.Statements) > 0 {
+				r.dead = append(r.dead, deadCode{s.ElseBlock.Statements[0], "the condition " + exprString(s.Condition) + " is always true"})
+			}
+			if s.ThenBlock != nil {
+				var b *env
+				r.path = append(r.path, taken)
+				then, b = r.statements(s.ThenBlock.Statements, then)
+				r.path = r.path[:len(r.path)-1]
+				broke = joinEnvs(broke, b)
+			}
+			if s.ElseBlock != nil {
+				var b *env
+				r.path = append(r.path, notTaken)
+				els, b = r.statements(s.ElseBlock.Statements, els)
+				r.path = r.path[:len(r.path)-1]
+				broke = joinEnvs(broke, b)
+			}
+			// When one branch leaves the block, the statements after the
+			// if run only on the other
+			switch {
+			case then == nil && els != nil:
+				r.path = append(r.path, notTaken)
+			case els == nil && then != nil:
+				r.path = append(r.path, taken)
+			}
+			in = joinEnvs(then, els)
+		case *ast.WhileStatement:
+			if in = r.whileStatement(s, in); in == nil {
+				reason = "no path leaves the loop before it"
+			}
+		case *ast.SwitchStatement:
+			in = r.switchStatement(s, in)
+		case *ast.BreakStatement:
+			broke = joinEnvs(broke, in)
+			in = nil
+			reason = "it follows a break statement"
+		case *ast.ReturnStatement:
+			if s.Value != nil {
+				value, typ := r.eval(s.Value, in)
+				if r.reachable(in) != nil && r.fn.ReturnType.IsInteger() {
+					value = r.convert(s.Value, value, typ, r.fn.ReturnType)
+					if r.returns != nil {
+						value = r.returns.Join(value)
+					}
+					r.returns = &value
+				}
+			}
+			in = nil
+			reason = "it follows a return statement"
+		case *ast.ExprStatement:
+			r.eval(s.Expr, in)
+			in = r.reachable(in)
+		}
+	}
+	return in, broke
+}
+
+// whileStatement runs a loop and returns the env after it. The values at
+// the head of the loop, where the end of the body joins the entry, are
+// found by running the body with what is recorded thrown away, widening
+// the values that grow until they settle and then narrowing them by one
+// more run; the values recorded are those of the run from the result
+func (r *valueRanges) whileStatement(s *ast.WhileStatement, in *env) *env {
+	head := in
+	for {
+		next := r.widen(head, joinEnvs(in, r.iterate(s, head)))
+		if sameEnvs(next, head) {
+			break
+		}
+		head = next
+	}
+	head = joinEnvs(in, r.iterate(s, head))
+
+	r.eval(s.Condition, head)
+	if head = r.reachable(head); head == nil {
+		return nil
+	}
+	body, exit := r.refine(s.Condition, head, true), r.refine(s.Condition, head, false)
+	if body == nil && len(s.Body.Statements) > 0 {
+		r.dead = append(r.dead, deadCode{s.Body.Statements[0], "the condition " + exprString(s.Condition) + " is always false"})
+	}
+	r.path = append(r.path, exprString(s.Condition)+" is true")
+	_, broke := r.statements(s.Body.Statements, body)
+	r.path = r.path[:len(r.path)-1]
+	return joinEnvs(exit, broke)
+}
+
+// iterate runs the condition and body of a loop once from head, on a
+// pass of its own that records nothing, and returns the env at the end of
+// the body
+func (r *valueRanges) iterate(s *ast.WhileStatement, head *env) *env {
+	quiet := newValueRanges(r.fn, r.unit, r.types)
+	in := head.copy()
+	quiet.eval(s.Condition, in)
+	out, _ := quiet.statements(s.Body.Statements, quiet.refine(s.Condition, quiet.reachable(in), true))
+	return out
+}
+
+// widen returns the env of the variables in both a and b, each holding
+// the values of a widened by those of b
+func (r *valueRanges) widen(a, b *env) *env {
+	if a == nil || b == nil {
+		return joinEnvs(a, b)
+	}
+	w := joinEnvs(a, b)
+	for name := range w.values {
+		if typ := r.types[name]; typ != nil && typ.IsInteger() {
+			w.values[name] = a.values[name].Widen(b.values[name], typ)
+		}
+	}
+	return w
+}
+
+// sameEnvs reports whether a and b hold the same values
+func sameEnvs(a, b *env) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.values) != len(b.values) || len(a.nonzero) != len(b.nonzero) {
+		return false
+	}
+	for name, i := range a.values {
+		j, ok := b.values[name]
+		if !ok || i.Lo.Cmp(j.Lo) != 0 || i.Hi.Cmp(j.Hi) != 0 {
+			return false
+		}
+	}
+	for name := range a.nonzero {
+		if !b.nonzero[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// switchStatement runs a switch and returns the env after it. Each case
+// is entered from the tag matching its label or by falling through from
+// the case before
+func (r *valueRanges) switchStatement(s *ast.SwitchStatement, in *env) *env {
+	r.eval(s.Tag, in)
+	if in = r.reachable(in); in == nil {
+		return nil
+	}
+	var after, fall *env
+	hasDefault := false
+	for _, cs := range s.Cases {
+		entry := in.copy()
+		if cs.Value == nil {
+			hasDefault = true
+			r.path = append(r.path, "the default case of switch ("+exprString(s.Tag)+") is taken")
+		} else {
+			r.path = append(r.path, exprString(s.Tag)+" == "+exprString(cs.Value))
+			r.eval(cs.Value, in)
+			entry = r.refine(&ast.BinaryOp{Left: s.Tag, Operator: "==", Right: cs.Value}, in, true)
+			if entry == nil && fall == nil && len(cs.Body) > 0 {
+				r.dead = append(r.dead, deadCode{cs.Body[0], exprString(s.Tag) + " is never " + exprString(cs.Value)})
+			}
+		}
+		out, broke := r.statements(cs.Body, joinEnvs(entry, fall))
+		after = joinEnvs(after, broke)
+		fall = out
+		r.path = r.path[:len(r.path)-1]
+	}
+	after = joinEnvs(after, fall)
+	if !hasDefault {
+		after = joinEnvs(after, in)
+	}
+	return after
+}
+
+// eval returns the values expr can take and its type, updating in with
+// any assignments it makes. The interval is only meaningful for integer
+// types; the type is nil where it cannot be determined
+func (r *valueRanges) eval(expr ast.Expression, in *env) (Interval, *ast.Type) {
+	value, typ := r.evalExpression(expr, in)
+	if typ != nil && typ.IsInteger() {
+		if prev, ok := r.values[expr]; ok {
+			value = prev.Join(value)
+		}
+		r.values[expr] = value
+	}
+	return value, typ
+}
+
+func (r *valueRanges) evalExpression(expr ast.Expression, in *env) (Interval, *ast.Type) {
+	switch e := expr.(type) {
+	case *ast.IntLiteral:
+		return newInterval(int64(e.Value), int64(e.Value)), ast.Int
+	case *ast.StringLiteral:
+		return Interval{}, ast.PointerTo(ast.Char)
+	case *ast.Identifier:
+		typ := r.types[e.Name]
+		if typ == nil {
+			for _, fn := range r.program.Functions {
+				if fn.Name == e.Name {
+					return Interval{}, fn.Signature()
+				}
+			}
+			return Interval{}, nil
+		}
+		if value, ok := in.values[e.Name]; ok {
+			if in.nonzero[e.Name] {
+				r.nonzero[e] = true
+			}
+			return value, typ
+		}
+		if typ.IsInteger() {
+			return typeRange(typ), typ
+		}
+		return Interval{}, typ
+	case *ast.UnaryOp:
+		value, typ := r.eval(e.Operand, in)
+		if typ == nil {
+			return Interval{}, nil
+		}
+		if e.Operator == "*" {
+			if typ = typ.Decay(); typ.Kind != ast.PointerType {
+				return Interval{}, nil
+			}
+			return anyValue(typ.Elem), typ.Elem
+		}
+		if !typ.IsInteger() {
+			return Interval{}, typ
+		}
+		typ = typ.Promote()
+		exact := Interval{new(big.Int).Neg(value.Hi), new(big.Int).Neg(value.Lo)}
+		r.arith[e] = arithmetic{typ, exact}
+		return fit(exact, typ), typ
+	case *ast.BinaryOp:
+		return r.binary(e, in)
+	case *ast.IndexExpr:
+		_, array := r.eval(e.Array, in)
+		r.eval(e.Index, in)
+		if array != nil && array.Kind == ast.ArrayType {
+			r.access[e] = &access{array: array, path: append([]string(nil), r.path...)}
+		}
+		if array == nil || array.Decay().Kind != ast.PointerType {
+			return Interval{}, nil
+		}
+		elem := array.Decay().Elem
+		return anyValue(elem), elem
+	case *ast.Assignment:
+		value, typ := r.eval(e.Value, in)
+		target, ok := e.Target.(*ast.Identifier)
+		if !ok {
+			_, targetType := r.eval(e.Target, in)
+			if index, ok := e.Target.(*ast.IndexExpr); ok && r.access[index] != nil {
+				r.access[index].write = true
+			}
+			return r.convert(e.Value, value, typ, targetType), targetType
+		}
+		targetType := r.types[target.Name]
+		if targetType == nil {
+			return Interval{}, nil
+		}
+		value = r.convert(e.Value, value, typ, targetType)
+		if targetType.IsInteger() {
+			in.set(target.Name, value)
+		}
+		return value, targetType
+	case *ast.CallExpr:
+		name := calledFunction(r.fn, e)
+		callee := r.unit.callee(name)
+		for i, arg := range e.Args {
+			value, typ := r.eval(arg, in)
+			if callee != nil && i < len(callee.Params) {
+				r.convert(arg, value, typ, callee.Params[i].Type)
+			}
+		}
+		typ := r.resultType(e, in)
+		if r.unit.defines(name) && typ != nil {
+			if returns, ok := r.unit.assumed[name]; ok {
+				if returns == nil {
+					r.unreachable = true
+					return anyValue(typ), typ
+				}
+				return *returns, typ
+			}
+			if summary := r.unit.Summary(name); summary != nil && summary.Returns != nil {
+				return *summary.Returns, typ
+			}
+		}
+		return anyValue(typ), typ
+	}
+	return Interval{}, nil
+}
+
+// binary evaluates a binary operator. Comparisons are decided where the
+// operand ranges allow, and the right operand of && and || is evaluated
+// on the path where the left one does not settle the result
+func (r *valueRanges) binary(e *ast.BinaryOp, in *env) (Interval, *ast.Type) {
+	boolean := newInterval(0, 1)
+	switch e.Operator {
+	case "&&", "||":
+		r.eval(e.Left, in)
+		right := r.refine(e.Left, in, e.Operator == "&&")
+		if right != nil {
+			r.eval(e.Right, right)
+			for name, value := range right.values {
+				if prev, ok := in.values[name]; ok {
+					in.values[name] = prev.Join(value)
+				}
+			}
+		}
+		return boolean, ast.Int
+	}
+
+	left, leftType := r.eval(e.Left, in)
+	right, rightType := r.eval(e.Right, in)
+	if leftType == nil || rightType == nil {
+		return Interval{}, nil
+	}
+	switch e.Operator {
+	case "<", ">", "==":
+		if leftType.IsInteger() && rightType.IsInteger() {
+			return compare(e.Operator, left, right), ast.Int
+		}
+		return boolean, ast.Int
+	}
+
+	// Pointer arithmetic
+	if leftType.Decay().Kind == ast.PointerType {
+		if rightType.Decay().Kind == ast.PointerType {
+			return typeRange(ast.Long), ast.Long
+		}
+		return Interval{}, leftType.Decay()
+	}
+	if rightType.Decay().Kind == ast.PointerType {
+		return Interval{}, rightType.Decay()
+	}
+
+	typ := ast.CommonType(leftType, rightType)
+	if typ == nil || !typ.IsInteger() {
+		return Interval{}, typ
+	}
+	exact, ok := arith(e.Operator, left, right)
+	if !ok {
+		return typeRange(typ), typ
+	}
+	r.arith[e] = arithmetic{typ, exact}
+	return fit(exact, typ), typ
+}
+
+// compare returns the truth values a comparison of values from l and r
+// can have
+func compare(op string, l, r Interval) Interval {
+	var always, never bool
+	switch op {
+	case "<":
+		always, never = l.Hi.Cmp(r.Lo) < 0, l.Lo.Cmp(r.Hi) >= 0
+	case ">":
+		always, never = l.Lo.Cmp(r.Hi) > 0, l.Hi.Cmp(r.Lo) <= 0
+	case "==":
+		always, never = l.IsConstant() && r.IsConstant() && l.Lo.Cmp(r.Lo) == 0, !l.Overlaps(r)
+	}
+	switch {
+	case always:
+		return newInterval(1, 1)
+	case never:
+		return newInterval(0, 0)
+	}
+	return newInterval(0, 1)
+}
+
+// refine returns a copy of in narrowed to the paths on which cond, which
+// has already been evaluated, is truth. It returns nil if there are none
+func (r *valueRanges) refine(cond ast.Expression, in *env, truth bool) *env {
+	if in == nil {
+		return nil
+	}
+	if value, ok := r.values[cond]; ok {
+		if truth && value.IsConstant() && value.Lo.Sign() == 0 || !truth && !value.ContainsValue(0) {
+			return nil
+		}
+	}
+	switch e := cond.(type) {
+	case *ast.BinaryOp:
+		switch e.Operator {
+		case "&&", "||":
+			// a && b is true when both are; a || b is false when both are
+			if (e.Operator == "&&") == truth {
+				return r.refine(e.Right, r.refine(e.Left, in, truth), truth)
+			}
+			return joinEnvs(r.refine(e.Left, in, truth), r.refine(e.Right, r.refine(e.Left, in, !truth), truth))
+		case "<", ">", "==":
+			out := in.copy()
+			if !r.narrow(out, e.Left, e.Operator, e.Right, truth) {
+				return nil
+			}
+			mirrored := map[string]string{"<": ">", ">": "<", "==": "=="}[e.Operator]
+			if !r.narrow(out, e.Right, mirrored, e.Left, truth) {
+				return nil
+			}
+			return out
+		}
+	case *ast.Identifier:
+		// if (x) is if (x != 0)
+		out := in.copy()
+		if !r.narrow(out, e, "==", &ast.IntLiteral{Value: 0}, !truth) {
+			return nil
+		}
+		return out
+	}
+	return in.copy()
+}
+
+// narrow narrows the variable x in e to the values for which x op y is
+// truth, where y has already been evaluated. It reports false if there
+// are no such values. Operands that are not tracked variables are left
+// alone
+func (r *valueRanges) narrow(e *env, x ast.Expression, op string, y ast.Expression, truth bool) bool {
+	id, ok := x.(*ast.Identifier)
+	if !ok {
+		return true
+	}
+	value, ok := e.values[id.Name]
+	if !ok {
+		return true
+	}
+	bound, ok := r.values[y]
+	if lit, isLit := y.(*ast.IntLiteral); isLit {
+		bound, ok = newInterval(int64(lit.Value), int64(lit.Value)), true
+	}
+	if !ok {
+		return true
+	}
+	one := big.NewInt(1)
+	switch {
+	case op == "<" && truth: // x < y
+		value, ok = value.Meet(Interval{value.Lo, new(big.Int).Sub(bound.Hi, one)})
+	case op == "<": // x >= y
+		value, ok = value.Meet(Interval{bound.Lo, value.Hi})
+	case op == ">" && truth: // x > y
+		value, ok = value.Meet(Interval{new(big.Int).Add(bound.Lo, one), value.Hi})
+	case op == ">": // x <= y
+		value, ok = value.Meet(Interval{value.Lo, bound.Hi})
+	case truth: // x == y
+		value, ok = value.Meet(bound)
+	case bound.IsConstant(): // x != y
+		if value.IsConstant() && value.Lo.Cmp(bound.Lo) == 0 {
+			return false
+		}
+		if bound.Lo.Sign() == 0 {
+			e.nonzero[id.Name] = true
+		}
+		if value.Lo.Cmp(bound.Lo) == 0 {
+			value.Lo = new(big.Int).Add(value.Lo, one)
+		} else if value.Hi.Cmp(bound.Lo) == 0 {
+			value.Hi = new(big.Int).Sub(value.Hi, one)
+		}
+	}
+	if !ok {
+		return false
+	}
+	e.values[id.Name] = value
+	return true
+}
+
+// resultType returns the type of the value a call returns, or nil when
+// the callee is unknown
+func (r *valueRanges) resultType(call *ast.CallExpr, in *env) *ast.Type {
+	if id, ok := call.Callee.(*ast.Identifier); ok {
+		if typ, ok := r.types[id.Name]; ok {
+			if typ.IsFuncPointer() {
+				return typ.Elem.Elem
+			}
+			return nil
+		}
+		for _, fn := range r.program.Functions {
+			if fn.Name == id.Name {
+				return fn.ReturnType
+			}
+		}
+		if libc := codegen.LookupLibc(id.Name); libc != nil {
+			return libc.ResultType()
+		}
+		// Undeclared functions are implicitly declared to return int
+		return ast.Int
+	}
+	_, typ := r.eval(call.Callee, in)
+	if typ != nil && typ.IsFuncPointer() {
+		return typ.Elem.Elem
+	}
+	return nil
+}
+
+// convert returns the values value, of type from, has once converted to
+// type to, recording the conversion of expr if it can lose information
+func (r *valueRanges) convert(expr ast.Expression, value Interval, from, to *ast.Type) Interval {
+	if from != nil && to != nil && to.IsInteger() && (from.IsFloating() || from.IsInteger() && typeBits[to.Name] < typeBits[from.Name]) {
+		if c := r.conversions[expr]; c != nil && from.IsInteger() {
+			c.value = c.value.Join(value)
+		} else {
+			r.conversions[expr] = &conversion{from, to, value}
+		}
+	}
+	return convertRange(value, from, to)
+}
+
+// anyValue returns the values an unknown value of type t can have
+func anyValue(t *ast.Type) Interval {
+	if t != nil && t.IsInteger() {
+		return typeRange(t)
+	}
+	return Interval{}
+}
+
+// convertRange returns the values value, of type from, has once converted
+// to type to
+func convertRange(value Interval, from, to *ast.Type) Interval {
+	if to == nil || !to.IsInteger() {
+		return Interval{}
+	}
+	if from == nil || !from.IsInteger() {
+		return typeRange(to)
+	}
+	return fit(value, to)
+}
@@ -0,0 +1,161 @@
+package analysis
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// stackLimit is the stack a recursion is expected to fit in: the 8 MiB
+// main-thread stack of common Linux systems
+const stackLimit = 8 << 20
+
+// checkRecursion reports recursion whose depth no argument bounds, and
+// recursion whose bounded depth can still exhaust the stack. A recursive
+// call is bounded when it passes a parameter that decreases, p - c or
+// p / c, on a path where p is bounded below
+func checkRecursion(unit *Unit) []Finding {
+	program := unit.Program
+	graph := BuildCallGraph(program)
+	target, _ := codegen.LookupTarget(codegen.DefaultTriple)
+	findings := []Finding{}
+	for _, cycle := range graph.Cycles() {
+		members := map[string]bool{}
+		for _, name := range cycle {
+			members[name] = true
+		}
+		route := strings.Join(append(append([]string(nil), cycle...), cycle[0]), " -> ")
+
+		// Only a function calling itself can be shown to terminate; in
+		// mutual recursion the decreasing value passes through other
+		// functions' parameters
+		fn := functionNamed(program, cycle[0])
+		ranges := unit.ranges(fn)
+		var site *ast.CallExpr
+		depth := new(big.Int)
+		bounded := true
+		inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+			call, ok := expr.(*ast.CallExpr)
+			if !ok || !members[calledFunction(fn, call)] {
+				return
+			}
+			if site == nil {
+				site = call
+			}
+			d := recursionDepth(fn, call, ranges)
+			if len(cycle) > 1 || d == nil {
+				bounded = false
+				return
+			}
+			if d.Cmp(depth) > 0 {
+				depth = d
+			}
+		})
+		if !bounded {
+			message := fmt.Sprintf("recursion %s has no argument that decreases toward a bound, so only the stack limits its depth", route)
+			if len(cycle) > 1 {
+				message = fmt.Sprintf("mutual recursion %s cannot be shown to stop, so only the stack limits its depth", route)
+			}
+			findings = append(findings, Finding{
+				Rule:       "recursion",
+				Severity:   Medium,
+				Function:   fn.Name,
+				Pos:        site.Pos,
+				End:        site.End,
+				Message:    message,
+				CWE:        674,
+				Suggestion: "bound the depth with a parameter that decreases on each call and is checked first, or rewrite the recursion iteratively",
+			})
+			continue
+		}
+		frame := big.NewInt(int64(frameSize(fn, target)))
+		stack := new(big.Int).Mul(depth, frame)
+		if stack.Cmp(big.NewInt(stackLimit)) > 0 {
+			findings = append(findings, Finding{
+				Rule:       "recursion",
+				Severity:   Low,
+				Function:   fn.Name,
+				Pos:        site.Pos,
+				End:        site.End,
+				Message:    fmt.Sprintf("recursion %s can nest %s calls deep, about %s bytes of stack at %s bytes a frame", route, depth, stack, frame),
+				CWE:        674,
+				Suggestion: "check the parameter against a smaller limit before recursing",
+			})
+		}
+	}
+	return findings
+}
+
+// recursionDepth returns how many times fn can call itself through call
+// before the recursion stops, or nil if no argument bounds it
+func recursionDepth(fn *ast.Function, call *ast.CallExpr, ranges *valueRanges) *big.Int {
+	var best *big.Int
+	for i, arg := range call.Args {
+		if i >= len(fn.Params) || !fn.Params[i].Type.IsInteger() {
+			continue
+		}
+		step, ok := arg.(*ast.BinaryOp)
+		if !ok || step.Operator != "-" && step.Operator != "/" {
+			continue
+		}
+		param, ok := step.Left.(*ast.Identifier)
+		if !ok || param.Name != fn.Params[i].Name {
+			continue
+		}
+		amount, ok := ranges.values[step.Right]
+		current, known := ranges.values[step.Left]
+		if !ok || !known || !amount.IsConstant() {
+			continue
+		}
+		// The parameter may start anywhere in its type; what bounds the
+		// recursion is the lower bound it must exceed to recurse
+		full := typeRange(fn.Params[i].Type)
+		if current.Lo.Cmp(full.Lo) == 0 {
+			continue
+		}
+		var depth *big.Int
+		switch {
+		case step.Operator == "-" && amount.Lo.Sign() > 0:
+			span := new(big.Int).Sub(full.Hi, current.Lo)
+			depth = span.Quo(span, amount.Lo)
+			depth.Add(depth, big.NewInt(1))
+		case step.Operator == "/" && amount.Lo.Cmp(big.NewInt(1)) > 0 && current.Lo.Sign() > 0:
+			depth = big.NewInt(0)
+			for v := new(big.Int).Set(full.Hi); v.Cmp(current.Lo) >= 0; v.Quo(v, amount.Lo) {
+				depth.Add(depth, big.NewInt(1))
+			}
+		default:
+			continue
+		}
+		if best == nil || depth.Cmp(best) < 0 {
+			best = depth
+		}
+	}
+	return best
+}
+
+// frameSize estimates the stack frame of fn from its parameters and
+// locals on target, with the return address and saved frame pointer
+func frameSize(fn *ast.Function, target *codegen.Target) int {
+	size := 2 * target.PointerSize
+	for _, param := range fn.Params {
+		size += target.SizeOf(param.Type)
+	}
+	inspectDecls(fn.Body.Statements, func(decl *ast.VarDecl) {
+		size += target.SizeOf(decl.Type)
+	})
+	return (size + codegen.StackAlign - 1) / codegen.StackAlign * codegen.StackAlign
+}
+
+// functionNamed returns the function program defines called name, or nil
+func functionNamed(program *ast.Program, name string) *ast.Function {
+	for _, fn := range program.Functions {
+		if fn.Name == name && fn.Body != nil {
+			return fn
+		}
+	}
+	return nil
+}
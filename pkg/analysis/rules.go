@@ -0,0 +1,77 @@
+package analysis
+
+// Rule describes one identifier findings are reported under
+type Rule struct {
+	ID          string
+	Description string
+	CWE         int // weakness the rule's findings are, unless they name a more specific one
+}
+
+// Rules returns the rules of the registered passes, in the order the
+// passes were registered
+func Rules() []Rule {
+	var rules []Rule
+	for _, pass := range Passes() {
+		rules = append(rules, pass.Rules()...)
+	}
+	return rules
+}
+
+// LookupRule returns the rule of a registered pass called id, or nil
+func LookupRule(id string) *Rule {
+	for _, pass := range Passes() {
+		rules := pass.Rules()
+		for i := range rules {
+			if rules[i].ID == id {
+				return &rules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// cweNames are the names of the weaknesses the checks report, as the
+// Common Weakness Enumeration gives them
+var cweNames = map[int]string{
+	20:  "Improper Input Validation",
+	78:  "OS Command Injection",
+	88:  "Argument Injection",
+	120: "Classic Buffer Overflow",
+	125: "Out-of-bounds Read",
+	129: "Improper Validation of Array Index",
+	134: "Use of Externally-Controlled Format String",
+	190: "Integer Overflow or Wraparound",
+	197: "Numeric Truncation Error",
+	242: "Use of Inherently Dangerous Function",
+	259: "Use of Hard-coded Password",
+	321: "Use of Hard-coded Cryptographic Key",
+	329: "Generation of Predictable IV with CBC Mode",
+	367: "Time-of-check Time-of-use (TOCTOU) Race Condition",
+	369: "Divide By Zero",
+	377: "Insecure Temporary File",
+	401: "Missing Release of Memory after Effective Lifetime",
+	415: "Double Free",
+	416: "Use After Free",
+	457: "Use of Uninitialized Variable",
+	476: "NULL Pointer Dereference",
+	481: "Assigning instead of Comparing",
+	561: "Dead Code",
+	562: "Return of Stack Variable Address",
+	606: "Unchecked Input for Loop Condition",
+	628: "Function Call with Incorrectly Specified Arguments",
+	674: "Uncontrolled Recursion",
+	681: "Incorrect Conversion between Numeric Types",
+	676: "Use of Potentially Dangerous Function",
+	787: "Out-of-bounds Write",
+	798: "Use of Hard-coded Credentials",
+	805: "Buffer Access with Incorrect Length Value",
+	825: "Expired Pointer Dereference",
+	835: "Loop with Unreachable Exit Condition ('Infinite Loop')",
+	839: "Numeric Range Comparison Without Minimum Check",
+}
+
+// CWEName returns the name of CWE entry id, or "" if it is not one the
+// checks report
+func CWEName(id int) string {
+	return cweNames[id]
+}
@@ -0,0 +1,226 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// ruleTests has, for each rule, a program it reports and a close one it
+// does not
+var ruleTests = []struct {
+	rule     string
+	positive string
+	negative string
+}{
+	{"taint",
+		`int main() { char *cmd = getenv("CMD"); system(cmd); return 0; }`,
+		`int main() { system("ls"); return 0; }`},
+	{"recursion",
+		`int f(int n) { return f(n - 1); } int main() { return f(3); }`,
+		`int f(int n) { return n - 1; } int main() { return f(3); }`},
+	{"dangerous-call",
+		`int main() { char b[8]; gets(b); return 0; }`,
+		`int main() { char b[8]; fgets(b, 8, stdin); return 0; }`},
+	{"format-string",
+		`int main() { char *s = getenv("X"); printf(s); return 0; }`,
+		`int main() { char *s = getenv("X"); printf("%s", s); return 0; }`},
+	{"format-arguments",
+		`int main() { printf("%d %d\n", 1); return 0; }`,
+		`int main() { printf("%d %d\n", 1, 2); return 0; }`},
+	{"integer-overflow",
+		`int main() { int x = atoi(getenv("X")); return x * 1000; }`,
+		`int main() { int x = 3; return x * 1000; }`},
+	{"lossy-conversion",
+		`int main() { int i = atoi(getenv("X")); char c = i; return c; }`,
+		`int main() { int i = 65; char c = i; return c; }`},
+	{"array-bounds",
+		`int main() { int a[4]; a[4] = 1; return 0; }`,
+		`int main() { int a[4]; a[3] = 1; return 0; }`},
+	{"signed-index",
+		`int main(int argc, char **argv) { int a[4]; int i = atoi(argv[1]); if (i < 4) { a[i] = 1; } return 0; }`,
+		`int main(int argc, char **argv) { int a[4]; int i = atoi(argv[1]); if (i < 4) { if (i > 0 - 1) { a[i] = 1; } } return 0; }`},
+	{"uninitialized",
+		`int main() { int x; return x; }`,
+		`int main() { int x = 0; return x; }`},
+	{"null-dereference",
+		`int main() { int *p = malloc(4); *p = 1; free(p); return 0; }`,
+		`int main() { int *p = malloc(4); if (p == 0) { return 1; } *p = 1; free(p); return 0; }`},
+	{"dangling-pointer",
+		`int main() { int *p = malloc(4); if (p == 0) { return 1; } free(p); *p = 1; return 0; }`,
+		`int main() { int *p = malloc(4); if (p == 0) { return 1; } *p = 1; free(p); return 0; }`},
+	{"memory-leak",
+		`int main() { int *p = malloc(4); if (p == 0) { return 1; } *p = 1; return 0; }`,
+		`int main() { int *p = malloc(4); if (p == 0) { return 1; } *p = 1; free(p); return 0; }`},
+	{"double-free",
+		`int main() { int *p = malloc(4); free(p); free(p); return 0; }`,
+		`int main() { int *p = malloc(4); free(p); return 0; }`},
+	{"toctou",
+		`int main() { if (access("f", 0) == 0) { unlink("f"); } return 0; }`,
+		`int main() { unlink("f"); return 0; }`},
+	{"division-by-zero",
+		`int main() { int z = 0; return 1 / z; }`,
+		`int main() { int z = 2; return 1 / z; }`},
+	{"hardcoded-secret",
+		`int main() { char *password = "hunter2"; return password[0]; }`,
+		`int main() { char *name = "hunter2"; return name[0]; }`},
+	{"hardcoded-key",
+		`int main() { char key[16]; AES_set_encrypt_key("0123456789abcdef", 128, key); return 0; }`,
+		`int main(int argc, char **argv) { char key[16]; AES_set_encrypt_key(argv[1], 128, key); return 0; }`},
+	{"infinite-loop",
+		`int main() { int i = 0; int j = 0; while (i < 10) { j = j + 1; } return j; }`,
+		`int main() { int i = 0; int n = 10; while (i < n) { i = i + 1; } return i; }`},
+	{"unreachable-code",
+		`int main() { int x = 1; return x; x = 2; }`,
+		`int main() { int x = 1; x = 2; return x; }`},
+	{"constant-condition",
+		`int main() { int x = atoi(getenv("X")); if (x = 1) { return 1; } return 0; }`,
+		`int main() { int x = atoi(getenv("X")); if (x == 1) { return 1; } return 0; }`},
+}
+
+// analyze returns the findings of src
+func analyze(t *testing.T, src string) []Finding {
+	t.Helper()
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	findings, err := Analyze(program, nil)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return findings
+}
+
+// reports reports whether analyzing src finds anything under rule
+func reports(t *testing.T, src, rule string) bool {
+	t.Helper()
+	for _, f := range analyze(t, src) {
+		if f.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// TestRules checks that each rule reports its positive case and not its
+// negative one, and that every registered rule has a case
+func TestRules(t *testing.T) {
+	tested := map[string]bool{}
+	for _, test := range ruleTests {
+		tested[test.rule] = true
+		if LookupRule(test.rule) == nil {
+			t.Errorf("%s is not a registered rule", test.rule)
+			continue
+		}
+		if !reports(t, test.positive, test.rule) {
+			t.Errorf("%s: no %s finding", test.positive, test.rule)
+		}
+		if reports(t, test.negative, test.rule) {
+			t.Errorf("%s: unexpected %s finding", test.negative, test.rule)
+		}
+	}
+	for _, rule := range Rules() {
+		if !tested[rule.ID] {
+			t.Errorf("rule %s has no test case", rule.ID)
+		}
+	}
+}
+
+// loopTests has, for checks that follow values around a loop, a program
+// where what the loop does makes them report and a close one where it
+// does not
+var loopTests = []struct {
+	rule     string
+	positive string
+	negative string
+}{
+	{"taint",
+		`int main() { int n = atoi(getenv("N")); int i = 0; while (i < n) { i = i + 1; } return i; }`,
+		`int main() { int n = atoi(getenv("N")); if (n > 100) { return 0; } int i = 0; while (i < n) { i = i + 1; } return i; }`},
+	{"array-bounds",
+		`int main() { int a[10]; int i = 0; while (i < 10) { i = i + 1; } a[i] = 1; return 0; }`,
+		`int main() { int a[10]; int i = 0; while (i < 10) { a[i] = 1; i = i + 1; } return 0; }`},
+	{"uninitialized",
+		`int main() { int x; int i = atoi(getenv("I")); while (i < 3) { x = i; i = i + 1; } return x; }`,
+		`int main() { int x = 0; int i = atoi(getenv("I")); while (i < 3) { x = i; i = i + 1; } return x; }`},
+	{"memory-leak",
+		`int main() { int i = 0; while (i < 3) { char *p = malloc(4); if (p == 0) { return 1; } i = i + 1; } return 0; }`,
+		`int main() { int i = 0; while (i < 3) { char *p = malloc(4); if (p == 0) { return 1; } free(p); i = i + 1; } return 0; }`},
+	{"double-free",
+		`int main() { char *p = malloc(4); int i = 0; while (i < 3) { free(p); i = i + 1; } return 0; }`,
+		`int main() { int i = 0; while (i < 3) { char *p = malloc(4); free(p); i = i + 1; } return 0; }`},
+	{"unreachable-code",
+		`int main() { while (1) { printf("x\n"); } return 0; }`,
+		`int main() { while (1) { if (getchar() == 0) { break; } } return 0; }`},
+}
+
+// TestLoops checks the loopTests
+func TestLoops(t *testing.T) {
+	for _, test := range loopTests {
+		if !reports(t, test.positive, test.rule) {
+			t.Errorf("%s: no %s finding", test.positive, test.rule)
+		}
+		if reports(t, test.negative, test.rule) {
+			t.Errorf("%s: unexpected %s finding", test.negative, test.rule)
+		}
+	}
+}
+
+// TestSignedIndex checks that an index of any signed type checked against
+// the upper bound alone is reported both as out of bounds and as signed,
+// and that one checked against 0 as well is neither
+func TestSignedIndex(t *testing.T) {
+	for _, typ := range []string{"char", "short", "int", "long"} {
+		src := "int f(" + typ + " i) { int a[4]; if (i < 4) { a[i] = 1; } return 0; }"
+		for _, rule := range []string{"array-bounds", "signed-index"} {
+			if !reports(t, src, rule) {
+				t.Errorf("%s: no %s finding", src, rule)
+			}
+		}
+		src = "int f(" + typ + " i) { int a[4]; if (i < 4) { if (i > 0 - 1) { a[i] = 1; } } return 0; }"
+		for _, rule := range []string{"array-bounds", "signed-index"} {
+			if reports(t, src, rule) {
+				t.Errorf("%s: unexpected %s finding", src, rule)
+			}
+		}
+	}
+}
+
+// TestFindingEnd checks that a finding spans the call or statement it is
+// about, and that its diagnostic underlines all of it
+func TestFindingEnd(t *testing.T) {
+	for _, test := range []struct {
+		rule, src, text string
+	}{
+		{"dangerous-call", `int main() { char buf[8]; gets(buf); return 0; }`, "gets(buf)"},
+		{"division-by-zero", `int main() { int z = 0; return 1 / z; }`, "return 1 / z;"},
+		{"infinite-loop", `int main() { int i = 0; while (i < 10) { printf("x"); } return i; }`, `while (i < 10) { printf("x"); }`},
+	} {
+		start := strings.Index(test.src, test.text)
+		want := lexer.Position{Line: 1, Column: start + len(test.text) + 1, Offset: start + len(test.text)}
+		found := false
+		for _, f := range analyze(t, test.src) {
+			if f.Rule != test.rule {
+				continue
+			}
+			found = true
+			if f.Pos.Column != start+1 || f.End != want {
+				t.Errorf("%s: %s finding spans %s to %s, want %d to %s", test.src, test.rule, f.Pos, f.End, start+1, want)
+			}
+			if d := f.Diagnostic("a.c"); d.End != want {
+				t.Errorf("%s: diagnostic ends at %s, want %s", test.src, d.End, want)
+			}
+		}
+		if !found {
+			t.Errorf("%s: no %s finding", test.src, test.rule)
+		}
+	}
+
+	f := Finding{Pos: lexer.Position{Line: 2, Column: 3}}
+	if d := f.Diagnostic("a.c"); d.End != f.Pos {
+		t.Errorf("without an end, the diagnostic ends at %s, want %s", d.End, f.Pos)
+	}
+}
@@ -0,0 +1,298 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// The subset of the SARIF 2.1.0 log format Citadel writes
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool        sarifTool         `json:"tool"`
+	Invocations []sarifInvocation `json:"invocations,omitempty"`
+	Results     []sarifResult     `json:"results"`
+}
+
+type sarifInvocation struct {
+	ExecutionSuccessful        bool                `json:"executionSuccessful"`
+	ToolExecutionNotifications []sarifNotification `json:"toolExecutionNotifications"`
+}
+
+type sarifNotification struct {
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Locations  []sarifLocation `json:"locations"`
+	Properties *sarifStage     `json:"properties,omitempty"`
+}
+
+type sarifStage struct {
+	Stage string `json:"stage"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     *sarifMessage      `json:"shortDescription,omitempty"`
+	HelpURI              string             `json:"helpUri,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           sarifProperties    `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifProperties struct {
+	Tags             []string `json:"tags,omitempty"`
+	SecuritySeverity string   `json:"security-severity,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string             `json:"ruleId"`
+	RuleIndex           int                `json:"ruleIndex"`
+	Level               string             `json:"level"`
+	Message             sarifMessage       `json:"message"`
+	Locations           []sarifLocation    `json:"locations"`
+	PartialFingerprints map[string]string  `json:"partialFingerprints,omitempty"`
+	CodeFlows           []sarifCodeFlow    `json:"codeFlows,omitempty"`
+	Suppressions        []sarifSuppression `json:"suppressions,omitempty"`
+	Properties          *sarifProperties   `json:"properties,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifCodeFlow struct {
+	ThreadFlows []sarifThreadFlow `json:"threadFlows"`
+}
+
+type sarifThreadFlow struct {
+	Locations []sarifThreadFlowLocation `json:"locations"`
+}
+
+type sarifThreadFlowLocation struct {
+	Location sarifLocation `json:"location"`
+}
+
+// sarifLevels maps severities to SARIF result levels
+var sarifLevels = map[Severity]string{
+	Info:     "note",
+	Low:      "note",
+	Medium:   "warning",
+	High:     "error",
+	Critical: "error",
+}
+
+// securitySeverities maps severities to the CVSS-like scores GitHub code
+// scanning ranks security alerts by
+var securitySeverities = map[Severity]string{
+	Info:     "0.0",
+	Low:      "3.0",
+	Medium:   "5.5",
+	High:     "8.0",
+	Critical: "9.5",
+}
+
+// WriteSARIF writes findings in file as a SARIF 2.1.0 log. Each rule
+// reported is described once, at the highest severity it was reported
+// with; findings with a trace carry it as a code flow, and suppressed
+// findings the reason given for suppressing them
+func WriteSARIF(w io.Writer, file string, findings []Finding) error {
+	return WriteSARIFFiles(w, []FileFindings{{file, findings}})
+}
+
+// WriteSARIFFiles writes the findings in several files as one SARIF run,
+// as WriteSARIF does for one
+func WriteSARIFFiles(w io.Writer, files []FileFindings) error {
+	return WriteSARIFDiagnostics(w, files, nil)
+}
+
+// WriteSARIFDiagnostics writes the findings in several files as
+// WriteSARIFFiles does, and the errors and warnings of diags, such as
+// files that did not parse, as notifications of the run's invocation,
+// which did not succeed if there is an error among them. Diagnostics of
+// findings in diags are left out, as files gives them
+func WriteSARIFDiagnostics(w io.Writer, files []FileFindings, diags []diag.Diagnostic) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:    "Citadel",
+			Version: codegen.Version,
+			Rules:   []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	index := map[string]int{}
+	worst := map[string]Severity{}
+	for _, ff := range files {
+		file := ff.File
+		for _, f := range ff.Findings {
+			i, ok := index[f.Rule]
+			if !ok {
+				i = len(run.Tool.Driver.Rules)
+				index[f.Rule] = i
+				rule := sarifRule{
+					ID:         f.Rule,
+					Properties: sarifProperties{Tags: []string{"security"}},
+				}
+				if info := LookupRule(f.Rule); info != nil {
+					rule.ShortDescription = &sarifMessage{info.Description}
+					rule.HelpURI = cweURI(info.CWE)
+				}
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+			}
+			rule := &run.Tool.Driver.Rules[i]
+			// GitHub code scanning reads the weaknesses of a rule from its
+			// tags
+			if tag := cweTag(f.CWE); tag != "" && !hasTag(rule.Properties.Tags, tag) {
+				rule.Properties.Tags = append(rule.Properties.Tags, tag)
+			}
+			if !ok || f.Severity > worst[f.Rule] {
+				worst[f.Rule] = f.Severity
+				rule.DefaultConfiguration.Level = sarifLevels[f.Severity]
+				rule.Properties.SecuritySeverity = securitySeverities[f.Severity]
+			}
+
+			result := sarifResult{
+				RuleID:    f.Rule,
+				RuleIndex: i,
+				Level:     sarifLevels[f.Severity],
+				Message:   sarifMessage{f.Message},
+				Locations: []sarifLocation{sarifLocationOf(file, f.Pos, f.End, "")},
+			}
+			if f.Fingerprint != "" {
+				result.PartialFingerprints = map[string]string{"citadel/v1": f.Fingerprint}
+			}
+			if tag := cweTag(f.CWE); tag != "" {
+				result.Properties = &sarifProperties{Tags: []string{tag}}
+			}
+			if f.Suppressed != nil {
+				result.Suppressions = []sarifSuppression{{"inSource", f.Suppressed.Reason}}
+			}
+			if len(f.Trace) > 0 {
+				flow := sarifThreadFlow{}
+				for _, step := range f.Trace {
+					flow.Locations = append(flow.Locations, sarifThreadFlowLocation{sarifLocationOf(file, step.Pos, lexer.Position{}, step.Message)})
+				}
+				result.CodeFlows = []sarifCodeFlow{{ThreadFlows: []sarifThreadFlow{flow}}}
+			}
+			run.Results = append(run.Results, result)
+		}
+	}
+	var notifications []sarifNotification
+	successful := true
+	for _, d := range diags {
+		if d.Severity != diag.Error && d.Severity != diag.Warning {
+			continue
+		}
+		successful = successful && d.Severity != diag.Error
+		notifications = append(notifications, sarifNotification{
+			Level:      d.Severity,
+			Message:    sarifMessage{d.Message},
+			Locations:  []sarifLocation{sarifLocationOf(d.File, d.Pos, lexer.Position{}, "")},
+			Properties: &sarifStage{d.Stage},
+		})
+	}
+	if notifications != nil {
+		run.Invocations = []sarifInvocation{{successful, notifications}}
+	}
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+// sarifLocationOf returns the location of pos in file, ending before end
+// if that is known, with an optional message. A finding without a
+// position locates the whole file
+func sarifLocationOf(file string, pos, end lexer.Position, message string) sarifLocation {
+	loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(file)},
+	}}
+	if pos.Line > 0 {
+		loc.PhysicalLocation.Region = &sarifRegion{StartLine: pos.Line, StartColumn: pos.Column}
+		if end.Line > 0 {
+			loc.PhysicalLocation.Region.EndLine, loc.PhysicalLocation.Region.EndColumn = end.Line, end.Column
+		}
+	}
+	if message != "" {
+		loc.Message = &sarifMessage{message}
+	}
+	return loc
+}
+
+// cweTag returns the tag SARIF consumers recognize for CWE entry id, or ""
+func cweTag(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprintf("external/cwe/cwe-%d", id)
+}
+
+// cweURI returns the address of the description of CWE entry id, or ""
+func cweURI(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://cwe.mitre.org/data/definitions/%d.html", id)
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
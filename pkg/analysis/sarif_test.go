@@ -0,0 +1,89 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// TestWriteSARIF checks the log written for findings of two rules, one of
+// them reported twice and suppressed once, and one with a trace, and for
+// a file that did not parse
+func TestWriteSARIF(t *testing.T) {
+	const src = "int main() {\n  char *cmd = getenv(\"CMD\");\n  system(cmd);\n  int z = 0;\n  int a = 1 / z;\n  return 2 / z;\n}\n"
+	findings := analyze(t, src)
+	var taint, division int
+	for i := range findings {
+		switch findings[i].Rule {
+		case "taint":
+			taint++
+		case "division-by-zero":
+			if division++; division == 1 {
+				findings[i].Suppressed = &Suppression{Reason: "z is set elsewhere"}
+			}
+		}
+	}
+	if taint != 1 || division != 2 {
+		t.Fatalf("%d taint and %d division-by-zero findings, want 1 and 2: %v", taint, division, findings)
+	}
+	parseError := diag.Diagnostic{File: "b.c", Pos: lexer.Position{Line: 1, Column: 5}, Severity: diag.Error, Stage: "parser", Message: "expected ;"}
+
+	var out bytes.Buffer
+	if err := WriteSARIFDiagnostics(&out, []FileFindings{{"src/a.c", findings}}, []diag.Diagnostic{parseError}); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("version %q, %d runs", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	if len(run.Results) != len(findings) {
+		t.Errorf("%d results, want %d", len(run.Results), len(findings))
+	}
+	suppressed := 0
+	rules := map[string]bool{}
+	for _, rule := range run.Tool.Driver.Rules {
+		if rules[rule.ID] {
+			t.Errorf("rule %s described twice", rule.ID)
+		}
+		rules[rule.ID] = true
+	}
+	for _, result := range run.Results {
+		if result.RuleIndex >= len(run.Tool.Driver.Rules) || run.Tool.Driver.Rules[result.RuleIndex].ID != result.RuleID {
+			t.Errorf("result of %s has rule index %d", result.RuleID, result.RuleIndex)
+		}
+		region := result.Locations[0].PhysicalLocation.Region
+		if result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "src/a.c" || region == nil || region.StartLine == 0 {
+			t.Errorf("result of %s at %+v", result.RuleID, result.Locations[0])
+		}
+		switch result.RuleID {
+		case "taint":
+			if len(result.CodeFlows) != 1 || len(result.CodeFlows[0].ThreadFlows[0].Locations) < 2 {
+				t.Errorf("taint result has code flows %+v, want its trace", result.CodeFlows)
+			}
+			if result.Level != "error" || result.Properties == nil || result.Properties.Tags[0] != "external/cwe/cwe-78" {
+				t.Errorf("taint result has level %s and properties %+v", result.Level, result.Properties)
+			}
+		case "division-by-zero":
+			if len(result.Suppressions) == 1 && result.Suppressions[0].Justification == "z is set elsewhere" {
+				suppressed++
+			}
+		}
+	}
+	if suppressed != 1 {
+		t.Errorf("%d results carry the suppression, want 1", suppressed)
+	}
+	if len(run.Invocations) != 1 || run.Invocations[0].ExecutionSuccessful {
+		t.Fatalf("invocations %+v, want one that did not succeed", run.Invocations)
+	}
+	notification := run.Invocations[0].ToolExecutionNotifications[0]
+	if notification.Message.Text != "expected ;" || notification.Properties.Stage != "parser" || notification.Locations[0].PhysicalLocation.Region.StartColumn != 5 {
+		t.Errorf("notification %+v", notification)
+	}
+}
@@ -0,0 +1,221 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// secretPattern is a form of string literal that is a credential whatever
+// it is used for
+type secretPattern struct {
+	pattern  *regexp.Regexp
+	what     string
+	severity Severity
+}
+
+var secretPatterns = []secretPattern{
+	{regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`), "a private key", Critical},
+	{regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`), "an AWS access key ID", High},
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`), "a GitHub token", High},
+	{regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), "a Slack token", High},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`), "a Google API key", High},
+	{regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`), "a Stripe secret key", High},
+	{regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^/:@\s]+:[^/@\s]+@`), "a URL with a password in it", High},
+}
+
+// secretName matches the names of variables that hold credentials
+var secretName = regexp.MustCompile(`(?i)(passw(or)?d|passwd|pwd|secret|token|api_?key|credential)`)
+
+// passwordName matches those that hold passwords
+var passwordName = regexp.MustCompile(`(?i)(passw(or)?d|passwd|pwd)`)
+
+// keyArgs are the arguments of cryptographic library functions that take
+// a key or an initialization vector, by argument index
+var keyArgs = map[string]map[int]string{
+	"AES_set_encrypt_key": {0: "key"},
+	"AES_set_decrypt_key": {0: "key"},
+	"AES_cbc_encrypt":     {4: "iv"},
+	"DES_set_key":         {0: "key"},
+	"HMAC":                {1: "key"},
+	"EVP_EncryptInit":     {2: "key", 3: "iv"},
+	"EVP_DecryptInit":     {2: "key", 3: "iv"},
+	"EVP_CipherInit":      {2: "key", 3: "iv"},
+	"EVP_EncryptInit_ex":  {3: "key", 4: "iv"},
+	"EVP_DecryptInit_ex":  {3: "key", 4: "iv"},
+	"EVP_CipherInit_ex":   {3: "key", 4: "iv"},
+}
+
+// comparisons are the library functions that compare their first two
+// arguments
+var comparisons = map[string]bool{"strcmp": true, "strncmp": true, "memcmp": true}
+
+// checkSecrets reports string literals that are credentials: literals in
+// the form of a private key or a well-known kind of token, literals
+// assigned to or compared with variables named like passwords, keys and
+// tokens, and constants passed to cryptographic functions as keys or
+// initialization vectors
+func checkSecrets(fn *ast.Function, unit *Unit) []Finding {
+	findings := []Finding{}
+	reported := map[*ast.StringLiteral]bool{}
+	secret := func(lit *ast.StringLiteral, severity Severity, cwe int, format string, args ...interface{}) {
+		if reported[lit] {
+			return
+		}
+		reported[lit] = true
+		findings = append(findings, Finding{
+			Rule:       "hardcoded-secret",
+			Severity:   severity,
+			Function:   fn.Name,
+			Pos:        lit.Pos,
+			End:        lit.End,
+			Message:    fmt.Sprintf(format, args...),
+			CWE:        cwe,
+			Suggestion: "load the secret at run time from a protected file, the environment or a secrets manager",
+		})
+	}
+	named := func(name string, value ast.Expression) {
+		lit, ok := value.(*ast.StringLiteral)
+		if !ok || lit.Value == "" || !secretName.MatchString(name) {
+			return
+		}
+		cwe := 798
+		if passwordName.MatchString(name) {
+			cwe = 259
+		}
+		secret(lit, High, cwe, "%s is set to the constant %s", name, redact(lit.Value))
+	}
+
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		switch e := expr.(type) {
+		case *ast.StringLiteral:
+			for _, p := range secretPatterns {
+				if p.pattern.MatchString(e.Value) {
+					secret(e, p.severity, 798, "the string literal contains %s", p.what)
+					break
+				}
+			}
+		case *ast.Assignment:
+			if id, ok := e.Target.(*ast.Identifier); ok {
+				named(id.Name, e.Value)
+			}
+		case *ast.CallExpr:
+			if name := calledFunction(fn, e); comparisons[name] && len(e.Args) >= 2 {
+				for i, arg := range e.Args[:2] {
+					if id, ok := e.Args[1-i].(*ast.Identifier); ok {
+						if lit, ok := arg.(*ast.StringLiteral); ok && secretName.MatchString(id.Name) {
+							secret(lit, High, 259, "%s compares %s with the constant %s", name, id.Name, redact(lit.Value))
+						}
+					}
+				}
+			}
+		}
+		if decl, ok := stmt.(*ast.VarDecl); ok && expr == decl.Value {
+			named(decl.Name, decl.Value)
+		}
+	})
+
+	// Keys and initialization vectors: a constant is a string literal, or
+	// a variable only ever set to one, or filled from one or with a fixed
+	// byte
+	constants := map[string]string{}
+	varying := map[string]bool{}
+	set := func(name string, value ast.Expression, how string) {
+		if how == "" {
+			if lit, ok := value.(*ast.StringLiteral); ok {
+				how = "the string literal at " + lit.Pos.String()
+			}
+		}
+		if how == "" || varying[name] {
+			varying[name] = true
+			delete(constants, name)
+			return
+		}
+		constants[name] = how
+	}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		switch e := expr.(type) {
+		case *ast.Assignment:
+			if id, ok := e.Target.(*ast.Identifier); ok {
+				set(id.Name, e.Value, "")
+			}
+		case *ast.CallExpr:
+			name := calledFunction(fn, e)
+			if len(e.Args) < 2 {
+				return
+			}
+			dst, ok := e.Args[0].(*ast.Identifier)
+			if !ok {
+				return
+			}
+			switch name {
+			case "strcpy", "strncpy", "memcpy":
+				if lit, ok := e.Args[1].(*ast.StringLiteral); ok {
+					set(dst.Name, nil, fmt.Sprintf("the string literal %s copies in at %s", name, lit.Pos))
+				} else {
+					set(dst.Name, nil, "")
+				}
+			case "memset":
+				if b, ok := e.Args[1].(*ast.IntLiteral); ok {
+					set(dst.Name, nil, fmt.Sprintf("the byte %d memset fills it with at %s", b.Value, e.Pos))
+				} else {
+					set(dst.Name, nil, "")
+				}
+			}
+		}
+		if decl, ok := stmt.(*ast.VarDecl); ok && expr == decl.Value {
+			set(decl.Name, decl.Value, "")
+		}
+	})
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return
+		}
+		name := calledFunction(fn, call)
+		for i := range call.Args {
+			role, ok := keyArgs[name][i]
+			if !ok {
+				continue
+			}
+			from := ""
+			switch arg := call.Args[i].(type) {
+			case *ast.StringLiteral:
+				from = "the string literal at " + arg.Pos.String()
+			case *ast.Identifier:
+				if how, ok := constants[arg.Name]; ok {
+					from = fmt.Sprintf("%s holds %s", arg.Name, how)
+				}
+			}
+			if from == "" {
+				continue
+			}
+			finding := Finding{
+				Rule:       "hardcoded-key",
+				Severity:   High,
+				Function:   fn.Name,
+				Pos:        call.Pos,
+				End:        call.End,
+				Message:    fmt.Sprintf("%s is given a constant key: %s", name, from),
+				CWE:        321,
+				Suggestion: "derive keys with a key-derivation function or load them from protected storage",
+			}
+			if role == "iv" {
+				finding.Severity, finding.CWE = Medium, 329
+				finding.Message = fmt.Sprintf("%s is given a constant initialization vector: %s", name, from)
+				finding.Suggestion = "generate a fresh random initialization vector for every message, e.g. with RAND_bytes"
+			}
+			findings = append(findings, finding)
+		}
+	})
+	return findings
+}
+
+// redact shortens a secret for messages, so reports do not spread it
+func redact(secret string) string {
+	if len(secret) <= 4 {
+		return `"…"`
+	}
+	return `"` + secret[:4] + `…"`
+}
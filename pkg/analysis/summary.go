@@ -0,0 +1,229 @@
+package analysis
+
+import (
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// Summary is what a call to a function the program defines can rely on,
+// whatever arguments it passes. The range, null and dangling-pointer
+// checks apply summaries at call sites. The taint check does not need
+// them: it follows untrusted data into each call with the arguments the
+// call passes
+type Summary struct {
+	Function string
+	// Returns holds the values an integer result can take, or is nil
+	Returns *Interval
+	// NullResult says where a pointer result may be null, e.g. "f can
+	// return NULL at 4:9", or is empty
+	NullResult string
+	// Allocates is true when the result may be a heap block the function
+	// allocated
+	Allocates bool
+	// Frees maps the pointer parameters whose memory the function frees to
+	// whether it does so on every path
+	Frees map[int]bool
+	// ReturnsParams are the pointer parameters the result may point into:
+	// the memory they refer to escapes through the result
+	ReturnsParams []int
+}
+
+// freesArg reports whether the function may free the memory argument i
+// refers to, and whether it does so on every path. A nil summary frees
+// nothing
+func (s *Summary) freesArg(i int) (definite, frees bool) {
+	if s == nil {
+		return false, false
+	}
+	definite, frees = s.Frees[i]
+	return definite, frees
+}
+
+// ranges returns the range pass over fn, computed once per unit
+func (u *Unit) ranges(fn *ast.Function) *valueRanges {
+	if u.rangesOf == nil {
+		u.rangesOf = map[*ast.Function]*valueRanges{}
+	}
+	r, ok := u.rangesOf[fn]
+	if !ok {
+		r = computeRanges(fn, u)
+		u.rangesOf[fn] = r
+	}
+	return r
+}
+
+// widenAfter is how many rounds the returns of a recursion cycle can grow
+// before the bounds still growing are widened
+const widenAfter = 3
+
+// returns computes the values fn can return if its result is an integer.
+// Around a recursion cycle they depend on each other, so the returns of
+// the functions in the cycle start out empty, a call that has nothing to
+// return ending its path, and are recomputed until they settle. Widening
+// after widenAfter rounds guarantees they do. While a cycle is being
+// computed, other routes into it see no returns
+func (u *Unit) returns(fn *ast.Function) *Interval {
+	if !fn.ReturnType.IsInteger() {
+		return nil
+	}
+	if u.callGraph == nil {
+		u.callGraph = BuildCallGraph(u.Program)
+		u.cycles = map[string][]string{}
+		for _, names := range u.callGraph.Cycles() {
+			for _, name := range names {
+				u.cycles[name] = names
+			}
+		}
+		u.assumed = map[string]*Interval{}
+		u.cycleReturns = map[string]*Interval{}
+	}
+	if r, ok := u.cycleReturns[fn.Name]; ok {
+		return r
+	}
+	if _, ok := u.assumed[fn.Name]; ok {
+		return nil
+	}
+	var cycle []*ast.Function
+	for _, name := range u.cycles[fn.Name] {
+		if member := functionNamed(u.Program, name); member.ReturnType.IsInteger() {
+			cycle = append(cycle, member)
+		}
+	}
+	if cycle == nil {
+		return computeRanges(fn, u).returns
+	}
+
+	for _, member := range cycle {
+		u.assumed[member.Name] = nil
+	}
+	for round := 1; ; round++ {
+		changed := false
+		for _, member := range cycle {
+			old, next := u.assumed[member.Name], computeRanges(member, u).returns
+			switch {
+			case next == nil || old != nil && old.Contains(*next):
+				continue
+			case old == nil:
+			case round > widenAfter:
+				widened := old.Widen(*next, member.ReturnType)
+				next = &widened
+			default:
+				joined := old.Join(*next)
+				next = &joined
+			}
+			u.assumed[member.Name] = next
+			changed = true
+		}
+		if !changed {
+			break
+		}
+	}
+	for _, member := range cycle {
+		u.cycleReturns[member.Name] = u.assumed[member.Name]
+		delete(u.assumed, member.Name)
+	}
+	return u.cycleReturns[fn.Name]
+}
+
+// Summary returns the summary of the function the program defines
+// called name, or nil if there is none. Summaries are computed on first
+// use, callees first, unless Config.Facts has them already. A function
+// being summarized has no summary, so calls around a recursion cycle get
+// none, except for the values they return. The summary of a function the
+// program only declares is the one in Config.Facts, if any
+func (u *Unit) Summary(name string) *Summary {
+	if u.summaries == nil {
+		u.summaries = map[string]*Summary{}
+	}
+	if s, ok := u.summaries[name]; ok {
+		return s
+	}
+	if s := u.Config.Facts.Lookup(name); s != nil {
+		u.summaries[name] = s
+		return s
+	}
+	fn := functionNamed(u.Program, name)
+	if fn == nil {
+		return nil
+	}
+	u.summaries[name] = nil
+	s := &Summary{Function: name, Frees: map[int]bool{}}
+	s.Returns = u.returns(fn)
+
+	n := &nullChecks{fn: fn, program: u.Program, unit: u, types: map[string]*ast.Type{}}
+	for _, param := range fn.Params {
+		n.types[param.Name] = param.Type
+	}
+	n.statements(fn.Body.Statements, nullEnv{})
+	s.NullResult = n.result.origin
+
+	l := &lifetimes{fn: fn, program: u.Program, unit: u, types: map[string]*ast.Type{}, sites: map[allocation]int{}, reported: map[int]bool{}, returnedAllocs: map[int]bool{}}
+	params := map[int]int{} // allocations of the memory parameters point to
+	entry := newLifetimeEnv()
+	for i, param := range fn.Params {
+		l.types[param.Name] = param.Type
+		if param.Type.Kind == ast.PointerType {
+			params[i] = l.allocate(fn.Body.Pos, lexer.Position{}, "the memory "+param.Name+" points to", false, entry)
+			entry.points[param.Name] = params[i]
+		}
+	}
+	out, _ := l.statements(fn.Body.Statements, entry, false)
+	exit := joinLifetimes(l.exit, out)
+	if exit == nil {
+		exit = entry
+	}
+	fromParam := map[int]bool{}
+	for i := range fn.Params {
+		id, ok := params[i]
+		if !ok {
+			continue
+		}
+		fromParam[id] = true
+		if d, ok := exit.dead[id]; ok {
+			s.Frees[i] = d.definite
+		}
+		if l.returnedAllocs[id] {
+			s.ReturnsParams = append(s.ReturnsParams, i)
+		}
+	}
+	for id := range l.returnedAllocs {
+		s.Allocates = s.Allocates || !l.allocs[id].local && !fromParam[id]
+	}
+
+	u.summaries[name] = s
+	return s
+}
+
+// settledSummaries returns the functions the program defines whose
+// summaries do not depend on the order they were computed in: those that
+// cannot reach a cycle of several functions, in which the summaries of
+// the others are missing while each is computed, whichever comes first
+func (u *Unit) settledSummaries() []string {
+	graph := BuildCallGraph(u.Program)
+	unsettled := map[string]bool{}
+	for _, cycle := range graph.Cycles() {
+		if len(cycle) > 1 {
+			for _, name := range cycle {
+				unsettled[name] = true
+			}
+		}
+	}
+	// Callers of unsettled functions are unsettled, until none is added
+	for changed := len(unsettled) > 0; changed; {
+		changed = false
+		for caller, callees := range graph.Calls {
+			for _, callee := range callees {
+				if unsettled[callee] && !unsettled[caller] {
+					unsettled[caller], changed = true, true
+				}
+			}
+		}
+	}
+	var names []string
+	for _, name := range graph.Functions {
+		if !unsettled[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
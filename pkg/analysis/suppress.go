@@ -0,0 +1,96 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// Suppression is a comment that silences the findings of some rules on
+// one line:
+//
+//	x = a + b; // citadel:ignore integer-overflow a and b are bytes
+//	// citadel:ignore-next-line null-dereference checked by the caller
+//
+// A citadel:ignore comment alone on its line has no code to silence, so
+// it applies to the next line like citadel:ignore-next-line
+// Several rules can be named separated by commas, and "all" names every
+// rule
+type Suppression struct {
+	Pos    lexer.Position // where the comment is
+	Line   int            // line whose findings it suppresses
+	Rules  []string
+	Reason string
+}
+
+// suppresses reports whether s silences f
+func (s *Suppression) suppresses(f Finding) bool {
+	if f.Pos.Line != s.Line {
+		return false
+	}
+	for _, rule := range s.Rules {
+		if rule == "all" || rule == f.Rule {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSuppressions returns the suppressions among comments, and an error
+// for each that names no rule or one that does not exist
+func ParseSuppressions(comments []lexer.Comment) ([]Suppression, []error) {
+	var suppressions []Suppression
+	var errs []error
+	for _, c := range comments {
+		text := strings.TrimPrefix(c.Text, "//")
+		if strings.HasPrefix(c.Text, "/*") {
+			text = strings.TrimSuffix(strings.TrimPrefix(c.Text, "/*"), "*/")
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		s := Suppression{Pos: c.Pos}
+		switch fields[0] {
+		case "citadel:ignore":
+			s.Line = c.Pos.Line
+			if c.Alone {
+				s.Line = c.Pos.Line + 1 + strings.Count(c.Text, "\n")
+			}
+		case "citadel:ignore-next-line":
+			s.Line = c.Pos.Line + 1 + strings.Count(c.Text, "\n")
+		default:
+			continue
+		}
+		if len(fields) < 2 {
+			errs = append(errs, fmt.Errorf("%s: %s names no rule", c.Pos, fields[0]))
+			continue
+		}
+		s.Rules = strings.Split(fields[1], ",")
+		s.Reason = strings.Join(fields[2:], " ")
+		valid := true
+		for _, rule := range s.Rules {
+			if rule != "all" && LookupRule(rule) == nil {
+				errs = append(errs, fmt.Errorf("%s: %s names unknown rule %q", c.Pos, fields[0], rule))
+				valid = false
+			}
+		}
+		if valid {
+			suppressions = append(suppressions, s)
+		}
+	}
+	return suppressions, errs
+}
+
+// Suppress marks the findings the suppressions silence
+func Suppress(findings []Finding, suppressions []Suppression) {
+	for i := range findings {
+		for j := range suppressions {
+			if suppressions[j].suppresses(findings[i]) {
+				findings[i].Suppressed = &suppressions[j]
+				break
+			}
+		}
+	}
+}
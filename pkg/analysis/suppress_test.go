@@ -0,0 +1,82 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// TestSuppressionLine checks which line each form of suppression silences,
+// and that a citadel:ignore alone on its line applies to the next one
+func TestSuppressionLine(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		line int
+	}{
+		{"int x = 1; // citadel:ignore all\n", 1},
+		{"int x = 1; /* citadel:ignore all */\n", 1},
+		{"/* citadel:ignore all */ int x = 1;\n", 1},
+		{"// citadel:ignore all\nint x = 1;\n", 2},
+		{"  /* citadel:ignore all\n   */\nint x = 1;\n", 3},
+		{"// citadel:ignore-next-line all\nint x = 1;\n", 2},
+		{"int x = 1; // citadel:ignore-next-line all\nint y = 2;\n", 2},
+	} {
+		lex := lexer.New(test.src)
+		for tok := lex.NextToken(); tok.Type != lexer.EOF; tok = lex.NextToken() {
+		}
+		suppressions, errs := ParseSuppressions(lex.Comments())
+		if len(errs) != 0 || len(suppressions) != 1 {
+			t.Fatalf("%q: got %v, %v, want one suppression", test.src, suppressions, errs)
+		}
+		if got := suppressions[0].Line; got != test.line {
+			t.Errorf("%q: suppresses line %d, want %d", test.src, got, test.line)
+		}
+	}
+}
+
+// TestSuppress checks that a suppression silences the findings of the rules
+// it names on its line, and no others, and that naming an unknown rule or
+// none is an error
+func TestSuppress(t *testing.T) {
+	for _, test := range []struct {
+		comment    string
+		suppressed bool
+		errs       int
+	}{
+		{"// citadel:ignore division-by-zero z is never 0", true, 0},
+		{"// citadel:ignore all", true, 0},
+		{"// citadel:ignore uninitialized,division-by-zero", true, 0},
+		{"// citadel:ignore uninitialized", false, 0},
+		{"// citadel:ignore no-such-rule", false, 1},
+		{"// citadel:ignore", false, 1},
+	} {
+		src := "int main() {\n  int z = 0;\n  return 1 / z; " + test.comment + "\n}\n"
+		lex := lexer.New(src)
+		program, err := parser.New(lex).ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		findings, err := Analyze(program, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		suppressions, errs := ParseSuppressions(lex.Comments())
+		if len(errs) != test.errs {
+			t.Errorf("%s: got errors %v, want %d", test.comment, errs, test.errs)
+		}
+		Suppress(findings, suppressions)
+		found := false
+		for _, f := range findings {
+			if f.Rule == "division-by-zero" {
+				found = true
+				if suppressed := f.Suppressed != nil; suppressed != test.suppressed {
+					t.Errorf("%s: suppressed %v, want %v", test.comment, suppressed, test.suppressed)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: no division-by-zero finding", src)
+		}
+	}
+}
@@ -0,0 +1,724 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// TaintConfig describes where untrusted data enters a program, what makes
+// it safe to use, and where it must not arrive unchecked. Argument and
+// parameter indexes count from 0
+type TaintConfig struct {
+	Sources    []TaintSource `json:"sources"`
+	Sanitizers []string      `json:"sanitizers"` // functions whose result, and whose arguments afterwards, are safe
+	Sinks      []TaintSink   `json:"sinks"`
+}
+
+// TaintSource is a function that produces untrusted data
+type TaintSource struct {
+	Function string `json:"function"`
+	// Result marks the return value as untrusted
+	Result bool `json:"result,omitempty"`
+	// Args are the arguments whose pointee the function fills with
+	// untrusted data, like the buffer of read
+	Args []int `json:"args,omitempty"`
+	// Params are the parameters of the function, when the program defines
+	// it, that hold untrusted data on entry, like argv in main
+	Params []int `json:"params,omitempty"`
+	// Origin describes the data, e.g. "the environment"
+	Origin string `json:"origin"`
+	// annotated marks sources declared in the program, which apply even
+	// to functions it defines
+	annotated bool
+}
+
+// TaintSink is a use of data that untrusted data must not reach
+type TaintSink struct {
+	// Function is the called function, "[]" for the subscript of any
+	// array, or "while" for what the condition of any loop compares
+	Function string `json:"function"`
+	Args     []int  `json:"args,omitempty"`
+	// Rest extends Args to every argument after the last one it lists,
+	// for the variable arguments of functions like execl
+	Rest     bool     `json:"rest,omitempty"`
+	Severity Severity `json:"severity"`
+	CWE      int      `json:"cwe"`
+	// Use describes what the sink does with the data, e.g. "runs it as a
+	// shell command"
+	Use string `json:"use"`
+}
+
+// indexSink and loopSink are the TaintSink function names standing for
+// array subscripts and for the operands of the comparisons in the
+// condition of a loop
+const (
+	indexSink = "[]"
+	loopSink  = "while"
+)
+
+// arguments returns the indexes of the arguments the sink uses in a call
+// passing n
+func (s TaintSink) arguments(n int) []int {
+	indexes := s.Args
+	if s.Rest && len(s.Args) > 0 {
+		indexes = append([]int(nil), s.Args...)
+		for i := s.Args[len(s.Args)-1] + 1; i < n; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// DefaultTaintConfig returns the sources, sanitizers and sinks the taint
+// check uses unless configured otherwise
+func DefaultTaintConfig() *TaintConfig {
+	return &TaintConfig{
+		Sources: []TaintSource{
+			{Function: "main", Params: []int{1}, Origin: "the command line"},
+			{Function: "getenv", Result: true, Origin: "the environment"},
+			{Function: "read", Args: []int{1}, Origin: "a file descriptor"},
+			{Function: "recv", Args: []int{1}, Origin: "the network"},
+			{Function: "recvfrom", Args: []int{1}, Origin: "the network"},
+			{Function: "fgets", Result: true, Args: []int{0}, Origin: "a stream"},
+			{Function: "gets", Result: true, Args: []int{0}, Origin: "standard input"},
+		},
+		Sinks: []TaintSink{
+			{Function: "system", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs it as a shell command"},
+			{Function: "popen", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs it as a shell command"},
+			{Function: "execl", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs the program it names"},
+			{Function: "execlp", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs the program it names"},
+			{Function: "execle", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs the program it names"},
+			{Function: "execv", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs the program it names"},
+			{Function: "execvp", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs the program it names"},
+			{Function: "execve", Args: []int{0}, Severity: Critical, CWE: 78, Use: "runs the program it names"},
+			{Function: "execl", Args: []int{1}, Rest: true, Severity: High, CWE: 88, Use: "passes it to a program as an argument"},
+			{Function: "execlp", Args: []int{1}, Rest: true, Severity: High, CWE: 88, Use: "passes it to a program as an argument"},
+			{Function: "execle", Args: []int{1}, Rest: true, Severity: High, CWE: 88, Use: "passes it to a program as an argument"},
+			{Function: "execv", Args: []int{1}, Severity: High, CWE: 88, Use: "passes it to a program as its arguments"},
+			{Function: "execvp", Args: []int{1}, Severity: High, CWE: 88, Use: "passes it to a program as its arguments"},
+			{Function: "execve", Args: []int{1}, Severity: High, CWE: 88, Use: "passes it to a program as its arguments"},
+			{Function: "memcpy", Args: []int{2}, Severity: High, CWE: 805, Use: "uses it as the number of bytes to copy"},
+			{Function: "memmove", Args: []int{2}, Severity: High, CWE: 805, Use: "uses it as the number of bytes to copy"},
+			{Function: indexSink, Severity: High, CWE: 129, Use: "uses it as an array index"},
+			{Function: loopSink, Severity: Medium, CWE: 606, Use: "bounds how often the loop runs"},
+		},
+	}
+}
+
+// LoadTaintConfig reads a taint configuration from a JSON file
+func LoadTaintConfig(path string) (*TaintConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &TaintConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// withAnnotations returns config extended with the sources, sinks and
+// sanitizers program declares with attributes on its functions:
+// citadel_source, whose result is untrusted, or with arguments, whose
+// arguments it fills with untrusted data; citadel_sink(n, ...), whose
+// arguments must not receive untrusted data; and citadel_sanitizer.
+// Attribute arguments count from 1, as those of nonnull and format do
+func (config *TaintConfig) withAnnotations(program *ast.Program) (*TaintConfig, error) {
+	extended := &TaintConfig{}
+	if config != nil {
+		extended.Sources = append([]TaintSource(nil), config.Sources...)
+		extended.Sanitizers = append([]string(nil), config.Sanitizers...)
+		extended.Sinks = append([]TaintSink(nil), config.Sinks...)
+	}
+	annotated := false
+	for _, fn := range program.Functions {
+		for _, attr := range fn.Attributes {
+			var args []int
+			switch attr.Name {
+			case "citadel_source", "citadel_sink", "citadel_sanitizer":
+				for _, arg := range attr.Args {
+					var n int
+					if _, err := fmt.Sscanf(arg, "%d", &n); err != nil || n < 1 || n > len(fn.Params) {
+						return nil, fmt.Errorf("%s: %s argument %s is not a parameter number between 1 and %d", fn.Name, attr.Name, arg, len(fn.Params))
+					}
+					args = append(args, n-1)
+				}
+			default:
+				continue
+			}
+			annotated = true
+			switch attr.Name {
+			case "citadel_source":
+				source := TaintSource{Function: fn.Name, Args: args, Origin: "a source declared with citadel_source", annotated: true}
+				source.Result = len(args) == 0
+				extended.Sources = append(extended.Sources, source)
+			case "citadel_sink":
+				if len(args) == 0 {
+					return nil, fmt.Errorf("%s: citadel_sink needs the numbers of the parameters that are sinks", fn.Name)
+				}
+				sink := TaintSink{Function: fn.Name, Args: args, Severity: High, Use: "is declared a sink with citadel_sink"}
+				extended.Sinks = append(extended.Sinks, sink)
+			case "citadel_sanitizer":
+				extended.Sanitizers = append(extended.Sanitizers, fn.Name)
+			}
+		}
+	}
+	if !annotated {
+		return config, nil
+	}
+	return extended, nil
+}
+
+// copyFunctions are library functions that copy the data of their other
+// arguments into their first
+var copyFunctions = map[string]bool{
+	"strcpy": true, "strncpy": true, "strcat": true, "strncat": true,
+	"memcpy": true, "memmove": true, "sprintf": true, "snprintf": true,
+}
+
+// step is one hop of untrusted data on its way from a source. A nil step
+// stands for trusted data
+type step struct {
+	pos     lexer.Position
+	message string
+	prev    *step
+	source  string // what produced the data, on the first step
+}
+
+// sourceStep returns the first step of data that source produces
+func sourceStep(pos lexer.Position, source, format string, args ...interface{}) *step {
+	return &step{pos: pos, message: fmt.Sprintf(format, args...), source: source}
+}
+
+func (s *step) then(pos lexer.Position, format string, args ...interface{}) *step {
+	return &step{pos: pos, message: fmt.Sprintf(format, args...), prev: s}
+}
+
+// origin returns the first step, at the source
+func (s *step) origin() *step {
+	for s.prev != nil {
+		s = s.prev
+	}
+	return s
+}
+
+// trace returns the steps leading to s, oldest first
+func (s *step) trace() []TraceStep {
+	var steps []TraceStep
+	for ; s != nil; s = s.prev {
+		steps = append([]TraceStep{{Pos: s.pos, Message: s.message}}, steps...)
+	}
+	return steps
+}
+
+// taintEnv maps the variables holding untrusted data, or pointing to it,
+// to the last step that brought it there
+type taintEnv map[string]*step
+
+func (e taintEnv) copy() taintEnv {
+	c := taintEnv{}
+	for name, s := range e {
+		c[name] = s
+	}
+	return c
+}
+
+// untrusted returns the variables e maps to untrusted data
+func (e taintEnv) untrusted() []string {
+	var names []string
+	for name, s := range e {
+		if s != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// joinTaint merges two paths that meet: data untrusted on either is
+// untrusted
+func joinTaint(a, b taintEnv) taintEnv {
+	j := a.copy()
+	for name, s := range b {
+		if j[name] == nil {
+			j[name] = s
+		}
+	}
+	return j
+}
+
+// taintAnalysis follows untrusted data through a program. Calls to
+// functions the program defines are analyzed in the context of the
+// arguments they are given, so data is followed across calls
+type taintAnalysis struct {
+	program  *ast.Program
+	unit     *Unit
+	config   *TaintConfig
+	findings []Finding
+	reported map[string]bool
+	active   map[*ast.Function]bool // functions being analyzed, to stop at recursion
+}
+
+// taintFrame is the analysis of one function in one calling context
+type taintFrame struct {
+	fn     *ast.Function
+	env    taintEnv
+	result *step // untrusted data the function may return
+}
+
+// checkTaint reports untrusted data from the configured sources that
+// reaches a sink without passing through a sanitizer, with the path it
+// takes
+func checkTaint(unit *Unit, config *TaintConfig) []Finding {
+	t := newTaintAnalysis(unit, config)
+	t.run(nil)
+	return t.findings
+}
+
+func newTaintAnalysis(unit *Unit, config *TaintConfig) *taintAnalysis {
+	return &taintAnalysis{
+		program:  unit.Program,
+		unit:     unit,
+		config:   config,
+		findings: []Finding{},
+		reported: map[string]bool{},
+		active:   map[*ast.Function]bool{},
+	}
+}
+
+// run analyzes each function the program defines from its entry, with
+// the parameters the sources name untrusted, passing the frame at its
+// end to done unless it is nil
+func (t *taintAnalysis) run(done func(frame *taintFrame)) {
+	for _, fn := range t.program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		params := make([]*step, len(fn.Params))
+		for _, source := range t.config.Sources {
+			if source.Function != fn.Name {
+				continue
+			}
+			for _, i := range source.Params {
+				if i < len(params) {
+					params[i] = sourceStep(fn.Body.Pos, fn.Params[i].Name+" of "+fn.Name, "%s receives %s from %s", fn.Name, fn.Params[i].Name, source.Origin)
+				}
+			}
+		}
+		t.unit.Visited(fn)
+		frame := t.function(fn, params)
+		if done != nil {
+			done(frame)
+		}
+	}
+}
+
+// TaintFact is a variable that may hold untrusted data, or point to it,
+// when a function returns, with the path the data took to it
+type TaintFact struct {
+	Function string
+	Variable string
+	Trace    []TraceStep
+}
+
+// TaintFacts returns the variables of the functions the program defines
+// that may hold untrusted data when they return, each function analyzed
+// from its entry as the taint check analyzes it, in the order of the
+// functions and then of the variables' names. A nil config uses
+// DefaultTaintConfig, extended with the program's annotations
+func TaintFacts(program *ast.Program, config *TaintConfig) ([]TaintFact, error) {
+	if config == nil {
+		config = DefaultTaintConfig()
+	}
+	config, err := config.withAnnotations(program)
+	if err != nil {
+		return nil, err
+	}
+	settings := DefaultConfig()
+	settings.Taint = config
+	unit := &Unit{Program: program, Config: settings, results: map[string][]Finding{}}
+	t := newTaintAnalysis(unit, config)
+	var facts []TaintFact
+	t.run(func(frame *taintFrame) {
+		names := frame.env.untrusted()
+		sort.Strings(names)
+		for _, name := range names {
+			facts = append(facts, TaintFact{frame.fn.Name, name, frame.env[name].trace()})
+		}
+	})
+	return facts, nil
+}
+
+// function analyzes fn with parameters carrying the given taint and
+// returns the frame at its end
+func (t *taintAnalysis) function(fn *ast.Function, params []*step) *taintFrame {
+	frame := &taintFrame{fn: fn, env: taintEnv{}}
+	for i, param := range fn.Params {
+		if i < len(params) && params[i] != nil {
+			frame.env[param.Name] = params[i]
+		}
+	}
+	t.active[fn] = true
+	frame.env = t.statements(frame, fn.Body.Statements, frame.env)
+	delete(t.active, fn)
+	return frame
+}
+
+// statements follows data through stmts and returns the env after them.
+// Branches are joined whether or not they return, which can only add
+// untrusted data
+func (t *taintAnalysis) statements(frame *taintFrame, stmts []ast.Statement, env taintEnv) taintEnv {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.Block:
+			env = t.statements(frame, s.Statements, env)
+		case *ast.VarDecl:
+			env[s.Name] = nil
+			if s.Value != nil {
+				if value := t.expression(frame, s, s.Value, env); value != nil {
+					env[s.Name] = value.then(s.Pos, "assigned to %s", s.Name)
+				}
+			}
+		case *ast.IfStatement:
+			t.expression(frame, s, s.Condition, env)
+			then, els := env.copy(), env.copy()
+			if s.ThenBlock != nil {
+				then = t.statements(frame, s.ThenBlock.Statements, then)
+			}
+			if s.ElseBlock != nil {
+				els = t.statements(frame, s.ElseBlock.Statements, els)
+			}
+			env = joinTaint(then, els)
+		case *ast.WhileStatement:
+			// The body runs until no more variables become untrusted
+			for round := 0; round < loopRounds; round++ {
+				t.loopCondition(frame, s, s.Condition, env)
+				next := joinTaint(env, t.statements(frame, s.Body.Statements, env.copy()))
+				if len(next.untrusted()) == len(env.untrusted()) {
+					break
+				}
+				env = next
+			}
+		case *ast.SwitchStatement:
+			t.expression(frame, s, s.Tag, env)
+			after, fall := env.copy(), taintEnv{}
+			for _, cs := range s.Cases {
+				fall = t.statements(frame, cs.Body, joinTaint(env, fall))
+				after = joinTaint(after, fall)
+			}
+			env = after
+		case *ast.ReturnStatement:
+			if s.Value != nil {
+				if value := t.expression(frame, s, s.Value, env); value != nil && frame.result == nil {
+					frame.result = value
+				}
+			}
+		case *ast.ExprStatement:
+			t.expression(frame, s, s.Expr, env)
+		}
+	}
+	return env
+}
+
+// expression returns the untrusted data expr may evaluate to, reporting
+// the sinks it reaches and updating env with what it assigns
+func (t *taintAnalysis) expression(frame *taintFrame, stmt ast.Statement, expr ast.Expression, env taintEnv) *step {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		return env[e.Name]
+	case *ast.BinaryOp:
+		left := t.expression(frame, stmt, e.Left, env)
+		right := t.expression(frame, stmt, e.Right, env)
+		switch e.Operator {
+		case "&&", "||", "<", ">", "==":
+			// Truth values carry too little of the data to exploit
+			return nil
+		}
+		if left != nil {
+			return left
+		}
+		return right
+	case *ast.UnaryOp:
+		return t.expression(frame, stmt, e.Operand, env)
+	case *ast.IndexExpr:
+		array := t.expression(frame, stmt, e.Array, env)
+		if index := t.expression(frame, stmt, e.Index, env); index != nil {
+			t.indexSink(frame, stmt, e, index)
+		}
+		return array
+	case *ast.Assignment:
+		value := t.expression(frame, stmt, e.Value, env)
+		switch target := e.Target.(type) {
+		case *ast.Identifier:
+			env[target.Name] = nil
+			if value != nil {
+				env[target.Name] = value.then(stmt.Position(), "assigned to %s", target.Name)
+			}
+		default:
+			// Storing through a pointer or into an element leaves the rest
+			// of the memory as it was
+			t.expression(frame, stmt, e.Target, env)
+			if name := baseVariable(e.Target); name != "" && value != nil {
+				env[name] = value.then(stmt.Position(), "stored into %s", exprString(e.Target))
+			}
+		}
+		return value
+	case *ast.CallExpr:
+		return t.call(frame, stmt, e, env)
+	}
+	return nil
+}
+
+// call follows data into and out of a call
+func (t *taintAnalysis) call(frame *taintFrame, stmt ast.Statement, call *ast.CallExpr, env taintEnv) *step {
+	args := make([]*step, len(call.Args))
+	for i, arg := range call.Args {
+		args[i] = t.expression(frame, stmt, arg, env)
+	}
+	name := calledFunction(frame.fn, call)
+	if name == "" {
+		return firstTaint(args)
+	}
+
+	for _, sink := range t.config.Sinks {
+		if sink.Function != name {
+			continue
+		}
+		for _, i := range sink.arguments(len(args)) {
+			if i < len(args) && args[i] != nil {
+				t.report(frame, call.Pos, call.End, sink, args[i], fmt.Sprintf("argument %d of %s", i+1, name))
+			}
+		}
+	}
+	for _, sanitizer := range t.config.Sanitizers {
+		if sanitizer == name {
+			for _, arg := range call.Args {
+				if id, ok := arg.(*ast.Identifier); ok {
+					env[id.Name] = nil
+				}
+			}
+			return nil
+		}
+	}
+
+	var result *step
+	if callee := t.defined(name); callee != nil {
+		result = t.callDefined(callee, call, args, env)
+	} else if tainted := firstTaint(args); tainted != nil {
+		// Library functions are assumed to compute their result from
+		// their arguments
+		result = tainted.then(call.Pos, "passed through %s", name)
+		if copyFunctions[name] && len(call.Args) > 0 {
+			if dst := baseVariable(call.Args[0]); dst != "" && firstTaint(args[1:]) != nil {
+				env[dst] = firstTaint(args[1:]).then(call.Pos, "copied into %s by %s", dst, name)
+			}
+		}
+	}
+
+	for _, source := range t.config.Sources {
+		if source.Function != name || t.defined(name) != nil && !source.annotated {
+			continue
+		}
+		origin := sourceStep(call.Pos, name, "%s returns data from %s", name, source.Origin)
+		if source.Result {
+			result = origin
+		}
+		for _, i := range source.Args {
+			if i < len(call.Args) {
+				if dst := baseVariable(call.Args[i]); dst != "" {
+					env[dst] = sourceStep(call.Pos, name, "%s reads data from %s into %s", name, source.Origin, dst)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// callDefined analyzes a call to a function the program defines in the
+// context of its arguments. Pointer parameters the callee fills with
+// untrusted data make the arguments passed for them untrusted
+func (t *taintAnalysis) callDefined(callee *ast.Function, call *ast.CallExpr, args []*step, env taintEnv) *step {
+	if firstTaint(args) == nil {
+		// Untrusted data can still come from sources inside the callee,
+		// but only its result and pointer parameters carry it out
+		if !t.producesTaint(callee) {
+			return nil
+		}
+	}
+	if t.active[callee] {
+		// Recursion: assume the result derives from the arguments
+		return firstTaint(args)
+	}
+	params := make([]*step, len(callee.Params))
+	for i := range params {
+		if i < len(args) && args[i] != nil {
+			params[i] = args[i].then(call.Pos, "passed to %s as %s", callee.Name, callee.Params[i].Name)
+		}
+	}
+	frame := t.function(callee, params)
+	for i, param := range callee.Params {
+		if i >= len(call.Args) || param.Type.Decay().Kind != ast.PointerType {
+			continue
+		}
+		if s := frame.env[param.Name]; s != nil && s != params[i] {
+			if dst := baseVariable(call.Args[i]); dst != "" {
+				env[dst] = s.then(call.Pos, "stored into %s by %s", dst, callee.Name)
+			}
+		}
+	}
+	if frame.result == nil {
+		return nil
+	}
+	return frame.result.then(call.Pos, "returned by %s", callee.Name)
+}
+
+// producesTaint reports whether fn calls a source, directly or through
+// the functions it calls
+func (t *taintAnalysis) producesTaint(fn *ast.Function) bool {
+	found := false
+	visited := map[*ast.Function]bool{}
+	var visit func(fn *ast.Function)
+	visit = func(fn *ast.Function) {
+		if visited[fn] || found {
+			return
+		}
+		visited[fn] = true
+		inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+			call, ok := expr.(*ast.CallExpr)
+			if !ok {
+				return
+			}
+			name := calledFunction(fn, call)
+			if callee := t.defined(name); callee != nil {
+				visit(callee)
+				return
+			}
+			for _, source := range t.config.Sources {
+				found = found || source.Function == name
+			}
+		})
+	}
+	visit(fn)
+	return found
+}
+
+// indexSink reports an untrusted array index, unless value ranges show
+// it stays within the bounds of the array
+func (t *taintAnalysis) indexSink(frame *taintFrame, stmt ast.Statement, index *ast.IndexExpr, value *step) {
+	for _, sink := range t.config.Sinks {
+		if sink.Function != indexSink {
+			continue
+		}
+		ranges := t.unit.ranges(frame.fn)
+		if access := ranges.access[index]; access != nil {
+			bounded, ok := ranges.values[index.Index]
+			if ok && newInterval(0, int64(access.array.Len)-1).Contains(bounded) {
+				return
+			}
+		}
+		t.report(frame, stmt.Position(), stmt.EndPosition(), sink, value, "the index of "+exprString(index))
+	}
+}
+
+// loopCondition follows data through the condition of a loop, reporting
+// untrusted operands of the comparisons it is made of, or the condition
+// itself when it is a value tested against zero
+func (t *taintAnalysis) loopCondition(frame *taintFrame, stmt *ast.WhileStatement, cond ast.Expression, env taintEnv) {
+	if e, ok := cond.(*ast.BinaryOp); ok {
+		switch e.Operator {
+		case "&&", "||":
+			t.loopCondition(frame, stmt, e.Left, env)
+			t.loopCondition(frame, stmt, e.Right, env)
+			return
+		case "<", ">", "==":
+			for _, operand := range []ast.Expression{e.Left, e.Right} {
+				if value := t.expression(frame, stmt, operand, env); value != nil {
+					t.loopSink(frame, stmt, operand, value)
+				}
+			}
+			return
+		}
+	}
+	if value := t.expression(frame, stmt, cond, env); value != nil {
+		t.loopSink(frame, stmt, cond, value)
+	}
+}
+
+// loopSink reports an untrusted operand of the condition of a loop,
+// unless it is a variable value ranges show a check before the loop
+// narrowed to fewer values than its type holds
+func (t *taintAnalysis) loopSink(frame *taintFrame, stmt *ast.WhileStatement, operand ast.Expression, value *step) {
+	for _, sink := range t.config.Sinks {
+		if sink.Function != loopSink {
+			continue
+		}
+		ranges := t.unit.ranges(frame.fn)
+		if id, ok := operand.(*ast.Identifier); ok {
+			bounded, ok := ranges.values[operand]
+			if typ := ranges.types[id.Name]; ok && typ != nil && typ.IsInteger() && !bounded.Contains(typeRange(typ)) {
+				return
+			}
+		}
+		t.report(frame, stmt.Pos, stmt.EndPosition(), sink, value, exprString(operand)+" in the condition of the loop")
+	}
+}
+
+// report records untrusted data reaching a sink, once per source and sink,
+// with the path it took ending at the sink, which spans pos to end
+func (t *taintAnalysis) report(frame *taintFrame, pos, end lexer.Position, sink TaintSink, value *step, what string) {
+	origin := value.origin()
+	key := fmt.Sprintf("%s|%s|%s", pos, origin.pos, what)
+	if t.reported[key] {
+		return
+	}
+	t.reported[key] = true
+	t.findings = append(t.findings, Finding{
+		Rule:       "taint",
+		Severity:   sink.Severity,
+		Function:   frame.fn.Name,
+		Pos:        pos,
+		End:        end,
+		Message:    fmt.Sprintf("untrusted data from %s at %s reaches %s, which %s", origin.source, origin.pos, what, sink.Use),
+		CWE:        sink.CWE,
+		Suggestion: "validate the data before this use",
+		Trace:      value.then(pos, "reaches %s", what).trace(),
+	})
+}
+
+// defined returns the function called name that the program defines, or
+// nil
+func (t *taintAnalysis) defined(name string) *ast.Function {
+	return functionNamed(t.program, name)
+}
+
+// firstTaint returns the first untrusted value in values, or nil
+func firstTaint(values []*step) *step {
+	for _, s := range values {
+		if s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// baseVariable returns the variable whose memory an lvalue or pointer
+// expression refers to, such as buf for buf[i] or *buf, or ""
+func baseVariable(expr ast.Expression) string {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		return e.Name
+	case *ast.IndexExpr:
+		return baseVariable(e.Array)
+	case *ast.UnaryOp:
+		return baseVariable(e.Operand)
+	case *ast.BinaryOp:
+		// Pointer arithmetic
+		return baseVariable(e.Left)
+	}
+	return ""
+}
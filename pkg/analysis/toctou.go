@@ -0,0 +1,92 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// pathChecks are the library functions that look at a file by name, by
+// the index of the path argument
+var pathChecks = map[string]int{"access": 0, "stat": 0, "lstat": 0}
+
+// pathUses are the library functions that act on a file by name, by the
+// indexes of their path arguments
+var pathUses = map[string][]int{
+	"open":   {0},
+	"creat":  {0},
+	"fopen":  {0},
+	"chmod":  {0},
+	"chown":  {0},
+	"unlink": {0},
+	"remove": {0},
+	"rename": {0, 1},
+}
+
+// checkRaces reports files checked by name and then used by name, e.g.
+// access(path, W_OK) followed by open(path, ...): the file the name refers
+// to can be replaced between the two calls. A path is the same when it is
+// the same variable, not assigned in between, or an equal string literal
+func checkRaces(fn *ast.Function, unit *Unit) []Finding {
+	type check struct {
+		name string
+		call *ast.CallExpr
+	}
+	// checked maps each path to the last check of it
+	checked := map[string]check{}
+	key := func(path ast.Expression) string {
+		switch p := path.(type) {
+		case *ast.Identifier:
+			return p.Name
+		case *ast.StringLiteral:
+			return p.String()
+		}
+		return ""
+	}
+	findings := []Finding{}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		switch e := expr.(type) {
+		case *ast.Assignment:
+			if id, ok := e.Target.(*ast.Identifier); ok {
+				delete(checked, id.Name)
+			}
+		case *ast.CallExpr:
+			name := calledFunction(fn, e)
+			if name == "" || unit.defines(name) {
+				return
+			}
+			if i, ok := pathChecks[name]; ok && i < len(e.Args) {
+				if path := key(e.Args[i]); path != "" {
+					checked[path] = check{name, e}
+				}
+				return
+			}
+			for _, i := range pathUses[name] {
+				if i >= len(e.Args) {
+					continue
+				}
+				path := key(e.Args[i])
+				c, ok := checked[path]
+				if path == "" || !ok {
+					continue
+				}
+				delete(checked, path)
+				findings = append(findings, Finding{
+					Rule:       "toctou",
+					Severity:   Medium,
+					Function:   fn.Name,
+					Pos:        e.Pos,
+					End:        e.End,
+					Message:    fmt.Sprintf("%s uses %s after %s checks it at %s; the file can be replaced in between", name, exprString(e.Args[i]), c.name, c.call.Pos),
+					CWE:        367,
+					Suggestion: "open the file once and check the descriptor with fstat, or drop privileges instead of checking with access",
+					Trace: []TraceStep{
+						{Pos: c.call.Pos, Message: fmt.Sprintf("%s checks %s", c.name, exprString(e.Args[i]))},
+						{Pos: e.Pos, Message: fmt.Sprintf("%s uses it", name)},
+					},
+				})
+			}
+		}
+	})
+	return findings
+}
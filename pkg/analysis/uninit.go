@@ -0,0 +1,254 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// unassigned maps the locals no definition reaches on some path to the
+// branch conditions of such a path, taken since their declaration. An
+// empty path means no definition reaches on any path. A nil map stands
+// for a path that cannot be reached
+type unassigned map[string][]string
+
+func (u unassigned) copy() unassigned {
+	if u == nil {
+		return nil
+	}
+	c := unassigned{}
+	for name, path := range u {
+		c[name] = path
+	}
+	return c
+}
+
+// taking returns a copy of u on the branch where condition holds
+func (u unassigned) taking(condition string) unassigned {
+	c := u.copy()
+	for name, path := range c {
+		c[name] = append(append([]string(nil), path...), condition)
+	}
+	return c
+}
+
+// joinUnassigned merges two branches that meet after starting from
+// before. A local unassigned on both is unassigned whichever is taken
+func joinUnassigned(before, a, b unassigned) unassigned {
+	if a == nil {
+		return b.copy()
+	}
+	if b == nil {
+		return a.copy()
+	}
+	j := unassigned{}
+	for name, path := range a {
+		if _, ok := b[name]; ok {
+			j[name] = before[name]
+		} else {
+			j[name] = path
+		}
+	}
+	for name, path := range b {
+		if _, ok := a[name]; !ok {
+			j[name] = path
+		}
+	}
+	return j
+}
+
+// definitions is a reaching definitions pass over one function that
+// reports the locals read where no assignment reaches on some path
+type definitions struct {
+	fn       *ast.Function
+	findings []Finding
+	reported map[string]bool
+}
+
+// checkUninitialized reports reads of scalar locals that no assignment
+// reaches on at least one path, naming the branches of that path. Each
+// local is reported once, at its first such read
+func checkUninitialized(fn *ast.Function, unit *Unit) []Finding {
+	d := &definitions{fn: fn, findings: []Finding{}, reported: map[string]bool{}}
+	d.statements(fn.Body.Statements, unassigned{})
+	return d.findings
+}
+
+// statements runs stmts from in and returns the state at their end and
+// the state joined over the break statements among them
+func (d *definitions) statements(stmts []ast.Statement, in unassigned) (out, broke unassigned) {
+	for _, stmt := range stmts {
+		if in == nil {
+			break
+		}
+		switch s := stmt.(type) {
+		case *ast.Block:
+			var b unassigned
+			in, b = d.statements(s.Statements, in)
+			broke = joinUnassigned(in, broke, b)
+		case *ast.VarDecl:
+			delete(in, s.Name)
+			if s.Value != nil {
+				d.expression(s, s.Value, in)
+			} else if s.Type.Kind != ast.ArrayType {
+				in[s.Name] = nil
+			}
+		case *ast.IfStatement:
+			then, els := d.condition(s, s.Condition, in)
+			if s.ThenBlock != nil {
+				var b unassigned
+				then, b = d.statements(s.ThenBlock.Statements, then)
+				broke = joinUnassigned(in, broke, b)
+			}
+			if s.ElseBlock != nil {
+				var b unassigned
+				els, b = d.statements(s.ElseBlock.Statements, els)
+				broke = joinUnassigned(in, broke, b)
+			}
+			in = joinUnassigned(in, then, els)
+		case *ast.WhileStatement:
+			head := d.loopHead(s, in)
+			then, els := d.condition(s, s.Condition, head)
+			_, b := d.statements(s.Body.Statements, then)
+			in = joinUnassigned(head, els, b)
+		case *ast.SwitchStatement:
+			d.expression(s, s.Tag, in)
+			tag := exprString(s.Tag)
+			var after, fall unassigned
+			hasDefault := false
+			for _, cs := range s.Cases {
+				var entry unassigned
+				if cs.Value == nil {
+					hasDefault = true
+					entry = in.taking("the default case of switch (" + tag + ") is taken")
+				} else {
+					entry = in.taking(tag + " == " + exprString(cs.Value))
+				}
+				out, b := d.statements(cs.Body, joinUnassigned(in, entry, fall))
+				after = joinUnassigned(in, after, b)
+				fall = out
+			}
+			after = joinUnassigned(in, after, fall)
+			if !hasDefault {
+				after = joinUnassigned(in, after, in.taking("no case of switch ("+tag+") matches"))
+			}
+			in = after
+		case *ast.BreakStatement:
+			broke = joinUnassigned(in, broke, in)
+			in = nil
+		case *ast.ReturnStatement:
+			if s.Value != nil {
+				d.expression(s, s.Value, in)
+			}
+			in = nil
+		case *ast.ExprStatement:
+			d.expression(s, s.Expr, in)
+		}
+	}
+	return in, broke
+}
+
+// loopHead returns the state at the head of a loop entered with in,
+// where the end of the body joins the entry. The rounds that find it
+// report nothing
+func (d *definitions) loopHead(s *ast.WhileStatement, in unassigned) unassigned {
+	head := in
+	for round := 0; round < loopRounds; round++ {
+		quiet := &definitions{fn: d.fn, reported: map[string]bool{}}
+		then, _ := quiet.condition(s, s.Condition, head.copy())
+		out, _ := quiet.statements(s.Body.Statements, then)
+		next := joinUnassigned(in, in, out)
+		if len(next) == len(head) {
+			break
+		}
+		head = next
+	}
+	return head
+}
+
+// expression reports the reads in expr of locals in in, and removes the
+// locals it assigns
+func (d *definitions) expression(stmt ast.Statement, expr ast.Expression, in unassigned) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		path, ok := in[e.Name]
+		if !ok || d.reported[e.Name] {
+			return
+		}
+		d.reported[e.Name] = true
+		finding := Finding{
+			Rule:       "uninitialized",
+			Severity:   High,
+			Function:   d.fn.Name,
+			Pos:        stmt.Position(),
+			End:        stmt.EndPosition(),
+			Message:    fmt.Sprintf("%s is read before it is assigned", e.Name),
+			CWE:        457,
+			Suggestion: "initialize " + e.Name + " where it is declared",
+		}
+		if len(path) > 0 {
+			finding.Severity = Medium
+			finding.Message = fmt.Sprintf("%s may be read before it is assigned, when %s", e.Name, strings.Join(path, " and "))
+		}
+		d.findings = append(d.findings, finding)
+	case *ast.BinaryOp:
+		d.expression(stmt, e.Left, in)
+		if e.Operator == "&&" || e.Operator == "||" {
+			// The right operand may not be evaluated, so what it assigns
+			// is assigned on some paths only
+			skipped := exprString(e.Left) + " is false"
+			if e.Operator == "||" {
+				skipped = exprString(e.Left) + " is true"
+			}
+			right := in.copy()
+			d.expression(stmt, e.Right, right)
+			for name := range in {
+				if _, ok := right[name]; !ok {
+					in[name] = append(append([]string(nil), in[name]...), skipped)
+				}
+			}
+			return
+		}
+		d.expression(stmt, e.Right, in)
+	case *ast.UnaryOp:
+		d.expression(stmt, e.Operand, in)
+	case *ast.IndexExpr:
+		d.expression(stmt, e.Array, in)
+		d.expression(stmt, e.Index, in)
+	case *ast.Assignment:
+		d.expression(stmt, e.Value, in)
+		if target, ok := e.Target.(*ast.Identifier); ok {
+			delete(in, target.Name)
+		} else {
+			d.expression(stmt, e.Target, in)
+		}
+	case *ast.CallExpr:
+		d.expression(stmt, e.Callee, in)
+		for _, arg := range e.Args {
+			d.expression(stmt, arg, in)
+		}
+	}
+}
+
+// condition evaluates a branch condition and returns the states on the
+// paths where it is true and false. The operands of && and || are
+// followed separately, so the state where a && b is true has what b
+// assigns
+func (d *definitions) condition(stmt ast.Statement, cond ast.Expression, in unassigned) (then, els unassigned) {
+	if e, ok := cond.(*ast.BinaryOp); ok {
+		switch e.Operator {
+		case "&&":
+			leftThen, leftElse := d.condition(stmt, e.Left, in)
+			then, els = d.condition(stmt, e.Right, leftThen)
+			return then, joinUnassigned(in, leftElse, els)
+		case "||":
+			leftThen, leftElse := d.condition(stmt, e.Left, in)
+			then, els = d.condition(stmt, e.Right, leftElse)
+			return joinUnassigned(in, leftThen, then), els
+		}
+	}
+	d.expression(stmt, cond, in)
+	condition := exprString(cond)
+	return in.taking(condition + " is true"), in.taking(condition + " is false")
+}
@@ -0,0 +1,68 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// checkUnreachableCode reports statements no path reaches, which are
+// often error handling that can never run, and branch conditions that
+// assign a constant where a comparison was meant, as in if (x = 0)
+func checkUnreachableCode(fn *ast.Function, unit *Unit) []Finding {
+	ranges := unit.ranges(fn)
+	findings := []Finding{}
+	for _, dead := range ranges.dead {
+		findings = append(findings, Finding{
+			Rule:       "unreachable-code",
+			Severity:   Low,
+			Function:   fn.Name,
+			Pos:        dead.stmt.Position(),
+			End:        dead.stmt.EndPosition(),
+			Message:    "statement is never executed: " + dead.reason,
+			CWE:        561,
+			Suggestion: "remove the code, or fix the logic that was meant to reach it",
+		})
+	}
+	inspect(fn.Body.Statements, func(stmt ast.Statement, expr ast.Expression) {
+		branch, ok := stmt.(*ast.IfStatement)
+		if !ok {
+			return
+		}
+		assignment, ok := expr.(*ast.Assignment)
+		if !ok || !isCondition(branch.Condition, expr) {
+			return
+		}
+		value, ok := ranges.values[assignment]
+		if !ok || !value.IsConstant() {
+			return
+		}
+		truth := "true"
+		if value.Lo.Sign() == 0 {
+			truth = "false"
+		}
+		findings = append(findings, Finding{
+			Rule:       "constant-condition",
+			Severity:   Medium,
+			Function:   fn.Name,
+			Pos:        branch.Pos,
+			End:        branch.EndPosition(),
+			Message:    fmt.Sprintf("condition %s assigns %s and is always %s", exprString(assignment), value, truth),
+			CWE:        481,
+			Suggestion: fmt.Sprintf("compare with == instead: %s == %s", exprString(assignment.Target), exprString(assignment.Value)),
+		})
+	})
+	return findings
+}
+
+// isCondition reports whether expr is cond or one of the operands of the
+// && and || operators cond is made of
+func isCondition(cond, expr ast.Expression) bool {
+	if cond == expr {
+		return true
+	}
+	if e, ok := cond.(*ast.BinaryOp); ok && (e.Operator == "&&" || e.Operator == "||") {
+		return isCondition(e.Left, expr) || isCondition(e.Right, expr)
+	}
+	return false
+}
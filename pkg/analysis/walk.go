@@ -0,0 +1,194 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// inspect calls visit for every expression in stmts, parents before their
+// operands, along with the statement the expression belongs to
+func inspect(stmts []ast.Statement, visit func(stmt ast.Statement, expr ast.Expression)) {
+	for _, stmt := range stmts {
+		inspectStatement(stmt, visit)
+	}
+}
+
+func inspectStatement(stmt ast.Statement, visit func(ast.Statement, ast.Expression)) {
+	expr := func(e ast.Expression) {
+		inspectExpression(stmt, e, visit)
+	}
+	switch s := stmt.(type) {
+	case *ast.Block:
+		inspect(s.Statements, visit)
+	case *ast.VarDecl:
+		expr(s.Value)
+	case *ast.IfStatement:
+		expr(s.Condition)
+		if s.ThenBlock != nil {
+			inspect(s.ThenBlock.Statements, visit)
+		}
+		if s.ElseBlock != nil {
+			inspect(s.ElseBlock.Statements, visit)
+		}
+	case *ast.WhileStatement:
+		expr(s.Condition)
+		inspect(s.Body.Statements, visit)
+	case *ast.SwitchStatement:
+		expr(s.Tag)
+		for _, cs := range s.Cases {
+			expr(cs.Value)
+			inspect(cs.Body, visit)
+		}
+	case *ast.ReturnStatement:
+		expr(s.Value)
+	case *ast.ExprStatement:
+		expr(s.Expr)
+	}
+}
+
+func inspectExpression(stmt ast.Statement, expr ast.Expression, visit func(ast.Statement, ast.Expression)) {
+	if expr == nil {
+		return
+	}
+	visit(stmt, expr)
+	switch e := expr.(type) {
+	case *ast.BinaryOp:
+		inspectExpression(stmt, e.Left, visit)
+		inspectExpression(stmt, e.Right, visit)
+	case *ast.UnaryOp:
+		inspectExpression(stmt, e.Operand, visit)
+	case *ast.IndexExpr:
+		inspectExpression(stmt, e.Array, visit)
+		inspectExpression(stmt, e.Index, visit)
+	case *ast.Assignment:
+		inspectExpression(stmt, e.Target, visit)
+		inspectExpression(stmt, e.Value, visit)
+	case *ast.CallExpr:
+		inspectExpression(stmt, e.Callee, visit)
+		for _, arg := range e.Args {
+			inspectExpression(stmt, arg, visit)
+		}
+	}
+}
+
+// loopRounds bounds how often a flow-sensitive check runs the body of a
+// loop waiting for the state at its head to settle. The state there
+// grows with each round and so settles within a few; the bound keeps a
+// check from running on where it does not
+const loopRounds = 8
+
+// calledFunction returns the name of the library or program function a
+// call calls directly, or "" for calls through function pointers
+func calledFunction(fn *ast.Function, call *ast.CallExpr) string {
+	id, ok := call.Callee.(*ast.Identifier)
+	if !ok {
+		return ""
+	}
+	for _, param := range fn.Params {
+		if param.Name == id.Name {
+			return ""
+		}
+	}
+	local := false
+	inspectDecls(fn.Body.Statements, func(decl *ast.VarDecl) {
+		local = local || decl.Name == id.Name
+	})
+	if local {
+		return ""
+	}
+	return id.Name
+}
+
+// inspectDecls calls visit for every variable declaration in stmts
+func inspectDecls(stmts []ast.Statement, visit func(*ast.VarDecl)) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.Block:
+			inspectDecls(s.Statements, visit)
+		case *ast.VarDecl:
+			visit(s)
+		case *ast.IfStatement:
+			if s.ThenBlock != nil {
+				inspectDecls(s.ThenBlock.Statements, visit)
+			}
+			if s.ElseBlock != nil {
+				inspectDecls(s.ElseBlock.Statements, visit)
+			}
+		case *ast.WhileStatement:
+			inspectDecls(s.Body.Statements, visit)
+		case *ast.SwitchStatement:
+			for _, cs := range s.Cases {
+				inspectDecls(cs.Body, visit)
+			}
+		}
+	}
+}
+
+// definesFunction reports whether program defines a function called name
+// itself, shadowing any library function of that name
+func definesFunction(program *ast.Program, name string) bool {
+	for _, fn := range program.Functions {
+		if fn.Name == name && fn.Body != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// defines reports whether the program of the unit defines a function
+// called name, or an earlier program did and Config.Facts summarizes it
+func (u *Unit) defines(name string) bool {
+	return definesFunction(u.Program, name) || u.Config.Facts.Has(name)
+}
+
+// callee returns the function called name the program of the unit
+// defines, or its declaration if an earlier program defined it, or nil
+func (u *Unit) callee(name string) *ast.Function {
+	if fn := functionNamed(u.Program, name); fn != nil || !u.Config.Facts.Has(name) {
+		return fn
+	}
+	for _, fn := range u.Program.Functions {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// exprString renders an expression in C syntax for messages
+func exprString(expr ast.Expression) string {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		return e.Name
+	case *ast.IntLiteral:
+		return fmt.Sprint(e.Value)
+	case *ast.StringLiteral:
+		return e.String()
+	case *ast.BinaryOp:
+		return operandString(e.Left) + " " + e.Operator + " " + operandString(e.Right)
+	case *ast.UnaryOp:
+		return e.Operator + operandString(e.Operand)
+	case *ast.IndexExpr:
+		return operandString(e.Array) + "[" + exprString(e.Index) + "]"
+	case *ast.Assignment:
+		return exprString(e.Target) + " = " + exprString(e.Value)
+	case *ast.CallExpr:
+		args := []string{}
+		for _, arg := range e.Args {
+			args = append(args, exprString(arg))
+		}
+		return operandString(e.Callee) + "(" + strings.Join(args, ", ") + ")"
+	}
+	return expr.String()
+}
+
+// operandString renders an operand, parenthesized unless it is primary
+func operandString(expr ast.Expression) string {
+	switch expr.(type) {
+	case *ast.BinaryOp, *ast.Assignment:
+		return "(" + exprString(expr) + ")"
+	}
+	return exprString(expr)
+}
@@ -0,0 +1,218 @@
+package ast
+
+// Arena allocates the nodes of a syntax tree from chunks of each type
+// rather than one at a time, so that parsing a large file makes a few
+// hundred allocations instead of one per node, and the garbage collector
+// has that many fewer objects to trace. The nodes of a chunk are freed
+// together, once none of them is reachable: a program parsed into an
+// Arena holds it in Program.Arena, and the nodes live as long as the
+// program. A nil Arena allocates each node on its own, as new does.
+// An Arena is not safe for concurrent use
+type Arena struct {
+	functions  slab[Function]
+	params     slab[Parameter]
+	attributes slab[Attribute]
+	blocks     slab[Block]
+	decls      slab[VarDecl]
+	ifs        slab[IfStatement]
+	switches   slab[SwitchStatement]
+	cases      slab[SwitchCase]
+	whiles     slab[WhileStatement]
+	breaks     slab[BreakStatement]
+	returns    slab[ReturnStatement]
+	exprStmts  slab[ExprStatement]
+	asms       slab[AsmStatement]
+	idents     slab[Identifier]
+	ints       slab[IntLiteral]
+	strs       slab[StringLiteral]
+	binaries   slab[BinaryOp]
+	unaries    slab[UnaryOp]
+	indexes    slab[IndexExpr]
+	assigns    slab[Assignment]
+	calls      slab[CallExpr]
+}
+
+// NewArena returns an empty Arena
+func NewArena() *Arena {
+	return &Arena{}
+}
+
+// Chunks of a type start small, for the few functions of a small file
+// not to take much more memory than they would alone, and double up to
+// a limit
+const (
+	minChunk = 16
+	maxChunk = 1024
+)
+
+// slab hands out Ts from its current chunk, starting a new one when it is
+// full
+type slab[T any] struct {
+	chunk []T
+}
+
+func (s *slab[T]) alloc(v T) *T {
+	if len(s.chunk) == cap(s.chunk) {
+		n := min(max(2*cap(s.chunk), minChunk), maxChunk)
+		s.chunk = make([]T, 0, n)
+	}
+	s.chunk = append(s.chunk, v)
+	return &s.chunk[len(s.chunk)-1]
+}
+
+// alloc returns a copy of v on its own. Returning &v instead would have v
+// escape, costing an allocation even when it goes into an Arena
+func alloc[T any](v T) *T {
+	p := new(T)
+	*p = v
+	return p
+}
+
+// Each of these returns a copy of v in a, or on its own if a is nil
+
+func (a *Arena) Function(v Function) *Function {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.functions.alloc(v)
+}
+
+func (a *Arena) Parameter(v Parameter) *Parameter {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.params.alloc(v)
+}
+
+func (a *Arena) Attribute(v Attribute) *Attribute {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.attributes.alloc(v)
+}
+
+func (a *Arena) Block(v Block) *Block {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.blocks.alloc(v)
+}
+
+func (a *Arena) VarDecl(v VarDecl) *VarDecl {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.decls.alloc(v)
+}
+
+func (a *Arena) IfStatement(v IfStatement) *IfStatement {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.ifs.alloc(v)
+}
+
+func (a *Arena) SwitchStatement(v SwitchStatement) *SwitchStatement {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.switches.alloc(v)
+}
+
+func (a *Arena) SwitchCase(v SwitchCase) *SwitchCase {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.cases.alloc(v)
+}
+
+func (a *Arena) WhileStatement(v WhileStatement) *WhileStatement {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.whiles.alloc(v)
+}
+
+func (a *Arena) BreakStatement(v BreakStatement) *BreakStatement {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.breaks.alloc(v)
+}
+
+func (a *Arena) ReturnStatement(v ReturnStatement) *ReturnStatement {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.returns.alloc(v)
+}
+
+func (a *Arena) ExprStatement(v ExprStatement) *ExprStatement {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.exprStmts.alloc(v)
+}
+
+func (a *Arena) AsmStatement(v AsmStatement) *AsmStatement {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.asms.alloc(v)
+}
+
+func (a *Arena) Identifier(v Identifier) *Identifier {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.idents.alloc(v)
+}
+
+func (a *Arena) IntLiteral(v IntLiteral) *IntLiteral {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.ints.alloc(v)
+}
+
+func (a *Arena) StringLiteral(v StringLiteral) *StringLiteral {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.strs.alloc(v)
+}
+
+func (a *Arena) BinaryOp(v BinaryOp) *BinaryOp {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.binaries.alloc(v)
+}
+
+func (a *Arena) UnaryOp(v UnaryOp) *UnaryOp {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.unaries.alloc(v)
+}
+
+func (a *Arena) IndexExpr(v IndexExpr) *IndexExpr {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.indexes.alloc(v)
+}
+
+func (a *Arena) Assignment(v Assignment) *Assignment {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.assigns.alloc(v)
+}
+
+func (a *Arena) CallExpr(v CallExpr) *CallExpr {
+	if a == nil {
+		return alloc(v)
+	}
+	return a.calls.alloc(v)
+}
@@ -0,0 +1,294 @@
+// Package ast defines the syntax tree of the C subset and its types, which
+// package parser builds and the code generator and analysis passes walk
+package ast
+
+import (
+	"strconv"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// Node types
+type Node interface {
+	String() string
+}
+
+// Program is the root node
+type Program struct {
+	Functions []*Function
+	// Arena is where the parser allocated the nodes, if it used one
+	Arena *Arena
+}
+
+// Function represents a function definition
+// (or a prototype, when Body is nil)
+type Function struct {
+	ReturnType *Type
+	Name       string
+	Params     []*Parameter
+	Body       *Block
+	Attributes []*Attribute
+	Static     bool // declared static, giving it internal linkage
+	Pos        lexer.Position
+	NamePos    lexer.Position
+	// File names the file the function was parsed from, once Merge has
+	// combined it with others
+	File string
+}
+
+// Attribute is a GNU-style __attribute__((name(args...))) annotation
+type Attribute struct {
+	Name string
+	Args []string
+	// StringArgs says which of Args were string literals, whose quotes
+	// Args leaves out
+	StringArgs []bool
+}
+
+// HasAttribute reports whether the function carries the named attribute
+func (f *Function) HasAttribute(name string) bool {
+	return f.Attribute(name) != nil
+}
+
+// Attribute returns the first attribute with the given name, or nil
+func (f *Function) Attribute(name string) *Attribute {
+	for _, attr := range f.Attributes {
+		if attr.Name == name {
+			return attr
+		}
+	}
+	return nil
+}
+
+type Parameter struct {
+	Type *Type
+	Name string
+	Pos  lexer.Position // the name, if the parameter has one
+	// Written is the type as declared, an array where Type is the
+	// pointer it is adjusted to
+	Written *Type
+}
+
+// Signature returns the function type of f
+func (f *Function) Signature() *Type {
+	sig := &Type{Kind: FuncType, Elem: f.ReturnType}
+	for _, param := range f.Params {
+		sig.Params = append(sig.Params, param.Type)
+	}
+	return sig
+}
+
+// Statement types
+type Statement interface {
+	Node
+	statementNode()
+	// Position returns where the statement starts in the source
+	Position() lexer.Position
+	// EndPosition returns where the statement ends in the source, after
+	// its last character, or the zero Position if that is not known
+	EndPosition() lexer.Position
+}
+
+type Block struct {
+	Pos        lexer.Position
+	Statements []Statement
+	End        lexer.Position // the closing brace
+}
+
+type VarDecl struct {
+	Pos     lexer.Position
+	Type    *Type
+	Name    string
+	NamePos lexer.Position
+	Value   Expression
+	End     lexer.Position // after the semicolon
+}
+
+type IfStatement struct {
+	Pos       lexer.Position
+	Condition Expression
+	ThenBlock *Block
+	ElseBlock *Block
+}
+
+// SwitchStatement is switch (Tag) { case ...: ... default: ... }
+type SwitchStatement struct {
+	Pos   lexer.Position
+	Tag   Expression
+	Cases []*SwitchCase
+	End   lexer.Position // the closing brace
+}
+
+// SwitchCase is a case label, or the default label when Value is nil,
+// with the statements up to the next label. Control falls through into
+// the next case unless the body breaks or returns
+type SwitchCase struct {
+	Pos   lexer.Position
+	Value Expression
+	Body  []Statement
+}
+
+// WhileStatement is while (Condition) { Body }
+type WhileStatement struct {
+	Pos       lexer.Position
+	Condition Expression
+	Body      *Block
+}
+
+// BreakStatement leaves the innermost enclosing loop or switch
+type BreakStatement struct {
+	Pos lexer.Position
+	End lexer.Position // after the semicolon
+}
+
+type ReturnStatement struct {
+	Pos   lexer.Position
+	Value Expression
+	End   lexer.Position // after the semicolon
+}
+
+// ExprStatement is an expression evaluated for its side effects
+type ExprStatement struct {
+	Pos  lexer.Position
+	Expr Expression
+	End  lexer.Position // after the semicolon
+}
+
+// AsmStatement is a basic inline assembly statement, __asm__("...")
+type AsmStatement struct {
+	Pos      lexer.Position
+	Template string         // as written in the source, escapes included
+	End      lexer.Position // after the semicolon
+}
+
+// Expression types
+type Expression interface {
+	Node
+	expressionNode()
+}
+
+type Identifier struct {
+	Pos  lexer.Position
+	Name string
+}
+
+type IntLiteral struct {
+	Value int
+}
+
+// StringLiteral is a string constant, with adjacent literals concatenated
+type StringLiteral struct {
+	Pos   lexer.Position
+	Value string         // as written in the source, escapes included
+	End   lexer.Position // after the closing quote of the last literal
+}
+
+type BinaryOp struct {
+	Left     Expression
+	Operator string
+	Right    Expression
+	// Parenthesized records parentheses written around the operation,
+	// which Format keeps even where precedence makes them redundant
+	Parenthesized bool
+}
+
+type UnaryOp struct {
+	Operator string
+	Operand  Expression
+}
+
+// IndexExpr is an array subscript such as buf[i]
+type IndexExpr struct {
+	Array Expression
+	Index Expression
+}
+
+// Assignment stores Value into the lvalue Target
+type Assignment struct {
+	Target        Expression
+	Value         Expression
+	Parenthesized bool // as for BinaryOp
+}
+
+// CallExpr is a call through a function name or a function pointer
+type CallExpr struct {
+	Pos    lexer.Position
+	Callee Expression
+	Args   []Expression
+	End    lexer.Position // after the closing parenthesis
+}
+
+// Implement interface methods
+func (p *Program) String() string                   { return "Program" }
+func (f *Function) String() string                  { return "Function: " + f.Name }
+func (b *Block) statementNode()                     {}
+func (b *Block) String() string                     { return "Block" }
+func (v *VarDecl) statementNode()                   {}
+func (v *VarDecl) String() string                   { return "VarDecl: " + v.Name }
+func (i *IfStatement) statementNode()               {}
+func (i *IfStatement) String() string               { return "IfStatement" }
+func (s *SwitchStatement) statementNode()           {}
+func (s *SwitchStatement) String() string           { return "SwitchStatement" }
+func (w *WhileStatement) statementNode()            {}
+func (w *WhileStatement) String() string            { return "WhileStatement" }
+func (b *BreakStatement) statementNode()            {}
+func (b *BreakStatement) String() string            { return "BreakStatement" }
+func (r *ReturnStatement) statementNode()           {}
+func (r *ReturnStatement) String() string           { return "ReturnStatement" }
+func (e *ExprStatement) statementNode()             {}
+func (e *ExprStatement) String() string             { return "ExprStatement" }
+func (a *AsmStatement) statementNode()              {}
+func (a *AsmStatement) String() string              { return "AsmStatement" }
+func (b *Block) Position() lexer.Position           { return b.Pos }
+func (v *VarDecl) Position() lexer.Position         { return v.Pos }
+func (i *IfStatement) Position() lexer.Position     { return i.Pos }
+func (s *SwitchStatement) Position() lexer.Position { return s.Pos }
+func (w *WhileStatement) Position() lexer.Position  { return w.Pos }
+func (b *BreakStatement) Position() lexer.Position  { return b.Pos }
+func (r *ReturnStatement) Position() lexer.Position { return r.Pos }
+func (e *ExprStatement) Position() lexer.Position   { return e.Pos }
+func (a *AsmStatement) Position() lexer.Position    { return a.Pos }
+
+func (b *Block) EndPosition() lexer.Position           { return after(b.End) }
+func (v *VarDecl) EndPosition() lexer.Position         { return v.End }
+func (s *SwitchStatement) EndPosition() lexer.Position { return after(s.End) }
+func (w *WhileStatement) EndPosition() lexer.Position  { return w.Body.EndPosition() }
+func (b *BreakStatement) EndPosition() lexer.Position  { return b.End }
+func (r *ReturnStatement) EndPosition() lexer.Position { return r.End }
+func (e *ExprStatement) EndPosition() lexer.Position   { return e.End }
+func (a *AsmStatement) EndPosition() lexer.Position    { return a.End }
+
+func (i *IfStatement) EndPosition() lexer.Position {
+	if i.ElseBlock != nil {
+		return i.ElseBlock.EndPosition()
+	}
+	return i.ThenBlock.EndPosition()
+}
+
+// after returns the position after the one-character token at pos, such
+// as the closing brace of a block, or the zero Position for an unknown pos
+func after(pos lexer.Position) lexer.Position {
+	if pos.Line == 0 {
+		return lexer.Position{}
+	}
+	pos.Column++
+	pos.Offset++
+	return pos
+}
+
+func (id *Identifier) expressionNode()   {}
+func (id *Identifier) String() string    { return id.Name }
+func (il *IntLiteral) expressionNode()   {}
+func (il *IntLiteral) String() string    { return strconv.Itoa(il.Value) }
+func (s *StringLiteral) expressionNode() {}
+func (s *StringLiteral) String() string  { return "\"" + s.Value + "\"" }
+func (b *BinaryOp) expressionNode()      {}
+func (b *BinaryOp) String() string       { return "BinaryOp" }
+func (u *UnaryOp) expressionNode()       {}
+func (u *UnaryOp) String() string        { return "UnaryOp" }
+func (ix *IndexExpr) expressionNode()    {}
+func (ix *IndexExpr) String() string     { return ix.Array.String() + "[" + ix.Index.String() + "]" }
+func (a *Assignment) expressionNode()    {}
+func (a *Assignment) String() string     { return "Assignment" }
+func (c *CallExpr) expressionNode()      {}
+func (c *CallExpr) String() string       { return "CallExpr" }
@@ -0,0 +1,160 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TypeKind distinguishes the shapes a Type can take
+type TypeKind int
+
+const (
+	BasicType TypeKind = iota
+	PointerType
+	ArrayType
+	FuncType
+)
+
+// Type is a C type in the supported subset
+type Type struct {
+	Kind   TypeKind
+	Name   string  // basic type name, e.g. "int"
+	Elem   *Type   // pointee for pointers, element for arrays, return type for functions
+	Len    int     // element count for arrays
+	Params []*Type // parameter types for functions
+}
+
+// The basic types. Integer types are signed
+var (
+	Char   = &Type{Kind: BasicType, Name: "char"}
+	Short  = &Type{Kind: BasicType, Name: "short"}
+	Int    = &Type{Kind: BasicType, Name: "int"}
+	Long   = &Type{Kind: BasicType, Name: "long"}
+	Float  = &Type{Kind: BasicType, Name: "float"}
+	Double = &Type{Kind: BasicType, Name: "double"}
+)
+
+// Void is only ever the pointee of the void* some C library functions
+// return; the subset cannot declare anything of it
+var Void = &Type{Kind: BasicType, Name: "void"}
+
+// BasicTypes maps the basic type names to their types
+var BasicTypes = map[string]*Type{
+	"char": Char, "short": Short, "int": Int, "long": Long,
+	"float": Float, "double": Double,
+}
+
+// integerRanks orders the integer types by conversion rank
+var integerRanks = map[string]int{"char": 1, "short": 2, "int": 3, "long": 4}
+
+// IsInteger reports whether t is one of the integer types
+func (t *Type) IsInteger() bool {
+	return t.Kind == BasicType && integerRanks[t.Name] > 0
+}
+
+// IsFloating reports whether t is float or double
+func (t *Type) IsFloating() bool {
+	return t.Kind == BasicType && (t.Name == "float" || t.Name == "double")
+}
+
+// IsArithmetic reports whether t is an integer or floating type
+func (t *Type) IsArithmetic() bool {
+	return t.IsInteger() || t.IsFloating()
+}
+
+// Promote applies the integer promotions: char and short become int
+func (t *Type) Promote() *Type {
+	if t.IsInteger() && integerRanks[t.Name] < integerRanks["int"] {
+		return Int
+	}
+	return t
+}
+
+// CommonType returns the type the usual arithmetic conversions bring two
+// arithmetic operands to, or nil if either is not arithmetic
+func CommonType(a, b *Type) *Type {
+	if !a.IsArithmetic() || !b.IsArithmetic() {
+		return nil
+	}
+	switch {
+	case a.Name == "double" || b.Name == "double":
+		return Double
+	case a.Name == "float" || b.Name == "float":
+		return Float
+	}
+	a, b = a.Promote(), b.Promote()
+	if integerRanks[a.Name] >= integerRanks[b.Name] {
+		return a
+	}
+	return b
+}
+
+// PointerTo returns a pointer to elem
+func PointerTo(elem *Type) *Type {
+	return &Type{Kind: PointerType, Elem: elem}
+}
+
+// ArrayOf returns an array of n elements of type elem
+func ArrayOf(elem *Type, n int) *Type {
+	return &Type{Kind: ArrayType, Elem: elem, Len: n}
+}
+
+// Decay returns the type an expression of type t has when used as a value:
+// arrays decay to a pointer to their first element
+func (t *Type) Decay() *Type {
+	if t.Kind == ArrayType {
+		return PointerTo(t.Elem)
+	}
+	return t
+}
+
+// IsVoidPointer reports whether t is void*
+func (t *Type) IsVoidPointer() bool {
+	return t.Kind == PointerType && t.Elem == Void
+}
+
+// IsFuncPointer reports whether t is a pointer to a function
+func (t *Type) IsFuncPointer() bool {
+	return t.Kind == PointerType && t.Elem.Kind == FuncType
+}
+
+// Equal reports whether t and other describe the same type
+func (t *Type) Equal(other *Type) bool {
+	if t.Kind != other.Kind || t.Name != other.Name || t.Len != other.Len || len(t.Params) != len(other.Params) {
+		return false
+	}
+	if (t.Elem == nil) != (other.Elem == nil) || (t.Elem != nil && !t.Elem.Equal(other.Elem)) {
+		return false
+	}
+	for i := range t.Params {
+		if !t.Params[i].Equal(other.Params[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the type in C abstract-declarator syntax
+func (t *Type) String() string {
+	switch t.Kind {
+	case PointerType:
+		if t.Elem.Kind == FuncType {
+			return t.Elem.Elem.String() + " (*)" + t.Elem.paramList()
+		}
+		return t.Elem.String() + "*"
+	case ArrayType:
+		return fmt.Sprintf("%s[%d]", t.Elem, t.Len)
+	case FuncType:
+		return t.Elem.String() + " " + t.paramList()
+	default:
+		return t.Name
+	}
+}
+
+func (t *Type) paramList() string {
+	params := []string{}
+	for _, param := range t.Params {
+		params = append(params, param.String())
+	}
+	return "(" + strings.Join(params, ", ") + ")"
+}
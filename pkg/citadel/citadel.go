@@ -0,0 +1,273 @@
+// Package citadel compiles C source to LLVM IR and reports the security
+// findings of the analysis passes, as the citadel command does, for Go
+// programs that embed the compiler rather than run the binary.
+//
+//	res, err := citadel.Compile(ctx, citadel.Source{Name: "a.c", Text: src}, citadel.Options{})
+//	if err != nil {
+//		for _, d := range res.Diagnostics {
+//			fmt.Println(d)
+//		}
+//	}
+package citadel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llirgen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/anouar-bakouch/citadel/pkg/sema"
+)
+
+// Source is a C translation unit to compile.
+type Source struct {
+	// Name is the file name diagnostics and findings give; it need not
+	// exist on disk.
+	Name string
+	// Text is the source, unless Reader is set, in which case Compile
+	// reads it from Reader.
+	Text   string
+	Reader io.Reader
+}
+
+// Options controls a compilation.
+type Options struct {
+	// Codegen controls the IR generated. Its SourceFile and Source are
+	// taken from the Source compiled.
+	Codegen codegen.Options
+	// Backend selects the IR generator: "text", or "" for it, or "llir"
+	// for the one built on github.com/llir/llvm.
+	Backend string
+	// Analysis configures the analysis passes; nil uses
+	// analysis.DefaultConfig.
+	Analysis *analysis.Config
+	// SkipAnalysis leaves Findings empty without running the passes.
+	SkipAnalysis bool
+	// SkipCodegen stops after the analysis, leaving IR empty. The program
+	// is still checked for semantic errors.
+	SkipCodegen bool
+	// Output, if set, receives the IR as it is generated instead of
+	// Result.IR; what was written before an error is incomplete.
+	Output io.Writer
+	// FileSet, if set, has the source added to it, for the offsets of the
+	// positions in the syntax tree, findings and diagnostics to resolve
+	// to file:line:col, as those of the other sources added are.
+	FileSet *lexer.FileSet
+	// Stream compiles the source a function at a time: each is parsed,
+	// generated, analyzed and released before the next, for translation
+	// units too large to hold whole as a syntax tree. It needs the text
+	// backend and, to keep the IR out of memory too, Output. A function
+	// can only call those declared before it, as C requires, and calling
+	// one declared later fails with ErrCalledBeforeDeclared. The analysis
+	// sees earlier functions through their summaries alone;
+	// Result.Program and Result.Manifest are left nil.
+	Stream bool
+	// MemoryLimit, when streaming, is how many bytes the summaries of the
+	// functions compiled so far may take in memory before the later ones
+	// are written to a temporary file in TempDir, or the default
+	// directory for temporary files if it is empty. 0 keeps them all in
+	// memory. It bounds nothing else: the source, its comments and the
+	// findings are held whole.
+	MemoryLimit int64
+	TempDir     string
+}
+
+// Result is what a compilation produced. When Compile fails, it holds
+// what the steps before the failing one produced.
+type Result struct {
+	// Program is the syntax tree, or nil if the source does not parse.
+	Program *ast.Program
+	// IR is the textual LLVM IR of the module, unless Options.Output
+	// took it.
+	IR string
+	// Findings are those of the analysis passes in source order, marked
+	// suppressed as the comments of the source say.
+	Findings []analysis.Finding
+	// Diagnostics are the errors and warnings about the source, in the
+	// order the steps reported them.
+	Diagnostics []Diagnostic
+	// Manifest lists the functions, globals and external dependencies of
+	// the module, with the findings in each function that are not
+	// suppressed, when the text backend generated it.
+	Manifest *codegen.Manifest
+}
+
+// Diagnostic is an error or warning about the source, with the notes and
+// fixes of the step that reported it: lexer, parser, semantic,
+// suppression, analysis or codegen.
+type Diagnostic = diag.Diagnostic
+
+// Compile lexes, parses and checks src, runs the analysis passes over it
+// and generates its IR, stopping at the first step that fails. The error
+// names that step and wraps what it found, for errors.As to pick out as a
+// *parser.SyntaxError, which may wrap a *lexer.Error, or a *codegen.Error;
+// the diagnostics of the result describe what failed. Once ctx is done,
+// Compile stops at the next function or analysis pass and returns
+// ctx.Err().
+//
+// The result depends on nothing but src and opts: compiling them again
+// gives byte-for-byte the same IR, manifest, findings and diagnostics,
+// which name the file as src.Name does and carry no timestamps, for
+// builds to be reproducible and their outputs cached.
+func Compile(ctx context.Context, src Source, opts Options) (res Result, err error) {
+	bag := &diag.Bag{}
+	defer func() { res.Diagnostics = bag.Diagnostics() }()
+	defer diag.Recover("compile", &err)
+	if opts.Backend != "" && opts.Backend != "text" && opts.Backend != "llir" {
+		return res, fmt.Errorf("unknown backend %q", opts.Backend)
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	if opts.Stream {
+		return compileStream(ctx, src, opts, bag)
+	}
+	r := src.Reader
+	if r == nil {
+		r = strings.NewReader(src.Text)
+	}
+	file, err := parser.ParseFile(nil, src.Name, r, parser.WithContext(ctx), parser.WithFileSet(opts.FileSet))
+	if file == nil {
+		return res, fmt.Errorf("reading %s: %w", src.Name, err)
+	}
+	src.Text = file.Source
+	bag.AddLexerErrors(src.Name, src.Text)
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	if err != nil {
+		bag.AddError("parser", src.Name, err)
+		return res, fmt.Errorf("parse error: %w", err)
+	}
+	program := file.Program
+	res.Program = program
+
+	codegenOpts := opts.Codegen
+	codegenOpts.Context = ctx
+	codegenOpts.SourceFile = src.Name
+	codegenOpts.Source = src.Text
+	codegenOpts.Sources = nil
+	err = sema.Check(program, codegenOpts)
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	if err != nil && codegen.IsBackendError(err) {
+		bag.AddError("codegen", src.Name, err)
+		return res, fmt.Errorf("code generation error: %w", err)
+	}
+	if err != nil {
+		bag.AddError("semantic", src.Name, err)
+		return res, fmt.Errorf("semantic error: %w", err)
+	}
+
+	suppressions, errs := analysis.ParseSuppressions(file.Comments)
+	for _, err := range errs {
+		bag.Add(Diagnostic{File: src.Name, Severity: diag.Warning, Stage: "suppression", Message: err.Error()})
+	}
+	res.Findings = []analysis.Finding{}
+	if !opts.SkipAnalysis {
+		config := analysis.DefaultConfig()
+		if opts.Analysis != nil {
+			copied := *opts.Analysis
+			config = &copied
+		}
+		config.Context = ctx
+		res.Findings, err = analysis.Analyze(program, config)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if err != nil {
+			bag.AddError("analysis", src.Name, err)
+			return res, fmt.Errorf("analysis error: %w", err)
+		}
+		analysis.Suppress(res.Findings, suppressions)
+	}
+	if opts.SkipCodegen {
+		return res, nil
+	}
+
+	if codegenOpts.DivisionChecks {
+		codegenOpts.ProvenDivisions = analysis.ProveDivisions(program)
+	}
+	var gen codegen.Backend = codegen.NewWithOptions(codegenOpts)
+	if opts.Backend == "llir" {
+		gen = llirgen.New(codegenOpts)
+	}
+	if opts.Output != nil {
+		err = gen.GenerateTo(opts.Output, program)
+	} else {
+		res.IR, err = gen.Generate(program)
+	}
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+	if err != nil {
+		bag.AddError("codegen", src.Name, err)
+		return res, fmt.Errorf("code generation error: %w", err)
+	}
+	if text, ok := gen.(*codegen.CodeGen); ok {
+		res.Manifest = text.Manifest(program)
+		counts := map[string]int{}
+		for _, f := range res.Findings {
+			if f.Suppressed == nil {
+				counts[f.Function]++
+			}
+		}
+		for i := range res.Manifest.Functions {
+			res.Manifest.Functions[i].Findings = counts[res.Manifest.Functions[i].Name]
+		}
+	}
+	return res, nil
+}
+
+// CompileAll compiles each of files as Compile does, on up to parallelism
+// goroutines at once, or runtime.GOMAXPROCS(0) if parallelism is 0 or
+// less. The results are in the order of files whichever finishes first,
+// and so is the error, which joins that of each file that failed, named
+// after it. Options.Output cannot be shared by the files, so it must be
+// nil; each result holds its IR.
+//
+// Compile keeps no state between calls, and neither do the lexers,
+// parsers and code generators it creates, so any number of compilations
+// can run at once.
+func CompileAll(ctx context.Context, files []Source, parallelism int, opts Options) ([]Result, error) {
+	if opts.Output != nil {
+		return nil, errors.New("CompileAll cannot write the IR of several files to Options.Output")
+	}
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+	results := make([]Result, len(files))
+	errs := make([]error, len(files))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range files {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i], errs[i] = Compile(ctx, files[i], opts)
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", files[i].Name, err))
+		}
+	}
+	return results, errors.Join(failed...)
+}
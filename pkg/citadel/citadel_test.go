@@ -0,0 +1,183 @@
+package citadel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+var sources = []string{
+	`int check(char *input) {
+	char buf[8];
+	strcpy(buf, input);
+	if (strcmp(buf, "secret") == 0) {
+		return 1;
+	}
+	return 0;
+}
+int main() { return check("guess"); }`,
+	`int f(int a, int b) {
+	int c = a / b;
+	if (a > 1) { c = c + a * 2; }
+	switch (a) { case 1: return c; default: break; }
+	return c;
+}`,
+	"int f() { return 1 }",
+	"int f() { return y; }",
+}
+
+// TestCompileAll checks that compiling many files at once, which go test
+// -race watches for data races, gives what compiling them one by one does
+func TestCompileAll(t *testing.T) {
+	ctx := context.Background()
+	var files []Source
+	for i := 0; i < 4; i++ {
+		for j, src := range sources {
+			files = append(files, Source{Name: fmt.Sprintf("f%d_%d.c", i, j), Text: src})
+		}
+	}
+	results, err := CompileAll(ctx, files, 8, Options{})
+	if err == nil {
+		t.Fatal("CompileAll of files with errors succeeded")
+	}
+	for i, file := range files {
+		want, wantErr := Compile(ctx, file, Options{})
+		got := results[i]
+		if failed := strings.Contains(err.Error(), file.Name+": "); failed != (wantErr != nil) {
+			t.Errorf("%s: CompileAll failed: %v, Compile failed: %v", file.Name, failed, wantErr != nil)
+		}
+		if got.IR != want.IR {
+			t.Errorf("%s: IR differs from that of Compile", file.Name)
+		}
+		if !reflect.DeepEqual(got.Findings, want.Findings) {
+			t.Errorf("%s: findings differ from those of Compile:\n%v\n%v", file.Name, got.Findings, want.Findings)
+		}
+		if !reflect.DeepEqual(got.Diagnostics, want.Diagnostics) {
+			t.Errorf("%s: diagnostics differ from those of Compile:\n%v\n%v", file.Name, got.Diagnostics, want.Diagnostics)
+		}
+	}
+}
+
+// TestDeterministic checks that compiling the same source with the same
+// options twice gives byte-for-byte the same IR, manifest, findings and
+// diagnostics, whatever order maps are iterated in, and that none of them
+// name the working directory
+func TestDeterministic(t *testing.T) {
+	ctx := context.Background()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := []codegen.Options{
+		{},
+		{OptLevel: 1},
+		{OptLevel: 1, StackUsage: true, SourceComments: true, CFI: true, BoundsChecks: true, OverflowChecks: true},
+		{Obfuscate: []string{"check", "f"}, Internalize: true, Exports: []string{"check"}},
+	}
+	for i, src := range sources {
+		for j, opts := range options {
+			source := Source{Name: fmt.Sprintf("src/f%d.c", i), Text: src}
+			first, firstErr := Compile(ctx, source, Options{Codegen: opts})
+			want, err := json.Marshal(first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(want), wd) {
+				t.Errorf("%s with options %d: the output names the working directory %s", source.Name, j, wd)
+			}
+			for n := 0; n < 4; n++ {
+				again, againErr := Compile(ctx, source, Options{Codegen: opts})
+				got, err := json.Marshal(again)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(againErr) != fmt.Sprint(firstErr) || !bytes.Equal(got, want) {
+					t.Fatalf("%s with options %d: compiling again gave a different result:\n%s\n%s", source.Name, j, want, got)
+				}
+			}
+		}
+	}
+}
+
+// TestStream checks that compiling a function at a time gives what
+// compiling the whole program does, when each function is declared before
+// it is called, with the summaries of the analysis spilled to disk
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	streamed := append(sources, `int *maybe(int n) {
+	if (n > 3) { return 0; }
+	return malloc(4);
+}
+int g(int n);
+int main(int argc, char **argv) {
+	int *p = maybe(argc);
+	*p = g(argc);
+	return 0;
+}
+int g(int n) { return n / (n - 2); }`)
+	for i, src := range streamed {
+		source := Source{Name: fmt.Sprintf("f%d.c", i), Text: src}
+		want, wantErr := Compile(ctx, source, Options{})
+		got, err := Compile(ctx, source, Options{Stream: true, MemoryLimit: 1, TempDir: t.TempDir()})
+		if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+			t.Errorf("%s: streaming failed with %v, compiling whole with %v", source.Name, err, wantErr)
+		}
+		if got.IR != want.IR {
+			t.Errorf("%s: IR differs from that of compiling whole:\n%s\n%s", source.Name, got.IR, want.IR)
+		}
+		if !reflect.DeepEqual(got.Findings, want.Findings) {
+			t.Errorf("%s: findings differ from those of compiling whole:\n%v\n%v", source.Name, got.Findings, want.Findings)
+		}
+		if !reflect.DeepEqual(got.Diagnostics, want.Diagnostics) {
+			t.Errorf("%s: diagnostics differ from those of compiling whole:\n%v\n%v", source.Name, got.Diagnostics, want.Diagnostics)
+		}
+	}
+}
+
+// TestStreamCalledBeforeDeclared checks that streaming a call to a
+// function defined after it, which compiling whole accepts, fails with
+// ErrCalledBeforeDeclared, and that an undefined function stays a plain
+// semantic error
+func TestStreamCalledBeforeDeclared(t *testing.T) {
+	ctx := context.Background()
+	later := Source{Name: "later.c", Text: "int main() { return g(2); }\nint g(int x) { return x; }"}
+	if _, err := Compile(ctx, later, Options{}); err != nil {
+		t.Fatalf("compiling whole: %v", err)
+	}
+	if _, err := Compile(ctx, later, Options{Stream: true}); !errors.Is(err, ErrCalledBeforeDeclared) {
+		t.Errorf("streaming: got %v, want ErrCalledBeforeDeclared", err)
+	}
+	undefined := Source{Name: "undefined.c", Text: "int main() { return h(2); }\nint g(int x) { return x; }"}
+	if _, err := Compile(ctx, undefined, Options{Stream: true}); err == nil || errors.Is(err, ErrCalledBeforeDeclared) {
+		t.Errorf("streaming a call to an undefined function: got %v, want a semantic error", err)
+	}
+}
+
+// BenchmarkCompileAll compiles batches of files as a server or batch job
+// does, for the buffers pooled across compilations to show in the
+// allocations per batch. Symbolic execution is off, as its solver would
+// account for nearly all of them
+func BenchmarkCompileAll(b *testing.B) {
+	ctx := context.Background()
+	config := analysis.DefaultConfig()
+	config.Symbolic = nil
+	var files []Source
+	for i := 0; i < 64; i++ {
+		files = append(files, Source{Name: fmt.Sprintf("f%d.c", i), Text: sources[i%2]})
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := CompileAll(ctx, files, 8, Options{Analysis: config}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package citadel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// ErrCalledBeforeDeclared is wrapped by the error of Compile under
+// Options.Stream when a function calls one the source only declares after
+// it. Compiling the whole program accepts that, but streaming needs a
+// prototype before the call.
+var ErrCalledBeforeDeclared = errors.New("function called before it is declared")
+
+// compileStream is Compile under Options.Stream. Beyond the source, read
+// whole for the lexer to scan and diagnostics to quote, it holds the
+// function at hand, the signatures of those before it, their summaries up
+// to Options.MemoryLimit, the comments and the findings; only the
+// summaries are bounded.
+func compileStream(ctx context.Context, src Source, opts Options, bag *diag.Bag) (res Result, err error) {
+	if opts.Backend == "llir" {
+		return res, errors.New("streaming needs the text backend")
+	}
+	name, source := src.Name, src.Text
+	if src.Reader != nil {
+		data, err := io.ReadAll(src.Reader)
+		if err != nil {
+			return res, fmt.Errorf("reading %s: %w", name, err)
+		}
+		source = string(data)
+	}
+	if opts.FileSet != nil {
+		opts.FileSet.AddFile(name, source)
+	}
+	bag.AddLexerErrors(name, source)
+	lex := lexer.New(source)
+	p := parser.New(lex, parser.WithContext(ctx))
+
+	codegenOpts := opts.Codegen
+	codegenOpts.Context = ctx
+	codegenOpts.SourceFile = name
+	codegenOpts.Source = source
+	codegenOpts.Sources = nil
+	gen := codegen.NewWithOptions(codegenOpts)
+	var ir strings.Builder
+	w := io.Writer(&ir)
+	switch {
+	case opts.SkipCodegen:
+		// The code generator still makes the semantic checks
+		w = io.Discard
+	case opts.Output != nil:
+		w = opts.Output
+	}
+	if err := gen.Begin(w); err != nil {
+		bag.AddError("codegen", name, err)
+		return res, fmt.Errorf("code generation error: %w", err)
+	}
+	defer gen.Abort()
+
+	var config *analysis.Config
+	if !opts.SkipAnalysis {
+		config = analysis.DefaultConfig()
+		if opts.Analysis != nil {
+			copied := *opts.Analysis
+			config = &copied
+		}
+		config.Context = ctx
+		config.Facts = analysis.NewFacts(opts.TempDir, opts.MemoryLimit)
+		defer config.Facts.Close()
+	}
+
+	// Each function is analyzed along with the signatures of the
+	// functions declared before it that it names, and of those whose
+	// attributes configure the analysis of every function
+	signatures := map[string]*ast.Function{}
+	var annotated []*ast.Function
+	findings := []analysis.Finding{}
+	for {
+		fn, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if err != nil {
+			if perr, ok := err.(*parser.Error); ok {
+				perr.File = name
+			}
+			bag.AddError("parser", name, err)
+			return res, fmt.Errorf("parse error: %w", err)
+		}
+		err = gen.Add(fn)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		if err != nil {
+			if errors.Is(err, codegen.ErrUndefinedFunction) {
+				if callee := declaredLater(p, fn, signatures); callee != "" {
+					err = fmt.Errorf("%w: %s calls %s, which is declared after it", ErrCalledBeforeDeclared, fn.Name, callee)
+				}
+			}
+			if codegen.IsBackendError(err) {
+				bag.AddError("codegen", name, err)
+				return res, fmt.Errorf("code generation error: %w", err)
+			}
+			bag.AddError("semantic", name, err)
+			return res, fmt.Errorf("semantic error: %w", err)
+		}
+		if config != nil && fn.Body != nil {
+			program := &ast.Program{Functions: append([]*ast.Function(nil), annotated...)}
+			for _, ref := range referenced(fn) {
+				if sig := signatures[ref]; sig != nil && len(sig.Attributes) == 0 {
+					program.Functions = append(program.Functions, sig)
+				}
+			}
+			program.Functions = append(program.Functions, fn)
+			found, err := analysis.Analyze(program, config)
+			if ctx.Err() != nil {
+				return res, ctx.Err()
+			}
+			if err != nil {
+				bag.AddError("analysis", name, err)
+				return res, fmt.Errorf("analysis error: %w", err)
+			}
+			findings = append(findings, found...)
+		}
+		if signatures[fn.Name] == nil {
+			signature := *fn
+			signature.Body = nil
+			signatures[fn.Name] = &signature
+			if len(fn.Attributes) > 0 {
+				annotated = append(annotated, &signature)
+			}
+		}
+	}
+
+	suppressions, errs := analysis.ParseSuppressions(lex.Comments())
+	for _, err := range errs {
+		bag.Add(Diagnostic{File: name, Severity: diag.Warning, Stage: "suppression", Message: err.Error()})
+	}
+	analysis.Suppress(findings, suppressions)
+	res.Findings = findings
+
+	if err := gen.End(); err != nil {
+		bag.AddError("codegen", name, err)
+		return res, fmt.Errorf("code generation error: %w", err)
+	}
+	if !opts.SkipCodegen && opts.Output == nil {
+		res.IR = ir.String()
+	}
+	return res, nil
+}
+
+// declaredLater parses the rest of the source for a function fn calls
+// that was not declared before it, and returns its name, or "" if there
+// is none.
+func declaredLater(p *parser.Parser, fn *ast.Function, signatures map[string]*ast.Function) string {
+	later := map[string]bool{}
+	for {
+		next, err := p.Next()
+		if err != nil {
+			break
+		}
+		later[next.Name] = true
+	}
+	for _, ref := range referenced(fn) {
+		if later[ref] && signatures[ref] == nil {
+			return ref
+		}
+	}
+	return ""
+}
+
+// referenced returns the names fn refers to, each once, in the order it
+// first does: its variables and the functions it calls or takes the
+// address of.
+func referenced(fn *ast.Function) []string {
+	var names []string
+	seen := map[string]bool{}
+	var expr func(e ast.Expression)
+	expr = func(e ast.Expression) {
+		switch e := e.(type) {
+		case *ast.Identifier:
+			if !seen[e.Name] {
+				seen[e.Name] = true
+				names = append(names, e.Name)
+			}
+		case *ast.BinaryOp:
+			expr(e.Left)
+			expr(e.Right)
+		case *ast.UnaryOp:
+			expr(e.Operand)
+		case *ast.IndexExpr:
+			expr(e.Array)
+			expr(e.Index)
+		case *ast.Assignment:
+			expr(e.Target)
+			expr(e.Value)
+		case *ast.CallExpr:
+			expr(e.Callee)
+			for _, arg := range e.Args {
+				expr(arg)
+			}
+		}
+	}
+	var stmts func(list []ast.Statement)
+	stmts = func(list []ast.Statement) {
+		for _, stmt := range list {
+			switch s := stmt.(type) {
+			case *ast.Block:
+				stmts(s.Statements)
+			case *ast.VarDecl:
+				expr(s.Value)
+			case *ast.IfStatement:
+				expr(s.Condition)
+				stmts(s.ThenBlock.Statements)
+				if s.ElseBlock != nil {
+					stmts(s.ElseBlock.Statements)
+				}
+			case *ast.WhileStatement:
+				expr(s.Condition)
+				stmts(s.Body.Statements)
+			case *ast.SwitchStatement:
+				expr(s.Tag)
+				for _, cs := range s.Cases {
+					stmts(cs.Body)
+				}
+			case *ast.ReturnStatement:
+				expr(s.Value)
+			case *ast.ExprStatement:
+				expr(s.Expr)
+			}
+		}
+	}
+	stmts(fn.Body.Statements)
+	return names
+}
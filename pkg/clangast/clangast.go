@@ -0,0 +1,290 @@
+// Package clangast imports the syntax tree clang dumps as JSON, with
+// -Xclang -ast-dump=json, as a Citadel program, for the analyses and
+// instrumentation to run on C that clang parses but Citadel's own parser
+// does not: headers, macros, typedefs and the rest of the preprocessor
+// and declaration syntax. The functions of the file clang was run on are
+// imported as far as they keep to what the AST represents
+package clangast
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// Options adjusts an Import
+type Options struct {
+	// Partial imports the functions that keep to what the AST represents
+	// and leaves out the others, with an error for each, rather than
+	// failing when any does not
+	Partial bool
+}
+
+// Import converts the JSON dump clang writes of a translation unit to r
+// into a program of the functions of its main file, the one clang was run
+// on. Header functions are left out, except for prototypes the main file
+// calls that are not C library functions codegen knows.
+//
+// Constructs the AST has no node for, such as for loops, unsigned types
+// or structs, are errors at their position in the main file, in a
+// parser.ErrorList of one per function. With Options.Partial, Import
+// returns the program without those functions along with the list; the
+// program is nil otherwise. Some are rewritten into what the AST has
+// instead: a != b into (a == b) == 0, a <= b into (a > b) == 0, !a into
+// a == 0, a += b into a = a + b, and ++ and -- as statements into
+// assignments
+func Import(r io.Reader, opts Options) (_ *ast.Program, err error) {
+	defer diag.Recover("clang import", &err)
+	var tu node
+	if err := json.NewDecoder(r).Decode(&tu); err != nil {
+		return nil, fmt.Errorf("reading clang AST: %w", err)
+	}
+	if tu.Kind != "TranslationUnitDecl" {
+		return nil, fmt.Errorf("reading clang AST: the root is %q, not a TranslationUnitDecl", tu.Kind)
+	}
+
+	locs := &locations{}
+	locs.node(&tu)
+	im := &importer{arena: ast.NewArena(), file: locs.main, called: map[string]bool{}}
+	program := &ast.Program{Arena: im.arena}
+	var errs parser.ErrorList
+	var headers []*node
+	for _, decl := range tu.Inner {
+		if decl.Kind != "FunctionDecl" || decl.IsImplicit {
+			if decl.Kind == "VarDecl" && im.inMain(decl) {
+				errs = append(errs, im.unsupported(decl, "global variables"))
+			}
+			continue
+		}
+		if !im.inMain(decl) {
+			headers = append(headers, decl)
+			continue
+		}
+		fn, err := im.function(decl)
+		if err != nil {
+			errs = append(errs, err.(*parser.Error))
+			continue
+		}
+		program.Functions = append(program.Functions, fn)
+	}
+
+	// Prototypes of the header functions the main file calls, ahead of
+	// its own functions
+	var protos []*ast.Function
+	declared := map[string]bool{}
+	for _, decl := range headers {
+		if !im.called[decl.Name] || declared[decl.Name] || codegen.LookupLibc(decl.Name) != nil {
+			continue
+		}
+		if fn, err := im.prototype(decl); err == nil {
+			protos = append(protos, fn)
+			declared[decl.Name] = true
+		}
+	}
+	program.Functions = append(protos, program.Functions...)
+
+	if len(errs) > 0 {
+		if !opts.Partial {
+			return nil, errs
+		}
+		return program, errs
+	}
+	return program, nil
+}
+
+// Dump runs clang on file for the JSON dump of its syntax tree Import
+// reads, with args before the file, such as -I and -D options. Clang is
+// looked up on PATH unless the CLANG environment variable names a
+// specific binary
+func Dump(ctx context.Context, file string, args ...string) ([]byte, error) {
+	tool := os.Getenv("CLANG")
+	if tool == "" {
+		tool = "clang"
+	}
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil, fmt.Errorf("the clang frontend needs clang: %v", err)
+	}
+	args = append([]string{"-Xclang", "-ast-dump=json", "-fsyntax-only"}, args...)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, append(args, file)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("clang failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// node is a node of clang's dump, with the fields of all the kinds Import
+// reads
+type node struct {
+	Kind           string          `json:"kind"`
+	Name           string          `json:"name"`
+	Loc            *loc            `json:"loc"`
+	Range          *srcRange       `json:"range"`
+	Type           *qualType       `json:"type"`
+	IsImplicit     bool            `json:"isImplicit"`
+	StorageClass   string          `json:"storageClass"`
+	Variadic       bool            `json:"variadic"`
+	Opcode         string          `json:"opcode"`
+	IsPostfix      bool            `json:"isPostfix"`
+	CastKind       string          `json:"castKind"`
+	Value          json.RawMessage `json:"value"`
+	HasElse        bool            `json:"hasElse"`
+	ReferencedDecl *node           `json:"referencedDecl"`
+	Inner          []*node         `json:"inner"`
+}
+
+type qualType struct {
+	QualType          string `json:"qualType"`
+	DesugaredQualType string `json:"desugaredQualType"`
+}
+
+type srcRange struct {
+	Begin *loc `json:"begin"`
+	End   *loc `json:"end"`
+}
+
+// loc is a source location. Clang leaves out the file and line when they
+// are those of the location written before, which locations.node fills in
+type loc struct {
+	Offset       int       `json:"offset"`
+	File         string    `json:"file"`
+	Line         int       `json:"line"`
+	Col          int       `json:"col"`
+	TokLen       int       `json:"tokLen"`
+	IncludedFrom *struct{} `json:"includedFrom"`
+	// A location in a macro expansion has where the macro spelled the
+	// code and where it was expanded instead of the fields above
+	SpellingLoc  *loc `json:"spellingLoc"`
+	ExpansionLoc *loc `json:"expansionLoc"`
+}
+
+// locations resolves the locations of a dump in the order clang wrote
+// them, which is that of the fields of node and then its inner nodes
+type locations struct {
+	file, main string
+	line       int
+	included   map[string]bool
+}
+
+func (l *locations) node(n *node) {
+	l.loc(n.Loc)
+	if n.Range != nil {
+		l.loc(n.Range.Begin)
+		l.loc(n.Range.End)
+	}
+	// A null in the dump would make a nil node for the importer to trip on
+	inner := n.Inner[:0]
+	for _, c := range n.Inner {
+		if c != nil {
+			l.node(c)
+			inner = append(inner, c)
+		}
+	}
+	n.Inner = inner
+}
+
+func (l *locations) loc(lc *loc) {
+	if lc == nil {
+		return
+	}
+	if lc.SpellingLoc != nil || lc.ExpansionLoc != nil {
+		l.loc(lc.SpellingLoc)
+		l.loc(lc.ExpansionLoc)
+		// Code from a macro is at the place it was expanded
+		if lc.ExpansionLoc != nil {
+			*lc = *lc.ExpansionLoc
+		}
+		return
+	}
+	if lc.Col == 0 {
+		return // an invalid location, as of implicit declarations
+	}
+	if lc.File != "" {
+		l.file = lc.File
+		if l.included == nil {
+			l.included = map[string]bool{}
+		}
+		if lc.IncludedFrom != nil {
+			l.included[lc.File] = true
+		} else if l.main == "" && !l.included[lc.File] {
+			l.main = lc.File
+		}
+	}
+	lc.File = l.file
+	if lc.Line != 0 {
+		l.line = lc.Line
+	}
+	lc.Line = l.line
+}
+
+// importer converts the nodes of the main file
+type importer struct {
+	arena *ast.Arena
+	file  string // the main file
+	// called is the functions the main file refers to
+	called map[string]bool
+}
+
+// inMain reports whether n was declared in the main file
+func (im *importer) inMain(n *node) bool {
+	return n.Loc != nil && n.Loc.Col > 0 && n.Loc.File == im.file
+}
+
+// begin returns where n starts
+func (im *importer) begin(n *node) lexer.Position {
+	if n.Range != nil && n.Range.Begin != nil && n.Range.Begin.Col > 0 {
+		return position(n.Range.Begin)
+	}
+	return position(n.Loc)
+}
+
+// end returns where the last token of n starts
+func (im *importer) end(n *node) lexer.Position {
+	if n.Range != nil && n.Range.End != nil && n.Range.End.Col > 0 {
+		return position(n.Range.End)
+	}
+	return im.begin(n)
+}
+
+// after returns where the last token of n ends, which is as far as the
+// dump locates n: clang leaves the semicolon out of a statement's range
+func (im *importer) after(n *node) lexer.Position {
+	if n.Range == nil || n.Range.End == nil || n.Range.End.Col == 0 {
+		return lexer.Position{}
+	}
+	pos := position(n.Range.End)
+	pos.Column += n.Range.End.TokLen
+	pos.Offset += n.Range.End.TokLen
+	return pos
+}
+
+func position(l *loc) lexer.Position {
+	if l == nil {
+		return lexer.Position{}
+	}
+	return lexer.Position{Line: l.Line, Column: l.Col, Offset: l.Offset}
+}
+
+// unsupported returns the error for what n is, which the AST has no node
+// for
+func (im *importer) unsupported(n *node, what string) *parser.Error {
+	return im.errorf(n, "cannot import %s", what)
+}
+
+func (im *importer) errorf(n *node, format string, args ...any) *parser.Error {
+	return &parser.Error{File: im.file, Pos: im.begin(n), End: im.end(n), Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,527 @@
+package clangast
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// function converts a FunctionDecl of the main file, a definition if it
+// has a body
+func (im *importer) function(n *node) (*ast.Function, error) {
+	fn, err := im.prototype(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range n.Inner {
+		if c.Kind == "CompoundStmt" {
+			if fn.Body, err = im.block(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fn, nil
+}
+
+// prototype converts the declaration part of a FunctionDecl
+func (im *importer) prototype(n *node) (*ast.Function, error) {
+	if n.Variadic {
+		return nil, im.unsupported(n, "variadic functions")
+	}
+	typ, err := im.typeOf(n)
+	if err != nil {
+		return nil, err
+	}
+	if typ.Kind != ast.FuncType {
+		return nil, im.errorf(n, "%s is not a function type", n.Type.QualType)
+	}
+	fn := im.arena.Function(ast.Function{
+		ReturnType: typ.Elem,
+		Name:       n.Name,
+		Static:     n.StorageClass == "static",
+		Pos:        im.begin(n),
+		NamePos:    position(n.Loc),
+	})
+	for _, c := range n.Inner {
+		if c.Kind != "ParmVarDecl" {
+			continue
+		}
+		typ, err := im.typeOf(c)
+		if err != nil {
+			return nil, err
+		}
+		fn.Params = append(fn.Params, im.arena.Parameter(ast.Parameter{
+			Type:    typ.Decay(),
+			Name:    c.Name,
+			Pos:     position(c.Loc),
+			Written: typ,
+		}))
+	}
+	return fn, nil
+}
+
+func (im *importer) block(n *node) (*ast.Block, error) {
+	b := im.arena.Block(ast.Block{Pos: im.begin(n), End: im.end(n)})
+	for _, c := range n.Inner {
+		stmts, err := im.statement(c)
+		if err != nil {
+			return nil, err
+		}
+		b.Statements = append(b.Statements, stmts...)
+	}
+	return b, nil
+}
+
+// body converts the then or else branch of an if, a block of its own if
+// it is not written as one
+func (im *importer) body(n *node) (*ast.Block, error) {
+	if n.Kind == "CompoundStmt" {
+		return im.block(n)
+	}
+	stmts, err := im.statement(n)
+	if err != nil {
+		return nil, err
+	}
+	return im.arena.Block(ast.Block{Pos: im.begin(n), Statements: stmts, End: im.end(n)}), nil
+}
+
+// unsupportedStmts names the statements the AST has no node for
+var unsupportedStmts = map[string]string{
+	"DoStmt":           "do loops",
+	"ForStmt":          "for loops",
+	"GotoStmt":         "goto statements",
+	"IndirectGotoStmt": "goto statements",
+	"LabelStmt":        "labels",
+	"ContinueStmt":     "continue statements",
+	"GCCAsmStmt":       "inline assembly operands",
+	"CaseStmt":         "case labels outside the block of a switch",
+	"DefaultStmt":      "case labels outside the block of a switch",
+}
+
+// statement converts a statement to none, for an empty one, or more, for
+// a declaration of several variables
+func (im *importer) statement(n *node) ([]ast.Statement, error) {
+	if err := im.checkOperands(n); err != nil {
+		return nil, err
+	}
+	switch n.Kind {
+	case "NullStmt":
+		return nil, nil
+	case "CompoundStmt":
+		b, err := im.block(n)
+		if err != nil {
+			return nil, err
+		}
+		return []ast.Statement{b}, nil
+	case "DeclStmt":
+		var stmts []ast.Statement
+		for _, c := range n.Inner {
+			if c.Kind != "VarDecl" {
+				continue // typedefs, struct and function declarations
+			}
+			decl, err := im.varDecl(c)
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, decl)
+		}
+		return stmts, nil
+	case "IfStmt":
+		s, err := im.ifStmt(n)
+		if err != nil {
+			return nil, err
+		}
+		return []ast.Statement{s}, nil
+	case "WhileStmt":
+		s, err := im.whileStmt(n)
+		if err != nil {
+			return nil, err
+		}
+		return []ast.Statement{s}, nil
+	case "SwitchStmt":
+		s, err := im.switchStmt(n)
+		if err != nil {
+			return nil, err
+		}
+		return []ast.Statement{s}, nil
+	case "BreakStmt":
+		return []ast.Statement{im.arena.BreakStatement(ast.BreakStatement{Pos: im.begin(n), End: im.after(n)})}, nil
+	case "ReturnStmt":
+		s := im.arena.ReturnStatement(ast.ReturnStatement{Pos: im.begin(n), End: im.after(n)})
+		if len(n.Inner) > 0 {
+			value, err := im.expression(n.Inner[0])
+			if err != nil {
+				return nil, err
+			}
+			s.Value = value
+		}
+		return []ast.Statement{s}, nil
+	}
+	if what, ok := unsupportedStmts[n.Kind]; ok {
+		return nil, im.unsupported(n, what)
+	}
+
+	e, err := im.exprStatement(n)
+	if err != nil {
+		return nil, err
+	}
+	return []ast.Statement{im.arena.ExprStatement(ast.ExprStatement{Pos: im.begin(n), Expr: e, End: im.after(n)})}, nil
+}
+
+// exprStatement converts an expression used as a statement, where ++ and
+// -- become assignments and a cast to void drops out
+func (im *importer) exprStatement(n *node) (ast.Expression, error) {
+	if err := im.checkOperands(n); err != nil {
+		return nil, err
+	}
+	switch {
+	case n.Kind == "UnaryOperator" && (n.Opcode == "++" || n.Opcode == "--"):
+		target, err := im.expression(n.Inner[0])
+		if err != nil {
+			return nil, err
+		}
+		step := im.arena.BinaryOp(ast.BinaryOp{Left: target, Operator: n.Opcode[:1], Right: im.arena.IntLiteral(ast.IntLiteral{Value: 1})})
+		return im.arena.Assignment(ast.Assignment{Target: target, Value: step}), nil
+	case n.Kind == "CStyleCastExpr" && n.CastKind == "ToVoid":
+		return im.exprStatement(n.Inner[0])
+	case n.Kind == "ParenExpr":
+		return im.exprStatement(n.Inner[0])
+	}
+	return im.expression(n)
+}
+
+func (im *importer) varDecl(n *node) (*ast.VarDecl, error) {
+	switch n.StorageClass {
+	case "static":
+		return nil, im.unsupported(n, "static local variables")
+	case "extern":
+		return nil, im.unsupported(n, "extern declarations in functions")
+	}
+	typ, err := im.typeOf(n)
+	if err != nil {
+		return nil, err
+	}
+	decl := im.arena.VarDecl(ast.VarDecl{Pos: im.begin(n), Type: typ, Name: n.Name, NamePos: position(n.Loc), End: im.after(n)})
+	if len(n.Inner) > 0 {
+		if decl.Value, err = im.expression(n.Inner[0]); err != nil {
+			return nil, err
+		}
+	}
+	return decl, nil
+}
+
+func (im *importer) ifStmt(n *node) (*ast.IfStatement, error) {
+	cond, err := im.expression(n.Inner[0])
+	if err != nil {
+		return nil, err
+	}
+	s := im.arena.IfStatement(ast.IfStatement{Pos: im.begin(n), Condition: cond})
+	if s.ThenBlock, err = im.body(n.Inner[1]); err != nil {
+		return nil, err
+	}
+	if n.HasElse && len(n.Inner) > 2 {
+		if s.ElseBlock, err = im.body(n.Inner[2]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (im *importer) whileStmt(n *node) (*ast.WhileStatement, error) {
+	if len(n.Inner) != 2 {
+		return nil, im.unsupported(n, "declarations in a while condition")
+	}
+	cond, err := im.expression(n.Inner[0])
+	if err != nil {
+		return nil, err
+	}
+	s := im.arena.WhileStatement(ast.WhileStatement{Pos: im.begin(n), Condition: cond})
+	if s.Body, err = im.body(n.Inner[1]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// switchStmt converts a switch whose body is a block of cases, as the
+// AST has them, each case taking the statements up to the next
+func (im *importer) switchStmt(n *node) (*ast.SwitchStatement, error) {
+	tag, err := im.expression(n.Inner[0])
+	if err != nil {
+		return nil, err
+	}
+	s := im.arena.SwitchStatement(ast.SwitchStatement{Pos: im.begin(n), Tag: tag, End: im.end(n)})
+	body := n.Inner[1]
+	if body.Kind != "CompoundStmt" {
+		return nil, im.unsupported(body, "switch bodies that are not a block")
+	}
+	for _, c := range body.Inner {
+		if err := im.switchItem(s, c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// switchItem adds a statement of the body of a switch to s: a case label
+// starts a case, with the statement it labels, and any other statement
+// goes on the last case
+func (im *importer) switchItem(s *ast.SwitchStatement, n *node) error {
+	if err := im.checkOperands(n); err != nil {
+		return err
+	}
+	switch n.Kind {
+	case "CaseStmt":
+		if len(n.Inner) != 2 {
+			return im.unsupported(n, "case ranges")
+		}
+		value, err := im.caseValue(n.Inner[0])
+		if err != nil {
+			return err
+		}
+		s.Cases = append(s.Cases, im.arena.SwitchCase(ast.SwitchCase{Pos: im.begin(n), Value: value}))
+		return im.switchItem(s, n.Inner[1])
+	case "DefaultStmt":
+		s.Cases = append(s.Cases, im.arena.SwitchCase(ast.SwitchCase{Pos: im.begin(n)}))
+		return im.switchItem(s, n.Inner[0])
+	}
+	if len(s.Cases) == 0 {
+		return im.unsupported(n, "statements before the first case of a switch")
+	}
+	stmts, err := im.statement(n)
+	if err != nil {
+		return err
+	}
+	last := s.Cases[len(s.Cases)-1]
+	last.Body = append(last.Body, stmts...)
+	return nil
+}
+
+// caseValue converts the label of a case, which clang has already
+// evaluated when it is not a literal, as for an enum constant or a macro
+func (im *importer) caseValue(n *node) (ast.Expression, error) {
+	if n.Kind == "ConstantExpr" && len(n.Value) > 0 {
+		v, err := im.integer(n)
+		if err != nil {
+			return nil, err
+		}
+		if v < 0 {
+			return im.arena.UnaryOp(ast.UnaryOp{Operator: "-", Operand: im.arena.IntLiteral(ast.IntLiteral{Value: -v})}), nil
+		}
+		return im.arena.IntLiteral(ast.IntLiteral{Value: v}), nil
+	}
+	return im.expression(n)
+}
+
+// integer returns the value of an integer literal or constant, which
+// clang writes as a string, or as a number for a character
+func (im *importer) integer(n *node) (int, error) {
+	var text string
+	if err := json.Unmarshal(n.Value, &text); err != nil {
+		text = string(n.Value)
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, im.errorf(n, "integer %s is out of range", text)
+	}
+	return v, nil
+}
+
+// operands is how many inner nodes the nodes of each kind that has some
+// have at least
+var operands = map[string]int{
+	"IfStmt": 2, "SwitchStmt": 2, "CaseStmt": 2, "DefaultStmt": 1,
+	"ImplicitCastExpr": 1, "CStyleCastExpr": 1, "ConstantExpr": 1, "ExprWithCleanups": 1,
+	"ParenExpr": 1, "UnaryOperator": 1, "BinaryOperator": 2, "CompoundAssignOperator": 2,
+	"ArraySubscriptExpr": 2, "CallExpr": 1,
+}
+
+// checkOperands returns an error for a node with fewer inner nodes than
+// its kind has, which clang does not write
+func (im *importer) checkOperands(n *node) error {
+	if len(n.Inner) < operands[n.Kind] {
+		return im.errorf(n, "malformed clang AST: %s with %d operands", n.Kind, len(n.Inner))
+	}
+	return nil
+}
+
+// Operators the AST has, and those rewritten in terms of them
+var (
+	binaryOps = map[string]bool{
+		"+": true, "-": true, "*": true, "/": true, "%": true,
+		"<": true, ">": true, "==": true, "&&": true, "||": true,
+	}
+	// a op b is (a negated b) == 0
+	negatedOps = map[string]string{"!=": "==", "<=": ">", ">=": "<"}
+)
+
+// arithmeticCasts are the cast kinds that convert between the types the
+// AST has, which its conversions make implicitly
+var arithmeticCasts = map[string]bool{
+	"IntegralCast": true, "IntegralToFloating": true, "FloatingToIntegral": true,
+	"FloatingCast": true, "NoOp": true, "LValueToRValue": true,
+}
+
+func (im *importer) expression(n *node) (ast.Expression, error) {
+	if err := im.checkOperands(n); err != nil {
+		return nil, err
+	}
+	switch n.Kind {
+	case "ImplicitCastExpr", "ConstantExpr", "ExprWithCleanups":
+		return im.expression(n.Inner[0])
+	case "CStyleCastExpr":
+		if !arithmeticCasts[n.CastKind] {
+			return nil, im.unsupported(n, "casts other than between arithmetic types")
+		}
+		return im.expression(n.Inner[0])
+	case "ParenExpr":
+		e, err := im.expression(n.Inner[0])
+		if err != nil {
+			return nil, err
+		}
+		switch e := e.(type) {
+		case *ast.BinaryOp:
+			e.Parenthesized = true
+		case *ast.Assignment:
+			e.Parenthesized = true
+		}
+		return e, nil
+	case "IntegerLiteral", "CharacterLiteral":
+		v, err := im.integer(n)
+		if err != nil {
+			return nil, err
+		}
+		return im.arena.IntLiteral(ast.IntLiteral{Value: v}), nil
+	case "StringLiteral":
+		var text string
+		if err := json.Unmarshal(n.Value, &text); err != nil || !strings.HasPrefix(text, `"`) {
+			return nil, im.unsupported(n, "wide and unicode string literals")
+		}
+		return im.arena.StringLiteral(ast.StringLiteral{Pos: im.begin(n), Value: strings.TrimSuffix(text[1:], `"`), End: im.after(n)}), nil
+	case "DeclRefExpr":
+		decl := n.ReferencedDecl
+		if decl == nil {
+			return nil, im.errorf(n, "reference to nothing")
+		}
+		switch decl.Kind {
+		case "EnumConstantDecl":
+			return nil, im.unsupported(n, "enum constants")
+		case "FunctionDecl":
+			im.called[decl.Name] = true
+		}
+		return im.arena.Identifier(ast.Identifier{Pos: im.begin(n), Name: decl.Name}), nil
+	case "BinaryOperator":
+		return im.binary(n)
+	case "CompoundAssignOperator":
+		op := strings.TrimSuffix(n.Opcode, "=")
+		if !binaryOps[op] {
+			return nil, im.unsupported(n, "the "+n.Opcode+" operator")
+		}
+		target, err := im.expression(n.Inner[0])
+		if err != nil {
+			return nil, err
+		}
+		value, err := im.expression(n.Inner[1])
+		if err != nil {
+			return nil, err
+		}
+		value = im.arena.BinaryOp(ast.BinaryOp{Left: target, Operator: op, Right: value})
+		return im.arena.Assignment(ast.Assignment{Target: target, Value: value}), nil
+	case "UnaryOperator":
+		return im.unary(n)
+	case "ArraySubscriptExpr":
+		array, err := im.expression(n.Inner[0])
+		if err != nil {
+			return nil, err
+		}
+		index, err := im.expression(n.Inner[1])
+		if err != nil {
+			return nil, err
+		}
+		return im.arena.IndexExpr(ast.IndexExpr{Array: array, Index: index}), nil
+	case "CallExpr":
+		callee, err := im.expression(n.Inner[0])
+		if err != nil {
+			return nil, err
+		}
+		call := im.arena.CallExpr(ast.CallExpr{Pos: im.begin(n), Callee: callee, End: im.after(n)})
+		for _, arg := range n.Inner[1:] {
+			e, err := im.expression(arg)
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, e)
+		}
+		return call, nil
+	}
+	return nil, im.unsupported(n, unsupportedExpr(n.Kind))
+}
+
+func (im *importer) binary(n *node) (ast.Expression, error) {
+	if n.Opcode != "=" && !binaryOps[n.Opcode] && negatedOps[n.Opcode] == "" {
+		return nil, im.unsupported(n, "the "+n.Opcode+" operator")
+	}
+	left, err := im.expression(n.Inner[0])
+	if err != nil {
+		return nil, err
+	}
+	right, err := im.expression(n.Inner[1])
+	if err != nil {
+		return nil, err
+	}
+	if n.Opcode == "=" {
+		return im.arena.Assignment(ast.Assignment{Target: left, Value: right}), nil
+	}
+	if op, ok := negatedOps[n.Opcode]; ok {
+		return im.isZero(im.arena.BinaryOp(ast.BinaryOp{Left: left, Operator: op, Right: right, Parenthesized: true})), nil
+	}
+	return im.arena.BinaryOp(ast.BinaryOp{Left: left, Operator: n.Opcode, Right: right}), nil
+}
+
+func (im *importer) unary(n *node) (ast.Expression, error) {
+	switch n.Opcode {
+	case "-", "*", "+", "!":
+	case "++", "--":
+		return nil, im.unsupported(n, "++ and -- inside expressions")
+	default:
+		return nil, im.unsupported(n, "the unary "+n.Opcode+" operator")
+	}
+	operand, err := im.expression(n.Inner[0])
+	if err != nil {
+		return nil, err
+	}
+	switch n.Opcode {
+	case "+":
+		return operand, nil
+	case "!":
+		return im.isZero(operand), nil
+	}
+	return im.arena.UnaryOp(ast.UnaryOp{Operator: n.Opcode, Operand: operand}), nil
+}
+
+// isZero returns e == 0
+func (im *importer) isZero(e ast.Expression) ast.Expression {
+	return im.arena.BinaryOp(ast.BinaryOp{Left: e, Operator: "==", Right: im.arena.IntLiteral(ast.IntLiteral{Value: 0})})
+}
+
+// unsupportedExpr names an expression the AST has no node for
+func unsupportedExpr(kind string) string {
+	switch kind {
+	case "MemberExpr":
+		return "struct and union members"
+	case "ConditionalOperator", "BinaryConditionalOperator":
+		return "conditional expressions"
+	case "UnaryExprOrTypeTraitExpr":
+		return "sizeof and alignof"
+	case "InitListExpr":
+		return "initializer lists"
+	case "FloatingLiteral":
+		return "floating constants"
+	case "CompoundLiteralExpr":
+		return "compound literals"
+	case "StmtExpr":
+		return "statement expressions"
+	}
+	return "clang " + kind + " nodes"
+}
@@ -0,0 +1,39 @@
+package clangast_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/clangast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/sema"
+)
+
+// FuzzImport checks that importing any JSON returns an error rather than
+// panicking, and that a program it imports is one sema checks without
+// panicking either
+func FuzzImport(f *testing.F) {
+	for _, seed := range []string{
+		`{"kind":"TranslationUnitDecl","inner":[]}`,
+		`{"kind":"TranslationUnitDecl","inner":[{"kind":"FunctionDecl","loc":{"offset":4,"file":"a.c","line":1,"col":5},"range":{"begin":{"offset":0,"col":1},"end":{"offset":26,"col":27}},"name":"f","type":{"qualType":"int (int)"},"inner":[{"kind":"ParmVarDecl","loc":{"offset":10,"col":11},"range":{"begin":{"offset":6,"col":7},"end":{"offset":10,"col":11}},"name":"a","type":{"qualType":"int"}},{"kind":"CompoundStmt","range":{"begin":{"offset":13,"col":14},"end":{"offset":26,"col":27}},"inner":[{"kind":"ReturnStmt","range":{"begin":{"offset":15,"col":16},"end":{"offset":24,"col":25}},"inner":[{"kind":"UnaryOperator","range":{"begin":{"offset":22,"col":23},"end":{"offset":24,"col":25}},"type":{"qualType":"int"},"opcode":"!","inner":[{"kind":"ImplicitCastExpr","range":{"begin":{"offset":23,"col":24},"end":{"offset":23,"col":24}},"castKind":"LValueToRValue","inner":[{"kind":"DeclRefExpr","range":{"begin":{"offset":23,"col":24},"end":{"offset":23,"col":24}},"referencedDecl":{"kind":"ParmVarDecl","name":"a"}}]}]}]}]}]}]}`,
+		`{"kind":"TranslationUnitDecl","inner":[{"kind":"FunctionDecl","loc":{"file":"a.c","line":1,"col":5},"name":"g","type":{"qualType":"char *(*(int (*)[4], ...))(void)"},"inner":[null,{"kind":"CompoundStmt","inner":[{"kind":"SwitchStmt","inner":[{"kind":"IntegerLiteral","value":"1"},{"kind":"CompoundStmt","inner":[{"kind":"CaseStmt","inner":[{"kind":"ConstantExpr","value":"-1"},{"kind":"BreakStmt"}]}]}]}]}]}]}`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		noPanic := func(err error) {
+			var internal *diag.InternalError
+			if errors.As(err, &internal) {
+				t.Fatalf("%v\n%s", err, internal.Stack)
+			}
+		}
+		program, err := clangast.Import(strings.NewReader(input), clangast.Options{Partial: true})
+		noPanic(err)
+		if program == nil {
+			return
+		}
+		noPanic(sema.Check(program, codegen.Options{}))
+	})
+}
@@ -0,0 +1,269 @@
+package clangast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// typeOf converts the type of a declaration, looking through typedefs to
+// the type they name when the name is not one the AST has
+func (im *importer) typeOf(n *node) (*ast.Type, error) {
+	if n.Type == nil {
+		return nil, im.errorf(n, "declaration without a type")
+	}
+	typ, err := parseType(n.Type.QualType)
+	if err != nil && n.Type.DesugaredQualType != "" {
+		typ, err = parseType(n.Type.DesugaredQualType)
+	}
+	if err != nil {
+		return nil, im.errorf(n, "cannot import type %s: %v", n.Type.QualType, err)
+	}
+	return typ, nil
+}
+
+// parseType converts a type as clang writes it, such as int,
+// const char *, int [8], int (*)(int, char **) or int (void) for a
+// function
+func parseType(s string) (*ast.Type, error) {
+	p := &typeParser{toks: typeTokens(s)}
+	typ, err := p.typeName()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf("unexpected %q", p.peek())
+	}
+	return typ, nil
+}
+
+// typeTokens splits a type into words and punctuation
+func typeTokens(s string) []string {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case strings.HasPrefix(s[i:], "..."):
+			toks = append(toks, "...")
+			i += 3
+		default:
+			toks = append(toks, s[i:i+1])
+			i++
+		}
+	}
+	return toks
+}
+
+type typeParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *typeParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *typeParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// qualifiers are the words that do not change the type for the AST
+var qualifiers = map[string]bool{
+	"const": true, "volatile": true, "restrict": true, "__restrict": true, "signed": true,
+}
+
+// typeName parses the base type and the abstract declarator after it
+func (p *typeParser) typeName() (*ast.Type, error) {
+	base, err := p.base()
+	if err != nil {
+		return nil, err
+	}
+	return p.declarator(base)
+}
+
+// base parses the words that name a basic type, in any order
+func (p *typeParser) base() (*ast.Type, error) {
+	var words []string
+	for {
+		tok := p.peek()
+		if tok == "" || !isWord(tok) {
+			break
+		}
+		p.next()
+		if !qualifiers[tok] {
+			words = append(words, tok)
+		}
+	}
+	longs, name := 0, ""
+	for _, w := range words {
+		switch w {
+		case "long":
+			longs++
+		case "int":
+			if name == "" {
+				name = w
+			}
+		case "char", "short", "float", "double":
+			if name != "" && name != "int" {
+				return nil, fmt.Errorf("%s %s", name, w)
+			}
+			name = w
+		default:
+			return nil, fmt.Errorf("%s is not a type the AST has", w)
+		}
+	}
+	switch {
+	case longs > 0 && name == "double":
+		return nil, fmt.Errorf("long double is not a type the AST has")
+	case longs > 0 && (name == "" || name == "int"):
+		// long long is as wide as long on the targets codegen has
+		name = "long"
+	case longs > 0:
+		return nil, fmt.Errorf("long %s", name)
+	case name == "" && len(words) == 0:
+		return nil, fmt.Errorf("missing type name")
+	case name == "":
+		// signed alone
+		name = "int"
+	}
+	return ast.BasicTypes[name], nil
+}
+
+func isWord(tok string) bool {
+	c := rune(tok[0])
+	return c == '_' || unicode.IsLetter(c)
+}
+
+// declarator parses an abstract declarator, returning the type it gives
+// base: pointers first, then either a parenthesized declarator, whose
+// type applies to what follows it, or array and function suffixes
+func (p *typeParser) declarator(base *ast.Type) (*ast.Type, error) {
+	for p.peek() == "*" || qualifiers[p.peek()] {
+		if p.next() == "*" {
+			base = ast.PointerTo(base)
+		}
+	}
+	if p.peek() == "(" && p.pos+1 < len(p.toks) && (p.toks[p.pos+1] == "*" || p.toks[p.pos+1] == "(" || p.toks[p.pos+1] == "[") {
+		// In int (*)[4] the suffix applies to int, and the pointer to the
+		// result: skip the inner declarator, take the suffixes after it,
+		// and come back to it with their type
+		p.next()
+		inner := p.pos
+		if err := p.skipParens(); err != nil {
+			return nil, err
+		}
+		outer, err := p.suffixes(base)
+		if err != nil {
+			return nil, err
+		}
+		end := p.pos
+		p.pos = inner
+		typ, err := p.declarator(outer)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("unbalanced parentheses")
+		}
+		p.pos = end
+		return typ, nil
+	}
+	return p.suffixes(base)
+}
+
+// skipParens moves past the ) that closes the ( just consumed
+func (p *typeParser) skipParens() error {
+	for depth := 1; depth > 0; {
+		switch p.next() {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case "":
+			return fmt.Errorf("unbalanced parentheses")
+		}
+	}
+	return nil
+}
+
+// suffixes parses array sizes and parameter lists. The first binds
+// tightest to the name, so int [2][3] is an array of 2 arrays of 3
+func (p *typeParser) suffixes(base *ast.Type) (*ast.Type, error) {
+	var apply []func(*ast.Type) *ast.Type
+	for {
+		switch p.peek() {
+		case "[":
+			p.next()
+			n, err := strconv.Atoi(p.next())
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("arrays without a positive constant size")
+			}
+			if p.next() != "]" {
+				return nil, fmt.Errorf("unbalanced brackets")
+			}
+			apply = append(apply, func(elem *ast.Type) *ast.Type { return ast.ArrayOf(elem, n) })
+		case "(":
+			p.next()
+			params, err := p.params()
+			if err != nil {
+				return nil, err
+			}
+			apply = append(apply, func(ret *ast.Type) *ast.Type {
+				return &ast.Type{Kind: ast.FuncType, Elem: ret, Params: params}
+			})
+		default:
+			typ := base
+			for i := len(apply) - 1; i >= 0; i-- {
+				typ = apply[i](typ)
+			}
+			return typ, nil
+		}
+	}
+}
+
+// params parses a parameter list after its (, adjusting array and
+// function parameters to pointers
+func (p *typeParser) params() ([]*ast.Type, error) {
+	var params []*ast.Type
+	if p.peek() == "void" && p.pos+1 < len(p.toks) && p.toks[p.pos+1] == ")" {
+		p.pos += 2
+		return params, nil
+	}
+	for p.peek() != ")" {
+		if p.peek() == "..." {
+			return nil, fmt.Errorf("variadic functions are not in the AST")
+		}
+		typ, err := p.typeName()
+		if err != nil {
+			return nil, err
+		}
+		if typ.Kind == ast.FuncType {
+			typ = ast.PointerTo(typ)
+		}
+		params = append(params, typ.Decay())
+		if p.peek() == "," {
+			p.next()
+		} else if p.peek() != ")" {
+			return nil, fmt.Errorf("unexpected %q in parameters", p.peek())
+		}
+	}
+	p.next()
+	return params, nil
+}
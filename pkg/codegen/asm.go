@@ -0,0 +1,87 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// cEscapes maps the simple C escape sequences to the bytes they denote.
+var cEscapes = map[byte]byte{
+	'n': '\n', 't': '\t', 'r': '\r', 'v': '\v', 'f': '\f', 'a': '\a', 'b': '\b',
+	'0': 0, '\\': '\\', '"': '"', '\'': '\'', '?': '?',
+}
+
+// unescapeC decodes the escape sequences of a C string literal body.
+func unescapeC(literal string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(literal); i++ {
+		if literal[i] != '\\' {
+			b.WriteByte(literal[i])
+			continue
+		}
+		i++
+		if i == len(literal) {
+			return "", fmt.Errorf("string literal ends in a backslash")
+		}
+		ch, ok := cEscapes[literal[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported escape sequence \\%c", literal[i])
+		}
+		b.WriteByte(ch)
+	}
+	return b.String(), nil
+}
+
+// AsmTemplate converts the source text of a basic asm statement to an
+// LLVM inline assembly template. Basic asm has no operands, so a literal
+// $ must be doubled to keep LLVM from reading it as an operand reference.
+func AsmTemplate(literal string) (string, error) {
+	text, err := unescapeC(literal)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(text, "$", "$$", -1), nil
+}
+
+// AsmConstraints returns the constraint string for a basic asm statement
+// on the given target. As with clang, x86 asm is assumed to clobber the
+// direction and condition flags and the FP status register.
+func AsmConstraints(triple string) string {
+	if strings.HasPrefix(triple, "x86_64") || strings.HasPrefix(triple, "i386") || strings.HasPrefix(triple, "i686") {
+		return "~{dirflag},~{fpsr},~{flags}"
+	}
+	return ""
+}
+
+// quoteLLVM renders s as an LLVM string constant, hex-escaping quotes,
+// backslashes and non-printable bytes.
+func quoteLLVM(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch < ' ' || ch > '~' || ch == '"' || ch == '\\' {
+			fmt.Fprintf(&b, "\\%02X", ch)
+		} else {
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// generateAsm emits a basic inline assembly statement as a call to a
+// side-effecting asm expression, which LLVM will neither move nor delete.
+func (c *CodeGen) generateAsm(stmt *ast.AsmStatement) error {
+	if c.target.IsWasm() {
+		return fmt.Errorf("inline assembly is not supported on %s", c.target.Triple)
+	}
+	template, err := AsmTemplate(stmt.Template)
+	if err != nil {
+		return fmt.Errorf("invalid asm statement: %v", err)
+	}
+	c.emit("call void asm sideeffect %s, %s()", quoteLLVM(template), quoteLLVM(AsmConstraints(c.target.Triple)))
+	return nil
+}
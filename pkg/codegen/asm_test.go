@@ -0,0 +1,36 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// TestInlineAsm checks the template and constraints of basic asm on
+// each target, that it runs under lli, and that WebAssembly rejects it
+func TestInlineAsm(t *testing.T) {
+	const src = `int main() { __asm__("nop"); __asm__("movl $0, %eax"); return 4; }`
+	ir := generate(t, src, codegen.Options{})
+	for _, want := range []string{
+		`call void asm sideeffect "nop", "~{dirflag},~{fpsr},~{flags}"()`,
+		`call void asm sideeffect "movl $$0, %eax", "~{dirflag},~{fpsr},~{flags}"()`,
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+	if ir := generate(t, `int main() { __asm__("nop"); return 0; }`, codegen.Options{Target: "aarch64-unknown-linux-gnu"}); !strings.Contains(ir, `call void asm sideeffect "nop", ""()`) {
+		t.Errorf("aarch64 asm has constraints:\n%s", ir)
+	}
+	_, err := generateErr(src, codegen.Options{Target: "wasm32-unknown-unknown"})
+	if err == nil || !strings.Contains(err.Error(), "inline assembly is not supported on wasm32") {
+		t.Errorf("wasm32: got %v, want inline assembly rejected", err)
+	}
+
+	ir = generate(t, `int main() { __asm__("nop"); return 4; }`, codegen.Options{})
+	if status, _ := llitest.Run(t, ir); status != 4 {
+		t.Errorf("exit status %d, want 4", status)
+	}
+}
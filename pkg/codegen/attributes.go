@@ -0,0 +1,97 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// functionAttributes returns the attribute list for a function definition.
+func (c *CodeGen) functionAttributes(fn *ast.Function) []string {
+	attrs := []string{}
+	sanitizers := []struct {
+		enabled bool
+		name    string
+	}{
+		{c.opts.SanitizeAddress, "address"},
+		{c.opts.SanitizeMemory, "memory"},
+		{c.opts.SanitizeThread, "thread"},
+	}
+	for _, san := range sanitizers {
+		if san.enabled && !sanitizerDisabled(fn, san.name) {
+			attrs = append(attrs, "sanitize_"+san.name)
+		}
+	}
+	if c.opts.FramePointer != "" {
+		attrs = append(attrs, fmt.Sprintf("\"frame-pointer\"=\"%s\"", c.opts.FramePointer))
+	}
+	if c.opts.StackProtector != "" {
+		attrs = append(attrs, c.opts.StackProtector)
+	}
+	if c.opts.SafeStack {
+		attrs = append(attrs, "safestack")
+	}
+	if c.opts.ShadowCallStack {
+		attrs = append(attrs, "shadowcallstack")
+		// The shadow stack pointer lives in x18, which must be reserved or
+		// llc refuses to lower the function.
+		attrs = append(attrs, "\"target-features\"=\"+reserve-x18\"")
+	}
+	if c.target.IsWasm() {
+		if name := WasmExportName(fn, c.opts); name != "" {
+			attrs = append(attrs, fmt.Sprintf("\"wasm-export-name\"=\"%s\"", name))
+		}
+	}
+	return attrs
+}
+
+// sanitizerDisabled reports whether the source opts fn out of the named
+// sanitizer, via no_sanitize("name"), a bare no_sanitize, or the GCC-style
+// no_sanitize_name spelling.
+func sanitizerDisabled(fn *ast.Function, name string) bool {
+	if fn.HasAttribute("no_sanitize_" + name) {
+		return true
+	}
+	for _, attr := range fn.Attributes {
+		if attr.Name != "no_sanitize" {
+			continue
+		}
+		if len(attr.Args) == 0 {
+			return true
+		}
+		for _, arg := range attr.Args {
+			if arg == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attributeGroup returns the " #N" suffix for a definition carrying attrs,
+// reusing an existing group when the same set was already emitted.
+func (c *CodeGen) attributeGroup(attrs []string) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	key := strings.Join(attrs, " ")
+	for i, group := range c.attrGroups {
+		if group == key {
+			return fmt.Sprintf(" #%d", i)
+		}
+	}
+	c.attrGroups = append(c.attrGroups, key)
+	return fmt.Sprintf(" #%d", len(c.attrGroups)-1)
+}
+
+// writeAttributeGroups emits the attribute group definitions collected
+// while generating the module.
+func (c *CodeGen) writeAttributeGroups() {
+	for i, group := range c.attrGroups {
+		c.output.WriteString(fmt.Sprintf("attributes #%d = { %s }\n", i, group))
+	}
+	if len(c.attrGroups) > 0 {
+		c.output.WriteString("\n")
+	}
+}
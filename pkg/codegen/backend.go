@@ -0,0 +1,17 @@
+package codegen
+
+import (
+	"io"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Backend lowers a parsed program to textual LLVM IR. CodeGen is the
+// default backend; llirgen builds the module with github.com/llir/llvm.
+type Backend interface {
+	Generate(program *ast.Program) (string, error)
+	// GenerateTo writes the IR to w instead of returning it
+	GenerateTo(w io.Writer, program *ast.Program) error
+}
+
+var _ Backend = (*CodeGen)(nil)
@@ -0,0 +1,79 @@
+package codegen_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// largeProgram returns a program of n functions with branches, arrays,
+// a loop, a switch and calls, whose IR runs to a few kilobytes each
+func largeProgram(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `int f%d(int a, char *s) {
+    int buf[8];
+    int i = 0;
+    int x = a * 2 + 1;
+    while (i < 8) {
+        buf[i] = x + i;
+        i = i + 1;
+    }
+    if (x > 10 && a < 100) {
+        x = x - buf[3] / (a + 1);
+    }
+    switch (a) {
+    case 1:
+        puts("one");
+        break;
+    default:
+        x = -x;
+    }
+    return x + s[0];
+}
+`, i)
+	}
+	b.WriteString("int main() { return f0(3, \"x\"); }\n")
+	return b.String()
+}
+
+// BenchmarkGenerateTo compares streaming the IR of a large program to a
+// writer with GenerateTo against building the whole module as a string
+// with Generate. Bytes are those of the IR
+func BenchmarkGenerateTo(b *testing.B) {
+	program, err := parser.New(lexer.New(largeProgram(2000))).ParseProgram()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ir, err := codegen.New().Generate(program)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bench := range []struct {
+		name     string
+		generate func() error
+	}{
+		{"Generate", func() error {
+			_, err := codegen.New().Generate(program)
+			return err
+		}},
+		{"GenerateTo", func() error {
+			return codegen.New().GenerateTo(io.Discard, program)
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(ir)))
+			for i := 0; i < b.N; i++ {
+				if err := bench.generate(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
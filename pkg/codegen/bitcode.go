@@ -0,0 +1,43 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Bitcode assembles textual IR into LLVM bitcode with llvm-as. The
+// assembler is looked up on PATH unless the LLVM_AS environment variable
+// names a specific binary.
+func Bitcode(ir string) ([]byte, error) {
+	tool := os.Getenv("LLVM_AS")
+	if tool == "" {
+		tool = "llvm-as"
+	}
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return nil, fmt.Errorf("bitcode output needs llvm-as: %v", err)
+	}
+
+	out, err := runTool(path, []string{"-o", "-", "-"}, ir)
+	if err != nil {
+		return nil, fmt.Errorf("llvm-as failed: %v", err)
+	}
+	return out, nil
+}
+
+// runTool runs an LLVM tool with input on stdin and returns its stdout.
+// The error includes whatever the tool wrote to stderr.
+func runTool(path string, args []string, input string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,38 @@
+package codegen_test
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// TestBitcode checks that Bitcode assembles the IR into a bitcode file
+// that lli runs like the text, and names llvm-as when it cannot find it
+func TestBitcode(t *testing.T) {
+	if _, err := exec.LookPath("llvm-as"); err != nil {
+		t.Skip(err)
+	}
+	ir := generate(t, symbolsProgram, codegen.Options{})
+	bc, err := codegen.Bitcode(ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(bc, []byte("BC\xC0\xDE")) {
+		t.Fatalf("output does not start with the bitcode magic: % x", bc[:min(len(bc), 8)])
+	}
+	if status, _ := llitest.Run(t, string(bc)); status != symbolsStatus {
+		t.Errorf("lli a.bc: exit status %d, want %d", status, symbolsStatus)
+	}
+
+	if _, err := codegen.Bitcode("this is not IR"); err == nil || !strings.Contains(err.Error(), "llvm-as failed") {
+		t.Errorf("invalid IR: got %v, want llvm-as failed", err)
+	}
+	t.Setenv("LLVM_AS", "no-such-llvm-as")
+	if _, err := codegen.Bitcode(ir); err == nil || !strings.Contains(err.Error(), "bitcode output needs llvm-as") {
+		t.Errorf("missing llvm-as: got %v, want bitcode output needs llvm-as", err)
+	}
+}
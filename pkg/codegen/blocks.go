@@ -0,0 +1,301 @@
+package codegen
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// basicBlock is a labeled sequence of instructions within the function
+// being generated. An empty label marks the unnamed entry block.
+type basicBlock struct {
+	label  string
+	instrs []string
+}
+
+var (
+	labelRef = regexp.MustCompile(`label %([-a-zA-Z$._0-9]+)`)
+	localRef = regexp.MustCompile(`%([0-9]+)\b`)
+)
+
+// terminator returns the block's terminating instruction, or "" if the
+// block does not end in one yet.
+func (b *basicBlock) terminator() string {
+	if len(b.instrs) == 0 {
+		return ""
+	}
+	last := b.instrs[len(b.instrs)-1]
+	for _, op := range []string{"br ", "ret ", "ret", "switch ", "unreachable"} {
+		if strings.HasPrefix(last, op) {
+			return last
+		}
+	}
+	return ""
+}
+
+// successors returns the labels the block's terminator can branch to.
+func (b *basicBlock) successors() []string {
+	succs := []string{}
+	for _, m := range labelRef.FindAllStringSubmatch(b.terminator(), -1) {
+		succs = append(succs, m[1])
+	}
+	return succs
+}
+
+// emit appends an instruction to the current block. Code following a
+// terminator is unreachable and goes into a fresh block that dead block
+// elimination later removes.
+func (c *CodeGen) emit(format string, args ...interface{}) {
+	if c.cur.terminator() != "" {
+		c.startBlock(strconv.Itoa(c.nextLabel()))
+	}
+	if c.note != "" {
+		c.cur.instrs = append(c.cur.instrs, c.note)
+		c.note = ""
+	}
+	c.cur.instrs = append(c.cur.instrs, fmt.Sprintf(format, args...))
+}
+
+// startBlock begins a new block. If the current block has no terminator
+// yet, control falls through into the new one.
+func (c *CodeGen) startBlock(label string) {
+	if c.cur != nil && c.cur.terminator() == "" {
+		c.cur.instrs = append(c.cur.instrs, "br label %"+label)
+	}
+	c.cur = &basicBlock{label: label}
+	c.blocks = append(c.blocks, c.cur)
+}
+
+// removeDeadBlocks drops blocks unreachable from the entry block and
+// returns how many it removed.
+func (c *CodeGen) removeDeadBlocks() int {
+	byLabel := map[string]*basicBlock{}
+	for _, b := range c.blocks {
+		byLabel[b.label] = b
+	}
+
+	reachable := map[*basicBlock]bool{}
+	worklist := []*basicBlock{c.blocks[0]}
+	for len(worklist) > 0 {
+		b := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		if reachable[b] {
+			continue
+		}
+		reachable[b] = true
+		for _, succ := range b.successors() {
+			if next, ok := byLabel[succ]; ok {
+				worklist = append(worklist, next)
+			}
+		}
+	}
+
+	live := c.blocks[:0]
+	for _, b := range c.blocks {
+		if reachable[b] {
+			live = append(live, b)
+		}
+	}
+	removed := len(c.blocks) - len(live)
+	c.blocks = live
+	return removed
+}
+
+// mergeBlocks folds a block into its predecessor when the predecessor
+// ends in an unconditional branch to it and is its only predecessor, and
+// returns the number of blocks merged away.
+func (c *CodeGen) mergeBlocks() int {
+	merged := 0
+	for changed := true; changed; {
+		changed = false
+
+		preds := map[string]int{}
+		for _, b := range c.blocks {
+			for _, succ := range b.successors() {
+				preds[succ]++
+			}
+		}
+
+		for i, b := range c.blocks {
+			succs := b.successors()
+			if len(succs) != 1 || !strings.HasPrefix(b.terminator(), "br label") || preds[succs[0]] != 1 {
+				continue
+			}
+			j := c.blockIndex(succs[0])
+			if j <= 0 || j == i || c.hasPhis(succs[0]) {
+				continue
+			}
+			next := c.blocks[j]
+			b.instrs = append(b.instrs[:len(b.instrs)-1], next.instrs...)
+			c.blocks = append(c.blocks[:j], c.blocks[j+1:]...)
+			c.retargetPhis(next.label, b.label)
+			merged++
+			changed = true
+			break
+		}
+	}
+	return merged
+}
+
+// skipEmptyBlocks redirects branches to blocks that contain nothing but an
+// unconditional branch straight to that branch's target.
+func (c *CodeGen) skipEmptyBlocks() {
+	for _, b := range c.blocks[1:] {
+		if len(b.instrs) != 1 || !strings.HasPrefix(b.instrs[0], "br label") {
+			continue
+		}
+		target := b.successors()[0]
+		if target == b.label || c.hasPhis(target) {
+			continue
+		}
+		for _, pred := range c.blocks {
+			if pred.terminator() != "" {
+				last := len(pred.instrs) - 1
+				pred.instrs[last] = retarget(pred.instrs[last], b.label, target)
+			}
+		}
+	}
+}
+
+// retarget rewrites the branches of a terminator to block old so they go
+// to block new instead.
+func retarget(term, old, new string) string {
+	return labelRef.ReplaceAllStringFunc(term, func(ref string) string {
+		if ref == "label %"+old {
+			return "label %" + new
+		}
+		return ref
+	})
+}
+
+func (c *CodeGen) hasPhis(label string) bool {
+	i := c.blockIndex(label)
+	return i >= 0 && len(c.blocks[i].instrs) > 0 && strings.Contains(c.blocks[i].instrs[0], " phi ")
+}
+
+// retargetPhis rewrites phi incoming edges from block old to block new
+// after old has been merged into new.
+func (c *CodeGen) retargetPhis(old, new string) {
+	for _, b := range c.blocks {
+		for i, instr := range b.instrs {
+			if strings.Contains(instr, " phi ") {
+				b.instrs[i] = strings.Replace(instr, "%"+old+" ]", "%"+new+" ]", -1)
+			}
+		}
+	}
+}
+
+func (c *CodeGen) blockIndex(label string) int {
+	for i, b := range c.blocks {
+		if b.label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+// renumber gives the values and blocks of the current function their
+// final names: the name hint recorded for them, uniqued, or else the next
+// number in sequence, as the textual IR format requires for unnamed
+// values. An unnamed entry block, whose label is not printed, implicitly
+// takes %0.
+func (c *CodeGen) renumber() {
+	used := map[string]bool{}
+	for _, param := range c.function.Params {
+		used[param.Name] = true
+	}
+	for _, b := range c.blocks {
+		if _, err := strconv.Atoi(b.label); err != nil {
+			used[b.label] = true
+		}
+	}
+
+	mapping := map[string]string{}
+	next, unique := 0, 0
+	versions := map[string]int{}
+	assign := func(id string) {
+		hint, ok := c.regNames[id]
+		if !ok || c.opts.DiscardValueNames {
+			mapping[id] = strconv.Itoa(next)
+			next++
+			return
+		}
+		// Unique like LLVM does, with a suffix shared by the whole
+		// function, except for versioned names
+		name := hint
+		if strings.HasSuffix(hint, ".") {
+			name = hint + strconv.Itoa(versions[hint])
+			versions[hint]++
+		}
+		for used[name] {
+			if strings.HasSuffix(hint, ".") {
+				name = hint + strconv.Itoa(versions[hint])
+				versions[hint]++
+			} else {
+				unique++
+				name = hint + strconv.Itoa(unique)
+			}
+		}
+		used[name] = true
+		mapping[id] = name
+	}
+	for _, b := range c.blocks {
+		if _, err := strconv.Atoi(b.label); err == nil {
+			assign(b.label)
+		}
+		for _, instr := range b.instrs {
+			if m := localRef.FindStringSubmatchIndex(instr); m != nil && m[0] == 0 && strings.HasPrefix(instr[m[1]:], " = ") {
+				assign(instr[m[2]:m[3]])
+			}
+		}
+	}
+
+	rename := func(s string) string {
+		return localRef.ReplaceAllStringFunc(s, func(ref string) string {
+			if n, ok := mapping[ref[1:]]; ok {
+				return "%" + n
+			}
+			return ref
+		})
+	}
+	for _, b := range c.blocks {
+		if n, ok := mapping[b.label]; ok {
+			b.label = n
+		}
+		for i, instr := range b.instrs {
+			if !isComment(instr) {
+				b.instrs[i] = rename(instr)
+			}
+		}
+	}
+}
+
+// finishBlocks optimizes and flattens the current function when
+// requested and numbers its values.
+func (c *CodeGen) finishBlocks() error {
+	if c.opts.OptLevel >= 1 {
+		c.optimize()
+	}
+	if c.obfuscated() {
+		if err := c.flatten(); err != nil {
+			return err
+		}
+	}
+	c.renumber()
+	return nil
+}
+
+// writeBlocks writes the blocks of the current function to the output.
+func (c *CodeGen) writeBlocks() {
+	for i, b := range c.blocks {
+		if i > 0 {
+			c.output.WriteString(fmt.Sprintf("\n%s:\n", b.label))
+		} else if _, err := strconv.Atoi(b.label); err != nil {
+			c.output.WriteString(b.label + ":\n")
+		}
+		for _, instr := range b.instrs {
+			c.output.WriteString("  " + instr + "\n")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// CallingConvs lists the calling conventions a function can be given,
+// either through Options.CallingConv or an attribute of the same name.
+var CallingConvs = []string{"ccc", "fastcc"}
+
+// CallingConv returns the calling convention of fn: the one named by its
+// attributes, otherwise the default from opts. main always defaults to
+// the C convention so the C runtime can call it.
+func CallingConv(fn *ast.Function, opts Options) string {
+	for _, name := range CallingConvs {
+		if fn.HasAttribute(name) {
+			return name
+		}
+	}
+	if fn.Name == "main" || opts.CallingConv == "" {
+		return "ccc"
+	}
+	return opts.CallingConv
+}
+
+// callingConvPrefix returns the keyword for cc followed by a space, for
+// splicing into define, declare and call instructions. The C convention is
+// LLVM's default and is left implicit.
+func callingConvPrefix(cc string) string {
+	if cc == "" || cc == "ccc" {
+		return ""
+	}
+	return cc + " "
+}
+
+// directCallee returns the function a call names directly, or nil when the
+// call goes through a function pointer.
+func (c *CodeGen) directCallee(call *ast.CallExpr) *ast.Function {
+	id, ok := call.Callee.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+	if _, local := c.variables[id.Name]; local {
+		return nil
+	}
+	return c.functions[id.Name]
+}
+
+// calleeSignature returns the function type being called.
+func (c *CodeGen) calleeSignature(call *ast.CallExpr) (*ast.Type, error) {
+	if fn := c.directCallee(call); fn != nil {
+		return fn.Signature(), nil
+	}
+	if id, ok := call.Callee.(*ast.Identifier); ok {
+		if _, local := c.varTypes[id.Name]; !local {
+			return nil, c.undefined(ErrUndefinedFunction, id)
+		}
+	}
+	t, err := c.typeOf(call.Callee)
+	if err != nil {
+		return nil, err
+	}
+	if !t.IsFuncPointer() {
+		return nil, fmt.Errorf("called object %s is not a function", call.Callee)
+	}
+	return t.Elem, nil
+}
+
+func (c *CodeGen) generateCall(call *ast.CallExpr) (string, error) {
+	if intrinsic := c.memIntrinsic(call); intrinsic != "" {
+		return c.generateMemIntrinsic(call, intrinsic)
+	}
+	if fn := c.libcCallee(call); fn != nil {
+		return c.generateLibcCall(call, fn)
+	}
+
+	sig, err := c.calleeSignature(call)
+	if err != nil {
+		return "", err
+	}
+	if len(call.Args) != len(sig.Params) {
+		return "", fmt.Errorf("call to %s expects %d arguments, got %d", call.Callee, len(sig.Params), len(call.Args))
+	}
+
+	callee := ""
+	// Indirect calls can only assume the default convention
+	cc := c.opts.CallingConv
+	if fn := c.directCallee(call); fn != nil {
+		callee = c.symbol(fn)
+		cc = CallingConv(fn, c.opts)
+	} else {
+		callee, err = c.generateExpression(call.Callee)
+		if err != nil {
+			return "", err
+		}
+		if c.opts.CFI {
+			c.generateTypeTest(callee, sig)
+		}
+	}
+
+	args := []string{}
+	for i, arg := range call.Args {
+		value, err := c.generateExpressionAs(arg, sig.Params[i])
+		if err != nil {
+			return "", fmt.Errorf("argument %d to %s: %v", i+1, call.Callee, err)
+		}
+		args = append(args, fmt.Sprintf("%s %s", c.llvmType(sig.Params[i]), value))
+	}
+
+	resultReg := c.nextNamedReg("call")
+	c.emit("%%%d = call %s%s %s(%s)", resultReg, callingConvPrefix(cc), c.llvmType(sig.Elem), callee, strings.Join(args, ", "))
+	return fmt.Sprintf("%%%d", resultReg), nil
+}
+
+// generateTypeTest emits a forward-edge CFI check that target points to a
+// function whose type metadata matches sig, trapping otherwise.
+func (c *CodeGen) generateTypeTest(target string, sig *ast.Type) {
+	c.declare("llvm.type.test", "declare i1 @llvm.type.test(i8*, metadata)")
+	c.declare("llvm.trap", "declare void @llvm.trap()")
+
+	castReg := c.nextReg()
+	c.emit("%%%d = bitcast %s* %s to i8*", castReg, c.llvmType(sig), target)
+	testReg := c.nextReg()
+	c.emit("%%%d = call i1 @llvm.type.test(i8* %%%d, metadata !\"%s\")", testReg, castReg, typeID(sig))
+
+	contLabel := c.nextNamedLabel("cfi.cont")
+	trapLabel := c.nextNamedLabel("cfi.trap")
+	c.emit("br i1 %%%d, label %%%d, label %%%d", testReg, contLabel, trapLabel)
+	c.startBlock(strconv.Itoa(trapLabel))
+	c.emit("call void @llvm.trap()")
+	c.emit("unreachable")
+	c.startBlock(strconv.Itoa(contLabel))
+}
+
+// declare records an external declaration to emit once after the function
+// definitions.
+func (c *CodeGen) declare(name, decl string) {
+	if c.declared[name] {
+		return
+	}
+	c.declared[name] = true
+	c.declarations = append(c.declarations, decl)
+	c.declaredNames = append(c.declaredNames, name)
+}
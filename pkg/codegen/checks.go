@@ -0,0 +1,135 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Labels of the per-function trap blocks that runtime checks branch to.
+const (
+	boundsFailLabel   = "__citadel_bounds_fail"
+	overflowFailLabel = "__citadel_overflow_fail"
+	divFailLabel      = "__citadel_div_fail"
+)
+
+// overflowIntrinsics maps arithmetic operators to the prefix of the LLVM
+// intrinsic that reports signed overflow.
+var overflowIntrinsics = map[string]string{
+	"+": "sadd",
+	"-": "ssub",
+	"*": "smul",
+}
+
+// trapBlock records that the current function branches to the trap block
+// with the given label and returns the label.
+func (c *CodeGen) trapBlock(label string) string {
+	for _, existing := range c.trapLabels {
+		if existing == label {
+			return label
+		}
+	}
+	c.trapLabels = append(c.trapLabels, label)
+	return label
+}
+
+// generateTrapBlocks emits the trap blocks used by the current function.
+func (c *CodeGen) generateTrapBlocks() {
+	if len(c.trapLabels) == 0 {
+		return
+	}
+	c.declare("llvm.trap", "declare void @llvm.trap()")
+	for _, label := range c.trapLabels {
+		c.startBlock(label)
+		c.emit("call void @llvm.trap()")
+		c.emit("unreachable")
+	}
+}
+
+// generateBoundsCheck branches to the trap block unless 0 <= index < length,
+// for an i64 index.
+// A single unsigned comparison covers both ends of the range.
+func (c *CodeGen) generateBoundsCheck(index string, length int) {
+	if i, ok := constantValue(index); ok && i >= 0 && i < int64(length) {
+		return
+	}
+	failReg := c.nextReg()
+	c.emit("%%%d = icmp uge i64 %s, %d", failReg, index, length)
+	c.generateTrapBranch(fmt.Sprintf("%%%d", failReg), boundsFailLabel, "bounds.ok")
+}
+
+// generateCheckedArithmetic emits left op right on the integer type typ
+// through the given llvm.*.with.overflow intrinsic, trapping if the
+// operation overflowed.
+func (c *CodeGen) generateCheckedArithmetic(intrinsic, typ, left, right string) string {
+	name := fmt.Sprintf("llvm.%s.with.overflow.%s", intrinsic, typ)
+	pair := fmt.Sprintf("{ %s, i1 }", typ)
+	c.declare(name, fmt.Sprintf("declare %s @%s(%s, %s)", pair, name, typ, typ))
+
+	pairReg := c.nextReg()
+	c.emit("%%%d = call %s @%s(%s %s, %s %s)", pairReg, pair, name, typ, left, typ, right)
+	resultReg := c.nextReg()
+	c.emit("%%%d = extractvalue %s %%%d, 0", resultReg, pair, pairReg)
+	overflowReg := c.nextReg()
+	c.emit("%%%d = extractvalue %s %%%d, 1", overflowReg, pair, pairReg)
+
+	c.generateTrapBranch(fmt.Sprintf("%%%d", overflowReg), overflowFailLabel, "overflow.ok")
+	return fmt.Sprintf("%%%d", resultReg)
+}
+
+// generateDivisionCheck traps before sdiv/srem on the integer type typ
+// when the divisor is zero or the operation is INT_MIN / -1, both of
+// which are undefined behavior. Checks that constant operands or proof
+// rule out are skipped.
+func (c *CodeGen) generateDivisionCheck(typ, dividend, divisor string, proof DivisionProof) {
+	d, constDivisor := constantValue(divisor)
+	n, constDividend := constantValue(dividend)
+	min := minInt(typeBits(typ))
+
+	switch {
+	case constDivisor && d == 0:
+		c.generateTrapBranch("true", divFailLabel, "div.ok")
+		return
+	case !constDivisor && !proof.NonzeroDivisor:
+		zeroReg := c.nextReg()
+		c.emit("%%%d = icmp eq %s %s, 0", zeroReg, typ, divisor)
+		c.generateTrapBranch(fmt.Sprintf("%%%d", zeroReg), divFailLabel, "div.ok")
+	}
+
+	if proof.NoOverflow || (constDivisor && d != -1) || (constDividend && n != min) {
+		return
+	}
+	conds := []string{}
+	if !constDivisor {
+		reg := c.nextReg()
+		c.emit("%%%d = icmp eq %s %s, -1", reg, typ, divisor)
+		conds = append(conds, fmt.Sprintf("%%%d", reg))
+	}
+	if !constDividend {
+		reg := c.nextReg()
+		c.emit("%%%d = icmp eq %s %s, %d", reg, typ, dividend, min)
+		conds = append(conds, fmt.Sprintf("%%%d", reg))
+	}
+	cond := "true"
+	switch len(conds) {
+	case 1:
+		cond = conds[0]
+	case 2:
+		bothReg := c.nextReg()
+		c.emit("%%%d = and i1 %s, %s", bothReg, conds[0], conds[1])
+		cond = fmt.Sprintf("%%%d", bothReg)
+	}
+	c.generateTrapBranch(cond, divFailLabel, "div.ok")
+}
+
+// generateTrapBranch branches to the trap block when cond holds and
+// continues in a fresh block otherwise. A "true" condition traps
+// unconditionally; the continuation block is then unreachable.
+func (c *CodeGen) generateTrapBranch(cond, trapLabel, okName string) {
+	label := c.nextNamedLabel(okName)
+	if cond == "true" {
+		c.emit("br label %%%s", c.trapBlock(trapLabel))
+	} else {
+		c.emit("br i1 %s, label %%%s, label %%%d", cond, c.trapBlock(trapLabel), label)
+	}
+	c.startBlock(strconv.Itoa(label))
+}
@@ -0,0 +1,186 @@
+package codegen_test
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// trapped is the status of a program that a run-time check stopped
+const trapped = 128 + int(syscall.SIGILL)
+
+// runProgram compiles src with opts and runs it, returning its exit status,
+// or 128 plus the number of the signal that killed it. The test is skipped
+// when there is no toolchain to link with
+func runProgram(t *testing.T, src string, opts codegen.Options) int {
+	t.Helper()
+	tool, err := codegen.FindLinker("")
+	if err != nil {
+		t.Skip(err)
+	}
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	ir, err := codegen.NewWithOptions(opts).Generate(program)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	target, err := codegen.LookupTarget(opts.Target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(t.TempDir(), "a.out")
+	if err := codegen.Link(ir, exe, tool, target, opts); err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	err = exec.Command(exe).Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+// TestDivisionChecks checks that -div-checks traps on division by zero and
+// on the most negative value divided by -1, at the width of int and of
+// long, and lets 0 / -1 through; constant operands are folded at -O1
+func TestDivisionChecks(t *testing.T) {
+	for _, test := range []struct {
+		decls, expr string
+		opt         int
+		want        int
+	}{
+		{"int m1 = 0 - 1; int zero = 0; int x = 7;", "0 / m1", 0, 3},
+		{"int m1 = 0 - 1; int zero = 0; int x = 7;", "x / zero", 0, trapped},
+		{"int m1 = 0 - 1; int min = 0 - 2147483647 - 1;", "min / m1", 0, trapped},
+		{"int m1 = 0 - 1; int min = 0 - 2147483647 - 1;", "min % m1", 0, trapped},
+		{"", "(0 - 2147483647 - 1) / (0 - 1)", 1, trapped},
+		{"", "0 / (0 - 1)", 1, 3},
+		{"long m1 = 0 - 1; long zero = 0; long x = 7;", "0 / m1", 0, 3},
+		{"long m1 = 0 - 1; long zero = 0; long x = 7;", "x / zero", 0, trapped},
+		// 2^21 cubed wraps around to -2^63
+		{"long m1 = 0 - 1; long a = 2097152; long min = a * a * a;", "min / m1", 0, trapped},
+	} {
+		src := "int main() { " + test.decls + " long r = " + test.expr + "; return 3; }"
+		opts := codegen.Options{DivisionChecks: true, OptLevel: test.opt}
+		if got := runProgram(t, src, opts); got != test.want {
+			t.Errorf("%s at -O%d: exit status %d, want %d", test.expr, test.opt, got, test.want)
+		}
+	}
+}
+
+// TestProvenDivisions checks that the division checks are left out where
+// the value ranges of the program prove they cannot trap, as inside a
+// branch on the divisor, and kept where they do not
+func TestProvenDivisions(t *testing.T) {
+	for _, test := range []struct {
+		src         string
+		checks      int // branches to the trap block without proofs
+		provenCheck int // and with them
+	}{
+		{"int f(int x, int y) { if (y > 0) { return x / y; } return 0; }", 2, 0},
+		{"int f(int x, int y) { if (y == 0) { return 0; } return 100 % y; }", 1, 0},
+		{"int f(int x, int y) { if (y < 0) { return x / y; } return 0; }", 2, 1},
+		{"int f(int x, int y) { if (x > 0) { return x / y; } return 0; }", 2, 1},
+		{"int f(int x, int y) { return x / y; }", 2, 2},
+	} {
+		program, err := parser.New(lexer.New(test.src)).ParseProgram()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, proofs := range []map[*ast.BinaryOp]codegen.DivisionProof{nil, analysis.ProveDivisions(program)} {
+			ir, err := codegen.NewWithOptions(codegen.Options{DivisionChecks: true, ProvenDivisions: proofs}).Generate(program)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.checks
+			if proofs != nil {
+				want = test.provenCheck
+			}
+			if got := strings.Count(ir, "label %__citadel_div_fail"); got != want {
+				t.Errorf("%s with proofs %v: %d checks, want %d:\n%s", test.src, proofs != nil, got, want, ir)
+			}
+		}
+	}
+
+	const src = "int quot(int x, int y) { if (y > 0) { return x / y; } return x / (y + 1); } int main() { return quot(12, 4) + quot(5, 0 - 1); }"
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := codegen.Options{DivisionChecks: true, ProvenDivisions: analysis.ProveDivisions(program)}
+	if got := runProgram(t, src, opts); got != trapped {
+		t.Errorf("exit status %d, want the unproven division by zero trapped", got)
+	}
+}
+
+// TestLongIndex checks that a long index is not truncated to int, so
+// that -bounds-checks sees the index the program uses
+func TestLongIndex(t *testing.T) {
+	const decls = "int a[4]; a[0] = 10; a[1] = 11; long i = 65536; "
+	for _, test := range []struct {
+		src    string
+		checks bool
+		want   int
+	}{
+		{"int main() { " + decls + "i = i * 65536 + 1; return a[i]; }", true, trapped},
+		{"int main() { " + decls + "i = 1; return a[i]; }", true, 11},
+		{"int main() { " + decls + "i = 1; return a[i]; }", false, 11},
+	} {
+		if got := runProgram(t, test.src, codegen.Options{BoundsChecks: test.checks}); got != test.want {
+			t.Errorf("%s with bounds checks %v: exit status %d, want %d", test.src, test.checks, got, test.want)
+		}
+	}
+}
+
+// TestCFI checks that a program built with -cfi, whose type tests llc
+// would crash on, has them lowered and runs, and that native output of
+// it is produced
+func TestCFI(t *testing.T) {
+	if _, err := exec.LookPath("opt"); err != nil {
+		t.Skip(err)
+	}
+	const src = "int add(int a, int b) { return a + b; } int apply(int (*f)(int, int), int x) { return f(x, 2); } int main() { return apply(add, 40); }"
+	opts := codegen.Options{CFI: true}
+	if got := runProgram(t, src, opts); got != 42 {
+		t.Errorf("exit status %d, want 42", got)
+	}
+
+	tool, err := codegen.FindToolchain("")
+	if err != nil {
+		t.Skip(err)
+	}
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, err := codegen.NewWithOptions(opts).Generate(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := codegen.LookupTarget("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range codegen.NativeKinds {
+		if out, err := codegen.Native(ir, kind, tool, target, opts); err != nil || len(out) == 0 {
+			t.Errorf("%s: %d bytes, %v", kind, len(out), err)
+		}
+	}
+}
@@ -0,0 +1,758 @@
+package codegen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// Target used when no other is requested.
+const (
+	DefaultDataLayout = "e-m:e-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128"
+	DefaultTriple     = "x86_64-pc-linux-gnu"
+)
+
+type CodeGen struct {
+	output     *bufio.Writer // module text, streamed to the caller's writer
+	regCounter int
+	variables  map[string]int // maps var name to register number
+	varTypes   map[string]*ast.Type
+	// exprTypes remembers the types of the expressions typeOf has seen
+	// since varTypes last changed
+	exprTypes     map[ast.Expression]*ast.Type
+	functions     map[string]*ast.Function
+	functionNames []string // the names of functions, in order of first declaration
+	opts          Options
+	target        *Target
+	attrGroups    []string         // attribute groups, indexed by group number
+	metadata      []string         // metadata nodes, indexed by node number
+	namedMD       []*namedMetadata // named metadata lists in output order
+	declared      map[string]bool
+	declarations  []string          // external declarations needed by the module
+	declaredNames []string          // the names of declarations, in the same order
+	globals       []string          // global constant definitions
+	stringGlobals map[string]string // string literal bytes to the constant holding them
+	regNames      map[string]string // name hints for registers of the current function
+
+	blocks      []*basicBlock // blocks of the current function
+	cur         *basicBlock   // block instructions are appended to
+	trapLabels  []string      // trap blocks the current function branches to
+	breakLabels []int         // blocks break jumps to, innermost last
+	loopDepth   int           // loops around the statement being generated
+	function    *ast.Function
+
+	note        string              // source comment to put before the next instruction
+	notedLine   int                 // source line of the last comment in this function
+	sourceLines map[string][]string // lines of each source file, split on first use
+
+	passStats      []PassStat
+	stackEstimates []StackEstimate
+}
+
+// New creates a code generator configured by opts, such as
+// New(WithTarget("wasm32-unknown-unknown"), WithOptLevel(1)).
+func New(opts ...Option) *CodeGen {
+	var options Options
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return NewWithOptions(options)
+}
+
+// NewWithOptions creates a code generator with the given options.
+func NewWithOptions(opts Options) *CodeGen {
+	return &CodeGen{
+		variables:     make(map[string]int),
+		varTypes:      make(map[string]*ast.Type),
+		functions:     make(map[string]*ast.Function),
+		declared:      make(map[string]bool),
+		stringGlobals: make(map[string]string),
+		sourceLines:   make(map[string][]string),
+		regCounter:    1,
+		opts:          opts,
+	}
+}
+
+func (c *CodeGen) nextReg() int {
+	reg := c.regCounter
+	c.regCounter++
+	return reg
+}
+
+// nextLabel returns a number for a new block. Blocks share the numbering
+// of unnamed values, which writeBlocks makes sequential.
+func (c *CodeGen) nextLabel() int {
+	return c.nextReg()
+}
+
+// nextNamedReg returns a new register that renumber names after hint,
+// uniqued, unless value names are discarded. A hint ending in "." is
+// numbered by version instead (x.0, x.1, ...).
+func (c *CodeGen) nextNamedReg(hint string) int {
+	reg := c.nextReg()
+	c.regNames[strconv.Itoa(reg)] = hint
+	return reg
+}
+
+// nextNamedLabel is nextNamedReg for blocks.
+func (c *CodeGen) nextNamedLabel(hint string) int {
+	return c.nextNamedReg(hint)
+}
+
+// Generate lowers program and returns the module's textual IR.
+func (c *CodeGen) Generate(program *ast.Program) (string, error) {
+	ir := irBuffers.Get().(*bytes.Buffer)
+	defer putIRBuffer(ir)
+	if err := c.GenerateTo(ir, program); err != nil {
+		return "", err
+	}
+	return ir.String(), nil
+}
+
+// irBuffers and outputs hold the buffers of finished generations, for
+// the next to write into rather than grow and allocate their own, which
+// matters to servers and batch jobs generating thousands of modules.
+var (
+	irBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	outputs   = sync.Pool{New: func() any { return bufio.NewWriter(nil) }}
+)
+
+// maxPooledIR is the largest buffer kept for reuse; one that held a
+// module larger than that is left to the garbage collector rather than
+// pinned for modules of any size.
+const maxPooledIR = 16 << 20
+
+func putIRBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledIR {
+		return
+	}
+	buf.Reset()
+	irBuffers.Put(buf)
+}
+
+// GenerateTo lowers program and writes the module's textual IR to w as
+// each function is finished, so only one function's instructions are
+// held in memory at a time. Output written before an error is incomplete.
+func (c *CodeGen) GenerateTo(w io.Writer, program *ast.Program) (err error) {
+	defer diag.Recover("codegen", &err)
+	if err := c.start(w); err != nil {
+		return err
+	}
+	defer c.release()
+
+	// Collect signatures so calls can reference functions defined later
+	for _, fn := range program.Functions {
+		if err := c.addSignature(fn); err != nil {
+			return err
+		}
+	}
+
+	c.addModuleMetadata()
+
+	// Generate each function
+	log := logging.Or(c.opts.Logger)
+	log.Debug("generating IR", "target", c.target.Triple, "functions", len(program.Functions), "opt", c.opts.OptLevel)
+	for _, fn := range program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		if err := Canceled(c.opts); err != nil {
+			return err
+		}
+		start := time.Now()
+		if err := c.generateFunction(fn); err != nil {
+			return err
+		}
+		log.Log(context.Background(), logging.LevelTrace, "generated function", "function", fn.Name, "elapsed", time.Since(start))
+	}
+	return c.finish()
+}
+
+// start checks the target and options and writes the header of the module
+// to w.
+func (c *CodeGen) start(w io.Writer) error {
+	target, err := LookupTarget(c.opts.Target)
+	if err != nil {
+		return &BackendError{err}
+	}
+	if err := CheckTargetOptions(target, c.opts); err != nil {
+		return &BackendError{err}
+	}
+	c.target = target
+	c.output = outputs.Get().(*bufio.Writer)
+	c.output.Reset(w)
+
+	// Header
+	c.output.WriteString("; Generated by citadel\n")
+	c.output.WriteString(fmt.Sprintf("target datalayout = \"%s\"\n", c.target.DataLayout))
+	c.output.WriteString(fmt.Sprintf("target triple = \"%s\"\n\n", c.target.Triple))
+	return nil
+}
+
+// release returns the output buffer to outputs once the module is written
+// or abandoned.
+func (c *CodeGen) release() {
+	if c.output == nil {
+		return
+	}
+	c.output.Reset(nil)
+	outputs.Put(c.output)
+	c.output = nil
+}
+
+// addSignature records the signature of fn for calls to reference,
+// checking it against any earlier declaration.
+func (c *CodeGen) addSignature(fn *ast.Function) error {
+	if err := CheckSymbolAttributes(fn); err != nil {
+		return c.errorAt(fn, fn.Pos, err)
+	}
+	if prev, ok := c.functions[fn.Name]; ok {
+		if !prev.Signature().Equal(fn.Signature()) {
+			return c.errorAt(fn, fn.Pos, fmt.Errorf("%w for %s: %s", ErrConflictingTypes, fn.Name, fn.Signature()), c.noteAt(prev, "previous declaration is here, with type %s", prev.Signature()))
+		}
+		if prev.Body != nil && fn.Body != nil {
+			return c.errorAt(fn, fn.Pos, fmt.Errorf("%w of %s", ErrRedefinition, fn.Name), c.noteAt(prev, "previous definition is here"))
+		}
+		if fn.Body == nil {
+			return nil
+		}
+	} else {
+		c.functionNames = append(c.functionNames, fn.Name)
+	}
+	c.functions[fn.Name] = fn
+	return nil
+}
+
+// finish writes what follows the functions: the declarations, globals,
+// attribute groups and metadata they need.
+func (c *CodeGen) finish() error {
+	// Prototypes without a definition become external declarations
+	for _, name := range c.functionNames {
+		if fn := c.functions[name]; fn.Body == nil {
+			c.declare(fn.Name, fmt.Sprintf("declare %s%s %s(%s)", c.symbolKeywords(fn), c.llvmType(fn.ReturnType), c.symbol(fn), c.paramTypeList(fn)))
+		}
+	}
+
+	for _, global := range c.globals {
+		c.output.WriteString(global + "\n")
+	}
+	if len(c.globals) > 0 {
+		c.output.WriteString("\n")
+	}
+
+	for _, decl := range c.declarations {
+		c.output.WriteString(decl + "\n")
+	}
+	if len(c.declarations) > 0 {
+		c.output.WriteString("\n")
+	}
+
+	c.writeAttributeGroups()
+	c.writeMetadata()
+
+	if err := c.output.Flush(); err != nil {
+		return &BackendError{err}
+	}
+	return nil
+}
+
+func (c *CodeGen) generateFunction(fn *ast.Function) error {
+	// Function signature
+	params := []string{}
+	for _, param := range fn.Params {
+		params = append(params, fmt.Sprintf("%s %%%s", c.llvmType(param.Type), param.Name))
+	}
+
+	group := c.attributeGroup(c.functionAttributes(fn))
+	typeMD := ""
+	if c.opts.CFI {
+		typeMD = fmt.Sprintf(" !type !%d", c.addMetadata(fmt.Sprintf("!{i64 0, !\"%s\"}", typeID(fn.Signature()))))
+	}
+	header := fmt.Sprintf("define %s%s %s(%s)%s%s%s", c.symbolKeywords(fn), c.llvmType(fn.ReturnType), c.symbol(fn), strings.Join(params, ", "), group, c.sectionSuffix(fn), typeMD)
+
+	// Reset counters and locals for this function
+	c.regCounter = 1
+	c.variables = make(map[string]int)
+	c.varTypes = make(map[string]*ast.Type)
+	c.exprTypes = nil
+	c.trapLabels = nil
+	c.breakLabels = nil
+	c.loopDepth = 0
+	c.cur = nil
+	c.blocks = nil
+	c.function = fn
+	c.regNames = make(map[string]string)
+	c.note = ""
+	c.notedLine = 0
+	c.startBlock(strconv.Itoa(c.nextNamedLabel("entry")))
+
+	// Entry block - allocate space for return
+	returnReg := c.nextNamedReg("retval")
+	c.emit("%%%d = alloca %s, align %d", returnReg, c.llvmType(fn.ReturnType), c.target.AlignOf(fn.ReturnType))
+
+	// Allocate space for parameters and store incoming args
+	for _, param := range fn.Params {
+		reg := c.nextNamedReg(param.Name + ".addr")
+		c.variables[param.Name] = reg
+		c.varTypes[param.Name] = param.Type
+		c.emit("%%%d = alloca %s, align %d", reg, c.llvmType(param.Type), c.target.AlignOf(param.Type))
+	}
+
+	for _, param := range fn.Params {
+		t := c.llvmType(param.Type)
+		c.emit("store %s %%%s, %s* %%%d, align %d", t, param.Name, t, c.variables[param.Name], c.target.AlignOf(param.Type))
+	}
+
+	// Generate body statements
+	if err := c.generateBlock(fn.Body, returnReg); err != nil {
+		return err
+	}
+
+	// Falling off the end returns whatever is in the return slot
+	if c.cur.terminator() == "" {
+		loadReg := c.nextReg()
+		c.emit("%%%d = load %s, %s* %%%d, align %d", loadReg, c.llvmType(fn.ReturnType), c.llvmType(fn.ReturnType), returnReg, c.target.AlignOf(fn.ReturnType))
+		c.emit("ret %s %%%d", c.llvmType(fn.ReturnType), loadReg)
+	}
+
+	c.generateTrapBlocks()
+	if err := c.finishBlocks(); err != nil {
+		return err
+	}
+	if err := c.verifyFunction(fn); err != nil {
+		return err
+	}
+
+	est := c.estimateStack()
+	c.stackEstimates = append(c.stackEstimates, est)
+	if c.opts.StackUsage {
+		c.output.WriteString(stackComment(est) + "\n")
+		header += fmt.Sprintf(" !citadel.stack !%d", c.addMetadata(fmt.Sprintf("!{i64 %d}", est.Total)))
+	}
+	c.output.WriteString(header + " {\n")
+	c.writeBlocks()
+
+	c.output.WriteString("}\n\n")
+	return nil
+}
+
+func (c *CodeGen) generateBlock(block *ast.Block, returnReg int) error {
+	return c.generateStatements(block.Statements, returnReg)
+}
+
+func (c *CodeGen) generateStatements(stmts []ast.Statement, returnReg int) error {
+	for _, stmt := range stmts {
+		c.noteStatement(stmt)
+		if err := c.generateStatement(stmt, returnReg); err != nil {
+			return c.errorAt(c.function, stmt.Position(), err)
+		}
+	}
+	return nil
+}
+
+// errorAt returns err as a parser.Error at pos in the file of fn, with
+// notes, unless it is one already or pos is unknown.
+func (c *CodeGen) errorAt(fn *ast.Function, pos lexer.Position, err error, notes ...parser.Note) error {
+	return c.errorSpan(fn, pos, pos, err, notes...)
+}
+
+// errorSpan is errorAt for an error about the text from pos to end.
+func (c *CodeGen) errorSpan(fn *ast.Function, pos, end lexer.Position, err error, notes ...parser.Note) error {
+	if _, ok := err.(*parser.Error); ok || pos.Line == 0 {
+		return err
+	}
+	return &parser.Error{File: c.fileOf(fn), Pos: pos, End: end, Msg: err.Error(), Notes: notes, Err: &Error{Function: fn.Name, Pos: pos, Err: err}}
+}
+
+// noteAt returns a note on the declaration of fn.
+func (c *CodeGen) noteAt(fn *ast.Function, format string, args ...interface{}) parser.Note {
+	return parser.Note{File: c.fileOf(fn), Pos: fn.Pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// fileOf returns the name of the source file fn is in.
+func (c *CodeGen) fileOf(fn *ast.Function) string {
+	if fn.File != "" {
+		return fn.File
+	}
+	return c.opts.SourceFile
+}
+
+func (c *CodeGen) generateStatement(stmt ast.Statement, returnReg int) error {
+	switch s := stmt.(type) {
+	case *ast.VarDecl:
+		return c.generateVarDecl(s)
+	case *ast.IfStatement:
+		return c.generateIfStatement(s, returnReg)
+	case *ast.WhileStatement:
+		return c.generateWhileStatement(s, returnReg)
+	case *ast.SwitchStatement:
+		return c.generateSwitch(s, returnReg)
+	case *ast.BreakStatement:
+		return c.generateBreak()
+	case *ast.ReturnStatement:
+		return c.generateReturnStatement(s, returnReg)
+	case *ast.ExprStatement:
+		// The result of a call may be discarded, even one of a void function
+		if call, ok := s.Expr.(*ast.CallExpr); ok {
+			_, err := c.generateCall(call)
+			return err
+		}
+		_, err := c.generateExpression(s.Expr)
+		return err
+	case *ast.AsmStatement:
+		return c.generateAsm(s)
+	default:
+		return fmt.Errorf("unknown statement type")
+	}
+}
+
+func (c *CodeGen) generateVarDecl(decl *ast.VarDecl) error {
+	// Allocate space
+	reg := c.nextNamedReg(decl.Name)
+	t := c.llvmType(decl.Type)
+	c.variables[decl.Name] = reg
+	c.varTypes[decl.Name] = decl.Type
+	c.exprTypes = nil
+	alloca := fmt.Sprintf("%%%d = alloca %s, align %d", reg, t, c.target.AlignOf(decl.Type))
+	if c.loopDepth > 0 {
+		// An alloca in a loop would take more stack on every iteration,
+		// so the slot goes with the others at the start of the entry
+		// block
+		entry := c.blocks[0]
+		i := 0
+		for i < len(entry.instrs) && strings.Contains(entry.instrs[i], " = alloca ") {
+			i++
+		}
+		entry.instrs = append(entry.instrs[:i], append([]string{alloca}, entry.instrs[i:]...)...)
+	} else {
+		c.emit("%s", alloca)
+	}
+
+	// Store initial value if provided
+	if decl.Value != nil && decl.Type.Kind == ast.ArrayType {
+		return fmt.Errorf("array initializers are not supported: %s", decl.Name)
+	}
+	if decl.Value != nil {
+		value, err := c.generateExpressionAs(decl.Value, decl.Type)
+		if err != nil {
+			return err
+		}
+
+		c.emit("store %s %s, %s* %%%d, align %d", t, value, t, reg, c.target.AlignOf(decl.Type))
+	}
+
+	return nil
+}
+
+func (c *CodeGen) generateIfStatement(stmt *ast.IfStatement, returnReg int) error {
+	// Generate condition
+	cond, err := c.generateCondition(stmt.Condition)
+	if err != nil {
+		return err
+	}
+
+	thenLabel := c.nextNamedLabel("if.then")
+	elseLabel := c.nextNamedLabel("if.end")
+
+	// A constant condition needs no conditional branch
+	switch cond {
+	case "true":
+		c.emit("br label %%%d", thenLabel)
+	case "false":
+		c.emit("br label %%%d", elseLabel)
+	default:
+		c.emit("br i1 %s, label %%%d, label %%%d", cond, thenLabel, elseLabel)
+	}
+
+	// Then block
+	c.startBlock(strconv.Itoa(thenLabel))
+	if err := c.generateBlock(stmt.ThenBlock, returnReg); err != nil {
+		return err
+	}
+
+	// Else block (or empty)
+	c.startBlock(strconv.Itoa(elseLabel))
+
+	return nil
+}
+
+// generateWhileStatement tests the condition in a block of its own, which
+// the end of the body branches back to, and break leaves the loop.
+func (c *CodeGen) generateWhileStatement(stmt *ast.WhileStatement, returnReg int) error {
+	condLabel := c.nextNamedLabel("while.cond")
+	c.startBlock(strconv.Itoa(condLabel))
+	cond, err := c.generateCondition(stmt.Condition)
+	if err != nil {
+		return err
+	}
+
+	bodyLabel := c.nextNamedLabel("while.body")
+	endLabel := c.nextNamedLabel("while.end")
+	switch cond {
+	case "true":
+		c.emit("br label %%%d", bodyLabel)
+	case "false":
+		c.emit("br label %%%d", endLabel)
+	default:
+		c.emit("br i1 %s, label %%%d, label %%%d", cond, bodyLabel, endLabel)
+	}
+
+	c.startBlock(strconv.Itoa(bodyLabel))
+	c.breakLabels = append(c.breakLabels, endLabel)
+	c.loopDepth++
+	err = c.generateBlock(stmt.Body, returnReg)
+	c.loopDepth--
+	c.breakLabels = c.breakLabels[:len(c.breakLabels)-1]
+	if err != nil {
+		return err
+	}
+	if c.cur.terminator() == "" {
+		c.emit("br label %%%d", condLabel)
+	}
+
+	c.startBlock(strconv.Itoa(endLabel))
+	return nil
+}
+
+func (c *CodeGen) generateReturnStatement(stmt *ast.ReturnStatement, returnReg int) error {
+	// Evaluate return value, converted to the return type
+	retType := c.function.ReturnType
+	value, err := c.generateExpressionAs(stmt.Value, retType)
+	if err != nil {
+		return err
+	}
+	t, align := c.llvmType(retType), c.target.AlignOf(retType)
+	c.emit("store %s %s, %s* %%%d, align %d", t, value, t, returnReg, align)
+
+	// Jump to final return block
+	finalLabel := c.nextNamedLabel("return")
+	c.emit("br label %%%d", finalLabel)
+
+	// Final return block
+	c.startBlock(strconv.Itoa(finalLabel))
+	loadReg := c.nextReg()
+	c.emit("%%%d = load %s, %s* %%%d, align %d", loadReg, t, t, returnReg, align)
+	c.emit("ret %s %%%d", t, loadReg)
+
+	return nil
+}
+
+// generateExpression emits code for expr and returns the operand holding
+// its value (a register such as %5, or a global such as @f).
+func (c *CodeGen) generateExpression(expr ast.Expression) (string, error) {
+	switch e := expr.(type) {
+	case *ast.IntLiteral:
+		// Literals are used directly as constant operands
+		return strconv.Itoa(e.Value), nil
+	case *ast.StringLiteral:
+		return c.generateStringLiteral(e)
+	case *ast.Identifier:
+		// Load variable
+		varReg := c.variables[e.Name]
+		if varReg == 0 {
+			// A function name decays to a pointer to the function
+			if fn, ok := c.functions[e.Name]; ok {
+				return c.symbol(fn), nil
+			}
+			return "", c.undefined(ErrUndefinedVariable, e)
+		}
+		t := c.varTypes[e.Name]
+		if t.Kind == ast.ArrayType {
+			return c.generateElementAddress(fmt.Sprintf("%%%d", varReg), t, "0", "arraydecay")
+		}
+		loadReg := c.nextNamedReg(e.Name + ".")
+		c.emit("%%%d = load %s, %s* %%%d, align %d", loadReg, c.llvmType(t), c.llvmType(t), varReg, c.target.AlignOf(t))
+		return fmt.Sprintf("%%%d", loadReg), nil
+	case *ast.BinaryOp:
+		if isBoolean(e) {
+			cond, err := c.generateCondition(e)
+			if err != nil {
+				return "", err
+			}
+			return c.boolToInt(cond, e.Operator), nil
+		}
+		return c.generateBinaryOp(e)
+	case *ast.UnaryOp:
+		return c.generateUnaryOp(e)
+	case *ast.CallExpr:
+		value, err := c.generateCall(e)
+		if err == nil && value == "" {
+			return "", fmt.Errorf("void value of call to %s used", e.Callee)
+		}
+		return value, err
+	case *ast.IndexExpr:
+		return c.generateIndex(e)
+	case *ast.Assignment:
+		return c.generateAssignment(e)
+	default:
+		return "", fmt.Errorf("unknown expression type")
+	}
+}
+
+func (c *CodeGen) generateUnaryOp(op *ast.UnaryOp) (string, error) {
+	t, err := c.typeOf(op.Operand)
+	if err != nil {
+		return "", err
+	}
+
+	switch op.Operator {
+	case "*":
+		// Dereferencing a function pointer yields the function, which
+		// immediately decays back to the same pointer
+		if !t.IsFuncPointer() {
+			return "", fmt.Errorf("unsupported dereference of %s", t)
+		}
+		return c.generateExpression(op.Operand)
+	case "-":
+		if !t.IsArithmetic() {
+			return "", fmt.Errorf("invalid argument type %s to unary -", t)
+		}
+		t = t.Promote()
+		operand, err := c.generateExpressionAs(op.Operand, t)
+		if err != nil {
+			return "", err
+		}
+		typ := c.llvmType(t)
+		if t.IsFloating() {
+			resultReg := c.nextNamedReg("fneg")
+			c.emit("%%%d = fneg %s %s", resultReg, typ, operand)
+			return fmt.Sprintf("%%%d", resultReg), nil
+		}
+		if folded, ok := c.foldBinary(typ, "-", "0", operand); ok {
+			return folded, nil
+		}
+		if c.opts.OverflowChecks {
+			return c.generateCheckedArithmetic("ssub", typ, "0", operand), nil
+		}
+		resultReg := c.nextNamedReg("sub")
+		c.emit("%%%d = sub %s 0, %s", resultReg, typ, operand)
+		return fmt.Sprintf("%%%d", resultReg), nil
+	default:
+		return "", fmt.Errorf("unsupported operator: %s", op.Operator)
+	}
+}
+
+// binaryOpNames are the value names given to the results of binary
+// operators, following clang.
+var binaryOpNames = map[string]string{
+	"==": "cmp", ">": "cmp", "<": "cmp",
+	"+": "add", "-": "sub", "*": "mul", "/": "div", "%": "rem",
+}
+
+// integerOps and floatOps are the instructions binary operators lower to
+// on integer and floating operands.
+var (
+	integerOps = map[string]string{
+		"==": "icmp eq", ">": "icmp sgt", "<": "icmp slt",
+		"+": "add", "-": "sub", "*": "mul", "/": "sdiv", "%": "srem",
+	}
+	floatOps = map[string]string{
+		"==": "fcmp oeq", ">": "fcmp ogt", "<": "fcmp olt",
+		"+": "fadd", "-": "fsub", "*": "fmul", "/": "fdiv", "%": "frem",
+	}
+)
+
+// nullComparison returns the pointer operand of op if op compares a
+// pointer with the null pointer constant 0, as in p == 0, or else nil.
+func nullComparison(op *ast.BinaryOp, typeOf func(ast.Expression) (*ast.Type, error)) ast.Expression {
+	if op.Operator != "==" {
+		return nil
+	}
+	ptr, null := op.Left, op.Right
+	if isNullConstant(ptr) {
+		ptr, null = null, ptr
+	}
+	if !isNullConstant(null) {
+		return nil
+	}
+	if t, err := typeOf(ptr); err != nil || t.Kind != ast.PointerType {
+		return nil
+	}
+	return ptr
+}
+
+// isNullConstant reports whether expr is the literal 0, which C lets stand
+// for the null pointer.
+func isNullConstant(expr ast.Expression) bool {
+	lit, ok := expr.(*ast.IntLiteral)
+	return ok && lit.Value == 0
+}
+
+// generateNullComparison emits ptr == 0 as a comparison with null.
+func (c *CodeGen) generateNullComparison(ptr ast.Expression) (string, error) {
+	t, err := c.typeOf(ptr)
+	if err != nil {
+		return "", err
+	}
+	value, err := c.generateExpression(ptr)
+	if err != nil {
+		return "", err
+	}
+	resultReg := c.nextNamedReg("cmp")
+	c.emit("%%%d = icmp eq %s %s, null", resultReg, c.llvmType(t), value)
+	return fmt.Sprintf("%%%d", resultReg), nil
+}
+
+// generateBinaryOp converts both operands to their common type and
+// applies the operator in that type.
+func (c *CodeGen) generateBinaryOp(op *ast.BinaryOp) (string, error) {
+	if op.Operator == "&&" || op.Operator == "||" {
+		return c.generateLogical(op)
+	}
+	if _, ok := integerOps[op.Operator]; !ok {
+		return "", fmt.Errorf("unsupported operator: %s", op.Operator)
+	}
+	if ptr := nullComparison(op, c.typeOf); ptr != nil {
+		return c.generateNullComparison(ptr)
+	}
+
+	t, err := c.arithmeticType(op)
+	if err != nil {
+		return "", err
+	}
+	left, err := c.generateExpressionAs(op.Left, t)
+	if err != nil {
+		return "", err
+	}
+	right, err := c.generateExpressionAs(op.Right, t)
+	if err != nil {
+		return "", err
+	}
+	typ := c.llvmType(t)
+
+	if t.IsFloating() {
+		resultReg := c.nextNamedReg(binaryOpNames[op.Operator])
+		c.emit("%%%d = %s %s %s, %s", resultReg, floatOps[op.Operator], typ, left, right)
+		return fmt.Sprintf("%%%d", resultReg), nil
+	}
+
+	if folded, ok := c.foldBinary(typ, op.Operator, left, right); ok {
+		return folded, nil
+	}
+
+	if c.opts.OverflowChecks {
+		if intrinsic, ok := overflowIntrinsics[op.Operator]; ok {
+			return c.generateCheckedArithmetic(intrinsic, typ, left, right), nil
+		}
+	}
+
+	if c.opts.DivisionChecks && (op.Operator == "/" || op.Operator == "%") {
+		c.generateDivisionCheck(typ, left, right, c.opts.ProvenDivisions[op])
+	}
+
+	resultReg := c.nextNamedReg(binaryOpNames[op.Operator])
+	c.emit("%%%d = %s %s %s, %s", resultReg, integerOps[op.Operator], typ, left, right)
+	return fmt.Sprintf("%%%d", resultReg), nil
+}
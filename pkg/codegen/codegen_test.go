@@ -0,0 +1,38 @@
+package codegen_test
+
+import (
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// TestWhile checks that while loops run their body until the condition
+// fails or a break leaves them, a break inside a switch leaving the
+// switch only
+func TestWhile(t *testing.T) {
+	const src = `int main() {
+    int i = 0;
+    int sum = 0;
+    while (i < 10) {
+        int j = 0;
+        while (1) {
+            if (j > i) {
+                break;
+            }
+            j = j + 1;
+        }
+        switch (i) {
+        case 3:
+            break;
+        default:
+            sum = sum + j;
+        }
+        i = i + 1;
+    }
+    return sum;
+}`
+	// 1 + 2 + ... + 10, less the 4 of i = 3
+	if status := runProgram(t, src, codegen.Options{}); status != 51 {
+		t.Errorf("exit status %d, want 51", status)
+	}
+}
@@ -0,0 +1,45 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// isComment reports whether a block entry is a source-location comment
+// rather than an instruction.
+func isComment(instr string) bool {
+	return strings.HasPrefix(instr, ";")
+}
+
+// noteStatement queues a "; file.c:12: if (x > 0)" comment to precede the
+// first instruction generated for stmt. Statements sharing a line with
+// the previous one are not noted again.
+func (c *CodeGen) noteStatement(stmt ast.Statement) {
+	pos := stmt.Position()
+	if !c.opts.SourceComments || pos.Line == 0 || pos.Line == c.notedLine {
+		return
+	}
+	c.notedLine = pos.Line
+	file, source := c.opts.SourceFile, c.opts.Source
+	if c.function.File != "" {
+		file, source = c.function.File, c.opts.Sources[c.function.File]
+	}
+	c.note = fmt.Sprintf("; %s:%d: %s", file, pos.Line, c.sourceLine(file, source, pos.Line))
+}
+
+// sourceLine returns line n of source, the text of file, trimmed of
+// surrounding space and an opening brace.
+func (c *CodeGen) sourceLine(file, source string, n int) string {
+	lines, ok := c.sourceLines[file]
+	if !ok {
+		lines = strings.Split(source, "\n")
+		c.sourceLines[file] = lines
+	}
+	if n > len(lines) {
+		return ""
+	}
+	line := strings.TrimSpace(lines[n-1])
+	return strings.TrimSpace(strings.TrimSuffix(line, "{"))
+}
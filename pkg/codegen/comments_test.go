@@ -0,0 +1,82 @@
+package codegen_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// commentedProgram has statements on lines of their own and an if that
+// opens a block on its line
+const commentedProgram = `int f(int *p) {
+    p[0] = p[0] + 1;
+    if (p[0] == 2) {
+        return 1;
+    }
+    return 0;
+}
+int main() { int a[1]; a[0] = 1; return f(a); }`
+
+// namedValue matches a value or label named after the source
+var namedValue = regexp.MustCompile(`%[A-Za-z_][\w.]*\b|^[a-z][\w.]*:`)
+
+// TestValueNames checks that values and blocks are named after the
+// source unless DiscardValueNames numbers them, which leaves the
+// program's behavior alone
+func TestValueNames(t *testing.T) {
+	ir := generate(t, commentedProgram, codegen.Options{})
+	for _, want := range []string{
+		"%retval = alloca i32", "%p.addr = alloca i32*", "%arrayidx = getelementptr",
+		"%cmp = icmp eq i32", "br i1 %cmp, label %if.then, label %if.end", "%call = call i32 @f(",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+
+	discarded := generate(t, commentedProgram, codegen.Options{DiscardValueNames: true})
+	for _, fn := range []string{"f", "main"} {
+		body := functionIR(discarded, fn)
+		for _, line := range strings.Split(body, "\n")[1:] {
+			// Parameters keep their names in the signature and the
+			// instructions that read them
+			line = strings.ReplaceAll(line, "%p", "")
+			if m := namedValue.FindString(strings.TrimSpace(line)); m != "" {
+				t.Errorf("%s: %s is named: %s", fn, m, line)
+			}
+		}
+	}
+
+	for _, ir := range []string{ir, discarded} {
+		if status, _ := llitest.Run(t, ir); status != 1 {
+			t.Errorf("exit status %d, want 1", status)
+		}
+	}
+}
+
+// TestSourceComments checks that each statement's instructions follow a
+// comment giving its file, line and text without the opening brace, once
+// per line, and that files merged from several sources show their own
+func TestSourceComments(t *testing.T) {
+	ir := generate(t, commentedProgram, codegen.Options{SourceComments: true, SourceFile: "c.c", Source: commentedProgram})
+	for _, want := range []string{
+		"  ; c.c:2: p[0] = p[0] + 1;\n  %p.0 = load",
+		"  ; c.c:3: if (p[0] == 2)\n",
+		"  ; c.c:4: return 1;\n  store i32 1",
+		"  ; c.c:6: return 0;\n",
+		"  ; c.c:8: int main() { int a[1]; a[0] = 1; return f(a); }\n",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %q:\n%s", want, ir)
+		}
+	}
+	if n := strings.Count(ir, "; c.c:8:"); n != 1 {
+		t.Errorf("line 8 noted %d times, want once:\n%s", n, ir)
+	}
+	if plain := generate(t, commentedProgram, codegen.Options{}); strings.Contains(plain, "; c.c:") {
+		t.Errorf("comments without SourceComments:\n%s", plain)
+	}
+}
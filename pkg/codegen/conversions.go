@@ -0,0 +1,150 @@
+package codegen
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// arithmeticType returns the type the usual arithmetic conversions bring
+// the operands of an arithmetic or comparison operator to.
+func (c *CodeGen) arithmeticType(op *ast.BinaryOp) (*ast.Type, error) {
+	left, err := c.typeOf(op.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.typeOf(op.Right)
+	if err != nil {
+		return nil, err
+	}
+	t := ast.CommonType(left, right)
+	if t == nil {
+		return nil, fmt.Errorf("invalid operands to binary %s (%s and %s)", op.Operator, left, right)
+	}
+	return t, nil
+}
+
+// generateExpressionAs evaluates expr and converts the result to type to,
+// as assignment, argument passing and return do.
+func (c *CodeGen) generateExpressionAs(expr ast.Expression, to *ast.Type) (string, error) {
+	from, err := c.typeOf(expr)
+	if err != nil {
+		return "", err
+	}
+	value, err := c.generateExpression(expr)
+	if err != nil {
+		return "", err
+	}
+	return c.convert(value, from, to)
+}
+
+// convert emits the implicit conversion of value from one type to
+// another: sext or trunc between integers, sitofp and fptosi between
+// integers and floating types, with zext, uitofp and fptoui for plain
+// char where the target has it unsigned, and fpext or fptrunc between
+// float and double. Constants are converted at compile time. Pointers only convert
+// to the same pointer type, except that void* and data pointers convert
+// to each other and the constant 0 is a null pointer; mixing pointers and
+// arithmetic values is an error rather than IR with mismatched types.
+func (c *CodeGen) convert(value string, from, to *ast.Type) (string, error) {
+	if from.Equal(to) {
+		return value, nil
+	}
+	if to.Kind == ast.PointerType {
+		if voidPointerConversion(from, to) {
+			if c.llvmType(from) == c.llvmType(to) || value == "null" {
+				return value, nil
+			}
+			reg := c.nextReg()
+			c.emit("%%%d = bitcast %s %s to %s", reg, c.llvmType(from), value, c.llvmType(to))
+			return fmt.Sprintf("%%%d", reg), nil
+		}
+		if from.Kind == ast.PointerType {
+			return "", fmt.Errorf("incompatible pointer types converting %s to %s", from, to)
+		}
+		if v, ok := constantValue(value); ok && v == 0 && from.IsInteger() {
+			return "null", nil
+		}
+		return "", fmt.Errorf("incompatible integer to pointer conversion from %s to %s", from, to)
+	}
+	if from.Kind == ast.PointerType {
+		return "", fmt.Errorf("incompatible pointer to integer conversion from %s to %s", from, to)
+	}
+	if !from.IsArithmetic() || !to.IsArithmetic() {
+		return "", fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+
+	fromType, toType := c.llvmType(from), c.llvmType(to)
+	if fromType == toType {
+		// int and long on ILP32 targets
+		return value, nil
+	}
+	unsignedFrom := c.unsignedChar(from)
+	if v, ok := constantValue(value); ok {
+		if unsignedFrom {
+			v = int64(uint8(v))
+		}
+		return c.convertConstant(v, to), nil
+	}
+
+	var opcode string
+	fromSize, toSize := c.target.SizeOf(from), c.target.SizeOf(to)
+	switch {
+	case from.IsInteger() && to.IsInteger() && toSize > fromSize && unsignedFrom:
+		opcode = "zext"
+	case from.IsInteger() && to.IsInteger() && toSize > fromSize:
+		opcode = "sext"
+	case from.IsInteger() && to.IsInteger():
+		opcode = "trunc"
+	case from.IsInteger() && unsignedFrom:
+		opcode = "uitofp"
+	case from.IsInteger():
+		opcode = "sitofp"
+	case c.unsignedChar(to):
+		opcode = "fptoui"
+	case to.IsInteger():
+		opcode = "fptosi"
+	case toSize > fromSize:
+		opcode = "fpext"
+	default:
+		opcode = "fptrunc"
+	}
+	reg := c.nextNamedReg("conv")
+	c.emit("%%%d = %s %s %s to %s", reg, opcode, fromType, value, toType)
+	return fmt.Sprintf("%%%d", reg), nil
+}
+
+// unsignedChar reports whether t is plain char on a target that has it
+// unsigned.
+func (c *CodeGen) unsignedChar(t *ast.Type) bool {
+	return c.target.UnsignedChar && t.Kind == ast.BasicType && t.Name == "char"
+}
+
+// voidPointerConversion reports whether converting from to to is between
+// void* and a pointer to data, which C allows without a cast.
+func voidPointerConversion(from, to *ast.Type) bool {
+	if from.Kind != ast.PointerType || to.Kind != ast.PointerType || from.IsFuncPointer() || to.IsFuncPointer() {
+		return false
+	}
+	return from.IsVoidPointer() || to.IsVoidPointer()
+}
+
+// convertConstant returns the operand spelling integer constant v as a
+// value of type to: wrapped to the width of an integer type, or as the
+// hexadecimal double LLVM expects for floating constants.
+func (c *CodeGen) convertConstant(v int64, to *ast.Type) string {
+	switch to.Name {
+	case "float":
+		return fmt.Sprintf("0x%016X", math.Float64bits(float64(float32(v))))
+	case "double":
+		return fmt.Sprintf("0x%016X", math.Float64bits(float64(v)))
+	}
+	return fmt.Sprint(wrap(v, c.target.SizeOf(to)*8))
+}
+
+// sizeType returns the signed integer type as wide as size_t, which is
+// long on every supported target.
+func (c *CodeGen) sizeType() *ast.Type {
+	return ast.Long
+}
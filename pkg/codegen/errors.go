@@ -0,0 +1,88 @@
+package codegen
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/internal/suggest"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// Errors that an Error may wrap, for callers to tell the common semantic
+// errors apart with errors.Is.
+var (
+	ErrUndefinedVariable = errors.New("undefined variable")
+	ErrUndefinedFunction = errors.New("undefined function")
+	ErrConflictingTypes  = errors.New("conflicting types")
+	ErrRedefinition      = errors.New("redefinition")
+)
+
+// Error is a semantic error in a function of the program, such as an
+// undefined variable or a conflicting declaration. Pos is the position of
+// the identifier it is about, if any, or else of the statement or
+// declaration it is in, and Err says what is wrong with it. Generate returns it as the Err of a parser.Error, which adds the
+// file and notes on related declarations.
+type Error struct {
+	Function string
+	Pos      lexer.Position
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// BackendError is an error in lowering a program that is no fault of the
+// program: an option the target or backend cannot honor, IR the verifier
+// rejects, or a failure writing the module. The other errors of Generate
+// are semantic errors in the program.
+type BackendError struct {
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// IsBackendError reports whether err is a BackendError, or a panic the
+// code generator recovered from, rather than an error in the program.
+func IsBackendError(err error) bool {
+	var backend *BackendError
+	var internal *diag.InternalError
+	return errors.As(err, &backend) || errors.As(err, &internal)
+}
+
+// undefined returns err, ErrUndefinedVariable or ErrUndefinedFunction, for
+// the identifier id, at id and suggesting the variable or function in
+// scope it is likeliest a misspelling of.
+func (c *CodeGen) undefined(err error, id *ast.Identifier) error {
+	name := id.Name
+	names := make([]string, 0, len(c.varTypes)+len(c.functions))
+	for n := range c.varTypes {
+		names = append(names, n)
+	}
+	for n := range c.functions {
+		names = append(names, n)
+	}
+	if s := suggest.Closest(name, names); s != "" {
+		err = fmt.Errorf("%w: %s; did you mean %s?", err, name, s)
+	} else {
+		err = fmt.Errorf("%w: %s", err, name)
+	}
+	if c.function == nil {
+		return err
+	}
+	end := id.Pos
+	end.Column += len(name)
+	return c.errorSpan(c.function, id.Pos, end, err)
+}
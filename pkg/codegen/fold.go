@@ -0,0 +1,232 @@
+package codegen
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// constantValue returns the value of an operand that is an integer
+// constant rather than a register or global.
+func constantValue(operand string) (int64, bool) {
+	v, err := strconv.ParseInt(operand, 10, 64)
+	return v, err == nil
+}
+
+// foldBinary evaluates op on two constants of integer type typ and
+// returns the resulting constant operand. Operations whose result is
+// undefined (division by zero, INT_MIN / -1) and, when overflow is
+// trapped, operations that overflow are left for the runtime to handle.
+func (c *CodeGen) foldBinary(typ, op string, left, right string) (string, bool) {
+	l, lok := constantValue(left)
+	r, rok := constantValue(right)
+	if !lok || !rok || c.opts.OptLevel < 1 {
+		return "", false
+	}
+	bits := typeBits(typ)
+	// 64-bit overflow cannot be seen in an int64 result
+	if bits >= 64 && c.opts.OverflowChecks && overflowIntrinsics[op] != "" {
+		return "", false
+	}
+	c.record("constfold", "instructions folded", 1)
+
+	var result int64
+	switch op {
+	case "+":
+		result = l + r
+	case "-":
+		result = l - r
+	case "*":
+		result = l * r
+	case "/", "%":
+		if r == 0 || (l == minInt(bits) && r == -1) {
+			return "", false
+		}
+		if op == "/" {
+			result = l / r
+		} else {
+			result = l % r
+		}
+	case "==":
+		return strconv.FormatBool(l == r), true
+	case ">":
+		return strconv.FormatBool(l > r), true
+	case "<":
+		return strconv.FormatBool(l < r), true
+	default:
+		return "", false
+	}
+
+	if result != wrap(result, bits) {
+		if c.opts.OverflowChecks {
+			return "", false
+		}
+		result = wrap(result, bits) // wrap like the hardware would
+	}
+	return strconv.FormatInt(result, 10), true
+}
+
+var (
+	foldableBinary = regexp.MustCompile(`^(%[0-9]+) = (add|sub|mul|sdiv|srem|and|or|xor) (i[0-9]+) (\S+), (\S+)$`)
+	foldableCmp    = regexp.MustCompile(`^(%[0-9]+) = icmp (\w+) (i[0-9]+) (\S+), (\S+)$`)
+	foldableCast   = regexp.MustCompile(`^(%[0-9]+) = (zext|sext|trunc) (i[0-9]+) (\S+) to (i[0-9]+)$`)
+	constantBranch = regexp.MustCompile(`^br i1 (true|false), label (%\S+), label (%\S+)$`)
+)
+
+// foldInstructions evaluates instructions whose operands have become
+// constant, for instance after mem2reg, and turns conditional branches on
+// constants into unconditional ones. It returns the number of
+// instructions changed.
+func (c *CodeGen) foldInstructions() int {
+	folded := 0
+	replacements := map[string]string{}
+	for _, b := range c.blocks {
+		kept := b.instrs[:0]
+		for _, instr := range b.instrs {
+			instr = substituteIn(instr, replacements)
+			if reg, value, ok := foldInstruction(instr); ok {
+				replacements[reg] = value
+				folded++
+				continue
+			}
+			if m := constantBranch.FindStringSubmatch(instr); m != nil {
+				target := m[2]
+				if m[1] == "false" {
+					target = m[3]
+				}
+				instr = "br label " + target
+				folded++
+			}
+			kept = append(kept, instr)
+		}
+		b.instrs = kept
+	}
+	c.substitute(replacements)
+	return folded
+}
+
+// foldInstruction evaluates a binary operation, comparison or cast on
+// constant operands, returning the defined register and its value.
+func foldInstruction(instr string) (string, string, bool) {
+	if m := foldableBinary.FindStringSubmatch(instr); m != nil {
+		bits := typeBits(m[3])
+		l, lok := irConstant(m[4], bits)
+		r, rok := irConstant(m[5], bits)
+		if !lok || !rok {
+			return "", "", false
+		}
+		var result int64
+		switch m[2] {
+		case "add":
+			result = l + r
+		case "sub":
+			result = l - r
+		case "mul":
+			result = l * r
+		case "and":
+			result = l & r
+		case "or":
+			result = l | r
+		case "xor":
+			result = l ^ r
+		case "sdiv", "srem":
+			if r == 0 || (r == -1 && l == int64(-1)<<uint(bits-1)) {
+				return "", "", false
+			}
+			if m[2] == "sdiv" {
+				result = l / r
+			} else {
+				result = l % r
+			}
+		}
+		return m[1], formatConstant(wrap(result, bits), bits), true
+	}
+
+	if m := foldableCmp.FindStringSubmatch(instr); m != nil {
+		bits := typeBits(m[3])
+		l, lok := irConstant(m[4], bits)
+		r, rok := irConstant(m[5], bits)
+		if !lok || !rok {
+			return "", "", false
+		}
+		mask := uint64(1)<<uint(bits) - 1
+		ul, ur := uint64(l)&mask, uint64(r)&mask
+		var result bool
+		switch m[2] {
+		case "eq":
+			result = l == r
+		case "ne":
+			result = l != r
+		case "sgt":
+			result = l > r
+		case "sge":
+			result = l >= r
+		case "slt":
+			result = l < r
+		case "sle":
+			result = l <= r
+		case "ugt":
+			result = ul > ur
+		case "uge":
+			result = ul >= ur
+		case "ult":
+			result = ul < ur
+		case "ule":
+			result = ul <= ur
+		default:
+			return "", "", false
+		}
+		return m[1], strconv.FormatBool(result), true
+	}
+
+	if m := foldableCast.FindStringSubmatch(instr); m != nil {
+		from, to := typeBits(m[3]), typeBits(m[5])
+		v, ok := irConstant(m[4], from)
+		if !ok {
+			return "", "", false
+		}
+		if m[2] == "zext" {
+			v = int64(uint64(v) & (uint64(1)<<uint(from) - 1))
+		}
+		return m[1], formatConstant(wrap(v, to), to), true
+	}
+	return "", "", false
+}
+
+func typeBits(typ string) int {
+	bits, _ := strconv.Atoi(typ[1:])
+	return bits
+}
+
+// irConstant parses a constant operand of an integer type of the given
+// width, sign-extended to int64.
+func irConstant(operand string, bits int) (int64, bool) {
+	switch operand {
+	case "true":
+		return wrap(1, bits), bits == 1
+	case "false":
+		return 0, bits == 1
+	}
+	v, ok := constantValue(operand)
+	return wrap(v, bits), ok
+}
+
+// wrap truncates v to the given width and sign-extends it back.
+func wrap(v int64, bits int) int64 {
+	if bits >= 64 {
+		return v
+	}
+	shift := uint(64 - bits)
+	return v << shift >> shift
+}
+
+// minInt returns the smallest value of a signed integer of bits bits.
+func minInt(bits int) int64 {
+	return -(int64(1) << (bits - 1))
+}
+
+func formatConstant(v int64, bits int) string {
+	if bits == 1 {
+		return strconv.FormatBool(v != 0)
+	}
+	return strconv.FormatInt(v, 10)
+}
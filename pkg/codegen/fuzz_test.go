@@ -0,0 +1,56 @@
+package codegen_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/gobackend"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llirgen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// FuzzCompile checks that the whole pipeline, from parsing to the IR of
+// both backends at each optimization level, the Go translation and the
+// analysis, returns an error rather than panicking on any input, and that
+// no step recovered from a panic to return one
+func FuzzCompile(f *testing.F) {
+	for _, seed := range []string{
+		"int main() { return 0; }",
+		"int puts(char *s); int main() { char buf[4]; buf[5] = 1; puts(buf); return 1 / 0; }",
+		"int f(int a) { if (a < 1) { return f(a - 1); } switch (a) { case 1: break; default: return 2; } return a = a * 2; }",
+		"int g(int (*cb)(int), int x) { return cb(x) + -x % 3; }",
+		"int f() { int x; return x; }",
+		"int f() { return " + strings.Repeat("-", parser.MaxNesting-2) + "1; }",
+		"int helper(int x); int f(int n) { return helper(n) + f(n - 1); }",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		noPanic := func(err error) {
+			var internal *diag.InternalError
+			if errors.As(err, &internal) {
+				t.Fatalf("%v\n%s", err, internal.Stack)
+			}
+		}
+		program, err := parser.New(lexer.New(input)).ParseProgram()
+		noPanic(err)
+		if err != nil {
+			return
+		}
+		for _, level := range []int{0, 1} {
+			_, err := codegen.NewWithOptions(codegen.Options{OptLevel: level}).Generate(program)
+			noPanic(err)
+		}
+		_, err = llirgen.New(codegen.Options{}).Generate(program)
+		noPanic(err)
+		_, err = gobackend.New(gobackend.Options{}).Generate(program)
+		noPanic(err)
+		_, err = analysis.Analyze(program, nil)
+		noPanic(err)
+	})
+}
@@ -0,0 +1,460 @@
+package gobackend
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// value is the translation of an expression.
+type value struct {
+	text string
+	// typ is the C type of the value, decayed; nil for a call of a
+	// function returning nothing
+	typ *ast.Type
+	// cond says text is a Go bool, for a comparison or logical operator,
+	// whose C value is the int 1 or 0
+	cond bool
+	// constant says the value is the integer constant val, which text
+	// spells as an untyped Go constant
+	constant bool
+	val      int
+	// prec is the Go precedence of the operator outermost in text, 0 for
+	// an operand
+	prec int
+}
+
+// precedences are the Go precedences of the binary operators of the
+// subset, which order them as C does.
+var precedences = map[string]int{
+	"||": 1, "&&": 2, "==": 3, "<": 3, ">": 3, "+": 4, "-": 4, "*": 5, "/": 5, "%": 5,
+}
+
+// unaryPrec is the precedence of a unary operator, above those of the
+// binary ones.
+const unaryPrec = 6
+
+// paren returns the text of v, parenthesized if its operator binds less
+// tightly than prec.
+func paren(v value, prec int) string {
+	if v.prec != 0 && v.prec < prec {
+		return "(" + v.text + ")"
+	}
+	return v.text
+}
+
+// value returns the text of v as a C value, an int in place of a bool.
+func (g *Generator) value(v value) string {
+	if v.cond {
+		g.used["citadelBool"] = true
+		return "citadelBool(" + v.text + ")"
+	}
+	return v.text
+}
+
+// cond returns the text of v as a Go bool, true where the C value is
+// nonzero.
+func (g *Generator) cond(v value) string {
+	switch {
+	case v.cond:
+		return v.text
+	case v.constant:
+		return strconv.FormatBool(v.val != 0)
+	case v.typ != nil && v.typ.Kind == ast.PointerType:
+		return paren(v, 3) + " != nil"
+	}
+	return paren(v, 3) + " != 0"
+}
+
+// convert returns the text of v converted to type to as an assignment
+// converts it in C.
+func (g *Generator) convert(v value, to *ast.Type) (string, error) {
+	if v.typ == nil {
+		return "", errors.New("void value not ignored as it ought to be")
+	}
+	if to.Kind != ast.BasicType {
+		if v.constant && v.val == 0 {
+			return "nil", nil
+		}
+		if v.typ.Kind == ast.BasicType || goType(v.typ) != goType(to) {
+			return "", fmt.Errorf("incompatible conversion from %s to %s", v.typ, to)
+		}
+		return v.text, nil
+	}
+	if v.typ.Kind != ast.BasicType {
+		return "", fmt.Errorf("incompatible conversion from %s to %s", v.typ, to)
+	}
+	if v.constant {
+		if to.IsInteger() {
+			return strconv.Itoa(truncate(v.val, to)), nil
+		}
+		return v.text, nil
+	}
+	text := g.value(v)
+	if v.cond && to.Equal(ast.Int) || !v.cond && goType(v.typ) == goType(to) {
+		return text, nil
+	}
+	return goType(to) + "(" + text + ")", nil
+}
+
+// truncate returns n converted to the integer type t.
+func truncate(n int, t *ast.Type) int {
+	switch t.Name {
+	case "char":
+		return int(int8(n))
+	case "short":
+		return int(int16(n))
+	case "int":
+		return int(int32(n))
+	}
+	return n
+}
+
+func (g *Generator) lookup(name string) *ast.Type {
+	for i := len(g.scopes) - 1; i >= 0; i-- {
+		if t, ok := g.scopes[i][name]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// element returns the element index of the slice of ptr, which the
+// runtime checks is in it.
+func (g *Generator) element(ptr, index value) (value, error) {
+	if !isIntegral(index) {
+		return value{}, errors.New("array subscript is not an integer")
+	}
+	g.used["citadelAt"] = true
+	text := fmt.Sprintf("*citadelAt(%s, %s, %d)", ptr.text, g.value(index), g.line)
+	return value{text: text, typ: ptr.typ.Elem.Decay(), prec: unaryPrec}, nil
+}
+
+// isIntegral reports whether v has an integer type.
+func isIntegral(v value) bool {
+	return v.typ != nil && v.typ.IsInteger()
+}
+
+func (g *Generator) expr(e ast.Expression) (value, error) {
+	switch e := e.(type) {
+	case *ast.IntLiteral:
+		t := ast.Int
+		if int(int32(e.Value)) != e.Value {
+			t = ast.Long
+		}
+		return value{text: strconv.Itoa(e.Value), typ: t, constant: true, val: e.Value}, nil
+	case *ast.StringLiteral:
+		text, err := codegen.StringBytes(e)
+		if err != nil {
+			return value{}, err
+		}
+		g.used["citadelString"] = true
+		return value{text: "citadelString(" + strconv.Quote(strings.TrimSuffix(text, "\x00")) + ")", typ: ast.PointerTo(ast.Char)}, nil
+	case *ast.Identifier:
+		if t := g.lookup(e.Name); t != nil {
+			return value{text: goName(e.Name), typ: t.Decay()}, nil
+		}
+		if fn := g.functions[e.Name]; fn != nil {
+			if fn.Body == nil {
+				return value{}, fmt.Errorf("%s is declared but not defined, and has no Go translation", e.Name)
+			}
+			return value{text: goName(e.Name), typ: ast.PointerTo(fn.Signature())}, nil
+		}
+		return value{}, fmt.Errorf("undefined variable: %s", e.Name)
+	case *ast.BinaryOp:
+		return g.binaryOp(e)
+	case *ast.UnaryOp:
+		operand, err := g.expr(e.Operand)
+		if err != nil {
+			return value{}, err
+		}
+		if operand.typ == nil {
+			return value{}, errors.New("void value not ignored as it ought to be")
+		}
+		if e.Operator == "*" {
+			switch {
+			case operand.typ.IsFuncPointer():
+				return operand, nil
+			case operand.typ.Kind != ast.PointerType:
+				return value{}, fmt.Errorf("invalid type argument of unary *: %s", operand.typ)
+			}
+			return g.element(operand, value{text: "0", typ: ast.Int, constant: true})
+		}
+		if operand.typ.Kind != ast.BasicType {
+			return value{}, fmt.Errorf("invalid operand of unary -: %s", operand.typ)
+		}
+		t := operand.typ.Promote()
+		if operand.cond {
+			t = ast.Int
+		}
+		if operand.constant && t.IsInteger() {
+			n := truncate(-operand.val, t)
+			return value{text: strconv.Itoa(n), typ: t, constant: true, val: n}, nil
+		}
+		text, err := g.convert(operand, t)
+		if err != nil {
+			return value{}, err
+		}
+		// Leaving no -- for Go to read as a decrement
+		if operand.prec != 0 || strings.HasPrefix(text, "-") {
+			text = "(" + text + ")"
+		}
+		return value{text: "-" + text, typ: t, prec: unaryPrec}, nil
+	case *ast.IndexExpr:
+		array, err := g.expr(e.Array)
+		if err != nil {
+			return value{}, err
+		}
+		if array.typ == nil || array.typ.Kind != ast.PointerType || array.typ.IsFuncPointer() {
+			return value{}, errors.New("subscripted value is not an array or pointer")
+		}
+		index, err := g.expr(e.Index)
+		if err != nil {
+			return value{}, err
+		}
+		return g.element(array, index)
+	case *ast.Assignment:
+		// An assignment is a statement in Go: as a value, it is a function
+		// literal that assigns and returns what it assigned
+		text, t, err := g.assignment(e)
+		if err != nil {
+			return value{}, err
+		}
+		target, _, _ := g.lvalue(e.Target)
+		return value{text: fmt.Sprintf("func() %s {\n%s\nreturn %s\n}()", goType(t), text, target), typ: t}, nil
+	case *ast.CallExpr:
+		return g.call(e)
+	}
+	return value{}, fmt.Errorf("unknown expression type")
+}
+
+// lvalue returns the text of target, which is assigned to, and its type.
+func (g *Generator) lvalue(target ast.Expression) (string, *ast.Type, error) {
+	switch e := target.(type) {
+	case *ast.Identifier:
+		t := g.lookup(e.Name)
+		if t == nil {
+			return "", nil, fmt.Errorf("expression is not assignable: %s", parser.FormatExpr(target))
+		}
+		if t.Kind == ast.ArrayType {
+			return "", nil, fmt.Errorf("array %s is not assignable", e.Name)
+		}
+		return goName(e.Name), t, nil
+	case *ast.UnaryOp:
+		if e.Operator != "*" {
+			break
+		}
+		v, err := g.expr(target)
+		if err != nil {
+			return "", nil, err
+		}
+		if v.typ.IsFuncPointer() {
+			break
+		}
+		return v.text, v.typ, nil
+	case *ast.IndexExpr:
+		v, err := g.expr(target)
+		return v.text, v.typ, err
+	}
+	return "", nil, fmt.Errorf("expression is not assignable: %s", parser.FormatExpr(target))
+}
+
+// assignment returns the Go statement of a, and the type assigned.
+func (g *Generator) assignment(a *ast.Assignment) (string, *ast.Type, error) {
+	target, t, err := g.lvalue(a.Target)
+	if err != nil {
+		return "", nil, err
+	}
+	v, err := g.expr(a.Value)
+	if err != nil {
+		return "", nil, err
+	}
+	text, err := g.convert(v, t)
+	if err != nil {
+		return "", nil, err
+	}
+	return target + " = " + text, t, nil
+}
+
+func (g *Generator) binaryOp(op *ast.BinaryOp) (value, error) {
+	left, err := g.expr(op.Left)
+	if err != nil {
+		return value{}, err
+	}
+	right, err := g.expr(op.Right)
+	if err != nil {
+		return value{}, err
+	}
+	if left.typ == nil || right.typ == nil {
+		return value{}, errors.New("void value not ignored as it ought to be")
+	}
+	prec := precedences[op.Operator]
+	switch op.Operator {
+	case "&&", "||":
+		text := paren(value{text: g.cond(left), prec: condPrec(left)}, prec) + " " + op.Operator + " " + paren(value{text: g.cond(right), prec: condPrec(right)}, prec+1)
+		return value{text: text, typ: ast.Int, cond: true, prec: prec}, nil
+	}
+
+	lp, rp := left.typ.Kind == ast.PointerType, right.typ.Kind == ast.PointerType
+	switch {
+	case (lp || rp) && op.Operator == "==":
+		// Slices and functions compare only with nil
+		switch {
+		case lp && right.constant && right.val == 0:
+			return value{text: paren(left, prec) + " == nil", typ: ast.Int, cond: true, prec: prec}, nil
+		case rp && left.constant && left.val == 0:
+			return value{text: paren(right, prec) + " == nil", typ: ast.Int, cond: true, prec: prec}, nil
+		}
+		return value{}, errors.New("comparing pointers has no Go translation")
+	case lp || rp:
+		return value{}, fmt.Errorf("pointer operands of %s have no Go translation", op.Operator)
+	}
+
+	common := ast.CommonType(left.typ, right.typ)
+	if common == nil {
+		return value{}, fmt.Errorf("invalid operands of types %s and %s", left.typ, right.typ)
+	}
+	if (op.Operator == "/" || op.Operator == "%") && right.constant && right.val == 0 {
+		return value{}, errors.New("division by zero")
+	}
+	if left.constant && right.constant && common.IsInteger() {
+		n := fold(op.Operator, left.val, right.val)
+		if precedences[op.Operator] != 3 {
+			n = truncate(n, common)
+		}
+		t := common
+		if precedences[op.Operator] == 3 {
+			t = ast.Int
+		}
+		return value{text: strconv.Itoa(n), typ: t, constant: true, val: n}, nil
+	}
+
+	l, err := g.convert(left, common)
+	if err != nil {
+		return value{}, err
+	}
+	r, err := g.convert(right, common)
+	if err != nil {
+		return value{}, err
+	}
+	if l == left.text {
+		l = paren(left, prec)
+	}
+	if r == right.text {
+		r = paren(right, prec+1)
+	}
+	text := l + " " + op.Operator + " " + r
+	if precedences[op.Operator] == 3 {
+		return value{text: text, typ: ast.Int, cond: true, prec: prec}, nil
+	}
+	return value{text: text, typ: common, prec: prec}, nil
+}
+
+// condPrec returns the precedence of the text of v as a Go bool.
+func condPrec(v value) int {
+	if v.cond {
+		return v.prec
+	}
+	if v.constant {
+		return 0
+	}
+	return 3
+}
+
+// fold returns the value of the constant operation a op b, which is not
+// a division by zero.
+func fold(op string, a, b int) int {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return a % b
+	case "==":
+		return boolInt(a == b)
+	case "<":
+		return boolInt(a < b)
+	}
+	return boolInt(a > b)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// call translates a call of a function of the program, of one the
+// runtime has in place of the C library, or through a function pointer.
+func (g *Generator) call(call *ast.CallExpr) (value, error) {
+	var callee string
+	var sig *ast.Type
+	variadic := false
+	if id, ok := call.Callee.(*ast.Identifier); ok && g.lookup(id.Name) == nil {
+		fn := g.functions[id.Name]
+		lib, isLib := libc[id.Name]
+		switch {
+		case fn != nil && fn.Body != nil:
+			callee, sig = goName(id.Name), fn.Signature()
+		case isLib:
+			g.used[lib.helper] = true
+			callee, sig, variadic = lib.helper, &ast.Type{Kind: ast.FuncType, Elem: lib.result, Params: lib.params}, lib.variadic
+		case fn != nil || codegen.LookupLibc(id.Name) != nil:
+			return value{}, fmt.Errorf("%s is not defined in the program, and has no Go translation", id.Name)
+		default:
+			return value{}, fmt.Errorf("undefined function: %s", id.Name)
+		}
+	} else {
+		v, err := g.expr(call.Callee)
+		if err != nil {
+			return value{}, err
+		}
+		if v.typ == nil || !v.typ.IsFuncPointer() {
+			return value{}, fmt.Errorf("called object %s is not a function", call.Callee)
+		}
+		callee, sig = paren(v, unaryPrec), v.typ.Elem
+	}
+
+	if len(call.Args) < len(sig.Params) || len(call.Args) > len(sig.Params) && !variadic {
+		return value{}, fmt.Errorf("call to %s expects %d arguments, got %d", call.Callee, len(sig.Params), len(call.Args))
+	}
+	args := []string{}
+	for i, arg := range call.Args {
+		v, err := g.expr(arg)
+		if err != nil {
+			return value{}, err
+		}
+		var text string
+		switch {
+		case i < len(sig.Params):
+			text, err = g.convert(v, sig.Params[i])
+		case v.typ == nil:
+			err = errors.New("void value not ignored as it ought to be")
+		case v.typ.Kind != ast.BasicType:
+			text = v.text
+		default:
+			// The default argument promotions
+			to := v.typ.Promote()
+			if to.Equal(ast.Float) {
+				to = ast.Double
+			}
+			text, err = g.convert(v, to)
+		}
+		if err != nil {
+			return value{}, fmt.Errorf("argument %d to %s: %v", i+1, call.Callee, err)
+		}
+		args = append(args, text)
+	}
+	return value{text: callee + "(" + strings.Join(args, ", ") + ")", typ: sig.Elem}, nil
+}
@@ -0,0 +1,332 @@
+// Package gobackend translates programs into Go source, for porting small
+// C utilities into Go services with Citadel's frontend doing the parsing
+// and the semantic checks. The integer and floating types become the Go
+// types of the same width, and arrays and pointers become slices, with
+// each subscript and dereference checked against the slice by a call that
+// names the C source line when it fails. Calls to the C library functions
+// the runtime covers go to Go functions written into the output with the
+// checks. The backend is experimental: what has no Go counterpart here,
+// such as comparing pointers, inline assembly or the rest of the C
+// library, is an error.
+package gobackend
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+)
+
+// Options configures the translation.
+type Options struct {
+	// Package is the name of the Go package, main if empty. A main
+	// package gets a Go main function that runs the C one and exits with
+	// its result.
+	Package string
+	// File names the C source in the messages of failed checks and in the
+	// comment heading the output.
+	File string
+}
+
+// Generator translates programs into Go.
+type Generator struct {
+	opts Options
+	// functions are the declarations of the program by name, the
+	// definition of each if it has one
+	functions map[string]*ast.Function
+	used      map[string]bool // the runtime helpers the output calls
+
+	// State of the function being translated
+	fn     *ast.Function
+	scopes []map[string]*ast.Type
+	reads  map[string]bool // the names the function reads, as Go counts uses
+	line   int             // of the statement being translated
+}
+
+func New(opts Options) *Generator {
+	return &Generator{opts: opts}
+}
+
+// Generate translates program and returns the Go source, formatted as
+// gofmt does.
+func (g *Generator) Generate(program *ast.Program) (_ string, err error) {
+	defer diag.Recover("codegen", &err)
+	g.functions = map[string]*ast.Function{}
+	g.used = map[string]bool{}
+	for _, fn := range program.Functions {
+		if prev := g.functions[fn.Name]; prev == nil || fn.Body != nil {
+			g.functions[fn.Name] = fn
+		}
+	}
+
+	var body strings.Builder
+	for _, fn := range program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		if err := g.function(&body, fn); err != nil {
+			return "", err
+		}
+	}
+	if main := g.functions["main"]; main != nil && main.Body != nil && g.packageName() == "main" {
+		if err := g.entryPoint(&body, main); err != nil {
+			return "", err
+		}
+	}
+
+	var out strings.Builder
+	if g.opts.File != "" {
+		fmt.Fprintf(&out, "// Translated from %s by Citadel.\n\n", g.opts.File)
+	}
+	fmt.Fprintf(&out, "package %s\n", g.packageName())
+	helpers, imports := g.runtime()
+	if len(imports) > 0 {
+		out.WriteString("\nimport (\n")
+		for _, path := range imports {
+			fmt.Fprintf(&out, "%q\n", path)
+		}
+		out.WriteString(")\n")
+	}
+	out.WriteString(body.String())
+	out.WriteString(helpers)
+
+	src, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return "", fmt.Errorf("translated Go does not parse: %v", err)
+	}
+	return string(src), nil
+}
+
+// GenerateTo translates program and writes the Go source to w.
+func (g *Generator) GenerateTo(w io.Writer, program *ast.Program) error {
+	src, err := g.Generate(program)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, src)
+	return err
+}
+
+func (g *Generator) packageName() string {
+	if g.opts.Package == "" {
+		return "main"
+	}
+	return g.opts.Package
+}
+
+// runtime returns the definitions of the helpers the output calls, and
+// the packages they import, sorted.
+func (g *Generator) runtime() (string, []string) {
+	for changed := true; changed; {
+		changed = false
+		for name := range g.used {
+			for _, dep := range helpers[name].uses {
+				if !g.used[dep] {
+					g.used[dep], changed = true, true
+				}
+			}
+		}
+	}
+	var b strings.Builder
+	paths := map[string]bool{}
+	if g.used["citadelFile"] {
+		fmt.Fprintf(&b, "\nconst citadelFile = %q\n", g.opts.File)
+	}
+	for _, name := range helperOrder {
+		if !g.used[name] {
+			continue
+		}
+		b.WriteString("\n" + helpers[name].source)
+		for _, path := range helpers[name].imports {
+			paths[path] = true
+		}
+	}
+	if g.used["os"] {
+		paths["os"] = true
+	}
+	imports := make([]string, 0, len(paths))
+	for path := range paths {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	return b.String(), imports
+}
+
+// function writes the translation of fn, a definition, to w.
+func (g *Generator) function(w *strings.Builder, fn *ast.Function) error {
+	g.fn = fn
+	g.scopes = []map[string]*ast.Type{{}}
+	g.reads = map[string]bool{}
+	collectReads(fn.Body, g.reads)
+	params := []string{}
+	for _, param := range fn.Params {
+		name := "_"
+		if param.Name != "" {
+			name = goName(param.Name)
+			g.scopes[0][param.Name] = param.Type
+		}
+		params = append(params, name+" "+goType(param.Type))
+	}
+	fmt.Fprintf(w, "\nfunc %s(%s) %s {\n", goName(fn.Name), strings.Join(params, ", "), goType(fn.ReturnType))
+	if err := g.statements(w, fn.Body.Statements); err != nil {
+		return err
+	}
+	// Go has no falling off the end of a function with a result; C's main
+	// returns 0 then, and the other functions an unspecified value
+	if n := len(fn.Body.Statements); n == 0 || !isReturn(fn.Body.Statements[n-1]) {
+		fmt.Fprintf(w, "return %s\n", zero(fn.ReturnType))
+	}
+	w.WriteString("}\n")
+	return nil
+}
+
+// entryPoint writes the Go main function, which passes main the command
+// line if it takes argc and argv, and exits with what it returns.
+func (g *Generator) entryPoint(w *strings.Builder, main *ast.Function) error {
+	g.used["os"] = true
+	w.WriteString("\nfunc main() {\n")
+	switch len(main.Params) {
+	case 0:
+		fmt.Fprintf(w, "os.Exit(int(%s()))\n", goName("main"))
+	case 2:
+		argv := ast.PointerTo(ast.PointerTo(ast.Char))
+		if !main.Params[0].Type.Equal(ast.Int) || !main.Params[1].Type.Equal(argv) {
+			return &codegen.Error{Function: main.Name, Pos: main.Pos, Err: errors.New("main takes no parameters or int and char **")}
+		}
+		g.used["citadelString"] = true
+		w.WriteString("args := make([][]int8, len(os.Args)+1)\n")
+		w.WriteString("for i, arg := range os.Args {\nargs[i] = citadelString(arg)\n}\n")
+		fmt.Fprintf(w, "os.Exit(int(%s(int32(len(os.Args)), args)))\n", goName("main"))
+	default:
+		return &codegen.Error{Function: main.Name, Pos: main.Pos, Err: errors.New("main takes no parameters or int and char **")}
+	}
+	w.WriteString("}\n")
+	return nil
+}
+
+func isReturn(stmt ast.Statement) bool {
+	_, ok := stmt.(*ast.ReturnStatement)
+	return ok
+}
+
+// goType returns the Go spelling of t: arrays and the pointers they decay
+// to are both slices of the element type.
+func goType(t *ast.Type) string {
+	switch t.Kind {
+	case ast.PointerType:
+		if t.Elem.Kind == ast.FuncType {
+			return goType(t.Elem)
+		}
+		return "[]" + goType(t.Elem)
+	case ast.ArrayType:
+		return "[]" + goType(t.Elem)
+	case ast.FuncType:
+		params := []string{}
+		for _, param := range t.Params {
+			params = append(params, goType(param))
+		}
+		return "func(" + strings.Join(params, ", ") + ") " + goType(t.Elem)
+	}
+	return basicTypes[t.Name]
+}
+
+var basicTypes = map[string]string{
+	"char": "int8", "short": "int16", "int": "int32", "long": "int64",
+	"float": "float32", "double": "float64",
+}
+
+// zero returns the zero value of t in Go.
+func zero(t *ast.Type) string {
+	if t.Kind == ast.BasicType {
+		return "0"
+	}
+	return "nil"
+}
+
+// reserved are the names a C identifier cannot keep in Go: the keywords,
+// the predeclared identifiers, the packages the runtime imports, and the
+// functions Go gives a meaning of its own.
+var reserved = map[string]bool{}
+
+func init() {
+	for _, name := range strings.Fields(`
+		break case chan const continue default defer else fallthrough for
+		func go goto if import interface map package range return select
+		struct switch type var
+		any bool byte comparable complex64 complex128 error float32 float64
+		int int8 int16 int32 int64 rune string uint uint8 uint16 uint32
+		uint64 uintptr true false iota nil append cap clear close complex
+		copy delete imag len make max min new panic print println real
+		recover
+		fmt os strings main init _`) {
+		reserved[name] = true
+	}
+}
+
+// goName returns the Go name of the C identifier name: the reserved names,
+// and those starting like the names of the runtime, take an underscore.
+func goName(name string) string {
+	if reserved[name] || strings.HasPrefix(name, "citadel") {
+		return name + "_"
+	}
+	return name
+}
+
+// collectReads adds to reads the names the statements of node use as Go
+// counts uses, which leaves out assigning to a variable.
+func collectReads(node ast.Node, reads map[string]bool) {
+	switch n := node.(type) {
+	case *ast.Block:
+		for _, stmt := range n.Statements {
+			collectReads(stmt, reads)
+		}
+	case *ast.VarDecl:
+		collectReads(n.Value, reads)
+	case *ast.IfStatement:
+		collectReads(n.Condition, reads)
+		collectReads(n.ThenBlock, reads)
+		if n.ElseBlock != nil {
+			collectReads(n.ElseBlock, reads)
+		}
+	case *ast.WhileStatement:
+		collectReads(n.Condition, reads)
+		collectReads(n.Body, reads)
+	case *ast.SwitchStatement:
+		collectReads(n.Tag, reads)
+		for _, cs := range n.Cases {
+			for _, stmt := range cs.Body {
+				collectReads(stmt, reads)
+			}
+		}
+	case *ast.ReturnStatement:
+		collectReads(n.Value, reads)
+	case *ast.ExprStatement:
+		collectReads(n.Expr, reads)
+	case *ast.Identifier:
+		reads[n.Name] = true
+	case *ast.BinaryOp:
+		collectReads(n.Left, reads)
+		collectReads(n.Right, reads)
+	case *ast.UnaryOp:
+		collectReads(n.Operand, reads)
+	case *ast.IndexExpr:
+		collectReads(n.Array, reads)
+		collectReads(n.Index, reads)
+	case *ast.Assignment:
+		if _, ok := n.Target.(*ast.Identifier); !ok {
+			collectReads(n.Target, reads)
+		}
+		collectReads(n.Value, reads)
+	case *ast.CallExpr:
+		collectReads(n.Callee, reads)
+		for _, arg := range n.Args {
+			collectReads(arg, reads)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package gobackend_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen/gobackend"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// run translates src, builds the Go it becomes and runs it, returning
+// the exit status and what it wrote to the standard output and error.
+// The test is skipped when there is no go command to build it with.
+func run(t *testing.T, src string) (int, string, string) {
+	t.Helper()
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	out, err := gobackend.New(gobackend.Options{File: "t.c"}).Generate(program)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(out), 0644); err != nil {
+		t.Fatal(err)
+	}
+	build := exec.Command(gocmd, "build", "-o", "prog", "main.go")
+	build.Dir = dir
+	build.Env = append(os.Environ(), "GOFLAGS=", "GO111MODULE=off")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s", src, err, out)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(filepath.Join(dir, "prog"))
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err = cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), stdout.String(), stderr.String()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stdout.String(), stderr.String()
+}
+
+// TestRun checks that translated programs compute what the C does, with
+// the integer widths of C, and that an index outside an array stops the
+// program with the C line it is on.
+func TestRun(t *testing.T) {
+	for _, test := range []struct {
+		src    string
+		status int
+		stdout string
+		stderr string // a part of the standard error
+	}{
+		{"int main() { return 42; }", 42, "", ""},
+		{"int square(int x) { return x * x; } int main() { return square(6) + 1; }", 37, "", ""},
+		{`int main() {
+    int a[4];
+    int i = 0;
+    while (i < 4) { a[i] = i * i; i = i + 1; }
+    printf("%d %d\n", a[2], a[3]);
+    switch (a[2]) { case 4: return a[1] + a[3]; default: return 1; }
+}`, 10, "4 9\n", ""},
+		// char wraps at 8 bits, and long holds what int cannot
+		{"int main() { char c = 127; c = c + 1; long n = 3000000000; return (c < 0) + n / 1000000000; }", 4, "", ""},
+		{"int main() {\n    int a[4];\n    int i = 4;\n    a[i] = 1;\n    return 0;\n}\n", 2, "", "t.c:4: index 4 out of bounds [0:4]"},
+	} {
+		status, stdout, stderr := run(t, test.src)
+		if status != test.status || stdout != test.stdout || !strings.Contains(stderr, test.stderr) {
+			t.Errorf("%s: exit status %d, output %q, errors %q; want %d, %q and errors with %q",
+				test.src, status, stdout, stderr, test.status, test.stdout, test.stderr)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package gobackend
+
+import "github.com/anouar-bakouch/citadel/pkg/ast"
+
+// libcFunction is a C library function the runtime has a Go function
+// for, with the C types the translation converts the arguments to.
+type libcFunction struct {
+	helper   string
+	result   *ast.Type // nil for void
+	params   []*ast.Type
+	variadic bool
+}
+
+var charPtr = ast.PointerTo(ast.Char)
+
+// libc are the C library functions the runtime covers.
+var libc = map[string]libcFunction{
+	"printf":  {helper: "citadelPrintf", result: ast.Int, params: []*ast.Type{charPtr}, variadic: true},
+	"puts":    {helper: "citadelPuts", result: ast.Int, params: []*ast.Type{charPtr}},
+	"putchar": {helper: "citadelPutchar", result: ast.Int, params: []*ast.Type{ast.Int}},
+	"strlen":  {helper: "citadelStrlen", result: ast.Long, params: []*ast.Type{charPtr}},
+	"strcmp":  {helper: "citadelStrcmp", result: ast.Int, params: []*ast.Type{charPtr, charPtr}},
+	"strcpy":  {helper: "citadelStrcpy", result: charPtr, params: []*ast.Type{charPtr, charPtr}},
+	"atoi":    {helper: "citadelAtoi", result: ast.Int, params: []*ast.Type{charPtr}},
+	"abs":     {helper: "citadelAbs", result: ast.Int, params: []*ast.Type{ast.Int}},
+	"exit":    {helper: "citadelExit", params: []*ast.Type{ast.Int}},
+}
+
+// helper is a function of the runtime, written into the output if it is
+// called.
+type helper struct {
+	source  string
+	imports []string
+	uses    []string // the other helpers it calls
+}
+
+// helperOrder is the order the helpers are written in.
+var helperOrder = []string{
+	"citadelInteger", "citadelAt", "citadelBool", "citadelString", "citadelGoString",
+	"citadelPrintf", "citadelPuts", "citadelPutchar", "citadelStrlen", "citadelStrcmp",
+	"citadelStrcpy", "citadelAtoi", "citadelAbs", "citadelExit",
+}
+
+var helpers = map[string]helper{
+	"citadelInteger": {source: `// citadelInteger are the types of C integers, and of Go's untyped
+// constants.
+type citadelInteger interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+`},
+	"citadelAt": {source: `// citadelAt returns the element i of s, panicking with the C source
+// line if s has no such element.
+func citadelAt[T any, I citadelInteger](s []T, i I, line int) *T {
+	if i < 0 || int64(i) >= int64(len(s)) {
+		panic(fmt.Sprintf("%s:%d: index %d out of bounds [0:%d]", citadelFile, line, i, len(s)))
+	}
+	return &s[i]
+}
+`, imports: []string{"fmt"}, uses: []string{"citadelInteger", "citadelFile"}},
+	"citadelBool": {source: `// citadelBool returns b as C's 1 or 0.
+func citadelBool(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+`},
+	"citadelString": {source: `// citadelString returns s as a C string.
+func citadelString(s string) []int8 {
+	c := make([]int8, len(s)+1)
+	for i := 0; i < len(s); i++ {
+		c[i] = int8(s[i])
+	}
+	return c
+}
+`},
+	"citadelGoString": {source: `// citadelGoString returns the C string s as a Go string.
+func citadelGoString(s []int8) string {
+	b := []byte{}
+	for _, c := range s {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+`},
+	"citadelPrintf": {source: `// citadelPrintf is printf. The length modifiers are left to the types of
+// the arguments, and %s takes a C string.
+func citadelPrintf(format []int8, args ...any) int32 {
+	f := citadelGoString(format)
+	var b strings.Builder
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			b.WriteByte(f[i])
+			continue
+		}
+		j := i + 1
+		for j < len(f) && strings.IndexByte("-+ #0123456789.", f[j]) >= 0 {
+			j++
+		}
+		spec := f[i:j]
+		for j < len(f) && strings.IndexByte("hlLqjzt", f[j]) >= 0 {
+			j++
+		}
+		if j == len(f) {
+			b.WriteString(f[i:])
+			break
+		}
+		verb := f[j]
+		i = j
+		switch verb {
+		case '%':
+			b.WriteByte('%')
+			continue
+		case 'i', 'u':
+			verb = 'd'
+		}
+		var arg any
+		if len(args) > 0 {
+			arg, args = args[0], args[1:]
+		}
+		if s, ok := arg.([]int8); ok && verb == 's' {
+			arg = citadelGoString(s)
+		}
+		fmt.Fprintf(&b, spec+string(verb), arg)
+	}
+	n, _ := os.Stdout.WriteString(b.String())
+	return int32(n)
+}
+`, imports: []string{"fmt", "os", "strings"}, uses: []string{"citadelGoString"}},
+	"citadelPuts": {source: `// citadelPuts is puts.
+func citadelPuts(s []int8) int32 {
+	os.Stdout.WriteString(citadelGoString(s) + "\n")
+	return 0
+}
+`, imports: []string{"os"}, uses: []string{"citadelGoString"}},
+	"citadelPutchar": {source: `// citadelPutchar is putchar.
+func citadelPutchar(c int32) int32 {
+	os.Stdout.Write([]byte{byte(c)})
+	return int32(byte(c))
+}
+`, imports: []string{"os"}},
+	"citadelStrlen": {source: `// citadelStrlen is strlen.
+func citadelStrlen(s []int8) int64 {
+	return int64(len(citadelGoString(s)))
+}
+`, uses: []string{"citadelGoString"}},
+	"citadelStrcmp": {source: `// citadelStrcmp is strcmp.
+func citadelStrcmp(a, b []int8) int32 {
+	return int32(strings.Compare(citadelGoString(a), citadelGoString(b)))
+}
+`, imports: []string{"strings"}, uses: []string{"citadelGoString"}},
+	"citadelStrcpy": {source: `// citadelStrcpy is strcpy, and panics if dst is too short.
+func citadelStrcpy(dst, src []int8) []int8 {
+	s := citadelGoString(src)
+	copy(dst[:len(s)+1], citadelString(s))
+	return dst
+}
+`, uses: []string{"citadelGoString", "citadelString"}},
+	"citadelAtoi": {source: `// citadelAtoi is atoi.
+func citadelAtoi(s []int8) int32 {
+	i, n, sign := 0, int32(0), int32(1)
+	for i < len(s) && (s[i] == ' ' || s[i] >= '\t' && s[i] <= '\r') {
+		i++
+	}
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		if s[i] == '-' {
+			sign = -1
+		}
+		i++
+	}
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		n = n*10 + int32(s[i]-'0')
+	}
+	return sign * n
+}
+`},
+	"citadelAbs": {source: `// citadelAbs is abs.
+func citadelAbs(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+`},
+	"citadelExit": {source: `// citadelExit is exit.
+func citadelExit(status int32) {
+	os.Exit(int(status))
+}
+`, imports: []string{"os"}},
+}
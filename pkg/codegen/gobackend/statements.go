@@ -0,0 +1,197 @@
+package gobackend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// statements writes the translation of stmts to w. An error is a
+// *codegen.Error at the statement it is in.
+func (g *Generator) statements(w *strings.Builder, stmts []ast.Statement) error {
+	for _, stmt := range stmts {
+		g.line = stmt.Position().Line
+		if err := g.statement(w, stmt); err != nil {
+			var cerr *codegen.Error
+			if errors.As(err, &cerr) {
+				return err
+			}
+			return &codegen.Error{Function: g.fn.Name, Pos: stmt.Position(), Err: err}
+		}
+	}
+	return nil
+}
+
+func (g *Generator) block(w *strings.Builder, stmts []ast.Statement) error {
+	g.scopes = append(g.scopes, map[string]*ast.Type{})
+	defer func() { g.scopes = g.scopes[:len(g.scopes)-1] }()
+	return g.statements(w, stmts)
+}
+
+func (g *Generator) statement(w *strings.Builder, stmt ast.Statement) error {
+	switch s := stmt.(type) {
+	case *ast.Block:
+		w.WriteString("{\n")
+		if err := g.block(w, s.Statements); err != nil {
+			return err
+		}
+		w.WriteString("}\n")
+	case *ast.VarDecl:
+		return g.varDecl(w, s)
+	case *ast.IfStatement:
+		cond, err := g.expr(s.Condition)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "if %s {\n", g.cond(cond))
+		if err := g.block(w, s.ThenBlock.Statements); err != nil {
+			return err
+		}
+		if s.ElseBlock != nil {
+			w.WriteString("} else {\n")
+			if err := g.block(w, s.ElseBlock.Statements); err != nil {
+				return err
+			}
+		}
+		w.WriteString("}\n")
+	case *ast.WhileStatement:
+		cond, err := g.expr(s.Condition)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "for %s {\n", g.cond(cond))
+		if err := g.block(w, s.Body.Statements); err != nil {
+			return err
+		}
+		w.WriteString("}\n")
+	case *ast.SwitchStatement:
+		return g.switchStatement(w, s)
+	case *ast.BreakStatement:
+		w.WriteString("break\n")
+	case *ast.ReturnStatement:
+		if s.Value == nil {
+			fmt.Fprintf(w, "return %s\n", zero(g.fn.ReturnType))
+			return nil
+		}
+		v, err := g.expr(s.Value)
+		if err != nil {
+			return err
+		}
+		text, err := g.convert(v, g.fn.ReturnType)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "return %s\n", text)
+	case *ast.ExprStatement:
+		// Go takes assignments and calls as statements, and any other
+		// expression only as a value to discard
+		if a, ok := s.Expr.(*ast.Assignment); ok {
+			text, _, err := g.assignment(a)
+			if err != nil {
+				return err
+			}
+			w.WriteString(text + "\n")
+			return nil
+		}
+		v, err := g.expr(s.Expr)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.Expr.(*ast.CallExpr); ok {
+			w.WriteString(v.text + "\n")
+			return nil
+		}
+		fmt.Fprintf(w, "_ = %s\n", g.value(v))
+	case *ast.AsmStatement:
+		return errors.New("inline assembly has no Go translation")
+	default:
+		return fmt.Errorf("unknown statement type")
+	}
+	return nil
+}
+
+func (g *Generator) varDecl(w *strings.Builder, decl *ast.VarDecl) error {
+	name := goName(decl.Name)
+	switch {
+	case decl.Type.Kind == ast.ArrayType:
+		if decl.Value != nil {
+			return fmt.Errorf("array initializers are not supported: %s", decl.Name)
+		}
+		fmt.Fprintf(w, "%s := %s\n", name, makeArray(decl.Type))
+	case decl.Value != nil:
+		v, err := g.expr(decl.Value)
+		if err != nil {
+			return err
+		}
+		text, err := g.convert(v, decl.Type)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "var %s %s = %s\n", name, goType(decl.Type), text)
+	default:
+		fmt.Fprintf(w, "var %s %s\n", name, goType(decl.Type))
+	}
+	g.scopes[len(g.scopes)-1][decl.Name] = decl.Type
+	if !g.reads[decl.Name] {
+		fmt.Fprintf(w, "_ = %s\n", name)
+	}
+	return nil
+}
+
+// makeArray returns the Go expression making the slice of an array of
+// type t, and of the arrays that are its elements.
+func makeArray(t *ast.Type) string {
+	if t.Elem.Kind != ast.ArrayType {
+		return fmt.Sprintf("make(%s, %d)", goType(t), t.Len)
+	}
+	return fmt.Sprintf("func() %s {\na := make(%s, %d)\nfor i := range a {\na[i] = %s\n}\nreturn a\n}()", goType(t), goType(t), t.Len, makeArray(t.Elem))
+}
+
+// switchStatement translates a switch. Go cases do not fall through, so
+// each case that ends other than in break or return and is not the last
+// falls through explicitly, and a break ending a case is left to Go.
+func (g *Generator) switchStatement(w *strings.Builder, s *ast.SwitchStatement) error {
+	tag, err := g.expr(s.Tag)
+	if err != nil {
+		return err
+	}
+	if tag.typ == nil || !tag.typ.IsInteger() {
+		return fmt.Errorf("switch quantity is not an integer")
+	}
+	text, err := g.convert(tag, tag.typ.Promote())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "switch %s {\n", text)
+	g.scopes = append(g.scopes, map[string]*ast.Type{})
+	defer func() { g.scopes = g.scopes[:len(g.scopes)-1] }()
+	for i, cs := range s.Cases {
+		if cs.Value == nil {
+			w.WriteString("default:\n")
+		} else {
+			value, err := codegen.CaseConstant(cs.Value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "case %d:\n", value)
+		}
+		body := cs.Body
+		ends := len(body) > 0 && isReturn(body[len(body)-1])
+		if n := len(body); n > 0 {
+			if _, ok := body[n-1].(*ast.BreakStatement); ok {
+				body, ends = body[:n-1], true
+			}
+		}
+		if err := g.statements(w, body); err != nil {
+			return err
+		}
+		if !ends && i < len(s.Cases)-1 {
+			w.WriteString("fallthrough\n")
+		}
+	}
+	w.WriteString("}\n")
+	return nil
+}
@@ -0,0 +1,93 @@
+package codegen_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// generate parses src and returns the IR generated for it with opts
+func generate(t *testing.T, src string, opts codegen.Options) string {
+	t.Helper()
+	ir, err := generateErr(src, opts)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return ir
+}
+
+// generateErr is generate for options that may be rejected
+func generateErr(src string, opts codegen.Options) (string, error) {
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		return "", err
+	}
+	return codegen.NewWithOptions(opts).Generate(program)
+}
+
+// compileIR runs llc on ir for the triple it names and returns the
+// assembly. The test is skipped when there is no llc
+func compileIR(t *testing.T, ir string) string {
+	t.Helper()
+	llc, err := exec.LookPath("llc")
+	if err != nil {
+		t.Skip(err)
+	}
+	path := filepath.Join(t.TempDir(), "a.ll")
+	if err := os.WriteFile(path, []byte(ir), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(llc, "-o", "-", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("llc: %v\n%s", err, out)
+	}
+	return string(out)
+}
+
+// TestShadowCallStack checks that -shadow-call-stack is rejected on the
+// targets LLVM has no shadow call stack for, and elsewhere marks the
+// functions and the module and has llc push the return address through
+// x18
+func TestShadowCallStack(t *testing.T) {
+	const src = "int g(int x) { return x; } int main() { return g(1); }"
+	for _, triple := range []string{"x86_64-unknown-linux-gnu", "wasm32-unknown-unknown"} {
+		_, err := generateErr(src, codegen.Options{Target: triple, ShadowCallStack: true})
+		if err == nil || !strings.Contains(err.Error(), "shadow-call-stack is not supported") {
+			t.Errorf("%s: got %v, want shadow-call-stack rejected", triple, err)
+		}
+	}
+	for triple, push := range map[string]string{
+		"aarch64-unknown-linux-gnu": "str\tx30, [x18]",
+		"riscv64-unknown-linux-gnu": "sd\tra, 0(s2)",
+	} {
+		ir := generate(t, src, codegen.Options{Target: triple, ShadowCallStack: true})
+		for _, want := range []string{"shadowcallstack", `"target-features"="+reserve-x18"`, `!{i32 7, !"shadowcallstack", i32 1}`} {
+			if !strings.Contains(ir, want) {
+				t.Errorf("%s: IR lacks %s:\n%s", triple, want, ir)
+			}
+		}
+		if asm := compileIR(t, ir); !strings.Contains(asm, push) {
+			t.Errorf("%s: assembly lacks %q:\n%s", triple, push, asm)
+		}
+	}
+}
+
+// TestSafeStack checks that -safestack marks the functions and the
+// module
+func TestSafeStack(t *testing.T) {
+	ir := generate(t, "int main() { int a[4]; a[0] = 1; return a[0]; }", codegen.Options{SafeStack: true})
+	for _, want := range []string{"safestack", `!{i32 7, !"safestack", i32 1}`} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+	if _, err := generateErr("int main() { return 0; }", codegen.Options{Target: "wasm32-unknown-unknown", SafeStack: true}); err == nil {
+		t.Error("safestack accepted on wasm32")
+	}
+}
@@ -0,0 +1,132 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// memIntrinsics maps the C library memory functions to the LLVM
+// intrinsics they are lowered to. The optimizer understands the
+// intrinsics, and the backend can expand small constant-sized ones inline.
+var memIntrinsics = map[string]string{
+	"memcpy":  "llvm.memcpy.p0i8.p0i8.i64",
+	"memmove": "llvm.memmove.p0i8.p0i8.i64",
+	"memset":  "llvm.memset.p0i8.i64",
+}
+
+// MemIntrinsic returns the intrinsic the C library function name is
+// lowered to, or "" if it is not one of the memory functions.
+func MemIntrinsic(name string) string {
+	return memIntrinsics[name]
+}
+
+// memIntrinsic returns the intrinsic a call lowers to, or "" if the call
+// is an ordinary one. A function the program defines itself under one of
+// these names is called normally.
+func (c *CodeGen) memIntrinsic(call *ast.CallExpr) string {
+	id, ok := call.Callee.(*ast.Identifier)
+	if !ok {
+		return ""
+	}
+	if _, local := c.variables[id.Name]; local {
+		return ""
+	}
+	if fn, ok := c.functions[id.Name]; ok && fn.Body != nil {
+		return ""
+	}
+	return memIntrinsics[id.Name]
+}
+
+// generateMemIntrinsic lowers memcpy, memmove or memset to the given
+// intrinsic and returns the destination pointer, which the C functions
+// return.
+func (c *CodeGen) generateMemIntrinsic(call *ast.CallExpr, intrinsic string) (string, error) {
+	name := call.Callee.String()
+	if len(call.Args) != 3 {
+		return "", fmt.Errorf("call to %s expects 3 arguments, got %d", name, len(call.Args))
+	}
+
+	dst, dstBytes, dstAlign, err := c.generateBytePointer(call.Args[0], name)
+	if err != nil {
+		return "", err
+	}
+	args := []string{fmt.Sprintf("i8* align %d %s", dstAlign, dstBytes)}
+	if name == "memset" {
+		value, err := c.generateIntArgument(call.Args[1], name)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "i8 "+c.truncateToByte(value))
+	} else {
+		_, srcBytes, srcAlign, err := c.generateBytePointer(call.Args[1], name)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, fmt.Sprintf("i8* align %d %s", srcAlign, srcBytes))
+	}
+	size, err := c.generateIntArgument(call.Args[2], name)
+	if err != nil {
+		return "", err
+	}
+	args = append(args, "i64 "+c.widenToI64(size), "i1 false")
+
+	if name == "memset" {
+		c.declare(intrinsic, fmt.Sprintf("declare void @%s(i8*, i8, i64, i1)", intrinsic))
+	} else {
+		c.declare(intrinsic, fmt.Sprintf("declare void @%s(i8*, i8*, i64, i1)", intrinsic))
+	}
+	c.emit("call void @%s(%s, %s, %s, %s)", intrinsic, args[0], args[1], args[2], args[3])
+	return dst, nil
+}
+
+// generateBytePointer evaluates a pointer argument and casts it to i8*.
+// It returns the original pointer, the cast and the alignment known for
+// the pointee: that of the object for a local array, otherwise that of
+// the element type.
+func (c *CodeGen) generateBytePointer(expr ast.Expression, fn string) (string, string, int, error) {
+	t, err := c.typeOf(expr)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if t.Kind != ast.PointerType || t.IsFuncPointer() {
+		return "", "", 0, fmt.Errorf("argument %s to %s is not a data pointer", expr, fn)
+	}
+	align := c.target.AlignOf(t.Elem)
+	if id, ok := expr.(*ast.Identifier); ok {
+		if object := c.varTypes[id.Name]; object != nil && object.Kind == ast.ArrayType {
+			align = c.target.AlignOf(object)
+		}
+	}
+
+	ptr, err := c.generateExpression(expr)
+	if err != nil {
+		return "", "", 0, err
+	}
+	castReg := c.nextReg()
+	c.emit("%%%d = bitcast %s %s to i8*", castReg, c.llvmType(t), ptr)
+	return ptr, fmt.Sprintf("%%%d", castReg), align, nil
+}
+
+// generateIntArgument evaluates an int argument to a memory function.
+func (c *CodeGen) generateIntArgument(expr ast.Expression, fn string) (string, error) {
+	t, err := c.typeOf(expr)
+	if err != nil {
+		return "", err
+	}
+	if !t.IsInteger() {
+		return "", fmt.Errorf("argument %s to %s must be an int, got %s", expr, fn, t)
+	}
+	return c.generateExpressionAs(expr, ast.Int)
+}
+
+// truncateToByte converts an i32 operand to i8, as memset does with its
+// fill value.
+func (c *CodeGen) truncateToByte(value string) string {
+	if v, ok := constantValue(value); ok {
+		return fmt.Sprint(int8(v))
+	}
+	reg := c.nextReg()
+	c.emit("%%%d = trunc i32 %s to i8", reg, value)
+	return fmt.Sprintf("%%%d", reg)
+}
@@ -0,0 +1,50 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// TestMemIntrinsics checks that memcpy, memmove and memset become calls
+// to their intrinsics that run under lli, and that a program's own
+// memcpy is called like any other function
+func TestMemIntrinsics(t *testing.T) {
+	const src = `int main() {
+    int a[4];
+    int b[4];
+    memset(a, 0, 16);
+    a[1] = 5;
+    a[2] = 7;
+    memcpy(b, a, 16);
+    memset(a, 0, 16);
+    memmove(a, b, 8);
+    return a[1] * 10 + b[2] + a[2];
+}`
+	ir := generate(t, src, codegen.Options{})
+	for _, want := range []string{
+		"call void @llvm.memset.p0i8.i64(i8* align 16 %0, i8 0, i64 16, i1 false)",
+		"call void @llvm.memcpy.p0i8.p0i8.i64(i8* align 16 %1, i8* align 16 %2, i64 16, i1 false)",
+		"call void @llvm.memmove.p0i8.p0i8.i64(i8* align 16 %4, i8* align 16 %5, i64 8, i1 false)",
+		"declare void @llvm.memset.p0i8.i64(i8*, i8, i64, i1)",
+		"declare void @llvm.memcpy.p0i8.p0i8.i64(i8*, i8*, i64, i1)",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+	if strings.Count(ir, "declare void @llvm.memset") != 1 {
+		t.Errorf("memset declared more than once:\n%s", ir)
+	}
+
+	own := generate(t, "int memcpy(int a, int b, int n) { return n; } int main() { return memcpy(1, 2, 3); }", codegen.Options{})
+	if !strings.Contains(own, "call i32 @memcpy(i32 1, i32 2, i32 3)") || strings.Contains(own, "@llvm.memcpy") {
+		t.Errorf("the program's memcpy is not called:\n%s", own)
+	}
+
+	if status, _ := llitest.Run(t, ir); status != 57 {
+		t.Errorf("exit status %d, want 57", status)
+	}
+}
@@ -0,0 +1,242 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// LibcType is the C type of a libc parameter or result, as far as calls
+// from the supported subset are concerned.
+type LibcType int
+
+const (
+	LibcInt  LibcType = iota // int
+	LibcSize                 // size_t, whose width depends on the target
+	LibcPtr                  // any data pointer, passed as i8*
+	LibcVoid                 // no value; results only
+)
+
+// LibcFunction is the signature of a C library function that may be
+// called without a prototype.
+type LibcFunction struct {
+	Name     string
+	Result   LibcType
+	Params   []LibcType
+	Variadic bool
+	// Pointee is the type a LibcPtr result points to: char for strings,
+	// and void for memory and the FILE the subset cannot name.
+	Pointee *ast.Type
+}
+
+// libcFunctions are the C library functions with built-in signatures.
+var libcFunctions = map[string]*LibcFunction{}
+
+func init() {
+	for _, fn := range []*LibcFunction{
+		{Name: "printf", Result: LibcInt, Params: []LibcType{LibcPtr}, Variadic: true},
+		{Name: "sprintf", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}, Variadic: true},
+		{Name: "snprintf", Result: LibcInt, Params: []LibcType{LibcPtr, LibcSize, LibcPtr}, Variadic: true},
+		{Name: "scanf", Result: LibcInt, Params: []LibcType{LibcPtr}, Variadic: true},
+		{Name: "puts", Result: LibcInt, Params: []LibcType{LibcPtr}},
+		{Name: "putchar", Result: LibcInt, Params: []LibcType{LibcInt}},
+		{Name: "getchar", Result: LibcInt},
+		{Name: "gets", Result: LibcPtr, Params: []LibcType{LibcPtr}, Pointee: ast.Char},
+		{Name: "fgets", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcInt, LibcPtr}, Pointee: ast.Char},
+		{Name: "malloc", Result: LibcPtr, Params: []LibcType{LibcSize}, Pointee: ast.Void},
+		{Name: "calloc", Result: LibcPtr, Params: []LibcType{LibcSize, LibcSize}, Pointee: ast.Void},
+		{Name: "realloc", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcSize}, Pointee: ast.Void},
+		{Name: "free", Result: LibcVoid, Params: []LibcType{LibcPtr}},
+		{Name: "strlen", Result: LibcSize, Params: []LibcType{LibcPtr}},
+		{Name: "strcmp", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}},
+		{Name: "strncmp", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr, LibcSize}},
+		{Name: "strcpy", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr}, Pointee: ast.Char},
+		{Name: "strncpy", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr, LibcSize}, Pointee: ast.Char},
+		{Name: "strchr", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcInt}, Pointee: ast.Char},
+		{Name: "strrchr", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcInt}, Pointee: ast.Char},
+		{Name: "strstr", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr}, Pointee: ast.Char},
+		{Name: "strcat", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr}, Pointee: ast.Char},
+		{Name: "strncat", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr, LibcSize}, Pointee: ast.Char},
+		{Name: "memcpy", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr, LibcSize}, Pointee: ast.Void},
+		{Name: "memmove", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr, LibcSize}, Pointee: ast.Void},
+		{Name: "memset", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcInt, LibcSize}, Pointee: ast.Void},
+		{Name: "memcmp", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr, LibcSize}},
+		{Name: "atoi", Result: LibcInt, Params: []LibcType{LibcPtr}},
+		{Name: "abs", Result: LibcInt, Params: []LibcType{LibcInt}},
+		{Name: "rand", Result: LibcInt},
+		{Name: "srand", Result: LibcVoid, Params: []LibcType{LibcInt}},
+		{Name: "getenv", Result: LibcPtr, Params: []LibcType{LibcPtr}, Pointee: ast.Char},
+		{Name: "system", Result: LibcInt, Params: []LibcType{LibcPtr}},
+		{Name: "popen", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr}, Pointee: ast.Void},
+		{Name: "execl", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}, Variadic: true},
+		{Name: "execlp", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}, Variadic: true},
+		{Name: "execle", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}, Variadic: true},
+		{Name: "execv", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}},
+		{Name: "execvp", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}},
+		{Name: "execve", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr, LibcPtr}},
+		{Name: "access", Result: LibcInt, Params: []LibcType{LibcPtr, LibcInt}},
+		{Name: "stat", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}},
+		{Name: "lstat", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}},
+		{Name: "open", Result: LibcInt, Params: []LibcType{LibcPtr, LibcInt}, Variadic: true},
+		{Name: "creat", Result: LibcInt, Params: []LibcType{LibcPtr, LibcInt}},
+		{Name: "fopen", Result: LibcPtr, Params: []LibcType{LibcPtr, LibcPtr}, Pointee: ast.Void},
+		{Name: "chmod", Result: LibcInt, Params: []LibcType{LibcPtr, LibcInt}},
+		{Name: "chown", Result: LibcInt, Params: []LibcType{LibcPtr, LibcInt, LibcInt}},
+		{Name: "unlink", Result: LibcInt, Params: []LibcType{LibcPtr}},
+		{Name: "remove", Result: LibcInt, Params: []LibcType{LibcPtr}},
+		{Name: "rename", Result: LibcInt, Params: []LibcType{LibcPtr, LibcPtr}},
+		{Name: "exit", Result: LibcVoid, Params: []LibcType{LibcInt}},
+		{Name: "abort", Result: LibcVoid},
+	} {
+		libcFunctions[fn.Name] = fn
+	}
+}
+
+// LookupLibc returns the built-in signature of a C library function, or
+// nil if name is not one.
+func LookupLibc(name string) *LibcFunction {
+	return libcFunctions[name]
+}
+
+// LLVMType returns the LLVM spelling of t on target.
+func (t LibcType) LLVMType(target *Target) string {
+	switch t {
+	case LibcSize:
+		return fmt.Sprintf("i%d", target.PointerSize*8)
+	case LibcPtr:
+		return "i8*"
+	case LibcVoid:
+		return "void"
+	default:
+		return "i32"
+	}
+}
+
+// ResultType returns the type a call to fn has in the supported subset:
+// size_t results are long, which is as wide on every supported target,
+// and pointers point to Pointee.
+func (fn *LibcFunction) ResultType() *ast.Type {
+	switch fn.Result {
+	case LibcPtr:
+		return ast.PointerTo(fn.Pointee)
+	case LibcSize:
+		return ast.Long
+	}
+	return ast.Int
+}
+
+// Declaration returns the declare line for fn on target, marking it
+// dso_local when compiling without PIC as prototypes are.
+func (fn *LibcFunction) Declaration(target *Target, dsoLocal bool) string {
+	params := []string{}
+	for _, param := range fn.Params {
+		params = append(params, param.LLVMType(target))
+	}
+	if fn.Variadic {
+		params = append(params, "...")
+	}
+	preemption := ""
+	if dsoLocal {
+		preemption = "dso_local "
+	}
+	return fmt.Sprintf("declare %s%s @%s(%s)", preemption, fn.Result.LLVMType(target), fn.Name, strings.Join(params, ", "))
+}
+
+// libcCallee returns the built-in signature a call resolves to, or nil
+// when the callee is a local, a function the program declares, or not a
+// known library function.
+func (c *CodeGen) libcCallee(call *ast.CallExpr) *LibcFunction {
+	id, ok := call.Callee.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+	if _, local := c.variables[id.Name]; local {
+		return nil
+	}
+	if _, declared := c.functions[id.Name]; declared {
+		return nil
+	}
+	return libcFunctions[id.Name]
+}
+
+// generateLibcCall calls a C library function through its built-in
+// signature, declaring it on first use. Arguments are converted to the
+// parameter types: pointers are cast to i8* and ints widened to size_t.
+// The result is converted back to the subset's types, or is "" for void
+// functions.
+func (c *CodeGen) generateLibcCall(call *ast.CallExpr, fn *LibcFunction) (string, error) {
+	if len(call.Args) < len(fn.Params) || len(call.Args) > len(fn.Params) && !fn.Variadic {
+		return "", fmt.Errorf("call to %s expects %d arguments, got %d", fn.Name, len(fn.Params), len(call.Args))
+	}
+	c.declare(fn.Name, fn.Declaration(c.target, c.opts.PICLevel == 0))
+
+	args := []string{}
+	for i, arg := range call.Args {
+		t, err := c.typeOf(arg)
+		if err != nil {
+			return "", err
+		}
+		value, err := c.generateExpression(arg)
+		if err != nil {
+			return "", err
+		}
+		if i >= len(fn.Params) {
+			// Variadic arguments undergo the default argument promotions
+			promoted := t.Promote()
+			if t == ast.Float {
+				promoted = ast.Double
+			}
+			if value, err = c.convert(value, t, promoted); err != nil {
+				return "", err
+			}
+			args = append(args, c.llvmType(promoted)+" "+value)
+			continue
+		}
+		param := fn.Params[i]
+		switch {
+		case param == LibcPtr && t.Equal(ast.PointerTo(ast.Char)):
+			// Already an i8*
+		case param == LibcPtr && t.Kind == ast.PointerType:
+			castReg := c.nextReg()
+			c.emit("%%%d = bitcast %s %s to i8*", castReg, c.llvmType(t), value)
+			value = fmt.Sprintf("%%%d", castReg)
+		case param == LibcSize && t.IsArithmetic():
+			value, err = c.convert(value, t, c.sizeType())
+		case param == LibcInt && t.IsArithmetic():
+			value, err = c.convert(value, t, ast.Int)
+		default:
+			return "", fmt.Errorf("argument %d to %s has incompatible type %s", i+1, fn.Name, t)
+		}
+		if err != nil {
+			return "", err
+		}
+		args = append(args, param.LLVMType(c.target)+" "+value)
+	}
+
+	signature := fn.Result.LLVMType(c.target)
+	if fn.Variadic {
+		// Calls to variadic functions spell out the full function type
+		params := []string{}
+		for _, param := range fn.Params {
+			params = append(params, param.LLVMType(c.target))
+		}
+		signature += " (" + strings.Join(append(params, "..."), ", ") + ")"
+	}
+	if fn.Result == LibcVoid {
+		c.emit("call %s @%s(%s)", signature, fn.Name, strings.Join(args, ", "))
+		return "", nil
+	}
+	resultReg := c.nextNamedReg("call")
+	c.emit("%%%d = call %s @%s(%s)", resultReg, signature, fn.Name, strings.Join(args, ", "))
+	result := fmt.Sprintf("%%%d", resultReg)
+
+	if fn.Result == LibcPtr {
+		if t := c.llvmType(fn.ResultType()); t != "i8*" {
+			castReg := c.nextReg()
+			c.emit("%%%d = bitcast i8* %s to %s", castReg, result, t)
+			result = fmt.Sprintf("%%%d", castReg)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package codegen_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llirgen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// TestLibcPointerResults checks that the results of the C library have
+// the types C gives them with both backends: strings are char*, and the
+// void* of malloc converts to any data pointer
+func TestLibcPointerResults(t *testing.T) {
+	for _, src := range []string{
+		`int main() { char *e = getenv("HOME"); return e[0]; }`,
+		`int main() { char *p = malloc(8); p[0] = 1; free(p); return 0; }`,
+		`int main() { char *s = strchr("a=b", 61); return s[1]; }`,
+		`int main() { int *n = calloc(4, 4); n = realloc(n, 32); free(n); return 0; }`,
+		`int *alloc() { return malloc(4); }`,
+	} {
+		program, err := parser.New(lexer.New(src)).ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if _, err := codegen.NewWithOptions(codegen.Options{}).Generate(program); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+		if _, err := llirgen.New(codegen.Options{}).Generate(program); err != nil {
+			t.Errorf("%s: llirgen: %v", src, err)
+		}
+	}
+
+	program, err := parser.New(lexer.New(`int main() { int *p = getenv("HOME"); return 0; }`)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = codegen.NewWithOptions(codegen.Options{}).Generate(program)
+	if err == nil || !strings.Contains(err.Error(), "incompatible pointer types converting char* to int*") {
+		t.Errorf("assigning getenv to int*: got %v, want incompatible pointer types", err)
+	}
+}
+
+// TestNullComparison checks that a pointer compares with the constant 0
+// as with null, on either side of ==, with both backends
+func TestNullComparison(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{`int main() { int *q = malloc(4); if (q == 0) { return 1; } q[0] = 5; free(q); return 0; }`, "icmp eq i32* "},
+		{`int main() { char *e = getenv("HOME"); if (0 == e) { return 1; } return e[0]; }`, "icmp eq i8* "},
+	} {
+		program, err := parser.New(lexer.New(test.src)).ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		ir, err := codegen.NewWithOptions(codegen.Options{}).Generate(program)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		} else if !strings.Contains(ir, test.want) || !strings.Contains(ir, ", null\n") {
+			t.Errorf("%s: the IR has no %s..., null:\n%s", test.src, test.want, ir)
+		}
+		if _, err := llirgen.New(codegen.Options{}).Generate(program); err != nil {
+			t.Errorf("%s: llirgen: %v", test.src, err)
+		}
+	}
+
+	program, err := parser.New(lexer.New(`int main() { int *q = malloc(4); return q == 1; }`)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codegen.NewWithOptions(codegen.Options{}).Generate(program); err == nil {
+		t.Error("comparing a pointer with 1: got no error")
+	}
+}
+
+// TestLibcSizeResults checks that size_t results are long, as wide as
+// size_t on the target, so that the result of strlen is stored in a long
+// as it is instead of truncated to int and extended back
+func TestLibcSizeResults(t *testing.T) {
+	const src = `int main() { long n = strlen("hello"); return n; }`
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for target, size := range map[string]string{"x86_64-pc-linux-gnu": "i64", "wasm32-unknown-unknown": "i32"} {
+		stored := regexp.MustCompile(`(%\S+) = call ` + size + ` @strlen\(.*\)\n\s*store ` + size + ` (%\S+),`)
+		opts := codegen.Options{Target: target}
+		for backend, gen := range map[string]codegen.Backend{"codegen": codegen.NewWithOptions(opts), "llirgen": llirgen.New(opts)} {
+			ir, err := gen.Generate(program)
+			if err != nil {
+				t.Fatalf("%s for %s: %v", backend, target, err)
+			}
+			if m := stored.FindStringSubmatch(ir); m == nil || m[1] != m[2] {
+				t.Errorf("%s for %s: the %s result of strlen is not stored as it is:\n%s", backend, target, size, ir)
+			}
+		}
+	}
+	if got := runProgram(t, src, codegen.Options{}); got != 5 {
+		t.Errorf("exit status %d, want 5", got)
+	}
+}
@@ -0,0 +1,49 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// StringBytes returns the bytes a string literal denotes, including the
+// terminating NUL.
+func StringBytes(lit *ast.StringLiteral) (string, error) {
+	text, err := unescapeC(lit.Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid string literal: %v", err)
+	}
+	return text + "\x00", nil
+}
+
+// stringGlobal returns the private constant holding the NUL-terminated
+// bytes of a string literal, defining it on first use. Identical literals
+// share one constant, named like clang's: @.str, @.str.1, ...
+func (c *CodeGen) stringGlobal(lit *ast.StringLiteral) (string, int, error) {
+	text, err := StringBytes(lit)
+	if err != nil {
+		return "", 0, err
+	}
+	if name, ok := c.stringGlobals[text]; ok {
+		return name, len(text), nil
+	}
+	name := "@.str"
+	if n := len(c.stringGlobals); n > 0 {
+		name = fmt.Sprintf("@.str.%d", n)
+	}
+	c.stringGlobals[text] = name
+	c.globals = append(c.globals, fmt.Sprintf("%s = private unnamed_addr constant [%d x i8] c%s, align 1", name, len(text), quoteLLVM(text)))
+	return name, len(text), nil
+}
+
+// generateStringLiteral returns a char* to the first byte of a string
+// literal's constant.
+func (c *CodeGen) generateStringLiteral(lit *ast.StringLiteral) (string, error) {
+	name, n, err := c.stringGlobal(lit)
+	if err != nil {
+		return "", err
+	}
+	reg := c.nextNamedReg("arraydecay")
+	c.emit("%%%d = getelementptr inbounds [%d x i8], [%d x i8]* %s, i64 0, i64 0", reg, n, n, name)
+	return fmt.Sprintf("%%%d", reg), nil
+}
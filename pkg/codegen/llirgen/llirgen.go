@@ -0,0 +1,1147 @@
+// Package llirgen lowers programs to LLVM IR by constructing the module in
+// memory with github.com/llir/llvm rather than by concatenating strings.
+// It covers the core language; the runtime checks and hardening options
+// are only implemented by the textual backend in package codegen.
+package llirgen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/metadata"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// local is a stack slot holding a parameter or local variable.
+type local struct {
+	addr value.Value
+	typ  *ast.Type
+}
+
+// Generator is a codegen.Backend built on llir/llvm.
+type Generator struct {
+	opts   codegen.Options
+	target *codegen.Target
+	module *ir.Module
+	funcs  map[string]*ir.Func
+	sigs   map[string]*ast.Function
+	strs   map[string]*ir.Global // string literal bytes to their constant
+
+	// State of the function being generated
+	source   *ast.Function
+	fn       *ir.Func
+	block    *ir.Block // nil after a terminator, until code needs a block
+	retSlot  value.Value
+	retBlock *ir.Block
+	vars     map[string]*local
+	breaks   []*ir.Block // blocks break jumps to, innermost last
+}
+
+var _ codegen.Backend = (*Generator)(nil)
+
+func New(opts codegen.Options) *Generator {
+	return &Generator{opts: opts}
+}
+
+// Generate lowers program and returns the module's textual IR.
+func (g *Generator) Generate(program *ast.Program) (string, error) {
+	m, err := g.Module(program)
+	if err != nil {
+		return "", err
+	}
+	return m.String(), nil
+}
+
+// GenerateTo lowers program and writes the module's textual IR to w.
+func (g *Generator) GenerateTo(w io.Writer, program *ast.Program) error {
+	m, err := g.Module(program)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := m.WriteTo(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Module lowers program to an llir module, for callers that want to
+// inspect or transform it programmatically.
+func (g *Generator) Module(program *ast.Program) (_ *ir.Module, err error) {
+	defer diag.Recover("codegen", &err)
+	if err := g.checkOptions(); err != nil {
+		return nil, &codegen.BackendError{Err: err}
+	}
+
+	target, err := codegen.LookupTarget(g.opts.Target)
+	if err != nil {
+		return nil, &codegen.BackendError{Err: err}
+	}
+	if err := codegen.CheckTargetOptions(target, g.opts); err != nil {
+		return nil, &codegen.BackendError{Err: err}
+	}
+	g.target = target
+
+	g.module = ir.NewModule()
+	g.module.DataLayout = target.DataLayout
+	g.module.TargetTriple = target.Triple
+	g.funcs = make(map[string]*ir.Func)
+	g.sigs = make(map[string]*ast.Function)
+	g.strs = make(map[string]*ir.Global)
+
+	// Declare every function first so calls can refer to later ones
+	for _, fn := range program.Functions {
+		if err := codegen.CheckSymbolAttributes(fn); err != nil {
+			return nil, err
+		}
+		if prev, ok := g.sigs[fn.Name]; ok {
+			if !prev.Signature().Equal(fn.Signature()) {
+				return nil, fmt.Errorf("conflicting types for %s", fn.Name)
+			}
+			if prev.Body != nil && fn.Body != nil {
+				return nil, fmt.Errorf("redefinition of %s", fn.Name)
+			}
+			if fn.Body == nil {
+				continue
+			}
+			g.sigs[fn.Name] = fn
+			g.funcs[fn.Name].SetName(codegen.SymbolName(fn, g.opts))
+			continue
+		}
+		g.sigs[fn.Name] = fn
+		params := []*ir.Param{}
+		for _, param := range fn.Params {
+			params = append(params, ir.NewParam(param.Name, g.lltype(param.Type)))
+		}
+		f := g.module.NewFunc(codegen.SymbolName(fn, g.opts), g.lltype(fn.ReturnType), params...)
+		f.CallingConv = callingConv(codegen.CallingConv(fn, g.opts))
+		if codegen.DSOLocal(fn, g.opts) {
+			f.Preemption = enum.PreemptionDSOLocal
+		}
+		f.Visibility = visibility(codegen.Visibility(fn, g.opts))
+		g.funcs[fn.Name] = f
+	}
+
+	for _, fn := range program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		if err := codegen.Canceled(g.opts); err != nil {
+			return nil, err
+		}
+		// The definition may differ from an earlier prototype in all of these
+		f := g.funcs[fn.Name]
+		if codegen.Linkage(fn, g.opts) == "internal" {
+			f.Linkage = enum.LinkageInternal
+		}
+		f.Preemption = enum.PreemptionNone
+		if codegen.DSOLocal(fn, g.opts) {
+			f.Preemption = enum.PreemptionDSOLocal
+		}
+		f.Visibility = visibility(codegen.Visibility(fn, g.opts))
+		f.Section = codegen.Section(fn)
+		if g.opts.FramePointer != "" {
+			f.FuncAttrs = append(f.FuncAttrs, ir.AttrPair{Key: "frame-pointer", Value: g.opts.FramePointer})
+		}
+		if attr, ok := stackProtectors[g.opts.StackProtector]; ok {
+			f.FuncAttrs = append(f.FuncAttrs, attr)
+		}
+		if name := codegen.WasmExportName(fn, g.opts); target.IsWasm() && name != "" {
+			f.FuncAttrs = append(f.FuncAttrs, ir.AttrPair{Key: "wasm-export-name", Value: name})
+		}
+		if err := g.generateFunction(fn); err != nil {
+			return nil, err
+		}
+	}
+
+	g.addModuleMetadata()
+	if err := g.module.AssignMetadataIDs(); err != nil {
+		return nil, err
+	}
+	return g.module, nil
+}
+
+// addModuleMetadata records the same module flags, producer and version
+// stamp as the textual backend.
+func (g *Generator) addModuleMetadata() {
+	named := func(name string, tuple *metadata.Tuple) {
+		def, ok := g.module.NamedMetadataDefs[name]
+		if !ok {
+			def = &metadata.NamedDef{Name: name}
+			g.module.NamedMetadataDefs[name] = def
+		}
+		def.Nodes = append(def.Nodes, tuple)
+		g.module.MetadataDefs = append(g.module.MetadataDefs, tuple)
+	}
+	tuple := func(fields ...metadata.Field) *metadata.Tuple {
+		return &metadata.Tuple{MetadataID: -1, Fields: fields}
+	}
+
+	for _, flag := range codegen.ModuleFlags(g.opts) {
+		named("llvm.module.flags", tuple(
+			constant.NewInt(types.I32, int64(flag.Behavior)),
+			&metadata.String{Value: flag.Key},
+			constant.NewInt(types.I32, int64(flag.Value)),
+		))
+	}
+	named("llvm.ident", tuple(&metadata.String{Value: codegen.Ident(g.opts)}))
+	named("citadel.version", tuple(&metadata.String{Value: codegen.Version}))
+}
+
+// checkOptions rejects options this backend does not implement.
+func (g *Generator) checkOptions() error {
+	unsupported := []struct {
+		set  bool
+		name string
+	}{
+		{g.opts.SourceComments, "source comments"},
+		{g.opts.StackUsage, "stack usage annotations"},
+		{g.opts.SafeStack, "safestack"},
+		{g.opts.ShadowCallStack, "shadow-call-stack"},
+		{g.opts.SanitizeAddress || g.opts.SanitizeMemory || g.opts.SanitizeThread, "sanitizers"},
+		{g.opts.CFI, "cfi"},
+		{g.opts.BoundsChecks, "bounds-checks"},
+		{g.opts.OverflowChecks, "overflow-checks"},
+		{g.opts.DivisionChecks, "div-checks"},
+		{g.opts.OptLevel > 0, "optimization"},
+		{len(g.opts.Obfuscate) > 0, "obfuscation"},
+	}
+	for _, opt := range unsupported {
+		if opt.set {
+			return fmt.Errorf("the llir backend does not support %s", opt.name)
+		}
+	}
+	return nil
+}
+
+// stackProtectors maps the values of Options.StackProtector to their
+// llir enums.
+var stackProtectors = map[string]enum.FuncAttr{
+	"ssp":       enum.FuncAttrSSP,
+	"sspstrong": enum.FuncAttrSSPStrong,
+	"sspreq":    enum.FuncAttrSSPReq,
+}
+
+// callingConv maps a calling convention name to its llir enum, leaving
+// the C convention implicit.
+func callingConv(name string) enum.CallingConv {
+	if name == "fastcc" {
+		return enum.CallingConvFast
+	}
+	return enum.CallingConvNone
+}
+
+func visibility(name string) enum.Visibility {
+	switch name {
+	case "hidden":
+		return enum.VisibilityHidden
+	case "protected":
+		return enum.VisibilityProtected
+	}
+	return enum.VisibilityNone
+}
+
+// lltype returns the llir type for a C type on the target.
+func (g *Generator) lltype(t *ast.Type) types.Type {
+	switch t.Kind {
+	case ast.PointerType:
+		return types.NewPointer(g.lltype(t.Elem))
+	case ast.ArrayType:
+		return types.NewArray(uint64(t.Len), g.lltype(t.Elem))
+	case ast.FuncType:
+		params := []types.Type{}
+		for _, param := range t.Params {
+			params = append(params, g.lltype(param))
+		}
+		return types.NewFunc(g.lltype(t.Elem), params...)
+	}
+	switch t.Name {
+	case "float":
+		return types.Float
+	case "double":
+		return types.Double
+	default:
+		return types.NewInt(uint64(g.target.SizeOf(t) * 8))
+	}
+}
+
+func (g *Generator) generateFunction(fn *ast.Function) error {
+	g.source = fn
+	g.fn = g.funcs[fn.Name]
+	g.vars = make(map[string]*local)
+
+	entry := g.fn.NewBlock("")
+	g.block = entry
+	g.retBlock = ir.NewBlock("")
+	g.retSlot = entry.NewAlloca(g.lltype(fn.ReturnType))
+
+	for i, param := range fn.Params {
+		addr := entry.NewAlloca(g.lltype(param.Type))
+		g.vars[param.Name] = &local{addr: addr, typ: param.Type}
+		entry.NewStore(g.fn.Params[i], addr)
+	}
+
+	if err := g.generateBlock(fn.Body); err != nil {
+		return err
+	}
+
+	// Falling off the end returns whatever is in the return slot
+	if g.block != nil {
+		g.block.NewBr(g.retBlock)
+	}
+	g.retBlock.Parent = g.fn
+	g.fn.Blocks = append(g.fn.Blocks, g.retBlock)
+	g.retBlock.NewRet(g.retBlock.NewLoad(g.lltype(fn.ReturnType), g.retSlot))
+	return nil
+}
+
+// current returns the block to append to, starting an unreachable one if
+// the previous block was terminated.
+func (g *Generator) current() *ir.Block {
+	if g.block == nil {
+		g.block = g.fn.NewBlock("")
+	}
+	return g.block
+}
+
+// startBlock continues code generation in b, falling through from the
+// current block if it has no terminator.
+func (g *Generator) startBlock(b *ir.Block) {
+	if g.block != nil {
+		g.block.NewBr(b)
+	}
+	b.Parent = g.fn
+	g.fn.Blocks = append(g.fn.Blocks, b)
+	g.block = b
+}
+
+func (g *Generator) generateBlock(block *ast.Block) error {
+	for _, stmt := range block.Statements {
+		if err := g.generateStatement(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) generateStatement(stmt ast.Statement) error {
+	switch s := stmt.(type) {
+	case *ast.VarDecl:
+		addr := g.fn.Blocks[0].NewAlloca(g.lltype(s.Type))
+		// Keep allocas ahead of the entry block's other instructions
+		entry := g.fn.Blocks[0]
+		entry.Insts = append([]ir.Instruction{addr}, entry.Insts[:len(entry.Insts)-1]...)
+		g.vars[s.Name] = &local{addr: addr, typ: s.Type}
+		if s.Value != nil {
+			if s.Type.Kind == ast.ArrayType {
+				return fmt.Errorf("array initializers are not supported: %s", s.Name)
+			}
+			v, err := g.generateValue(s.Value, s.Type)
+			if err != nil {
+				return err
+			}
+			g.current().NewStore(v, addr)
+		}
+		return nil
+	case *ast.IfStatement:
+		return g.generateIf(s)
+	case *ast.WhileStatement:
+		return g.generateWhile(s)
+	case *ast.SwitchStatement:
+		return g.generateSwitch(s)
+	case *ast.BreakStatement:
+		if len(g.breaks) == 0 {
+			return fmt.Errorf("break statement not within a loop or switch")
+		}
+		g.current().NewBr(g.breaks[len(g.breaks)-1])
+		g.block = nil
+		return nil
+	case *ast.ReturnStatement:
+		v, err := g.generateValue(s.Value, g.source.ReturnType)
+		if err != nil {
+			return err
+		}
+		g.current().NewStore(v, g.retSlot)
+		g.block.NewBr(g.retBlock)
+		g.block = nil
+		return nil
+	case *ast.ExprStatement:
+		// The result of a call may be discarded, even one of a void function
+		if call, ok := s.Expr.(*ast.CallExpr); ok {
+			_, err := g.generateCall(call)
+			return err
+		}
+		_, err := g.generateExpression(s.Expr)
+		return err
+	case *ast.AsmStatement:
+		if g.target.IsWasm() {
+			return fmt.Errorf("inline assembly is not supported on %s", g.target.Triple)
+		}
+		template, err := codegen.AsmTemplate(s.Template)
+		if err != nil {
+			return fmt.Errorf("invalid asm statement: %v", err)
+		}
+		asm := ir.NewInlineAsm(types.NewPointer(types.NewFunc(types.Void)), template, codegen.AsmConstraints(g.module.TargetTriple))
+		asm.SideEffect = true
+		g.current().NewCall(asm)
+		return nil
+	default:
+		return fmt.Errorf("unknown statement type")
+	}
+}
+
+// generateSwitch lowers a switch statement like the textual backend: one
+// block per case label, each falling through into the next.
+func (g *Generator) generateSwitch(stmt *ast.SwitchStatement) error {
+	tag, err := g.generateValue(stmt.Tag, ast.Int)
+	if err != nil {
+		return err
+	}
+
+	blocks := make([]*ir.Block, len(stmt.Cases))
+	endBlock := ir.NewBlock("")
+	var defaultBlock *ir.Block
+	cases := []*ir.Case{}
+	seen := map[int]bool{}
+	for i, cs := range stmt.Cases {
+		blocks[i] = ir.NewBlock("")
+		if cs.Value == nil {
+			if defaultBlock != nil {
+				return fmt.Errorf("multiple default labels in one switch")
+			}
+			defaultBlock = blocks[i]
+			continue
+		}
+		value, err := codegen.CaseConstant(cs.Value)
+		if err != nil {
+			return err
+		}
+		if seen[value] {
+			return fmt.Errorf("duplicate case value %d", value)
+		}
+		seen[value] = true
+		cases = append(cases, ir.NewCase(constant.NewInt(types.I32, int64(value)), blocks[i]))
+	}
+	if defaultBlock == nil {
+		defaultBlock = endBlock
+	}
+	g.current().NewSwitch(tag, defaultBlock, cases...)
+	g.block = nil
+
+	g.breaks = append(g.breaks, endBlock)
+	for i, cs := range stmt.Cases {
+		g.startBlock(blocks[i])
+		if err := g.generateBlock(&ast.Block{Statements: cs.Body}); err != nil {
+			return err
+		}
+	}
+	g.breaks = g.breaks[:len(g.breaks)-1]
+	g.startBlock(endBlock)
+	return nil
+}
+
+func (g *Generator) generateIf(stmt *ast.IfStatement) error {
+	cond, err := g.generateExpression(stmt.Condition)
+	if err != nil {
+		return err
+	}
+	cond = g.toBool(cond)
+
+	thenBlock := ir.NewBlock("")
+	endBlock := ir.NewBlock("")
+	elseBlock := endBlock
+	if stmt.ElseBlock != nil {
+		elseBlock = ir.NewBlock("")
+	}
+	g.current().NewCondBr(cond, thenBlock, elseBlock)
+	g.block = nil
+
+	g.startBlock(thenBlock)
+	if err := g.generateBlock(stmt.ThenBlock); err != nil {
+		return err
+	}
+	if stmt.ElseBlock != nil {
+		if g.block != nil {
+			g.block.NewBr(endBlock)
+			g.block = nil
+		}
+		g.startBlock(elseBlock)
+		if err := g.generateBlock(stmt.ElseBlock); err != nil {
+			return err
+		}
+	}
+	g.startBlock(endBlock)
+	return nil
+}
+
+// generateWhile tests the condition in a block of its own, which the end
+// of the body branches back to.
+func (g *Generator) generateWhile(stmt *ast.WhileStatement) error {
+	condBlock := ir.NewBlock("")
+	bodyBlock := ir.NewBlock("")
+	endBlock := ir.NewBlock("")
+	g.startBlock(condBlock)
+	cond, err := g.generateExpression(stmt.Condition)
+	if err != nil {
+		return err
+	}
+	g.current().NewCondBr(g.toBool(cond), bodyBlock, endBlock)
+	g.block = nil
+
+	g.breaks = append(g.breaks, endBlock)
+	g.startBlock(bodyBlock)
+	if err := g.generateBlock(stmt.Body); err != nil {
+		return err
+	}
+	g.breaks = g.breaks[:len(g.breaks)-1]
+	if g.block != nil {
+		g.block.NewBr(condBlock)
+		g.block = nil
+	}
+	g.startBlock(endBlock)
+	return nil
+}
+
+// toBool converts a scalar to i1 by comparing it against zero.
+func (g *Generator) toBool(v value.Value) value.Value {
+	if v.Type().Equal(types.I1) {
+		return v
+	}
+	switch t := v.Type().(type) {
+	case *types.PointerType:
+		return g.current().NewICmp(enum.IPredNE, v, constant.NewNull(t))
+	case *types.FloatType:
+		return g.current().NewFCmp(enum.FPredUNE, v, constant.NewFloat(t, 0))
+	case *types.IntType:
+		return g.current().NewICmp(enum.IPredNE, v, constant.NewInt(t, 0))
+	}
+	return v
+}
+
+// generateValue evaluates expr as a value of type t, applying the implicit
+// conversions of assignment, argument passing and return.
+func (g *Generator) generateValue(expr ast.Expression, t *ast.Type) (value.Value, error) {
+	v, err := g.generateExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return g.convert(v, g.lltype(t))
+}
+
+// convert converts v to type to like the textual backend: between integer
+// widths with sext and trunc, between integers and floating types with
+// sitofp and fptosi, or zext, uitofp and fptoui for the i8 of char where
+// it is unsigned, and between float and double with fpext and fptrunc.
+// Pointers only convert to the same pointer type, or from the constant 0
+// or the i8* void* results are.
+func (g *Generator) convert(v value.Value, to types.Type) (value.Value, error) {
+	v = g.widen(v)
+	from := v.Type()
+	if from.Equal(to) {
+		return v, nil
+	}
+	if ptr, ok := to.(*types.PointerType); ok {
+		if c, ok := v.(*constant.Int); ok && c.X.Sign() == 0 {
+			return constant.NewNull(ptr), nil
+		}
+		if from.Equal(types.NewPointer(types.I8)) {
+			// void*, as the C library returns it, converts to any pointer
+			return g.current().NewBitCast(v, ptr), nil
+		}
+		return nil, fmt.Errorf("incompatible conversion from %s to %s", from, to)
+	}
+
+	b := g.current()
+	switch from := from.(type) {
+	case *types.IntType:
+		// i8 is plain char, unsigned on some targets
+		unsigned := from.BitSize == 8 && g.target.UnsignedChar
+		switch to := to.(type) {
+		case *types.IntType:
+			if to.BitSize > from.BitSize && unsigned {
+				return b.NewZExt(v, to), nil
+			}
+			if to.BitSize > from.BitSize {
+				return b.NewSExt(v, to), nil
+			}
+			return b.NewTrunc(v, to), nil
+		case *types.FloatType:
+			if unsigned {
+				return b.NewUIToFP(v, to), nil
+			}
+			return b.NewSIToFP(v, to), nil
+		}
+	case *types.FloatType:
+		switch to := to.(type) {
+		case *types.IntType:
+			if to.BitSize == 8 && g.target.UnsignedChar {
+				return b.NewFPToUI(v, to), nil
+			}
+			return b.NewFPToSI(v, to), nil
+		case *types.FloatType:
+			if to.Kind == types.FloatKindDouble {
+				return b.NewFPExt(v, to), nil
+			}
+			return b.NewFPTrunc(v, to), nil
+		}
+	}
+	return nil, fmt.Errorf("incompatible conversion from %s to %s", from, to)
+}
+
+// commonType returns the type the usual arithmetic conversions bring two
+// operands to: the wider floating type if either is floating, otherwise
+// the wider integer type but at least int.
+// nullComparison compares a pointer with null if op compares it with the
+// constant 0, as the textual backend does.
+func (g *Generator) nullComparison(op *ast.BinaryOp, left, right value.Value) (value.Value, bool) {
+	if op.Operator != "==" {
+		return nil, false
+	}
+	if _, ok := left.Type().(*types.PointerType); !ok {
+		left, right = right, left
+	}
+	ptr, ok := left.Type().(*types.PointerType)
+	if c, zero := right.(*constant.Int); !ok || !zero || c.X.Sign() != 0 {
+		return nil, false
+	}
+	return g.current().NewICmp(enum.IPredEQ, left, constant.NewNull(ptr)), true
+}
+
+func commonType(a, b types.Type) (types.Type, error) {
+	for _, t := range []types.Type{a, b} {
+		switch t.(type) {
+		case *types.IntType, *types.FloatType:
+		default:
+			return nil, fmt.Errorf("invalid operands of types %s and %s", a, b)
+		}
+	}
+	if a.Equal(types.Double) || b.Equal(types.Double) {
+		return types.Double, nil
+	}
+	if a.Equal(types.Float) || b.Equal(types.Float) {
+		return types.Float, nil
+	}
+	common := types.I32
+	for _, t := range []types.Type{a, b} {
+		if t := t.(*types.IntType); t.BitSize > common.BitSize {
+			common = t
+		}
+	}
+	return common, nil
+}
+
+func (g *Generator) generateExpression(expr ast.Expression) (value.Value, error) {
+	switch e := expr.(type) {
+	case *ast.IntLiteral:
+		// As in C, a literal too large for int is a long
+		if int64(int32(e.Value)) != int64(e.Value) {
+			return constant.NewInt(types.I64, int64(e.Value)), nil
+		}
+		return constant.NewInt(types.I32, int64(e.Value)), nil
+	case *ast.StringLiteral:
+		return g.stringLiteral(e)
+	case *ast.Identifier:
+		if v, ok := g.vars[e.Name]; ok {
+			if v.typ.Kind == ast.ArrayType {
+				return g.elementAddress(v.addr, v.typ, constant.NewInt(types.I64, 0)), nil
+			}
+			return g.current().NewLoad(g.lltype(v.typ), v.addr), nil
+		}
+		if fn, ok := g.funcs[e.Name]; ok {
+			return fn, nil
+		}
+		return nil, fmt.Errorf("undefined variable: %s", e.Name)
+	case *ast.BinaryOp:
+		return g.generateBinaryOp(e)
+	case *ast.UnaryOp:
+		v, err := g.generateExpression(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Operator {
+		case "-":
+			t, err := commonType(g.widen(v).Type(), types.I32)
+			if err != nil {
+				return nil, err
+			}
+			if v, err = g.convert(v, t); err != nil {
+				return nil, err
+			}
+			if _, ok := t.(*types.FloatType); ok {
+				return g.current().NewFNeg(v), nil
+			}
+			return g.current().NewSub(constant.NewInt(t.(*types.IntType), 0), v), nil
+		case "*":
+			ptr, ok := v.Type().(*types.PointerType)
+			if !ok {
+				return nil, fmt.Errorf("cannot dereference %s", e.Operand)
+			}
+			if _, isFunc := ptr.ElemType.(*types.FuncType); isFunc {
+				return v, nil
+			}
+			return g.current().NewLoad(ptr.ElemType, v), nil
+		}
+		return nil, fmt.Errorf("unsupported operator: %s", e.Operator)
+	case *ast.IndexExpr:
+		addr, t, err := g.address(e)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == ast.ArrayType {
+			return g.elementAddress(addr, t, constant.NewInt(types.I64, 0)), nil
+		}
+		return g.current().NewLoad(g.lltype(t), addr), nil
+	case *ast.Assignment:
+		addr, t, err := g.address(e.Target)
+		if err != nil {
+			return nil, err
+		}
+		v, err := g.generateValue(e.Value, t)
+		if err != nil {
+			return nil, err
+		}
+		g.current().NewStore(v, addr)
+		return v, nil
+	case *ast.CallExpr:
+		v, err := g.generateCall(e)
+		if err == nil && v.Type().Equal(types.Void) {
+			return nil, fmt.Errorf("void value of call to %s used", e.Callee)
+		}
+		return v, err
+	default:
+		return nil, fmt.Errorf("unknown expression type")
+	}
+}
+
+// stringLiteral returns a char* to a private constant holding the bytes
+// of lit, shared between identical literals.
+func (g *Generator) stringLiteral(lit *ast.StringLiteral) (value.Value, error) {
+	text, err := codegen.StringBytes(lit)
+	if err != nil {
+		return nil, err
+	}
+	global, ok := g.strs[text]
+	if !ok {
+		init := constant.NewCharArrayFromString(text)
+		name := ".str"
+		if n := len(g.strs); n > 0 {
+			name = fmt.Sprintf(".str.%d", n)
+		}
+		global = g.module.NewGlobalDef(name, init)
+		global.Linkage = enum.LinkagePrivate
+		global.UnnamedAddr = enum.UnnamedAddrUnnamedAddr
+		global.Immutable = true
+		global.Align = 1
+		g.strs[text] = global
+	}
+	zero := constant.NewInt(types.I64, 0)
+	gep := constant.NewGetElementPtr(global.ContentType, global, zero, zero)
+	gep.InBounds = true
+	return gep, nil
+}
+
+// widen zero-extends comparison results so they can be used as int.
+func (g *Generator) widen(v value.Value) value.Value {
+	if v.Type().Equal(types.I1) {
+		return g.current().NewZExt(v, types.I32)
+	}
+	return v
+}
+
+// generateLogical lowers && and || with short-circuit evaluation, like
+// the textual backend.
+func (g *Generator) generateLogical(op *ast.BinaryOp) (value.Value, error) {
+	left, err := g.generateExpression(op.Left)
+	if err != nil {
+		return nil, err
+	}
+	cond := g.toBool(left)
+	leftBlock := g.current()
+	rhsBlock := ir.NewBlock("")
+	endBlock := ir.NewBlock("")
+	decided := constant.False
+	if op.Operator == "&&" {
+		leftBlock.NewCondBr(cond, rhsBlock, endBlock)
+	} else {
+		decided = constant.True
+		leftBlock.NewCondBr(cond, endBlock, rhsBlock)
+	}
+	g.block = nil
+
+	g.startBlock(rhsBlock)
+	right, err := g.generateExpression(op.Right)
+	if err != nil {
+		return nil, err
+	}
+	right = g.toBool(right)
+	rightBlock := g.current()
+
+	g.startBlock(endBlock)
+	return endBlock.NewPhi(ir.NewIncoming(decided, leftBlock), ir.NewIncoming(right, rightBlock)), nil
+}
+
+func (g *Generator) generateBinaryOp(op *ast.BinaryOp) (value.Value, error) {
+	if op.Operator == "&&" || op.Operator == "||" {
+		return g.generateLogical(op)
+	}
+
+	left, err := g.generateExpression(op.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := g.generateExpression(op.Right)
+	if err != nil {
+		return nil, err
+	}
+	if cmp, ok := g.nullComparison(op, left, right); ok {
+		return cmp, nil
+	}
+	left, right = g.widen(left), g.widen(right)
+	t, err := commonType(left.Type(), right.Type())
+	if err != nil {
+		return nil, err
+	}
+	if left, err = g.convert(left, t); err != nil {
+		return nil, err
+	}
+	if right, err = g.convert(right, t); err != nil {
+		return nil, err
+	}
+
+	b := g.current()
+	if _, ok := t.(*types.FloatType); ok {
+		switch op.Operator {
+		case "==":
+			return b.NewFCmp(enum.FPredOEQ, left, right), nil
+		case ">":
+			return b.NewFCmp(enum.FPredOGT, left, right), nil
+		case "<":
+			return b.NewFCmp(enum.FPredOLT, left, right), nil
+		case "+":
+			return b.NewFAdd(left, right), nil
+		case "-":
+			return b.NewFSub(left, right), nil
+		case "*":
+			return b.NewFMul(left, right), nil
+		case "/":
+			return b.NewFDiv(left, right), nil
+		case "%":
+			return b.NewFRem(left, right), nil
+		}
+	}
+	switch op.Operator {
+	case "==":
+		return b.NewICmp(enum.IPredEQ, left, right), nil
+	case ">":
+		return b.NewICmp(enum.IPredSGT, left, right), nil
+	case "<":
+		return b.NewICmp(enum.IPredSLT, left, right), nil
+	case "+":
+		return b.NewAdd(left, right), nil
+	case "-":
+		return b.NewSub(left, right), nil
+	case "*":
+		return b.NewMul(left, right), nil
+	case "/":
+		return b.NewSDiv(left, right), nil
+	case "%":
+		return b.NewSRem(left, right), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", op.Operator)
+	}
+}
+
+// address returns the address of an lvalue and the type stored there.
+func (g *Generator) address(expr ast.Expression) (value.Value, *ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		v, ok := g.vars[e.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("undefined variable: %s", e.Name)
+		}
+		return v.addr, v.typ, nil
+	case *ast.IndexExpr:
+		// Indexes are i64, so that a long one is not truncated
+		index, err := g.generateExpression(e.Index)
+		if err != nil {
+			return nil, nil, err
+		}
+		if index, err = g.convert(index, types.I64); err != nil {
+			return nil, nil, err
+		}
+
+		// Arrays are indexed in place; anything else through a pointer
+		if base, t, err := g.address(e.Array); err == nil && t.Kind == ast.ArrayType {
+			return g.elementAddress(base, t, index), t.Elem, nil
+		}
+		ptr, err := g.generateExpression(e.Array)
+		if err != nil {
+			return nil, nil, err
+		}
+		ptrType, ok := ptr.Type().(*types.PointerType)
+		if !ok {
+			return nil, nil, fmt.Errorf("subscripted value %s is not an array or pointer", e.Array)
+		}
+		gep := g.current().NewGetElementPtr(ptrType.ElemType, ptr, index)
+		gep.InBounds = true
+		elem, err := g.elementType(e.Array)
+		return gep, elem, err
+	case *ast.UnaryOp:
+		if e.Operator == "*" {
+			ptr, err := g.generateExpression(e.Operand)
+			if err != nil {
+				return nil, nil, err
+			}
+			elem, err := g.elementType(e.Operand)
+			return ptr, elem, err
+		}
+	}
+	return nil, nil, fmt.Errorf("expression is not assignable: %s", parser.FormatExpr(expr))
+}
+
+// elementType returns the C type pointed to by the pointer expression expr.
+func (g *Generator) elementType(expr ast.Expression) (*ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if v, ok := g.vars[e.Name]; ok && v.typ.Kind != ast.BasicType {
+			return v.typ.Elem, nil
+		}
+	case *ast.IndexExpr:
+		inner, err := g.elementType(e.Array)
+		if err == nil && inner.Kind != ast.BasicType {
+			return inner.Elem, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a pointer", expr)
+}
+
+// elementAddress returns the address of element index of the array at base.
+func (g *Generator) elementAddress(base value.Value, t *ast.Type, index value.Value) value.Value {
+	gep := g.current().NewGetElementPtr(g.lltype(t), base, constant.NewInt(types.I64, 0), index)
+	gep.InBounds = true
+	return gep
+}
+
+func (g *Generator) generateCall(call *ast.CallExpr) (value.Value, error) {
+	var callee value.Value
+	var sig *types.FuncType
+	cc := callingConv(g.opts.CallingConv)
+	if id, ok := call.Callee.(*ast.Identifier); ok && g.vars[id.Name] == nil {
+		if intrinsic := codegen.MemIntrinsic(id.Name); intrinsic != "" && (g.sigs[id.Name] == nil || g.sigs[id.Name].Body == nil) {
+			return g.generateMemIntrinsic(call, id.Name, intrinsic)
+		}
+		if lib := codegen.LookupLibc(id.Name); lib != nil && g.sigs[id.Name] == nil {
+			return g.generateLibcCall(call, lib)
+		}
+		fn, ok := g.funcs[id.Name]
+		if !ok {
+			return nil, fmt.Errorf("undefined function: %s", id.Name)
+		}
+		callee, sig, cc = fn, fn.Sig, fn.CallingConv
+	} else {
+		v, err := g.generateExpression(call.Callee)
+		if err != nil {
+			return nil, err
+		}
+		ptr, ok := v.Type().(*types.PointerType)
+		if !ok {
+			return nil, fmt.Errorf("called object %s is not a function", call.Callee)
+		}
+		if sig, ok = ptr.ElemType.(*types.FuncType); !ok {
+			return nil, fmt.Errorf("called object %s is not a function", call.Callee)
+		}
+		callee = v
+	}
+
+	if len(call.Args) != len(sig.Params) {
+		return nil, fmt.Errorf("call to %s expects %d arguments, got %d", call.Callee, len(sig.Params), len(call.Args))
+	}
+	args := []value.Value{}
+	for i, arg := range call.Args {
+		v, err := g.generateExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = g.convert(v, sig.Params[i]); err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %v", i+1, call.Callee, err)
+		}
+		args = append(args, v)
+	}
+	inst := g.current().NewCall(callee, args...)
+	inst.CallingConv = cc
+	return inst, nil
+}
+
+// libcType returns the llir type of a libc parameter or result.
+func (g *Generator) libcType(t codegen.LibcType) types.Type {
+	switch t {
+	case codegen.LibcSize:
+		return types.NewInt(uint64(g.target.PointerSize * 8))
+	case codegen.LibcPtr:
+		return types.NewPointer(types.I8)
+	case codegen.LibcVoid:
+		return types.Void
+	default:
+		return types.I32
+	}
+}
+
+// generateLibcCall calls a C library function through its built-in
+// signature, converting arguments and the result like the textual
+// backend.
+func (g *Generator) generateLibcCall(call *ast.CallExpr, lib *codegen.LibcFunction) (value.Value, error) {
+	if len(call.Args) < len(lib.Params) || len(call.Args) > len(lib.Params) && !lib.Variadic {
+		return nil, fmt.Errorf("call to %s expects %d arguments, got %d", lib.Name, len(lib.Params), len(call.Args))
+	}
+	fn, ok := g.funcs[lib.Name]
+	if !ok {
+		params := []*ir.Param{}
+		for _, param := range lib.Params {
+			params = append(params, ir.NewParam("", g.libcType(param)))
+		}
+		fn = g.module.NewFunc(lib.Name, g.libcType(lib.Result), params...)
+		fn.Sig.Variadic = lib.Variadic
+		if g.opts.PICLevel == 0 {
+			fn.Preemption = enum.PreemptionDSOLocal
+		}
+		g.funcs[lib.Name] = fn
+	}
+
+	args := []value.Value{}
+	for i, arg := range call.Args {
+		v, err := g.generateExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		v = g.widen(v)
+		if i >= len(lib.Params) {
+			// Variadic arguments undergo the default argument promotions
+			promoted := v.Type()
+			switch t := promoted.(type) {
+			case *types.IntType:
+				if t.BitSize < 32 {
+					promoted = types.I32
+				}
+			case *types.FloatType:
+				promoted = types.Double
+			}
+			if v, err = g.convert(v, promoted); err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+			continue
+		}
+		_, isPointer := v.Type().(*types.PointerType)
+		switch param := lib.Params[i]; {
+		case param == codegen.LibcPtr && isPointer:
+			v = g.current().NewBitCast(v, g.libcType(param))
+		case param != codegen.LibcPtr && !isPointer:
+			v, err = g.convert(v, g.libcType(param))
+		default:
+			return nil, fmt.Errorf("argument %d to %s has incompatible type", i+1, lib.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	var result value.Value = g.current().NewCall(fn, args...)
+	if lib.Result == codegen.LibcPtr {
+		if t := g.lltype(lib.ResultType()); !t.Equal(result.Type()) {
+			result = g.current().NewBitCast(result, t)
+		}
+	}
+	return result, nil
+}
+
+// generateMemIntrinsic lowers memcpy, memmove or memset to the given
+// intrinsic and returns the destination pointer.
+func (g *Generator) generateMemIntrinsic(call *ast.CallExpr, name, intrinsic string) (value.Value, error) {
+	if len(call.Args) != 3 {
+		return nil, fmt.Errorf("call to %s expects 3 arguments, got %d", name, len(call.Args))
+	}
+	values := []value.Value{}
+	for _, arg := range call.Args {
+		v, err := g.generateExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, g.widen(v))
+	}
+
+	bytePtr := types.NewPointer(types.I8)
+	pointerArg := func(v value.Value) (value.Value, error) {
+		ptr, ok := v.Type().(*types.PointerType)
+		if !ok {
+			return nil, fmt.Errorf("argument to %s is not a data pointer", name)
+		}
+		if _, isFunc := ptr.ElemType.(*types.FuncType); isFunc {
+			return nil, fmt.Errorf("argument to %s is not a data pointer", name)
+		}
+		return ir.NewArg(g.current().NewBitCast(v, bytePtr), ir.Align(g.abiAlign(ptr.ElemType))), nil
+	}
+	intArg := func(v value.Value) (value.Value, error) {
+		if _, ok := v.Type().(*types.IntType); !ok {
+			return nil, fmt.Errorf("argument to %s must be an int", name)
+		}
+		return g.convert(v, types.I32)
+	}
+
+	dst, err := pointerArg(values[0])
+	if err != nil {
+		return nil, err
+	}
+	var second value.Value
+	if name == "memset" {
+		fill, err := intArg(values[1])
+		if err != nil {
+			return nil, err
+		}
+		second = g.current().NewTrunc(fill, types.I8)
+	} else if second, err = pointerArg(values[1]); err != nil {
+		return nil, err
+	}
+	size, err := intArg(values[2])
+	if err != nil {
+		return nil, err
+	}
+	size = g.current().NewSExt(size, types.I64)
+
+	fn, ok := g.funcs[intrinsic]
+	if !ok {
+		fn = g.module.NewFunc(intrinsic, types.Void,
+			ir.NewParam("", bytePtr), ir.NewParam("", second.Type()), ir.NewParam("", types.I64), ir.NewParam("", types.I1))
+		g.funcs[intrinsic] = fn
+	}
+	g.current().NewCall(fn, dst, second, size, constant.False)
+	return values[0], nil
+}
+
+// abiAlign returns the ABI alignment of t on the target.
+func (g *Generator) abiAlign(t types.Type) uint64 {
+	switch t := t.(type) {
+	case *types.PointerType:
+		return uint64(g.target.PointerSize)
+	case *types.ArrayType:
+		return g.abiAlign(t.ElemType)
+	case *types.IntType:
+		return (t.BitSize + 7) / 8
+	case *types.FloatType:
+		if t.Kind == types.FloatKindDouble {
+			return 8
+		}
+		return 4
+	default:
+		return 1
+	}
+}
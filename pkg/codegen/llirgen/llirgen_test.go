@@ -0,0 +1,99 @@
+package llirgen_test
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/codegen/llirgen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// programs exercise the statements and expressions both backends lower
+var programs = []string{
+	"int main() { return 42; }",
+	"int square(int x) { return x * x; } int main() { return square(6) - 1; }",
+	`int main() {
+    int a[4];
+    int i = 0;
+    while (i < 4) { a[i] = i * i; i = i + 1; }
+    printf("%d %d\n", a[2], a[3]);
+    switch (a[2]) { case 4: return a[1] + a[3]; default: return 1; }
+}`,
+	`int main() {
+    long n = 3000000000;
+    short s = 7;
+    char c = 65;
+    int r = 0;
+    if (n > 2000000000 && s < 8) { r = r + 1; }
+    if (c == 65 || n < 0) { r = r + 2; }
+    return r + n / 1000000000 + s % 4;
+}`,
+	`int fact(int n) { if (n < 2) { return 1; } return n * fact(n - 1); }
+int main() {
+    char *s = "citadel";
+    puts(s);
+    return fact(5) % 256 + strlen(s);
+}`,
+}
+
+// generate lowers src with backend
+func generate(t *testing.T, backend codegen.Backend, src string) string {
+	t.Helper()
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	ir, err := backend.Generate(program)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	return ir
+}
+
+// paramNames matches the names llir gives the parameters of declarations
+var paramNames = regexp.MustCompile(` %[0-9]+`)
+
+// signatures returns the define and declare lines of ir, sorted, with the
+// parameter names of declarations left out
+func signatures(ir string) []string {
+	var lines []string
+	for _, line := range strings.Split(ir, "\n") {
+		switch {
+		case strings.HasPrefix(line, "define "):
+			lines = append(lines, line)
+		case strings.HasPrefix(line, "declare "):
+			lines = append(lines, paramNames.ReplaceAllString(line, ""))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// TestSignatures checks that llirgen defines and declares the functions
+// the textual backend does, with the same types and attributes
+func TestSignatures(t *testing.T) {
+	for _, src := range programs {
+		text := signatures(generate(t, codegen.New(), src))
+		llir := signatures(generate(t, llirgen.New(codegen.Options{}), src))
+		if strings.Join(text, "\n") != strings.Join(llir, "\n") {
+			t.Errorf("%s:\ntextual backend:\n%s\nllirgen:\n%s", src, strings.Join(text, "\n"), strings.Join(llir, "\n"))
+		}
+	}
+}
+
+// TestBehavior checks that the IR of llirgen, run by lli, exits with the
+// status and writes the output the IR of the textual backend does
+func TestBehavior(t *testing.T) {
+	for _, src := range programs {
+		wantStatus, wantOut := llitest.Run(t, generate(t, codegen.New(), src))
+		status, out := llitest.Run(t, generate(t, llirgen.New(codegen.Options{}), src))
+		if status != wantStatus || out != wantOut {
+			t.Errorf("%s: exit status %d, output %q; the textual backend's %d, %q", src, status, out, wantStatus, wantOut)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+//go:build llvm && cgo
+
+package llvmc
+
+/*
+#include <llvm-c/Core.h>
+*/
+import "C"
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+func (g *Generator) generateCall(call *ast.CallExpr) (C.LLVMValueRef, error) {
+	var callee C.LLVMValueRef
+	var sig C.LLVMTypeRef
+	cc := callingConv(g.opts.CallingConv)
+	if id, ok := call.Callee.(*ast.Identifier); ok && g.vars[id.Name] == nil {
+		if intrinsic := codegen.MemIntrinsic(id.Name); intrinsic != "" && (g.sigs[id.Name] == nil || g.sigs[id.Name].Body == nil) {
+			return g.generateMemIntrinsic(call, id.Name, intrinsic)
+		}
+		if lib := codegen.LookupLibc(id.Name); lib != nil && g.sigs[id.Name] == nil {
+			return g.generateLibcCall(call, lib)
+		}
+		fn, ok := g.funcs[id.Name]
+		if !ok {
+			return nil, fmt.Errorf("undefined function: %s", id.Name)
+		}
+		callee, sig, cc = fn, C.LLVMGlobalGetValueType(fn), C.LLVMGetFunctionCallConv(fn)
+	} else {
+		v, err := g.generateExpression(call.Callee)
+		if err != nil {
+			return nil, err
+		}
+		if !isFuncPointer(typeOf(v)) {
+			return nil, fmt.Errorf("called object %s is not a function", call.Callee)
+		}
+		callee, sig = v, C.LLVMGetElementType(typeOf(v))
+	}
+
+	params := make([]C.LLVMTypeRef, C.LLVMCountParamTypes(sig))
+	if len(params) > 0 {
+		C.LLVMGetParamTypes(sig, &params[0])
+	}
+	if len(call.Args) != len(params) {
+		return nil, fmt.Errorf("call to %s expects %d arguments, got %d", call.Callee, len(params), len(call.Args))
+	}
+	args := []C.LLVMValueRef{}
+	for i, arg := range call.Args {
+		v, err := g.generateExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = g.convert(v, params[i]); err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %v", i+1, call.Callee, err)
+		}
+		args = append(args, v)
+	}
+	inst := C.LLVMBuildCall2(g.b(), sig, callee, valueArray(args), C.uint(len(args)), noName)
+	C.LLVMSetInstructionCallConv(inst, cc)
+	return inst, nil
+}
+
+// libcType returns the LLVM type of a libc parameter or result.
+func (g *Generator) libcType(t codegen.LibcType) C.LLVMTypeRef {
+	switch t {
+	case codegen.LibcSize:
+		return g.intType(g.target.PointerSize * 8)
+	case codegen.LibcPtr:
+		return g.bytePtr()
+	case codegen.LibcVoid:
+		return C.LLVMVoidTypeInContext(g.ctx)
+	default:
+		return g.intType(32)
+	}
+}
+
+// generateLibcCall calls a C library function through its built-in
+// signature, converting arguments and the result like the textual
+// backend. Unlike there, the declaration is not marked dso_local, which
+// the C API of LLVM 14 cannot set.
+func (g *Generator) generateLibcCall(call *ast.CallExpr, lib *codegen.LibcFunction) (C.LLVMValueRef, error) {
+	if len(call.Args) < len(lib.Params) || len(call.Args) > len(lib.Params) && !lib.Variadic {
+		return nil, fmt.Errorf("call to %s expects %d arguments, got %d", lib.Name, len(lib.Params), len(call.Args))
+	}
+	params := []C.LLVMTypeRef{}
+	for _, param := range lib.Params {
+		params = append(params, g.libcType(param))
+	}
+	variadic := C.LLVMBool(0)
+	if lib.Variadic {
+		variadic = 1
+	}
+	sig := C.LLVMFunctionType(g.libcType(lib.Result), typeArray(params), C.uint(len(params)), variadic)
+	fn, ok := g.funcs[lib.Name]
+	if !ok {
+		fn = g.addFunction(lib.Name, sig)
+		g.funcs[lib.Name] = fn
+	}
+
+	args := []C.LLVMValueRef{}
+	for i, arg := range call.Args {
+		v, err := g.generateExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		v = g.widen(v)
+		if i >= len(lib.Params) {
+			// Variadic arguments undergo the default argument promotions
+			promoted := typeOf(v)
+			switch {
+			case isInt(promoted) && intWidth(promoted) < 32:
+				promoted = g.intType(32)
+			case isFloat(promoted):
+				promoted = C.LLVMDoubleTypeInContext(g.ctx)
+			}
+			if v, err = g.convert(v, promoted); err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+			continue
+		}
+		pointer := isPointer(typeOf(v))
+		switch param := lib.Params[i]; {
+		case param == codegen.LibcPtr && pointer:
+			v = C.LLVMBuildBitCast(g.b(), v, g.libcType(param), noName)
+		case param != codegen.LibcPtr && !pointer:
+			v, err = g.convert(v, g.libcType(param))
+		default:
+			return nil, fmt.Errorf("argument %d to %s has incompatible type", i+1, lib.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	result := C.LLVMBuildCall2(g.b(), sig, fn, valueArray(args), C.uint(len(args)), noName)
+	if lib.Result == codegen.LibcPtr {
+		if t := g.lltype(lib.ResultType()); t != g.bytePtr() {
+			result = C.LLVMBuildBitCast(g.b(), result, t, noName)
+		}
+	}
+	return result, nil
+}
+
+// generateMemIntrinsic lowers memcpy, memmove or memset to the given
+// intrinsic and returns the destination pointer.
+func (g *Generator) generateMemIntrinsic(call *ast.CallExpr, name, intrinsic string) (C.LLVMValueRef, error) {
+	if len(call.Args) != 3 {
+		return nil, fmt.Errorf("call to %s expects 3 arguments, got %d", name, len(call.Args))
+	}
+	values := []C.LLVMValueRef{}
+	for _, arg := range call.Args {
+		v, err := g.generateExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, g.widen(v))
+	}
+
+	// Pointer arguments are cast to i8* and keep the alignment of what
+	// they point to, as a parameter attribute on the call
+	var aligns []int
+	pointerArg := func(v C.LLVMValueRef) (C.LLVMValueRef, error) {
+		if t := typeOf(v); !isPointer(t) || isFuncPointer(t) {
+			return nil, fmt.Errorf("argument to %s is not a data pointer", name)
+		}
+		aligns = append(aligns, g.abiAlign(C.LLVMGetElementType(typeOf(v))))
+		return C.LLVMBuildBitCast(g.b(), v, g.bytePtr(), noName), nil
+	}
+	intArg := func(v C.LLVMValueRef) (C.LLVMValueRef, error) {
+		if !isInt(typeOf(v)) {
+			return nil, fmt.Errorf("argument to %s must be an int", name)
+		}
+		return g.convert(v, g.intType(32))
+	}
+
+	dst, err := pointerArg(values[0])
+	if err != nil {
+		return nil, err
+	}
+	var second C.LLVMValueRef
+	if name == "memset" {
+		fill, err := intArg(values[1])
+		if err != nil {
+			return nil, err
+		}
+		second = C.LLVMBuildTrunc(g.b(), fill, g.intType(8), noName)
+	} else if second, err = pointerArg(values[1]); err != nil {
+		return nil, err
+	}
+	size, err := intArg(values[2])
+	if err != nil {
+		return nil, err
+	}
+	size = C.LLVMBuildSExt(g.b(), size, g.intType(64), noName)
+
+	params := []C.LLVMTypeRef{g.bytePtr(), typeOf(second), g.intType(64), g.intType(1)}
+	sig := C.LLVMFunctionType(C.LLVMVoidTypeInContext(g.ctx), typeArray(params), C.uint(len(params)), 0)
+	fn, ok := g.funcs[intrinsic]
+	if !ok {
+		fn = g.addFunction(intrinsic, sig)
+		g.funcs[intrinsic] = fn
+	}
+	args := []C.LLVMValueRef{dst, second, size, g.constInt(1, 0)}
+	inst := C.LLVMBuildCall2(g.b(), sig, fn, valueArray(args), C.uint(len(args)), noName)
+	for i, align := range aligns {
+		// Attribute index 1 is the first argument
+		C.LLVMSetInstrParamAlignment(inst, C.LLVMAttributeIndex(1+i), C.uint(align))
+	}
+	return values[0], nil
+}
+
+// abiAlign returns the ABI alignment of t on the target.
+func (g *Generator) abiAlign(t C.LLVMTypeRef) int {
+	switch kindOf(t) {
+	case C.LLVMPointerTypeKind:
+		return g.target.PointerSize
+	case C.LLVMArrayTypeKind:
+		return g.abiAlign(C.LLVMGetElementType(t))
+	case C.LLVMIntegerTypeKind:
+		return (intWidth(t) + 7) / 8
+	case C.LLVMDoubleTypeKind:
+		return 8
+	case C.LLVMFloatTypeKind:
+		return 4
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,23 @@
+// Package llvmc lowers programs to LLVM IR by building the module through
+// the LLVM C API, in the libLLVM the binary links, rather than writing
+// the IR out as text. With the module in LLVM's own hands, Generate runs
+// LLVM's verifier over it and, at an OptLevel above 0 or with Passes, its
+// optimization pipeline, in process. Like llirgen it covers the core
+// language; the runtime checks and hardening options are only implemented
+// by the textual backend in package codegen.
+//
+// The backend needs cgo and is only built with the llvm build tag, with
+// the flags llvm-config gives for the headers and the library:
+//
+//	CGO_CFLAGS="$(llvm-config --cflags)" CGO_LDFLAGS="$(llvm-config --ldflags)" go build -tags llvm ./cmd/citadel
+//
+// Without the tag, Available is false and Generate returns ErrUnavailable.
+// It is written against LLVM 14, the last release whose C API keeps the
+// typed pointers of the IR Citadel generates.
+package llvmc
+
+import "errors"
+
+// ErrUnavailable is what Generate returns in a binary built without the
+// llvm tag.
+var ErrUnavailable = errors.New("the llvm backend needs citadel built with -tags llvm and cgo")
@@ -0,0 +1,435 @@
+//go:build llvm && cgo
+
+package llvmc
+
+/*
+#include <stdlib.h>
+#include <llvm-c/Core.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+func (g *Generator) constInt(bits, n int) C.LLVMValueRef {
+	return C.LLVMConstInt(g.intType(bits), C.ulonglong(n), 1)
+}
+
+// toBool converts a scalar to i1 by comparing it against zero.
+func (g *Generator) toBool(v C.LLVMValueRef) C.LLVMValueRef {
+	t := typeOf(v)
+	switch {
+	case isInt(t) && intWidth(t) == 1:
+		return v
+	case isPointer(t):
+		return C.LLVMBuildICmp(g.b(), C.LLVMIntNE, v, C.LLVMConstNull(t), noName)
+	case isFloat(t):
+		return C.LLVMBuildFCmp(g.b(), C.LLVMRealUNE, v, C.LLVMConstNull(t), noName)
+	case isInt(t):
+		return C.LLVMBuildICmp(g.b(), C.LLVMIntNE, v, C.LLVMConstNull(t), noName)
+	}
+	return v
+}
+
+// generateValue evaluates expr as a value of type t, applying the implicit
+// conversions of assignment, argument passing and return.
+func (g *Generator) generateValue(expr ast.Expression, t *ast.Type) (C.LLVMValueRef, error) {
+	v, err := g.generateExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return g.convert(v, g.lltype(t))
+}
+
+// convert converts v to type to like the textual backend: between integer
+// widths with sext and trunc, between integers and floating types with
+// sitofp and fptosi, or zext, uitofp and fptoui for the i8 of char where
+// it is unsigned, and between float and double with fpext and fptrunc.
+// Pointers only convert to the same pointer type, or from the constant 0
+// or the i8* void* results are.
+func (g *Generator) convert(v C.LLVMValueRef, to C.LLVMTypeRef) (C.LLVMValueRef, error) {
+	v = g.widen(v)
+	from := typeOf(v)
+	if from == to {
+		return v, nil
+	}
+	if isPointer(to) {
+		if C.LLVMIsAConstantInt(v) != nil && C.LLVMConstIntGetZExtValue(v) == 0 {
+			return C.LLVMConstNull(to), nil
+		}
+		if from == g.bytePtr() {
+			// void*, as the C library returns it, converts to any pointer
+			return C.LLVMBuildBitCast(g.b(), v, to, noName), nil
+		}
+		return nil, fmt.Errorf("incompatible conversion from %s to %s", printType(from), printType(to))
+	}
+
+	// i8 is plain char, unsigned on some targets
+	unsignedChar := func(t C.LLVMTypeRef) bool {
+		return g.target.UnsignedChar && isInt(t) && intWidth(t) == 8
+	}
+	switch {
+	case isInt(from) && isInt(to):
+		if intWidth(to) > intWidth(from) && unsignedChar(from) {
+			return C.LLVMBuildZExt(g.b(), v, to, noName), nil
+		}
+		if intWidth(to) > intWidth(from) {
+			return C.LLVMBuildSExt(g.b(), v, to, noName), nil
+		}
+		return C.LLVMBuildTrunc(g.b(), v, to, noName), nil
+	case isInt(from) && isFloat(to) && unsignedChar(from):
+		return C.LLVMBuildUIToFP(g.b(), v, to, noName), nil
+	case isInt(from) && isFloat(to):
+		return C.LLVMBuildSIToFP(g.b(), v, to, noName), nil
+	case isFloat(from) && unsignedChar(to):
+		return C.LLVMBuildFPToUI(g.b(), v, to, noName), nil
+	case isFloat(from) && isInt(to):
+		return C.LLVMBuildFPToSI(g.b(), v, to, noName), nil
+	case isFloat(from) && isFloat(to):
+		if kindOf(to) == C.LLVMDoubleTypeKind {
+			return C.LLVMBuildFPExt(g.b(), v, to, noName), nil
+		}
+		return C.LLVMBuildFPTrunc(g.b(), v, to, noName), nil
+	}
+	return nil, fmt.Errorf("incompatible conversion from %s to %s", printType(from), printType(to))
+}
+
+// commonType returns the type the usual arithmetic conversions bring two
+// operands to: the wider floating type if either is floating, otherwise
+// the wider integer type but at least int.
+// nullComparison compares a pointer with null if op compares it with the
+// constant 0, as the textual backend does.
+func (g *Generator) nullComparison(op *ast.BinaryOp, left, right C.LLVMValueRef) (C.LLVMValueRef, bool) {
+	if op.Operator != "==" {
+		return nil, false
+	}
+	if !isPointer(typeOf(left)) {
+		left, right = right, left
+	}
+	if !isPointer(typeOf(left)) || C.LLVMIsAConstantInt(right) == nil || C.LLVMConstIntGetZExtValue(right) != 0 {
+		return nil, false
+	}
+	return C.LLVMBuildICmp(g.b(), C.LLVMIntEQ, left, C.LLVMConstNull(typeOf(left)), noName), true
+}
+
+func (g *Generator) commonType(a, b C.LLVMTypeRef) (C.LLVMTypeRef, error) {
+	for _, t := range []C.LLVMTypeRef{a, b} {
+		if !isInt(t) && !isFloat(t) {
+			return nil, fmt.Errorf("invalid operands of types %s and %s", printType(a), printType(b))
+		}
+	}
+	for _, kind := range []C.LLVMTypeKind{C.LLVMDoubleTypeKind, C.LLVMFloatTypeKind} {
+		if kindOf(a) == kind {
+			return a, nil
+		}
+		if kindOf(b) == kind {
+			return b, nil
+		}
+	}
+	common := g.intType(32)
+	for _, t := range []C.LLVMTypeRef{a, b} {
+		if intWidth(t) > intWidth(common) {
+			common = t
+		}
+	}
+	return common, nil
+}
+
+func (g *Generator) generateExpression(expr ast.Expression) (C.LLVMValueRef, error) {
+	switch e := expr.(type) {
+	case *ast.IntLiteral:
+		return g.constInt(32, e.Value), nil
+	case *ast.StringLiteral:
+		return g.stringLiteral(e)
+	case *ast.Identifier:
+		if v, ok := g.vars[e.Name]; ok {
+			if v.typ.Kind == ast.ArrayType {
+				return g.elementAddress(v.addr, v.typ, g.constInt(64, 0)), nil
+			}
+			return C.LLVMBuildLoad2(g.b(), g.lltype(v.typ), v.addr, noName), nil
+		}
+		if fn, ok := g.funcs[e.Name]; ok {
+			return fn, nil
+		}
+		return nil, fmt.Errorf("undefined variable: %s", e.Name)
+	case *ast.BinaryOp:
+		return g.generateBinaryOp(e)
+	case *ast.UnaryOp:
+		v, err := g.generateExpression(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Operator {
+		case "-":
+			t, err := g.commonType(typeOf(g.widen(v)), g.intType(32))
+			if err != nil {
+				return nil, err
+			}
+			if v, err = g.convert(v, t); err != nil {
+				return nil, err
+			}
+			if isFloat(t) {
+				return C.LLVMBuildFNeg(g.b(), v, noName), nil
+			}
+			return C.LLVMBuildSub(g.b(), C.LLVMConstNull(t), v, noName), nil
+		case "*":
+			t := typeOf(v)
+			if !isPointer(t) {
+				return nil, fmt.Errorf("cannot dereference %s", e.Operand)
+			}
+			if isFuncPointer(t) {
+				return v, nil
+			}
+			return C.LLVMBuildLoad2(g.b(), C.LLVMGetElementType(t), v, noName), nil
+		}
+		return nil, fmt.Errorf("unsupported operator: %s", e.Operator)
+	case *ast.IndexExpr:
+		addr, t, err := g.address(e)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind == ast.ArrayType {
+			return g.elementAddress(addr, t, g.constInt(64, 0)), nil
+		}
+		return C.LLVMBuildLoad2(g.b(), g.lltype(t), addr, noName), nil
+	case *ast.Assignment:
+		addr, t, err := g.address(e.Target)
+		if err != nil {
+			return nil, err
+		}
+		v, err := g.generateValue(e.Value, t)
+		if err != nil {
+			return nil, err
+		}
+		C.LLVMBuildStore(g.b(), v, addr)
+		return v, nil
+	case *ast.CallExpr:
+		v, err := g.generateCall(e)
+		if err == nil && kindOf(typeOf(v)) == C.LLVMVoidTypeKind {
+			return nil, fmt.Errorf("void value of call to %s used", e.Callee)
+		}
+		return v, err
+	default:
+		return nil, fmt.Errorf("unknown expression type")
+	}
+}
+
+// stringLiteral returns a char* to a private constant holding the bytes
+// of lit, shared between identical literals.
+func (g *Generator) stringLiteral(lit *ast.StringLiteral) (C.LLVMValueRef, error) {
+	text, err := codegen.StringBytes(lit)
+	if err != nil {
+		return nil, err
+	}
+	global, ok := g.strs[text]
+	if !ok {
+		ctext := C.CString(text)
+		init := C.LLVMConstStringInContext(g.ctx, ctext, C.uint(len(text)), 1)
+		C.free(unsafe.Pointer(ctext))
+		name := ".str"
+		if n := len(g.strs); n > 0 {
+			name = fmt.Sprintf(".str.%d", n)
+		}
+		cname := C.CString(name)
+		global = C.LLVMAddGlobal(g.module, typeOf(init), cname)
+		C.free(unsafe.Pointer(cname))
+		C.LLVMSetInitializer(global, init)
+		C.LLVMSetLinkage(global, C.LLVMPrivateLinkage)
+		C.LLVMSetUnnamedAddress(global, C.LLVMGlobalUnnamedAddr)
+		C.LLVMSetGlobalConstant(global, 1)
+		C.LLVMSetAlignment(global, 1)
+		g.strs[text] = global
+	}
+	zero := []C.LLVMValueRef{g.constInt(64, 0), g.constInt(64, 0)}
+	return C.LLVMConstInBoundsGEP2(C.LLVMGlobalGetValueType(global), global, valueArray(zero), 2), nil
+}
+
+// widen zero-extends comparison results so they can be used as int.
+func (g *Generator) widen(v C.LLVMValueRef) C.LLVMValueRef {
+	if t := typeOf(v); isInt(t) && intWidth(t) == 1 {
+		return C.LLVMBuildZExt(g.b(), v, g.intType(32), noName)
+	}
+	return v
+}
+
+// generateLogical lowers && and || with short-circuit evaluation, like
+// the textual backend.
+func (g *Generator) generateLogical(op *ast.BinaryOp) (C.LLVMValueRef, error) {
+	left, err := g.generateExpression(op.Left)
+	if err != nil {
+		return nil, err
+	}
+	cond := g.toBool(left)
+	leftBlock := g.current()
+	rhsBlock := g.newBlock()
+	endBlock := g.newBlock()
+	decided := 0
+	if op.Operator == "&&" {
+		C.LLVMBuildCondBr(g.builder, cond, rhsBlock, endBlock)
+	} else {
+		decided = 1
+		C.LLVMBuildCondBr(g.builder, cond, endBlock, rhsBlock)
+	}
+	g.block = nil
+
+	g.startBlock(rhsBlock)
+	right, err := g.generateExpression(op.Right)
+	if err != nil {
+		return nil, err
+	}
+	right = g.toBool(right)
+	rightBlock := g.current()
+
+	g.startBlock(endBlock)
+	phi := C.LLVMBuildPhi(g.builder, g.intType(1), noName)
+	values := []C.LLVMValueRef{g.constInt(1, decided), right}
+	blocks := []C.LLVMBasicBlockRef{leftBlock, rightBlock}
+	C.LLVMAddIncoming(phi, valueArray(values), &blocks[0], 2)
+	return phi, nil
+}
+
+func (g *Generator) generateBinaryOp(op *ast.BinaryOp) (C.LLVMValueRef, error) {
+	if op.Operator == "&&" || op.Operator == "||" {
+		return g.generateLogical(op)
+	}
+
+	left, err := g.generateExpression(op.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := g.generateExpression(op.Right)
+	if err != nil {
+		return nil, err
+	}
+	if cmp, ok := g.nullComparison(op, left, right); ok {
+		return cmp, nil
+	}
+	left, right = g.widen(left), g.widen(right)
+	t, err := g.commonType(typeOf(left), typeOf(right))
+	if err != nil {
+		return nil, err
+	}
+	if left, err = g.convert(left, t); err != nil {
+		return nil, err
+	}
+	if right, err = g.convert(right, t); err != nil {
+		return nil, err
+	}
+
+	b := g.b()
+	if isFloat(t) {
+		switch op.Operator {
+		case "==":
+			return C.LLVMBuildFCmp(b, C.LLVMRealOEQ, left, right, noName), nil
+		case ">":
+			return C.LLVMBuildFCmp(b, C.LLVMRealOGT, left, right, noName), nil
+		case "<":
+			return C.LLVMBuildFCmp(b, C.LLVMRealOLT, left, right, noName), nil
+		case "+":
+			return C.LLVMBuildFAdd(b, left, right, noName), nil
+		case "-":
+			return C.LLVMBuildFSub(b, left, right, noName), nil
+		case "*":
+			return C.LLVMBuildFMul(b, left, right, noName), nil
+		case "/":
+			return C.LLVMBuildFDiv(b, left, right, noName), nil
+		case "%":
+			return C.LLVMBuildFRem(b, left, right, noName), nil
+		}
+	}
+	switch op.Operator {
+	case "==":
+		return C.LLVMBuildICmp(b, C.LLVMIntEQ, left, right, noName), nil
+	case ">":
+		return C.LLVMBuildICmp(b, C.LLVMIntSGT, left, right, noName), nil
+	case "<":
+		return C.LLVMBuildICmp(b, C.LLVMIntSLT, left, right, noName), nil
+	case "+":
+		return C.LLVMBuildAdd(b, left, right, noName), nil
+	case "-":
+		return C.LLVMBuildSub(b, left, right, noName), nil
+	case "*":
+		return C.LLVMBuildMul(b, left, right, noName), nil
+	case "/":
+		return C.LLVMBuildSDiv(b, left, right, noName), nil
+	case "%":
+		return C.LLVMBuildSRem(b, left, right, noName), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", op.Operator)
+	}
+}
+
+// address returns the address of an lvalue and the type stored there.
+func (g *Generator) address(expr ast.Expression) (C.LLVMValueRef, *ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		v, ok := g.vars[e.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("undefined variable: %s", e.Name)
+		}
+		return v.addr, v.typ, nil
+	case *ast.IndexExpr:
+		// Indexes are i64, so that a long one is not truncated
+		index, err := g.generateExpression(e.Index)
+		if err != nil {
+			return nil, nil, err
+		}
+		if index, err = g.convert(index, g.intType(64)); err != nil {
+			return nil, nil, err
+		}
+
+		// Arrays are indexed in place; anything else through a pointer
+		if base, t, err := g.address(e.Array); err == nil && t.Kind == ast.ArrayType {
+			return g.elementAddress(base, t, index), t.Elem, nil
+		}
+		ptr, err := g.generateExpression(e.Array)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isPointer(typeOf(ptr)) {
+			return nil, nil, fmt.Errorf("subscripted value %s is not an array or pointer", e.Array)
+		}
+		indices := []C.LLVMValueRef{index}
+		gep := C.LLVMBuildInBoundsGEP2(g.b(), C.LLVMGetElementType(typeOf(ptr)), ptr, valueArray(indices), 1, noName)
+		elem, err := g.elementType(e.Array)
+		return gep, elem, err
+	case *ast.UnaryOp:
+		if e.Operator == "*" {
+			ptr, err := g.generateExpression(e.Operand)
+			if err != nil {
+				return nil, nil, err
+			}
+			elem, err := g.elementType(e.Operand)
+			return ptr, elem, err
+		}
+	}
+	return nil, nil, fmt.Errorf("expression is not assignable: %s", parser.FormatExpr(expr))
+}
+
+// elementType returns the C type pointed to by the pointer expression expr.
+func (g *Generator) elementType(expr ast.Expression) (*ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if v, ok := g.vars[e.Name]; ok && v.typ.Kind != ast.BasicType {
+			return v.typ.Elem, nil
+		}
+	case *ast.IndexExpr:
+		inner, err := g.elementType(e.Array)
+		if err == nil && inner.Kind != ast.BasicType {
+			return inner.Elem, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a pointer", expr)
+}
+
+// elementAddress returns the address of element index of the array at base.
+func (g *Generator) elementAddress(base C.LLVMValueRef, t *ast.Type, index C.LLVMValueRef) C.LLVMValueRef {
+	indices := []C.LLVMValueRef{g.constInt(64, 0), index}
+	return C.LLVMBuildInBoundsGEP2(g.b(), g.lltype(t), base, valueArray(indices), 2, noName)
+}
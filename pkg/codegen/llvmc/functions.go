@@ -0,0 +1,274 @@
+//go:build llvm && cgo
+
+package llvmc
+
+/*
+#include <llvm-c/Core.h>
+*/
+import "C"
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+func (g *Generator) generateFunction(fn *ast.Function) error {
+	g.source = fn
+	g.fn = g.funcs[fn.Name]
+	g.vars = make(map[string]*local)
+
+	entry := C.LLVMAppendBasicBlockInContext(g.ctx, g.fn, noName)
+	g.block = entry
+	C.LLVMPositionBuilderAtEnd(g.builder, entry)
+	g.retBlock = C.LLVMCreateBasicBlockInContext(g.ctx, noName)
+	g.retSlot = g.alloca(g.lltype(fn.ReturnType))
+
+	for i, param := range fn.Params {
+		addr := g.alloca(g.lltype(param.Type))
+		g.vars[param.Name] = &local{addr: addr, typ: param.Type}
+		C.LLVMBuildStore(g.builder, C.LLVMGetParam(g.fn, C.uint(i)), addr)
+	}
+
+	if err := g.generateBlock(fn.Body); err != nil {
+		return err
+	}
+
+	// Falling off the end returns whatever is in the return slot
+	g.startBlock(g.retBlock)
+	ret := C.LLVMBuildLoad2(g.builder, g.lltype(fn.ReturnType), g.retSlot, noName)
+	C.LLVMBuildRet(g.builder, ret)
+	g.block = nil
+	return nil
+}
+
+// alloca allocates a stack slot of type t after the entry block's other
+// allocas and ahead of its other instructions.
+func (g *Generator) alloca(t C.LLVMTypeRef) C.LLVMValueRef {
+	entry := C.LLVMGetEntryBasicBlock(g.fn)
+	inst := C.LLVMGetFirstInstruction(entry)
+	for inst != nil && C.LLVMGetInstructionOpcode(inst) == C.LLVMAlloca {
+		inst = C.LLVMGetNextInstruction(inst)
+	}
+	if inst != nil {
+		C.LLVMPositionBuilderBefore(g.allocas, inst)
+	} else {
+		C.LLVMPositionBuilderAtEnd(g.allocas, entry)
+	}
+	return C.LLVMBuildAlloca(g.allocas, t, noName)
+}
+
+// newBlock creates a block that is not in the function yet, for
+// startBlock to add where the code reaches it.
+func (g *Generator) newBlock() C.LLVMBasicBlockRef {
+	return C.LLVMCreateBasicBlockInContext(g.ctx, noName)
+}
+
+// current returns the block to append to, starting an unreachable one if
+// the previous block was terminated.
+func (g *Generator) current() C.LLVMBasicBlockRef {
+	if g.block == nil {
+		g.block = C.LLVMAppendBasicBlockInContext(g.ctx, g.fn, noName)
+		C.LLVMPositionBuilderAtEnd(g.builder, g.block)
+	}
+	return g.block
+}
+
+// b returns the builder, positioned at the end of the current block.
+func (g *Generator) b() C.LLVMBuilderRef {
+	g.current()
+	return g.builder
+}
+
+// branch ends the current block with a branch to dest.
+func (g *Generator) branch(dest C.LLVMBasicBlockRef) {
+	C.LLVMBuildBr(g.b(), dest)
+	g.block = nil
+}
+
+// startBlock continues code generation in b, falling through from the
+// current block if it has no terminator.
+func (g *Generator) startBlock(b C.LLVMBasicBlockRef) {
+	if g.block != nil {
+		C.LLVMBuildBr(g.builder, b)
+	}
+	C.LLVMAppendExistingBasicBlock(g.fn, b)
+	C.LLVMPositionBuilderAtEnd(g.builder, b)
+	g.block = b
+}
+
+func (g *Generator) generateBlock(block *ast.Block) error {
+	for _, stmt := range block.Statements {
+		if err := g.generateStatement(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Generator) generateStatement(stmt ast.Statement) error {
+	switch s := stmt.(type) {
+	case *ast.Block:
+		return g.generateBlock(s)
+	case *ast.VarDecl:
+		addr := g.alloca(g.lltype(s.Type))
+		g.vars[s.Name] = &local{addr: addr, typ: s.Type}
+		if s.Value != nil {
+			if s.Type.Kind == ast.ArrayType {
+				return fmt.Errorf("array initializers are not supported: %s", s.Name)
+			}
+			v, err := g.generateValue(s.Value, s.Type)
+			if err != nil {
+				return err
+			}
+			C.LLVMBuildStore(g.b(), v, addr)
+		}
+		return nil
+	case *ast.IfStatement:
+		return g.generateIf(s)
+	case *ast.WhileStatement:
+		return g.generateWhile(s)
+	case *ast.SwitchStatement:
+		return g.generateSwitch(s)
+	case *ast.BreakStatement:
+		if len(g.breaks) == 0 {
+			return fmt.Errorf("break statement not within a loop or switch")
+		}
+		g.branch(g.breaks[len(g.breaks)-1])
+		return nil
+	case *ast.ReturnStatement:
+		v, err := g.generateValue(s.Value, g.source.ReturnType)
+		if err != nil {
+			return err
+		}
+		C.LLVMBuildStore(g.b(), v, g.retSlot)
+		g.branch(g.retBlock)
+		return nil
+	case *ast.ExprStatement:
+		// The result of a call may be discarded, even one of a void function
+		if call, ok := s.Expr.(*ast.CallExpr); ok {
+			_, err := g.generateCall(call)
+			return err
+		}
+		_, err := g.generateExpression(s.Expr)
+		return err
+	case *ast.AsmStatement:
+		return fmt.Errorf("the llvm backend does not support inline assembly")
+	default:
+		return fmt.Errorf("unknown statement type")
+	}
+}
+
+// generateSwitch lowers a switch statement like the textual backend: one
+// block per case label, each falling through into the next.
+func (g *Generator) generateSwitch(stmt *ast.SwitchStatement) error {
+	tag, err := g.generateValue(stmt.Tag, ast.Int)
+	if err != nil {
+		return err
+	}
+
+	blocks := make([]C.LLVMBasicBlockRef, len(stmt.Cases))
+	endBlock := g.newBlock()
+	var defaultBlock C.LLVMBasicBlockRef
+	values := map[int]C.LLVMBasicBlockRef{}
+	order := []int{}
+	for i, cs := range stmt.Cases {
+		blocks[i] = g.newBlock()
+		if cs.Value == nil {
+			if defaultBlock != nil {
+				return fmt.Errorf("multiple default labels in one switch")
+			}
+			defaultBlock = blocks[i]
+			continue
+		}
+		value, err := codegen.CaseConstant(cs.Value)
+		if err != nil {
+			return err
+		}
+		if _, ok := values[value]; ok {
+			return fmt.Errorf("duplicate case value %d", value)
+		}
+		values[value] = blocks[i]
+		order = append(order, value)
+	}
+	if defaultBlock == nil {
+		defaultBlock = endBlock
+	}
+	sw := C.LLVMBuildSwitch(g.b(), tag, defaultBlock, C.uint(len(order)))
+	for _, value := range order {
+		C.LLVMAddCase(sw, g.constInt(32, value), values[value])
+	}
+	g.block = nil
+
+	g.breaks = append(g.breaks, endBlock)
+	for i, cs := range stmt.Cases {
+		g.startBlock(blocks[i])
+		if err := g.generateBlock(&ast.Block{Statements: cs.Body}); err != nil {
+			return err
+		}
+	}
+	g.breaks = g.breaks[:len(g.breaks)-1]
+	g.startBlock(endBlock)
+	return nil
+}
+
+// generateWhile tests the condition in a block of its own, which the end
+// of the body branches back to.
+func (g *Generator) generateWhile(stmt *ast.WhileStatement) error {
+	condBlock := g.newBlock()
+	bodyBlock := g.newBlock()
+	endBlock := g.newBlock()
+	g.startBlock(condBlock)
+	cond, err := g.generateExpression(stmt.Condition)
+	if err != nil {
+		return err
+	}
+	C.LLVMBuildCondBr(g.b(), g.toBool(cond), bodyBlock, endBlock)
+	g.block = nil
+
+	g.breaks = append(g.breaks, endBlock)
+	g.startBlock(bodyBlock)
+	if err := g.generateBlock(stmt.Body); err != nil {
+		return err
+	}
+	g.breaks = g.breaks[:len(g.breaks)-1]
+	if g.block != nil {
+		g.branch(condBlock)
+	}
+	g.startBlock(endBlock)
+	return nil
+}
+
+func (g *Generator) generateIf(stmt *ast.IfStatement) error {
+	cond, err := g.generateExpression(stmt.Condition)
+	if err != nil {
+		return err
+	}
+	cond = g.toBool(cond)
+
+	thenBlock := g.newBlock()
+	endBlock := g.newBlock()
+	elseBlock := endBlock
+	if stmt.ElseBlock != nil {
+		elseBlock = g.newBlock()
+	}
+	C.LLVMBuildCondBr(g.b(), cond, thenBlock, elseBlock)
+	g.block = nil
+
+	g.startBlock(thenBlock)
+	if err := g.generateBlock(stmt.ThenBlock); err != nil {
+		return err
+	}
+	if stmt.ElseBlock != nil {
+		if g.block != nil {
+			g.branch(endBlock)
+		}
+		g.startBlock(elseBlock)
+		if err := g.generateBlock(stmt.ElseBlock); err != nil {
+			return err
+		}
+	}
+	g.startBlock(endBlock)
+	return nil
+}
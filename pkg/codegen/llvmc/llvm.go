@@ -0,0 +1,422 @@
+//go:build llvm && cgo
+
+package llvmc
+
+/*
+#cgo LDFLAGS: -lLLVM
+#include <stdlib.h>
+#include <llvm-c/Analysis.h>
+#include <llvm-c/Core.h>
+#include <llvm-c/Error.h>
+#include <llvm-c/Transforms/PassBuilder.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+)
+
+// Available says whether the binary was built with the llvm tag, and so
+// with the backend.
+const Available = true
+
+// noName names the values and blocks the backend creates, which LLVM
+// numbers.
+var noName = C.CString("")
+
+// local is a stack slot holding a parameter or local variable.
+type local struct {
+	addr C.LLVMValueRef
+	typ  *ast.Type
+}
+
+// Generator is a codegen.Backend built on the LLVM C API.
+type Generator struct {
+	// Passes is the pipeline run over the module, as opt -passes takes it,
+	// such as "default<O2>" or "mem2reg,instcombine". If empty, an OptLevel
+	// above 0 runs LLVM's default pipeline at that level.
+	Passes string
+
+	opts    codegen.Options
+	target  *codegen.Target
+	ctx     C.LLVMContextRef
+	module  C.LLVMModuleRef
+	builder C.LLVMBuilderRef
+	allocas C.LLVMBuilderRef // inserts at the start of the entry block
+	funcs   map[string]C.LLVMValueRef
+	sigs    map[string]*ast.Function
+	strs    map[string]C.LLVMValueRef // string literal bytes to their constant
+
+	// State of the function being generated
+	source   *ast.Function
+	fn       C.LLVMValueRef
+	block    C.LLVMBasicBlockRef // nil after a terminator, until code needs a block
+	retSlot  C.LLVMValueRef
+	retBlock C.LLVMBasicBlockRef
+	vars     map[string]*local
+	breaks   []C.LLVMBasicBlockRef // blocks break jumps to, innermost last
+}
+
+var _ codegen.Backend = (*Generator)(nil)
+
+func New(opts codegen.Options) *Generator {
+	return &Generator{opts: opts}
+}
+
+// Generate lowers program, verifies the module and runs the passes over
+// it, and returns the module's textual IR as LLVM prints it.
+func (g *Generator) Generate(program *ast.Program) (_ string, err error) {
+	defer diag.Recover("codegen", &err)
+	if err := g.checkOptions(); err != nil {
+		return "", &codegen.BackendError{Err: err}
+	}
+	target, err := codegen.LookupTarget(g.opts.Target)
+	if err != nil {
+		return "", &codegen.BackendError{Err: err}
+	}
+	if err := codegen.CheckTargetOptions(target, g.opts); err != nil {
+		return "", &codegen.BackendError{Err: err}
+	}
+	g.target = target
+
+	g.ctx = C.LLVMContextCreate()
+	defer C.LLVMContextDispose(g.ctx)
+	g.module = g.newModule()
+	defer C.LLVMDisposeModule(g.module)
+	g.builder = C.LLVMCreateBuilderInContext(g.ctx)
+	defer C.LLVMDisposeBuilder(g.builder)
+	g.allocas = C.LLVMCreateBuilderInContext(g.ctx)
+	defer C.LLVMDisposeBuilder(g.allocas)
+
+	if err := g.generateModule(program); err != nil {
+		return "", err
+	}
+	if err := g.verify(); err != nil {
+		return "", err
+	}
+	if err := g.runPasses(); err != nil {
+		return "", err
+	}
+	text := C.LLVMPrintModuleToString(g.module)
+	defer C.LLVMDisposeMessage(text)
+	return C.GoString(text), nil
+}
+
+// GenerateTo lowers program as Generate does and writes the IR to w.
+func (g *Generator) GenerateTo(w io.Writer, program *ast.Program) error {
+	ir, err := g.Generate(program)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, ir)
+	return err
+}
+
+func (g *Generator) newModule() C.LLVMModuleRef {
+	name := C.CString("citadel")
+	defer C.free(unsafe.Pointer(name))
+	m := C.LLVMModuleCreateWithNameInContext(name, g.ctx)
+	layout := C.CString(g.target.DataLayout)
+	defer C.free(unsafe.Pointer(layout))
+	C.LLVMSetDataLayout(m, layout)
+	triple := C.CString(g.target.Triple)
+	defer C.free(unsafe.Pointer(triple))
+	C.LLVMSetTarget(m, triple)
+	return m
+}
+
+// verify runs LLVM's verifier over the module.
+func (g *Generator) verify() error {
+	var msg *C.char
+	failed := C.LLVMVerifyModule(g.module, C.LLVMReturnStatusAction, &msg)
+	defer C.LLVMDisposeMessage(msg)
+	if failed != 0 {
+		return &codegen.BackendError{Err: fmt.Errorf("LLVM rejected the module: %s", C.GoString(msg))}
+	}
+	return nil
+}
+
+// runPasses runs Passes, or the default pipeline at OptLevel, over the
+// module with LLVM's new pass manager.
+func (g *Generator) runPasses() error {
+	passes := g.Passes
+	if passes == "" && g.opts.OptLevel > 0 {
+		passes = fmt.Sprintf("default<O%d>", g.opts.OptLevel)
+	}
+	if passes == "" {
+		return nil
+	}
+	cpasses := C.CString(passes)
+	defer C.free(unsafe.Pointer(cpasses))
+	options := C.LLVMCreatePassBuilderOptions()
+	defer C.LLVMDisposePassBuilderOptions(options)
+	C.LLVMPassBuilderOptionsSetVerifyEach(options, 1)
+	if err := C.LLVMRunPasses(g.module, cpasses, nil, options); err != nil {
+		msg := C.LLVMGetErrorMessage(err)
+		defer C.LLVMDisposeErrorMessage(msg)
+		return &codegen.BackendError{Err: fmt.Errorf("running passes %q: %s", passes, C.GoString(msg))}
+	}
+	return nil
+}
+
+func (g *Generator) generateModule(program *ast.Program) error {
+	g.funcs = make(map[string]C.LLVMValueRef)
+	g.sigs = make(map[string]*ast.Function)
+	g.strs = make(map[string]C.LLVMValueRef)
+
+	// Declare every function first so calls can refer to later ones
+	for _, fn := range program.Functions {
+		if err := codegen.CheckSymbolAttributes(fn); err != nil {
+			return err
+		}
+		if prev, ok := g.sigs[fn.Name]; ok {
+			if !prev.Signature().Equal(fn.Signature()) {
+				return fmt.Errorf("conflicting types for %s", fn.Name)
+			}
+			if prev.Body != nil && fn.Body != nil {
+				return fmt.Errorf("redefinition of %s", fn.Name)
+			}
+			if fn.Body == nil {
+				continue
+			}
+			g.sigs[fn.Name] = fn
+			setName(g.funcs[fn.Name], codegen.SymbolName(fn, g.opts))
+			continue
+		}
+		g.sigs[fn.Name] = fn
+		f := g.addFunction(codegen.SymbolName(fn, g.opts), g.lltype(fn.Signature()))
+		C.LLVMSetFunctionCallConv(f, callingConv(codegen.CallingConv(fn, g.opts)))
+		C.LLVMSetVisibility(f, visibility(codegen.Visibility(fn, g.opts)))
+		for i, param := range fn.Params {
+			setName(C.LLVMGetParam(f, C.uint(i)), param.Name)
+		}
+		g.funcs[fn.Name] = f
+	}
+
+	for _, fn := range program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		if err := codegen.Canceled(g.opts); err != nil {
+			return err
+		}
+		// The definition may differ from an earlier prototype in these
+		f := g.funcs[fn.Name]
+		if codegen.Linkage(fn, g.opts) == "internal" {
+			C.LLVMSetLinkage(f, C.LLVMInternalLinkage)
+		}
+		C.LLVMSetVisibility(f, visibility(codegen.Visibility(fn, g.opts)))
+		if section := codegen.Section(fn); section != "" {
+			csection := C.CString(section)
+			C.LLVMSetSection(f, csection)
+			C.free(unsafe.Pointer(csection))
+		}
+		if g.opts.FramePointer != "" {
+			g.addAttribute(f, "frame-pointer", g.opts.FramePointer)
+		}
+		if g.opts.StackProtector != "" {
+			g.addEnumAttribute(f, g.opts.StackProtector)
+		}
+		if name := codegen.WasmExportName(fn, g.opts); g.target.IsWasm() && name != "" {
+			g.addAttribute(f, "wasm-export-name", name)
+		}
+		if err := g.generateFunction(fn); err != nil {
+			return err
+		}
+	}
+
+	g.addModuleMetadata()
+	return nil
+}
+
+func (g *Generator) addFunction(name string, sig C.LLVMTypeRef) C.LLVMValueRef {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	return C.LLVMAddFunction(g.module, cname, sig)
+}
+
+func setName(v C.LLVMValueRef, name string) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	C.LLVMSetValueName2(v, cname, C.size_t(len(name)))
+}
+
+// addAttribute adds the string attribute key=value to the function f.
+func (g *Generator) addAttribute(f C.LLVMValueRef, key, value string) {
+	ckey, cvalue := C.CString(key), C.CString(value)
+	defer C.free(unsafe.Pointer(ckey))
+	defer C.free(unsafe.Pointer(cvalue))
+	attr := C.LLVMCreateStringAttribute(g.ctx, ckey, C.uint(len(key)), cvalue, C.uint(len(value)))
+	C.LLVMAddAttributeAtIndex(f, ^C.LLVMAttributeIndex(0), attr) // the function index, ~0U
+}
+
+// addEnumAttribute adds the attribute without a value called name, such
+// as ssp, to the function f.
+func (g *Generator) addEnumAttribute(f C.LLVMValueRef, name string) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	kind := C.LLVMGetEnumAttributeKindForName(cname, C.size_t(len(name)))
+	attr := C.LLVMCreateEnumAttribute(g.ctx, kind, 0)
+	C.LLVMAddAttributeAtIndex(f, ^C.LLVMAttributeIndex(0), attr)
+}
+
+// addModuleMetadata records the same module flags, producer and version
+// stamp as the textual backend. The flags are written as metadata nodes,
+// as LLVMAddModuleFlag has no max behavior.
+func (g *Generator) addModuleMetadata() {
+	for _, flag := range codegen.ModuleFlags(g.opts) {
+		g.addNamedMetadata("llvm.module.flags", g.intMetadata(flag.Behavior), g.stringMetadata(flag.Key), g.intMetadata(flag.Value))
+	}
+	g.addNamedMetadata("llvm.ident", g.stringMetadata(codegen.Ident(g.opts)))
+	g.addNamedMetadata("citadel.version", g.stringMetadata(codegen.Version))
+}
+
+func (g *Generator) addNamedMetadata(name string, fields ...C.LLVMMetadataRef) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	node := C.LLVMMDNodeInContext2(g.ctx, &fields[0], C.size_t(len(fields)))
+	C.LLVMAddNamedMetadataOperand(g.module, cname, C.LLVMMetadataAsValue(g.ctx, node))
+}
+
+func (g *Generator) intMetadata(n int) C.LLVMMetadataRef {
+	return C.LLVMValueAsMetadata(C.LLVMConstInt(g.intType(32), C.ulonglong(n), 0))
+}
+
+func (g *Generator) stringMetadata(s string) C.LLVMMetadataRef {
+	cs := C.CString(s)
+	defer C.free(unsafe.Pointer(cs))
+	return C.LLVMMDStringInContext2(g.ctx, cs, C.size_t(len(s)))
+}
+
+// checkOptions rejects options this backend does not implement.
+func (g *Generator) checkOptions() error {
+	unsupported := []struct {
+		set  bool
+		name string
+	}{
+		{g.opts.SourceComments, "source comments"},
+		{g.opts.StackUsage, "stack usage annotations"},
+		{g.opts.SafeStack, "safestack"},
+		{g.opts.ShadowCallStack, "shadow-call-stack"},
+		{g.opts.SanitizeAddress || g.opts.SanitizeMemory || g.opts.SanitizeThread, "sanitizers"},
+		{g.opts.CFI, "cfi"},
+		{g.opts.BoundsChecks, "bounds-checks"},
+		{g.opts.OverflowChecks, "overflow-checks"},
+		{g.opts.DivisionChecks, "div-checks"},
+		{len(g.opts.Obfuscate) > 0, "obfuscation"},
+	}
+	for _, opt := range unsupported {
+		if opt.set {
+			return fmt.Errorf("the llvm backend does not support %s", opt.name)
+		}
+	}
+	return nil
+}
+
+// callingConv maps a calling convention name to LLVM's number for it.
+func callingConv(name string) C.uint {
+	if name == "fastcc" {
+		return C.LLVMFastCallConv
+	}
+	return C.LLVMCCallConv
+}
+
+func visibility(name string) C.LLVMVisibility {
+	switch name {
+	case "hidden":
+		return C.LLVMHiddenVisibility
+	case "protected":
+		return C.LLVMProtectedVisibility
+	}
+	return C.LLVMDefaultVisibility
+}
+
+func (g *Generator) intType(bits int) C.LLVMTypeRef {
+	return C.LLVMIntTypeInContext(g.ctx, C.uint(bits))
+}
+
+func (g *Generator) bytePtr() C.LLVMTypeRef {
+	return C.LLVMPointerType(g.intType(8), 0)
+}
+
+// lltype returns the LLVM type for a C type on the target.
+func (g *Generator) lltype(t *ast.Type) C.LLVMTypeRef {
+	switch t.Kind {
+	case ast.PointerType:
+		return C.LLVMPointerType(g.lltype(t.Elem), 0)
+	case ast.ArrayType:
+		return C.LLVMArrayType(g.lltype(t.Elem), C.uint(t.Len))
+	case ast.FuncType:
+		params := []C.LLVMTypeRef{}
+		for _, param := range t.Params {
+			params = append(params, g.lltype(param))
+		}
+		return C.LLVMFunctionType(g.lltype(t.Elem), typeArray(params), C.uint(len(params)), 0)
+	}
+	switch t.Name {
+	case "float":
+		return C.LLVMFloatTypeInContext(g.ctx)
+	case "double":
+		return C.LLVMDoubleTypeInContext(g.ctx)
+	default:
+		return g.intType(g.target.SizeOf(t) * 8)
+	}
+}
+
+// typeArray and valueArray return the first of ts or vs for the C API,
+// which takes arrays as a pointer and a count.
+func typeArray(ts []C.LLVMTypeRef) *C.LLVMTypeRef {
+	if len(ts) == 0 {
+		return nil
+	}
+	return &ts[0]
+}
+
+func valueArray(vs []C.LLVMValueRef) *C.LLVMValueRef {
+	if len(vs) == 0 {
+		return nil
+	}
+	return &vs[0]
+}
+
+func typeOf(v C.LLVMValueRef) C.LLVMTypeRef {
+	return C.LLVMTypeOf(v)
+}
+
+func kindOf(t C.LLVMTypeRef) C.LLVMTypeKind {
+	return C.LLVMGetTypeKind(t)
+}
+
+func isInt(t C.LLVMTypeRef) bool {
+	return kindOf(t) == C.LLVMIntegerTypeKind
+}
+
+func isFloat(t C.LLVMTypeRef) bool {
+	k := kindOf(t)
+	return k == C.LLVMFloatTypeKind || k == C.LLVMDoubleTypeKind
+}
+
+func isPointer(t C.LLVMTypeRef) bool {
+	return kindOf(t) == C.LLVMPointerTypeKind
+}
+
+// isFuncPointer reports whether t is a pointer to a function.
+func isFuncPointer(t C.LLVMTypeRef) bool {
+	return isPointer(t) && kindOf(C.LLVMGetElementType(t)) == C.LLVMFunctionTypeKind
+}
+
+func intWidth(t C.LLVMTypeRef) int {
+	return int(C.LLVMGetIntTypeWidth(t))
+}
+
+func printType(t C.LLVMTypeRef) string {
+	s := C.LLVMPrintTypeToString(t)
+	defer C.LLVMDisposeMessage(s)
+	return C.GoString(s)
+}
@@ -0,0 +1,37 @@
+//go:build !llvm || !cgo
+
+package llvmc
+
+import (
+	"io"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// Available says whether the binary was built with the llvm tag, and so
+// with the backend.
+const Available = false
+
+// Generator is a codegen.Backend built on the LLVM C API, which this
+// binary was built without.
+type Generator struct {
+	// Passes is the pipeline run over the module, as opt -passes takes it.
+	Passes string
+}
+
+var _ codegen.Backend = (*Generator)(nil)
+
+func New(opts codegen.Options) *Generator {
+	return &Generator{}
+}
+
+// Generate returns ErrUnavailable, as a codegen.BackendError.
+func (g *Generator) Generate(program *ast.Program) (string, error) {
+	return "", &codegen.BackendError{Err: ErrUnavailable}
+}
+
+// GenerateTo returns ErrUnavailable, as a codegen.BackendError.
+func (g *Generator) GenerateTo(w io.Writer, program *ast.Program) error {
+	return &codegen.BackendError{Err: ErrUnavailable}
+}
@@ -0,0 +1,117 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// isBoolean reports whether expr yields an i1 rather than an int:
+// comparisons and the logical operators do.
+func isBoolean(expr ast.Expression) bool {
+	op, ok := expr.(*ast.BinaryOp)
+	if !ok {
+		return false
+	}
+	switch op.Operator {
+	case "==", "<", ">", "&&", "||":
+		return true
+	}
+	return false
+}
+
+// generateCondition evaluates expr for its truth value as an i1 operand.
+// Comparisons and logical operators produce one directly; other values
+// are compared against zero, or against null for pointers.
+func (c *CodeGen) generateCondition(expr ast.Expression) (string, error) {
+	if op, ok := expr.(*ast.BinaryOp); ok && isBoolean(op) {
+		return c.generateBinaryOp(op)
+	}
+	value, err := c.generateExpression(expr)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := constantValue(value); ok && c.opts.OptLevel >= 1 {
+		c.record("constfold", "instructions folded", 1)
+		return strconv.FormatBool(v != 0), nil
+	}
+	t, err := c.typeOf(expr)
+	if err != nil {
+		return "", err
+	}
+	cmp, zero := "icmp ne", "0"
+	switch {
+	case t.Kind == ast.PointerType:
+		zero = "null"
+	case t.IsFloating():
+		cmp, zero = "fcmp une", "0.0"
+	}
+	reg := c.nextNamedReg("tobool")
+	c.emit("%%%d = %s %s %s, %s", reg, cmp, c.llvmType(t), value, zero)
+	return fmt.Sprintf("%%%d", reg), nil
+}
+
+// boolToInt widens an i1 truth value to the int C gives comparisons and
+// logical operators, 0 or 1.
+func (c *CodeGen) boolToInt(cond, operator string) string {
+	switch cond {
+	case "true":
+		return "1"
+	case "false":
+		return "0"
+	}
+	hint := "conv"
+	if operator == "&&" {
+		hint = "land.ext"
+	} else if operator == "||" {
+		hint = "lor.ext"
+	}
+	reg := c.nextNamedReg(hint)
+	c.emit("%%%d = zext i1 %s to i32", reg, cond)
+	return fmt.Sprintf("%%%d", reg)
+}
+
+// generateLogical lowers && and || with short-circuit evaluation: the
+// right operand is evaluated in a block of its own only when the left
+// one does not already decide the result, and a phi merges the two
+// paths.
+func (c *CodeGen) generateLogical(op *ast.BinaryOp) (string, error) {
+	prefix, decided := "land", "false"
+	if op.Operator == "||" {
+		prefix, decided = "lor", "true"
+	}
+
+	left, err := c.generateCondition(op.Left)
+	if err != nil {
+		return "", err
+	}
+	// A folded left operand settles the question without a branch
+	switch left {
+	case decided:
+		return left, nil
+	case "true", "false":
+		return c.generateCondition(op.Right)
+	}
+
+	rhsLabel := c.nextNamedLabel(prefix + ".rhs")
+	endLabel := c.nextNamedLabel(prefix + ".end")
+	if op.Operator == "&&" {
+		c.emit("br i1 %s, label %%%d, label %%%d", left, rhsLabel, endLabel)
+	} else {
+		c.emit("br i1 %s, label %%%d, label %%%d", left, endLabel, rhsLabel)
+	}
+	leftBlock := c.cur.label
+
+	c.startBlock(strconv.Itoa(rhsLabel))
+	right, err := c.generateCondition(op.Right)
+	if err != nil {
+		return "", err
+	}
+	rightBlock := c.cur.label
+
+	c.startBlock(strconv.Itoa(endLabel))
+	reg := c.nextReg()
+	c.emit("%%%d = phi i1 [ %s, %%%s ], [ %s, %%%s ]", reg, decided, leftBlock, right, rightBlock)
+	return fmt.Sprintf("%%%d", reg), nil
+}
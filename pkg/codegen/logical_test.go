@@ -0,0 +1,54 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// logicalProgram counts in n[0] the right operands of && and || that
+// are evaluated, and encodes the count and the four results in its
+// exit status
+const logicalProgram = `int bump(int *p) { p[0] = p[0] + 1; return 1; }
+int main() {
+    int n[1];
+    n[0] = 0;
+    int a = 0 == 1 && bump(n);
+    int b = 1 == 1 || bump(n);
+    int c = 1 == 1 && bump(n);
+    int d = 0 == 1 || bump(n);
+    return n[0] * 10 + a + b * 2 + c * 4 + d * 8;
+}`
+
+// TestShortCircuit checks that && and || branch around their right
+// operand and merge the result with a phi, and that the right operand
+// runs only when the left one does not decide the result, at -O0 and -O1
+func TestShortCircuit(t *testing.T) {
+	ir := generate(t, logicalProgram, codegen.Options{})
+	for _, want := range []string{
+		"br i1 %cmp, label %land.rhs, label %land.end",
+		"%0 = phi i1 [ false, %entry ], [ %tobool, %land.rhs ]",
+		"%land.ext = zext i1 %0 to i32",
+		"br i1 %cmp1, label %lor.end, label %lor.rhs",
+		"%1 = phi i1 [ true, %land.end ], [ %tobool4, %lor.rhs ]",
+		"%lor.ext = zext i1 %1 to i32",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+	if n := strings.Count(ir, "call i32 @bump("); n != 4 {
+		t.Errorf("%d calls to bump, want 4:\n%s", n, ir)
+	}
+
+	// bump runs for c and d only, and a is false while b, c and d are true
+	const want = 2*10 + 0 + 1*2 + 1*4 + 1*8
+	for _, level := range []int{0, 1} {
+		ir := generate(t, logicalProgram, codegen.Options{OptLevel: level})
+		if status, _ := llitest.Run(t, ir); status != want {
+			t.Errorf("-O%d: exit status %d, want %d", level, status, want)
+		}
+	}
+}
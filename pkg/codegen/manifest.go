@@ -0,0 +1,137 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Manifest lists the symbols of a generated module, for build systems and
+// software bill of materials tools to read without parsing the IR.
+type Manifest struct {
+	Target    string             `json:"target"`
+	Functions []ManifestFunction `json:"functions"`
+	Globals   []ManifestGlobal   `json:"globals"`
+	Externals []ManifestExternal `json:"externals"`
+}
+
+// ManifestFunction is a function the module defines.
+type ManifestFunction struct {
+	Name string `json:"name"`
+	// Symbol is the name the function is emitted under, with
+	// Options.SymbolPrefix.
+	Symbol string `json:"symbol"`
+	// Signature is the C type of the function, such as "int (char*, int)".
+	Signature  string `json:"signature"`
+	File       string `json:"file,omitempty"`
+	Line       int    `json:"line"`
+	Linkage    string `json:"linkage"`    // external or internal
+	Visibility string `json:"visibility"` // default, hidden or protected
+	// Findings is the number of analysis findings in the function. Code
+	// generation does not run the analysis, so it is for the caller to
+	// fill in.
+	Findings int           `json:"findings"`
+	Stack    ManifestStack `json:"stack"`
+}
+
+// ManifestStack is the frame estimate of a function, as StackEstimate
+// gives it.
+type ManifestStack struct {
+	Bytes    int  `json:"bytes"`
+	Locals   int  `json:"locals"`
+	Overhead int  `json:"overhead"`
+	Calls    bool `json:"calls"`
+}
+
+// ManifestGlobal is a global the module defines. Citadel's subset has no
+// global variables, so these are the constants of string literals.
+type ManifestGlobal struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"` // LLVM type, such as [6 x i8]
+	Size    int    `json:"size"`
+	Linkage string `json:"linkage"`
+}
+
+// ManifestExternal is a symbol the module declares and needs from
+// elsewhere when it is linked.
+type ManifestExternal struct {
+	Name string `json:"name"`
+	// Kind is libc for a C library function, intrinsic for an LLVM
+	// intrinsic, which the backend expands, and function for a prototype
+	// of the program without a definition.
+	Kind        string `json:"kind"`
+	Declaration string `json:"declaration"` // the declare line of the IR
+}
+
+// Manifest returns the manifest of the module Generate or GenerateTo last
+// generated for program.
+func (c *CodeGen) Manifest(program *ast.Program) *Manifest {
+	m := &Manifest{
+		Functions: []ManifestFunction{},
+		Globals:   []ManifestGlobal{},
+		Externals: []ManifestExternal{},
+	}
+	if c.target != nil {
+		m.Target = c.target.Triple
+	}
+
+	stacks := map[string]StackEstimate{}
+	for _, est := range c.stackEstimates {
+		stacks[est.Function] = est
+	}
+	for _, fn := range program.Functions {
+		if fn.Body == nil || c.functions[fn.Name] != fn {
+			continue
+		}
+		file := fn.File
+		if file == "" {
+			file = c.opts.SourceFile
+		}
+		linkage := Linkage(fn, c.opts)
+		if linkage == "" {
+			linkage = "external"
+		}
+		visibility := Visibility(fn, c.opts)
+		if visibility == "" {
+			visibility = "default"
+		}
+		est := stacks[fn.Name]
+		m.Functions = append(m.Functions, ManifestFunction{
+			Name:       fn.Name,
+			Symbol:     SymbolName(fn, c.opts),
+			Signature:  fn.Signature().String(),
+			File:       file,
+			Line:       fn.Pos.Line,
+			Linkage:    linkage,
+			Visibility: visibility,
+			Stack:      ManifestStack{Bytes: est.Total, Locals: est.Locals, Overhead: est.Overhead, Calls: est.Calls},
+		})
+	}
+
+	sizes := map[string]int{}
+	for text, name := range c.stringGlobals {
+		sizes[name] = len(text)
+	}
+	for _, global := range c.globals {
+		name, _, _ := strings.Cut(global, " = ")
+		m.Globals = append(m.Globals, ManifestGlobal{
+			Name:    strings.TrimPrefix(name, "@"),
+			Type:    fmt.Sprintf("[%d x i8]", sizes[name]),
+			Size:    sizes[name],
+			Linkage: "private",
+		})
+	}
+
+	for i, name := range c.declaredNames {
+		kind := "function"
+		switch {
+		case strings.HasPrefix(name, "llvm."):
+			kind = "intrinsic"
+		case LookupLibc(name) != nil:
+			kind = "libc"
+		}
+		m.Externals = append(m.Externals, ManifestExternal{Name: name, Kind: kind, Declaration: c.declarations[i]})
+	}
+	return m
+}
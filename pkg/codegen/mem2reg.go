@@ -0,0 +1,281 @@
+package codegen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var alignSuffix = regexp.MustCompile(`, align [0-9]+$`)
+
+// slotAccess reports whether instr is a load from or a store to slot, a
+// stack slot holding a value of type typ, that uses the slot only as its
+// address. It returns the loaded register or the stored value.
+func slotAccess(instr, slot, typ string) (load, store string, ok bool) {
+	refs := 0
+	for _, ref := range localRef.FindAllString(instr, -1) {
+		if ref == slot {
+			refs++
+		}
+	}
+	if refs != 1 {
+		return "", "", false
+	}
+	instr = alignSuffix.ReplaceAllString(instr, "")
+	pointer := ", " + typ + "* " + slot
+	if i := strings.Index(instr, " = load "); i > 0 && instr[i+len(" = load "):] == typ+pointer {
+		return instr[:i], "", true
+	}
+	if strings.HasPrefix(instr, "store "+typ+" ") && strings.HasSuffix(instr, pointer) {
+		return "", instr[len("store "+typ+" ") : len(instr)-len(pointer)], true
+	}
+	return "", "", false
+}
+
+// dominators computes the immediate dominator of every block of the
+// current function by index, using the iterative algorithm of Cooper,
+// Harvey and Kennedy. Blocks unreachable from the entry get -1.
+func (c *CodeGen) dominators() (idom []int, preds, succs [][]int) {
+	index := map[string]int{}
+	for i, b := range c.blocks {
+		index[b.label] = i
+	}
+	preds = make([][]int, len(c.blocks))
+	succs = make([][]int, len(c.blocks))
+	for i, b := range c.blocks {
+		for _, label := range b.successors() {
+			if j, ok := index[label]; ok {
+				succs[i] = append(succs[i], j)
+				preds[j] = append(preds[j], i)
+			}
+		}
+	}
+
+	// Reverse postorder numbering
+	order := []int{}
+	visited := make([]bool, len(c.blocks))
+	var visit func(int)
+	visit = func(b int) {
+		visited[b] = true
+		for _, s := range succs[b] {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(0)
+	rpo := make([]int, len(c.blocks))
+	for i, b := range order {
+		rpo[b] = len(order) - 1 - i
+	}
+
+	idom = make([]int, len(c.blocks))
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for rpo[a] > rpo[b] {
+				a = idom[a]
+			}
+			for rpo[b] > rpo[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 2; i >= 0; i-- {
+			b := order[i]
+			newIdom := -1
+			for _, p := range preds[b] {
+				if idom[p] == -1 {
+					continue
+				}
+				if newIdom == -1 {
+					newIdom = p
+				} else {
+					newIdom = intersect(p, newIdom)
+				}
+			}
+			if idom[b] != newIdom {
+				idom[b] = newIdom
+				changed = true
+			}
+		}
+	}
+	return idom, preds, succs
+}
+
+// phiReg returns a register for a phi merging the values of slot, named
+// as a version of the slot's variable.
+func (c *CodeGen) phiReg(slot string) int {
+	if hint, ok := c.regNames[slot[1:]]; ok {
+		return c.nextNamedReg(strings.TrimSuffix(hint, ".addr") + ".")
+	}
+	return c.nextReg()
+}
+
+// promoteAllocas rewrites scalar stack slots that are only loaded from
+// and stored to into SSA values, placing phis on the iterated dominance
+// frontier of the stores, and returns the number of slots promoted.
+func (c *CodeGen) promoteAllocas() int {
+	// Candidate slots are the entry block's non-array allocas
+	slotTypes := map[string]string{}
+	slots := []string{}
+	for _, instr := range c.blocks[0].instrs {
+		m := valueDef.FindStringSubmatch(instr)
+		if m == nil || m[2] != "alloca" {
+			continue
+		}
+		typ := strings.TrimSuffix(resultType(m[2], m[3]), "*")
+		if strings.HasPrefix(typ, "[") {
+			continue
+		}
+		slotTypes["%"+m[1]] = typ
+		slots = append(slots, "%"+m[1])
+	}
+
+	// A slot whose address escapes or is used other than by a load or
+	// store must stay in memory
+	for _, b := range c.blocks {
+		for _, instr := range b.instrs {
+			if isComment(instr) {
+				continue
+			}
+			for _, ref := range localRef.FindAllString(instr, -1) {
+				typ, ok := slotTypes[ref]
+				if !ok || strings.HasPrefix(instr, ref+" = alloca ") {
+					continue
+				}
+				if _, _, ok := slotAccess(instr, ref, typ); !ok {
+					delete(slotTypes, ref)
+				}
+			}
+		}
+	}
+	promoted := slots[:0]
+	for _, slot := range slots {
+		if _, ok := slotTypes[slot]; ok {
+			promoted = append(promoted, slot)
+		}
+	}
+	if len(promoted) == 0 {
+		return 0
+	}
+
+	idom, preds, succs := c.dominators()
+	frontier := make([][]int, len(c.blocks))
+	for b := range c.blocks {
+		if len(preds[b]) < 2 || idom[b] == -1 {
+			continue
+		}
+		for _, p := range preds[b] {
+			for runner := p; runner != idom[b] && idom[runner] != -1; runner = idom[runner] {
+				frontier[runner] = append(frontier[runner], b)
+			}
+		}
+	}
+
+	// Place phis
+	phis := make([][]string, len(c.blocks))             // slots with a phi, per block
+	phiRegs := make([]map[string]string, len(c.blocks)) // phi register per slot, per block
+	incoming := make([]map[string][]string, len(c.blocks))
+	for _, slot := range promoted {
+		worklist := []int{}
+		for i, b := range c.blocks {
+			for _, instr := range b.instrs {
+				if _, stored, ok := slotAccess(instr, slot, slotTypes[slot]); ok && stored != "" {
+					worklist = append(worklist, i)
+					break
+				}
+			}
+		}
+		hasPhi := map[int]bool{}
+		for len(worklist) > 0 {
+			b := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+			for _, f := range frontier[b] {
+				if hasPhi[f] {
+					continue
+				}
+				hasPhi[f] = true
+				phis[f] = append(phis[f], slot)
+				if phiRegs[f] == nil {
+					phiRegs[f] = map[string]string{}
+					incoming[f] = map[string][]string{}
+				}
+				phiRegs[f][slot] = fmt.Sprintf("%%%d", c.phiReg(slot))
+				worklist = append(worklist, f)
+			}
+		}
+	}
+
+	// Rename along the dominator tree
+	children := make([][]int, len(c.blocks))
+	for b, d := range idom {
+		if b != 0 && d != -1 {
+			children[d] = append(children[d], b)
+		}
+	}
+	replacements := map[string]string{}
+	var rename func(b int, reaching map[string]string)
+	rename = func(b int, reaching map[string]string) {
+		current := map[string]string{}
+		for slot, value := range reaching {
+			current[slot] = value
+		}
+		for _, slot := range phis[b] {
+			current[slot] = phiRegs[b][slot]
+		}
+
+		kept := []string{}
+	instrs:
+		for _, instr := range c.blocks[b].instrs {
+			for _, slot := range promoted {
+				if strings.HasPrefix(instr, slot+" = alloca ") {
+					continue instrs
+				}
+				loaded, stored, ok := slotAccess(instr, slot, slotTypes[slot])
+				if !ok {
+					continue
+				}
+				if loaded != "" {
+					replacements[loaded] = current[slot]
+				} else {
+					current[slot] = stored
+				}
+				continue instrs
+			}
+			kept = append(kept, instr)
+		}
+		c.blocks[b].instrs = kept
+
+		for _, s := range succs[b] {
+			for _, slot := range phis[s] {
+				incoming[s][slot] = append(incoming[s][slot], fmt.Sprintf("[ %s, %%%s ]", current[slot], c.blocks[b].label))
+			}
+		}
+		for _, child := range children[b] {
+			rename(child, current)
+		}
+	}
+	initial := map[string]string{}
+	for _, slot := range promoted {
+		initial[slot] = "undef"
+	}
+	rename(0, initial)
+
+	for b, slots := range phis {
+		defs := []string{}
+		for _, slot := range slots {
+			defs = append(defs, fmt.Sprintf("%s = phi %s %s", phiRegs[b][slot], slotTypes[slot], strings.Join(incoming[b][slot], ", ")))
+		}
+		c.blocks[b].instrs = append(defs, c.blocks[b].instrs...)
+	}
+	c.substitute(replacements)
+	return len(promoted)
+}
@@ -0,0 +1,194 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// generateAddress emits code computing the address of an lvalue and returns
+// it along with the type of the object stored there.
+func (c *CodeGen) generateAddress(expr ast.Expression) (string, *ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		varReg := c.variables[e.Name]
+		if varReg == 0 {
+			return "", nil, c.undefined(ErrUndefinedVariable, e)
+		}
+		return fmt.Sprintf("%%%d", varReg), c.varTypes[e.Name], nil
+	case *ast.IndexExpr:
+		return c.generateIndexAddress(e)
+	case *ast.UnaryOp:
+		if e.Operator == "*" {
+			t, err := c.typeOf(e.Operand)
+			if err != nil {
+				return "", nil, err
+			}
+			if t.Kind != ast.PointerType || t.IsFuncPointer() {
+				return "", nil, fmt.Errorf("cannot assign through %s", t)
+			}
+			ptr, err := c.generateExpression(e.Operand)
+			if err != nil {
+				return "", nil, err
+			}
+			return ptr, t.Elem, nil
+		}
+	}
+	return "", nil, fmt.Errorf("expression is not assignable: %s", parser.FormatExpr(expr))
+}
+
+// generateIndexAddress emits the address of array[index].
+func (c *CodeGen) generateIndexAddress(e *ast.IndexExpr) (string, *ast.Type, error) {
+	// Index directly into arrays stored in local variables so their length
+	// stays known; anything else is indexed through a pointer value
+	if base, baseType, err := c.generateArrayBase(e.Array); err != nil {
+		return "", nil, err
+	} else if baseType != nil {
+		index, err := c.generateIndexValue(e.Index)
+		if err != nil {
+			return "", nil, err
+		}
+		if c.opts.BoundsChecks {
+			c.generateBoundsCheck(index, baseType.Len)
+		}
+		addr, err := c.generateElementAddress(base, baseType, index, "arrayidx")
+		return addr, baseType.Elem, err
+	}
+
+	t, err := c.typeOf(e.Array)
+	if err != nil {
+		return "", nil, err
+	}
+	if t.Kind != ast.PointerType || t.IsFuncPointer() {
+		return "", nil, fmt.Errorf("subscripted value %s is not an array or pointer", e.Array)
+	}
+	ptr, err := c.generateExpression(e.Array)
+	if err != nil {
+		return "", nil, err
+	}
+	index, err := c.generateIndexValue(e.Index)
+	if err != nil {
+		return "", nil, err
+	}
+	addrReg := c.nextNamedReg("arrayidx")
+	elem := c.llvmType(t.Elem)
+	c.emit("%%%d = getelementptr inbounds %s, %s* %s, i64 %s", addrReg, elem, elem, ptr, index)
+	return fmt.Sprintf("%%%d", addrReg), t.Elem, nil
+}
+
+// generateArrayBase returns the address and type of expr when it denotes an
+// array object (a local array or a row of a multi-dimensional one), or a
+// nil type otherwise.
+func (c *CodeGen) generateArrayBase(expr ast.Expression) (string, *ast.Type, error) {
+	if arrayObjectType(expr, c.varTypes) == nil {
+		return "", nil, nil
+	}
+	return c.generateAddress(expr)
+}
+
+// arrayObjectType returns the array type of expr if it designates an array
+// object whose length is known, without emitting any code.
+func arrayObjectType(expr ast.Expression, varTypes map[string]*ast.Type) *ast.Type {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if t, ok := varTypes[e.Name]; ok && t.Kind == ast.ArrayType {
+			return t
+		}
+	case *ast.IndexExpr:
+		if outer := arrayObjectType(e.Array, varTypes); outer != nil && outer.Elem.Kind == ast.ArrayType {
+			return outer.Elem
+		}
+	}
+	return nil
+}
+
+// generateElementAddress emits a GEP to element index, an i64, of the
+// array at base and names the result after hint.
+func (c *CodeGen) generateElementAddress(base string, arrayType *ast.Type, index, hint string) (string, error) {
+	addrReg := c.nextNamedReg(hint)
+	t := c.llvmType(arrayType)
+	c.emit("%%%d = getelementptr inbounds %s, %s* %s, i64 0, i64 %s", addrReg, t, t, base, index)
+	return fmt.Sprintf("%%%d", addrReg), nil
+}
+
+// generateIndexValue evaluates an array index, which must have an integer
+// type, extended to i64, the width of GEP indexes and the bounds checks,
+// so that a long index is not truncated.
+func (c *CodeGen) generateIndexValue(expr ast.Expression) (string, error) {
+	t, err := c.typeOf(expr)
+	if err != nil {
+		return "", err
+	}
+	if !t.IsInteger() {
+		return "", fmt.Errorf("array subscript %s is not an integer", expr)
+	}
+	value, err := c.generateExpressionAs(expr, t)
+	if err != nil {
+		return "", err
+	}
+	from := c.llvmType(t)
+	if from == "i64" {
+		return value, nil
+	}
+	if v, ok := constantValue(value); ok {
+		if c.unsignedChar(t) {
+			v = int64(uint8(v))
+		}
+		return strconv.FormatInt(v, 10), nil
+	}
+	opcode := "sext"
+	if c.unsignedChar(t) {
+		opcode = "zext"
+	}
+	reg := c.nextNamedReg("idxprom")
+	c.emit("%%%d = %s %s %s to i64", reg, opcode, from, value)
+	return fmt.Sprintf("%%%d", reg), nil
+}
+
+// widenToI64 sign-extends an i32 operand to i64, as GEP indexes and
+// memory intrinsic sizes require.
+func (c *CodeGen) widenToI64(value string) string {
+	if _, ok := constantValue(value); ok {
+		return value
+	}
+	reg := c.nextNamedReg("idxprom")
+	c.emit("%%%d = sext i32 %s to i64", reg, value)
+	return fmt.Sprintf("%%%d", reg)
+}
+
+func (c *CodeGen) generateIndex(e *ast.IndexExpr) (string, error) {
+	addr, t, err := c.generateIndexAddress(e)
+	if err != nil {
+		return "", err
+	}
+	// Indexing a row of a multi-dimensional array yields the decayed row
+	if t.Kind == ast.ArrayType {
+		return c.generateElementAddress(addr, t, "0", "arraydecay")
+	}
+	return c.generateLoad(addr, t), nil
+}
+
+func (c *CodeGen) generateAssignment(a *ast.Assignment) (string, error) {
+	addr, t, err := c.generateAddress(a.Target)
+	if err != nil {
+		return "", err
+	}
+	if t.Kind == ast.ArrayType {
+		return "", fmt.Errorf("array %s is not assignable", a.Target)
+	}
+	value, err := c.generateExpressionAs(a.Value, t)
+	if err != nil {
+		return "", err
+	}
+	c.emit("store %s %s, %s* %s, align %d", c.llvmType(t), value, c.llvmType(t), addr, c.target.AlignOf(t))
+	return value, nil
+}
+
+// generateLoad loads a value of type t from addr.
+func (c *CodeGen) generateLoad(addr string, t *ast.Type) string {
+	loadReg := c.nextReg()
+	c.emit("%%%d = load %s, %s* %s, align %d", loadReg, c.llvmType(t), c.llvmType(t), addr, c.target.AlignOf(t))
+	return fmt.Sprintf("%%%d", loadReg)
+}
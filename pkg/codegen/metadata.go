@@ -0,0 +1,134 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Version is the Citadel release recorded in the modules it generates.
+const Version = "0.1.0"
+
+// IRCompatibility says which LLVM releases accept the IR generated. It
+// spells pointer types out, as i8* rather than ptr, which LLVM dropped
+// after release 16.
+const IRCompatibility = "LLVM 7 to 15; LLVM 16 with -opaque-pointers=0"
+
+// FramePointerPolicies lists the accepted values of Options.FramePointer,
+// indexed by their value in the frame-pointer module flag.
+var FramePointerPolicies = []string{"none", "non-leaf", "all"}
+
+// StackProtectors lists the accepted values of Options.StackProtector,
+// the attributes of clang's -fstack-protector, -fstack-protector-strong
+// and -fstack-protector-all: ssp guards functions with character arrays,
+// sspstrong those with any array or local whose address is taken, and
+// sspreq every function.
+var StackProtectors = []string{"ssp", "sspstrong", "sspreq"}
+
+// ModuleFlag is an entry of !llvm.module.flags. Behavior says how the
+// linker merges conflicting values (1 = error, 4 = override, 7 = max).
+type ModuleFlag struct {
+	Behavior int
+	Key      string
+	Value    int
+}
+
+// ModuleFlags returns the module flags requested by opts.
+func ModuleFlags(opts Options) []ModuleFlag {
+	flags := []ModuleFlag{}
+	if opts.WCharSize != 0 {
+		flags = append(flags, ModuleFlag{1, "wchar_size", opts.WCharSize})
+	}
+	if opts.PICLevel != 0 {
+		flags = append(flags, ModuleFlag{7, "PIC Level", opts.PICLevel})
+	}
+	for i, policy := range FramePointerPolicies {
+		if opts.FramePointer == policy {
+			flags = append(flags, ModuleFlag{7, "frame-pointer", i})
+		}
+	}
+	if opts.CFI {
+		flags = append(flags, ModuleFlag{4, "CFI Canonical Jump Tables", 1})
+	}
+	// Record the stack hardening the functions were built with, for
+	// tools that inspect the module and for LTO, which keeps the flag if
+	// any module linked in has it
+	if opts.SafeStack {
+		flags = append(flags, ModuleFlag{7, "safestack", 1})
+	}
+	if opts.ShadowCallStack {
+		flags = append(flags, ModuleFlag{7, "shadowcallstack", 1})
+	}
+	return flags
+}
+
+// Ident returns the producer string recorded in !llvm.ident.
+func Ident(opts Options) string {
+	if opts.Ident != "" {
+		return opts.Ident
+	}
+	return "citadel version " + Version
+}
+
+// namedMetadata is a named metadata list such as !llvm.module.flags.
+type namedMetadata struct {
+	name  string
+	nodes []int
+}
+
+// addMetadata registers a metadata node and returns its number, reusing an
+// identical node when one exists.
+func (c *CodeGen) addMetadata(node string) int {
+	for i, existing := range c.metadata {
+		if existing == node {
+			return i
+		}
+	}
+	c.metadata = append(c.metadata, node)
+	return len(c.metadata) - 1
+}
+
+// addModuleFlag adds an entry to !llvm.module.flags with the given merge
+// behavior (1 = error, 4 = override, 7 = max, ...).
+func (c *CodeGen) addModuleFlag(behavior int, key, value string) {
+	c.addNamedMetadata("llvm.module.flags", c.addMetadata(fmt.Sprintf("!{i32 %d, !\"%s\", %s}", behavior, key, value)))
+}
+
+// addNamedMetadata appends node to the named metadata list, creating the
+// list on first use.
+func (c *CodeGen) addNamedMetadata(name string, node int) {
+	for _, named := range c.namedMD {
+		if named.name == name {
+			named.nodes = append(named.nodes, node)
+			return
+		}
+	}
+	c.namedMD = append(c.namedMD, &namedMetadata{name: name, nodes: []int{node}})
+}
+
+// addModuleMetadata records the module flags, the producer and the
+// Citadel version.
+func (c *CodeGen) addModuleMetadata() {
+	for _, flag := range ModuleFlags(c.opts) {
+		c.addModuleFlag(flag.Behavior, flag.Key, fmt.Sprintf("i32 %d", flag.Value))
+	}
+	c.addNamedMetadata("llvm.ident", c.addMetadata(fmt.Sprintf("!{!\"%s\"}", Ident(c.opts))))
+	c.addNamedMetadata("citadel.version", c.addMetadata(fmt.Sprintf("!{!\"%s\"}", Version)))
+}
+
+// writeMetadata emits the named and numbered metadata collected while
+// generating the module.
+func (c *CodeGen) writeMetadata() {
+	if len(c.metadata) == 0 {
+		return
+	}
+	for _, named := range c.namedMD {
+		refs := []string{}
+		for _, node := range named.nodes {
+			refs = append(refs, fmt.Sprintf("!%d", node))
+		}
+		c.output.WriteString(fmt.Sprintf("!%s = !{%s}\n", named.name, strings.Join(refs, ", ")))
+	}
+	for i, node := range c.metadata {
+		c.output.WriteString(fmt.Sprintf("!%d = %s\n", i, node))
+	}
+}
@@ -0,0 +1,137 @@
+package codegen_test
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// sanitizedProgram has a function for every way of opting out of a
+// sanitizer
+const sanitizedProgram = `int g(int x) { return x; }
+__attribute__((no_sanitize("address"))) int raw(int x) { return x; }
+__attribute__((no_sanitize_thread)) int racy(int x) { return x; }
+__attribute__((no_sanitize)) int bare(int x) { return x; }
+int main() { return g(1) + raw(2) + racy(3) + bare(4); }`
+
+// attributeGroup matches a function's reference to its attribute group
+var attributeGroup = regexp.MustCompile(`define [^\n]*@(\w+)\([^\n]*\) #(\d+)`)
+
+// functionAttributes returns the attributes of each function defined in
+// ir, from the attribute group it refers to
+func functionAttributes(t *testing.T, ir string) map[string]string {
+	t.Helper()
+	attrs := map[string]string{}
+	for _, m := range attributeGroup.FindAllStringSubmatch(ir, -1) {
+		group := regexp.MustCompile(`attributes #` + m[2] + ` = \{ ([^}]*) \}`).FindStringSubmatch(ir)
+		if group == nil {
+			t.Fatalf("no attribute group #%s:\n%s", m[2], ir)
+		}
+		attrs[m[1]] = group[1]
+	}
+	return attrs
+}
+
+// TestModuleFlags checks the module flags recorded for each option, and
+// that the IR carries them as !llvm.module.flags
+func TestModuleFlags(t *testing.T) {
+	opts := codegen.Options{WCharSize: 4, PICLevel: 2, FramePointer: "all", CFI: true, SafeStack: true}
+	want := []codegen.ModuleFlag{
+		{Behavior: 1, Key: "wchar_size", Value: 4},
+		{Behavior: 7, Key: "PIC Level", Value: 2},
+		{Behavior: 7, Key: "frame-pointer", Value: 2},
+		{Behavior: 4, Key: "CFI Canonical Jump Tables", Value: 1},
+		{Behavior: 7, Key: "safestack", Value: 1},
+	}
+	if got := codegen.ModuleFlags(opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("ModuleFlags = %v, want %v", got, want)
+	}
+	if got := codegen.ModuleFlags(codegen.Options{}); len(got) != 0 {
+		t.Errorf("ModuleFlags of the zero Options = %v, want none", got)
+	}
+
+	ir := generate(t, "int main() { return 0; }", opts)
+	for _, want := range []string{
+		"!llvm.module.flags = !{!0, !1, !2, !3, !4}",
+		`!0 = !{i32 1, !"wchar_size", i32 4}`,
+		`!1 = !{i32 7, !"PIC Level", i32 2}`,
+		`!2 = !{i32 7, !"frame-pointer", i32 2}`,
+		`!3 = !{i32 4, !"CFI Canonical Jump Tables", i32 1}`,
+		`!4 = !{i32 7, !"safestack", i32 1}`,
+		`!llvm.ident = !{!5}`,
+		`!5 = !{!"citadel version ` + codegen.Version + `"}`,
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+}
+
+// TestSanitizerAttributes checks that each sanitizer tags the functions
+// but those that opt out of it, and that the attributes leave the
+// program running under lli
+func TestSanitizerAttributes(t *testing.T) {
+	ir := generate(t, sanitizedProgram, codegen.Options{SanitizeAddress: true, SanitizeThread: true})
+	want := map[string]string{
+		"g":    "sanitize_address sanitize_thread",
+		"raw":  "sanitize_thread",
+		"racy": "sanitize_address",
+		"main": "sanitize_address sanitize_thread",
+	}
+	attrs := functionAttributes(t, ir)
+	for fn, want := range want {
+		if attrs[fn] != want {
+			t.Errorf("%s has attributes %q, want %q", fn, attrs[fn], want)
+		}
+	}
+	if _, ok := attrs["bare"]; ok {
+		t.Errorf("bare has attributes %q, want none", attrs["bare"])
+	}
+
+	ir = generate(t, sanitizedProgram, codegen.Options{SanitizeMemory: true})
+	if attrs := functionAttributes(t, ir); attrs["g"] != "sanitize_memory" || attrs["raw"] != "sanitize_memory" {
+		t.Errorf("want g and raw sanitize_memory, got %v", attrs)
+	}
+
+	if status, _ := llitest.Run(t, ir); status != 10 {
+		t.Errorf("exit status %d, want 10", status)
+	}
+}
+
+// TestPICAndFramePointer checks that PIC drops dso_local from the
+// definitions that can be preempted, that the frame-pointer policy is
+// set on every function, and that llc keeps a frame pointer in a leaf
+// function under "all"
+func TestPICAndFramePointer(t *testing.T) {
+	const src = "int leaf(int x) { return x + 1; } int main() { return leaf(1); }"
+	ir := generate(t, src, codegen.Options{})
+	if !strings.Contains(ir, "define dso_local i32 @leaf(") {
+		t.Errorf("without PIC, leaf is not dso_local:\n%s", ir)
+	}
+	ir = generate(t, src, codegen.Options{PICLevel: 2, FramePointer: "all"})
+	for _, want := range []string{
+		"define i32 @leaf(i32 %x) #0 {",
+		"define i32 @main() #0 {",
+		`attributes #0 = { "frame-pointer"="all" }`,
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+
+	asm := compileIR(t, ir)
+	leaf := asm[strings.Index(asm, "leaf:"):strings.Index(asm, "main:")]
+	for _, want := range []string{"pushq\t%rbp", "movq\t%rsp, %rbp"} {
+		if !strings.Contains(leaf, want) {
+			t.Errorf("leaf lacks %q:\n%s", want, leaf)
+		}
+	}
+	asm = compileIR(t, generate(t, src, codegen.Options{FramePointer: "none"}))
+	if leaf := asm[strings.Index(asm, "leaf:"):strings.Index(asm, "main:")]; strings.Contains(leaf, "pushq\t%rbp") {
+		t.Errorf("leaf keeps a frame pointer under none:\n%s", leaf)
+	}
+}
@@ -0,0 +1,182 @@
+package codegen
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// NativeKinds are the kinds of native output Native can produce.
+var NativeKinds = []string{"asm", "obj"}
+
+// FindToolchain returns the path of the tool that compiles IR to native
+// code. An explicitly configured tool, or else the LLC environment
+// variable, wins; otherwise llc and then clang are looked up on PATH.
+func FindToolchain(tool string) (string, error) {
+	if tool == "" {
+		tool = os.Getenv("LLC")
+	}
+	if tool != "" {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			return "", fmt.Errorf("native output needs llc or clang: %v", err)
+		}
+		return path, nil
+	}
+	for _, name := range []string{"llc", "clang"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("native output needs llc or clang, but neither is on PATH; install LLVM or name the tool with -toolchain or LLC")
+}
+
+// LowerTypeTests runs LLVM's LowerTypeTests pass over textual IR with
+// opt, which the OPT environment variable can name, and returns the IR it
+// writes. The llvm.type.test calls of -cfi are meant to be lowered at link
+// time, and llc, clang without LTO and lli crash on IR that still has them;
+// lowered within the one module, the checks allow calls to the functions
+// it defines alone.
+func LowerTypeTests(ir string) (string, error) {
+	tool := os.Getenv("OPT")
+	if tool == "" {
+		tool = "opt"
+	}
+	path, err := exec.LookPath(tool)
+	if err != nil {
+		return "", fmt.Errorf("cfi outside LLVM IR output needs opt to lower the type tests: %v", err)
+	}
+	out, err := runTool(path, []string{"-passes=lowertypetests", "-S", "-o", "-", "-"}, ir)
+	if err != nil {
+		return "", fmt.Errorf("opt failed: %v", err)
+	}
+	return string(out), nil
+}
+
+// Native compiles textual IR for target to an assembly listing ("asm") or
+// an object file ("obj") with the given llc or clang binary. Position-
+// independent code is requested when opts.PICLevel is set. With opts.CFI
+// the type tests are lowered first, as LowerTypeTests does.
+func Native(ir, kind, tool string, target *Target, opts Options) ([]byte, error) {
+	if kind != "asm" && kind != "obj" {
+		return nil, fmt.Errorf("unknown native output kind %q", kind)
+	}
+	if opts.CFI {
+		lowered, err := LowerTypeTests(ir)
+		if err != nil {
+			return nil, err
+		}
+		ir = lowered
+	}
+
+	var args []string
+	name := filepath.Base(tool)
+	if strings.Contains(name, "clang") {
+		args = []string{"-x", "ir", "-target", target.Triple, "-Wno-override-module"}
+		if kind == "asm" {
+			args = append(args, "-S")
+		} else {
+			args = append(args, "-c")
+		}
+		if opts.PICLevel > 0 {
+			args = append(args, "-fPIC")
+		}
+	} else {
+		args = []string{"-mtriple=" + target.Triple, "-filetype=" + kind}
+		if opts.PICLevel > 0 {
+			args = append(args, "-relocation-model=pic")
+		}
+	}
+	args = append(args, "-o", "-", "-")
+
+	out, err := runTool(tool, args, ir)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", name, err)
+	}
+	return out, nil
+}
+
+// FindLinker returns the path of the tool Link builds executables with.
+// An explicitly configured tool wins; otherwise clang and then llc are
+// looked up on PATH.
+func FindLinker(tool string) (string, error) {
+	if tool != "" {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			return "", fmt.Errorf("linking needs clang or llc: %v", err)
+		}
+		return path, nil
+	}
+	for _, name := range []string{"clang", "llc"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("linking needs clang, or llc and a C compiler, but neither is on PATH; install LLVM or name the tool with -toolchain")
+}
+
+// Link compiles textual IR for target into an executable at out. clang
+// compiles and links it in one step, with the runtimes of the sanitizers
+// opts enables; llc compiles it to a position-independent object file
+// that the C compiler, $CC or else cc, links. With opts.CFI the type
+// tests are lowered first, as LowerTypeTests does.
+func Link(ir, out, tool string, target *Target, opts Options) error {
+	name := filepath.Base(tool)
+	if opts.CFI {
+		lowered, err := LowerTypeTests(ir)
+		if err != nil {
+			return err
+		}
+		ir, opts.CFI = lowered, false
+	}
+	var sanitizers []string
+	for _, san := range []struct {
+		on   bool
+		name string
+	}{{opts.SanitizeAddress, "address"}, {opts.SanitizeMemory, "memory"}, {opts.SanitizeThread, "thread"}} {
+		if san.on {
+			sanitizers = append(sanitizers, san.name)
+		}
+	}
+
+	if strings.Contains(name, "clang") {
+		args := []string{"-x", "ir", "-target", target.Triple, "-Wno-override-module"}
+		if len(sanitizers) > 0 {
+			args = append(args, "-fsanitize="+strings.Join(sanitizers, ","))
+		}
+		args = append(args, "-o", out, "-")
+		if _, err := runTool(tool, args, ir); err != nil {
+			return fmt.Errorf("%s failed: %v", name, err)
+		}
+		return nil
+	}
+	if len(sanitizers) > 0 {
+		return fmt.Errorf("linking the %s sanitizer runtime needs clang", strings.Join(sanitizers, " and "))
+	}
+
+	// C compilers link position-independent executables by default
+	opts.PICLevel = 2
+	obj, err := Native(ir, "obj", tool, target, opts)
+	if err != nil {
+		return err
+	}
+	objPath := out + ".o"
+	if err := os.WriteFile(objPath, obj, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(objPath)
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	path, err := exec.LookPath(cc)
+	if err != nil {
+		return fmt.Errorf("linking the object file from %s needs a C compiler: %v", name, err)
+	}
+	if _, err := runTool(path, []string{"-o", out, objPath}, ""); err != nil {
+		return fmt.Errorf("%s failed: %v", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package codegen
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	condBranch   = regexp.MustCompile(`^br i1 (\S+), label %(\S+), label %(\S+)$`)
+	switchBranch = regexp.MustCompile(`^switch (\S+) (\S+), label %(\S+) \[ (.*) \]$`)
+	switchCase   = regexp.MustCompile(`(\S+) (\S+), label %(\S+)`)
+)
+
+// obfuscated reports whether the current function is to be flattened,
+// because Options.Obfuscate names it or the source marks it with the
+// citadel_obfuscate attribute.
+func (c *CodeGen) obfuscated() bool {
+	if c.function.HasAttribute("citadel_obfuscate") {
+		return true
+	}
+	for _, name := range c.opts.Obfuscate {
+		if name == c.function.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// flatten rewrites the blocks of the current function so that none
+// branches to another directly. Every block instead stores the number of
+// its successor in a state variable and branches to a dispatcher, which
+// switches on the state to the next block. Unconditional transitions go
+// through an opaque predicate, x*(x+1) being even, that a reader of the
+// IR cannot tell never selects the decoy block it offers. Values used
+// outside the block that defines them, phis included, are first demoted
+// to stack slots, since the dispatcher is the only block that dominates
+// the others afterwards.
+func (c *CodeGen) flatten() error {
+	if len(c.blocks) < 2 {
+		return nil
+	}
+	if err := c.demoteValues(); err != nil {
+		return fmt.Errorf("cannot flatten %s: %v", c.function.Name, err)
+	}
+
+	// Block numbers are scattered rather than sequential, seeded by the
+	// function name so that the output is reproducible
+	h := fnv.New32a()
+	h.Write([]byte(c.function.Name))
+	seed := h.Sum32() | 1
+	states := map[string]string{}
+	used := map[uint32]bool{}
+	for _, b := range c.blocks[1:] {
+		for {
+			seed ^= seed << 13
+			seed ^= seed >> 17
+			seed ^= seed << 5
+			if v := seed & 0x7fffffff; !used[v] {
+				used[v] = true
+				states[b.label] = strconv.FormatUint(uint64(v), 10)
+				break
+			}
+		}
+	}
+
+	state := c.nextNamedReg("state")
+	dispatch := &basicBlock{label: strconv.Itoa(c.nextNamedLabel("dispatch"))}
+	current := c.nextNamedReg("state.cur")
+	trap := &basicBlock{label: strconv.Itoa(c.nextNamedLabel("dispatch.default")), instrs: []string{"unreachable"}}
+
+	// decoy picks a block other than label for an opaque predicate to
+	// offer
+	decoy := func(label string) string {
+		for _, b := range c.blocks[1:] {
+			if b.label != label {
+				seed = seed*1103515245 + 12345
+				if seed%3 == 0 {
+					return b.label
+				}
+			}
+		}
+		for _, b := range c.blocks[1:] {
+			if b.label != label {
+				return b.label
+			}
+		}
+		return label
+	}
+
+	cases := []string{}
+	for i, b := range c.blocks {
+		if i > 0 {
+			cases = append(cases, fmt.Sprintf("i32 %s, label %%%s", states[b.label], b.label))
+		}
+		term := b.terminator()
+		if term == "" || !strings.HasPrefix(term, "br ") && !strings.HasPrefix(term, "switch ") {
+			continue
+		}
+		b.instrs = b.instrs[:len(b.instrs)-1]
+		next := ""
+		switch m := condBranch.FindStringSubmatch(term); {
+		case m != nil:
+			reg := c.nextReg()
+			b.instrs = append(b.instrs, fmt.Sprintf("%%%d = select i1 %s, i32 %s, i32 %s", reg, m[1], states[m[2]], states[m[3]]))
+			next = fmt.Sprintf("%%%d", reg)
+		case strings.HasPrefix(term, "switch "):
+			s := switchBranch.FindStringSubmatch(term)
+			if s == nil {
+				return fmt.Errorf("cannot flatten %s: unrecognized terminator %q", c.function.Name, term)
+			}
+			next = states[s[3]]
+			for _, cs := range switchCase.FindAllStringSubmatch(s[4], -1) {
+				eq, sel := c.nextReg(), c.nextReg()
+				b.instrs = append(b.instrs,
+					fmt.Sprintf("%%%d = icmp eq %s %s, %s", eq, s[1], s[2], cs[2]),
+					fmt.Sprintf("%%%d = select i1 %%%d, i32 %s, i32 %s", sel, eq, states[cs[3]], next))
+				next = fmt.Sprintf("%%%d", sel)
+			}
+		default:
+			target := strings.TrimPrefix(term, "br label %")
+			next = states[target]
+			if i > 0 {
+				// The dispatcher dominates every block but the entry, so
+				// the state it loaded is available here
+				plus, product, parity, even, sel := c.nextReg(), c.nextReg(), c.nextReg(), c.nextReg(), c.nextReg()
+				b.instrs = append(b.instrs,
+					fmt.Sprintf("%%%d = add i32 %%%d, 1", plus, current),
+					fmt.Sprintf("%%%d = mul i32 %%%d, %%%d", product, current, plus),
+					fmt.Sprintf("%%%d = and i32 %%%d, 1", parity, product),
+					fmt.Sprintf("%%%d = icmp eq i32 %%%d, 0", even, parity),
+					fmt.Sprintf("%%%d = select i1 %%%d, i32 %s, i32 %s", sel, even, next, states[decoy(target)]))
+				next = fmt.Sprintf("%%%d", sel)
+			}
+		}
+		b.instrs = append(b.instrs,
+			fmt.Sprintf("store i32 %s, i32* %%%d, align 4", next, state),
+			"br label %"+dispatch.label)
+	}
+
+	dispatch.instrs = []string{
+		fmt.Sprintf("%%%d = load i32, i32* %%%d, align 4", current, state),
+		fmt.Sprintf("switch i32 %%%d, label %%%s [ %s ]", current, trap.label, strings.Join(cases, " ")),
+	}
+	entry := c.blocks[0]
+	entry.instrs = append([]string{fmt.Sprintf("%%%d = alloca i32, align 4", state)}, entry.instrs...)
+	c.blocks = append([]*basicBlock{entry, dispatch}, append(c.blocks[1:], trap)...)
+	c.record("flatten", "blocks flattened", len(c.blocks)-3)
+	return nil
+}
+
+// demoteValues replaces the phis of the current function with stack
+// slots stored by their predecessors, and values used outside the block
+// that defines them, other than in the entry block, with slots stored
+// after the definition and loaded before each use.
+func (c *CodeGen) demoteValues() error {
+	entry := c.blocks[0]
+	allocas := []string{}
+
+	// Phis first: each becomes a load of a slot its predecessors store
+	for _, b := range c.blocks {
+		for i, instr := range b.instrs {
+			m := valueDef.FindStringSubmatch(instr)
+			if m == nil || m[2] != "phi" {
+				continue
+			}
+			typ := resultType(m[2], m[3])
+			slot := c.nextReg()
+			allocas = append(allocas, fmt.Sprintf("%%%d = alloca %s", slot, typ))
+			for _, in := range phiIncoming.FindAllStringSubmatch(m[3], -1) {
+				pred := c.blocks[c.blockIndex(in[2])]
+				last := len(pred.instrs) - 1
+				pred.instrs = append(pred.instrs[:last], fmt.Sprintf("store %s %s, %s* %%%d", typ, in[1], typ, slot), pred.instrs[last])
+			}
+			b.instrs[i] = fmt.Sprintf("%%%s = load %s, %s* %%%d", m[1], typ, typ, slot)
+		}
+	}
+
+	// Then values crossing blocks
+	home := map[string]*basicBlock{}
+	types := map[string]string{}
+	for _, b := range c.blocks {
+		for _, instr := range b.instrs {
+			if m := valueDef.FindStringSubmatch(instr); m != nil {
+				home[m[1]] = b
+				types[m[1]] = resultType(m[2], m[3])
+			}
+		}
+	}
+	crossing := map[string]bool{}
+	for _, b := range c.blocks {
+		for _, instr := range b.instrs {
+			if isComment(instr) {
+				continue
+			}
+			for _, ref := range localRef.FindAllStringSubmatch(instr, -1) {
+				if def, ok := home[ref[1]]; ok && def != b && def != entry {
+					crossing[ref[1]] = true
+				}
+			}
+			// Uses spell out the type of their operands where the
+			// definition does not
+			for _, m := range typedRef.FindAllStringSubmatch(instr, -1) {
+				if types[m[2]] == "" && m[3] != "(" {
+					types[m[2]] = m[1]
+				}
+			}
+			if m := binaryOp.FindStringSubmatch(instr); m != nil && strings.HasPrefix(m[3], "%") && types[m[3][1:]] == "" {
+				types[m[3][1:]] = m[1]
+			}
+		}
+	}
+	slots := map[string]int{}
+	for id := range crossing {
+		if types[id] == "" {
+			return fmt.Errorf("the type of %%%s is not known", id)
+		}
+		slots[id] = c.nextReg()
+	}
+	for _, id := range sortedKeys(crossing) {
+		allocas = append(allocas, fmt.Sprintf("%%%d = alloca %s", slots[id], types[id]))
+	}
+	for _, b := range c.blocks {
+		instrs := []string{}
+		for _, instr := range b.instrs {
+			if !isComment(instr) {
+				loaded := map[string]string{}
+				for _, ref := range localRef.FindAllStringSubmatch(instr, -1) {
+					id := ref[1]
+					if _, ok := slots[id]; !ok || home[id] == b || loaded["%"+id] != "" {
+						continue
+					}
+					reg := c.nextReg()
+					instrs = append(instrs, fmt.Sprintf("%%%d = load %s, %s* %%%d", reg, types[id], types[id], slots[id]))
+					loaded["%"+id] = fmt.Sprintf("%%%d", reg)
+				}
+				instr = substituteIn(instr, loaded)
+			}
+			instrs = append(instrs, instr)
+			if m := valueDef.FindStringSubmatch(instr); m != nil {
+				if slot, ok := slots[m[1]]; ok {
+					instrs = append(instrs, fmt.Sprintf("store %s %%%s, %s* %%%d", types[m[1]], m[1], types[m[1]], slot))
+				}
+			}
+		}
+		b.instrs = instrs
+	}
+	entry.instrs = append(allocas, entry.instrs...)
+	return nil
+}
+
+// sortedKeys returns the value numbers in set in ascending order.
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		x, _ := strconv.Atoi(keys[i])
+		y, _ := strconv.Atoi(keys[j])
+		return x < y
+	})
+	return keys
+}
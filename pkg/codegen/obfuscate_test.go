@@ -0,0 +1,93 @@
+package codegen_test
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// functionIR returns the body of the definition of fn in ir
+func functionIR(ir, fn string) string {
+	start := strings.Index(ir, "@"+fn+"(")
+	if start < 0 {
+		return ""
+	}
+	end := strings.Index(ir[start:], "\n}\n")
+	return ir[start : start+end]
+}
+
+// branch matches a branch and the labels it goes to
+var branch = regexp.MustCompile(`\bbr (?:i1 \S+, )?label %(\S+)(?:, label %(\S+))?`)
+
+// TestFlatten checks that the functions Obfuscate names, or that carry
+// citadel_obfuscate, branch only through a dispatcher that switches on
+// the state, that the others are left alone, that the output is the same
+// on every run, and that flattened programs behave as they did, at -O0
+// and -O1, under lli
+func TestFlatten(t *testing.T) {
+	const src = `int sum(int n) {
+    int i = 0;
+    int s = 0;
+    while (i < n) {
+        if (i > 5) { break; }
+        s = s + i;
+        i = i + 1;
+    }
+    return s;
+}
+__attribute__((citadel_obfuscate)) int pick(int k) {
+    switch (k) {
+    case 1: return 10;
+    case 2: return 20;
+    default: break;
+    }
+    if (k > 2 && k < 9) { return k; }
+    return 0;
+}
+int main() { return sum(10) + pick(2) + pick(7); }`
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range []int{0, 1} {
+		plain, err := codegen.NewWithOptions(codegen.Options{OptLevel: opt}).Generate(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := codegen.Options{OptLevel: opt, Obfuscate: []string{"sum"}}
+		ir, err := codegen.NewWithOptions(opts).Generate(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := codegen.NewWithOptions(opts).Generate(program); again != ir {
+			t.Errorf("-O%d: the flattened IR differs from one run to the next", opt)
+		}
+		for _, fn := range []string{"sum", "pick"} {
+			body := functionIR(ir, fn)
+			if !strings.Contains(body, "switch i32 ") || !strings.Contains(body, "dispatch") {
+				t.Errorf("-O%d: %s has no dispatcher:\n%s", opt, fn, body)
+			}
+			for _, m := range branch.FindAllStringSubmatch(body, -1) {
+				if m[1] != "dispatch" || m[2] != "" {
+					t.Errorf("-O%d: %s branches past the dispatcher with %q:\n%s", opt, fn, m[0], body)
+				}
+			}
+		}
+		if body := functionIR(ir, "main"); strings.Contains(body, "dispatch") {
+			t.Errorf("-O%d: main is flattened:\n%s", opt, body)
+		}
+		// Running is a subtest, for the checks above to go on without lli
+		t.Run(fmt.Sprintf("O%d", opt), func(t *testing.T) {
+			want, _ := llitest.Run(t, plain)
+			if got, _ := llitest.Run(t, ir); got != want {
+				t.Errorf("-O%d: exit status %d flattened, %d not", opt, got, want)
+			}
+		})
+	}
+}
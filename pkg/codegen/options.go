@@ -0,0 +1,163 @@
+package codegen
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Options controls optional features of the generated IR.
+type Options struct {
+	// OptLevel selects the optimizations run before the IR is written.
+	// 0 emits every operation as written; 1 folds constants, promotes
+	// scalar locals to SSA values, eliminates common subexpressions
+	// within blocks and removes dead blocks.
+	OptLevel int
+	// DiscardValueNames numbers every value and block (%1, %2, ...)
+	// instead of naming them after the source (%x.addr, %cmp, %if.then).
+	DiscardValueNames bool
+	// SourceComments precedes the instructions of each statement with a
+	// comment giving its location and source line, "; file.c:12: if (x > 0)".
+	// SourceFile is the name shown and Source the text lines are taken from.
+	// For functions parser.Merge took from several files, the name shown
+	// is their File and Sources holds the text of each file by name.
+	SourceComments bool
+	SourceFile     string
+	Source         string
+	Sources        map[string]string
+	// StackUsage precedes each definition with a comment estimating its
+	// stack frame and attaches the total as !citadel.stack metadata.
+	StackUsage bool
+
+	// Target is the target triple the IR is generated for; "" selects
+	// DefaultTriple. See LookupTarget for the supported architectures.
+	Target string
+
+	// SafeStack tags every function with the safestack attribute, which
+	// moves unsafe stack objects to a separate stack when compiled by LLVM.
+	SafeStack bool
+	// ShadowCallStack tags every function with the shadowcallstack
+	// attribute so return addresses are kept on a separate shadow stack.
+	ShadowCallStack bool
+	// StackProtector tags every function with one of StackProtectors, for
+	// LLVM to guard their frames with a canary checked before they
+	// return; "" leaves them unguarded.
+	StackProtector string
+
+	// SanitizeAddress, SanitizeMemory and SanitizeThread tag functions
+	// with the matching sanitize_* attribute so LLVM's sanitizer passes
+	// instrument them. Functions annotated with no_sanitize in the source
+	// are left untagged.
+	SanitizeAddress bool
+	SanitizeMemory  bool
+	SanitizeThread  bool
+	// CFI guards every indirect call with an llvm.type.test check against
+	// the callee's signature and tags definitions with matching !type
+	// metadata, so LLVM's LowerTypeTests pass can enforce forward-edge
+	// control-flow integrity at link time.
+	CFI bool
+	// BoundsChecks compares the index against the array length before
+	// every access to a locally sized array and branches to a trap block
+	// when it is out of range.
+	BoundsChecks bool
+	// OverflowChecks lowers signed +, - and * to the llvm.s*.with.overflow
+	// intrinsics and traps when the result wraps.
+	OverflowChecks bool
+	// DivisionChecks traps before sdiv/srem when the divisor is zero or
+	// the division is INT_MIN / -1.
+	DivisionChecks bool
+	// ProvenDivisions holds what the value ranges of the program prove
+	// of its divisions and remainders, as analysis.ProveDivisions finds
+	// it. DivisionChecks skips the checks a proof rules out.
+	ProvenDivisions map[*ast.BinaryOp]DivisionProof
+
+	// Obfuscate names the functions whose control flow is flattened
+	// through a dispatcher block, with opaque predicates guarding their
+	// transitions, to make the IR harder to follow. Functions with the
+	// citadel_obfuscate attribute are flattened as well.
+	Obfuscate []string
+
+	// CallingConv is the calling convention of functions that do not
+	// name one with an attribute: one of CallingConvs, or "" for ccc.
+	CallingConv string
+
+	// SymbolPrefix is prepended to the names of functions defined in the
+	// module so the output can be linked into larger programs without
+	// symbol collisions.
+	SymbolPrefix string
+	// Internalize gives internal linkage to every defined function except
+	// main and those named in Exports.
+	Internalize bool
+	Exports     []string
+	// DefaultVisibility is the visibility of defined functions without a
+	// visibility attribute, one of Visibilities; "" means default.
+	DefaultVisibility string
+
+	// WCharSize records the size of wchar_t in bytes in the wchar_size
+	// module flag, which the linker checks for consistency; 0 omits it.
+	WCharSize int
+	// PICLevel records the PIC Level module flag: 1 for -fpic, 2 for
+	// -fPIC, or 0 to omit it.
+	PICLevel int
+	// FramePointer is the frame-pointer policy, one of
+	// FramePointerPolicies. It is recorded as a module flag and set on
+	// every function; "" leaves the choice to the backend.
+	FramePointer string
+	// Ident is the producer string for !llvm.ident. It defaults to the
+	// Citadel version, which is also recorded in !citadel.version.
+	Ident string
+
+	// Logger traces the functions generated and what the passes change
+	// in them; nil logs nothing.
+	Logger *slog.Logger
+	// Context, if set, stops generation between functions once it is
+	// done, with its error.
+	Context context.Context
+}
+
+// DivisionProof is what is known of the operands of a division.
+type DivisionProof struct {
+	// NonzeroDivisor is set when the divisor is never zero.
+	NonzeroDivisor bool
+	// NoOverflow is set when the operands are never the minimum of the
+	// type and -1 together.
+	NoOverflow bool
+}
+
+// Canceled returns the error of opts.Context once it is done, or nil.
+func Canceled(opts Options) error {
+	if opts.Context == nil {
+		return nil
+	}
+	return opts.Context.Err()
+}
+
+// Option sets a field of the Options New creates a code generator with.
+type Option func(*Options)
+
+// WithTarget generates IR for the target triple.
+func WithTarget(triple string) Option {
+	return func(o *Options) { o.Target = triple }
+}
+
+// WithOptLevel sets the OptLevel.
+func WithOptLevel(level int) Option {
+	return func(o *Options) { o.OptLevel = level }
+}
+
+// WithLogger traces generation to logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) { o.Logger = logger }
+}
+
+// WithContext stops generation once ctx is done.
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) { o.Context = ctx }
+}
+
+// WithOptions replaces all of the Options with opts, for the options that
+// have no With function; later options change it further.
+func WithOptions(opts Options) Option {
+	return func(o *Options) { *o = opts }
+}
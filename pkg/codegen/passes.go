@@ -0,0 +1,174 @@
+package codegen
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/internal/logging"
+)
+
+// PassStat records how much one optimization pass changed one function.
+type PassStat struct {
+	Function string
+	Pass     string
+	Changes  int
+	Unit     string // what the changes are, e.g. "allocas promoted"
+}
+
+// PassStats returns what the optimization passes changed, grouped by
+// function in generation order. It is empty at -O0.
+func (c *CodeGen) PassStats() []PassStat {
+	return c.passStats
+}
+
+// record adds changes made by a pass to the current function's stats.
+func (c *CodeGen) record(pass, unit string, changes int) {
+	if changes == 0 {
+		return
+	}
+	logging.Or(c.opts.Logger).Log(context.Background(), logging.LevelTrace, "pass changed function", "function", c.function.Name, "pass", pass, "changes", changes, "unit", unit)
+	for i := range c.passStats {
+		stat := &c.passStats[i]
+		if stat.Function == c.function.Name && stat.Pass == pass {
+			stat.Changes += changes
+			return
+		}
+	}
+	c.passStats = append(c.passStats, PassStat{Function: c.function.Name, Pass: pass, Changes: changes, Unit: unit})
+}
+
+// optimize runs the -O1 pipeline over the blocks of the current function:
+// dead block elimination, mem2reg, constant folding to a fixed point and
+// block-local common subexpression elimination once blocks are merged.
+func (c *CodeGen) optimize() {
+	c.skipEmptyBlocks()
+	c.record("dce", "blocks removed", c.removeDeadBlocks())
+	c.record("mem2reg", "allocas promoted", c.promoteAllocas())
+	c.prunePhis()
+	for {
+		folded := c.foldInstructions()
+		if folded == 0 {
+			break
+		}
+		c.record("constfold", "instructions folded", folded)
+		c.record("dce", "blocks removed", c.removeDeadBlocks())
+		c.prunePhis()
+	}
+	c.skipEmptyBlocks()
+	c.record("dce", "blocks removed", c.removeDeadBlocks()+c.mergeBlocks())
+	c.record("cse", "instructions removed", c.eliminateCommonSubexpressions())
+}
+
+// substitute rewrites every use of the values in replacements, following
+// chains so that a value replaced by another replaced value ends up at
+// the final one.
+func (c *CodeGen) substitute(replacements map[string]string) {
+	if len(replacements) == 0 {
+		return
+	}
+	for _, b := range c.blocks {
+		for i, instr := range b.instrs {
+			if !isComment(instr) {
+				b.instrs[i] = substituteIn(instr, replacements)
+			}
+		}
+	}
+}
+
+func substituteIn(instr string, replacements map[string]string) string {
+	return localRef.ReplaceAllStringFunc(instr, func(ref string) string {
+		for {
+			next, ok := replacements[ref]
+			if !ok {
+				return ref
+			}
+			ref = next
+		}
+	})
+}
+
+// pureOps are the opcodes whose result depends only on their operands.
+var pureOps = map[string]bool{
+	"add": true, "sub": true, "mul": true, "sdiv": true, "srem": true,
+	"and": true, "or": true, "xor": true, "icmp": true, "getelementptr": true,
+	"zext": true, "sext": true, "trunc": true, "bitcast": true,
+}
+
+// eliminateCommonSubexpressions replaces pure instructions that repeat an
+// earlier computation in the same block with the earlier result and
+// returns the number removed.
+func (c *CodeGen) eliminateCommonSubexpressions() int {
+	removed := 0
+	replacements := map[string]string{}
+	for _, b := range c.blocks {
+		seen := map[string]string{}
+		kept := b.instrs[:0]
+		for _, instr := range b.instrs {
+			if !isComment(instr) {
+				instr = substituteIn(instr, replacements)
+			}
+			if m := valueDef.FindStringSubmatch(instr); m != nil && pureOps[m[2]] {
+				key := m[2] + " " + m[3]
+				if prev, ok := seen[key]; ok {
+					replacements["%"+m[1]] = prev
+					removed++
+					continue
+				}
+				seen[key] = "%" + m[1]
+			}
+			kept = append(kept, instr)
+		}
+		b.instrs = kept
+	}
+	c.substitute(replacements)
+	return removed
+}
+
+var phiIncoming = regexp.MustCompile(`\[ ([^,\]]+), %([-a-zA-Z$._0-9]+) \]`)
+
+// prunePhis drops phi entries for edges that no longer exist, then
+// replaces phis whose incoming values all agree with that value.
+func (c *CodeGen) prunePhis() {
+	preds := map[string]map[string]int{}
+	for _, b := range c.blocks {
+		for _, succ := range b.successors() {
+			if preds[succ] == nil {
+				preds[succ] = map[string]int{}
+			}
+			preds[succ][b.label]++
+		}
+	}
+
+	replacements := map[string]string{}
+	for _, b := range c.blocks {
+		kept := b.instrs[:0]
+		for _, instr := range b.instrs {
+			m := valueDef.FindStringSubmatch(instr)
+			if m == nil || m[2] != "phi" {
+				kept = append(kept, instr)
+				continue
+			}
+			typ := m[3][:strings.Index(m[3], " [")]
+			entries := []string{}
+			values := map[string]bool{}
+			for _, in := range phiIncoming.FindAllStringSubmatch(m[3], -1) {
+				if preds[b.label][in[2]] > 0 {
+					entries = append(entries, in[0])
+					if in[1] != "%"+m[1] {
+						values[in[1]] = true
+					}
+				}
+			}
+			if len(values) == 1 {
+				for value := range values {
+					replacements["%"+m[1]] = value
+				}
+				continue
+			}
+			kept = append(kept, "%"+m[1]+" = phi "+typ+" "+strings.Join(entries, ", "))
+		}
+		b.instrs = kept
+	}
+	c.substitute(replacements)
+}
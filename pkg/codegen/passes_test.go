@@ -0,0 +1,88 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// generateAt lowers src at opt level opt and returns the IR and what the
+// passes changed
+func generateAt(t *testing.T, src string, opt int) (string, []codegen.PassStat) {
+	t.Helper()
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	gen := codegen.NewWithOptions(codegen.Options{OptLevel: opt})
+	ir, err := gen.Generate(program)
+	if err != nil {
+		t.Fatalf("%s at -O%d: %v", src, opt, err)
+	}
+	return ir, gen.PassStats()
+}
+
+// changes returns how much pass changed fn
+func changes(stats []codegen.PassStat, fn, pass string) int {
+	for _, stat := range stats {
+		if stat.Function == fn && stat.Pass == pass {
+			return stat.Changes
+		}
+	}
+	return 0
+}
+
+// TestMem2Reg checks that -O1 promotes the scalar locals of a function to
+// registers, joining the values of the branches that assign them with a
+// phi, and leaves the arrays in memory
+func TestMem2Reg(t *testing.T) {
+	const src = "int f(int a) { int x = 1; int b[2]; if (a > 0) { x = a; } b[0] = x; return b[0]; }"
+	ir, stats := generateAt(t, src, 1)
+	if n := strings.Count(ir, " = alloca "); n != 1 {
+		t.Errorf("%d allocas left, want the array's:\n%s", n, ir)
+	}
+	if !strings.Contains(ir, " = phi i32 ") {
+		t.Errorf("no phi joining the values of x:\n%s", ir)
+	}
+	if changes(stats, "f", "mem2reg") < 2 {
+		t.Errorf("stats %+v, want a and x promoted", stats)
+	}
+}
+
+// TestCSE checks that -O1 computes a product written twice once
+func TestCSE(t *testing.T) {
+	const src = "int f(int a, int b) { int x = a * b; int y = a * b; return x + y; }"
+	o0, _ := generateAt(t, src, 0)
+	o1, stats := generateAt(t, src, 1)
+	if strings.Count(o0, " = mul ") != 2 || strings.Count(o1, " = mul ") != 1 {
+		t.Errorf("%d products at -O0 and %d at -O1, want 2 and 1:\n%s", strings.Count(o0, " = mul "), strings.Count(o1, " = mul "), o1)
+	}
+	if changes(stats, "f", "cse") != 1 {
+		t.Errorf("stats %+v, want one instruction removed by cse", stats)
+	}
+}
+
+// TestOptimizedBehavior checks that programs optimized at -O1 exit with
+// the status they do at -O0, under lli
+func TestOptimizedBehavior(t *testing.T) {
+	for _, src := range []string{
+		"int main() { int x = 2; int y = x * 3; if (y > 5) { x = y + 1; } return x; }",
+		"int f(int a, int b) { int x = a * b; int y = a * b; return x - y + a; } int main() { return f(7, 3); }",
+		"int main() { int i = 0; int s = 0; while (i < 10) { int k = i * 2; s = s + k; if (s > 50) { break; } i = i + 1; } return s; }",
+		"int main() { int a[4]; int i = 0; while (i < 4) { a[i] = i; i = i + 1; } switch (a[3]) { case 3: return a[1] + a[2]; default: return 0; } }",
+		"int main() { int x = 5; int r = 0; if (x > 3 && x < 10) { r = 1; } if (x == 4 || x > 4) { r = r + 2; } return r; }",
+	} {
+		var status [2]int
+		for opt := range status {
+			ir, _ := generateAt(t, src, opt)
+			status[opt], _ = llitest.Run(t, ir)
+		}
+		if status[0] != status[1] {
+			t.Errorf("%s: exit status %d at -O0, %d at -O1", src, status[0], status[1])
+		}
+	}
+}
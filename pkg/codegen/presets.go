@@ -0,0 +1,71 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Preset is a named target with the options builds for it usually take,
+// so that cross-compiling needs neither a triple nor the hardening that
+// goes with it spelled out. The data layout, the width of long, the
+// alignment of large arrays and the signedness of char come with the
+// target LookupTarget gives for the triple.
+type Preset struct {
+	Name        string
+	Description string
+	Triple      string
+	// PICLevel, StackProtector and FramePointer are the values of the
+	// Options fields of the same names.
+	PICLevel       int
+	StackProtector string
+	FramePointer   string
+}
+
+// Presets are the presets LookupPreset knows, by name.
+var Presets = []Preset{
+	{
+		Name:           "linux-x86_64",
+		Description:    "64-bit x86 Linux: position-independent, with the stack protector of -fstack-protector-strong",
+		Triple:         "x86_64-pc-linux-gnu",
+		PICLevel:       2,
+		StackProtector: "sspstrong",
+	},
+	{
+		Name:           "linux-arm64",
+		Description:    "64-bit Arm Linux: position-independent, with the stack protector of -fstack-protector-strong and the frame records AAPCS64 asks non-leaf functions for",
+		Triple:         "aarch64-unknown-linux-gnu",
+		PICLevel:       2,
+		StackProtector: "sspstrong",
+		FramePointer:   "non-leaf",
+	},
+	{
+		Name:        "riscv64-bare",
+		Description: "64-bit RISC-V with no operating system: static code, without a stack protector, as there is no __stack_chk_fail to call",
+		Triple:      "riscv64-unknown-elf",
+	},
+	{
+		Name:        "wasm32",
+		Description: "32-bit WebAssembly, whose engine keeps return addresses out of linear memory, so there is no stack to protect",
+		Triple:      "wasm32-unknown-unknown",
+	},
+}
+
+// LookupPreset returns the preset called name.
+func LookupPreset(name string) (*Preset, error) {
+	var names []string
+	for i := range Presets {
+		if Presets[i].Name == name {
+			return &Presets[i], nil
+		}
+		names = append(names, Presets[i].Name)
+	}
+	return nil, fmt.Errorf("unknown preset %q (want %s)", name, strings.Join(names, ", "))
+}
+
+// Apply sets the options of opts the preset gives.
+func (p *Preset) Apply(opts *Options) {
+	opts.Target = p.Triple
+	opts.PICLevel = p.PICLevel
+	opts.StackProtector = p.StackProtector
+	opts.FramePointer = p.FramePointer
+}
@@ -0,0 +1,63 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// TestPresetIR checks the IR of the presets for the ABI of their target:
+// the triple and data layout, the hardening the preset turns on, and
+// whether plain char widens as signed or unsigned
+func TestPresetIR(t *testing.T) {
+	src := "int widen(char c) { return c; }\nchar narrow(double d) { return d; }\nint main() { char buf[8]; buf[0] = 1; return widen(buf[0]); }"
+	program, err := parser.New(lexer.New(src)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		preset string
+		want   []string
+	}{
+		{"linux-x86_64", []string{
+			`target triple = "x86_64-pc-linux-gnu"`,
+			`target datalayout = "` + codegen.DefaultDataLayout + `"`,
+			"sext i8 %c",
+			"fptosi double %d",
+			"sspstrong",
+			`!"PIC Level", i32 2`,
+		}},
+		{"linux-arm64", []string{
+			`target triple = "aarch64-unknown-linux-gnu"`,
+			`target datalayout = "` + codegen.AArch64DataLayout + `"`,
+			"zext i8 %c",
+			"fptoui double %d",
+			"sspstrong",
+			`"frame-pointer"="non-leaf"`,
+			`!"PIC Level", i32 2`,
+		}},
+		{"riscv64-bare", []string{
+			`target triple = "riscv64-unknown-elf"`,
+			"zext i8 %c",
+		}},
+	} {
+		preset, err := codegen.LookupPreset(test.preset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opts codegen.Options
+		preset.Apply(&opts)
+		ir, err := codegen.NewWithOptions(opts).Generate(program)
+		if err != nil {
+			t.Fatalf("%s: %v", test.preset, err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(ir, want) {
+				t.Errorf("%s: the IR has no %s:\n%s", test.preset, want, ir)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StackAlign is the alignment of stack frames on the supported targets.
+const StackAlign = 16
+
+// StackEstimate is the estimated stack frame of one function.
+type StackEstimate struct {
+	Function string
+	// Locals is the space taken by the allocas left after optimization,
+	// each padded to its alignment.
+	Locals int
+	// Overhead is what a call adds besides the locals: the return address
+	// and, when a frame pointer is kept, the saved frame pointer.
+	Overhead int
+	// Total is Locals plus Overhead rounded up to StackAlign.
+	Total int
+	// Calls reports whether the function calls other functions, so its
+	// callees' frames sit on top of its own.
+	Calls bool
+}
+
+// StackEstimates returns the frame estimates of the functions defined in
+// the module, in generation order.
+func (c *CodeGen) StackEstimates() []StackEstimate {
+	return c.stackEstimates
+}
+
+// estimateStack computes the frame estimate of the current function from
+// its finished blocks.
+func (c *CodeGen) estimateStack() StackEstimate {
+	est := StackEstimate{Function: c.function.Name}
+	for _, b := range c.blocks {
+		for _, instr := range b.instrs {
+			m := valueDef.FindStringSubmatch(instr)
+			switch {
+			case m != nil && m[2] == "alloca":
+				size, _ := c.irSizeOf(strings.TrimSuffix(resultType(m[2], m[3]), "*"))
+				align := 1
+				if i := strings.LastIndex(m[3], ", align "); i >= 0 {
+					align, _ = strconv.Atoi(m[3][i+len(", align "):])
+				}
+				est.Locals = alignUp(est.Locals, align) + size
+			case strings.Contains(instr, "call ") && !strings.Contains(instr, "call void asm") && !strings.Contains(instr, "@llvm."):
+				est.Calls = true
+			}
+		}
+	}
+
+	// WebAssembly keeps return addresses and frame pointers on the
+	// engine's call stack, outside the linear-memory stack
+	if !c.target.IsWasm() {
+		est.Overhead = c.target.PointerSize
+		if c.opts.FramePointer == "all" || c.opts.FramePointer == "non-leaf" && est.Calls {
+			est.Overhead += c.target.PointerSize
+		}
+	}
+	est.Total = alignUp(est.Locals+est.Overhead, StackAlign)
+	return est
+}
+
+// stackComment returns the comment placed above a function's definition
+// when stack usage is annotated.
+func stackComment(est StackEstimate) string {
+	return fmt.Sprintf("; stack frame estimate: %d bytes (%d locals, %d call overhead)", est.Total, est.Locals, est.Overhead)
+}
+
+// irSizeOf returns the size and alignment of an LLVM type spelled as in
+// the generated IR.
+func (c *CodeGen) irSizeOf(typ string) (int, int) {
+	switch {
+	case strings.HasSuffix(typ, "*"):
+		return c.target.PointerSize, c.target.PointerSize
+	case strings.HasPrefix(typ, "["):
+		// [N x elem]
+		inner := typ[1 : len(typ)-1]
+		parts := strings.SplitN(inner, " x ", 2)
+		n, _ := strconv.Atoi(parts[0])
+		size, align := c.irSizeOf(parts[1])
+		return n * size, align
+	case typ == "float":
+		return 4, 4
+	case typ == "double":
+		return 8, 8
+	case strings.HasPrefix(typ, "i"):
+		bits, _ := strconv.Atoi(typ[1:])
+		bytes := (bits + 7) / 8
+		return bytes, bytes
+	}
+	return 0, 1
+}
+
+func alignUp(n, align int) int {
+	if align <= 1 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
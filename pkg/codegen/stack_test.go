@@ -0,0 +1,68 @@
+package codegen_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// TestStackUsage checks the frame estimates of a caller and a leaf on
+// x86-64, with and without a frame pointer, and on wasm32, where calls
+// cost no linear memory, and that the comments and metadata they are
+// annotated with leave the module valid
+func TestStackUsage(t *testing.T) {
+	program, err := parser.New(lexer.New(commentedProgram)).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		opts codegen.Options
+		want []codegen.StackEstimate
+	}{
+		{codegen.Options{}, []codegen.StackEstimate{
+			{Function: "f", Locals: 16, Overhead: 8, Total: 32},
+			{Function: "main", Locals: 8, Overhead: 8, Total: 16, Calls: true},
+		}},
+		{codegen.Options{FramePointer: "non-leaf"}, []codegen.StackEstimate{
+			{Function: "f", Locals: 16, Overhead: 8, Total: 32},
+			{Function: "main", Locals: 8, Overhead: 16, Total: 32, Calls: true},
+		}},
+		{codegen.Options{Target: "wasm32-unknown-unknown"}, []codegen.StackEstimate{
+			{Function: "f", Locals: 8, Total: 16},
+			{Function: "main", Locals: 8, Total: 16, Calls: true},
+		}},
+	} {
+		test.opts.StackUsage = true
+		gen := codegen.NewWithOptions(test.opts)
+		if _, err := gen.Generate(program); err != nil {
+			t.Fatal(err)
+		}
+		if got := gen.StackEstimates(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: StackEstimates = %+v, want %+v", test.opts, got, test.want)
+		}
+	}
+
+	ir := generate(t, commentedProgram, codegen.Options{StackUsage: true})
+	for _, want := range []string{
+		"; stack frame estimate: 32 bytes (16 locals, 8 call overhead)\ndefine dso_local i32 @f(i32* %p) !citadel.stack !2 {",
+		"; stack frame estimate: 16 bytes (8 locals, 8 call overhead)\ndefine dso_local i32 @main() !citadel.stack !3 {",
+		"!2 = !{i64 32}",
+		"!3 = !{i64 16}",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+	if plain := generate(t, commentedProgram, codegen.Options{}); strings.Contains(plain, "stack frame estimate") || strings.Contains(plain, "!citadel.stack") {
+		t.Errorf("stack usage annotated without StackUsage:\n%s", plain)
+	}
+
+	if status, _ := llitest.Run(t, ir); status != 1 {
+		t.Errorf("exit status %d, want 1", status)
+	}
+}
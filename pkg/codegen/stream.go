@@ -0,0 +1,64 @@
+package codegen
+
+import (
+	"io"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+)
+
+// Begin starts a module written to w a function at a time, for sources
+// too large to hold whole as one program: Add lowers each function as it
+// is parsed, and End writes what follows the functions. Unlike
+// GenerateTo, which sees every signature first, a call can only reach a
+// function added before it, as C requires it to be declared first.
+func (c *CodeGen) Begin(w io.Writer) (err error) {
+	defer diag.Recover("codegen", &err)
+	if err := c.start(w); err != nil {
+		return err
+	}
+	c.addModuleMetadata()
+	return nil
+}
+
+// generated stands in for the body of a function Add has lowered, so that
+// the function still counts as defined once its syntax tree is released.
+var generated = &ast.Block{}
+
+// Add lowers fn, a definition or a prototype, and writes its IR. The code
+// generator keeps its signature but not its body, which the caller can
+// release.
+func (c *CodeGen) Add(fn *ast.Function) (err error) {
+	defer diag.Recover("codegen", &err)
+	if err := Canceled(c.opts); err != nil {
+		return err
+	}
+	if err := c.addSignature(fn); err != nil {
+		return err
+	}
+	if fn.Body == nil {
+		return nil
+	}
+	if err := c.generateFunction(fn); err != nil {
+		return err
+	}
+	signature := *fn
+	signature.Body = generated
+	c.functions[fn.Name] = &signature
+	c.function, c.exprTypes, c.blocks, c.cur = nil, nil, nil, nil
+	return nil
+}
+
+// End writes the declarations, globals and metadata the functions added
+// need, and flushes the module to the writer Begin was given.
+func (c *CodeGen) End() (err error) {
+	defer diag.Recover("codegen", &err)
+	defer c.release()
+	return c.finish()
+}
+
+// Abort gives up a module Begin started without finishing it, after an
+// error. What was written before is incomplete.
+func (c *CodeGen) Abort() {
+	c.release()
+}
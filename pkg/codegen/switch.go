@@ -0,0 +1,99 @@
+package codegen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// generateSwitch lowers a switch statement to an LLVM switch over one
+// block per case label. Each case block falls through into the next with
+// an explicit branch, as C requires, and break branches to the block
+// after the switch.
+func (c *CodeGen) generateSwitch(stmt *ast.SwitchStatement, returnReg int) error {
+	t, err := c.typeOf(stmt.Tag)
+	if err != nil {
+		return err
+	}
+	if !t.IsInteger() {
+		return fmt.Errorf("switch on %s, which is not an integer", t)
+	}
+	tag, err := c.generateExpressionAs(stmt.Tag, ast.Int)
+	if err != nil {
+		return err
+	}
+
+	labels := make([]int, len(stmt.Cases))
+	defaultLabel := 0
+	dests := []string{}
+	seen := map[int]bool{}
+	for i, cs := range stmt.Cases {
+		if cs.Value == nil {
+			if defaultLabel != 0 {
+				return fmt.Errorf("multiple default labels in one switch")
+			}
+			labels[i] = c.nextNamedLabel("sw.default")
+			defaultLabel = labels[i]
+			continue
+		}
+		value, err := CaseConstant(cs.Value)
+		if err != nil {
+			return err
+		}
+		if seen[value] {
+			return fmt.Errorf("duplicate case value %d", value)
+		}
+		seen[value] = true
+		labels[i] = c.nextNamedLabel("sw.bb")
+		dests = append(dests, fmt.Sprintf("i32 %d, label %%%d", value, labels[i]))
+	}
+	endLabel := c.nextNamedLabel("sw.epilog")
+	if defaultLabel == 0 {
+		defaultLabel = endLabel
+	}
+
+	if len(dests) == 0 {
+		c.emit("br label %%%d", defaultLabel)
+	} else {
+		c.emit("switch i32 %s, label %%%d [ %s ]", tag, defaultLabel, strings.Join(dests, " "))
+	}
+
+	c.breakLabels = append(c.breakLabels, endLabel)
+	for i, cs := range stmt.Cases {
+		// Starting the next case block without a terminator falls through
+		c.startBlock(strconv.Itoa(labels[i]))
+		if err := c.generateStatements(cs.Body, returnReg); err != nil {
+			return err
+		}
+	}
+	c.breakLabels = c.breakLabels[:len(c.breakLabels)-1]
+
+	c.startBlock(strconv.Itoa(endLabel))
+	return nil
+}
+
+// generateBreak branches to the end of the innermost loop or switch.
+func (c *CodeGen) generateBreak() error {
+	if len(c.breakLabels) == 0 {
+		return fmt.Errorf("break statement not within a loop or switch")
+	}
+	c.emit("br label %%%d", c.breakLabels[len(c.breakLabels)-1])
+	return nil
+}
+
+// CaseConstant evaluates a case label, which must be an integer constant
+// expression: a literal, possibly negated.
+func CaseConstant(expr ast.Expression) (int, error) {
+	switch e := expr.(type) {
+	case *ast.IntLiteral:
+		return e.Value, nil
+	case *ast.UnaryOp:
+		if e.Operator == "-" {
+			value, err := CaseConstant(e.Operand)
+			return -value, err
+		}
+	}
+	return 0, fmt.Errorf("case label is not an integer constant")
+}
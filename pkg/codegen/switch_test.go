@@ -0,0 +1,71 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// switchProgram returns from one case, falls through from another into
+// the next, which breaks, and has a default
+const switchProgram = `int classify(int x) {
+    int r = 0;
+    switch (x) {
+    case 1:
+        return 100;
+    case 3:
+        r = r + 10;
+    case 4:
+        r = r + 1;
+        break;
+    default:
+        r = 50;
+    }
+    return r;
+}
+int main() { return classify(1) + classify(3) + classify(4) + classify(7); }`
+
+// TestSwitch checks that a switch becomes one switch instruction whose
+// cases fall through and break as in C, at -O0 and -O1, that a switch
+// without a default goes to the end, and that case labels are checked
+func TestSwitch(t *testing.T) {
+	ir := generate(t, switchProgram, codegen.Options{})
+	classify := functionIR(ir, "classify")
+	for _, want := range []string{
+		"switch i32 %x.0, label %sw.default [ i32 1, label %sw.bb i32 3, label %sw.bb1 i32 4, label %sw.bb2 ]",
+		"sw.bb1:\n  %r.0 = load i32, i32* %r, align 4\n  %add = add i32 %r.0, 10",
+		"br label %sw.bb2\n\nsw.bb2:",
+		"br label %sw.epilog\n\nsw.default:",
+		"br label %sw.epilog\n\nsw.epilog:",
+	} {
+		if !strings.Contains(classify, want) {
+			t.Errorf("classify lacks %q:\n%s", want, classify)
+		}
+	}
+	if n := strings.Count(classify, "switch i32"); n != 1 {
+		t.Errorf("%d switch instructions, want 1:\n%s", n, classify)
+	}
+
+	noDefault := generate(t, "int main() { int x = 2; switch (x) { case 1: return 1; } return 9; }", codegen.Options{})
+	if !strings.Contains(noDefault, "switch i32 %x.0, label %sw.epilog [ i32 1, label %sw.bb ]") {
+		t.Errorf("a switch without default does not go to its end:\n%s", noDefault)
+	}
+	for src, want := range map[string]string{
+		"int main() { int x = 2; switch (x) { case 1: return 1; case 1: return 2; } return 9; }":   "duplicate case value 1",
+		"int main() { int x = 2; switch (x) { default: return 1; default: return 2; } return 9; }": "multiple default labels in one switch",
+		"int main() { int x = 2; switch (x) { case x: return 1; } return 9; }":                     "case label is not an integer constant",
+	} {
+		if _, err := generateErr(src, codegen.Options{}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %s", src, err, want)
+		}
+	}
+
+	for _, level := range []int{0, 1} {
+		ir := generate(t, switchProgram, codegen.Options{OptLevel: level})
+		if status, _ := llitest.Run(t, ir); status != 162 {
+			t.Errorf("-O%d: exit status %d, want 162", level, status)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Visibilities are the symbol visibilities accepted by
+// Options.DefaultVisibility and the visibility attribute.
+var Visibilities = []string{"default", "hidden", "protected"}
+
+// SymbolName returns the name fn is emitted under. Functions defined in
+// the module get Options.SymbolPrefix; prototypes without a definition
+// name code defined elsewhere and keep their source name, as does main.
+func SymbolName(fn *ast.Function, opts Options) string {
+	if fn.Body == nil || fn.Name == "main" {
+		return fn.Name
+	}
+	return opts.SymbolPrefix + fn.Name
+}
+
+// Linkage returns the linkage of fn: "internal" for a static definition,
+// or when the module is internalized and fn is a definition other than
+// main that is not listed in Options.Exports, and "" for the default
+// external linkage otherwise.
+func Linkage(fn *ast.Function, opts Options) string {
+	if fn.Body == nil || fn.Name == "main" {
+		return ""
+	}
+	if fn.Static {
+		return "internal"
+	}
+	if !opts.Internalize {
+		return ""
+	}
+	for _, name := range opts.Exports {
+		if name == fn.Name {
+			return ""
+		}
+	}
+	return "internal"
+}
+
+// Visibility returns the visibility of fn, or "" for default visibility.
+// A visibility attribute takes precedence over Options.DefaultVisibility,
+// which like clang's -fvisibility only applies to definitions. Symbols
+// with local linkage always have default visibility.
+func Visibility(fn *ast.Function, opts Options) string {
+	visibility := ""
+	if attr := fn.Attribute("visibility"); attr != nil && len(attr.Args) > 0 {
+		visibility = attr.Args[0]
+	} else if fn.Body != nil {
+		visibility = opts.DefaultVisibility
+	}
+	if visibility == "default" || Linkage(fn, opts) != "" {
+		return ""
+	}
+	return visibility
+}
+
+// Section returns the section named by fn's section attribute, or "" to
+// leave the placement to the backend.
+func Section(fn *ast.Function) string {
+	if attr := fn.Attribute("section"); attr != nil && len(attr.Args) > 0 {
+		return attr.Args[0]
+	}
+	return ""
+}
+
+// CheckSymbolAttributes reports visibility and section attributes on fn
+// that are malformed.
+func CheckSymbolAttributes(fn *ast.Function) error {
+	if attr := fn.Attribute("visibility"); attr != nil {
+		valid := false
+		for _, visibility := range Visibilities {
+			valid = valid || len(attr.Args) == 1 && attr.Args[0] == visibility
+		}
+		if !valid {
+			return fmt.Errorf("visibility attribute of %s must be one of \"default\", \"hidden\" or \"protected\"", fn.Name)
+		}
+	}
+	if attr := fn.Attribute("section"); attr != nil {
+		if len(attr.Args) != 1 || attr.Args[0] == "" {
+			return fmt.Errorf("section attribute of %s needs a section name", fn.Name)
+		}
+		if fn.Body == nil {
+			return fmt.Errorf("section attribute on %s, which is not defined here", fn.Name)
+		}
+	}
+	return nil
+}
+
+// DSOLocal reports whether fn is known to resolve within the linked
+// image. Without PIC every symbol is local, as with clang's -fno-pic, so
+// calls need not go through the PLT or GOT. Local linkage implies it, and
+// hidden or protected visibility rule out preemption even under PIC.
+func DSOLocal(fn *ast.Function, opts Options) bool {
+	return Linkage(fn, opts) == "" && (opts.PICLevel == 0 || Visibility(fn, opts) != "")
+}
+
+// symbolKeywords returns the linkage, preemption, visibility and calling
+// convention keywords that precede the return type on the define or
+// declare line for fn, each followed by a space.
+func (c *CodeGen) symbolKeywords(fn *ast.Function) string {
+	keywords := ""
+	if linkage := Linkage(fn, c.opts); linkage != "" {
+		keywords += linkage + " "
+	}
+	if DSOLocal(fn, c.opts) {
+		keywords += "dso_local "
+	}
+	if visibility := Visibility(fn, c.opts); visibility != "" {
+		keywords += visibility + " "
+	}
+	return keywords + callingConvPrefix(CallingConv(fn, c.opts))
+}
+
+// sectionSuffix returns the section clause that follows the attributes
+// on the define line for fn, with a leading space.
+func (c *CodeGen) sectionSuffix(fn *ast.Function) string {
+	if section := Section(fn); section != "" {
+		return " section " + quoteLLVM(section)
+	}
+	return ""
+}
+
+// symbol returns the global operand referring to fn.
+func (c *CodeGen) symbol(fn *ast.Function) string {
+	return "@" + SymbolName(fn, c.opts)
+}
@@ -0,0 +1,119 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/internal/llitest"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// symbolsProgram calls a function with a calling convention attribute,
+// a static one, a hidden one and one in a section of its own from main
+const symbolsProgram = `__attribute__((fastcc)) int twice(int x) { return x + x; }
+int add(int a, int b) { return a + b; }
+static int one() { return 1; }
+__attribute__((visibility("hidden"))) int hidden(int x) { return x; }
+__attribute__((section(".text.hot"))) int hot(int x) { return x + 1; }
+int external(int x);
+int main() { return twice(add(1, 2)) + one() + hidden(3) + hot(4); }`
+
+// symbolsStatus is what symbolsProgram exits with
+const symbolsStatus = 6 + 1 + 3 + 5
+
+// TestCallingConventions checks that a calling convention attribute or
+// Options.CallingConv gives the definitions, prototypes and calls of a
+// function the same convention, main keeping the C one, and that the
+// program runs the same under each
+func TestCallingConventions(t *testing.T) {
+	for _, test := range []struct {
+		cc   string
+		want []string
+	}{
+		{"", []string{
+			"define dso_local fastcc i32 @twice(", "call fastcc i32 @twice(",
+			"define dso_local i32 @add(", "call i32 @add(",
+			"declare dso_local i32 @external(",
+		}},
+		{"fastcc", []string{
+			"define dso_local fastcc i32 @twice(", "call fastcc i32 @twice(",
+			"define dso_local fastcc i32 @add(", "call fastcc i32 @add(",
+			"declare dso_local fastcc i32 @external(",
+			"define dso_local i32 @main(",
+		}},
+	} {
+		ir := generate(t, symbolsProgram, codegen.Options{CallingConv: test.cc})
+		for _, want := range test.want {
+			if !strings.Contains(ir, want) {
+				t.Errorf("-cc %q: IR lacks %s:\n%s", test.cc, want, ir)
+			}
+		}
+		if status, _ := llitest.Run(t, ir); status != symbolsStatus {
+			t.Errorf("-cc %q: exit status %d, want %d", test.cc, status, symbolsStatus)
+		}
+	}
+}
+
+// TestSymbolPrefixAndInternalize checks that Options.SymbolPrefix renames
+// the definitions and the calls to them but not main or prototypes, and
+// that Options.Internalize makes every definition but main and Exports
+// internal, and that the program still runs
+func TestSymbolPrefixAndInternalize(t *testing.T) {
+	ir := generate(t, symbolsProgram, codegen.Options{SymbolPrefix: "lib_", Internalize: true, Exports: []string{"add"}})
+	for _, want := range []string{
+		"define internal fastcc i32 @lib_twice(", "call fastcc i32 @lib_twice(",
+		"define dso_local i32 @lib_add(", "call i32 @lib_add(",
+		"define internal i32 @lib_one(",
+		"define internal i32 @lib_hidden(",
+		"define dso_local i32 @main(",
+		"declare dso_local i32 @external(",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+	if strings.Contains(ir, "@twice(") || strings.Contains(ir, "@lib_main(") {
+		t.Errorf("a definition kept its name, or main was renamed:\n%s", ir)
+	}
+	if status, _ := llitest.Run(t, ir); status != symbolsStatus {
+		t.Errorf("exit status %d, want %d", status, symbolsStatus)
+	}
+}
+
+// TestVisibilityAndSections checks the visibility of definitions, from
+// their attribute or Options.DefaultVisibility, that internal ones have
+// none, that PIC leaves only the symbols that cannot be preempted
+// dso_local, and that llc puts a function with a section attribute in
+// its section
+func TestVisibilityAndSections(t *testing.T) {
+	ir := generate(t, symbolsProgram, codegen.Options{DefaultVisibility: "protected", PICLevel: 2})
+	for _, want := range []string{
+		"define dso_local protected i32 @add(",
+		"define dso_local hidden i32 @hidden(",
+		"define internal i32 @one(",
+		`define dso_local protected i32 @hot(i32 %x) section ".text.hot" {`,
+		"declare i32 @external(",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+
+	ir = generate(t, symbolsProgram, codegen.Options{PICLevel: 2})
+	for _, want := range []string{
+		"define i32 @add(",
+		"define dso_local hidden i32 @hidden(",
+		"define internal i32 @one(",
+		"define i32 @main(",
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("under PIC, IR lacks %s:\n%s", want, ir)
+		}
+	}
+	asm := compileIR(t, ir)
+	for _, want := range []string{".section\t.text.hot", ".hidden\thidden"} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("assembly lacks %q:\n%s", want, asm)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Target describes the platform the IR is generated for.
+type Target struct {
+	Triple     string
+	DataLayout string
+	// PointerSize is the size and alignment of pointers in bytes.
+	PointerSize int
+	// LongSize is the size and alignment of long in bytes: 8 on LP64
+	// targets and 4 on ILP32 ones.
+	LongSize int
+	// LargeArrayAlign is the alignment the ABI gives arrays of at least
+	// that many bytes, as x86-64 does with 16; 0 when arrays are aligned
+	// like their elements.
+	LargeArrayAlign int
+	// UnsignedChar is whether plain char is unsigned, as the Arm and
+	// RISC-V ABIs have it, so that it widens with zext rather than sext.
+	UnsignedChar bool
+}
+
+// Data layouts of the targets other than DefaultTriple's, as clang gives
+// them.
+const (
+	WasmDataLayout    = "e-m:e-p:32:32-i64:64-n32:64-S128"
+	AArch64DataLayout = "e-m:e-i8:8:32-i16:16:32-i64:64-i128:128-n32:64-S128"
+	RISCV64DataLayout = "e-m:e-p:64:64-i64:64-i128:128-n64-S128"
+)
+
+// LookupTarget returns the target for a triple, which must be for one of
+// the supported architectures: x86_64, aarch64, riscv64 or wasm32. An
+// empty triple selects DefaultTriple.
+func LookupTarget(triple string) (*Target, error) {
+	if triple == "" {
+		triple = DefaultTriple
+	}
+	switch arch := strings.SplitN(triple, "-", 2)[0]; arch {
+	case "x86_64":
+		return &Target{Triple: triple, DataLayout: DefaultDataLayout, PointerSize: 8, LongSize: 8, LargeArrayAlign: 16}, nil
+	case "aarch64":
+		// Apple and Windows keep char signed on Arm
+		signed := strings.Contains(triple, "-apple-") || strings.Contains(triple, "windows")
+		return &Target{Triple: triple, DataLayout: AArch64DataLayout, PointerSize: 8, LongSize: 8, UnsignedChar: !signed}, nil
+	case "riscv64":
+		return &Target{Triple: triple, DataLayout: RISCV64DataLayout, PointerSize: 8, LongSize: 8, UnsignedChar: true}, nil
+	case "wasm32":
+		return &Target{Triple: triple, DataLayout: WasmDataLayout, PointerSize: 4, LongSize: 4}, nil
+	default:
+		return nil, fmt.Errorf("unsupported target architecture %q in %s", arch, triple)
+	}
+}
+
+// IsWasm reports whether t is a WebAssembly target.
+func (t *Target) IsWasm() bool {
+	return strings.HasPrefix(t.Triple, "wasm")
+}
+
+// HasShadowCallStack reports whether LLVM keeps return addresses on a
+// shadow stack on t, which it does in x18 on AArch64 and RISC-V.
+func (t *Target) HasShadowCallStack() bool {
+	return strings.HasPrefix(t.Triple, "aarch64") || strings.HasPrefix(t.Triple, "riscv64")
+}
+
+// AlignOf returns the ABI alignment of a C type in bytes.
+func (t *Target) AlignOf(typ *ast.Type) int {
+	switch typ.Kind {
+	case ast.PointerType:
+		return t.PointerSize
+	case ast.ArrayType:
+		if t.LargeArrayAlign > 0 && t.SizeOf(typ) >= t.LargeArrayAlign {
+			return t.LargeArrayAlign
+		}
+		return t.AlignOf(typ.Elem)
+	default:
+		return t.SizeOf(typ)
+	}
+}
+
+// SizeOf returns the size of a C type in bytes.
+func (t *Target) SizeOf(typ *ast.Type) int {
+	switch typ.Kind {
+	case ast.PointerType:
+		return t.PointerSize
+	case ast.ArrayType:
+		return typ.Len * t.SizeOf(typ.Elem)
+	}
+	switch typ.Name {
+	case "char", "void":
+		// void* steps a byte at a time, as GNU C has it
+		return 1
+	case "short":
+		return 2
+	case "long":
+		return t.LongSize
+	case "double":
+		return 8
+	default:
+		return 4
+	}
+}
+
+// LLVMType returns the LLVM spelling of a C type on t.
+func (t *Target) LLVMType(typ *ast.Type) string {
+	switch typ.Kind {
+	case ast.PointerType:
+		return t.LLVMType(typ.Elem) + "*"
+	case ast.ArrayType:
+		return fmt.Sprintf("[%d x %s]", typ.Len, t.LLVMType(typ.Elem))
+	case ast.FuncType:
+		params := []string{}
+		for _, param := range typ.Params {
+			params = append(params, t.LLVMType(param))
+		}
+		return fmt.Sprintf("%s (%s)", t.LLVMType(typ.Elem), strings.Join(params, ", "))
+	}
+	switch typ.Name {
+	case "float", "double":
+		return typ.Name
+	default:
+		return fmt.Sprintf("i%d", t.SizeOf(typ)*8)
+	}
+}
+
+// CheckTargetOptions rejects options the target cannot honor. WebAssembly
+// has no native stack to protect or instrument: return addresses already
+// live outside linear memory, indirect calls are type-checked by the
+// engine, and LLVM has no sanitizer runtimes for it. LLVM implements the
+// shadow call stack on AArch64 and RISC-V alone; elsewhere llc would
+// drop the attribute without a word, leaving return addresses where they
+// were.
+func CheckTargetOptions(t *Target, opts Options) error {
+	if opts.ShadowCallStack && !t.HasShadowCallStack() {
+		return fmt.Errorf("shadow-call-stack is not supported on %s", t.Triple)
+	}
+	if !t.IsWasm() {
+		return nil
+	}
+	unsupported := []struct {
+		set  bool
+		name string
+	}{
+		{opts.SafeStack, "safestack"},
+		{opts.SanitizeAddress, "address sanitizer"},
+		{opts.SanitizeMemory, "memory sanitizer"},
+		{opts.SanitizeThread, "thread sanitizer"},
+		{opts.CFI, "cfi"},
+	}
+	for _, opt := range unsupported {
+		if opt.set {
+			return fmt.Errorf("%s is not supported on %s", opt.name, t.Triple)
+		}
+	}
+	return nil
+}
+
+// WasmExportName returns the name fn is exported from a WebAssembly
+// module under, or "" if it is not exported. An export_name attribute
+// names the export; otherwise every definition with external linkage and
+// default visibility is exported under its symbol name.
+func WasmExportName(fn *ast.Function, opts Options) string {
+	if fn.Body == nil || Linkage(fn, opts) != "" {
+		return ""
+	}
+	if attr := fn.Attribute("export_name"); attr != nil && len(attr.Args) > 0 {
+		return attr.Args[0]
+	}
+	if Visibility(fn, opts) == "hidden" {
+		return ""
+	}
+	return SymbolName(fn, opts)
+}
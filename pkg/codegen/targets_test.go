@@ -0,0 +1,56 @@
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// TestWasm32 checks the layout of wasm32, that long and pointers take 32
+// bits there, which definitions are exported and under what name, and
+// that llc turns the attributes into exports
+func TestWasm32(t *testing.T) {
+	const triple = "wasm32-unknown-unknown"
+	target, err := codegen.LookupTarget(triple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !target.IsWasm() || target.PointerSize != 4 || target.LongSize != 4 || target.DataLayout != codegen.WasmDataLayout {
+		t.Errorf("LookupTarget(%q) = %+v", triple, target)
+	}
+
+	const src = `__attribute__((export_name("twice"))) int double_it(int x) { return x + x; }
+__attribute__((visibility("hidden"))) int hid(int x) { return x; }
+static int st(int x) { return x; }
+long first(int *p) { long n = 1; int *q = p; return n; }
+int main() { return double_it(1) + hid(2) + st(3); }`
+	ir := generate(t, src, codegen.Options{Target: triple})
+	for _, want := range []string{
+		`target datalayout = "` + codegen.WasmDataLayout + `"`,
+		`target triple = "wasm32-unknown-unknown"`,
+		"define dso_local i32 @double_it(i32 %x) #0 {",
+		"define dso_local hidden i32 @hid(i32 %x) {",
+		"define internal i32 @st(i32 %x) {",
+		"define dso_local i32 @first(i32* %p) #1 {",
+		"%n = alloca i32, align 4",
+		"%q = alloca i32*, align 4",
+		`attributes #0 = { "wasm-export-name"="twice" }`,
+		`attributes #1 = { "wasm-export-name"="first" }`,
+		`attributes #2 = { "wasm-export-name"="main" }`,
+	} {
+		if !strings.Contains(ir, want) {
+			t.Errorf("IR lacks %s:\n%s", want, ir)
+		}
+	}
+
+	asm := compileIR(t, ir)
+	for _, want := range []string{".export_name\tdouble_it, twice", ".export_name\tfirst, first", ".functype\tfirst (i32) -> (i32)"} {
+		if !strings.Contains(asm, want) {
+			t.Errorf("assembly lacks %q:\n%s", want, asm)
+		}
+	}
+	if strings.Contains(asm, ".export_name\thid") || strings.Contains(asm, ".export_name\tst") {
+		t.Errorf("a hidden or static function is exported:\n%s", asm)
+	}
+}
@@ -0,0 +1,138 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// llvmType returns the LLVM spelling of a C type on the current target.
+func (c *CodeGen) llvmType(t *ast.Type) string {
+	return c.target.LLVMType(t)
+}
+
+// paramTypeList returns the comma-separated LLVM parameter types of fn.
+func (c *CodeGen) paramTypeList(fn *ast.Function) string {
+	params := []string{}
+	for _, param := range fn.Params {
+		params = append(params, c.llvmType(param.Type))
+	}
+	return strings.Join(params, ", ")
+}
+
+// typeID returns the Itanium type-info name used by clang as the CFI type
+// identifier for t, e.g. _ZTSFiiE for int (int).
+func typeID(t *ast.Type) string {
+	return "_ZTS" + mangle(t)
+}
+
+func mangle(t *ast.Type) string {
+	switch t.Kind {
+	case ast.PointerType:
+		return "P" + mangle(t.Elem)
+	case ast.FuncType:
+		params := ""
+		for _, param := range t.Params {
+			params += mangle(param)
+		}
+		if params == "" {
+			params = "v"
+		}
+		return "F" + mangle(t.Elem) + params + "E"
+	default:
+		return builtinCodes[t.Name]
+	}
+}
+
+// builtinCodes are the Itanium mangling codes of the basic types.
+var builtinCodes = map[string]string{
+	"char": "c", "short": "s", "int": "i", "long": "l", "float": "f", "double": "d",
+}
+
+// typeOf returns the C type of an expression. Types are remembered until
+// a declaration changes what names refer to, since the type of each
+// operator depends on those of its operands and a long chain of them
+// would otherwise be typed again at every level.
+func (c *CodeGen) typeOf(expr ast.Expression) (*ast.Type, error) {
+	if t, ok := c.exprTypes[expr]; ok {
+		return t, nil
+	}
+	t, err := c.computeType(expr)
+	if err != nil {
+		return nil, err
+	}
+	if c.exprTypes == nil {
+		c.exprTypes = map[ast.Expression]*ast.Type{}
+	}
+	c.exprTypes[expr] = t
+	return t, nil
+}
+
+func (c *CodeGen) computeType(expr ast.Expression) (*ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.IntLiteral:
+		return ast.Int, nil
+	case *ast.StringLiteral:
+		return ast.PointerTo(ast.Char), nil
+	case *ast.BinaryOp:
+		if isBoolean(e) {
+			return ast.Int, nil
+		}
+		return c.arithmeticType(e)
+	case *ast.Identifier:
+		if t, ok := c.varTypes[e.Name]; ok {
+			return t.Decay(), nil
+		}
+		if fn, ok := c.functions[e.Name]; ok {
+			return ast.PointerTo(fn.Signature()), nil
+		}
+		return nil, c.undefined(ErrUndefinedVariable, e)
+	case *ast.UnaryOp:
+		t, err := c.typeOf(e.Operand)
+		if err != nil {
+			return nil, err
+		}
+		if e.Operator == "-" {
+			if !t.IsArithmetic() {
+				return nil, fmt.Errorf("invalid argument type %s to unary -", t)
+			}
+			return t.Promote(), nil
+		}
+		if t.IsFuncPointer() {
+			return t, nil
+		}
+		if t.Kind != ast.PointerType {
+			return nil, fmt.Errorf("cannot dereference %s", t)
+		}
+		return t.Elem, nil
+	case *ast.IndexExpr:
+		t, err := c.typeOf(e.Array)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind != ast.PointerType {
+			return nil, fmt.Errorf("subscripted value %s is not an array or pointer", e.Array)
+		}
+		return t.Elem.Decay(), nil
+	case *ast.Assignment:
+		return c.typeOf(e.Target)
+	case *ast.CallExpr:
+		if c.memIntrinsic(e) != "" && len(e.Args) > 0 {
+			return c.typeOf(e.Args[0])
+		}
+		if fn := c.libcCallee(e); fn != nil {
+			if fn.Result == LibcVoid {
+				return nil, fmt.Errorf("void value of call to %s used", fn.Name)
+			}
+			return fn.ResultType(), nil
+		}
+		sig, err := c.calleeSignature(e)
+		if err != nil {
+			return nil, err
+		}
+		return sig.Elem, nil
+	default:
+		return nil, fmt.Errorf("unknown expression type")
+	}
+}
@@ -0,0 +1,163 @@
+package codegen
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+var (
+	valueDef = regexp.MustCompile(`^%([-a-zA-Z$._0-9]+) = (\w+) (.*)$`)
+	typedRef = regexp.MustCompile(`\b((?:i[0-9]+|float|double)\**) %([-a-zA-Z$._0-9]+)(\(?)`)
+	binaryOp = regexp.MustCompile(`^(?:%\S+ = )?(?:add|sub|mul|sdiv|srem|and|or|xor|fadd|fsub|fmul|fdiv|frem|icmp \w+|fcmp \w+) (\S+) (\S+), (\S+)$`)
+)
+
+// verifyFunction checks the blocks of the current function for the
+// structural mistakes llvm-as would reject: blocks without a terminator,
+// duplicate names, branches to missing blocks, uses of undefined values
+// and operands whose type does not match their definition. A failure is a
+// bug in the code generator, not in the input program.
+func (c *CodeGen) verifyFunction(fn *ast.Function) error {
+	fail := func(format string, args ...interface{}) error {
+		return &BackendError{fmt.Errorf("internal error: invalid IR in @%s: %s", fn.Name, fmt.Sprintf(format, args...))}
+	}
+
+	labels := map[string]bool{}
+	for _, b := range c.blocks {
+		if labels[b.label] {
+			return fail("block %%%s is defined more than once", b.label)
+		}
+		labels[b.label] = true
+	}
+
+	// Types of named values; "" when the verifier cannot infer one
+	types := map[string]string{}
+	for _, param := range fn.Params {
+		types[param.Name] = c.llvmType(param.Type)
+	}
+	for _, b := range c.blocks {
+		if b.terminator() == "" {
+			return fail("block %s does not end in a terminator", blockName(b))
+		}
+		for i, instr := range b.instrs {
+			if i < len(b.instrs)-1 && isTerminator(instr) {
+				return fail("terminator %q in the middle of block %s", instr, blockName(b))
+			}
+			m := valueDef.FindStringSubmatch(instr)
+			if m == nil {
+				continue
+			}
+			if _, dup := types[m[1]]; dup || labels[m[1]] {
+				return fail("%%%s is defined more than once", m[1])
+			}
+			types[m[1]] = resultType(m[2], m[3])
+		}
+		for _, succ := range b.successors() {
+			if !labels[succ] {
+				return fail("branch to undefined block %%%s in %s", succ, blockName(b))
+			}
+		}
+	}
+
+	check := func(instr, typ, operand string) error {
+		if !strings.HasPrefix(operand, "%") {
+			return nil
+		}
+		actual, ok := types[operand[1:]]
+		if !ok {
+			return fail("use of undefined value %s in %q", operand, instr)
+		}
+		if actual != "" && actual != typ {
+			return fail("%s has type %s but is used as %s in %q", operand, actual, typ, instr)
+		}
+		return nil
+	}
+	for _, b := range c.blocks {
+		for _, instr := range b.instrs {
+			if isComment(instr) {
+				continue
+			}
+			for _, m := range typedRef.FindAllStringSubmatch(instr, -1) {
+				// The return type before an indirect callee is not its type
+				if m[3] == "(" {
+					continue
+				}
+				if err := check(instr, m[1], "%"+m[2]); err != nil {
+					return err
+				}
+			}
+			if m := binaryOp.FindStringSubmatch(instr); m != nil {
+				if err := check(instr, m[1], m[3]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resultType infers the type of the value defined by an instruction with
+// the given opcode and operands, or returns "" if it is not obvious from
+// the text.
+func resultType(opcode, operands string) string {
+	switch opcode {
+	case "icmp", "fcmp":
+		return "i1"
+	case "add", "sub", "mul", "sdiv", "srem", "and", "or", "xor", "fadd", "fsub", "fmul", "fdiv", "frem", "fneg":
+		return strings.Fields(operands)[0]
+	case "phi":
+		if i := strings.Index(operands, " ["); i >= 0 {
+			return operands[:i]
+		}
+	case "zext", "sext", "trunc", "bitcast", "sitofp", "uitofp", "fptosi", "fptoui", "fpext", "fptrunc":
+		if i := strings.LastIndex(operands, " to "); i >= 0 {
+			return operands[i+len(" to "):]
+		}
+	case "alloca":
+		if i := strings.LastIndex(operands, ", align "); i >= 0 {
+			return operands[:i] + "*"
+		}
+		return operands + "*"
+	case "load":
+		// load T, T* %p: find the split where the pointer type repeats T
+		for i := strings.Index(operands, ", "); i >= 0; {
+			if t := operands[:i]; strings.HasPrefix(operands[i+2:], t+"* ") {
+				return t
+			}
+			next := strings.Index(operands[i+2:], ", ")
+			if next < 0 {
+				break
+			}
+			i += 2 + next
+		}
+	case "call":
+		for _, cc := range CallingConvs {
+			operands = strings.TrimPrefix(operands, cc+" ")
+		}
+		for _, sep := range []string{" @", " %"} {
+			if i := strings.Index(operands, sep); i >= 0 {
+				// Calls to variadic functions give the full function type
+				result := operands[:i]
+				if j := strings.Index(result, " ("); j >= 0 {
+					result = result[:j]
+				}
+				return result
+			}
+		}
+	}
+	return ""
+}
+
+func isTerminator(instr string) bool {
+	b := basicBlock{instrs: []string{instr}}
+	return b.terminator() != ""
+}
+
+func blockName(b *basicBlock) string {
+	if b.label == "" {
+		return "entry"
+	}
+	return "%" + b.label
+}
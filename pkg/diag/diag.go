@@ -0,0 +1,254 @@
+// Package diag collects the errors, warnings and findings every step of
+// the compiler reports about a source, for a tool to render them the same
+// way whichever step they come from
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// The severities of errors and warnings. Findings have those of the
+// analysis, from "info" to "critical"
+const (
+	Error   = "error"
+	Warning = "warning"
+)
+
+// Diagnostic is a problem with a source, or a finding about it
+type Diagnostic struct {
+	File string
+	// Pos is where the problem starts and End where it ends, or Pos if
+	// only its start is known. Diagnostics of the whole file have a zero
+	// Pos
+	Pos lexer.Position
+	End lexer.Position
+	// Severity is Error, Warning or the severity of a finding
+	Severity string
+	// Stage is the step that reported it: lexer, parser, semantic,
+	// codegen, analysis, suppression or io
+	Stage string
+	// Rule is the check a finding is from, or ""
+	Rule    string
+	Message string
+	// Suppressed marks a finding a comment silences
+	Suppressed bool
+	// Notes point at other code the diagnostic involves
+	Notes []Note
+	// Fixes are changes that would resolve it, to choose from
+	Fixes []Fix
+}
+
+// Note is a remark on a Diagnostic about code at another position
+type Note struct {
+	File    string
+	Pos     lexer.Position
+	Message string
+}
+
+// Fix is a change that resolves a Diagnostic: Edits to its file, or only
+// a Message saying what to do when the change is not mechanical
+type Fix struct {
+	Message string
+	Edits   []Edit
+}
+
+// Edit replaces the text from Pos to End with NewText; Pos and End are
+// the same for an insertion
+type Edit struct {
+	Pos     lexer.Position
+	End     lexer.Position
+	NewText string
+}
+
+// String returns the diagnostic as file:line:col: severity: message
+func (d Diagnostic) String() string {
+	location := d.File + ":"
+	if d.Pos.Line > 0 {
+		location += fmt.Sprintf("%d:%d:", d.Pos.Line, d.Pos.Column)
+	}
+	return fmt.Sprintf("%s %s: %s", location, d.Severity, d.Message)
+}
+
+// The JSON form of diagnostics, with lines and columns counted from 1
+
+type jsonDiagnostic struct {
+	File       string     `json:"file"`
+	Range      *jsonRange `json:"range,omitempty"`
+	Severity   string     `json:"severity"`
+	Source     string     `json:"source"`
+	Rule       string     `json:"rule,omitempty"`
+	Message    string     `json:"message"`
+	Suppressed bool       `json:"suppressed,omitempty"`
+	Notes      []jsonNote `json:"notes,omitempty"`
+	Fixes      []jsonFix  `json:"fixes,omitempty"`
+}
+
+type jsonNote struct {
+	File    string     `json:"file"`
+	Range   *jsonRange `json:"range"`
+	Message string     `json:"message"`
+}
+
+type jsonFix struct {
+	Message string     `json:"message"`
+	Edits   []jsonEdit `json:"edits,omitempty"`
+}
+
+type jsonEdit struct {
+	Range   *jsonRange `json:"range"`
+	NewText string     `json:"newText"`
+}
+
+type jsonRange struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func rangeOf(start, end lexer.Position) *jsonRange {
+	return &jsonRange{jsonPosition{start.Line, start.Column}, jsonPosition{end.Line, end.Column}}
+}
+
+// MarshalJSON encodes the diagnostic as an object with its file, range,
+// severity, source (the stage), rule, message, suppressed, notes and
+// fixes, leaving out the range of the whole file and the empty fields
+func (d Diagnostic) MarshalJSON() ([]byte, error) {
+	j := jsonDiagnostic{File: d.File, Severity: d.Severity, Source: d.Stage, Rule: d.Rule, Message: d.Message, Suppressed: d.Suppressed}
+	if d.Pos.Line > 0 {
+		j.Range = rangeOf(d.Pos, d.End)
+	}
+	for _, note := range d.Notes {
+		j.Notes = append(j.Notes, jsonNote{note.File, rangeOf(note.Pos, note.Pos), note.Message})
+	}
+	for _, fix := range d.Fixes {
+		f := jsonFix{Message: fix.Message}
+		for _, edit := range fix.Edits {
+			f.Edits = append(f.Edits, jsonEdit{rangeOf(edit.Pos, edit.End), edit.NewText})
+		}
+		j.Fixes = append(j.Fixes, f)
+	}
+	// Whether < > & are escaped is left to the encoder of the diagnostic
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(j); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Diagnoser is an error that describes itself as diagnostics, such as a
+// parser.Error, for Bag.AddError to add
+type Diagnoser interface {
+	error
+	Diagnostics(stage string) []Diagnostic
+}
+
+// Bag collects diagnostics, in the order they are added, from any number
+// of goroutines. The zero Bag is empty and ready to use
+type Bag struct {
+	mu    sync.Mutex
+	diags []Diagnostic
+}
+
+// Add adds diags to the bag
+func (b *Bag) Add(diags ...Diagnostic) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.diags = append(b.diags, diags...)
+}
+
+// AddError adds the diagnostics of err, an error of stage about file: those
+// of the Diagnoser it wraps, or the position of the *lexer.Error it wraps,
+// or else one error for the whole file. Diagnostics without a file get
+// file
+func (b *Bag) AddError(stage, file string, err error) {
+	var d Diagnoser
+	var lexErr *lexer.Error
+	var diags []Diagnostic
+	switch {
+	case errors.As(err, &d):
+		diags = d.Diagnostics(stage)
+	case errors.As(err, &lexErr):
+		diags = []Diagnostic{{Pos: lexErr.Pos, End: lexErr.End, Severity: Error, Stage: stage, Message: lexErr.Msg}}
+	default:
+		diags = []Diagnostic{{Severity: Error, Stage: stage, Message: err.Error()}}
+	}
+	for i := range diags {
+		if diags[i].File == "" {
+			diags[i].File = file
+		}
+	}
+	b.Add(diags...)
+}
+
+// AddLexerErrors adds an error for each illegal token of input, the
+// source of file
+func (b *Bag) AddLexerErrors(file, input string) {
+	lex := lexer.New(input)
+	for tok := lex.NextToken(); tok.Type != lexer.EOF; tok = lex.NextToken() {
+		if e := lexer.TokenError(tok); e != nil {
+			b.AddError("lexer", file, e)
+		}
+	}
+}
+
+// Diagnostics returns the diagnostics added, in order
+func (b *Bag) Diagnostics() []Diagnostic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Diagnostic(nil), b.diags...)
+}
+
+// Len returns how many diagnostics were added
+func (b *Bag) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.diags)
+}
+
+// Count returns how many diagnostics of severity were added
+func (b *Bag) Count(severity string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, d := range b.diags {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// HasErrors reports whether an error was added
+func (b *Bag) HasErrors() bool {
+	return b.Count(Error) > 0
+}
+
+// Sort orders the diagnostics by file and position, keeping the order
+// they were added in among those at the same place
+func (b *Bag) Sort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sort.SliceStable(b.diags, func(i, j int) bool {
+		a, c := b.diags[i], b.diags[j]
+		if a.File != c.File {
+			return a.File < c.File
+		}
+		if a.Pos.Line != c.Pos.Line {
+			return a.Pos.Line < c.Pos.Line
+		}
+		return a.Pos.Column < c.Pos.Column
+	})
+}
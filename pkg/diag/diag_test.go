@@ -0,0 +1,117 @@
+package diag_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// TestAddError checks the diagnostics each kind of error becomes: those
+// a Diagnoser describes itself as, the range of a lexer error, and one
+// error of the whole file for any other
+func TestAddError(t *testing.T) {
+	var bag diag.Bag
+	_, err := parser.New(lexer.New("int main() {\n  return 1 +;\n}\n")).ParseProgram()
+	if err == nil {
+		t.Fatal("no parse error")
+	}
+	bag.AddError("parser", "a.c", err)
+	bag.AddError("lexer", "b.c", &lexer.Error{Pos: lexer.Position{Line: 2, Column: 3}, End: lexer.Position{Line: 2, Column: 4}, Msg: "unexpected character '$'"})
+	bag.AddError("io", "c.c", errors.New("no such file"))
+
+	diags := bag.Diagnostics()
+	if len(diags) != 3 {
+		t.Fatalf("got %d diagnostics, want 3: %v", len(diags), diags)
+	}
+	if d := diags[0]; d.File != "a.c" || d.Stage != "parser" || d.Pos.Line != 2 || d.Severity != diag.Error {
+		t.Errorf("parse error: got %+v", d)
+	}
+	if got, want := diags[1].String(), "b.c:2:3: error: unexpected character '$'"; got != want {
+		t.Errorf("lexer error: got %q, want %q", got, want)
+	}
+	if got, want := diags[2].String(), "c.c: error: no such file"; got != want {
+		t.Errorf("other error: got %q, want %q", got, want)
+	}
+	if bag.Len() != 3 || bag.Count(diag.Error) != 3 || bag.Count(diag.Warning) != 0 || !bag.HasErrors() {
+		t.Errorf("Len %d, errors %d, warnings %d, HasErrors %v", bag.Len(), bag.Count(diag.Error), bag.Count(diag.Warning), bag.HasErrors())
+	}
+}
+
+// TestSort checks that Sort orders diagnostics by file, line and column,
+// keeping those at the same place in the order they were added
+func TestSort(t *testing.T) {
+	var bag diag.Bag
+	at := func(file string, line, column int, msg string) diag.Diagnostic {
+		return diag.Diagnostic{File: file, Pos: lexer.Position{Line: line, Column: column}, Severity: diag.Warning, Message: msg}
+	}
+	bag.Add(at("b.c", 1, 1, "b"), at("a.c", 3, 1, "d"), at("a.c", 2, 5, "c"), at("a.c", 2, 1, "a"), at("a.c", 2, 5, "c2"))
+	bag.Sort()
+	var got string
+	for _, d := range bag.Diagnostics() {
+		got += d.Message + " "
+	}
+	if want := "a c c2 d b "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestAddConcurrently checks that diagnostics added from many goroutines
+// are all kept
+func TestAddConcurrently(t *testing.T) {
+	var bag diag.Bag
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				bag.Add(diag.Diagnostic{Severity: diag.Error})
+			}
+		}()
+	}
+	wg.Wait()
+	if bag.Len() != 1600 {
+		t.Errorf("got %d diagnostics, want 1600", bag.Len())
+	}
+}
+
+// TestMarshalJSON checks the JSON form of a finding with notes and a fix,
+// and that of an error about the whole file, which has no range; an
+// encoder that does not escape HTML writes < and & as they are
+func TestMarshalJSON(t *testing.T) {
+	pos := lexer.Position{Line: 3, Column: 5}
+	end := lexer.Position{Line: 3, Column: 9}
+	finding := diag.Diagnostic{
+		File: "a.c", Pos: pos, End: end, Severity: "high", Stage: "analysis", Rule: "taint",
+		Message: "a < b & c",
+		Notes:   []diag.Note{{File: "a.c", Pos: lexer.Position{Line: 1, Column: 2}, Message: "source"}},
+		Fixes:   []diag.Fix{{Message: "validate it", Edits: []diag.Edit{{Pos: pos, End: pos, NewText: "check(x);"}}}},
+	}
+	for _, test := range []struct {
+		d    diag.Diagnostic
+		want string
+	}{
+		{finding, `{"file":"a.c","range":{"start":{"line":3,"column":5},"end":{"line":3,"column":9}},"severity":"high","source":"analysis","rule":"taint","message":"a < b & c",` +
+			`"notes":[{"file":"a.c","range":{"start":{"line":1,"column":2},"end":{"line":1,"column":2}},"message":"source"}],` +
+			`"fixes":[{"message":"validate it","edits":[{"range":{"start":{"line":3,"column":5},"end":{"line":3,"column":5}},"newText":"check(x);"}]}]}`},
+		{diag.Diagnostic{File: "b.c", Severity: diag.Error, Stage: "io", Message: "no such file"},
+			`{"file":"b.c","severity":"error","source":"io","message":"no such file"}`},
+	} {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(test.d); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != test.want {
+			t.Errorf("got\n%s\nwant\n%s", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package diag
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// InternalError is a panic of a step of the compiler, which Recover turns
+// into an error the step returns rather than let it take down the program
+// that called it. No input should cause one: it is a bug to report, with
+// the input and Stack
+type InternalError struct {
+	Stage string
+	Value any    // what the step panicked with
+	Stack []byte // the stack of the goroutine that panicked
+}
+
+func (e *InternalError) Error() string {
+	return fmt.Sprintf("internal error in %s: %v", e.Stage, e.Value)
+}
+
+// Unwrap returns the value panicked with if it is an error, such as a
+// runtime.Error
+func (e *InternalError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Recover, deferred by a step at the boundary of its package, stops a
+// panic of stage there and sets *err to an *InternalError in place of
+// what the step would have returned
+//
+//	func (p *Parser) ParseProgram() (program *ast.Program, err error) {
+//		defer diag.Recover("parser", &err)
+func Recover(stage string, err *error) {
+	if r := recover(); r != nil {
+		*err = &InternalError{Stage: stage, Value: r, Stack: debug.Stack()}
+	}
+}
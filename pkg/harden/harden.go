@@ -0,0 +1,183 @@
+// Package harden writes a program back out as C with run-time checks
+// added in the source, for builds that have to go through their own C
+// compiler rather than take Citadel's LLVM IR. The checks are those
+// codegen can add to the IR, bounds, overflow and division checks, and
+// assertions where the taint analysis found untrusted data reaching a
+// sink. Each check calls a static function of a prelude written ahead of
+// the program, which reports the file and line of the failure on the
+// standard error and aborts.
+package harden
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// Options selects the checks Write adds.
+type Options struct {
+	// File names the source in the messages of failed checks.
+	File string
+	// BoundsChecks checks the index of each subscript of an array whose
+	// length is known against that length.
+	BoundsChecks bool
+	// OverflowChecks checks signed int and long +, - and * for overflow.
+	OverflowChecks bool
+	// DivisionChecks checks / and % for a zero divisor and for the
+	// minimum value divided by -1.
+	DivisionChecks bool
+	// Taint are findings of the taint analysis: before the statement of
+	// each, the program reports that untrusted data reaches the sink, and
+	// aborts if compiled with CITADEL_TAINT_ABORT defined.
+	Taint []analysis.Finding
+}
+
+// Write writes program as C with the checks opts selects, formatted as
+// parser.Format does, with the comments of source. The C library headers
+// the program needs come first, in place of its own prototypes of C
+// library functions, which the subset has to write with types such as
+// char * where the headers have const char *, then the prelude of the
+// checks used, and prototypes of the functions called before their
+// declaration, which the subset allows and C does not.
+func Write(w io.Writer, program *ast.Program, source string, comments []lexer.Comment, opts Options) error {
+	r := rewrite(program, opts)
+	hardened, used := r.program, r.used
+
+	headers := map[string]bool{}
+	kept := *hardened
+	kept.Functions = nil
+	for _, fn := range r.forward {
+		// At no position, for the comments of the source to stay where they
+		// are
+		proto := *fn
+		proto.Body, proto.Pos, proto.NamePos = nil, lexer.Position{}, lexer.Position{}
+		kept.Functions = append(kept.Functions, &proto)
+	}
+	for _, fn := range hardened.Functions {
+		if header, ok := libcHeaders[fn.Name]; ok && fn.Body == nil && !r.defined[fn.Name] {
+			headers[header] = true
+			continue
+		}
+		kept.Functions = append(kept.Functions, fn)
+	}
+	for name := range r.called {
+		if header, ok := libcHeaders[name]; ok && !r.declared[name] {
+			headers[header] = true
+		}
+	}
+	if len(used) > 0 {
+		headers["limits.h"] = true
+		headers["stdio.h"] = true
+		headers["stdlib.h"] = true
+	}
+
+	var b strings.Builder
+	writeIncludes(&b, headers)
+	if len(used) > 0 {
+		b.WriteString(prelude(opts.File, used))
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+	return parser.Format(w, &kept, source, comments)
+}
+
+// WriteSource writes source, which program was parsed from, as a C
+// compiler takes it, for comparing Citadel's build of the program with
+// that compiler's. Beyond the headers of the C library functions the
+// program calls and prototypes of the functions called before their
+// declaration, written ahead of it, the source is left as it is, but for
+// its own prototypes of C library functions, which conflict with those of
+// the headers and are left out.
+func WriteSource(w io.Writer, program *ast.Program, source string) error {
+	r := rewrite(program, Options{})
+	headers := map[string]bool{}
+	blanked := make([]bool, len(source))
+	for _, fn := range program.Functions {
+		header, ok := libcHeaders[fn.Name]
+		if !ok || fn.Body != nil || r.defined[fn.Name] {
+			continue
+		}
+		headers[header] = true
+		// The declaration ends at its semicolon
+		lex := lexer.New(source[fn.Pos.Offset:])
+		tok := lex.NextToken()
+		for tok.Type != lexer.SEMICOLON && tok.Type != lexer.EOF {
+			tok = lex.NextToken()
+		}
+		for i := fn.Pos.Offset; i < fn.Pos.Offset+tok.End().Offset && i < len(source); i++ {
+			blanked[i] = source[i] != '\n'
+		}
+	}
+	for name := range r.called {
+		if header, ok := libcHeaders[name]; ok && !r.declared[name] {
+			headers[header] = true
+		}
+	}
+
+	var b strings.Builder
+	writeIncludes(&b, headers)
+	if len(r.forward) > 0 {
+		protos := &ast.Program{}
+		for _, fn := range r.forward {
+			proto := *fn
+			proto.Body = nil
+			protos.Functions = append(protos.Functions, &proto)
+		}
+		if err := parser.Format(&b, protos, "", nil); err != nil {
+			return err
+		}
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	for i := 0; i < len(source); i++ {
+		if !blanked[i] {
+			b.WriteByte(source[i])
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeIncludes writes an #include line for each of headers, in order.
+func writeIncludes(b *strings.Builder, headers map[string]bool) {
+	names := make([]string, 0, len(headers))
+	for header := range headers {
+		names = append(names, header)
+	}
+	sort.Strings(names)
+	for _, header := range names {
+		fmt.Fprintf(b, "#include <%s>\n", header)
+	}
+}
+
+// libcHeaders are the headers declaring the C library functions codegen
+// knows the signatures of.
+var libcHeaders = map[string]string{
+	"printf": "stdio.h", "sprintf": "stdio.h", "snprintf": "stdio.h", "scanf": "stdio.h",
+	"puts": "stdio.h", "putchar": "stdio.h", "getchar": "stdio.h", "gets": "stdio.h",
+	"fgets": "stdio.h", "popen": "stdio.h", "fopen": "stdio.h", "remove": "stdio.h",
+	"rename": "stdio.h",
+	"malloc": "stdlib.h", "calloc": "stdlib.h", "realloc": "stdlib.h", "free": "stdlib.h",
+	"atoi": "stdlib.h", "abs": "stdlib.h", "rand": "stdlib.h", "srand": "stdlib.h",
+	"getenv": "stdlib.h", "system": "stdlib.h", "exit": "stdlib.h", "abort": "stdlib.h",
+	"strlen": "string.h", "strcmp": "string.h", "strncmp": "string.h", "strcpy": "string.h",
+	"strncpy": "string.h", "strcat": "string.h", "strncat": "string.h", "memcpy": "string.h",
+	"memmove": "string.h", "memset": "string.h", "memcmp": "string.h", "strchr": "string.h",
+	"strrchr": "string.h", "strstr": "string.h",
+	"execl": "unistd.h", "execlp": "unistd.h", "execle": "unistd.h", "execv": "unistd.h",
+	"execvp": "unistd.h", "execve": "unistd.h", "access": "unistd.h", "chown": "unistd.h",
+	"unlink": "unistd.h",
+	"stat":   "sys/stat.h", "lstat": "sys/stat.h", "chmod": "sys/stat.h",
+	"open": "fcntl.h", "creat": "fcntl.h",
+}
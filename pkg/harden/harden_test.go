@@ -0,0 +1,119 @@
+package harden_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/harden"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// build compiles the C that write writes for src with the system C
+// compiler and runs it. It returns the exit status, -1 if the program was
+// killed, and the standard error. The test is skipped when there is no C
+// compiler.
+func build(t *testing.T, src string, write func(w io.Writer, program *ast.Program, comments []lexer.Comment) error) (int, string) {
+	t.Helper()
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip(err)
+	}
+	lex := lexer.New(src)
+	program, err := parser.New(lex).ParseProgram()
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	var c bytes.Buffer
+	if err := write(&c, program, lex.Comments()); err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+	dir := t.TempDir()
+	file, exe := filepath.Join(dir, "a.c"), filepath.Join(dir, "a.out")
+	if err := os.WriteFile(file, c.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(cc, "-o", exe, file).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s\n%s", src, err, out, c.String())
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), "X=true")
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode(), stderr.String()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stderr.String()
+}
+
+// TestWrite checks that each check aborts the program when it fails,
+// reporting the line, and lets it run when it holds, and that the checks
+// not asked for are not added.
+func TestWrite(t *testing.T) {
+	const (
+		index    = "int main() {\n    int a[4];\n    int i = 4;\n    a[0] = 3;\n    return a[i];\n}\n"
+		inRange  = "int main() {\n    int a[4];\n    int i = 3;\n    a[3] = 3;\n    return a[i];\n}\n"
+		overflow = "int main() {\n    int x = 2147483647;\n    int y = x + 1;\n    return 3;\n}\n"
+		division = "int main() {\n    int z = 0;\n    return 6 / z;\n}\n"
+		taint    = "int main() {\n    char *cmd = getenv(\"X\");\n    system(cmd);\n    return 3;\n}\n"
+	)
+	for _, test := range []struct {
+		src    string
+		opts   harden.Options
+		taint  bool // pass the taint findings of src
+		status int
+		stderr string
+	}{
+		{index, harden.Options{File: "t.c", BoundsChecks: true}, false, -1, "t.c:5: index out of bounds"},
+		{inRange, harden.Options{File: "t.c", BoundsChecks: true}, false, 3, ""},
+		{overflow, harden.Options{File: "t.c", OverflowChecks: true}, false, -1, "t.c:3: signed overflow in +"},
+		{overflow, harden.Options{File: "t.c", BoundsChecks: true}, false, 3, ""},
+		{division, harden.Options{File: "t.c", DivisionChecks: true}, false, -1, "t.c:3: division by zero"},
+		{taint, harden.Options{File: "t.c"}, true, 3, "t.c:3: untrusted data"},
+	} {
+		status, stderr := build(t, test.src, func(w io.Writer, program *ast.Program, comments []lexer.Comment) error {
+			if test.taint {
+				findings, err := analysis.Analyze(program, nil)
+				if err != nil {
+					return err
+				}
+				for _, f := range findings {
+					if f.Rule == "taint" {
+						test.opts.Taint = append(test.opts.Taint, f)
+					}
+				}
+			}
+			return harden.Write(w, program, test.src, comments, test.opts)
+		})
+		if status != test.status {
+			t.Errorf("%s with %+v: exit status %d, want %d", test.src, test.opts, status, test.status)
+		}
+		if !strings.HasPrefix(stderr, test.stderr) || test.stderr == "" && stderr != "" {
+			t.Errorf("%s with %+v: stderr %q, want %q", test.src, test.opts, stderr, test.stderr)
+		}
+	}
+}
+
+// TestWriteSource checks that the source a C compiler is given builds and
+// behaves as the program does.
+func TestWriteSource(t *testing.T) {
+	const src = "int strlen(char *s);\nint main() {\n    return twice(strlen(\"abc\"));\n}\nint twice(int n) {\n    return 2 * n;\n}\n"
+	status, stderr := build(t, src, func(w io.Writer, program *ast.Program, comments []lexer.Comment) error {
+		return harden.WriteSource(w, program, src)
+	})
+	if status != 6 {
+		t.Errorf("%s: exit status %d, want 6; %s", src, status, stderr)
+	}
+}
@@ -0,0 +1,92 @@
+package harden
+
+import (
+	"fmt"
+	"strings"
+)
+
+// helperOrder is the order the helpers of the prelude are written in.
+var helperOrder = []string{
+	"__citadel_index",
+	"__citadel_add_int", "__citadel_sub_int", "__citadel_mul_int", "__citadel_div_int", "__citadel_mod_int",
+	"__citadel_add_long", "__citadel_sub_long", "__citadel_mul_long", "__citadel_div_long", "__citadel_mod_long",
+	"__citadel_taint",
+}
+
+// arithmeticFailures are the conditions under which each arithmetic
+// helper fails, with MAX and MIN for the limits of its type, and what it
+// reports then.
+var arithmeticFailures = map[string][]string{
+	"add": {"(b > 0 && a > MAX - b) || (b < 0 && a < MIN - b)", "signed overflow in +"},
+	"sub": {"(b < 0 && a > MAX + b) || (b > 0 && a < MIN + b)", "signed overflow in -"},
+	"mul": {"a > 0 ? (b > 0 ? a > MAX / b : b < MIN / a) : (b > 0 ? a < MIN / b : (a != 0 && b < MAX / a))", "signed overflow in *"},
+	"div": {"a == MIN && b == -1", "signed overflow in /"},
+	"mod": {"a == MIN && b == -1", "signed overflow in %"},
+}
+
+var arithmeticOperators = map[string]string{"add": "+", "sub": "-", "mul": "*", "div": "/", "mod": "%"}
+
+// prelude returns the definitions of the helpers in used, for a source
+// named file.
+func prelude(file string, used map[string]bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nstatic const char __citadel_file[] = \"%s\";\n", escape(file))
+	b.WriteString(`
+static void __citadel_report(int line, const char *what) {
+    fprintf(stderr, "%s:%d: %s\n", __citadel_file, line, what);
+}
+`)
+	if len(used) > 1 || !used["__citadel_taint"] {
+		b.WriteString(`
+static void __citadel_fail(int line, const char *what) {
+    __citadel_report(line, what);
+    abort();
+}
+`)
+	}
+	for _, helper := range helperOrder {
+		if !used[helper] {
+			continue
+		}
+		b.WriteString("\n")
+		switch helper {
+		case "__citadel_index":
+			b.WriteString(`static long __citadel_index(long i, long n, int line) {
+    if (i < 0 || i >= n) {
+        __citadel_fail(line, "index out of bounds");
+    }
+    return i;
+}
+`)
+		case "__citadel_taint":
+			b.WriteString(`static void __citadel_taint(int line, const char *what) {
+    __citadel_report(line, what);
+#ifdef CITADEL_TAINT_ABORT
+    abort();
+#endif
+}
+`)
+		default:
+			b.WriteString(arithmeticHelper(helper))
+		}
+	}
+	return b.String()
+}
+
+// arithmeticHelper returns the definition of a helper named
+// __citadel_<op>_<type>.
+func arithmeticHelper(helper string) string {
+	parts := strings.Split(strings.TrimPrefix(helper, "__citadel_"), "_")
+	op, typ := parts[0], parts[1]
+	limit := strings.ToUpper(typ)
+	failure := arithmeticFailures[op]
+	cond := strings.NewReplacer("MAX", limit+"_MAX", "MIN", limit+"_MIN").Replace(failure[0])
+	var b strings.Builder
+	fmt.Fprintf(&b, "static %s %s(%s a, %s b, int line) {\n", typ, helper, typ, typ)
+	if op == "div" || op == "mod" {
+		b.WriteString("    if (b == 0) {\n        __citadel_fail(line, \"division by zero\");\n    }\n")
+	}
+	fmt.Fprintf(&b, "    if (%s) {\n        __citadel_fail(line, \"%s\");\n    }\n", cond, failure[1])
+	fmt.Fprintf(&b, "    return a %s b;\n}\n", arithmeticOperators[op])
+	return b.String()
+}
@@ -0,0 +1,360 @@
+package harden
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// rewriter builds a copy of a program with the checks added. Nodes it
+// changes are copied, so the program it was given is left as it was.
+type rewriter struct {
+	opts    Options
+	program *ast.Program // the copy
+	// functions are the declarations of the program by name, the last of
+	// each, for the types of calls
+	functions map[string]*ast.Function
+	defined   map[string]bool // functions with a body
+	declared  map[string]bool // functions with a declaration of any kind
+	called    map[string]bool // functions called by name
+	// seen are the functions declared so far, and forward those called
+	// before their declaration, which C needs a prototype of first
+	seen    map[string]bool
+	forward []*ast.Function
+	used    map[string]bool // the helpers of the prelude the checks call
+	scopes  []map[string]*ast.Type
+	// taint are the messages of the taint findings by position, until the
+	// statement or call at that position is rewritten
+	taint map[lexer.Position][]string
+	// pending are the taint assertions to go before the statement being
+	// rewritten
+	pending []ast.Statement
+	line    int // of the statement being rewritten
+}
+
+func rewrite(program *ast.Program, opts Options) *rewriter {
+	r := &rewriter{
+		opts:      opts,
+		program:   &ast.Program{},
+		functions: map[string]*ast.Function{},
+		defined:   map[string]bool{},
+		declared:  map[string]bool{},
+		called:    map[string]bool{},
+		seen:      map[string]bool{},
+		used:      map[string]bool{},
+		taint:     map[lexer.Position][]string{},
+	}
+	for _, f := range opts.Taint {
+		if f.Rule == "taint" {
+			r.taint[f.Pos] = append(r.taint[f.Pos], f.Message)
+		}
+	}
+	for _, fn := range program.Functions {
+		r.functions[fn.Name] = fn
+		r.declared[fn.Name] = true
+		r.defined[fn.Name] = r.defined[fn.Name] || fn.Body != nil
+	}
+	for _, fn := range program.Functions {
+		r.program.Functions = append(r.program.Functions, r.function(fn))
+	}
+	return r
+}
+
+func (r *rewriter) function(fn *ast.Function) *ast.Function {
+	r.seen[fn.Name] = true
+	if fn.Body == nil {
+		return fn
+	}
+	r.scopes = []map[string]*ast.Type{{}}
+	for _, param := range fn.Params {
+		r.scopes[0][param.Name] = param.Type
+	}
+	copied := *fn
+	copied.Body = r.block(fn.Body)
+	return &copied
+}
+
+func (r *rewriter) block(b *ast.Block) *ast.Block {
+	r.scopes = append(r.scopes, map[string]*ast.Type{})
+	defer func() { r.scopes = r.scopes[:len(r.scopes)-1] }()
+	copied := *b
+	copied.Statements = r.statements(b.Statements)
+	return &copied
+}
+
+// statements rewrites stmts, putting the taint assertions of each before
+// it.
+func (r *rewriter) statements(stmts []ast.Statement) []ast.Statement {
+	outer, line := r.pending, r.line
+	defer func() { r.pending, r.line = outer, line }()
+	var rewritten []ast.Statement
+	for _, stmt := range stmts {
+		r.pending, r.line = nil, stmt.Position().Line
+		r.assertTaint(stmt.Position())
+		stmt = r.statement(stmt)
+		rewritten = append(append(rewritten, r.pending...), stmt)
+	}
+	return rewritten
+}
+
+func (r *rewriter) statement(stmt ast.Statement) ast.Statement {
+	switch s := stmt.(type) {
+	case *ast.Block:
+		return r.block(s)
+	case *ast.VarDecl:
+		copied := *s
+		copied.Value = r.expr(s.Value)
+		r.scopes[len(r.scopes)-1][s.Name] = s.Type
+		return &copied
+	case *ast.IfStatement:
+		copied := *s
+		copied.Condition = r.expr(s.Condition)
+		copied.ThenBlock = r.block(s.ThenBlock)
+		if s.ElseBlock != nil {
+			copied.ElseBlock = r.block(s.ElseBlock)
+		}
+		return &copied
+	case *ast.WhileStatement:
+		copied := *s
+		copied.Condition = r.expr(s.Condition)
+		copied.Body = r.block(s.Body)
+		return &copied
+	case *ast.SwitchStatement:
+		copied := *s
+		copied.Tag = r.expr(s.Tag)
+		copied.Cases = nil
+		r.scopes = append(r.scopes, map[string]*ast.Type{})
+		for _, cs := range s.Cases {
+			c := *cs
+			c.Body = r.statements(cs.Body)
+			copied.Cases = append(copied.Cases, &c)
+		}
+		r.scopes = r.scopes[:len(r.scopes)-1]
+		return &copied
+	case *ast.ReturnStatement:
+		copied := *s
+		copied.Value = r.expr(s.Value)
+		return &copied
+	case *ast.ExprStatement:
+		copied := *s
+		copied.Expr = r.expr(s.Expr)
+		return &copied
+	}
+	return stmt
+}
+
+// assertTaint adds the taint assertions of the findings at pos to those
+// of the statement.
+func (r *rewriter) assertTaint(pos lexer.Position) {
+	for _, msg := range r.taint[pos] {
+		r.used["__citadel_taint"] = true
+		call := &ast.CallExpr{
+			Pos:    pos,
+			Callee: &ast.Identifier{Pos: pos, Name: "__citadel_taint"},
+			Args:   []ast.Expression{&ast.IntLiteral{Value: pos.Line}, &ast.StringLiteral{Pos: pos, Value: escape(msg)}},
+		}
+		r.pending = append(r.pending, &ast.ExprStatement{Pos: pos, Expr: call})
+	}
+	delete(r.taint, pos)
+}
+
+func (r *rewriter) expr(e ast.Expression) ast.Expression {
+	switch e := e.(type) {
+	case *ast.BinaryOp:
+		left, right := r.expr(e.Left), r.expr(e.Right)
+		if helper := r.arithmeticCheck(e); helper != "" {
+			return r.call(helper, left, right, &ast.IntLiteral{Value: r.line})
+		}
+		copied := *e
+		copied.Left, copied.Right = left, right
+		return &copied
+	case *ast.UnaryOp:
+		copied := *e
+		copied.Operand = r.expr(e.Operand)
+		return &copied
+	case *ast.IndexExpr:
+		copied := *e
+		copied.Array, copied.Index = r.expr(e.Array), r.expr(e.Index)
+		if array := r.arrayType(e.Array); array != nil && r.opts.BoundsChecks && !inBounds(e.Index, array.Len) {
+			copied.Index = r.call("__citadel_index", copied.Index, &ast.IntLiteral{Value: array.Len}, &ast.IntLiteral{Value: r.line})
+		}
+		return &copied
+	case *ast.Assignment:
+		copied := *e
+		copied.Target, copied.Value = r.expr(e.Target), r.expr(e.Value)
+		return &copied
+	case *ast.CallExpr:
+		r.assertTaint(e.Pos)
+		if id, ok := e.Callee.(*ast.Identifier); ok && r.lookup(id.Name) == nil {
+			r.called[id.Name] = true
+			if fn, ok := r.functions[id.Name]; ok && !r.seen[id.Name] {
+				r.seen[id.Name] = true
+				r.forward = append(r.forward, fn)
+			}
+		}
+		copied := *e
+		copied.Callee = r.expr(e.Callee)
+		copied.Args = make([]ast.Expression, len(e.Args))
+		for i, arg := range e.Args {
+			copied.Args[i] = r.expr(arg)
+		}
+		return &copied
+	}
+	return e
+}
+
+// call returns a call to the helper of the prelude named helper.
+func (r *rewriter) call(helper string, args ...ast.Expression) ast.Expression {
+	r.used[helper] = true
+	return &ast.CallExpr{Callee: &ast.Identifier{Name: helper}, Args: args}
+}
+
+// arithmeticCheck returns the helper that checks op, or "" if op is not
+// checked: checks are for signed int and long operands, as the usual
+// arithmetic conversions make them, and not for constants that cannot
+// fail.
+func (r *rewriter) arithmeticCheck(op *ast.BinaryOp) string {
+	var name string
+	switch op.Operator {
+	case "+", "-", "*":
+		if !r.opts.OverflowChecks || isLiteral(op.Left) && isLiteral(op.Right) {
+			return ""
+		}
+		name = map[string]string{"+": "add", "-": "sub", "*": "mul"}[op.Operator]
+	case "/", "%":
+		if lit, ok := op.Right.(*ast.IntLiteral); !r.opts.DivisionChecks || ok && lit.Value != 0 {
+			return ""
+		}
+		name = map[string]string{"/": "div", "%": "mod"}[op.Operator]
+	default:
+		return ""
+	}
+	left, right := r.typeOf(op.Left), r.typeOf(op.Right)
+	if left == nil || right == nil {
+		return ""
+	}
+	switch ast.CommonType(left, right) {
+	case ast.Int:
+		return "__citadel_" + name + "_int"
+	case ast.Long:
+		return "__citadel_" + name + "_long"
+	}
+	return ""
+}
+
+func isLiteral(e ast.Expression) bool {
+	_, ok := e.(*ast.IntLiteral)
+	return ok
+}
+
+// inBounds reports whether index is a constant within an array of n.
+func inBounds(index ast.Expression, n int) bool {
+	lit, ok := index.(*ast.IntLiteral)
+	return ok && lit.Value >= 0 && lit.Value < n
+}
+
+func (r *rewriter) lookup(name string) *ast.Type {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if t, ok := r.scopes[i][name]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// arrayType returns the type of e if it is an array object of known
+// length, as codegen checks the subscripts of.
+func (r *rewriter) arrayType(e ast.Expression) *ast.Type {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if t := r.lookup(e.Name); t != nil && t.Kind == ast.ArrayType {
+			return t
+		}
+	case *ast.IndexExpr:
+		if outer := r.arrayType(e.Array); outer != nil && outer.Elem.Kind == ast.ArrayType {
+			return outer.Elem
+		}
+	}
+	return nil
+}
+
+// typeOf returns the type of e as far as the checks need it, or nil.
+func (r *rewriter) typeOf(e ast.Expression) *ast.Type {
+	switch e := e.(type) {
+	case *ast.IntLiteral:
+		return ast.Int
+	case *ast.StringLiteral:
+		return ast.PointerTo(ast.Char)
+	case *ast.Identifier:
+		if t := r.lookup(e.Name); t != nil {
+			return t.Decay()
+		}
+	case *ast.BinaryOp:
+		switch e.Operator {
+		case "==", "<", ">", "&&", "||":
+			return ast.Int
+		}
+		left, right := r.typeOf(e.Left), r.typeOf(e.Right)
+		switch {
+		case left == nil || right == nil:
+			return nil
+		case left.Kind == ast.PointerType:
+			return left
+		case right.Kind == ast.PointerType:
+			return right
+		}
+		return ast.CommonType(left, right)
+	case *ast.UnaryOp:
+		t := r.typeOf(e.Operand)
+		switch {
+		case t == nil:
+			return nil
+		case e.Operator == "-":
+			return t.Promote()
+		case t.Kind == ast.PointerType:
+			return t.Elem
+		}
+	case *ast.IndexExpr:
+		if t := r.typeOf(e.Array); t != nil && t.Kind == ast.PointerType {
+			return t.Elem.Decay()
+		}
+	case *ast.Assignment:
+		return r.typeOf(e.Target)
+	case *ast.CallExpr:
+		if id, ok := e.Callee.(*ast.Identifier); ok && r.lookup(id.Name) == nil {
+			if fn, ok := r.functions[id.Name]; ok {
+				return fn.ReturnType
+			}
+			if fn := codegen.LookupLibc(id.Name); fn != nil && fn.Result != codegen.LibcVoid {
+				return fn.ResultType()
+			}
+			return nil
+		}
+		if t := r.typeOf(e.Callee); t != nil && t.IsFuncPointer() {
+			return t.Elem.Elem
+		}
+	}
+	return nil
+}
+
+// escape returns s as the text of a C string literal.
+func escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,257 @@
+package lexer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Pos is a position in a FileSet, small enough to keep in place of a
+// Position: the base of its file plus the offset in it. NoPos is no
+// position
+type Pos int
+
+const NoPos Pos = 0
+
+// IsValid reports whether p is a position
+func (p Pos) IsValid() bool {
+	return p != NoPos
+}
+
+// Location is a Position in a named file, as a FileSet resolves it
+type Location struct {
+	File string
+	Position
+}
+
+// String returns the location as file:line:col, line:col if it has no
+// file, or file or "-" if it has no line
+func (l Location) String() string {
+	switch {
+	case l.Line > 0 && l.File != "":
+		return fmt.Sprintf("%s:%s", l.File, l.Position)
+	case l.Line > 0:
+		return l.Position.String()
+	case l.File != "":
+		return l.File
+	}
+	return "-"
+}
+
+// File is a source file in a FileSet: its text and where each of its
+// lines starts, to convert between offsets, positions and Pos values. Line
+// information added with AddLineInfo makes the text of other files that
+// was pasted into it, as #include does, resolve to where it came from
+type File struct {
+	name  string
+	base  int
+	src   string
+	lines []int // the offset each line starts at
+
+	mu    sync.RWMutex
+	infos []lineInfo // by offset
+}
+
+// lineInfo says that the line starting at offset is line of file
+type lineInfo struct {
+	offset int
+	file   string
+	line   int
+}
+
+// Name returns the name the file was added with
+func (f *File) Name() string {
+	return f.name
+}
+
+// Base returns the Pos of the first byte of the file
+func (f *File) Base() int {
+	return f.base
+}
+
+// Size returns the length of the file in bytes
+func (f *File) Size() int {
+	return len(f.src)
+}
+
+// Source returns the text of the file
+func (f *File) Source() string {
+	return f.src
+}
+
+// LineCount returns how many lines the file has
+func (f *File) LineCount() int {
+	return len(f.lines)
+}
+
+// Line returns the text of line n without its line ending, or "" if the
+// file has no line n
+func (f *File) Line(n int) string {
+	if n < 1 || n > len(f.lines) {
+		return ""
+	}
+	end := len(f.src)
+	if n < len(f.lines) {
+		end = f.lines[n] - 1
+	}
+	return strings.TrimRight(f.src[f.lines[n-1]:end], "\r")
+}
+
+// Pos returns the Pos of offset in the file, taken to its start or end if
+// it is outside
+func (f *File) Pos(offset int) Pos {
+	return Pos(f.base + f.clamp(offset))
+}
+
+// Offset returns the offset in the file of p, a Pos of it
+func (f *File) Offset(p Pos) int {
+	return f.clamp(int(p) - f.base)
+}
+
+func (f *File) clamp(offset int) int {
+	if offset < 0 {
+		return 0
+	}
+	if offset > len(f.src) {
+		return len(f.src)
+	}
+	return offset
+}
+
+// Position returns the line and column of offset, as the lexer counts
+// them
+func (f *File) Position(offset int) Position {
+	offset = f.clamp(offset)
+	i := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset }) - 1
+	return Position{Line: i + 1, Column: offset - f.lines[i] + 1, Offset: offset}
+}
+
+// OffsetOf returns the offset of the line and column of pos, for a
+// position that does not carry it
+func (f *File) OffsetOf(pos Position) int {
+	if pos.Line < 1 {
+		return 0
+	}
+	if pos.Line > len(f.lines) {
+		return len(f.src)
+	}
+	return f.clamp(f.lines[pos.Line-1] + pos.Column - 1)
+}
+
+// AddLineInfo records that the line starting at offset, and those after
+// it up to the next line information, are line and the lines after it of
+// file, for Location to give
+func (f *File) AddLineInfo(offset int, file string, line int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := sort.Search(len(f.infos), func(i int) bool { return f.infos[i].offset > offset })
+	f.infos = append(f.infos, lineInfo{})
+	copy(f.infos[i+1:], f.infos[i:])
+	f.infos[i] = lineInfo{offset, file, line}
+}
+
+// Location returns the file, line and column of offset, which line
+// information may place in another file than this one. The offset is
+// always that in this file
+func (f *File) Location(offset int) Location {
+	pos := f.Position(offset)
+	loc := Location{File: f.name, Position: pos}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	i := sort.Search(len(f.infos), func(i int) bool { return f.infos[i].offset > pos.Offset }) - 1
+	if i >= 0 {
+		info := f.infos[i]
+		loc.File = info.file
+		loc.Line = info.line + pos.Line - f.Position(info.offset).Line
+	}
+	return loc
+}
+
+// FileSet is the source files of a program, each taking up its own range
+// of Pos values, so that one Pos says which file it is in as well as
+// where. It is safe to use from several goroutines; the zero FileSet is
+// empty and ready to use
+type FileSet struct {
+	mu    sync.RWMutex
+	base  int
+	files []*File
+}
+
+// AddFile adds a file called name with text src to the set, after the
+// files already in it
+func (s *FileSet) AddFile(name, src string) *File {
+	f := &File{name: name, src: src, lines: []int{0}}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.base == 0 {
+		s.base = 1 // Pos 0 is NoPos
+	}
+	f.base = s.base
+	// The Pos after the last byte of a file is its own, for the end of
+	// text running to the end of the file
+	s.base += len(src) + 1
+	s.files = append(s.files, f)
+	return f
+}
+
+// Files returns the files of the set, in the order they were added
+func (s *FileSet) Files() []*File {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*File(nil), s.files...)
+}
+
+// File returns the file p is in, or nil if it is in none
+func (s *FileSet) File(p Pos) *File {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].base > int(p) }) - 1
+	if i < 0 || int(p) > s.files[i].base+len(s.files[i].src) {
+		return nil
+	}
+	return s.files[i]
+}
+
+// Lookup returns the file last added as name, or nil if there is none
+func (s *FileSet) Lookup(name string) *File {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.files) - 1; i >= 0; i-- {
+		if s.files[i].name == name {
+			return s.files[i]
+		}
+	}
+	return nil
+}
+
+// Location returns where p is, or a Location that is not valid if p is in
+// none of the files
+func (s *FileSet) Location(p Pos) Location {
+	f := s.File(p)
+	if f == nil {
+		return Location{}
+	}
+	return f.Location(f.Offset(p))
+}
+
+// Resolve returns the Location of pos, a position in the file called
+// name, as Location does for its offset; a position without an offset has
+// it worked out from its line and column. A file the set does not have
+// gives pos as it is
+func (s *FileSet) Resolve(name string, pos Position) Location {
+	f := s.Lookup(name)
+	if f == nil || pos.Line < 1 {
+		return Location{File: name, Position: pos}
+	}
+	offset := pos.Offset
+	if offset == 0 {
+		offset = f.OffsetOf(pos)
+	}
+	return f.Location(offset)
+}
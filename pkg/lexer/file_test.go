@@ -0,0 +1,71 @@
+package lexer
+
+import "testing"
+
+// TestFileSet checks that each file of a set takes its own range of Pos
+// values, which convert back to the file, offset, line and column they
+// were made from, and that line information places text in other files
+func TestFileSet(t *testing.T) {
+	var s FileSet
+	a := s.AddFile("a.c", "int a;\nint b;\n")
+	b := s.AddFile("b.c", "x\r\ny")
+	if a.Base() != 1 || b.Base() != a.Base()+a.Size()+1 {
+		t.Errorf("bases %d and %d, want 1 and %d", a.Base(), b.Base(), a.Size()+2)
+	}
+	if NoPos.IsValid() || !a.Pos(0).IsValid() {
+		t.Error("NoPos is valid, or the first byte of a.c is not")
+	}
+
+	for _, test := range []struct {
+		file   *File
+		offset int
+		want   string
+	}{
+		{a, 0, "a.c:1:1"},
+		{a, 11, "a.c:2:5"},
+		{a, a.Size(), "a.c:3:1"}, // the end of the file is its own
+		{b, 3, "b.c:2:1"},
+		{b, 100, "b.c:2:2"}, // taken to the end
+	} {
+		p := test.file.Pos(test.offset)
+		if got := s.File(p); got != test.file {
+			t.Errorf("File(Pos(%d)) of %s is %v", test.offset, test.file.Name(), got)
+		}
+		if got := s.Location(p).String(); got != test.want {
+			t.Errorf("Location of offset %d of %s is %s, want %s", test.offset, test.file.Name(), got, test.want)
+		}
+	}
+	if got := a.Offset(a.Pos(11)); got != 11 {
+		t.Errorf("Offset(Pos(11)) = %d", got)
+	}
+	if s.File(NoPos) != nil || s.File(Pos(b.Base()+b.Size()+1)) != nil || s.Location(NoPos).String() != "-" {
+		t.Error("a Pos outside the files resolves")
+	}
+
+	if a.LineCount() != 3 || a.Line(2) != "int b;" || b.Line(1) != "x" || a.Line(0) != "" || a.Line(4) != "" {
+		t.Errorf("lines of a.c: %d, %q; of b.c: %q", a.LineCount(), a.Line(2), b.Line(1))
+	}
+	if got := a.OffsetOf(Position{Line: 2, Column: 5}); got != 11 {
+		t.Errorf("OffsetOf(2:5) = %d, want 11", got)
+	}
+
+	// A header pasted into a.c from its second line on
+	a.AddLineInfo(7, "h.h", 10)
+	if got := a.Location(11).String(); got != "h.h:10:5" {
+		t.Errorf("Location after line information is %s, want h.h:10:5", got)
+	}
+	if got := a.Location(0).String(); got != "a.c:1:1" {
+		t.Errorf("Location before line information is %s, want a.c:1:1", got)
+	}
+	if got := s.Resolve("a.c", Position{Line: 2, Column: 5}).String(); got != "h.h:10:5" {
+		t.Errorf("Resolve(a.c, 2:5) = %s, want h.h:10:5", got)
+	}
+	if got := s.Resolve("other.c", Position{Line: 2, Column: 5}).String(); got != "other.c:2:5" {
+		t.Errorf("Resolve of a file not in the set = %s", got)
+	}
+
+	again := s.AddFile("a.c", "")
+	if s.Lookup("a.c") != again || s.Lookup("c.c") != nil || len(s.Files()) != 3 {
+		t.Errorf("Lookup finds %v, want the last a.c added", s.Lookup("a.c"))
+	}
+}
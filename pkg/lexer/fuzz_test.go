@@ -0,0 +1,29 @@
+package lexer
+
+import "testing"
+
+// FuzzLexer checks that lexing any input ends, each token but EOF taking
+// at least a byte of it
+func FuzzLexer(f *testing.F) {
+	for _, seed := range []string{
+		"int main() { return 0; }",
+		"char *s = \"a\\\"b\" \"c\"; /* x */ // y\n",
+		"\"unterminated",
+		"/* unterminated",
+		"a == b && c || d < 1 > 2 % 3",
+		"int\x00x \xc3\xa9 \x80",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		lex := New(input)
+		for n := 0; ; n++ {
+			if n > len(input) {
+				t.Fatalf("more than %d tokens from %d bytes", n, len(input))
+			}
+			if lex.NextToken().Type == EOF {
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,428 @@
+package lexer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type TokenType int
+
+const (
+	// Keywords
+	INT TokenType = iota
+	CHAR
+	SHORT
+	LONG
+	FLOAT
+	DOUBLE
+	IF
+	RETURN
+	SWITCH
+	CASE
+	DEFAULT
+	BREAK
+	WHILE
+
+	// Identifiers and literals
+	IDENTIFIER
+	NUMBER
+	STRING
+
+	// Operators
+	EQUALS
+	EQUAL_EQUAL // ==
+	PLUS
+	MINUS
+	STAR
+	SLASH
+	PERCENT
+	GREATER
+	LESS
+	AND_AND // &&
+	OR_OR   // ||
+
+	// Delimiters
+	LPAREN
+	RPAREN
+	LBRACE
+	RBRACE
+	LBRACKET
+	RBRACKET
+	SEMICOLON
+	COLON
+	COMMA
+
+	// Special
+	EOF
+	ILLEGAL
+)
+
+var tokenNames = []string{
+	"INT", "CHAR", "SHORT", "LONG", "FLOAT", "DOUBLE", "IF", "RETURN", "SWITCH", "CASE", "DEFAULT", "BREAK", "WHILE",
+	"IDENTIFIER", "NUMBER", "STRING",
+	"EQUALS", "EQUAL_EQUAL", "PLUS", "MINUS", "STAR", "SLASH", "PERCENT", "GREATER", "LESS", "AND_AND", "OR_OR",
+	"LPAREN", "RPAREN", "LBRACE", "RBRACE", "LBRACKET", "RBRACKET", "SEMICOLON", "COLON", "COMMA",
+	"EOF", "ILLEGAL",
+}
+
+// String returns the name of the token type as declared, e.g. "AND_AND"
+func (t TokenType) String() string {
+	if t < 0 || int(t) >= len(tokenNames) {
+		return fmt.Sprintf("TokenType(%d)", int(t))
+	}
+	return tokenNames[t]
+}
+
+// Position is a 1-based line and column in the source
+// Position is where text is in its source: a line and a column of bytes
+// counted from 1, and the number of bytes before it. Positions the lexer
+// gives have all three; those made from a line and column alone, such as
+// the end of a range, may have an Offset of 0, which File.OffsetOf makes
+// good
+type Position struct {
+	Line   int
+	Column int
+	Offset int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+type Token struct {
+	Type    TokenType
+	Literal string
+	Pos     Position // where the token starts
+}
+
+// End returns where the text of a token on one line ends, after its last
+// character; a STRING's literal leaves out the quotes that are part of it
+func (t Token) End() Position {
+	n := len(t.Literal)
+	if t.Type == STRING {
+		n += 2
+	}
+	end := t.Pos
+	end.Column += n
+	end.Offset += n
+	return end
+}
+
+// Error is text the lexer cannot make a token of, which it returns as an
+// ILLEGAL token: a character outside the C subset or a string literal
+// with no closing quote. End is where the text ends
+type Error struct {
+	Pos     Position
+	End     Position
+	Literal string
+	Msg     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// TokenError returns the Error an ILLEGAL token stands for, or nil for
+// any other token
+func TokenError(tok Token) *Error {
+	if tok.Type != ILLEGAL {
+		return nil
+	}
+	e := &Error{Pos: tok.Pos, End: tok.Pos, Literal: tok.Literal}
+	if strings.HasPrefix(tok.Literal, "\"") {
+		e.Msg = "unterminated string literal"
+	} else {
+		e.Msg = fmt.Sprintf("unexpected character '%s'", printable(tok.Literal))
+		e.End.Column += len(tok.Literal)
+		e.End.Offset += len(tok.Literal)
+	}
+	return e
+}
+
+// printable returns lit, a character, with bytes that are not printable
+// ASCII escaped as \xNN, for errors not to write them to a terminal
+func printable(lit string) string {
+	var b strings.Builder
+	for i := 0; i < len(lit); i++ {
+		if c := lit[i]; c < ' ' || c > '~' {
+			fmt.Fprintf(&b, "\\x%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Comment is a // or /* */ comment, with its delimiters
+type Comment struct {
+	Text  string
+	Pos   Position // where the comment starts
+	Alone bool     // no code shares a line with it
+}
+
+type Lexer struct {
+	input    string
+	pos      int
+	current  byte
+	line     int
+	column   int
+	comments []Comment
+}
+
+func New(input string) *Lexer {
+	l := &Lexer{input: input, line: 1, column: 1}
+	if len(input) > 0 {
+		l.current = input[0]
+	}
+	return l
+}
+
+func (l *Lexer) advance() {
+	if l.current == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	l.pos++
+	if l.pos >= len(l.input) {
+		l.current = 0
+	} else {
+		l.current = l.input[l.pos]
+	}
+}
+
+func (l *Lexer) peek() byte {
+	if l.pos+1 >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+1]
+}
+
+// alone reports whether only blanks surround input[start:end] on its
+// first and last lines
+func (l *Lexer) alone(start, end int) bool {
+	for i := start - 1; i >= 0 && l.input[i] != '\n'; i-- {
+		if l.input[i] != ' ' && l.input[i] != '\t' && l.input[i] != '\r' {
+			return false
+		}
+	}
+	for i := end; i < len(l.input) && l.input[i] != '\n'; i++ {
+		if l.input[i] != ' ' && l.input[i] != '\t' && l.input[i] != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// skipWhitespace skips whitespace and // and /* */ comments
+func (l *Lexer) skipWhitespace() {
+	for {
+		switch {
+		case l.current == ' ' || l.current == '\t' || l.current == '\n' || l.current == '\r':
+			l.advance()
+		case l.current == '/' && l.peek() == '/':
+			start, pos := l.pos, Position{l.line, l.column, l.pos}
+			for l.current != '\n' && l.current != 0 {
+				l.advance()
+			}
+			l.comments = append(l.comments, Comment{l.input[start:l.pos], pos, l.alone(start, l.pos)})
+		case l.current == '/' && l.peek() == '*':
+			start, pos := l.pos, Position{l.line, l.column, l.pos}
+			l.advance()
+			l.advance()
+			for l.current != 0 && !(l.current == '*' && l.peek() == '/') {
+				l.advance()
+			}
+			if l.current != 0 {
+				l.advance()
+				l.advance()
+			}
+			l.comments = append(l.comments, Comment{l.input[start:l.pos], pos, l.alone(start, l.pos)})
+		default:
+			return
+		}
+	}
+}
+
+// Comments returns the comments skipped so far, in source order
+func (l *Lexer) Comments() []Comment {
+	return l.comments
+}
+
+func (l *Lexer) readIdentifier() string {
+	start := l.pos
+	for unicode.IsLetter(rune(l.current)) || unicode.IsDigit(rune(l.current)) || l.current == '_' {
+		l.advance()
+	}
+	return l.input[start:l.pos]
+}
+
+// readString reads a double-quoted string literal and returns its contents
+// with escape sequences left as written.
+func (l *Lexer) readString() (string, bool) {
+	l.advance() // consume opening quote
+	start := l.pos
+	for l.current != '"' {
+		if l.current == 0 || l.current == '\n' {
+			return l.input[start:l.pos], false
+		}
+		if l.current == '\\' && l.peek() != 0 {
+			l.advance()
+		}
+		l.advance()
+	}
+	literal := l.input[start:l.pos]
+	l.advance() // consume closing quote
+	return literal, true
+}
+
+func (l *Lexer) readNumber() string {
+	start := l.pos
+	for unicode.IsDigit(rune(l.current)) {
+		l.advance()
+	}
+	return l.input[start:l.pos]
+}
+
+func (l *Lexer) NextToken() Token {
+	l.skipWhitespace()
+	pos := Position{Line: l.line, Column: l.column, Offset: l.pos}
+
+	// A NUL byte before the end is an illegal character like any other
+	if l.pos >= len(l.input) {
+		return Token{Type: EOF, Literal: "", Pos: pos}
+	}
+
+	var tok Token
+
+	switch l.current {
+	case '=':
+		if l.peek() == '=' {
+			l.advance()
+			l.advance()
+			tok = Token{Type: EQUAL_EQUAL, Literal: "=="}
+		} else {
+			tok = Token{Type: EQUALS, Literal: "="}
+			l.advance()
+		}
+	case '+':
+		tok = Token{Type: PLUS, Literal: "+"}
+		l.advance()
+	case '-':
+		tok = Token{Type: MINUS, Literal: "-"}
+		l.advance()
+	case '*':
+		tok = Token{Type: STAR, Literal: "*"}
+		l.advance()
+	case '/':
+		tok = Token{Type: SLASH, Literal: "/"}
+		l.advance()
+	case '%':
+		tok = Token{Type: PERCENT, Literal: "%"}
+		l.advance()
+	case '>':
+		tok = Token{Type: GREATER, Literal: ">"}
+		l.advance()
+	case '<':
+		tok = Token{Type: LESS, Literal: "<"}
+		l.advance()
+	case '&':
+		if l.peek() == '&' {
+			l.advance()
+			l.advance()
+			tok = Token{Type: AND_AND, Literal: "&&"}
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: "&"}
+			l.advance()
+		}
+	case '|':
+		if l.peek() == '|' {
+			l.advance()
+			l.advance()
+			tok = Token{Type: OR_OR, Literal: "||"}
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: "|"}
+			l.advance()
+		}
+	case '(':
+		tok = Token{Type: LPAREN, Literal: "("}
+		l.advance()
+	case ')':
+		tok = Token{Type: RPAREN, Literal: ")"}
+		l.advance()
+	case '{':
+		tok = Token{Type: LBRACE, Literal: "{"}
+		l.advance()
+	case '}':
+		tok = Token{Type: RBRACE, Literal: "}"}
+		l.advance()
+	case '[':
+		tok = Token{Type: LBRACKET, Literal: "["}
+		l.advance()
+	case ']':
+		tok = Token{Type: RBRACKET, Literal: "]"}
+		l.advance()
+	case ';':
+		tok = Token{Type: SEMICOLON, Literal: ";"}
+		l.advance()
+	case ':':
+		tok = Token{Type: COLON, Literal: ":"}
+		l.advance()
+	case ',':
+		tok = Token{Type: COMMA, Literal: ","}
+		l.advance()
+	case '"':
+		literal, ok := l.readString()
+		if ok {
+			tok = Token{Type: STRING, Literal: literal}
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: "\"" + literal}
+		}
+	default:
+		if unicode.IsLetter(rune(l.current)) || l.current == '_' {
+			literal := l.readIdentifier()
+			tok = Token{Literal: literal}
+			// Check keywords
+			switch literal {
+			case "int":
+				tok.Type = INT
+			case "char":
+				tok.Type = CHAR
+			case "short":
+				tok.Type = SHORT
+			case "long":
+				tok.Type = LONG
+			case "float":
+				tok.Type = FLOAT
+			case "double":
+				tok.Type = DOUBLE
+			case "if":
+				tok.Type = IF
+			case "return":
+				tok.Type = RETURN
+			case "switch":
+				tok.Type = SWITCH
+			case "case":
+				tok.Type = CASE
+			case "default":
+				tok.Type = DEFAULT
+			case "break":
+				tok.Type = BREAK
+			case "while":
+				tok.Type = WHILE
+			default:
+				tok.Type = IDENTIFIER
+			}
+		} else if unicode.IsDigit(rune(l.current)) {
+			tok = Token{Type: NUMBER, Literal: l.readNumber()}
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: l.input[l.pos : l.pos+1]}
+			l.advance()
+		}
+	}
+
+	tok.Pos = pos
+	return tok
+}
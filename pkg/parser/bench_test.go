@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// largeUnit returns a translation unit of n functions of a few dozen
+// nodes each
+func largeUnit(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `int f%d(int a, char *s) {
+    int buf[8];
+    int x = a * 2 + f%d(a - 1, s);
+    buf[x %% 8] = x;
+    if (x > 10) {
+        x = x - buf[0] / (a + 1);
+    }
+    switch (a) {
+    case 1:
+        puts("one");
+        break;
+    default:
+        x = -x;
+    }
+    return x + s[0];
+}
+`, i, i)
+	}
+	return b.String()
+}
+
+// BenchmarkParseProgram compares parsing a large file into an Arena, as
+// ParseProgram does by default, with allocating each node on its own.
+// Besides time and allocations it reports the garbage collections each
+// parse causes
+func BenchmarkParseProgram(b *testing.B) {
+	src := largeUnit(2000)
+	for _, bench := range []struct {
+		name  string
+		arena func() *ast.Arena
+	}{
+		{"arena", ast.NewArena},
+		{"heap", func() *ast.Arena { return nil }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			for i := 0; i < b.N; i++ {
+				if _, err := New(lexer.New(src), WithArena(bench.arena())).ParseProgram(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N), "gcs/op")
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// Error is a problem with the source at a position of a file, from the
+// parser or from a later stage that checks the program. End is where
+// the offending text ends, or Pos if only its start is known. Notes
+// point at other code the problem involves, such as an earlier
+// declaration. Err is the error of the stage that found the problem, such
+// as a *SyntaxError, when it has one of its own
+type Error struct {
+	File  string
+	Pos   lexer.Position
+	End   lexer.Position
+	Msg   string
+	Notes []Note
+	Err   error
+}
+
+// Note is a remark on an Error about code at another position
+type Note struct {
+	File string
+	Pos  lexer.Position
+	Msg  string
+}
+
+// Error returns the position and message of the error, followed by a
+// line for each note
+func (e *Error) Error() string {
+	lines := []string{location(e.File, e.Pos) + e.Msg}
+	for _, note := range e.Notes {
+		lines = append(lines, location(note.File, note.Pos)+"note: "+note.Msg)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns Err, for errors.Is and errors.As to look into
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func location(file string, pos lexer.Position) string {
+	if file == "" {
+		return fmt.Sprintf("%s: ", pos)
+	}
+	return fmt.Sprintf("%s:%s: ", file, pos)
+}
+
+// ErrorList is several errors found at once, one per line
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	lines := make([]string, len(l))
+	for i, err := range l {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Diagnostic returns the error as a diagnostic of stage. A missing ; ) ]
+// } or : comes with a fix inserting it
+func (e *Error) Diagnostic(stage string) diag.Diagnostic {
+	d := diag.Diagnostic{File: e.File, Pos: e.Pos, End: e.End, Severity: diag.Error, Stage: stage, Message: e.Msg}
+	for _, note := range e.Notes {
+		d.Notes = append(d.Notes, diag.Note{File: note.File, Pos: note.Pos, Message: note.Msg})
+	}
+	var serr *SyntaxError
+	if errors.As(e.Err, &serr) && serr.After.Line > 0 {
+		if text, ok := insertable[serr.Expected]; ok {
+			d.Fixes = []diag.Fix{{
+				Message: fmt.Sprintf("insert '%s'", text),
+				Edits:   []diag.Edit{{Pos: serr.After, End: serr.After, NewText: text}},
+			}}
+		}
+	}
+	return d
+}
+
+// insertable is the text of the tokens a fix can insert where one is
+// missing, by name
+var insertable = map[string]string{
+	lexer.SEMICOLON.String(): ";",
+	lexer.RPAREN.String():    ")",
+	lexer.RBRACKET.String():  "]",
+	lexer.RBRACE.String():    "}",
+	lexer.COLON.String():     ":",
+}
+
+// Diagnostics returns the error as the one diagnostic of stage, for
+// diag.Bag.AddError
+func (e *Error) Diagnostics(stage string) []diag.Diagnostic {
+	return []diag.Diagnostic{e.Diagnostic(stage)}
+}
+
+// Diagnostics returns a diagnostic of stage for each error
+func (l ErrorList) Diagnostics(stage string) []diag.Diagnostic {
+	diags := make([]diag.Diagnostic, len(l))
+	for i, err := range l {
+		diags[i] = err.Diagnostic(stage)
+	}
+	return diags
+}
+
+// ErrTooDeep and ErrTooLong are the causes of the SyntaxErrors for input
+// nested deeper than MaxNesting and for expressions chaining more than
+// MaxOperators operators
+var (
+	ErrTooDeep = errors.New("nesting too deep")
+	ErrTooLong = errors.New("too many operators")
+)
+
+// SyntaxError is where the source does not follow the grammar of the C
+// subset. Token is the token the parser stopped at, and Pos and End span
+// its text. Expected is what the parser wanted in its place, such as
+// "RPAREN" or "parameter type", or "" if the token is wrong in itself. Err
+// is the cause, if there is more to say: a *lexer.Error for an ILLEGAL
+// token, or ErrTooDeep or ErrTooLong. After is where the token before
+// Token ends, where what was expected goes, when the parser wanted a
+// particular token
+type SyntaxError struct {
+	Pos      lexer.Position
+	End      lexer.Position
+	After    lexer.Position
+	Token    lexer.Token
+	Expected string
+	Msg      string
+	Err      error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// syntaxError returns a SyntaxError at tok, spanning its text
+func syntaxError(tok lexer.Token, expected, msg string) *SyntaxError {
+	end := tok.Pos
+	if !strings.Contains(tok.Literal, "\n") {
+		end.Column += len(tok.Literal)
+		end.Offset += len(tok.Literal)
+	}
+	e := &SyntaxError{Pos: tok.Pos, End: end, Token: tok, Expected: expected, Msg: msg}
+	if lexErr := lexer.TokenError(tok); lexErr != nil {
+		e.Err = lexErr
+	}
+	return e
+}
+
+// errorAt returns err as an Error at tok, spanning its text, unless it is
+// one already
+func errorAt(tok lexer.Token, err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	serr, ok := err.(*SyntaxError)
+	if !ok {
+		serr = syntaxError(tok, "", err.Error())
+	}
+	return &Error{Pos: serr.Pos, End: serr.End, Msg: serr.Msg, Err: serr}
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// TestMaxErrors checks that WithMaxErrors has the parser go on past broken
+// functions, collecting up to the limit, and that it stops at the first
+// error by default
+func TestMaxErrors(t *testing.T) {
+	const src = "int f( { return 0; }\nint g() { int = 1; return 0; }\nint h() { return (1; }\nint main() { return 0; }\n"
+	for _, test := range []struct {
+		max   int // -1 for the default
+		lines []int
+	}{
+		{-1, []int{1}},
+		{2, []int{1, 2}},
+		{10, []int{1, 2, 3}},
+		{0, []int{1, 2, 3}},
+	} {
+		var opts []Option
+		if test.max >= 0 {
+			opts = append(opts, WithMaxErrors(test.max))
+		}
+		_, err := New(lexer.New(src), opts...).ParseProgram()
+		var errs []*Error
+		switch err := err.(type) {
+		case *Error:
+			errs = []*Error{err}
+		case ErrorList:
+			errs = err
+		default:
+			t.Fatalf("max %d: got %v, want parse errors", test.max, err)
+		}
+		var lines []int
+		for _, e := range errs {
+			lines = append(lines, e.Pos.Line)
+		}
+		if len(lines) != len(test.lines) {
+			t.Errorf("max %d: errors on lines %v, want %v", test.max, lines, test.lines)
+			continue
+		}
+		for i := range lines {
+			if lines[i] != test.lines[i] {
+				t.Errorf("max %d: errors on lines %v, want %v", test.max, lines, test.lines)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// Format writes program back out as C source in one layout: four-space
+// indentation, opening braces on the line of their statement, one
+// statement per line and a blank line between function definitions.
+// Expressions get only the parentheses precedence needs and the ones
+// the source wrote around operators. The comments of source go before
+// the code that follows them, or at the end of the line when they ended
+// a line of code, and single blank lines between statements are kept
+func Format(w io.Writer, program *ast.Program, source string, comments []lexer.Comment) (err error) {
+	defer diag.Recover("format", &err)
+	f := &formatter{comments: comments}
+	if source != "" {
+		f.source = strings.Split(source, "\n")
+	}
+	for i, fn := range program.Functions {
+		if i > 0 && (fn.Body != nil || program.Functions[i-1].Body != nil) {
+			f.blank()
+		}
+		f.function(fn)
+	}
+	f.flush(lexer.Position{Line: len(f.source) + 1}, 0)
+	if len(f.lines) == 0 {
+		return nil
+	}
+	_, err = io.WriteString(w, strings.Join(f.lines, "\n")+"\n")
+	return err
+}
+
+// formatter collects the lines Format writes
+type formatter struct {
+	lines    []string
+	source   []string // the lines of the source
+	comments []lexer.Comment
+	next     int // the first comment not yet written
+}
+
+func (f *formatter) line(depth int, text string) {
+	f.lines = append(f.lines, strings.Repeat("    ", depth)+text)
+}
+
+// blank ends the lines so far with a blank line, unless they already end
+// with one or open a block
+func (f *formatter) blank() {
+	if n := len(f.lines); n > 0 && f.lines[n-1] != "" && !strings.HasSuffix(f.lines[n-1], "{") {
+		f.lines = append(f.lines, "")
+	}
+}
+
+// blankAbove reports whether the source line before line is blank
+func (f *formatter) blankAbove(line int) bool {
+	return line >= 2 && line-2 < len(f.source) && strings.TrimSpace(f.source[line-2]) == ""
+}
+
+// flush writes the comments before pos. One that follows code on its
+// line goes at the end of the last line written, the others on lines of
+// their own at depth
+func (f *formatter) flush(pos lexer.Position, depth int) {
+	for ; f.next < len(f.comments) && before(f.comments[f.next].Pos, pos); f.next++ {
+		c := f.comments[f.next]
+		if last := f.lastLine(); last >= 0 && f.followsCode(c.Pos) {
+			f.lines[last] += " " + c.Text
+			continue
+		}
+		if f.blankAbove(c.Pos.Line) {
+			f.blank()
+		}
+		f.line(depth, c.Text)
+	}
+}
+
+// lastLine returns the index of the last line that is not blank, or -1
+func (f *formatter) lastLine() int {
+	i := len(f.lines) - 1
+	for i >= 0 && f.lines[i] == "" {
+		i--
+	}
+	return i
+}
+
+// followsCode reports whether the source has anything but blanks before
+// pos on its line
+func (f *formatter) followsCode(pos lexer.Position) bool {
+	if pos.Line < 1 || pos.Line > len(f.source) {
+		return false
+	}
+	line := f.source[pos.Line-1]
+	if pos.Column-1 < len(line) {
+		line = line[:pos.Column-1]
+	}
+	return strings.TrimSpace(line) != ""
+}
+
+// node starts the code at pos: the comments before it, then a blank line
+// if the source had one above it
+func (f *formatter) node(pos lexer.Position, depth int) {
+	f.flush(pos, depth)
+	if f.blankAbove(pos.Line) {
+		f.blank()
+	}
+}
+
+func before(a, b lexer.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+}
+
+func (f *formatter) function(fn *ast.Function) {
+	f.node(fn.Pos, 0)
+	var header strings.Builder
+	if len(fn.Attributes) > 0 {
+		attrs := []string{}
+		for _, attr := range fn.Attributes {
+			attrs = append(attrs, formatAttribute(attr))
+		}
+		fmt.Fprintf(&header, "__attribute__((%s)) ", strings.Join(attrs, ", "))
+	}
+	if fn.Static {
+		header.WriteString("static ")
+	}
+	params := []string{}
+	for _, param := range fn.Params {
+		typ := param.Written
+		if typ == nil {
+			typ = param.Type
+		}
+		params = append(params, Declarator(typ, param.Name))
+	}
+	header.WriteString(Declarator(fn.ReturnType, fn.Name+"("+strings.Join(params, ", ")+")"))
+	if fn.Body == nil {
+		f.line(0, header.String()+";")
+		return
+	}
+	f.line(0, header.String()+" {")
+	f.block(fn.Body, 0)
+}
+
+func formatAttribute(attr *ast.Attribute) string {
+	if len(attr.Args) == 0 {
+		return attr.Name
+	}
+	args := []string{}
+	for i, arg := range attr.Args {
+		if i < len(attr.StringArgs) && attr.StringArgs[i] {
+			arg = "\"" + arg + "\""
+		}
+		args = append(args, arg)
+	}
+	return attr.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Declarator returns the declaration of name with type t, such as
+// char *buf[4] or int (*cb)(int); an empty name gives the type alone
+func Declarator(t *ast.Type, name string) string {
+	switch {
+	case t.IsFuncPointer():
+		params := []string{}
+		for _, param := range t.Elem.Params {
+			params = append(params, Declarator(param, ""))
+		}
+		return Declarator(t.Elem.Elem, "(*"+name+")("+strings.Join(params, ", ")+")")
+	case t.Kind == ast.PointerType:
+		return Declarator(t.Elem, "*"+name)
+	case t.Kind == ast.ArrayType:
+		return Declarator(t.Elem, fmt.Sprintf("%s[%d]", name, t.Len))
+	case name == "":
+		return t.Name
+	}
+	return t.Name + " " + name
+}
+
+// FormatExpr returns e as C source, with the parentheses Format would
+// give it, for messages about it
+func FormatExpr(e ast.Expression) string {
+	return formatExpr(e, 0)
+}
+
+// block writes the statements of b, opened on the line before, and its
+// closing brace
+func (f *formatter) block(b *ast.Block, depth int) {
+	for _, stmt := range b.Statements {
+		f.statement(stmt, depth+1)
+	}
+	f.flush(b.End, depth+1)
+	f.line(depth, "}")
+}
+
+func (f *formatter) statement(stmt ast.Statement, depth int) {
+	f.node(stmt.Position(), depth)
+	switch s := stmt.(type) {
+	case *ast.Block:
+		f.line(depth, "{")
+		f.block(s, depth)
+	case *ast.VarDecl:
+		decl := Declarator(s.Type, s.Name)
+		if s.Value != nil {
+			decl += " = " + formatExpr(s.Value, 0)
+		}
+		f.line(depth, decl+";")
+	case *ast.IfStatement:
+		f.line(depth, "if ("+formatExpr(s.Condition, 0)+") {")
+		f.block(s.ThenBlock, depth)
+		if s.ElseBlock != nil {
+			f.lines[len(f.lines)-1] += " else {"
+			f.block(s.ElseBlock, depth)
+		}
+	case *ast.SwitchStatement:
+		f.line(depth, "switch ("+formatExpr(s.Tag, 0)+") {")
+		for _, cs := range s.Cases {
+			f.node(cs.Pos, depth)
+			if cs.Value == nil {
+				f.line(depth, "default:")
+			} else {
+				f.line(depth, "case "+formatExpr(cs.Value, 0)+":")
+			}
+			for _, stmt := range cs.Body {
+				f.statement(stmt, depth+1)
+			}
+		}
+		f.flush(s.End, depth+1)
+		f.line(depth, "}")
+	case *ast.WhileStatement:
+		f.line(depth, "while ("+formatExpr(s.Condition, 0)+") {")
+		f.block(s.Body, depth)
+	case *ast.BreakStatement:
+		f.line(depth, "break;")
+	case *ast.ReturnStatement:
+		if s.Value == nil {
+			f.line(depth, "return;")
+		} else {
+			f.line(depth, "return "+formatExpr(s.Value, 0)+";")
+		}
+	case *ast.ExprStatement:
+		f.line(depth, formatExpr(s.Expr, 0)+";")
+	case *ast.AsmStatement:
+		f.line(depth, "__asm__(\""+s.Template+"\");")
+	}
+}
+
+// Operator precedences as the parser climbs them, with assignment below
+// the binary operators and unary and postfix operators above
+const (
+	assignPrec  = 0
+	unaryPrec   = 7
+	postfixPrec = 8
+	primaryPrec = 9
+)
+
+var binaryPrecs = map[string]int{
+	"||": 1, "&&": 2, "==": 3, "<": 4, ">": 4,
+	"+": 5, "-": 5, "*": 6, "/": 6, "%": 6,
+}
+
+// formatExpr returns e as C source, parenthesized if it binds looser
+// than prec or was parenthesized in the source
+func formatExpr(e ast.Expression, prec int) string {
+	if needsParens(e, prec) {
+		return "(" + formatOperands(e) + ")"
+	}
+	return formatOperands(e)
+}
+
+// needsParens reports whether formatExpr parenthesizes e below an
+// operator of precedence prec
+func needsParens(e ast.Expression, prec int) bool {
+	switch e := e.(type) {
+	case *ast.BinaryOp:
+		if e.Parenthesized {
+			return true
+		}
+	case *ast.Assignment:
+		if e.Parenthesized {
+			return true
+		}
+	}
+	return exprPrec(e) < prec
+}
+
+// formatPostfixOperand returns e as the array of a subscript or, if call
+// is true, the callee of a call. The parser takes no postfix operator
+// after a number, so a number gets parentheses there, and so does every
+// callee that is not a name
+func formatPostfixOperand(e ast.Expression, call bool) string {
+	text := formatExpr(e, postfixPrec)
+	if needsParens(e, postfixPrec) {
+		return text
+	}
+	switch e.(type) {
+	case *ast.Identifier:
+		return text
+	case *ast.IntLiteral:
+		return "(" + text + ")"
+	}
+	if call {
+		return "(" + text + ")"
+	}
+	return text
+}
+
+func exprPrec(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.Assignment:
+		return assignPrec
+	case *ast.BinaryOp:
+		return binaryPrecs[e.Operator]
+	case *ast.UnaryOp:
+		return unaryPrec
+	case *ast.IndexExpr, *ast.CallExpr:
+		return postfixPrec
+	}
+	return primaryPrec
+}
+
+// formatOperands returns e as C source, its operands parenthesized as
+// they need to be
+func formatOperands(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.Assignment:
+		// The target is parsed as a binary expression
+		return formatExpr(e.Target, 1) + " = " + formatExpr(e.Value, assignPrec)
+	case *ast.BinaryOp:
+		// Binary operators are left-associative
+		prec := binaryPrecs[e.Operator]
+		return formatExpr(e.Left, prec) + " " + e.Operator + " " + formatExpr(e.Right, prec+1)
+	case *ast.UnaryOp:
+		operand := formatExpr(e.Operand, unaryPrec)
+		if e.Operator == "-" && strings.HasPrefix(operand, "-") {
+			// - -x, not --x
+			return e.Operator + " " + operand
+		}
+		return e.Operator + operand
+	case *ast.IndexExpr:
+		return formatPostfixOperand(e.Array, false) + "[" + formatExpr(e.Index, assignPrec) + "]"
+	case *ast.CallExpr:
+		args := []string{}
+		for _, arg := range e.Args {
+			args = append(args, formatExpr(arg, assignPrec))
+		}
+		return formatPostfixOperand(e.Callee, true) + "(" + strings.Join(args, ", ") + ")"
+	}
+	return e.String()
+}
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// FuzzParser checks that parsing any input returns a program or an error
+// other than a recovered panic, and that a program Format writes out
+// parses again
+func FuzzParser(f *testing.F) {
+	for _, seed := range []string{
+		"int main() { return 0; }",
+		"int f(int a, char *b[4]) { if (a < 1) { return -*b[0]; } switch (a) { case 1: break; default: return 2; } return a = a + 1; }",
+		"static int (*cb)(int); __attribute__((noinline, section(\"x\"))) int g(void);",
+		"int f(int n) { while (n > 0) { while (1) { break; } n = n - 1; } return n; }",
+		"int f() {",
+		"int f() { return ((((1)))); }",
+		"int A(){return((0))();}",
+		"int f() { return " + strings.Repeat("(", MaxNesting) + "1" + strings.Repeat(")", MaxNesting) + "; }",
+		"int f() { return 1" + strings.Repeat(" + 1", MaxOperators) + "; }",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		lex := lexer.New(input)
+		program, err := New(lex).ParseProgram()
+		var internal *diag.InternalError
+		if errors.As(err, &internal) {
+			t.Fatalf("%v\n%s", err, internal.Stack)
+		}
+		if err != nil {
+			return
+		}
+		var out bytes.Buffer
+		if err := Format(&out, program, input, lex.Comments()); err != nil {
+			t.Fatalf("formatting: %v", err)
+		}
+		if _, err := New(lexer.New(out.String())).ParseProgram(); err != nil {
+			t.Fatalf("formatted program does not parse: %v\n%s", err, out.String())
+		}
+	})
+}
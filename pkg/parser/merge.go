@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// File is the program parsed from one source file. ParseFile also
+// records the text of the file and its comments, which Merge does not
+// need
+type File struct {
+	Name     string
+	Program  *ast.Program
+	Source   string
+	Comments []lexer.Comment
+	// Lines is the file in the FileSet WithFileSet gave, or in one of
+	// its own, for the offsets of the positions in Program to resolve
+	Lines *lexer.File
+}
+
+// Merge combines the programs of several files into one, as linking their
+// objects would, recording in each function the file it came from. Its
+// error, an ErrorList, lists every function defined in more than one of the files,
+// declared with different types in two of them, or static in one and
+// declared in another as well, which one module cannot hold
+func Merge(files []File) (*ast.Program, error) {
+	merged := &ast.Program{}
+	// The first declaration and the first definition of each name, and
+	// the first declaration making it static
+	first := map[string]*ast.Function{}
+	defined := map[string]*ast.Function{}
+	static := map[string]*ast.Function{}
+	var problems ErrorList
+	// report records a problem with fn, noting the other declaration it
+	// involves
+	report := func(fn, other *ast.Function, note string, format string, args ...interface{}) {
+		problems = append(problems, &Error{
+			File:  fn.File,
+			Pos:   fn.Pos,
+			End:   fn.Pos,
+			Msg:   fmt.Sprintf(format, args...),
+			Notes: []Note{{File: other.File, Pos: other.Pos, Msg: note}},
+		})
+	}
+	for _, file := range files {
+		for _, fn := range file.Program.Functions {
+			fn.File = file.Name
+			merged.Functions = append(merged.Functions, fn)
+			prev, seen := first[fn.Name]
+			if !seen {
+				first[fn.Name] = fn
+				if fn.Static {
+					static[fn.Name] = fn
+				}
+				if fn.Body != nil {
+					defined[fn.Name] = fn
+				}
+				continue
+			}
+			// Declarations within one file are the code generator's to
+			// check
+			if prev.File == fn.File {
+				if fn.Body != nil && defined[fn.Name] == nil {
+					defined[fn.Name] = fn
+				}
+				continue
+			}
+			if s := static[fn.Name]; s != nil && s.File != fn.File {
+				report(fn, s, "static declaration is here", "%s is static in %s but declared in %s as well, which one module cannot hold", fn.Name, s.File, fn.File)
+				continue
+			}
+			if fn.Static {
+				report(fn, prev, "other declaration is here", "%s is static in %s but declared in %s as well, which one module cannot hold", fn.Name, fn.File, prev.File)
+				static[fn.Name] = fn
+				continue
+			}
+			if !prev.Signature().Equal(fn.Signature()) {
+				report(fn, prev, fmt.Sprintf("previous declaration is here, with type %s", prev.Signature()), "conflicting types for %s: %s", fn.Name, fn.Signature())
+				continue
+			}
+			if def := defined[fn.Name]; def != nil && fn.Body != nil {
+				report(fn, def, "previous definition is here", "redefinition of %s", fn.Name)
+			} else if fn.Body != nil {
+				defined[fn.Name] = fn
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return merged, nil
+}
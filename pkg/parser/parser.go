@@ -0,0 +1,1117 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anouar-bakouch/citadel/internal/suggest"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+type Parser struct {
+	lex     *lexer.Lexer
+	current lexer.Token
+	peek    lexer.Token
+	depth   int            // how deeply the blocks and expressions being parsed nest
+	braces  int            // how many { before current are not closed yet
+	prevEnd lexer.Position // where the token before current ends
+
+	// Tokens read past peek while a Snapshot is held, for Restore to go
+	// back over; next is the index of the one after peek
+	buf   []lexer.Token
+	next  int
+	marks int
+
+	maxErrors int
+	dialect   Dialect
+	ctx       context.Context
+	fset      *lexer.FileSet
+	arena     *ast.Arena
+	static    map[string]bool // whether each function named so far is static
+}
+
+// Dialect is the C standard the parser holds the source to
+type Dialect int
+
+const (
+	// C99 is the default. C11 accepts the same, as the subset has none of
+	// its additions; C89 also rejects // comments and declarations that
+	// follow statements in a block
+	C99 Dialect = iota
+	C89
+	C11
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case C89:
+		return "C89"
+	case C11:
+		return "C11"
+	}
+	return "C99"
+}
+
+// Option configures a Parser
+type Option func(*Parser)
+
+// WithMaxErrors has ParseProgram go on past a function it cannot parse to
+// the next, until it has found n errors, which it returns as an
+// ErrorList; n <= 0 finds them all. The default is 1, stopping at the
+// first
+func WithMaxErrors(n int) Option {
+	return func(p *Parser) { p.maxErrors = n }
+}
+
+// WithContext has ParseProgram stop before the next function once ctx is
+// done, returning its error
+func WithContext(ctx context.Context) Option {
+	return func(p *Parser) { p.ctx = ctx }
+}
+
+// WithFileSet has ParseFile add the file it parses to fset, for the
+// positions of its program to be resolved along with those of others
+func WithFileSet(fset *lexer.FileSet) Option {
+	return func(p *Parser) { p.fset = fset }
+}
+
+// WithArena has the parser allocate the nodes of the program from arena,
+// which Program.Arena then holds, instead of a new Arena of its own; a nil
+// arena allocates each node on its own. Programs parsed into the same
+// arena are freed together, once none of them is reachable
+func WithArena(arena *ast.Arena) Option {
+	return func(p *Parser) { p.arena = arena }
+}
+
+// WithDialect holds the source to the C standard d instead of C99
+func WithDialect(d Dialect) Option {
+	return func(p *Parser) { p.dialect = d }
+}
+
+// MaxNesting is how deeply blocks, parenthesized expressions and operands
+// of unary operators can nest, as clang's default -fbracket-depth. The
+// passes over the tree recurse into it, so deeper input would exhaust
+// their stack rather than fail to parse
+const MaxNesting = 256
+
+// MaxOperators is how many binary operators an expression can chain
+// without parentheses. A chain is a tree as deep as it is long, so it is
+// bounded for the same reason as nesting, though far less tightly
+const MaxOperators = 10000
+
+// enter starts parsing a construct that nests, failing if it is too deep;
+// leave ends it
+func (p *Parser) enter() error {
+	if p.depth >= MaxNesting {
+		err := syntaxError(p.current, "", fmt.Sprintf("blocks and expressions nest more than %d deep", MaxNesting))
+		err.Err = ErrTooDeep
+		return err
+	}
+	p.depth++
+	return nil
+}
+
+func (p *Parser) leave() {
+	p.depth--
+}
+
+func New(lex *lexer.Lexer, opts ...Option) *Parser {
+	p := &Parser{lex: lex, maxErrors: 1, arena: ast.NewArena()}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.advance()
+	p.advance()
+	return p
+}
+
+func (p *Parser) advance() {
+	switch p.current.Type {
+	case lexer.LBRACE:
+		p.braces++
+	case lexer.RBRACE:
+		if p.braces > 0 {
+			p.braces--
+		}
+	}
+	p.prevEnd = p.current.End()
+	p.current = p.peek
+	p.peek = p.nextToken()
+}
+
+// nextToken returns the token after peek, from the buffer if Restore went
+// back over it, keeping it there while a Snapshot may go back to it
+func (p *Parser) nextToken() lexer.Token {
+	if p.next < len(p.buf) {
+		tok := p.buf[p.next]
+		p.next++
+		return tok
+	}
+	if p.marks == 0 {
+		p.buf, p.next = p.buf[:0], 0
+		return p.lex.NextToken()
+	}
+	if p.buf == nil {
+		p.buf = *tokenBufs.Get().(*[]lexer.Token)
+	}
+	tok := p.lex.NextToken()
+	p.buf = append(p.buf, tok)
+	p.next++
+	return tok
+}
+
+// tokenBufs holds the token buffers of parsers done with them, for the
+// parsers of the next files to fill rather than grow their own
+var tokenBufs = sync.Pool{New: func() any { return new([]lexer.Token) }}
+
+// releaseTokens returns the token buffer to tokenBufs once no Snapshot
+// can go back over it
+func (p *Parser) releaseTokens() {
+	if p.buf == nil || p.marks > 0 || p.next < len(p.buf) {
+		return
+	}
+	buf := p.buf[:0]
+	p.buf, p.next = nil, 0
+	tokenBufs.Put(&buf)
+}
+
+// Snapshot is the state of a Parser at a token, which Restore returns it
+// to
+type Snapshot struct {
+	current, peek lexer.Token
+	next          int
+	depth, braces int
+	prevEnd       lexer.Position
+}
+
+// Snapshot records where the parser is, for it to try parsing one way and
+// go back with Restore to try another if that fails. The tokens read
+// after it are kept until every snapshot taken is restored or released
+func (p *Parser) Snapshot() Snapshot {
+	p.marks++
+	return Snapshot{
+		current: p.current,
+		peek:    p.peek,
+		next:    p.next,
+		depth:   p.depth,
+		braces:  p.braces,
+		prevEnd: p.prevEnd,
+	}
+}
+
+// Restore returns the parser to s, reading again the tokens it parsed
+// since, and releases s
+func (p *Parser) Restore(s Snapshot) {
+	p.current, p.peek, p.next = s.current, s.peek, s.next
+	p.depth, p.braces, p.prevEnd = s.depth, s.braces, s.prevEnd
+	p.Release(s)
+}
+
+// Release gives up s, leaving the parser where it is, once the parse it
+// guarded succeeded
+func (p *Parser) Release(s Snapshot) {
+	if p.marks > 0 {
+		p.marks--
+	}
+}
+
+// synchronize skips past the end of the function the parser stopped in,
+// the next ; or } outside braces, for it to go on with the next one
+func (p *Parser) synchronize() {
+	p.depth = 0
+	for p.current.Type != lexer.EOF {
+		end := p.current.Type == lexer.SEMICOLON || p.current.Type == lexer.RBRACE
+		p.advance()
+		if end && p.braces == 0 {
+			return
+		}
+	}
+}
+
+func (p *Parser) expect(tokenType lexer.TokenType) error {
+	if p.current.Type != tokenType {
+		err := syntaxError(p.current, tokenType.String(), fmt.Sprintf("expected %s, got %s", tokenType, p.current.Type))
+		err.After = p.prevEnd
+		return err
+	}
+	p.advance()
+	return nil
+}
+
+// errorf returns a SyntaxError at the current token with the message
+// format gives
+func (p *Parser) errorf(format string, args ...interface{}) error {
+	return syntaxError(p.current, "", fmt.Sprintf(format, args...))
+}
+
+// expected returns a SyntaxError at the current token, where the parser
+// wanted what, with the message format gives
+func (p *Parser) expected(what, format string, args ...interface{}) error {
+	return syntaxError(p.current, what, fmt.Sprintf(format, args...))
+}
+
+// Parse the entire program
+func (p *Parser) ParseProgram() (_ *ast.Program, err error) {
+	defer diag.Recover("parser", &err)
+	defer p.releaseTokens()
+	program := &ast.Program{Arena: p.arena}
+
+	var errs ErrorList
+	for p.current.Type != lexer.EOF {
+		if p.ctx != nil && p.ctx.Err() != nil {
+			return nil, p.ctx.Err()
+		}
+		fn, err := p.parseFunction()
+		if err != nil {
+			// Parsing stops at the token it could not make sense of,
+			// unless it is to find more errors
+			errs = append(errs, errorAt(p.current, err))
+			if len(errs) == p.maxErrors {
+				break
+			}
+			p.synchronize()
+			continue
+		}
+		p.linkage(fn)
+		program.Functions = append(program.Functions, fn)
+	}
+	if p.dialect == C89 {
+		errs = append(errs, p.lineComments()...)
+		sort.SliceStable(errs, func(i, j int) bool {
+			a, b := errs[i].Pos, errs[j].Pos
+			return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+		})
+	}
+	if p.maxErrors > 0 && len(errs) > p.maxErrors {
+		errs = errs[:p.maxErrors]
+	}
+
+	switch len(errs) {
+	case 0:
+		return program, nil
+	case 1:
+		return nil, errs[0]
+	}
+	return nil, errs
+}
+
+// Next parses the next function of the source, definition or prototype,
+// and returns io.EOF after the last. It allocates each node on its own
+// rather than from an Arena, for a caller compiling a large file a
+// function at a time to hold only the function it is at, and what it
+// keeps of those before, such as their signatures, and not the chunks
+// they were allocated from. Next stops at the first error, as it has no
+// program to report more about
+func (p *Parser) Next() (_ *ast.Function, err error) {
+	defer diag.Recover("parser", &err)
+	if p.ctx != nil && p.ctx.Err() != nil {
+		return nil, p.ctx.Err()
+	}
+	if p.current.Type == lexer.EOF {
+		p.releaseTokens()
+		if p.dialect == C89 {
+			if errs := p.lineComments(); len(errs) > 0 {
+				return nil, errs[0]
+			}
+		}
+		return nil, io.EOF
+	}
+	p.arena = nil
+	fn, err := p.parseFunction()
+	if err != nil {
+		return nil, errorAt(p.current, err)
+	}
+	p.linkage(fn)
+	return fn, nil
+}
+
+// linkage gives fn the internal linkage of an earlier static declaration
+// of the same function, which later ones keep
+func (p *Parser) linkage(fn *ast.Function) {
+	if p.static == nil {
+		p.static = map[string]bool{}
+	}
+	if p.static[fn.Name] {
+		fn.Static = true
+	}
+	p.static[fn.Name] = fn.Static
+}
+
+// lineComments returns an error for each // comment, which C89 does not
+// allow
+func (p *Parser) lineComments() ErrorList {
+	var errs ErrorList
+	for _, c := range p.lex.Comments() {
+		if strings.HasPrefix(c.Text, "//") {
+			end := c.Pos
+			end.Column += len(c.Text)
+			end.Offset += len(c.Text)
+			errs = append(errs, &Error{Pos: c.Pos, End: end, Msg: "// comments are not allowed in C89"})
+		}
+	}
+	return errs
+}
+
+// ParseFile parses the C source read from r, or from the file name in
+// fsys if r is nil, or from the file name on disk if fsys is nil too. The
+// errors of parsing name the file, and the File holds its source,
+// comments and lines all the same, for the errors to quote; those of reading it are
+// returned as they are, with no File
+func ParseFile(fsys fs.FS, name string, r io.Reader, opts ...Option) (*File, error) {
+	var data []byte
+	var err error
+	switch {
+	case r != nil:
+		data, err = io.ReadAll(r)
+	case fsys != nil:
+		data, err = fs.ReadFile(fsys, name)
+	default:
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file := &File{Name: name, Source: string(data)}
+	lex := lexer.New(file.Source)
+	p := New(lex, opts...)
+	fset := p.fset
+	if fset == nil {
+		fset = &lexer.FileSet{}
+	}
+	file.Lines = fset.AddFile(name, file.Source)
+	file.Program, err = p.ParseProgram()
+	file.Comments = lex.Comments()
+	switch err := err.(type) {
+	case *Error:
+		err.File = name
+	case ErrorList:
+		for _, e := range err {
+			e.File = name
+		}
+	}
+	return file, err
+}
+
+// Parse a function
+func (p *Parser) parseFunction() (*ast.Function, error) {
+	fn := p.arena.Function(ast.Function{Pos: p.current.Pos})
+
+	// Leading attributes and storage class, in any order
+	for {
+		if p.current.Type == lexer.IDENTIFIER && p.current.Literal == "static" {
+			fn.Static = true
+			p.advance()
+			continue
+		}
+		attrs, err := p.parseAttributes()
+		if err != nil {
+			return nil, err
+		}
+		if len(attrs) == 0 {
+			break
+		}
+		fn.Attributes = append(fn.Attributes, attrs...)
+	}
+
+	// Return type
+	if !isTypeKeyword(p.current.Type) {
+		return nil, p.expected("return type", "expected return type, got %s", p.current.Literal)
+	}
+	fn.ReturnType = p.parseType()
+
+	// Function name
+	if p.current.Type != lexer.IDENTIFIER {
+		return nil, p.expected("function name", "expected function name")
+	}
+	fn.Name, fn.NamePos = p.current.Literal, p.current.Pos
+	p.advance()
+
+	// Parameters
+	params, err := p.parseParameterList()
+	if err != nil {
+		return nil, err
+	}
+	fn.Params = params
+
+	// Prototype without a body
+	if p.current.Type == lexer.SEMICOLON {
+		p.advance()
+		return fn, nil
+	}
+
+	for i, param := range fn.Params {
+		if param.Name == "" {
+			return nil, p.errorf("parameter %d of %s has no name", i+1, fn.Name)
+		}
+	}
+
+	// Body
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	fn.Body = body
+
+	return fn, nil
+}
+
+// isTypeKeyword reports whether tok names a basic type
+func isTypeKeyword(tok lexer.TokenType) bool {
+	switch tok {
+	case lexer.INT, lexer.CHAR, lexer.SHORT, lexer.LONG, lexer.FLOAT, lexer.DOUBLE:
+		return true
+	}
+	return false
+}
+
+// Parse a base type followed by any pointer stars
+func (p *Parser) parseType() *ast.Type {
+	typ := ast.BasicTypes[p.current.Literal]
+	p.advance()
+	// long int and short int name the same types as long and short
+	if (typ == ast.Long || typ == ast.Short) && p.current.Type == lexer.INT {
+		p.advance()
+	}
+	for p.current.Type == lexer.STAR {
+		typ = ast.PointerTo(typ)
+		p.advance()
+	}
+	return typ
+}
+
+// Parse a declarator following a base type: either a plain (optionally
+// omitted) name or a function pointer such as (*cb)(int, int). It returns
+// the name, where the name is, and the declared type
+func (p *Parser) parseDeclarator(base *ast.Type) (string, lexer.Position, *ast.Type, error) {
+	if p.current.Type == lexer.IDENTIFIER {
+		name, pos := p.current.Literal, p.current.Pos
+		p.advance()
+		typ, err := p.parseArraySuffix(base)
+		return name, pos, typ, err
+	}
+	if p.current.Type != lexer.LPAREN {
+		return "", lexer.Position{}, base, nil
+	}
+
+	p.advance() // consume (
+	if err := p.expect(lexer.STAR); err != nil {
+		return "", lexer.Position{}, nil, err
+	}
+	name, pos := "", lexer.Position{}
+	if p.current.Type == lexer.IDENTIFIER {
+		name, pos = p.current.Literal, p.current.Pos
+		p.advance()
+	}
+	if err := p.expect(lexer.RPAREN); err != nil {
+		return "", lexer.Position{}, nil, err
+	}
+
+	params, err := p.parseParameterList()
+	if err != nil {
+		return "", lexer.Position{}, nil, err
+	}
+	fnType := &ast.Type{Kind: ast.FuncType, Elem: base}
+	for _, param := range params {
+		fnType.Params = append(fnType.Params, param.Type)
+	}
+	return name, pos, ast.PointerTo(fnType), nil
+}
+
+// Parse any [N] array dimensions following a declarator name
+func (p *Parser) parseArraySuffix(elem *ast.Type) (*ast.Type, error) {
+	dims := []int{}
+	for p.current.Type == lexer.LBRACKET {
+		p.advance()
+		if p.current.Type != lexer.NUMBER {
+			return nil, p.expected("array size", "expected array size, got %s", p.current.Literal)
+		}
+		n, _ := strconv.Atoi(p.current.Literal)
+		if n <= 0 {
+			return nil, p.errorf("array size must be positive, got %d", n)
+		}
+		dims = append(dims, n)
+		p.advance()
+		if err := p.expect(lexer.RBRACKET); err != nil {
+			return nil, err
+		}
+	}
+
+	// int m[2][3] is an array of 2 arrays of 3 ints
+	typ := elem
+	for i := len(dims) - 1; i >= 0; i-- {
+		typ = ast.ArrayOf(typ, dims[i])
+	}
+	return typ, nil
+}
+
+// Parse a parenthesized, comma-separated parameter list
+func (p *Parser) parseParameterList() ([]*ast.Parameter, error) {
+	if err := p.expect(lexer.LPAREN); err != nil {
+		return nil, err
+	}
+
+	params := []*ast.Parameter{}
+	for p.current.Type != lexer.RPAREN {
+		if !isTypeKeyword(p.current.Type) {
+			return nil, p.expected("parameter type", "expected parameter type, got %s", p.current.Literal)
+		}
+		name, pos, typ, err := p.parseDeclarator(p.parseType())
+		if err != nil {
+			return nil, err
+		}
+		// Array parameters are adjusted to pointers
+		params = append(params, p.arena.Parameter(ast.Parameter{Type: typ.Decay(), Name: name, Pos: pos, Written: typ}))
+
+		if p.current.Type == lexer.COMMA {
+			p.advance()
+		} else if p.current.Type != lexer.RPAREN {
+			return nil, p.expected("COMMA or RPAREN", "expected , or ) in parameter list, got %s", p.current.Literal)
+		}
+	}
+	p.advance() // consume )
+
+	return params, nil
+}
+
+// Parse __attribute__((...)) lists preceding a declaration
+func (p *Parser) parseAttributes() ([]*ast.Attribute, error) {
+	attrs := []*ast.Attribute{}
+	for p.current.Type == lexer.IDENTIFIER && p.current.Literal == "__attribute__" {
+		p.advance()
+		if err := p.expect(lexer.LPAREN); err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.LPAREN); err != nil {
+			return nil, err
+		}
+
+		for p.current.Type != lexer.RPAREN {
+			if p.current.Type != lexer.IDENTIFIER {
+				return nil, p.expected("attribute name", "expected attribute name, got %s", p.current.Literal)
+			}
+			attr := p.arena.Attribute(ast.Attribute{Name: p.current.Literal})
+			p.advance()
+
+			if p.current.Type == lexer.LPAREN {
+				p.advance()
+				for p.current.Type != lexer.RPAREN {
+					switch p.current.Type {
+					case lexer.STRING, lexer.IDENTIFIER, lexer.NUMBER:
+						attr.Args = append(attr.Args, p.current.Literal)
+						attr.StringArgs = append(attr.StringArgs, p.current.Type == lexer.STRING)
+						p.advance()
+					default:
+						return nil, p.errorf("unexpected token in attribute %s: %s", attr.Name, p.current.Literal)
+					}
+					if p.current.Type == lexer.COMMA {
+						p.advance()
+					}
+				}
+				p.advance() // consume )
+			}
+			attrs = append(attrs, attr)
+
+			if p.current.Type == lexer.COMMA {
+				p.advance()
+			} else if p.current.Type != lexer.RPAREN {
+				return nil, p.expected("COMMA or RPAREN", "expected , or ) in attribute list, got %s", p.current.Literal)
+			}
+		}
+
+		if err := p.expect(lexer.RPAREN); err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.RPAREN); err != nil {
+			return nil, err
+		}
+	}
+	return attrs, nil
+}
+
+// Parse a block
+func (p *Parser) parseBlock() (*ast.Block, error) {
+	block := p.arena.Block(ast.Block{Pos: p.current.Pos})
+
+	if err := p.expect(lexer.LBRACE); err != nil {
+		return nil, err
+	}
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	for p.current.Type != lexer.RBRACE && p.current.Type != lexer.EOF {
+		if p.dialect == C89 && isTypeKeyword(p.current.Type) && len(block.Statements) > 0 {
+			if _, ok := block.Statements[len(block.Statements)-1].(*ast.VarDecl); !ok {
+				return nil, p.errorf("declarations must come before statements in C89")
+			}
+		}
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		block.Statements = append(block.Statements, stmt)
+	}
+
+	block.End = p.current.Pos
+	if err := p.expect(lexer.RBRACE); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Parse a statement
+func (p *Parser) parseStatement() (ast.Statement, error) {
+	switch p.current.Type {
+	case lexer.INT, lexer.CHAR, lexer.SHORT, lexer.LONG, lexer.FLOAT, lexer.DOUBLE:
+		return p.parseVarDecl()
+	case lexer.IF:
+		return p.parseIfStatement()
+	case lexer.RETURN:
+		return p.parseReturnStatement()
+	case lexer.SWITCH:
+		return p.parseSwitchStatement()
+	case lexer.WHILE:
+		return p.parseWhileStatement()
+	case lexer.BREAK:
+		stmt := p.arena.BreakStatement(ast.BreakStatement{Pos: p.current.Pos})
+		p.advance()
+		if err := p.expect(lexer.SEMICOLON); err != nil {
+			return nil, err
+		}
+		stmt.End = p.prevEnd
+		return stmt, nil
+	case lexer.IDENTIFIER:
+		if isAsmKeyword(p.current.Literal) {
+			return p.parseAsmStatement()
+		}
+		return p.parseNameStatement()
+	default:
+		return p.parseExprStatement()
+	}
+}
+
+func isAsmKeyword(name string) bool {
+	return name == "asm" || name == "__asm" || name == "__asm__"
+}
+
+// Parse a basic inline assembly statement: __asm__ [volatile] ("..." "...");
+func (p *Parser) parseAsmStatement() (*ast.AsmStatement, error) {
+	stmt := p.arena.AsmStatement(ast.AsmStatement{Pos: p.current.Pos})
+	p.advance() // consume __asm__
+	// Basic asm is always volatile, so the qualifier changes nothing
+	if p.current.Type == lexer.IDENTIFIER && (p.current.Literal == "volatile" || p.current.Literal == "__volatile__") {
+		p.advance()
+	}
+	if err := p.expect(lexer.LPAREN); err != nil {
+		return nil, err
+	}
+	if p.current.Type != lexer.STRING {
+		return nil, p.expected("assembly string", "expected assembly string, got %s", p.current.Literal)
+	}
+	// Adjacent string literals are concatenated
+	for p.current.Type == lexer.STRING {
+		stmt.Template += p.current.Literal
+		p.advance()
+	}
+	if p.current.Type != lexer.RPAREN {
+		return nil, p.expected("RPAREN", "only basic inline assembly is supported, got %s after the assembly string", p.current.Literal)
+	}
+	p.advance()
+	if err := p.expect(lexer.SEMICOLON); err != nil {
+		return nil, err
+	}
+	stmt.End = p.prevEnd
+	return stmt, nil
+}
+
+// Parse an expression statement such as a call
+func (p *Parser) parseExprStatement() (*ast.ExprStatement, error) {
+	pos := p.current.Pos
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.SEMICOLON); err != nil {
+		return nil, err
+	}
+	return p.arena.ExprStatement(ast.ExprStatement{Pos: pos, Expr: expr, End: p.prevEnd}), nil
+}
+
+// keywords are those of C, which a statement that starts with a name
+// that cannot be one of an expression may have meant
+var keywords = []string{
+	"auto", "break", "case", "char", "const", "continue", "default", "do",
+	"double", "else", "enum", "extern", "float", "for", "goto", "if", "int",
+	"long", "register", "return", "short", "signed", "sizeof", "static",
+	"struct", "switch", "typedef", "union", "unsigned", "void", "volatile",
+	"while",
+}
+
+// parseNameStatement parses a statement that starts with a name: most
+// often an expression such as f(x); or a * b;, but when that fails,
+// possibly a misspelt keyword, as in retrun x; or whlie (x) {, or the
+// declaration of a variable of a type the subset does not have, such as
+// size_t n; or FILE *f;. It tries the expression and, if that fails, goes
+// back to tell which
+func (p *Parser) parseNameStatement() (ast.Statement, error) {
+	start := p.Snapshot()
+	stmt, err := p.parseExprStatement()
+	if err == nil {
+		p.Release(start)
+		return stmt, nil
+	}
+
+	failed := p.Snapshot()
+	p.Restore(start)
+	name := p.current
+	p.advance()
+
+	// A keyword is followed by what an expression cannot go on with,
+	// either straight after it or after the parentheses of if (...)
+	after := p.Snapshot()
+	if p.current.Type == lexer.LPAREN {
+		for open := 0; p.current.Type != lexer.EOF; {
+			if p.current.Type == lexer.LPAREN {
+				open++
+			} else if p.current.Type == lexer.RPAREN {
+				open--
+			}
+			p.advance()
+			if open == 0 {
+				break
+			}
+		}
+	}
+	if p.current.Pos == failed.current.Pos || after.current.Pos == failed.current.Pos {
+		if keyword := suggest.Closest(name.Literal, keywords); keyword != "" {
+			p.Release(after)
+			p.Restore(failed)
+			return nil, syntaxError(name, "", fmt.Sprintf("unknown name %s; did you mean %s?", name.Literal, keyword))
+		}
+	}
+	p.Restore(after)
+
+	for p.current.Type == lexer.STAR {
+		p.advance()
+	}
+	if p.current.Type == lexer.IDENTIFIER {
+		p.Release(failed)
+		return nil, syntaxError(name, "type", fmt.Sprintf("unknown type name %s; the types are char, short, int, long, float and double", name.Literal))
+	}
+	p.Restore(failed)
+	return nil, err
+}
+
+// Parse variable declaration
+func (p *Parser) parseVarDecl() (*ast.VarDecl, error) {
+	decl := p.arena.VarDecl(ast.VarDecl{Pos: p.current.Pos})
+	name, pos, typ, err := p.parseDeclarator(p.parseType())
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, p.expected("identifier", "expected identifier")
+	}
+	decl.Name, decl.NamePos = name, pos
+	decl.Type = typ
+
+	if p.current.Type == lexer.EQUALS {
+		p.advance()
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		decl.Value = expr
+	}
+
+	p.expect(lexer.SEMICOLON)
+	decl.End = p.prevEnd
+	return decl, nil
+}
+
+// Parse if statement
+func (p *Parser) parseIfStatement() (*ast.IfStatement, error) {
+	stmt := p.arena.IfStatement(ast.IfStatement{Pos: p.current.Pos})
+	p.advance() // consume 'if'
+
+	p.expect(lexer.LPAREN)
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Condition = condition
+	p.expect(lexer.RPAREN)
+
+	thenBlock, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	stmt.ThenBlock = thenBlock
+
+	return stmt, nil
+}
+
+// Parse while statement
+func (p *Parser) parseWhileStatement() (*ast.WhileStatement, error) {
+	stmt := p.arena.WhileStatement(ast.WhileStatement{Pos: p.current.Pos})
+	p.advance() // consume 'while'
+
+	if err := p.expect(lexer.LPAREN); err != nil {
+		return nil, err
+	}
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Condition = condition
+	if err := p.expect(lexer.RPAREN); err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Body = body
+
+	return stmt, nil
+}
+
+// Parse switch statement
+func (p *Parser) parseSwitchStatement() (*ast.SwitchStatement, error) {
+	stmt := p.arena.SwitchStatement(ast.SwitchStatement{Pos: p.current.Pos})
+	p.advance() // consume 'switch'
+
+	if err := p.expect(lexer.LPAREN); err != nil {
+		return nil, err
+	}
+	tag, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Tag = tag
+	if err := p.expect(lexer.RPAREN); err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.LBRACE); err != nil {
+		return nil, err
+	}
+
+	for p.current.Type != lexer.RBRACE {
+		switch p.current.Type {
+		case lexer.CASE, lexer.DEFAULT:
+			c := p.arena.SwitchCase(ast.SwitchCase{Pos: p.current.Pos})
+			isCase := p.current.Type == lexer.CASE
+			p.advance()
+			if isCase {
+				value, err := p.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				c.Value = value
+			}
+			if err := p.expect(lexer.COLON); err != nil {
+				return nil, err
+			}
+			stmt.Cases = append(stmt.Cases, c)
+		case lexer.EOF:
+			return nil, p.expected("RBRACE", "unterminated switch statement")
+		default:
+			if len(stmt.Cases) == 0 {
+				return nil, p.errorf("statement in switch before the first case label")
+			}
+			s, err := p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
+			last := stmt.Cases[len(stmt.Cases)-1]
+			last.Body = append(last.Body, s)
+		}
+	}
+	stmt.End = p.current.Pos
+	p.advance() // consume }
+
+	return stmt, nil
+}
+
+// Parse return statement
+func (p *Parser) parseReturnStatement() (*ast.ReturnStatement, error) {
+	stmt := p.arena.ReturnStatement(ast.ReturnStatement{Pos: p.current.Pos})
+	p.advance() // consume 'return'
+
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Value = expr
+
+	p.expect(lexer.SEMICOLON)
+	stmt.End = p.prevEnd
+	return stmt, nil
+}
+
+// Binary operator precedences; higher binds tighter
+var precedences = map[lexer.TokenType]int{
+	lexer.OR_OR:       1,
+	lexer.AND_AND:     2,
+	lexer.EQUAL_EQUAL: 3,
+	lexer.GREATER:     4,
+	lexer.LESS:        4,
+	lexer.PLUS:        5,
+	lexer.MINUS:       5,
+	lexer.STAR:        6,
+	lexer.SLASH:       6,
+	lexer.PERCENT:     6,
+}
+
+// Parse expression
+func (p *Parser) parseExpression() (ast.Expression, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	left, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assignment is right-associative and binds loosest
+	if p.current.Type == lexer.EQUALS {
+		p.advance()
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return p.arena.Assignment(ast.Assignment{Target: left, Value: value}), nil
+	}
+
+	return left, nil
+}
+
+// Parse left-associative binary operators binding at least as tightly as
+// minPrec (precedence climbing)
+func (p *Parser) parseBinary(minPrec int) (ast.Expression, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for n := 0; ; n++ {
+		prec, ok := precedences[p.current.Type]
+		if !ok || prec < minPrec {
+			return left, nil
+		}
+		if n == MaxOperators {
+			err := syntaxError(p.current, "", fmt.Sprintf("expression chains more than %d operators", MaxOperators))
+			err.Err = ErrTooLong
+			return nil, err
+		}
+		op := p.current.Literal
+		p.advance()
+
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = p.arena.BinaryOp(ast.BinaryOp{Left: left, Operator: op, Right: right})
+	}
+}
+
+// Parse primary expression
+func (p *Parser) parsePrimary() (ast.Expression, error) {
+	var expr ast.Expression
+	start := p.current.Pos
+	switch p.current.Type {
+	case lexer.IDENTIFIER:
+		expr = p.arena.Identifier(ast.Identifier{Pos: start, Name: p.current.Literal})
+		p.advance()
+	case lexer.NUMBER:
+		val, _ := strconv.Atoi(p.current.Literal)
+		p.advance()
+		return p.arena.IntLiteral(ast.IntLiteral{Value: val}), nil
+	case lexer.STRING:
+		lit := p.arena.StringLiteral(ast.StringLiteral{Pos: start})
+		for p.current.Type == lexer.STRING {
+			lit.Value += p.current.Literal
+			p.advance()
+		}
+		lit.End = p.prevEnd
+		expr = lit
+	case lexer.STAR, lexer.MINUS:
+		op := p.current.Literal
+		p.advance()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		operand, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return p.arena.UnaryOp(ast.UnaryOp{Operator: op, Operand: operand}), nil
+	case lexer.LPAREN:
+		p.advance()
+		inner, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.RPAREN); err != nil {
+			return nil, err
+		}
+		switch e := inner.(type) {
+		case *ast.BinaryOp:
+			e.Parenthesized = true
+		case *ast.Assignment:
+			e.Parenthesized = true
+		}
+		expr = inner
+	default:
+		return nil, p.errorf("unexpected token in expression: %s", p.current.Literal)
+	}
+
+	// Calls and subscripts
+	for p.current.Type == lexer.LPAREN || p.current.Type == lexer.LBRACKET {
+		if p.current.Type == lexer.LBRACKET {
+			p.advance()
+			index, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(lexer.RBRACKET); err != nil {
+				return nil, err
+			}
+			expr = p.arena.IndexExpr(ast.IndexExpr{Array: expr, Index: index})
+			continue
+		}
+
+		p.advance()
+		call := p.arena.CallExpr(ast.CallExpr{Pos: start, Callee: expr})
+		for p.current.Type != lexer.RPAREN {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, arg)
+			if p.current.Type == lexer.COMMA {
+				p.advance()
+			} else if p.current.Type != lexer.RPAREN {
+				return nil, p.expected("COMMA or RPAREN", "expected , or ) in call to %s, got %s", call.Callee, p.current.Literal)
+			}
+		}
+		p.advance() // consume )
+		call.End = p.prevEnd
+		expr = call
+	}
+
+	return expr, nil
+}
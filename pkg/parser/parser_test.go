@@ -0,0 +1,199 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// TestContext checks that ParseProgram and Next stop with the error of a
+// context that is done, and parse as usual with one that is not
+func TestContext(t *testing.T) {
+	const src = "int f() { return 1; }\nint main() { return f(); }\n"
+	ctx, cancel := context.WithCancel(context.Background())
+	if program, err := New(lexer.New(src), WithContext(ctx)).ParseProgram(); err != nil || len(program.Functions) != 2 {
+		t.Fatalf("before cancel: got %v, %v", program, err)
+	}
+
+	p := New(lexer.New(src), WithContext(ctx))
+	if fn, err := p.Next(); err != nil || fn.Name != "f" {
+		t.Fatalf("Next before cancel: got %v, %v", fn, err)
+	}
+	cancel()
+	if _, err := p.Next(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Next after cancel: got %v, want %v", err, context.Canceled)
+	}
+	if _, err := New(lexer.New(src), WithContext(ctx)).ParseProgram(); !errors.Is(err, context.Canceled) {
+		t.Errorf("ParseProgram after cancel: got %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestDialect checks that C89 rejects // comments and declarations after
+// statements, reporting them in source order among the other errors, and
+// that C99 and C11 accept both
+func TestDialect(t *testing.T) {
+	const src = "int main() {\n    int a = 1; // one\n    a = 2;\n    int b = a;\n    return b;\n}\n"
+	for _, d := range []Dialect{C99, C11} {
+		if _, err := New(lexer.New(src), WithDialect(d)).ParseProgram(); err != nil {
+			t.Errorf("%s: %v", d, err)
+		}
+	}
+
+	_, err := New(lexer.New(src), WithDialect(C89), WithMaxErrors(0)).ParseProgram()
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("C89: got %v, want two errors", err)
+	}
+	for i, want := range []struct {
+		line, column int
+		msg          string
+	}{
+		{2, 16, "// comments are not allowed in C89"},
+		{4, 5, "declarations must come before statements in C89"},
+	} {
+		if errs[i].Pos.Line != want.line || errs[i].Pos.Column != want.column || errs[i].Msg != want.msg {
+			t.Errorf("error %d: %s: %s, want %d:%d: %s", i, errs[i].Pos, errs[i].Msg, want.line, want.column, want.msg)
+		}
+	}
+	if end := errs[0].End; end.Column != 16+len("// one") {
+		t.Errorf("the comment's error ends at column %d, want %d", end.Column, 16+len("// one"))
+	}
+
+	// Next finds the comments once it has read the whole source
+	p := New(lexer.New("int f() { return 0; } // f\n"), WithDialect(C89))
+	if _, err := p.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Next(); err == nil || !strings.Contains(err.Error(), "// comments are not allowed in C89") {
+		t.Errorf("Next at the end: got %v, want the comment rejected", err)
+	}
+
+	if C89.String() != "C89" || C99.String() != "C99" || C11.String() != "C11" {
+		t.Errorf("dialects are named %s, %s and %s", C89, C99, C11)
+	}
+}
+
+// TestParseFile checks that ParseFile reads from a reader, a file system
+// or the disk, names the file in the errors it finds while keeping its
+// source, comments and lines, and returns read errors as they are
+func TestParseFile(t *testing.T) {
+	const src = "// entry\nint main() { return 0; }\n"
+	fsys := fstest.MapFS{
+		"a.c":      {Data: []byte(src)},
+		"broken.c": {Data: []byte("int main() {\n    return 1 +;\n}\n")},
+	}
+	for _, test := range []struct {
+		name string
+		read func() (*File, error)
+	}{
+		{"reader", func() (*File, error) { return ParseFile(nil, "a.c", strings.NewReader(src)) }},
+		{"fsys", func() (*File, error) { return ParseFile(fsys, "a.c", nil) }},
+	} {
+		file, err := test.read()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if file.Name != "a.c" || file.Source != src || len(file.Program.Functions) != 1 || len(file.Comments) != 1 || file.Lines.LineCount() != 3 {
+			t.Errorf("%s: got %+v", test.name, file)
+		}
+	}
+
+	fset := &lexer.FileSet{}
+	file, err := ParseFile(fsys, "broken.c", nil, WithFileSet(fset))
+	var perr *Error
+	if !errors.As(err, &perr) || perr.File != "broken.c" || perr.Pos.Line != 2 {
+		t.Fatalf("got %v, want an error on line 2 of broken.c", err)
+	}
+	if !strings.HasPrefix(err.Error(), "broken.c:2:") {
+		t.Errorf("the error does not name the file: %v", err)
+	}
+	if file == nil || file.Program != nil || file.Lines.Line(2) != "    return 1 +;" || fset.Lookup("broken.c") != file.Lines {
+		t.Errorf("got %+v, want the source and lines of broken.c, in the file set", file)
+	}
+
+	if file, err := ParseFile(fsys, "missing.c", nil); file != nil || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: got %v, %v", file, err)
+	}
+	if file, err := ParseFile(nil, "r.c", iotestErrReader{}); file != nil || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("failing reader: got %v, %v", file, err)
+	}
+}
+
+// iotestErrReader fails every read
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+// TestSnapshotRestore checks that Restore goes back to the token of a
+// snapshot, nested in another or not, that Release keeps the parser where
+// it is, and that the tokens kept for them are let go once none is held
+func TestSnapshotRestore(t *testing.T) {
+	p := New(lexer.New("a b c d e f g"))
+	literals := func() string { return p.current.Literal + p.peek.Literal }
+
+	outer := p.Snapshot()
+	p.advance()
+	inner := p.Snapshot()
+	p.advance()
+	p.advance()
+	if got := literals(); got != "de" {
+		t.Fatalf("at %s, want de", got)
+	}
+	p.Restore(inner)
+	if got := literals(); got != "bc" {
+		t.Errorf("restored to %s, want bc", got)
+	}
+	p.advance()
+	p.advance()
+	p.advance()
+	if got := literals(); got != "ef" {
+		t.Errorf("read on to %s, want ef", got)
+	}
+	p.Restore(outer)
+	if got := literals(); got != "ab" {
+		t.Errorf("restored to %s, want ab", got)
+	}
+	if p.marks != 0 {
+		t.Errorf("%d snapshots held, want none", p.marks)
+	}
+
+	s := p.Snapshot()
+	p.advance()
+	p.Release(s)
+	if got := literals(); got != "bc" {
+		t.Errorf("released at %s, want bc", got)
+	}
+	for p.current.Type != lexer.EOF {
+		p.advance()
+	}
+	p.releaseTokens()
+	if p.buf != nil {
+		t.Errorf("%d tokens kept with no snapshot held", len(p.buf))
+	}
+}
+
+// TestDidYouMean checks that a misspelt keyword is named with the keyword
+// meant, a name that is not near one as a type the subset lacks, and that
+// other errors in statements that start with a name are left as they are
+func TestDidYouMean(t *testing.T) {
+	for src, want := range map[string]string{
+		"int main() { retrun 0; }":                  "unknown name retrun; did you mean return?",
+		"int main() { whlie (1) { } return 0; }":    "unknown name whlie; did you mean while?",
+		"int main() { size_t n; return 0; }":        "unknown type name size_t",
+		"int main() { uint32_t n; return 0; }":      "unknown type name uint32_t",
+		"int main() { int x = 1; x + ; return 0; }": "unexpected token in expression: ;",
+	} {
+		_, err := New(lexer.New(src)).ParseProgram()
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %s", src, err, want)
+		}
+	}
+}
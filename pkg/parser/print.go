@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// Fprint writes program to w as an indented tree, one node per line with
+// its children below it, giving the source position of the nodes that
+// record one
+func Fprint(w io.Writer, program *ast.Program) error {
+	return programNode(program).print(w, 0)
+}
+
+// FprintJSON writes program to w as a tree of JSON objects, each with the
+// kind of its node, the details Fprint prints and its children in order
+func FprintJSON(w io.Writer, program *ast.Program) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(programNode(program))
+}
+
+// CountNodes returns the number of nodes in program, counting them as
+// Fprint prints them, one per line
+func CountNodes(program *ast.Program) int {
+	return programNode(program).count()
+}
+
+// printNode is a syntax tree node as Fprint and FprintJSON write it
+type printNode struct {
+	Kind     string       `json:"kind"`
+	Name     string       `json:"name,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Operator string       `json:"operator,omitempty"`
+	Value    interface{}  `json:"value,omitempty"`
+	Static   bool         `json:"static,omitempty"`
+	Line     int          `json:"line,omitempty"`
+	Column   int          `json:"column,omitempty"`
+	Children []*printNode `json:"children,omitempty"`
+}
+
+func (n *printNode) print(w io.Writer, depth int) error {
+	fields := []string{n.Kind}
+	if n.Static {
+		fields = append(fields, "static")
+	}
+	for _, field := range []string{n.Name, n.Type, n.Operator} {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	switch v := n.Value.(type) {
+	case nil:
+	case string:
+		// Literals keep their escapes, so they are quoted as written
+		fields = append(fields, "\""+v+"\"")
+	default:
+		fields = append(fields, fmt.Sprint(v))
+	}
+	if n.Line != 0 {
+		fields = append(fields, lexer.Position{Line: n.Line, Column: n.Column}.String())
+	}
+	if _, err := fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", depth), strings.Join(fields, " ")); err != nil {
+		return err
+	}
+	for _, child := range n.Children {
+		if err := child.print(w, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// count returns the number of nodes in the tree below and including n
+func (n *printNode) count() int {
+	total := 1
+	for _, child := range n.Children {
+		total += child.count()
+	}
+	return total
+}
+
+// at sets the position of the node
+func (n *printNode) at(pos lexer.Position) *printNode {
+	n.Line, n.Column = pos.Line, pos.Column
+	return n
+}
+
+// add appends the nodes that are not nil to the children of the node
+func (n *printNode) add(children ...*printNode) *printNode {
+	for _, child := range children {
+		if child != nil {
+			n.Children = append(n.Children, child)
+		}
+	}
+	return n
+}
+
+func programNode(program *ast.Program) *printNode {
+	node := &printNode{Kind: "Program"}
+	for _, fn := range program.Functions {
+		node.add(functionNode(fn))
+	}
+	return node
+}
+
+func functionNode(fn *ast.Function) *printNode {
+	node := &printNode{Kind: "Function", Name: fn.Name, Type: fn.Signature().String(), Static: fn.Static}
+	for _, attr := range fn.Attributes {
+		attrNode := &printNode{Kind: "Attribute", Name: attr.Name}
+		if len(attr.Args) > 0 {
+			attrNode.Value = strings.Join(attr.Args, ", ")
+		}
+		node.add(attrNode)
+	}
+	for _, param := range fn.Params {
+		node.add(&printNode{Kind: "Parameter", Name: param.Name, Type: param.Type.String()})
+	}
+	if fn.Body != nil {
+		node.add(statementNode(fn.Body))
+	}
+	return node
+}
+
+func statementNode(stmt ast.Statement) *printNode {
+	switch s := stmt.(type) {
+	case *ast.Block:
+		node := (&printNode{Kind: "Block"}).at(s.Pos)
+		for _, stmt := range s.Statements {
+			node.add(statementNode(stmt))
+		}
+		return node
+	case *ast.VarDecl:
+		return (&printNode{Kind: "VarDecl", Name: s.Name, Type: s.Type.String()}).at(s.Pos).add(expressionNode(s.Value))
+	case *ast.IfStatement:
+		node := (&printNode{Kind: "IfStatement"}).at(s.Pos).add(expressionNode(s.Condition))
+		if s.ThenBlock != nil {
+			node.add(statementNode(s.ThenBlock))
+		}
+		if s.ElseBlock != nil {
+			node.add((&printNode{Kind: "Else"}).add(statementNode(s.ElseBlock)))
+		}
+		return node
+	case *ast.SwitchStatement:
+		node := (&printNode{Kind: "SwitchStatement"}).at(s.Pos).add(expressionNode(s.Tag))
+		for _, cs := range s.Cases {
+			label := (&printNode{Kind: "Case"}).at(cs.Pos).add(expressionNode(cs.Value))
+			if cs.Value == nil {
+				label.Kind = "Default"
+			}
+			for _, stmt := range cs.Body {
+				label.add(statementNode(stmt))
+			}
+			node.add(label)
+		}
+		return node
+	case *ast.WhileStatement:
+		return (&printNode{Kind: "WhileStatement"}).at(s.Pos).add(expressionNode(s.Condition), statementNode(s.Body))
+	case *ast.BreakStatement:
+		return (&printNode{Kind: "BreakStatement"}).at(s.Pos)
+	case *ast.ReturnStatement:
+		return (&printNode{Kind: "ReturnStatement"}).at(s.Pos).add(expressionNode(s.Value))
+	case *ast.ExprStatement:
+		return (&printNode{Kind: "ExprStatement"}).at(s.Pos).add(expressionNode(s.Expr))
+	case *ast.AsmStatement:
+		return (&printNode{Kind: "AsmStatement", Value: s.Template}).at(s.Pos)
+	}
+	return &printNode{Kind: stmt.String()}
+}
+
+func expressionNode(expr ast.Expression) *printNode {
+	switch e := expr.(type) {
+	case nil:
+		return nil
+	case *ast.Identifier:
+		return &printNode{Kind: "Identifier", Name: e.Name}
+	case *ast.IntLiteral:
+		return &printNode{Kind: "IntLiteral", Value: e.Value}
+	case *ast.StringLiteral:
+		return (&printNode{Kind: "StringLiteral", Value: e.Value}).at(e.Pos)
+	case *ast.BinaryOp:
+		return (&printNode{Kind: "BinaryOp", Operator: e.Operator}).add(expressionNode(e.Left), expressionNode(e.Right))
+	case *ast.UnaryOp:
+		return (&printNode{Kind: "UnaryOp", Operator: e.Operator}).add(expressionNode(e.Operand))
+	case *ast.IndexExpr:
+		return (&printNode{Kind: "IndexExpr"}).add(expressionNode(e.Array), expressionNode(e.Index))
+	case *ast.Assignment:
+		return (&printNode{Kind: "Assignment"}).add(expressionNode(e.Target), expressionNode(e.Value))
+	case *ast.CallExpr:
+		node := (&printNode{Kind: "CallExpr"}).at(e.Pos).add(expressionNode(e.Callee))
+		for _, arg := range e.Args {
+			node.add(expressionNode(arg))
+		}
+		return node
+	}
+	return &printNode{Kind: expr.String()}
+}
@@ -0,0 +1,129 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// htmlFile is a file as the HTML template shows it
+type htmlFile struct {
+	Path   string
+	Groups []htmlGroup
+}
+
+// htmlGroup is the findings of one severity in a file
+type htmlGroup struct {
+	Severity analysis.Severity
+	Findings []htmlFinding
+}
+
+// htmlFinding is a finding with what the HTML template shows beside it
+type htmlFinding struct {
+	analysis.Finding
+	CWERef  string // e.g. "CWE-476: NULL Pointer Dereference"
+	CWEURL  string
+	Excerpt []Line
+}
+
+// HTML writes a standalone page of the findings in files, grouped by file
+// and then by severity, each with the source around it. Suppressed
+// findings are only counted
+func HTML(w io.Writer, files []File) error {
+	counts, _ := count(files)
+	data := struct {
+		Version    string
+		Files      []htmlFile
+		Counts     Counts
+		Summary    string
+		Severities []analysis.Severity
+	}{
+		Version: codegen.Version,
+		Counts:  counts,
+		Summary: summary(files),
+	}
+	for s := analysis.Critical; s >= analysis.Info; s-- {
+		data.Severities = append(data.Severities, s)
+	}
+	for _, f := range files {
+		file := htmlFile{Path: f.Path}
+		for _, finding := range bySeverity(f.Findings) {
+			if n := len(file.Groups); n == 0 || file.Groups[n-1].Severity != finding.Severity {
+				file.Groups = append(file.Groups, htmlGroup{Severity: finding.Severity})
+			}
+			hf := htmlFinding{Finding: finding, CWERef: cwe(finding), Excerpt: excerpt(f.Source, finding.Pos.Line)}
+			if finding.CWE != 0 {
+				hf.CWEURL = fmt.Sprintf("https://cwe.mitre.org/data/definitions/%d.html", finding.CWE)
+			}
+			group := &file.Groups[len(file.Groups)-1]
+			group.Findings = append(group.Findings, hf)
+		}
+		data.Files = append(data.Files, file)
+	}
+	return htmlTemplate.Execute(w, data)
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Citadel security report</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+table.counts td, table.counts th { padding: 0.2em 1em; text-align: left; }
+.finding { border: 1px solid #ddd; border-radius: 4px; margin: 1em 0; padding: 0.5em 1em; }
+.severity { display: inline-block; padding: 0 0.5em; border-radius: 3px; color: #fff; font-size: 0.85em; text-transform: uppercase; }
+.critical { background: #7b1fa2; } .high { background: #c62828; } .medium { background: #ef6c00; }
+.low { background: #1565c0; } .info { background: #607d8b; }
+.rule { font-family: monospace; color: #555; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }
+pre .marked { background: #fff3b0; display: block; }
+.lineno { color: #999; user-select: none; }
+</style>
+</head>
+<body>
+<h1>Citadel security report</h1>
+<p>{{.Summary}}</p>
+<table class="counts">
+<tr><th>Severity</th><th>Findings</th></tr>
+{{- range .Severities}}
+<tr><td><span class="severity {{.}}">{{.}}</span></td><td>{{index $.Counts .}}</td></tr>
+{{- end}}
+</table>
+{{- range .Files}}
+<h2>{{.Path}}</h2>
+{{- if not .Groups}}
+<p>No findings.</p>
+{{- end}}
+{{- $path := .Path}}
+{{- range .Groups}}
+<h3><span class="severity {{.Severity}}">{{.Severity}}</span></h3>
+{{- range .Findings}}
+<div class="finding">
+<p><strong>{{$path}}:{{.Pos}}</strong> in <code>{{.Function}}</code>: {{.Message}} <span class="rule">[{{.Rule}}]</span></p>
+{{- if .CWERef}}
+<p><a href="{{.CWEURL}}">{{.CWERef}}</a></p>
+{{- end}}
+<pre>{{range .Excerpt}}<span{{if .Marked}} class="marked"{{end}}><span class="lineno">{{printf "%5d" .Number}}  </span>{{.Text}}
+</span>{{end}}</pre>
+{{- if .Trace}}
+<ol>
+{{- range .Trace}}
+<li>{{$path}}:{{.Pos}}: {{.Message}}</li>
+{{- end}}
+</ol>
+{{- end}}
+{{- if .Suggestion}}
+<p>Suggestion: {{.Suggestion}}</p>
+{{- end}}
+</div>
+{{- end}}
+{{- end}}
+{{- end}}
+<p><small>Citadel {{.Version}}</small></p>
+</body>
+</html>
+`))
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+)
+
+// Markdown writes a summary of the findings in files: a table of counts by
+// severity, then a table of findings for each file with any
+func Markdown(w io.Writer, files []File) error {
+	out := bufio.NewWriter(w)
+	counts, _ := count(files)
+	fmt.Fprintf(out, "## Citadel security analysis\n\n%s\n\n", summary(files))
+	if counts.Total() == 0 {
+		return out.Flush()
+	}
+
+	fmt.Fprintf(out, "| Severity | Findings |\n|---|---:|\n")
+	for s := analysis.Critical; s >= analysis.Info; s-- {
+		if counts[s] > 0 {
+			fmt.Fprintf(out, "| %s | %d |\n", s, counts[s])
+		}
+	}
+	for _, f := range files {
+		findings := bySeverity(f.Findings)
+		if len(findings) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n### %s\n\n", markdownEscape(f.Path))
+		fmt.Fprintf(out, "| Severity | Line | Rule | Finding |\n|---|---:|---|---|\n")
+		for _, finding := range findings {
+			message := markdownEscape(finding.Message)
+			if ref := cwe(finding); ref != "" {
+				message += " (" + markdownEscape(ref) + ")"
+			}
+			if finding.Suggestion != "" {
+				message += "<br>Suggestion: " + markdownEscape(finding.Suggestion)
+			}
+			fmt.Fprintf(out, "| %s | %d | `%s` | %s |\n", finding.Severity, finding.Pos.Line, finding.Rule, message)
+		}
+	}
+	return out.Flush()
+}
+
+// markdownEscape escapes the characters of s that Markdown tables and
+// inline formatting would interpret
+var markdownEscape = strings.NewReplacer(
+	"\\", "\\\\", "|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`",
+	"[", "\\[", "]", "\\]", "<", "&lt;", ">", "&gt;", "\n", " ",
+).Replace
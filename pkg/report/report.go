@@ -0,0 +1,115 @@
+// Package report renders analysis findings for people: a standalone HTML
+// page with the source around each finding, and a Markdown summary for
+// pull-request comments.
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+)
+
+// File is an analyzed file: its name, its source and what was found in it
+type File struct {
+	Path     string
+	Source   string
+	Findings []analysis.Finding
+}
+
+// excerptContext is how many lines around a finding its excerpt shows
+const excerptContext = 2
+
+// Line is a numbered line of source in an excerpt
+type Line struct {
+	Number int
+	Text   string
+	Marked bool // the line the finding is on
+}
+
+// excerpt returns the lines of source around line
+func excerpt(source string, line int) []Line {
+	lines := strings.Split(source, "\n")
+	var out []Line
+	for n := line - excerptContext; n <= line+excerptContext; n++ {
+		if n >= 1 && n <= len(lines) {
+			out = append(out, Line{n, strings.TrimRight(lines[n-1], "\r"), n == line})
+		}
+	}
+	return out
+}
+
+// Counts is the number of unsuppressed findings at each severity
+type Counts [analysis.Critical + 1]int
+
+// Total returns the number of findings counted
+func (c Counts) Total() int {
+	total := 0
+	for _, n := range c {
+		total += n
+	}
+	return total
+}
+
+// count returns the counts of the unsuppressed findings of files, and the
+// number suppressed
+func count(files []File) (counts Counts, suppressed int) {
+	for _, f := range files {
+		for _, finding := range f.Findings {
+			if finding.Suppressed != nil {
+				suppressed++
+			} else if finding.Severity >= 0 && finding.Severity <= analysis.Critical {
+				counts[finding.Severity]++
+			}
+		}
+	}
+	return counts, suppressed
+}
+
+// bySeverity returns the unsuppressed findings of a file, most severe
+// first and in source order within a severity
+func bySeverity(findings []analysis.Finding) []analysis.Finding {
+	var sorted []analysis.Finding
+	for _, f := range findings {
+		if f.Suppressed == nil {
+			sorted = append(sorted, f)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Severity > sorted[j].Severity
+	})
+	return sorted
+}
+
+// cwe returns the CWE reference of a finding, e.g. "CWE-476: NULL Pointer
+// Dereference", or ""
+func cwe(f analysis.Finding) string {
+	if f.CWE == 0 {
+		return ""
+	}
+	if name := analysis.CWEName(f.CWE); name != "" {
+		return fmt.Sprintf("CWE-%d: %s", f.CWE, name)
+	}
+	return fmt.Sprintf("CWE-%d", f.CWE)
+}
+
+// summary returns a sentence saying how many findings files have
+func summary(files []File) string {
+	counts, suppressed := count(files)
+	text := fmt.Sprintf("%d %s in %d %s", counts.Total(), plural(counts.Total(), "finding", "findings"), len(files), plural(len(files), "file", "files"))
+	if counts.Total() == 0 {
+		text = fmt.Sprintf("No findings in %d %s", len(files), plural(len(files), "file", "files"))
+	}
+	if suppressed > 0 {
+		text += fmt.Sprintf(" (%d suppressed)", suppressed)
+	}
+	return text + "."
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
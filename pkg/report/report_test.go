@@ -0,0 +1,119 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+)
+
+// testFile has a finding of each kind the reports treat differently: one
+// with a CWE, a suggestion and characters Markdown and HTML interpret,
+// a less severe one, and a suppressed one
+var testFile = File{
+	Path:   "a.c",
+	Source: "int main() {\n  char b[8];\n  gets(b);\n  return b[0] | 1;\n}\n",
+	Findings: []analysis.Finding{
+		{Rule: "uninitialized", Severity: analysis.Medium, Function: "main", Pos: lexer.Position{Line: 4, Column: 10},
+			Message: "b[0] | 1 reads <b> before it is set"},
+		{Rule: "dangerous-call", Severity: analysis.Critical, Function: "main", Pos: lexer.Position{Line: 3, Column: 3},
+			Message: "gets cannot limit its input", CWE: 242, Suggestion: "use fgets"},
+		{Rule: "division-by-zero", Severity: analysis.High, Function: "main", Pos: lexer.Position{Line: 4, Column: 3},
+			Message: "suppressed finding", Suppressed: &analysis.Suppression{Line: 4, Rules: []string{"all"}}},
+	},
+}
+
+// TestMarkdown checks the summary, the counts and the table of findings,
+// most severe first, escaped, and without those suppressed
+func TestMarkdown(t *testing.T) {
+	for _, test := range []struct {
+		files []File
+		want  []string
+	}{
+		{[]File{testFile}, []string{
+			"2 findings in 1 file (1 suppressed).",
+			"| critical | 1 |\n| medium | 1 |\n",
+			"### a.c\n",
+			"| critical | 3 | `dangerous-call` | gets cannot limit its input (CWE-242: Use of Inherently Dangerous Function)<br>Suggestion: use fgets |\n" +
+				"| medium | 4 | `uninitialized` | b\\[0\\] \\| 1 reads &lt;b&gt; before it is set |\n",
+		}},
+		{[]File{{Path: "b.c"}, {Path: "c.c"}}, []string{"No findings in 2 files."}},
+	} {
+		var b bytes.Buffer
+		if err := Markdown(&b, test.files); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("Markdown lacks %q:\n%s", want, b.String())
+			}
+		}
+		if strings.Contains(b.String(), "suppressed finding") {
+			t.Errorf("Markdown shows a suppressed finding:\n%s", b.String())
+		}
+	}
+}
+
+// TestHTML checks that the page escapes the findings, links their CWE and
+// marks their line in the excerpt, and leaves out those suppressed
+func TestHTML(t *testing.T) {
+	var b bytes.Buffer
+	if err := HTML(&b, []File{testFile}); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, want := range []string{
+		"<p>2 findings in 1 file (1 suppressed).</p>",
+		"reads &lt;b&gt; before it is set",
+		`<a href="https://cwe.mitre.org/data/definitions/242.html">CWE-242: Use of Inherently Dangerous Function</a>`,
+		`<span class="marked"><span class="lineno">    3  </span>  gets(b);`,
+		"<p>Suggestion: use fgets</p>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML lacks %q:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "suppressed finding") {
+		t.Errorf("HTML shows a suppressed finding:\n%s", page)
+	}
+	if critical, medium := strings.Index(page, "gets cannot"), strings.Index(page, "reads &lt;b&gt;"); critical > medium {
+		t.Errorf("HTML shows the medium finding before the critical one")
+	}
+}
+
+// TestScores checks the points findings, complexity, dangerous calls and
+// disabled instrumentation cost
+func TestScores(t *testing.T) {
+	high := analysis.Finding{Rule: "taint", Severity: analysis.High, Function: "f"}
+	dangerous := analysis.Finding{Rule: "dangerous-call", Severity: analysis.Low, Function: "f"}
+	suppressed := high
+	suppressed.Suppressed = &analysis.Suppression{}
+	measured := func(function string, complexity, calls int) analysis.Metrics {
+		return analysis.Metrics{Function: function, Complexity: complexity, Calls: calls}
+	}
+	both := []Hardening{{"bounds", true}, {"overflow", false}}
+	for _, test := range []struct {
+		metrics   []analysis.Metrics
+		findings  []analysis.Finding
+		hardening []Hardening
+		want      int
+	}{
+		{nil, nil, nil, 100},
+		{[]analysis.Metrics{measured("f", 1, 2)}, nil, nil, 100},
+		{[]analysis.Metrics{measured("f", 1, 2)}, []analysis.Finding{suppressed}, nil, 100},
+		// 100 - 15 for the high finding - 3 for the low one - 20 * 1/2 of
+		// the calls dangerous
+		{[]analysis.Metrics{measured("f", 1, 2)}, []analysis.Finding{high, dangerous}, nil, 72},
+		// Weighted by complexity: (72 * 1 + 100 * 3) / 4
+		{[]analysis.Metrics{measured("f", 1, 2), measured("g", 3, 0)}, []analysis.Finding{high, dangerous}, nil, 93},
+		// 5 over the complexity allowance, and half the instrumentation
+		{[]analysis.Metrics{measured("f", 15, 0)}, nil, both, 95 * 90 / 100},
+		{[]analysis.Metrics{measured("f", 40, 0)}, nil, nil, 100 - complexityCap},
+	} {
+		if got := Scores(test.metrics, test.findings, test.hardening).Score; got != test.want {
+			t.Errorf("%v, %v, %v: score %d, want %d", test.metrics, test.findings, test.hardening, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anouar-bakouch/citadel/pkg/analysis"
+)
+
+// Hardening is a kind of run-time instrumentation and whether the build
+// enables it
+type Hardening struct {
+	Name    string
+	Enabled bool
+}
+
+// FunctionScore is the security score of a function, from 0 to 100, with
+// what it is computed from
+type FunctionScore struct {
+	analysis.Metrics
+	Findings       Counts // unsuppressed, by severity
+	DangerousCalls int
+	Score          int
+}
+
+// Score is the security score of a module: the scores of its functions,
+// their average weighted by complexity, and how much of the available
+// instrumentation the build enables
+type Score struct {
+	Functions []FunctionScore
+	Hardening []Hardening
+	Score     int
+}
+
+// severityCost is how many points a finding of each severity costs its
+// function
+var severityCost = [analysis.Critical + 1]int{analysis.Info: 0, analysis.Low: 3, analysis.Medium: 8, analysis.High: 15, analysis.Critical: 25}
+
+// complexityAllowance is the cyclomatic complexity a function may have
+// before each further branch costs a point, up to complexityCap points
+const (
+	complexityAllowance = 10
+	complexityCap       = 20
+)
+
+// Scores computes the score of each function measured and of the module.
+// A function starts at 100 and loses points for each finding, for
+// complexity beyond complexityAllowance and for the share of its calls
+// that are to dangerous functions, down to 0. The module score is the
+// average of the function scores weighted by complexity, scaled from 80%
+// with no instrumentation enabled to 100% with all of it
+func Scores(metrics []analysis.Metrics, findings []analysis.Finding, hardening []Hardening) Score {
+	score := Score{Functions: []FunctionScore{}, Hardening: hardening}
+	index := map[string]int{}
+	for i, m := range metrics {
+		index[m.Function] = i
+		score.Functions = append(score.Functions, FunctionScore{Metrics: m})
+	}
+	for _, finding := range findings {
+		i, ok := index[finding.Function]
+		if !ok || finding.Suppressed != nil || finding.Severity < 0 || finding.Severity > analysis.Critical {
+			continue
+		}
+		score.Functions[i].Findings[finding.Severity]++
+		if finding.Rule == "dangerous-call" {
+			score.Functions[i].DangerousCalls++
+		}
+	}
+
+	total, weights := 0, 0
+	for i := range score.Functions {
+		f := &score.Functions[i]
+		s := 100
+		for severity, n := range f.Findings {
+			s -= n * severityCost[severity]
+		}
+		if over := f.Complexity - complexityAllowance; over > 0 {
+			s -= minInt(over, complexityCap)
+		}
+		if f.Calls > 0 {
+			s -= 20 * minInt(f.DangerousCalls, f.Calls) / f.Calls
+		}
+		f.Score = maxInt(s, 0)
+		total += f.Score * f.Complexity
+		weights += f.Complexity
+	}
+	if weights == 0 {
+		score.Score = 100
+	} else {
+		score.Score = total / weights
+	}
+	enabled := 0
+	for _, h := range hardening {
+		if h.Enabled {
+			enabled++
+		}
+	}
+	if len(hardening) > 0 {
+		score.Score = score.Score * (80 + 20*enabled/len(hardening)) / 100
+	}
+	return score
+}
+
+// WriteScore writes a table of the function scores followed by the module
+// score and the instrumentation enabled
+func WriteScore(w io.Writer, score Score) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "  Function\tScore\tComplexity\tFindings\tDangerous calls\n")
+	for _, f := range score.Functions {
+		fmt.Fprintf(table, "  %s\t%d\t%d\t%d\t%d of %d\n", f.Function, f.Score, f.Complexity, f.Findings.Total(), f.DangerousCalls, f.Calls)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	enabled := []string{}
+	for _, h := range score.Hardening {
+		if h.Enabled {
+			enabled = append(enabled, h.Name)
+		}
+	}
+	line := fmt.Sprintf("  Module: %d/100, instrumentation %d of %d", score.Score, len(enabled), len(score.Hardening))
+	if len(enabled) > 0 {
+		line += fmt.Sprintf(" (%s)", strings.Join(enabled, ", "))
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
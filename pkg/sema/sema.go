@@ -0,0 +1,622 @@
+// Package sema checks that a parsed program means something: that the
+// variables and functions it uses are declared, that declarations agree,
+// and that operands have types the operators take.
+//
+// The checks are those the code generator makes as it lowers a program,
+// made on the syntax tree alone, so that a program sema accepts is one
+// codegen can compile and checking it builds no IR.
+package sema
+
+import (
+	"fmt"
+
+	"github.com/anouar-bakouch/citadel/internal/suggest"
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/diag"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// Check reports the semantic errors in program, such as undefined
+// variables, conflicting declarations and operands of the wrong type, for
+// code generated with opts. It returns the first, as a parser.Error in
+// which errors.As finds the *codegen.Error. Of the options, only the
+// target bears on the checks, as inline assembly is rejected on
+// WebAssembly, and Context stops them.
+func Check(program *ast.Program, opts codegen.Options) (err error) {
+	defer diag.Recover("sema", &err)
+	target, err := codegen.LookupTarget(opts.Target)
+	if err != nil {
+		return &codegen.BackendError{Err: err}
+	}
+	check := &checker{opts: opts, target: target, functions: map[string]*ast.Function{}}
+	return check.program(program)
+}
+
+// checker makes the checks. Like lowering, it collects the signatures of
+// all the functions first, then goes through each body in order, a
+// variable being in scope from its declaration to the end of the function.
+type checker struct {
+	opts      codegen.Options
+	target    *codegen.Target
+	functions map[string]*ast.Function
+	fn        *ast.Function
+	vars      map[string]*ast.Type
+	breakable int // loops and switches around the statement being checked
+}
+
+// binaryOperators are the binary operators other than && and ||.
+var binaryOperators = map[string]bool{
+	"==": true, ">": true, "<": true,
+	"+": true, "-": true, "*": true, "/": true, "%": true,
+}
+
+func (k *checker) program(program *ast.Program) error {
+	for _, fn := range program.Functions {
+		if err := k.signature(fn); err != nil {
+			return err
+		}
+	}
+	for _, fn := range program.Functions {
+		if fn.Body == nil {
+			continue
+		}
+		if err := codegen.Canceled(k.opts); err != nil {
+			return err
+		}
+		if err := k.function(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signature records the signature of fn, checking its attributes and
+// that it agrees with any earlier declaration.
+func (k *checker) signature(fn *ast.Function) error {
+	if err := codegen.CheckSymbolAttributes(fn); err != nil {
+		return k.errorAt(fn, fn.Pos, fn.Pos, err)
+	}
+	if prev, ok := k.functions[fn.Name]; ok {
+		if !prev.Signature().Equal(fn.Signature()) {
+			return k.errorAt(fn, fn.Pos, fn.Pos, fmt.Errorf("%w for %s: %s", codegen.ErrConflictingTypes, fn.Name, fn.Signature()), k.noteAt(prev, "previous declaration is here, with type %s", prev.Signature()))
+		}
+		if prev.Body != nil && fn.Body != nil {
+			return k.errorAt(fn, fn.Pos, fn.Pos, fmt.Errorf("%w of %s", codegen.ErrRedefinition, fn.Name), k.noteAt(prev, "previous definition is here"))
+		}
+		if fn.Body == nil {
+			return nil
+		}
+	}
+	k.functions[fn.Name] = fn
+	return nil
+}
+
+func (k *checker) function(fn *ast.Function) error {
+	k.fn = fn
+	k.vars = map[string]*ast.Type{}
+	k.breakable = 0
+	for _, param := range fn.Params {
+		k.vars[param.Name] = param.Type
+	}
+	return k.statements(fn.Body.Statements)
+}
+
+// statements checks stmts, placing an error at the statement it is in
+// unless it has a place of its own.
+func (k *checker) statements(stmts []ast.Statement) error {
+	for _, stmt := range stmts {
+		if err := k.statement(stmt); err != nil {
+			return k.errorAt(k.fn, stmt.Position(), stmt.Position(), err)
+		}
+	}
+	return nil
+}
+
+func (k *checker) statement(stmt ast.Statement) error {
+	switch s := stmt.(type) {
+	case *ast.VarDecl:
+		k.vars[s.Name] = s.Type
+		if s.Value == nil {
+			return nil
+		}
+		if s.Type.Kind == ast.ArrayType {
+			return fmt.Errorf("array initializers are not supported: %s", s.Name)
+		}
+		return k.convertible(s.Value, s.Type)
+	case *ast.IfStatement:
+		if _, err := k.expression(s.Condition); err != nil {
+			return err
+		}
+		return k.statements(s.ThenBlock.Statements)
+	case *ast.WhileStatement:
+		if _, err := k.expression(s.Condition); err != nil {
+			return err
+		}
+		k.breakable++
+		defer func() { k.breakable-- }()
+		return k.statements(s.Body.Statements)
+	case *ast.SwitchStatement:
+		return k.switchStatement(s)
+	case *ast.BreakStatement:
+		if k.breakable == 0 {
+			return fmt.Errorf("break statement not within a loop or switch")
+		}
+		return nil
+	case *ast.ReturnStatement:
+		return k.convertible(s.Value, k.fn.ReturnType)
+	case *ast.ExprStatement:
+		// The result of a call may be discarded, even one of a void function
+		if call, ok := s.Expr.(*ast.CallExpr); ok {
+			_, err := k.call(call)
+			return err
+		}
+		_, err := k.expression(s.Expr)
+		return err
+	case *ast.AsmStatement:
+		if k.target.IsWasm() {
+			return fmt.Errorf("inline assembly is not supported on %s", k.target.Triple)
+		}
+		if _, err := codegen.AsmTemplate(s.Template); err != nil {
+			return fmt.Errorf("invalid asm statement: %v", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown statement type")
+	}
+}
+
+// switchStatement checks that the tag is an integer and that the case
+// labels are distinct integer constants, with at most one default.
+func (k *checker) switchStatement(stmt *ast.SwitchStatement) error {
+	t, err := k.expression(stmt.Tag)
+	if err != nil {
+		return err
+	}
+	if !t.IsInteger() {
+		return fmt.Errorf("switch on %s, which is not an integer", t)
+	}
+	seen := map[int]bool{}
+	hasDefault := false
+	for _, cs := range stmt.Cases {
+		if cs.Value == nil {
+			if hasDefault {
+				return fmt.Errorf("multiple default labels in one switch")
+			}
+			hasDefault = true
+			continue
+		}
+		value, err := codegen.CaseConstant(cs.Value)
+		if err != nil {
+			return err
+		}
+		if seen[value] {
+			return fmt.Errorf("duplicate case value %d", value)
+		}
+		seen[value] = true
+	}
+	k.breakable++
+	defer func() { k.breakable-- }()
+	for _, cs := range stmt.Cases {
+		if err := k.statements(cs.Body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expression checks expr and returns the type of its value.
+func (k *checker) expression(expr ast.Expression) (*ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.IntLiteral:
+		return ast.Int, nil
+	case *ast.StringLiteral:
+		if _, err := codegen.StringBytes(e); err != nil {
+			return nil, err
+		}
+		return ast.PointerTo(ast.Char), nil
+	case *ast.Identifier:
+		if t, ok := k.vars[e.Name]; ok {
+			return t.Decay(), nil
+		}
+		// A function name decays to a pointer to the function
+		if fn, ok := k.functions[e.Name]; ok {
+			return ast.PointerTo(fn.Signature()), nil
+		}
+		return nil, k.undefined(codegen.ErrUndefinedVariable, e)
+	case *ast.BinaryOp:
+		return k.binary(e)
+	case *ast.UnaryOp:
+		return k.unary(e)
+	case *ast.CallExpr:
+		t, err := k.call(e)
+		if err == nil && t == nil {
+			return nil, fmt.Errorf("void value of call to %s used", e.Callee)
+		}
+		return t, err
+	case *ast.IndexExpr:
+		t, err := k.element(e)
+		if err != nil {
+			return nil, err
+		}
+		// Indexing a row of a multi-dimensional array yields the decayed row
+		return t.Decay(), nil
+	case *ast.Assignment:
+		return k.assignment(e)
+	default:
+		return nil, fmt.Errorf("unknown expression type")
+	}
+}
+
+// binary checks a binary operator, whose operands are brought to their
+// common arithmetic type, except that a pointer compares with 0.
+// Comparisons and the logical operators yield an int.
+func (k *checker) binary(op *ast.BinaryOp) (*ast.Type, error) {
+	logical := op.Operator == "&&" || op.Operator == "||"
+	if !logical && !binaryOperators[op.Operator] {
+		return nil, fmt.Errorf("unsupported operator: %s", op.Operator)
+	}
+	left, err := k.expression(op.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := k.expression(op.Right)
+	if err != nil {
+		return nil, err
+	}
+	if logical {
+		return ast.Int, nil
+	}
+	if op.Operator == "==" && (left.Kind == ast.PointerType && isNullConstant(op.Right) || right.Kind == ast.PointerType && isNullConstant(op.Left)) {
+		return ast.Int, nil
+	}
+	t := ast.CommonType(left, right)
+	if t == nil {
+		return nil, fmt.Errorf("invalid operands to binary %s (%s and %s)", op.Operator, left, right)
+	}
+	if isBoolean(op) {
+		return ast.Int, nil
+	}
+	return t, nil
+}
+
+func (k *checker) unary(op *ast.UnaryOp) (*ast.Type, error) {
+	t, err := k.expression(op.Operand)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Operator {
+	case "*":
+		// Dereferencing a function pointer yields the function, which
+		// immediately decays back to the same pointer. Data pointers are
+		// only dereferenced to be assigned through
+		switch {
+		case t.Kind != ast.PointerType:
+			return nil, fmt.Errorf("cannot dereference %s", t)
+		case !t.IsFuncPointer():
+			return nil, fmt.Errorf("unsupported dereference of %s", t)
+		}
+		return t, nil
+	case "-":
+		if !t.IsArithmetic() {
+			return nil, fmt.Errorf("invalid argument type %s to unary -", t)
+		}
+		return t.Promote(), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", op.Operator)
+	}
+}
+
+// call checks a call and returns the type of its result, or nil for a C
+// library function that returns nothing.
+func (k *checker) call(call *ast.CallExpr) (*ast.Type, error) {
+	if id, ok := call.Callee.(*ast.Identifier); ok {
+		if _, local := k.vars[id.Name]; !local {
+			fn := k.functions[id.Name]
+			if fn == nil || fn.Body == nil {
+				if codegen.MemIntrinsic(id.Name) != "" {
+					return k.memCall(call, id.Name)
+				}
+			}
+			if lib := codegen.LookupLibc(id.Name); fn == nil && lib != nil {
+				return k.libcCall(call, lib)
+			}
+		}
+	}
+
+	sig, err := k.calleeSignature(call)
+	if err != nil {
+		return nil, err
+	}
+	if len(call.Args) != len(sig.Params) {
+		return nil, fmt.Errorf("call to %s expects %d arguments, got %d", call.Callee, len(sig.Params), len(call.Args))
+	}
+	for i, arg := range call.Args {
+		if err := k.convertible(arg, sig.Params[i]); err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %v", i+1, call.Callee, err)
+		}
+	}
+	return sig.Elem, nil
+}
+
+// calleeSignature returns the function type being called: that of the
+// function a call names, or of the function pointer it calls through.
+func (k *checker) calleeSignature(call *ast.CallExpr) (*ast.Type, error) {
+	if id, ok := call.Callee.(*ast.Identifier); ok {
+		if _, local := k.vars[id.Name]; !local {
+			if fn, ok := k.functions[id.Name]; ok {
+				return fn.Signature(), nil
+			}
+			return nil, k.undefined(codegen.ErrUndefinedFunction, id)
+		}
+	}
+	t, err := k.expression(call.Callee)
+	if err != nil {
+		return nil, err
+	}
+	if !t.IsFuncPointer() {
+		return nil, fmt.Errorf("called object %s is not a function", call.Callee)
+	}
+	return t.Elem, nil
+}
+
+// memCall checks a call to memcpy, memmove or memset, which take data
+// pointers and int sizes and return their first argument.
+func (k *checker) memCall(call *ast.CallExpr, name string) (*ast.Type, error) {
+	if len(call.Args) != 3 {
+		return nil, fmt.Errorf("call to %s expects 3 arguments, got %d", name, len(call.Args))
+	}
+	dst, err := k.dataPointer(call.Args[0], name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "memset" {
+		err = k.intArgument(call.Args[1], name)
+	} else {
+		_, err = k.dataPointer(call.Args[1], name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dst, k.intArgument(call.Args[2], name)
+}
+
+func (k *checker) dataPointer(expr ast.Expression, fn string) (*ast.Type, error) {
+	t, err := k.expression(expr)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind != ast.PointerType || t.IsFuncPointer() {
+		return nil, fmt.Errorf("argument %s to %s is not a data pointer", expr, fn)
+	}
+	return t, nil
+}
+
+func (k *checker) intArgument(expr ast.Expression, fn string) error {
+	t, err := k.expression(expr)
+	if err != nil {
+		return err
+	}
+	if !t.IsInteger() {
+		return fmt.Errorf("argument %s to %s must be an int, got %s", expr, fn, t)
+	}
+	return nil
+}
+
+// libcCall checks a call to a C library function the program does not
+// declare against its built-in signature. Variadic arguments may have
+// any type.
+func (k *checker) libcCall(call *ast.CallExpr, fn *codegen.LibcFunction) (*ast.Type, error) {
+	if len(call.Args) < len(fn.Params) || len(call.Args) > len(fn.Params) && !fn.Variadic {
+		return nil, fmt.Errorf("call to %s expects %d arguments, got %d", fn.Name, len(fn.Params), len(call.Args))
+	}
+	for i, arg := range call.Args {
+		t, err := k.expression(arg)
+		if err != nil {
+			return nil, err
+		}
+		if i >= len(fn.Params) {
+			continue
+		}
+		switch param := fn.Params[i]; {
+		case param == codegen.LibcPtr && t.Kind == ast.PointerType:
+		case (param == codegen.LibcSize || param == codegen.LibcInt) && t.IsArithmetic():
+		default:
+			return nil, fmt.Errorf("argument %d to %s has incompatible type %s", i+1, fn.Name, t)
+		}
+	}
+	if fn.Result == codegen.LibcVoid {
+		return nil, nil
+	}
+	return fn.ResultType(), nil
+}
+
+// element checks a subscript and returns the type of the element, which
+// is an array for a row of a multi-dimensional array.
+func (k *checker) element(e *ast.IndexExpr) (*ast.Type, error) {
+	var elem *ast.Type
+	if object := arrayObjectType(e.Array, k.vars); object != nil {
+		if row, ok := e.Array.(*ast.IndexExpr); ok {
+			if _, err := k.element(row); err != nil {
+				return nil, err
+			}
+		}
+		elem = object.Elem
+	} else {
+		t, err := k.expression(e.Array)
+		if err != nil {
+			return nil, err
+		}
+		if t.Kind != ast.PointerType || t.IsFuncPointer() {
+			return nil, fmt.Errorf("subscripted value %s is not an array or pointer", e.Array)
+		}
+		elem = t.Elem
+	}
+	t, err := k.expression(e.Index)
+	if err != nil {
+		return nil, err
+	}
+	if !t.IsInteger() {
+		return nil, fmt.Errorf("array subscript %s is not an integer", e.Index)
+	}
+	return elem, nil
+}
+
+func (k *checker) assignment(a *ast.Assignment) (*ast.Type, error) {
+	t, err := k.object(a.Target)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind == ast.ArrayType {
+		return nil, fmt.Errorf("array %s is not assignable", a.Target)
+	}
+	if err := k.convertible(a.Value, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// object checks an lvalue and returns the type of the object it
+// designates.
+func (k *checker) object(expr ast.Expression) (*ast.Type, error) {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if t, ok := k.vars[e.Name]; ok {
+			return t, nil
+		}
+		return nil, k.undefined(codegen.ErrUndefinedVariable, e)
+	case *ast.IndexExpr:
+		return k.element(e)
+	case *ast.UnaryOp:
+		if e.Operator == "*" {
+			t, err := k.expression(e.Operand)
+			if err != nil {
+				return nil, err
+			}
+			if t.Kind != ast.PointerType || t.IsFuncPointer() {
+				return nil, fmt.Errorf("cannot assign through %s", t)
+			}
+			return t.Elem, nil
+		}
+	}
+	return nil, fmt.Errorf("expression is not assignable: %s", parser.FormatExpr(expr))
+}
+
+// convertible checks expr and that its value converts implicitly to type
+// to, as assignment, argument passing and return convert it: between
+// arithmetic types, between void* and data pointers, and from the
+// constant 0 to any pointer.
+func (k *checker) convertible(expr ast.Expression, to *ast.Type) error {
+	from, err := k.expression(expr)
+	if err != nil {
+		return err
+	}
+	switch {
+	case from.Equal(to):
+		return nil
+	case to.Kind == ast.PointerType && voidPointerConversion(from, to):
+		return nil
+	case to.Kind == ast.PointerType && from.Kind == ast.PointerType:
+		return fmt.Errorf("incompatible pointer types converting %s to %s", from, to)
+	case to.Kind == ast.PointerType && from.IsInteger() && isNullConstant(expr):
+		return nil
+	case to.Kind == ast.PointerType:
+		return fmt.Errorf("incompatible integer to pointer conversion from %s to %s", from, to)
+	case from.Kind == ast.PointerType:
+		return fmt.Errorf("incompatible pointer to integer conversion from %s to %s", from, to)
+	case !from.IsArithmetic() || !to.IsArithmetic():
+		return fmt.Errorf("cannot convert %s to %s", from, to)
+	}
+	return nil
+}
+
+// undefined returns err, codegen.ErrUndefinedVariable or
+// codegen.ErrUndefinedFunction, for the identifier id, at id and
+// suggesting the variable or function in scope it is likeliest a
+// misspelling of.
+func (k *checker) undefined(err error, id *ast.Identifier) error {
+	names := make([]string, 0, len(k.vars)+len(k.functions))
+	for name := range k.vars {
+		names = append(names, name)
+	}
+	for name := range k.functions {
+		names = append(names, name)
+	}
+	if s := suggest.Closest(id.Name, names); s != "" {
+		err = fmt.Errorf("%w: %s; did you mean %s?", err, id.Name, s)
+	} else {
+		err = fmt.Errorf("%w: %s", err, id.Name)
+	}
+	end := id.Pos
+	end.Column += len(id.Name)
+	return k.errorAt(k.fn, id.Pos, end, err)
+}
+
+// errorAt returns err as a parser.Error about the text from pos to end in
+// the file of fn, with notes, unless it is one already or pos is unknown.
+func (k *checker) errorAt(fn *ast.Function, pos, end lexer.Position, err error, notes ...parser.Note) error {
+	if _, ok := err.(*parser.Error); ok || pos.Line == 0 {
+		return err
+	}
+	return &parser.Error{File: k.fileOf(fn), Pos: pos, End: end, Msg: err.Error(), Notes: notes, Err: &codegen.Error{Function: fn.Name, Pos: pos, Err: err}}
+}
+
+// noteAt returns a note on the declaration of fn.
+func (k *checker) noteAt(fn *ast.Function, format string, args ...interface{}) parser.Note {
+	return parser.Note{File: k.fileOf(fn), Pos: fn.Pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// fileOf returns the name of the source file fn is in.
+func (k *checker) fileOf(fn *ast.Function) string {
+	if fn.File != "" {
+		return fn.File
+	}
+	return k.opts.SourceFile
+}
+
+// isBoolean reports whether expr is a comparison or logical operator,
+// whose value is an int 0 or 1.
+func isBoolean(expr ast.Expression) bool {
+	op, ok := expr.(*ast.BinaryOp)
+	if !ok {
+		return false
+	}
+	switch op.Operator {
+	case "==", "<", ">", "&&", "||":
+		return true
+	}
+	return false
+}
+
+// isNullConstant reports whether expr is the literal 0, which compares
+// with and converts to any pointer.
+func isNullConstant(expr ast.Expression) bool {
+	lit, ok := expr.(*ast.IntLiteral)
+	return ok && lit.Value == 0
+}
+
+// voidPointerConversion reports whether from converts implicitly to to as
+// a data pointer to or from void*.
+func voidPointerConversion(from, to *ast.Type) bool {
+	if from.Kind != ast.PointerType || to.Kind != ast.PointerType || from.IsFuncPointer() || to.IsFuncPointer() {
+		return false
+	}
+	return from.IsVoidPointer() || to.IsVoidPointer()
+}
+
+// arrayObjectType returns the array type of expr when it designates a
+// local array, or a row of one, rather than a pointer, and nil otherwise.
+func arrayObjectType(expr ast.Expression, varTypes map[string]*ast.Type) *ast.Type {
+	switch e := expr.(type) {
+	case *ast.Identifier:
+		if t, ok := varTypes[e.Name]; ok && t.Kind == ast.ArrayType {
+			return t
+		}
+	case *ast.IndexExpr:
+		if outer := arrayObjectType(e.Array, varTypes); outer != nil && outer.Elem.Kind == ast.ArrayType {
+			return outer.Elem
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package sema_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+	"github.com/anouar-bakouch/citadel/pkg/sema"
+)
+
+// TestCheck checks that Check reports the semantic errors of a program but
+// not the errors of options only code generation uses
+func TestCheck(t *testing.T) {
+	parse := func(src string) *ast.Program {
+		program, err := parser.New(lexer.New(src)).ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		return program
+	}
+	backendOnly := codegen.Options{Target: "wasm32-unknown-unknown", SafeStack: true, OptLevel: 1, BoundsChecks: true}
+	ok := parse("int main() { int a[2]; a[1] = 3; return a[1]; }")
+	if err := sema.Check(ok, backendOnly); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	if _, err := codegen.NewWithOptions(backendOnly).Generate(ok); !codegen.IsBackendError(err) {
+		t.Errorf("Generate: got %v, want a backend error", err)
+	}
+
+	err := sema.Check(parse("int main() { return y; }"), backendOnly)
+	if !errors.Is(err, codegen.ErrUndefinedVariable) {
+		t.Errorf("Check: got %v, want %v", err, codegen.ErrUndefinedVariable)
+	}
+
+	// The message shows the expression as it was written, not its node
+	err = sema.Check(parse("int main() { int a = 1; (a + 2) = 3; return a; }"), codegen.Options{})
+	if want := "expression is not assignable: (a + 2)"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Check: got %v, want %s", err, want)
+	}
+}
+
+// TestCheckMatchesGenerate checks that Check accepts the programs
+// lowering accepts and stops at the same error as lowering in those it
+// rejects
+func TestCheckMatchesGenerate(t *testing.T) {
+	sources := []string{
+		"int f(int x); int main() { return f(1); } int f(int x) { return x; }",
+		"int main() { int a[2][3]; a[1][2] = 4; int *p = a[1]; return p[2]; }",
+		"int main() { char *s = malloc(4); memset(s, 0, 4); free(s); return strlen(\"ab\"); }",
+		"int twice(int x) { return x + x; } int main() { int (*f)(int) = twice; return (*f)(2); }",
+		"int main() { int *p = 0; if (p == 0 && 1) { return 1; } return 0; }",
+		"int main() { int i = 2; switch (i) { case 1: break; case 2: return 5; default: break; } return 0; }",
+		"int main() { __asm__(\"nop\"); return 0; }",
+
+		"int main() { return y; }",
+		"int main() { int count = 1; return cout; }",
+		"int main() { return g(1); }",
+		"int f(int x); long f(int x) { return x; }",
+		"int f() { return 0; } int f() { return 1; }",
+		"int main() { int *p = 1; return 0; }",
+		"int main() { int x; char *p; x = p; return 0; }",
+		"int main() { int *p; char *q; p = q; return 0; }",
+		"int main() { int x; return *x; }",
+		"int main() { int x; return x[0]; }",
+		"int main() { int a[2]; int *p; return a[p]; }",
+		"int main() { int a[2]; int b[2]; a = b; return 0; }",
+		"int main() { 1 = 2; return 0; }",
+		"int main() { int a = 1; (a + 2) = 3; return a; }",
+		"int main() { break; return 0; }",
+		"int main() { int *p; switch (p) { default: break; } return 0; }",
+		"int main() { switch (1) { case 1: break; case 1: break; } return 0; }",
+		"int main() { switch (1) { default: break; default: break; } return 0; }",
+		"int f(int x) { return x; } int main() { return f(1, 2); }",
+		"int f(int *x) { return 0; } int main() { int y; return f(y); }",
+		"int main() { int x = 1; return x(); }",
+		"int main() { char *p = malloc(1); return free(p); }",
+		"int main() { return memcpy(1, 2, 3); }",
+		"int main() { return strlen(1); }",
+		"int main() { int a[2] = 0; return 0; }",
+		"int main() { int *p; return -p; }",
+		"int main() { return \"\\q\"; }",
+	}
+	for _, src := range sources {
+		program, err := parser.New(lexer.New(src)).ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		checkErr := sema.Check(program, codegen.Options{})
+		_, genErr := codegen.New().Generate(program)
+		switch {
+		case checkErr == nil && genErr == nil:
+		case checkErr == nil || genErr == nil:
+			t.Errorf("%s: Check gave %v, Generate %v", src, checkErr, genErr)
+		case checkErr.Error() != genErr.Error():
+			t.Errorf("%s: Check gave %q, Generate %q", src, checkErr, genErr)
+		}
+	}
+}
@@ -0,0 +1,797 @@
+package symexec
+
+import (
+	"math/big"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/codegen"
+)
+
+// symbol is an unknown the engine reasons about
+type symbol struct {
+	name   string // how witnesses name it, or "" to leave it out
+	lo, hi *big.Int
+	// approx marks a stand-in for a value the engine does not model, such
+	// as a product of two unknowns or a call result; a path that depends
+	// on one cannot be confirmed
+	approx bool
+}
+
+// value is what an expression evaluates to. lin is nil for values the
+// engine does not track, such as floating-point ones
+type value struct {
+	lin *linear
+	typ *ast.Type
+}
+
+// state is one path through the function: the values of the variables in
+// the innermost frame and the conditions the path took
+type state struct {
+	env  map[string]value
+	path []constraint
+	// approx is set once the path branched on a condition the engine could
+	// not express
+	approx bool
+	// model, if known, is a solution of path. A path extended by
+	// conditions the model meets is feasible without asking the solver
+	model map[int]*big.Int
+}
+
+func (s *state) fork() *state {
+	env := make(map[string]value, len(s.env))
+	for name, v := range s.env {
+		env[name] = v
+	}
+	return &state{env: env, path: s.path[:len(s.path):len(s.path)], approx: s.approx, model: s.model}
+}
+
+// assume returns the path with c added to its conditions
+func (s *state) assume(c constraint) *state {
+	t := s.fork()
+	t.require(c)
+	return t
+}
+
+// require adds c to the conditions of the path, forgetting its model if
+// the model does not meet c
+func (s *state) require(c constraint) {
+	s.path = append(s.path, c)
+	if s.model != nil && !holds(c, s.model) {
+		s.model = nil
+	}
+}
+
+// frame is a function being executed, directly or from a call
+type frame struct {
+	fn    *ast.Function
+	types map[string]*ast.Type
+	depth int // calls between it and the function being checked
+}
+
+// flow says how a path leaves a list of statements
+type flow int
+
+const (
+	next flow = iota
+	broke
+	returned
+)
+
+type path struct {
+	st     *state
+	flow   flow
+	result value // on return
+}
+
+// result is one way an expression can evaluate
+type result struct {
+	st *state
+	v  value
+}
+
+type engine struct {
+	program    *ast.Program
+	options    Options
+	symbols    []symbol
+	paths      int  // paths started so far
+	work       int  // solver work left
+	incomplete bool // a branch was not taken for want of paths
+	queries    map[ast.Expression][]int
+	pending    []pending
+}
+
+// fresh returns a new symbol holding any value of type t
+func (e *engine) fresh(name string, t *ast.Type, approx bool) value {
+	lo, hi, ok := typeRange(t)
+	if !ok {
+		return value{typ: t}
+	}
+	e.symbols = append(e.symbols, symbol{name: name, lo: lo, hi: hi, approx: approx})
+	return value{lin: variable(len(e.symbols) - 1), typ: t}
+}
+
+// address returns a new symbol for the address of an object, which is
+// never null
+func (e *engine) address(t *ast.Type) value {
+	v := e.fresh("", t, false)
+	e.symbols[len(e.symbols)-1].lo = big.NewInt(1)
+	return v
+}
+
+// typeBits are the widths of the integer types, an LP64 long as in the
+// analysis
+var typeBits = map[string]uint{"char": 8, "short": 16, "int": 32, "long": 64}
+
+// typeRange returns the values of an integer type, or the addresses a
+// pointer can hold. It reports false for types the engine does not track
+func typeRange(t *ast.Type) (lo, hi *big.Int, ok bool) {
+	switch {
+	case t == nil:
+		return nil, nil, false
+	case t.IsInteger():
+		hi := new(big.Int).Lsh(big.NewInt(1), typeBits[t.Name]-1)
+		lo := new(big.Int).Neg(hi)
+		return lo, hi.Sub(hi, big.NewInt(1)), true
+	case t.Decay().Kind == ast.PointerType:
+		hi := new(big.Int).Lsh(big.NewInt(1), 64)
+		return new(big.Int), hi.Sub(hi, big.NewInt(1)), true
+	}
+	return nil, nil, false
+}
+
+// split returns the path st continues on when c holds and when it does
+// not, each nil if the solver shows it infeasible. Once the path bound
+// is reached only the first is followed
+func (e *engine) split(st *state, c constraint) (yes, no *state) {
+	yes, no = st.assume(c), st.assume(c.negate())
+	if !e.feasible(yes) {
+		yes = nil
+	}
+	if !e.feasible(no) {
+		no = nil
+	}
+	return e.bound(yes, no)
+}
+
+// feasible reports whether the solver cannot rule out the path st,
+// keeping the solution it finds for the paths st is extended into
+func (e *engine) feasible(st *state) bool {
+	if st.model != nil {
+		return true
+	}
+	o, model := e.solve(st.path)
+	st.model = model
+	return o != unsat
+}
+
+// both returns two copies of st for a branch on a condition the engine
+// cannot express
+func (e *engine) both(st *state) (yes, no *state) {
+	yes, no = st.fork(), st.fork()
+	yes.approx, no.approx = true, true
+	return e.bound(yes, no)
+}
+
+func (e *engine) bound(yes, no *state) (*state, *state) {
+	if yes == nil || no == nil {
+		return yes, no
+	}
+	if e.paths >= e.options.MaxPaths || e.options.Context != nil && e.options.Context.Err() != nil {
+		e.incomplete = true
+		return yes, nil
+	}
+	e.paths++
+	return yes, no
+}
+
+// statements executes stmts on the path st
+func (e *engine) statements(f *frame, st *state, stmts []ast.Statement) []path {
+	paths := []path{{st: st}}
+	for _, stmt := range stmts {
+		var out []path
+		for _, p := range paths {
+			if p.flow != next {
+				out = append(out, p)
+				continue
+			}
+			out = append(out, e.statement(f, p.st, stmt)...)
+		}
+		paths = out
+	}
+	return paths
+}
+
+func (e *engine) statement(f *frame, st *state, stmt ast.Statement) []path {
+	var paths []path
+	switch s := stmt.(type) {
+	case *ast.Block:
+		return e.statements(f, st, s.Statements)
+	case *ast.VarDecl:
+		f.types[s.Name] = s.Type
+		switch {
+		case s.Type.Kind == ast.ArrayType:
+			st.env[s.Name] = e.address(s.Type)
+		case s.Value == nil:
+			// An uninitialized local holds whatever was there
+			st.env[s.Name] = e.fresh("", s.Type, true)
+		default:
+			for _, r := range e.eval(f, st, s.Value) {
+				r.st.env[s.Name] = e.convert(r.st, r.v, s.Type)
+				paths = append(paths, path{st: r.st})
+			}
+			return paths
+		}
+	case *ast.IfStatement:
+		yes, no := e.branch(f, st, s.Condition)
+		for _, st := range yes {
+			if s.ThenBlock == nil {
+				paths = append(paths, path{st: st})
+			} else {
+				paths = append(paths, e.statements(f, st, s.ThenBlock.Statements)...)
+			}
+		}
+		for _, st := range no {
+			if s.ElseBlock == nil {
+				paths = append(paths, path{st: st})
+			} else {
+				paths = append(paths, e.statements(f, st, s.ElseBlock.Statements)...)
+			}
+		}
+		return paths
+	case *ast.WhileStatement:
+		return e.whileStatement(f, st, s)
+	case *ast.SwitchStatement:
+		return e.switchStatement(f, st, s)
+	case *ast.BreakStatement:
+		return []path{{st: st, flow: broke}}
+	case *ast.ReturnStatement:
+		if s.Value == nil {
+			return []path{{st: st, flow: returned, result: value{typ: f.fn.ReturnType}}}
+		}
+		for _, r := range e.eval(f, st, s.Value) {
+			paths = append(paths, path{st: r.st, flow: returned, result: e.convert(r.st, r.v, f.fn.ReturnType)})
+		}
+		return paths
+	case *ast.ExprStatement:
+		for _, r := range e.eval(f, st, s.Expr) {
+			paths = append(paths, path{st: r.st})
+		}
+		return paths
+	}
+	return []path{{st: st}}
+}
+
+// whileStatement runs a loop as any number of iterations at once: the
+// variables it assigns take stand-ins for whatever they hold at its head,
+// from which the loop is left or its body run once more
+func (e *engine) whileStatement(f *frame, st *state, s *ast.WhileStatement) []path {
+	head := st.fork()
+	for name := range assignedIn(s, map[string]bool{}) {
+		if t := f.types[name]; t != nil {
+			head.env[name] = e.fresh("", t, true)
+		}
+	}
+	yes, no := e.branch(f, head, s.Condition)
+	var paths []path
+	for _, st := range no {
+		paths = append(paths, path{st: st})
+	}
+	for _, st := range yes {
+		for _, p := range e.statements(f, st, s.Body.Statements) {
+			// Paths reaching the end of the body are back at the head
+			switch p.flow {
+			case broke:
+				p.flow = next
+				paths = append(paths, p)
+			case returned:
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// assignedIn adds to names the variables node assigns
+func assignedIn(node ast.Node, names map[string]bool) map[string]bool {
+	switch n := node.(type) {
+	case *ast.Block:
+		for _, stmt := range n.Statements {
+			assignedIn(stmt, names)
+		}
+	case *ast.VarDecl:
+		assignedIn(n.Value, names)
+	case *ast.IfStatement:
+		assignedIn(n.Condition, names)
+		if n.ThenBlock != nil {
+			assignedIn(n.ThenBlock, names)
+		}
+		if n.ElseBlock != nil {
+			assignedIn(n.ElseBlock, names)
+		}
+	case *ast.WhileStatement:
+		assignedIn(n.Condition, names)
+		assignedIn(n.Body, names)
+	case *ast.SwitchStatement:
+		assignedIn(n.Tag, names)
+		for _, cs := range n.Cases {
+			for _, stmt := range cs.Body {
+				assignedIn(stmt, names)
+			}
+		}
+	case *ast.ReturnStatement:
+		assignedIn(n.Value, names)
+	case *ast.ExprStatement:
+		assignedIn(n.Expr, names)
+	case *ast.BinaryOp:
+		assignedIn(n.Left, names)
+		assignedIn(n.Right, names)
+	case *ast.UnaryOp:
+		assignedIn(n.Operand, names)
+	case *ast.IndexExpr:
+		assignedIn(n.Array, names)
+		assignedIn(n.Index, names)
+	case *ast.Assignment:
+		if id, ok := n.Target.(*ast.Identifier); ok {
+			names[id.Name] = true
+		}
+		assignedIn(n.Target, names)
+		assignedIn(n.Value, names)
+	case *ast.CallExpr:
+		assignedIn(n.Callee, names)
+		for _, arg := range n.Args {
+			assignedIn(arg, names)
+		}
+	}
+	return names
+}
+
+// switchStatement enters each case whose label the tag can match, and the
+// default case when it can match none, running the case bodies from there
+// to a break
+func (e *engine) switchStatement(f *frame, st *state, s *ast.SwitchStatement) []path {
+	var paths []path
+	for _, r := range e.eval(f, st, s.Tag) {
+		rest, entries := r.st, make([]*state, len(s.Cases))
+		deflt := -1
+		for i, cs := range s.Cases {
+			if cs.Value == nil {
+				deflt = i
+				continue
+			}
+			if rest == nil {
+				continue
+			}
+			label := e.eval(f, rest, cs.Value)
+			if len(label) == 1 && label[0].v.lin != nil && r.v.lin != nil {
+				rest = label[0].st
+				entries[i], rest = e.split(rest, compare(r.v.lin, "==", label[0].v.lin))
+			} else {
+				entries[i], rest = e.both(rest)
+			}
+		}
+		if deflt >= 0 {
+			entries[deflt], rest = rest, nil
+		}
+		if rest != nil {
+			paths = append(paths, path{st: rest})
+		}
+		for i, entry := range entries {
+			if entry == nil {
+				continue
+			}
+			var body []ast.Statement
+			for _, cs := range s.Cases[i:] {
+				body = append(body, cs.Body...)
+			}
+			for _, p := range e.statements(f, entry, body) {
+				if p.flow == broke {
+					p.flow = next
+				}
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// branch returns the paths on which cond is true and those on which it is
+// false. && and || only evaluate their right operand when the left one
+// does not settle the result
+func (e *engine) branch(f *frame, st *state, cond ast.Expression) (yes, no []*state) {
+	if b, ok := cond.(*ast.BinaryOp); ok {
+		switch b.Operator {
+		case "&&", "||":
+			l, r := e.branch(f, st, b.Left)
+			settled, open := l, r
+			if b.Operator == "&&" {
+				settled, open = r, l
+			}
+			var more []*state
+			for _, st := range open {
+				y, n := e.branch(f, st, b.Right)
+				if b.Operator == "&&" {
+					yes = append(yes, y...)
+					more = append(more, n...)
+				} else {
+					more = append(more, y...)
+					no = append(no, n...)
+				}
+			}
+			if b.Operator == "&&" {
+				return yes, append(settled, more...)
+			}
+			return append(settled, more...), no
+		case "<", ">", "==":
+			for _, l := range e.eval(f, st, b.Left) {
+				for _, r := range e.eval(f, l.st, b.Right) {
+					var y, n *state
+					if l.v.lin != nil && r.v.lin != nil {
+						y, n = e.split(r.st, compare(l.v.lin, b.Operator, r.v.lin))
+					} else {
+						y, n = e.both(r.st)
+					}
+					yes, no = appendState(yes, y), appendState(no, n)
+				}
+			}
+			return yes, no
+		}
+	}
+	for _, r := range e.eval(f, st, cond) {
+		var y, n *state
+		if r.v.lin != nil {
+			y, n = e.split(r.st, constraint{r.v.lin, "!="})
+		} else {
+			y, n = e.both(r.st)
+		}
+		yes, no = appendState(yes, y), appendState(no, n)
+	}
+	return yes, no
+}
+
+func appendState(sts []*state, st *state) []*state {
+	if st == nil {
+		return sts
+	}
+	return append(sts, st)
+}
+
+// eval returns the ways expr can evaluate on the path st, answering the
+// queries on it along the way
+func (e *engine) eval(f *frame, st *state, expr ast.Expression) []result {
+	rs := e.evalExpression(f, st, expr)
+	if f.depth == 0 && len(e.queries[expr]) > 0 {
+		for _, r := range rs {
+			e.ask(r.st, expr, r.v)
+		}
+	}
+	return rs
+}
+
+func (e *engine) evalExpression(f *frame, st *state, expr ast.Expression) []result {
+	switch x := expr.(type) {
+	case *ast.IntLiteral:
+		return []result{{st, value{lin: constant(big.NewInt(int64(x.Value))), typ: ast.Int}}}
+	case *ast.StringLiteral:
+		return []result{{st, e.address(ast.PointerTo(ast.Char))}}
+	case *ast.Identifier:
+		if v, ok := st.env[x.Name]; ok {
+			return []result{{st, v}}
+		}
+		if t := f.types[x.Name]; t != nil {
+			return []result{{st, e.fresh("", t, true)}}
+		}
+		for _, fn := range e.program.Functions {
+			if fn.Name == x.Name {
+				return []result{{st, e.address(ast.PointerTo(fn.Signature()))}}
+			}
+		}
+		return []result{{st, value{}}}
+	case *ast.UnaryOp:
+		var rs []result
+		for _, r := range e.eval(f, st, x.Operand) {
+			t := r.v.typ
+			switch {
+			case t == nil:
+				rs = append(rs, result{r.st, value{}})
+			case x.Operator == "*":
+				// Memory is not modelled: a load can read anything
+				if t.Decay().Kind != ast.PointerType {
+					rs = append(rs, result{r.st, value{}})
+				} else {
+					rs = append(rs, result{r.st, e.fresh("", t.Decay().Elem, true)})
+				}
+			case t.IsInteger() && r.v.lin != nil:
+				t = t.Promote()
+				rs = append(rs, e.arithmetic(r.st, r.v.lin.times(big.NewInt(-1)), t))
+			default:
+				rs = append(rs, result{r.st, value{typ: t}})
+			}
+		}
+		return rs
+	case *ast.BinaryOp:
+		return e.binary(f, st, x)
+	case *ast.IndexExpr:
+		var rs []result
+		for _, a := range e.eval(f, st, x.Array) {
+			for _, i := range e.eval(f, a.st, x.Index) {
+				v := value{}
+				if t := a.v.typ; t != nil && t.Decay().Kind == ast.PointerType {
+					v = e.fresh("", t.Decay().Elem, true)
+				}
+				rs = append(rs, result{i.st, v})
+			}
+		}
+		return rs
+	case *ast.Assignment:
+		var rs []result
+		target, ok := x.Target.(*ast.Identifier)
+		if !ok {
+			// Stores to memory are not modelled, but the target is still
+			// evaluated for the queries in it
+			for _, t := range e.eval(f, st, x.Target) {
+				for _, r := range e.eval(f, t.st, x.Value) {
+					rs = append(rs, result{r.st, e.convert(r.st, r.v, t.v.typ)})
+				}
+			}
+			return rs
+		}
+		for _, r := range e.eval(f, st, x.Value) {
+			v := e.convert(r.st, r.v, f.types[target.Name])
+			r.st.env[target.Name] = v
+			rs = append(rs, result{r.st, v})
+		}
+		return rs
+	case *ast.CallExpr:
+		return e.call(f, st, x)
+	}
+	return []result{{st, value{}}}
+}
+
+// binary evaluates a binary operator. Comparisons and logical operators
+// fork the path into one where they are 1 and one where they are 0
+func (e *engine) binary(f *frame, st *state, x *ast.BinaryOp) []result {
+	switch x.Operator {
+	case "&&", "||", "<", ">", "==":
+		yes, no := e.branch(f, st, x)
+		var rs []result
+		for _, st := range yes {
+			rs = append(rs, result{st, value{lin: constant(big.NewInt(1)), typ: ast.Int}})
+		}
+		for _, st := range no {
+			rs = append(rs, result{st, value{lin: constant(big.NewInt(0)), typ: ast.Int}})
+		}
+		return rs
+	}
+	var rs []result
+	for _, l := range e.eval(f, st, x.Left) {
+		for _, r := range e.eval(f, l.st, x.Right) {
+			rs = append(rs, e.operate(r.st, x.Operator, l.v, r.v))
+		}
+	}
+	return rs
+}
+
+// operate applies an arithmetic operator to two values
+func (e *engine) operate(st *state, op string, l, r value) result {
+	if l.typ == nil || r.typ == nil {
+		return result{st, value{}}
+	}
+	lp, rp := l.typ.Decay().Kind == ast.PointerType, r.typ.Decay().Kind == ast.PointerType
+	var t *ast.Type
+	switch {
+	case lp && rp:
+		t = ast.Long
+	case lp:
+		t = l.typ.Decay()
+	case rp:
+		t = r.typ.Decay()
+	default:
+		t = ast.CommonType(l.typ, r.typ)
+	}
+	if l.lin == nil || r.lin == nil || t == nil || t.IsFloating() {
+		return result{st, e.fresh("", t, true)}
+	}
+	switch {
+	case op == "+":
+		return e.arithmetic(st, l.lin.plus(r.lin), t)
+	case op == "-":
+		return e.arithmetic(st, l.lin.minus(r.lin), t)
+	case op == "*" && l.lin.isConstant():
+		return e.arithmetic(st, r.lin.times(l.lin.k), t)
+	case op == "*" && r.lin.isConstant():
+		return e.arithmetic(st, l.lin.times(r.lin.k), t)
+	case (op == "/" || op == "%") && l.lin.isConstant() && r.lin.isConstant() && r.lin.k.Sign() != 0:
+		// C division truncates, as Quo and Rem do
+		if op == "/" {
+			return e.arithmetic(st, constant(new(big.Int).Quo(l.lin.k, r.lin.k)), t)
+		}
+		return e.arithmetic(st, constant(new(big.Int).Rem(l.lin.k, r.lin.k)), t)
+	}
+	return result{st, e.fresh("", t, true)}
+}
+
+// arithmetic returns the result of an operation of type t. Overflow is
+// undefined for signed types, so the path assumes the result fits
+func (e *engine) arithmetic(st *state, lin *linear, t *ast.Type) result {
+	lo, hi, _ := typeRange(t)
+	if !e.within(lin, lo, hi) {
+		st = st.assume(constraint{lin.minus(constant(hi)), "<="})
+		st.require(constraint{constant(lo).minus(lin), "<="})
+	}
+	return result{st, value{lin: lin, typ: t}}
+}
+
+// within reports whether lin stays within [lo, hi] whatever values its
+// symbols take
+func (e *engine) within(lin *linear, lo, hi *big.Int) bool {
+	min, max := new(big.Int).Set(lin.k), new(big.Int).Set(lin.k)
+	for id, a := range lin.terms {
+		s := e.symbols[id]
+		x, y := new(big.Int).Mul(a, s.lo), new(big.Int).Mul(a, s.hi)
+		if a.Sign() < 0 {
+			x, y = y, x
+		}
+		min.Add(min, x)
+		max.Add(max, y)
+	}
+	return lo.Cmp(min) <= 0 && max.Cmp(hi) <= 0
+}
+
+// convert returns v converted to type t. A value that fits t on the path
+// is unchanged; one that may not is replaced by any value of t
+func (e *engine) convert(st *state, v value, t *ast.Type) value {
+	lo, hi, ok := typeRange(t)
+	if !ok {
+		return value{typ: t}
+	}
+	if v.lin == nil {
+		return e.fresh("", t, true)
+	}
+	if !e.within(v.lin, lo, hi) {
+		below, _ := e.solve(append(st.path[:len(st.path):len(st.path)], compare(v.lin, "<", constant(lo))))
+		above, _ := e.solve(append(st.path[:len(st.path):len(st.path)], compare(v.lin, ">", constant(hi))))
+		if below != unsat || above != unsat {
+			return e.fresh("", t, true)
+		}
+	}
+	return value{lin: v.lin, typ: t}
+}
+
+// call evaluates the arguments of a call and then the call. Functions the
+// program defines are executed up to the depth bound; other calls return
+// any value of their result type
+func (e *engine) call(f *frame, st *state, call *ast.CallExpr) []result {
+	type partial struct {
+		st   *state
+		args []value
+	}
+	partials := []partial{{st: st}}
+	id, direct := call.Callee.(*ast.Identifier)
+	if direct && f.types[id.Name] != nil {
+		direct = false
+	}
+	var resultType *ast.Type
+	if !direct {
+		var next []partial
+		for _, r := range e.eval(f, st, call.Callee) {
+			if t := r.v.typ; t != nil && t.IsFuncPointer() {
+				resultType = t.Elem.Elem
+			}
+			next = append(next, partial{st: r.st})
+		}
+		partials = next
+	}
+	for _, arg := range call.Args {
+		var next []partial
+		for _, p := range partials {
+			for _, r := range e.eval(f, p.st, arg) {
+				next = append(next, partial{r.st, append(p.args[:len(p.args):len(p.args)], r.v)})
+			}
+		}
+		partials = next
+	}
+
+	var callee *ast.Function
+	if direct {
+		resultType = ast.Int // undeclared functions return int
+		if libc := codegen.LookupLibc(id.Name); libc != nil {
+			resultType = libc.ResultType()
+		}
+		for _, fn := range e.program.Functions {
+			if fn.Name == id.Name {
+				resultType = fn.ReturnType
+				if fn.Body != nil {
+					callee = fn
+				}
+			}
+		}
+	}
+	var rs []result
+	for _, p := range partials {
+		if callee == nil || f.depth >= e.options.MaxDepth {
+			rs = append(rs, result{p.st, e.fresh("", resultType, true)})
+			continue
+		}
+		rs = append(rs, e.inline(f, p.st, callee, p.args)...)
+	}
+	return rs
+}
+
+// inline executes a call to callee on the path st and returns to the
+// caller with each result it can return
+func (e *engine) inline(f *frame, st *state, callee *ast.Function, args []value) []result {
+	inner := &frame{fn: callee, types: map[string]*ast.Type{}, depth: f.depth + 1}
+	caller := st.env
+	entry := st.fork()
+	entry.env = map[string]value{}
+	for i, param := range callee.Params {
+		inner.types[param.Name] = param.Type
+		if i < len(args) {
+			entry.env[param.Name] = e.convert(entry, args[i], param.Type)
+		} else {
+			entry.env[param.Name] = e.fresh("", param.Type, true)
+		}
+	}
+	var rs []result
+	for _, p := range e.statements(inner, entry, callee.Body.Statements) {
+		v := p.result
+		if p.flow != returned {
+			// Falling off the end returns nothing usable
+			v = e.fresh("", callee.ReturnType, true)
+		}
+		back := &state{env: make(map[string]value, len(caller)), path: p.st.path, approx: p.st.approx, model: p.st.model}
+		for name, cv := range caller {
+			back.env[name] = cv
+		}
+		rs = append(rs, result{back, v})
+	}
+	return rs
+}
+
+// ask answers the queries on expr for the path st, on which it holds v
+func (e *engine) ask(st *state, expr ast.Expression, v value) {
+	for _, i := range e.queries[expr] {
+		p := &e.pending[i]
+		if p.answer.Verdict == Feasible {
+			continue
+		}
+		if v.lin == nil {
+			p.unknown = true
+			continue
+		}
+		for _, c := range p.query.Conditions {
+			cs := append(st.path[:len(st.path):len(st.path)], compare(v.lin, c.Op, constant(big.NewInt(c.Value))))
+			o, model := e.solve(cs)
+			if o == sat && !st.approx && !e.approximate(cs) {
+				p.answer = Answer{Verdict: Feasible, Witness: e.witness(model)}
+				break
+			}
+			if o != unsat {
+				p.unknown = true
+			}
+		}
+	}
+}
+
+// approximate reports whether any of cs depends on a stand-in symbol
+func (e *engine) approximate(cs []constraint) bool {
+	for _, c := range cs {
+		for id := range c.lin.terms {
+			if e.symbols[id].approx {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// witness returns the named inputs in model with their values
+func (e *engine) witness(model map[int]*big.Int) Witness {
+	w := Witness{}
+	for id, s := range e.symbols {
+		if _, ok := model[id]; ok && s.name != "" {
+			w = append(w, Binding{s.name, model[id]})
+		}
+	}
+	return w
+}
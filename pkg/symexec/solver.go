@@ -0,0 +1,395 @@
+package symexec
+
+import "math/big"
+
+// linear is k plus the sum of each symbol times its coefficient
+type linear struct {
+	k     *big.Int
+	terms map[int]*big.Int // symbol to nonzero coefficient
+}
+
+func constant(v *big.Int) *linear {
+	return &linear{k: v, terms: map[int]*big.Int{}}
+}
+
+func variable(id int) *linear {
+	return &linear{k: new(big.Int), terms: map[int]*big.Int{id: big.NewInt(1)}}
+}
+
+func (a *linear) isConstant() bool {
+	return len(a.terms) == 0
+}
+
+func (a *linear) plus(b *linear) *linear {
+	sum := &linear{k: new(big.Int).Add(a.k, b.k), terms: map[int]*big.Int{}}
+	for id, c := range a.terms {
+		sum.terms[id] = c
+	}
+	for id, c := range b.terms {
+		if prev, ok := sum.terms[id]; ok {
+			c = new(big.Int).Add(prev, c)
+		}
+		if c.Sign() == 0 {
+			delete(sum.terms, id)
+		} else {
+			sum.terms[id] = c
+		}
+	}
+	return sum
+}
+
+func (a *linear) times(c *big.Int) *linear {
+	if c.Sign() == 0 {
+		return constant(new(big.Int))
+	}
+	product := &linear{k: new(big.Int).Mul(a.k, c), terms: map[int]*big.Int{}}
+	for id, coef := range a.terms {
+		product.terms[id] = new(big.Int).Mul(coef, c)
+	}
+	return product
+}
+
+func (a *linear) minus(b *linear) *linear {
+	return a.plus(b.times(big.NewInt(-1)))
+}
+
+// constraint says that lin compares with zero as op says: "<=", "==" or
+// "!="
+type constraint struct {
+	lin *linear
+	op  string
+}
+
+// compare returns the constraint that l op r holds, for the operators of
+// the language
+func compare(l *linear, op string, r *linear) constraint {
+	one := constant(big.NewInt(1))
+	switch op {
+	case "<":
+		return constraint{l.minus(r).plus(one), "<="}
+	case ">":
+		return constraint{r.minus(l).plus(one), "<="}
+	}
+	return constraint{l.minus(r), "=="}
+}
+
+func (c constraint) negate() constraint {
+	switch c.op {
+	case "==":
+		return constraint{c.lin, "!="}
+	case "!=":
+		return constraint{c.lin, "=="}
+	}
+	// not lin <= 0 is -lin + 1 <= 0
+	return constraint{c.lin.times(big.NewInt(-1)).plus(constant(big.NewInt(1))), "<="}
+}
+
+// outcome is what the solver found out about a set of constraints
+type outcome int
+
+const (
+	unknown outcome = iota
+	sat
+	unsat
+)
+
+// solveWork bounds the work of the solver on one set of constraints.
+// Work is counted in constraints visited, by propagation or by the check
+// for contradictory differences, which is what the time it takes grows
+// with
+const solveWork = 20000
+
+// domain is the values a symbol can still take
+type domain struct{ lo, hi *big.Int }
+
+// solve decides whether the constraints have a solution, and returns one
+// if they do. Bounds are propagated through the constraints until they
+// settle; if taking the value nearest zero for every symbol then fails
+// some constraint, the domain of a symbol is split in half, the half
+// nearer zero searched first, which keeps witnesses small. The work
+// counts against what is left to the function, and once that is spent
+// every set of constraints is unknown
+func (e *engine) solve(cs []constraint) (outcome, map[int]*big.Int) {
+	if e.work <= 0 || e.options.Context != nil && e.options.Context.Err() != nil {
+		return unknown, nil
+	}
+	domains := map[int]domain{}
+	for _, c := range cs {
+		for id := range c.lin.terms {
+			domains[id] = domain{e.symbols[id].lo, e.symbols[id].hi}
+		}
+	}
+	budget := solveWork
+	if e.work < budget {
+		budget = e.work
+	}
+	left := budget
+	o, model := search(domains, cs, &left)
+	e.work -= budget - left
+	return o, model
+}
+
+// search solves cs within domains, spending at most budget work on it
+func search(domains map[int]domain, cs []constraint, budget *int) (outcome, map[int]*big.Int) {
+	if !propagate(domains, cs, budget) || cyclic(domains, cs, budget) {
+		return unsat, nil
+	}
+	model := map[int]*big.Int{}
+	for id, d := range domains {
+		switch {
+		case d.lo.Sign() > 0:
+			model[id] = d.lo
+		case d.hi.Sign() < 0:
+			model[id] = d.hi
+		default:
+			model[id] = new(big.Int)
+		}
+	}
+	if holdsAll(cs, model) {
+		return sat, model
+	}
+	split, width := -1, (*big.Int)(nil)
+	for id, d := range domains {
+		if w := new(big.Int).Sub(d.hi, d.lo); w.Sign() > 0 && (width == nil || w.Cmp(width) < 0 || w.Cmp(width) == 0 && id < split) {
+			split, width = id, w
+		}
+	}
+	switch {
+	case *budget <= 0:
+		// Propagation may have stopped short
+		return unknown, nil
+	case split < 0:
+		// Every domain is a single value, which model has taken
+		return unsat, nil
+	}
+	d := domains[split]
+	// Rsh rounds down, so mid is below zero only when the whole lower
+	// half is
+	mid := new(big.Int).Rsh(new(big.Int).Add(d.lo, d.hi), 1)
+	parts := []domain{{d.lo, mid}, {new(big.Int).Add(mid, big.NewInt(1)), d.hi}}
+	if mid.Sign() < 0 {
+		parts[0], parts[1] = parts[1], parts[0]
+	}
+	result := unsat
+	for _, part := range parts {
+		narrowed := make(map[int]domain, len(domains))
+		for id, d := range domains {
+			narrowed[id] = d
+		}
+		narrowed[split] = part
+		switch o, model := search(narrowed, cs, budget); o {
+		case sat:
+			return sat, model
+		case unknown:
+			result = unknown
+		}
+	}
+	return result, nil
+}
+
+// propagateRounds bounds how often propagation revisits the constraints;
+// bounds that keep shrinking by small steps are left to the search
+const propagateRounds = 32
+
+// propagate narrows the domains to the values each constraint allows
+// given the domains of the other symbols in it. It reports false if some
+// domain becomes empty. It stops once budget is spent, leaving domains
+// that are wider than they could be but still hold every solution
+func propagate(domains map[int]domain, cs []constraint, budget *int) bool {
+	for round := 0; round < propagateRounds && *budget > 0; round++ {
+		*budget -= len(cs)
+		changed := false
+		for _, c := range cs {
+			if len(c.lin.terms) == 0 {
+				if !holds(c, nil) {
+					return false
+				}
+				continue
+			}
+			for id, a := range c.lin.terms {
+				// a*x + rest op 0, with rest ranging over [rlo, rhi]
+				rlo, rhi := new(big.Int).Set(c.lin.k), new(big.Int).Set(c.lin.k)
+				for other, b := range c.lin.terms {
+					if other == id {
+						continue
+					}
+					d := domains[other]
+					x, y := new(big.Int).Mul(b, d.lo), new(big.Int).Mul(b, d.hi)
+					if b.Sign() < 0 {
+						x, y = y, x
+					}
+					rlo.Add(rlo, x)
+					rhi.Add(rhi, y)
+				}
+				d := domains[id]
+				lo, hi := d.lo, d.hi
+				switch c.op {
+				case "<=": // a*x <= -rlo
+					if a.Sign() > 0 {
+						hi = minInt(hi, floorDiv(new(big.Int).Neg(rlo), a))
+					} else {
+						lo = maxInt(lo, ceilDiv(new(big.Int).Neg(rlo), a))
+					}
+				case "==": // -rhi <= a*x <= -rlo
+					if a.Sign() > 0 {
+						lo = maxInt(lo, ceilDiv(new(big.Int).Neg(rhi), a))
+						hi = minInt(hi, floorDiv(new(big.Int).Neg(rlo), a))
+					} else {
+						lo = maxInt(lo, ceilDiv(new(big.Int).Neg(rlo), a))
+						hi = minInt(hi, floorDiv(new(big.Int).Neg(rhi), a))
+					}
+				case "!=": // a*x != -rest, which only helps once rest is known
+					if rlo.Cmp(rhi) != 0 {
+						continue
+					}
+					v, m := new(big.Int).QuoRem(new(big.Int).Neg(rlo), a, new(big.Int))
+					if m.Sign() != 0 {
+						continue
+					}
+					if lo.Cmp(v) == 0 {
+						lo = new(big.Int).Add(lo, big.NewInt(1))
+					} else if hi.Cmp(v) == 0 {
+						hi = new(big.Int).Sub(hi, big.NewInt(1))
+					}
+				}
+				if lo.Cmp(hi) > 0 {
+					return false
+				}
+				if lo.Cmp(d.lo) != 0 || hi.Cmp(d.hi) != 0 {
+					domains[id] = domain{lo, hi}
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return true
+}
+
+// cyclic reports whether the constraints of the form x - y + k <= 0, or
+// x - y + k == 0, contradict each other and the domains, which
+// propagation only finds out a step at a time: each of a > b, b > c and
+// c > a moves a bound by one. They are the edges of a graph whose
+// shortest paths bound the differences, with the domains edges to and
+// from a node for zero, and they contradict each other if it has a cycle
+// of negative length. It reports false, as if they did not, once budget
+// is spent
+func cyclic(domains map[int]domain, cs []constraint, budget *int) bool {
+	type edge struct {
+		from, to int
+		weight   *big.Int
+	}
+	const zero = -1
+	var edges []edge
+	for id, d := range domains {
+		edges = append(edges, edge{zero, id, d.hi}, edge{id, zero, new(big.Int).Neg(d.lo)})
+	}
+	for _, c := range cs {
+		if len(c.lin.terms) != 2 || c.op == "!=" {
+			continue
+		}
+		x, y := -1, -1
+		for id, a := range c.lin.terms {
+			switch {
+			case a.IsInt64() && a.Int64() == 1:
+				x = id
+			case a.IsInt64() && a.Int64() == -1:
+				y = id
+			}
+		}
+		if x < 0 || y < 0 {
+			continue
+		}
+		// x - y <= -k
+		edges = append(edges, edge{y, x, new(big.Int).Neg(c.lin.k)})
+		if c.op == "==" {
+			edges = append(edges, edge{x, y, c.lin.k})
+		}
+	}
+	if len(edges) == 2*len(domains) {
+		return false
+	}
+	dist := map[int]*big.Int{zero: new(big.Int)}
+	for id := range domains {
+		dist[id] = new(big.Int)
+	}
+	for round := 0; round <= len(domains); round++ {
+		if *budget <= 0 {
+			return false
+		}
+		*budget -= len(edges)
+		changed := false
+		for _, e := range edges {
+			if d := new(big.Int).Add(dist[e.from], e.weight); d.Cmp(dist[e.to]) < 0 {
+				dist[e.to] = d
+				changed = true
+			}
+		}
+		if !changed {
+			return false
+		}
+	}
+	return true
+}
+
+// holdsAll reports whether every constraint holds in model
+func holdsAll(cs []constraint, model map[int]*big.Int) bool {
+	for _, c := range cs {
+		if !holds(c, model) {
+			return false
+		}
+	}
+	return true
+}
+
+// holds reports whether c holds when its symbols take the values in
+// model. A symbol model has no value for fails it
+func holds(c constraint, model map[int]*big.Int) bool {
+	v := new(big.Int).Set(c.lin.k)
+	for id, a := range c.lin.terms {
+		x, ok := model[id]
+		if !ok {
+			return false
+		}
+		v.Add(v, new(big.Int).Mul(a, x))
+	}
+	switch c.op {
+	case "<=":
+		return v.Sign() <= 0
+	case "==":
+		return v.Sign() == 0
+	}
+	return v.Sign() != 0
+}
+
+func floorDiv(n, d *big.Int) *big.Int {
+	q, m := new(big.Int).QuoRem(n, d, new(big.Int))
+	if m.Sign() != 0 && (m.Sign() < 0) != (d.Sign() < 0) {
+		q.Sub(q, big.NewInt(1))
+	}
+	return q
+}
+
+func ceilDiv(n, d *big.Int) *big.Int {
+	q, m := new(big.Int).QuoRem(n, d, new(big.Int))
+	if m.Sign() != 0 && (m.Sign() < 0) == (d.Sign() < 0) {
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
+
+func minInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) > 0 {
+		return a
+	}
+	return b
+}
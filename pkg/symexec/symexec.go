@@ -0,0 +1,155 @@
+// Package symexec executes functions symbolically to settle questions the
+// analysis answers only approximately. Parameters, and whatever else the
+// engine does not model, are symbols; integer and pointer values are
+// linear combinations of them; each path carries the conditions of the
+// branches it took. A small solver for linear integer constraints prunes
+// infeasible paths and finds the inputs that reach a point.
+//
+// A loop is not unrolled: the variables it assigns hold any value at its
+// head, so the paths through it can be ruled out but not confirmed. Calls
+// to functions the program defines are executed in place up to a depth,
+// and the number of paths explored and the work of the solver are
+// bounded; each bound leaves the answers it cuts short unknown rather
+// than wrong. Signed overflow is undefined, so arithmetic is assumed to
+// stay within its type.
+package symexec
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+)
+
+// Options bound the exploration of a function
+type Options struct {
+	// MaxPaths is how many paths are explored; further branches are not
+	// taken
+	MaxPaths int
+	// MaxDepth is how deep calls to defined functions are executed; deeper
+	// calls return any value of their type
+	MaxDepth int
+	// MaxWork bounds the work of the solver over the whole function,
+	// counted in constraints visited, or is 0 for the default. Once it is
+	// spent, branches are taken both ways and queries left unknown
+	MaxWork int
+	// Context, if set, stops the exploration from taking more paths once
+	// it is done, as if MaxPaths were reached
+	Context context.Context `json:"-"`
+}
+
+// DefaultOptions are the bounds the analysis uses by default
+var DefaultOptions = Options{MaxPaths: 256, MaxDepth: 3, MaxWork: 1 << 18}
+
+// Condition is a comparison of the value of an expression with a
+// constant, e.g. {"<", 0}. The operators are "<", ">" and "=="
+type Condition struct {
+	Op    string
+	Value int64
+}
+
+func (c Condition) String() string {
+	return fmt.Sprintf("%s %d", c.Op, c.Value)
+}
+
+// Query asks whether the integer or pointer expression Expr, in the
+// function being executed, can hold a value meeting any of Conditions
+type Query struct {
+	Expr       ast.Expression
+	Conditions []Condition
+}
+
+// Verdict is the answer to a query
+type Verdict int
+
+const (
+	// Unknown means the bounds or the approximations of the engine left
+	// the query open
+	Unknown Verdict = iota
+	// Feasible means a path reaches the expression with such a value
+	Feasible
+	// Infeasible means no path does
+	Infeasible
+)
+
+func (v Verdict) String() string {
+	return [...]string{"unknown", "feasible", "infeasible"}[v]
+}
+
+// Answer is the verdict on a query, with the inputs that lead to the
+// value when it is feasible
+type Answer struct {
+	Verdict Verdict
+	Witness Witness
+}
+
+// Binding is the value an input takes
+type Binding struct {
+	Name  string // e.g. "i" for a parameter
+	Value *big.Int
+}
+
+// Witness lists the inputs a feasible path depends on, in the order the
+// path meets them. Inputs the path does not constrain are left out
+type Witness []Binding
+
+func (w Witness) String() string {
+	parts := make([]string, len(w))
+	for i, b := range w {
+		parts[i] = fmt.Sprintf("%s = %s", b.Name, b.Value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Check executes fn, a function program defines, and answers each query
+func Check(program *ast.Program, fn *ast.Function, queries []Query, options Options) []Answer {
+	if options.MaxPaths < 1 {
+		options.MaxPaths = 1
+	}
+	if options.MaxWork < 1 {
+		options.MaxWork = DefaultOptions.MaxWork
+	}
+	e := &engine{
+		program: program,
+		options: options,
+		paths:   1,
+		work:    options.MaxWork,
+		queries: map[ast.Expression][]int{},
+		pending: make([]pending, len(queries)),
+	}
+	for i, q := range queries {
+		e.queries[q.Expr] = append(e.queries[q.Expr], i)
+		e.pending[i].query = q
+	}
+	f := &frame{fn: fn, types: map[string]*ast.Type{}}
+	st := &state{env: map[string]value{}}
+	for _, param := range fn.Params {
+		f.types[param.Name] = param.Type
+		name := param.Name
+		if param.Type.Kind != ast.BasicType {
+			name = "" // addresses make poor witnesses
+		}
+		st.env[param.Name] = e.fresh(name, param.Type, false)
+	}
+	e.statements(f, st, fn.Body.Statements)
+
+	answers := make([]Answer, len(queries))
+	for i, p := range e.pending {
+		switch {
+		case p.answer.Verdict == Feasible:
+			answers[i] = p.answer
+		case !p.unknown && !e.incomplete:
+			answers[i].Verdict = Infeasible
+		}
+	}
+	return answers
+}
+
+// pending is a query being answered
+type pending struct {
+	query   Query
+	answer  Answer
+	unknown bool // some path could not be settled
+}
@@ -0,0 +1,84 @@
+package symexec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anouar-bakouch/citadel/pkg/ast"
+	"github.com/anouar-bakouch/citadel/pkg/lexer"
+	"github.com/anouar-bakouch/citadel/pkg/parser"
+)
+
+// TestCheck checks the verdicts on the value f returns last, and the
+// witness of those that are feasible
+func TestCheck(t *testing.T) {
+	const callee = "int g(int n) { if (n > 5) { return 5; } return n; } "
+	for _, test := range []struct {
+		src       string
+		condition Condition
+		options   Options
+		want      Verdict
+		witness   string
+	}{
+		{"int f(int i) { if (i < 0) { return 0; } return i; }", Condition{"<", 0}, DefaultOptions, Infeasible, ""},
+		{"int f(int i) { if (i < 0) { return 0; } return i; }", Condition{"==", 7}, DefaultOptions, Feasible, "i = 7"},
+		{"int f(int i) { return i + 1; }", Condition{"==", 0}, DefaultOptions, Feasible, "i = -1"},
+		{"int f(int i, int j) { if (i > j) { return j - i; } return 1; }", Condition{">", 0}, DefaultOptions, Feasible, ""},
+		{"int f(int i, int j) { if (i > j) { return j - i; } return 1; }", Condition{"==", 0}, DefaultOptions, Infeasible, ""},
+		{"int f(int i, int j, int k) { int r = 0; if (i > j) { if (j > k) { if (k > i) { r = 1; } } } return r; }", Condition{"==", 1}, DefaultOptions, Infeasible, ""},
+		{"int f(int i, int j) { int r = 0; if (i > j) { if (j > 1000) { r = i; } } return r; }", Condition{">", 5}, DefaultOptions, Feasible, "i = 1002, j = 1001"},
+		{callee + "int f(int i) { return g(i); }", Condition{">", 5}, DefaultOptions, Infeasible, ""},
+		{callee + "int f(int i) { return g(i); }", Condition{"==", 3}, DefaultOptions, Feasible, "i = 3"},
+		{callee + "int f(int i) { return g(i); }", Condition{">", 5}, Options{MaxPaths: 256}, Unknown, ""},
+		{"int f() { int n = 0; while (n < 10) { n = n + 1; } return n; }", Condition{"<", 10}, DefaultOptions, Infeasible, ""},
+		{"int f() { int n = 0; while (n < 10) { n = n + 1; } return n; }", Condition{"==", 10}, DefaultOptions, Unknown, ""},
+		{"int f(int i) { while (i > 0) { if (i == 5) { return 1; } i = i - 1; } return i; }", Condition{">", 0}, DefaultOptions, Infeasible, ""},
+	} {
+		program, err := parser.New(lexer.New(test.src)).ParseProgram()
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		fn := program.Functions[len(program.Functions)-1]
+		body := fn.Body.Statements
+		ret := body[len(body)-1].(*ast.ReturnStatement)
+		query := Query{Expr: ret.Value, Conditions: []Condition{test.condition}}
+		answer := Check(program, fn, []Query{query}, test.options)[0]
+		if answer.Verdict != test.want {
+			t.Errorf("%s, %v: %v, want %v", test.src, test.condition, answer.Verdict, test.want)
+		} else if test.witness != "" && answer.Witness.String() != test.witness {
+			t.Errorf("%s, %v: witness %s, want %s", test.src, test.condition, answer.Witness, test.witness)
+		}
+	}
+}
+
+// TestCheckManyBranches checks that the bounds keep a function with many
+// sequential branches, whose conditions contradict each other only in
+// combination, quick to execute, and that what they cut short is unknown
+// rather than infeasible
+func TestCheckManyBranches(t *testing.T) {
+	var src strings.Builder
+	src.WriteString("int f(int a, int b, int c) { int x = 0; ")
+	for i := 0; i < 120; i++ {
+		cond := []string{"a > b", "b > c", "c > a", "a + b > c"}[i%4]
+		fmt.Fprintf(&src, "if (%s) { x = x + %d; } ", cond, i)
+	}
+	src.WriteString("return x; }")
+	program, err := parser.New(lexer.New(src.String())).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := program.Functions[0]
+	body := fn.Body.Statements
+	ret := body[len(body)-1].(*ast.ReturnStatement)
+	query := Query{Expr: ret.Value, Conditions: []Condition{{"==", 0}}}
+	start := time.Now()
+	answer := Check(program, fn, []Query{query}, DefaultOptions)[0]
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("took %v", elapsed)
+	}
+	if answer.Verdict == Infeasible {
+		t.Errorf("x == 0: %v, but a = b = c = 0 returns 0", answer.Verdict)
+	}
+}
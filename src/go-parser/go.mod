@@ -1,3 +0,0 @@
-module llvm-security-parser
-
-go 1.13